@@ -5,6 +5,7 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"flag"
 	"fmt"
@@ -20,6 +21,8 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/ethereum/go-ethereum/rpc"
@@ -28,6 +31,7 @@ import (
 	"github.com/livepeer/go-livepeer/server"
 
 	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/golang/glog"
 	"github.com/livepeer/go-livepeer/common"
@@ -37,6 +41,7 @@ import (
 	"github.com/livepeer/go-livepeer/eth"
 	"github.com/livepeer/go-livepeer/eth/blockwatch"
 	"github.com/livepeer/go-livepeer/eth/watchers"
+	"github.com/livepeer/go-livepeer/secrets"
 	"github.com/livepeer/go-livepeer/verification"
 
 	lpmon "github.com/livepeer/go-livepeer/monitor"
@@ -104,8 +109,30 @@ func main() {
 
 	// Network & Addresses:
 	network := flag.String("network", "offchain", "Network to connect to")
+	httpProxy := flag.String("httpProxy", "", "Proxy all outbound HTTP traffic goes through, such as webhooks, object storage, segments sent to orchestrators and eth RPC, as http://, https:// or socks5:// URL with optional user:password@ credentials; defaults to the HTTP_PROXY and HTTPS_PROXY environment variables")
+	noProxy := flag.String("noProxy", "", "Comma separated destinations reached without -httpProxy: host names, .domain names for their subdomains, IP addresses and CIDR ranges, each with an optional :port, or *; defaults to the NO_PROXY environment variable")
 	rtmpAddr := flag.String("rtmpAddr", "127.0.0.1:"+RtmpPort, "Address to bind for RTMP commands")
+	ingestNode := flag.String("ingestNode", "", "Broadcaster only. Base URL this node is reachable at directly, among -ingestNodes and by the other nodes of -clusterStore")
+	ingestNodes := flag.String("ingestNodes", "", "Broadcaster only. Comma separated base URLs of the broadcasters sharing an ingest hostname. Each stream is pinned to one of them, or to the ingestNode of the auth webhook; HTTP pushes to other nodes are redirected and RTMP streams rejected")
+	clusterStore := flag.String("clusterStore", "", "Broadcaster only. Store shared by the broadcasters of a cluster, as etcd://host:port or etcd+https://host:port, for any of them to redirect requests for a stream to the node ingesting it and to take streams over from nodes that are gone. Requires -ingestNode")
+	clusterTTL := flag.Duration("clusterTTL", 15*time.Second, "Broadcaster only. How long streams of a -clusterStore node stay live after it stops refreshing them")
+	rtmpReconnectGrace := flag.Duration("rtmpReconnectGrace", 0, "Broadcaster only. Keep an RTMP stream for this long after its publisher disconnects, so that a reconnect continues the stream and its playlists after a discontinuity; 0 to end the stream right away")
+	keyframeSegmentation := flag.Bool("keyframeSegmentation", false, "Broadcaster only. Cut RTMP streams into segments at keyframes of the source, within -segmentMinDuration and -segmentMaxDuration, rather than every 2 seconds")
+	segmentMinDuration := flag.Duration("segmentMinDuration", server.SegLen, "Broadcaster only. Minimum duration of segments cut with -keyframeSegmentation; shorter segments are joined with the next")
+	segmentMaxDuration := flag.Duration("segmentMaxDuration", 8*time.Second, "Broadcaster only. Duration segments cut with -keyframeSegmentation are not joined beyond; longer segments are logged. 0 for no maximum")
+	streamSegmentDurationMin := flag.Duration("streamSegmentDurationMin", server.SegmentDurationMin, "Broadcaster only. Shortest segment duration streams may ask for with the segmentDuration query parameter of their ingest URL or the segmentDurationMs of the auth webhook response")
+	streamSegmentDurationMax := flag.Duration("streamSegmentDurationMax", server.SegmentDurationMax, "Broadcaster only. Longest segment duration streams may ask for with the segmentDuration query parameter of their ingest URL or the segmentDurationMs of the auth webhook response")
+	sanitizeTimestamps := flag.Bool("sanitizeTimestamps", false, "Broadcaster only. Repair the timestamps of RTMP source segments (non-monotonic or missing timestamps, AAC priming) before they are saved or transcoded")
+	avSyncDriftLimit := flag.Duration("avSyncDriftLimit", server.AVSyncDriftLimit, "Broadcaster only. How far audio of an RTMP source may drift from its video before the stream is reported unhealthy or, with -avSyncCorrection, the drift is corrected")
+	avSyncCorrection := flag.Bool("avSyncCorrection", false, "Broadcaster only. Move audio that drifts beyond -avSyncDriftLimit back in sync with video. Requires -sanitizeTimestamps")
+	maxSourceResolution := flag.String("maxSourceResolution", "", "Broadcaster only. Largest resolution of stream sources, as WxH, that caps portrait sources as well. Sources beyond it get -sourceLimitAction")
+	maxSourceFPS := flag.Uint("maxSourceFps", 0, "Broadcaster only. Highest frame rate of stream sources, as measured from their segments; 0 for no cap. Sources beyond it get -sourceLimitAction")
+	sourceLimitAction := flag.String("sourceLimitAction", server.SourceLimitReject, "Broadcaster only. What to do with sources beyond -maxSourceResolution or -maxSourceFps: reject, or downscale them on the broadcaster before they are stored and transcoded")
+	formatFallbackAttempts := flag.Int("formatFallbackAttempts", server.FormatFallbackAttempts, "Broadcaster only. Attempts in a row at transcoding segments of a stream that may fail with muxing errors before a rendition of the stream falls back to MPEG-TS; 0 to never fall back")
+	degradeCapabilities := flag.Bool("degradeCapabilities", false, "Broadcaster only. Drop the renditions of a stream that need capabilities an orchestrator rejected its segments for lacking, such as a GOP or an encoder profile, rather than keep failing over to other orchestrators")
+	restreamFFmpeg := flag.String("restreamFFmpeg", "ffmpeg", "Broadcaster only. ffmpeg binary used to push streams to the restream targets set by the auth webhook or the /setRestreams endpoint")
 	cliAddr := flag.String("cliAddr", "127.0.0.1:"+CliPort, "Address to bind for  CLI commands")
+	cliUsers := flag.String("cliUsers", "", "JSON file listing the users of the CLI webserver as {\"name\", \"role\", \"token\"} objects, with role read-only, operator or admin. If set, CLI requests need an \"Authorization: Bearer <token>\" header of a user allowed to use the endpoint. Operators may also end streams with DELETE /live/<manifestID> on the HTTP server, which is refused without -cliUsers")
 	httpAddr := flag.String("httpAddr", "", "Address to bind for HTTP commands")
 	serviceAddr := flag.String("serviceAddr", "", "Orchestrator only. Overrides the on-chain serviceURI that broadcasters can use to contact this node; may be an IP or hostname.")
 	orchAddr := flag.String("orchAddr", "", "Orchestrator to connect to as a standalone transcoder")
@@ -114,23 +141,50 @@ func main() {
 	verifierPath := flag.String("verifierPath", "", "Path to verifier shared volume")
 	localVerify := flag.Bool("localVerify", true, "Set to true to enable local verification i.e. pixel count and signature verification.")
 	httpIngest := flag.Bool("httpIngest", true, "Set to true to enable HTTP ingest")
+	httpCert := flag.String("httpCert", "", "Broadcaster only. PEM file of the certificate to serve -httpAddr over HTTPS with")
+	httpKey := flag.String("httpKey", "", "Broadcaster only. PEM file of the key of -httpCert")
+	tlsMinVersion := flag.String("tlsMinVersion", "", "Minimum TLS version (1.0, 1.1, 1.2 or 1.3) accepted by the orchestrator service endpoint and the broadcaster HTTPS server; Go default if empty")
+	tlsCipherSuites := flag.String("tlsCipherSuites", "", "Comma separated TLS 1.2 cipher suites, such as TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, accepted by the orchestrator service endpoint and the broadcaster HTTPS server; Go defaults if empty")
+	hstsMaxAge := flag.Duration("hstsMaxAge", 0, "Send a Strict-Transport-Security header with this max-age from the orchestrator service endpoint and the broadcaster HTTPS server; 0 to not send it")
+	hstsIncludeSubdomains := flag.Bool("hstsIncludeSubdomains", false, "Add includeSubDomains to the Strict-Transport-Security header of -hstsMaxAge")
 
 	// Transcoding:
 	orchestrator := flag.Bool("orchestrator", false, "Set to true to be an orchestrator")
 	transcoder := flag.Bool("transcoder", false, "Set to true to be a transcoder")
 	broadcaster := flag.Bool("broadcaster", false, "Set to true to be a broadcaster")
 	orchSecret := flag.String("orchSecret", "", "Shared secret with the orchestrator as a standalone transcoder")
-	transcodingOptions := flag.String("transcodingOptions", "P240p30fps16x9,P360p30fps16x9", "Transcoding options for broadcast job, or path to json config")
+	segmentClientCA := flag.String("segmentClientCA", "", "Orchestrator only. PEM file of the CAs issuing broadcaster client certificates. If set, broadcasters must present a certificate to submit segments")
+	serviceURICheckers := flag.String("serviceURICheckers", "", "Orchestrator only. Comma separated URLs of services that check the service URI is reachable from outside the network of the node. Service URIs failing the check are not registered on chain")
+	serviceURICheckInterval := flag.Duration("serviceURICheckInterval", time.Hour, "Orchestrator only. How often the service URI is checked again with -serviceURICheckers; 0 to check at startup only")
+	maintenanceWindows := flag.String("maintenanceWindows", "", "Orchestrator only. Comma separated scheduled maintenance windows to advertise to broadcasters, each as <RFC 3339 start>/<RFC 3339 end or duration>, e.g. 2021-03-01T02:00:00Z/2h")
+	segmentClientAllowlist := flag.String("segmentClientAllowlist", "", "Orchestrator only. Comma separated subject common names or SHA-256 fingerprints of the broadcaster certificates accepted with -segmentClientCA; any certificate issued by the CAs if empty")
+	segmentClientCert := flag.String("segmentClientCert", "", "Broadcaster only. PEM file of the client certificate presented to orchestrators")
+	segmentClientKey := flag.String("segmentClientKey", "", "Broadcaster only. PEM file of the key of -segmentClientCert")
+	transcodingOptions := flag.String("transcodingOptions", "P240p30fps16x9,P360p30fps16x9", "Transcoding options for broadcast job, or path to json config, or \"auto\" to derive them from the source")
+	profileMinDimension := flag.Int("profileMinDimension", server.DefaultProfileLimits.MinDimension, "Minimum width and height in pixels of transcoding profiles; 0 for no minimum")
+	profileMaxDimension := flag.Int("profileMaxDimension", server.DefaultProfileLimits.MaxDimension, "Maximum width and height in pixels of transcoding profiles; 0 for no maximum")
+	profileEvenDimensions := flag.Bool("profileEvenDimensions", server.DefaultProfileLimits.EvenDimensions, "Reject transcoding profiles with an odd width or height")
+	profileMinFPS := flag.Float64("profileMinFPS", server.DefaultProfileLimits.MinFPS, "Minimum frame rate of transcoding profiles; 0 for no minimum")
+	profileMaxFPS := flag.Float64("profileMaxFPS", server.DefaultProfileLimits.MaxFPS, "Maximum frame rate of transcoding profiles; 0 for no maximum")
+	profileMinBitrate := flag.Int64("profileMinBitrate", server.DefaultProfileLimits.MinBitrate, "Minimum bitrate in bits per second of transcoding profiles; 0 for no minimum")
+	profileMaxBitrate := flag.Int64("profileMaxBitrate", server.DefaultProfileLimits.MaxBitrate, "Maximum bitrate in bits per second of transcoding profiles; 0 for no maximum")
+	profileGOPWithinSegments := flag.Bool("profileGOPWithinSegments", server.DefaultProfileLimits.GOPWithinSegments, "Reject transcoding profiles with GOPs longer than the segments streams are cut into")
+	gopAlignment := flag.String("gopAlignment", string(server.GOPAlignmentOff), "Broadcaster only. How keyframes of the renditions of a stream are aligned for seamless switching: off, source to keyframe renditions only at the keyframes the source is segmented on, or forced to give all renditions the GOP set by their profiles. Unless off, the keyframes of transcoded renditions are checked to be aligned")
+	manifestIDPattern := flag.String("manifestIDPattern", "", "Broadcaster only. Regular expression that manifest IDs of streams must match in full; any if empty")
+	manifestIDMaxLength := flag.Int("manifestIDMaxLength", 0, "Broadcaster only. Maximum length of manifest IDs of streams; 0 for no maximum")
+	manifestIDReservedPrefixes := flag.String("manifestIDReservedPrefixes", "", "Broadcaster only. Comma separated prefixes that manifest IDs of streams may not start with")
 	maxAttempts := flag.Int("maxAttempts", 3, "Maximum transcode attempts")
 	maxSessions := flag.Int("maxSessions", 10, "Maximum number of concurrent transcoding sessions for Orchestrator, maximum number or RTMP streams for Broadcaster, or maximum capacity for transcoder")
 	currentManifest := flag.Bool("currentManifest", false, "Expose the currently active ManifestID as \"/stream/current.m3u8\"")
 	nvidia := flag.String("nvidia", "", "Comma-separated list of Nvidia GPU device IDs to use for transcoding")
-	testTranscoder := flag.Bool("testTranscoder", true, "Test Nvidia GPU transcoding at startup")
+	testTranscoder := flag.Bool("testTranscoder", true, "Test transcoding on the CPU or each -nvidia GPU at startup, for each capability that depends on the transcoder. Orchestrators don't advertise capabilities that fail on any device")
 
 	// Onchain:
 	ethAcctAddr := flag.String("ethAcctAddr", "", "Existing Eth account address")
 	ethPassword := flag.String("ethPassword", "", "Password for existing Eth account address")
 	ethKeystorePath := flag.String("ethKeystorePath", "", "Path for the Eth Key")
+	signerMode := flag.Bool("signer", false, "Set to true to run only a signing service for the ETH account on -signerSocket, so that the node serving media needs no access to the keystore")
+	signerSocket := flag.String("signerSocket", "", "Unix socket of the signing service. With -signer, the socket to listen on; otherwise, the node has transactions and tickets signed by the service instead of unlocking the keystore itself")
 	ethOrchAddr := flag.String("ethOrchAddr", "", "ETH address of an on-chain registered orchestrator")
 	ethUrl := flag.String("ethUrl", "", "Ethereum node JSON-RPC URL")
 	gasLimit := flag.Int("gasLimit", 0, "Gas limit for ETH transactions")
@@ -160,11 +214,34 @@ func main() {
 	monitor := flag.Bool("monitor", false, "Set to true to send performance metrics")
 	version := flag.Bool("version", false, "Print out the version")
 	verbosity := flag.String("v", "", "Log verbosity.  {4|5|6}")
+	auditLog := flag.String("auditLog", "", "Path of a file to write an audit record of every RTMP publish and every ingest, playback, API and admin request to, or 'syslog'")
+	auditLogMaxSize := flag.Int64("auditLogMaxSize", 100*1024*1024, "Size in bytes at which the audit log file is rotated")
+	auditLogMaxBackups := flag.Int("auditLogMaxBackups", 5, "Number of rotated audit log files to keep")
+	meteringSink := flag.String("meteringSink", "", "Broadcaster only. Path of a file to write a JSON usage record of every source segment to, or http(s) URL that batches of usage records are POSTed to")
+	meteringBatchSize := flag.Int("meteringBatchSize", 100, "Number of usage records delivered to -meteringSink at once")
+	meteringFlushInterval := flag.Duration("meteringFlushInterval", 10*time.Second, "Interval at which usage records are delivered to -meteringSink, if not batched sooner")
+	eventBus := flag.String("eventBus", "", "Broadcaster only. nats://[user:pass@]host:port URL of a NATS server to publish stream events and usage records to. Delivery is at-least-once for subjects captured by a JetStream stream")
+	eventBusSubjectPrefix := flag.String("eventBusSubjectPrefix", "livepeer", "Prefix of the subjects events are published on, as <prefix>.stream and <prefix>.usage")
+	auditRedact := flag.String("auditRedact", "", "Comma-separated list of audit record fields to redact: ip,key,query,ua")
 
 	// Storage:
 	datadir := flag.String("datadir", "", "Directory that data is stored in")
 	objectstore := flag.String("objectStore", "", "url of primary object store")
 	recordstore := flag.String("recordStore", "", "url of object store for recodings")
+	recordingMP4 := flag.Bool("recordingMP4", false, "Finalize recordings once their streams end, packaging each rendition into a single MP4 served under /recordings/<manifestID>/<rendition>.mp4")
+	recordingsRedirectExpiry := flag.Duration("recordingsRedirectExpiry", 0, "Redirect requests for recorded segments to signed object store URLs valid for this long instead of proxying them; 0 to disable")
+	recordFlushInterval := flag.Duration("recordFlushInterval", 0, "Interval over which saves of recording playlists are batched; 0 to save after every segment. May be overridden per stream by the auth webhook")
+	recordChecksums := flag.Bool("recordChecksums", false, "Broadcaster only. Write a manifest of the SHA-256 of every recorded segment next to recordings, against which they are verified when finalized")
+	recordAudioTrack := flag.Bool("recordAudioTrack", false, "Broadcaster only. Also record the audio of streams on its own, as the audio track of their recordings")
+	startOverWindow := flag.Duration("startOverWindow", 0, "Keep this much of the recordings of live streams in memory to serve start over playlists from, at /stream/{manifestID}/startover.m3u8; 0 to disable")
+	dvrWindow := flag.Duration("dvrWindow", 0, "Make the live media playlists of recorded streams span this much of the stream, so that players can seek back during the broadcast; 0 to serve live playlists only")
+	responseArchiveRetention := flag.Duration("responseArchiveRetention", 0, "Broadcaster only. Archive the segment credentials, payment and raw orchestrator response of every transcoded segment under responses/ of the -recordStore stream directory, marked to be kept for this long; 0 to disable")
+	jobWorkers := flag.Int("jobWorkers", 2, "Number of queued jobs, e.g. VOD transcodes and recording finalizations, run at once")
+	jobMaxAttempts := flag.Int("jobMaxAttempts", 3, "Number of times a queued job is run before it fails")
+	segmentJournalRetention := flag.Duration("segmentJournalRetention", 0, "Broadcaster only. Journal the outcome, orchestrator, pixels and cost of every source segment in the node DB, kept for this long; 0 to disable")
+	drainTimeout := flag.Duration("drainTimeout", 0, "Broadcaster only. On SIGTERM, stop accepting new streams and exit once live streams ended, waiting at most this long; 0 to exit right away. Drains started by /setBroadcasterDrain also time out after this long if set")
+	streamStateMaxAge := flag.Duration("streamStateMaxAge", 0, "Broadcaster only. Save the state of streams in the node DB, so that streams pushed again within this long of their last segment after a restart resume into the same playlists and object store paths, with the same renditions unless the auth webhook gives them a new manifest ID or renditions; 0 to disable")
+	sessionSnapshotMaxAge := flag.Duration("sessionSnapshotMaxAge", 0, "Broadcaster only. Snapshot the orchestrator sessions of streams in the node DB, so that streams pushed again within this long of a restart resume paying the same orchestrators; 0 to disable")
 
 	// All deprecated
 	s3bucket := flag.String("s3bucket", "", "S3 region/bucket (e.g. eu-central-1/testbucket)")
@@ -174,7 +251,53 @@ func main() {
 
 	// API
 	authWebhookURL := flag.String("authWebhookUrl", "", "RTMP authentication webhook URL")
+	authWebhookSecret := flag.String("authWebhookSecret", "", "Secret, path to a file containing it, or secret reference that requests to -authWebhookUrl are signed with in the X-Livepeer-Signature header")
+	authWebhookRetries := flag.Int("authWebhookRetries", server.AuthWebhookRetries, "Number of times -authWebhookUrl is asked again about a new stream after a network error, a 5xx or a 429 response")
+	authWebhookRetryBackoff := flag.Duration("authWebhookRetryBackoff", server.AuthWebhookRetryBackoff, "Wait before the first retry of -authWebhookUrl, doubled after each retry")
+	authWebhookCacheTTL := flag.Duration("authWebhookCacheTTL", 0, "Reuse the response of -authWebhookUrl for new streams with the same URL for this long; 0 to ask the webhook for every stream")
+	authWebhookFailOpen := flag.Bool("authWebhookFailOpen", false, "Accept new streams with the default settings when -authWebhookUrl still fails after -authWebhookRetries, rather than reject them")
+	authWebhookVerifyResponse := flag.Bool("authWebhookVerifyResponse", false, "Reject responses of -authWebhookUrl that aren't signed with -authWebhookSecret in the X-Livepeer-Signature header")
+	streamRegistry := flag.String("streamRegistry", "", "Broadcaster only. JSON file of pre-provisioned streams, as objects returned by the auth webhook with a manifestID and a streamKey. Pushes must carry the key of their stream, as in /live/<manifestID>/<streamKey> or a key query parameter. Cannot be used with -authWebhookUrl")
+	streamKeyMaxFailures := flag.Int("streamKeyMaxFailures", server.StreamKeyMaxFailures, "Broadcaster only. Failed stream key checks in a row after which a -streamRegistry stream is locked out for -streamKeyLockout")
+	streamKeyLockout := flag.Duration("streamKeyLockout", server.StreamKeyLockout, "Broadcaster only. How long a -streamRegistry stream is locked out for after -streamKeyMaxFailures failed key checks")
+	streamEventWebhookURL := flag.String("streamEventWebhookUrl", "", "Broadcaster only. URL that events of streams, such as warnings about a misconfigured source encoder and the stream.started, stream.idle, stream.ended and segment.transcoded lifecycle events, are POSTed to as JSON, with retries")
+	streamEventWebhookSecret := flag.String("streamEventWebhookSecret", "", "Broadcaster only. Secret, path to a file containing it, or secret reference that events POSTed to -streamEventWebhookUrl are signed with in the X-Livepeer-Signature header")
+	streamHeartbeatURL := flag.String("streamHeartbeatUrl", "", "Broadcaster only. URL that the stats of every live stream, such as its bitrate, segments, failed segments and orchestrator, are POSTed to as JSON every -streamHeartbeatInterval; signed like stream events. The auth webhook may give streams their own URL")
+	streamHeartbeatInterval := flag.Duration("streamHeartbeatInterval", server.StreamHeartbeatInterval, "Broadcaster only. How often heartbeats of live streams are sent; 0 to never send them")
+	hlsKeyRotation := flag.Uint64("hlsKeyRotation", 0, "Broadcaster only. Encrypt live HLS output with AES-128, using a new key every this many segments; 0 to disable")
+	hlsKeyServer := flag.String("hlsKeyServer", "", "Broadcaster only. URL of a SPEKE key server supplying the keys of streams encrypted with -hlsKeyRotation")
+	hlsKeyServerSystemIDs := flag.String("hlsKeyServerSystemIds", "", "Broadcaster only. Comma separated DRM system IDs to request from -hlsKeyServer; the key URI of the first one is announced in playlists")
+	playbackSigningKeys := flag.String("playbackSigningKeys", "", "Broadcaster only. Comma separated keys used to check signed /stream/ and /recordings/ URLs, as <key> for the node's own key or <keyID>:<key> for a tenant's key, which only signs manifest IDs starting with <keyID>-; key IDs may not contain -")
 	orchWebhookURL := flag.String("orchWebhookUrl", "", "Orchestrator discovery callback URL")
+	selectionWeights := flag.String("selectionWeights", "", "Broadcaster only. Weights orchestrators are scored by when selected, as comma separated <name>=<weight> pairs among stake, price, latency and errors, e.g. stake=1,price=1,latency=2,errors=4; the highest score is selected. Empty to select at random weighted by stake")
+	orchInfoCacheTTL := flag.Duration("orchInfoCacheTTL", 0, "Broadcaster only. Reuse the responses of orchestrators to discovery requests for this long, refreshing them in the background past half of it; 0 to ask orchestrators for every new session")
+	maintenanceLookahead := flag.Duration("maintenanceLookahead", time.Hour, "Broadcaster only. Don't select orchestrators for new sessions when they have a maintenance window starting within this long")
+	segmentEncryption := flag.Bool("segmentEncryption", false, "Broadcaster only. Encrypt segments end to end to orchestrators with a key agreed with each session, so that proxies between them can't read the content; orchestrators without support for it are not selected")
+	orchNotifications := flag.Bool("orchNotifications", true, "Broadcaster only. Subscribe to orchestrator notifications to reselect ahead of price, capability or availability changes")
+	streamCreateRate := flag.Float64("streamCreateRate", 0, "Broadcaster only. Maximum number of new streams admitted per second; 0 for unlimited")
+	streamCreateBurst := flag.Int("streamCreateBurst", 10, "Broadcaster only. Number of new streams that may be admitted at once before -streamCreateRate applies")
+	streamCreateQueue := flag.Int("streamCreateQueue", 1000, "Broadcaster only. Maximum number of new streams waiting to be admitted")
+	uploadBandwidth := flag.Int64("uploadBandwidth", 0, "Broadcaster only. Maximum bitrate in bits per second of the segments the node uploads to orchestrators, paced so that ingest and other streams keep a share of the uplink; 0 for unlimited")
+	orchUploadBandwidth := flag.Int64("orchUploadBandwidth", 0, "Broadcaster only. Maximum bitrate in bits per second of the segments the node uploads to each orchestrator; 0 for unlimited")
+	streamCreateTimeout := flag.Duration("streamCreateTimeout", 30*time.Second, "Broadcaster only. Maximum time a new stream waits to be admitted")
+	httpIdleConnsPerHost := flag.Int("httpIdleConnsPerHost", common.DefaultTransportConfig.MaxIdleConnsPerHost, "Broadcaster only. Number of idle connections kept per orchestrator for submitting segments and downloading results")
+	httpIdleConnTimeout := flag.Duration("httpIdleConnTimeout", common.DefaultTransportConfig.IdleConnTimeout, "Broadcaster only. How long idle orchestrator connections are kept open")
+	httpKeepAlive := flag.Duration("httpKeepAlive", common.DefaultTransportConfig.KeepAlive, "Broadcaster only. TCP keepalive period of orchestrator connections")
+	httpReadHeaderTimeout := flag.Duration("httpReadHeaderTimeout", server.DefaultHTTPServerConfig.ReadHeaderTimeout, "Broadcaster only. Maximum time to read the headers of an HTTP request")
+	httpReadTimeout := flag.Duration("httpReadTimeout", server.DefaultHTTPServerConfig.ReadTimeout, "Broadcaster only. Maximum time to read an HTTP request including its body; 0 for unlimited")
+	httpWriteTimeout := flag.Duration("httpWriteTimeout", server.DefaultHTTPServerConfig.WriteTimeout, "Broadcaster only. Maximum time to serve an HTTP request after reading its headers; 0 for unlimited")
+	httpIdleTimeout := flag.Duration("httpIdleTimeout", server.DefaultHTTPServerConfig.IdleTimeout, "Broadcaster only. Maximum time a keep-alive HTTP connection waits for the next request")
+	httpMaxBodySize := flag.Int64("httpMaxBodySize", server.DefaultHTTPServerConfig.MaxBodyBytes, "Broadcaster only. Maximum size in bytes of an HTTP request body; 0 for unlimited")
+	checkPushBodies := flag.Bool("checkPushBodies", true, "Broadcaster only. Reject segments pushed over HTTP that are empty, truncated or malformed with a 422")
+	hlsBlockingReload := flag.Bool("hlsBlockingReload", false, "Broadcaster only. Hold requests for live HLS media playlists with an _HLS_msn parameter until the playlist has that segment, as in Low-Latency HLS. Partial segments are not served")
+	defaultSegmentDuration := flag.Duration("defaultSegmentDuration", server.DefaultSegmentDuration, "Broadcaster only. Duration of segments pushed over HTTP without a Content-Duration header, when it can not be read from the segment")
+	maxPushBodySize := flag.Int64("maxPushBodySize", server.MaxPushBodySize, "Broadcaster only. Maximum size in bytes of a segment pushed over HTTP; 0 for unlimited")
+	httpMaxRequests := flag.Int("httpMaxRequests", server.DefaultHTTPServerConfig.MaxConcurrentRequests, "Broadcaster only. Maximum number of HTTP requests served at once; 0 for unlimited")
+	startupReadyTimeout := flag.Duration("startupReadyTimeout", 10*time.Second, "Broadcaster only. Maximum time segments ingested while the node is still starting up wait for it to be ready to transcode")
+	segmentWorkers := flag.Int("segmentWorkers", 0, "Broadcaster only. Number of segments processed at once, shared fairly between streams; 0 for unlimited")
+	segmentQueue := flag.Int("segmentQueue", 16, "Broadcaster only. Maximum number of segments per stream waiting for one of -segmentWorkers")
+	secretsRefreshInterval := flag.Duration("secretsRefreshInterval", 5*time.Minute, "Interval at which -objectStore, -recordStore and -playbackSigningKeys given as vault://, awssm:// or gcpsm:// secret references are fetched again to pick up rotated values; 0 to fetch only at startup")
+	memoryBudget := flag.Int64("memoryBudget", 0, "Maximum size in bytes of segments held in memory. Old segments are evicted and new segments rejected once reached; 0 for unlimited")
 
 	flag.Parse()
 	vFlag.Value.Set(*verbosity)
@@ -197,6 +320,13 @@ func main() {
 		return
 	}
 
+	if err := common.SetProxy(common.ProxyConfig{URL: *httpProxy, NoProxy: *noProxy}); err != nil {
+		glog.Fatal("Error setting -httpProxy: ", err)
+	}
+	if *httpProxy != "" {
+		glog.Infof("Sending outbound HTTP traffic through proxy=%s", common.RedactURL(*httpProxy))
+	}
+
 	type NetworkConfig struct {
 		ethController string
 	}
@@ -269,19 +399,29 @@ func main() {
 		glog.Errorf("Error creating livepeer node: %v", err)
 	}
 
-	if *orchSecret != "" {
+	if secrets.IsRef(*orchSecret) {
+		n.OrchSecret, err = secrets.Get(ctx, *orchSecret)
+		if err != nil {
+			glog.Fatal("Error getting orchestrator secret ", err)
+		}
+	} else if *orchSecret != "" {
 		n.OrchSecret, _ = common.GetPass(*orchSecret)
 	}
 
+	// capabilities that failed the transcoding self test
+	var failedCapabilities []core.Capability
 	if *transcoder {
 		core.WorkDir = *datadir
-		if *nvidia != "" {
-			if *testTranscoder {
-				err := core.TestNvidiaTranscoder(*nvidia)
-				if err != nil {
-					glog.Fatalf("Unable to transcode using Nvidia gpu=%s err=%v", *nvidia, err)
-				}
+		if *testTranscoder {
+			failedCapabilities, err = core.SelfTestCapabilities(*nvidia, defaultCapabilities)
+			if err != nil {
+				glog.Fatalf("Unable to transcode using nvidia=%s err=%v", *nvidia, err)
 			}
+			if len(failedCapabilities) > 0 {
+				glog.Warningf("Capabilities failed the transcoding self test and won't be advertised: %s", core.CapabilityNames(failedCapabilities))
+			}
+		}
+		if *nvidia != "" {
 			n.Transcoder = core.NewLoadBalancingTranscoder(*nvidia, core.NewNvidiaTranscoder)
 		} else {
 			n.Transcoder = core.NewLocalTranscoder(*datadir)
@@ -329,6 +469,8 @@ func main() {
 
 	watcherErr := make(chan error)
 	serviceErr := make(chan error)
+	// Parts of the startup that run in the background while the node accepts ingest
+	var pendingStartup sync.WaitGroup
 	var timeWatcher *watchers.TimeWatcher
 	if *network == "offchain" {
 		glog.Infof("***Livepeer is in off-chain mode***")
@@ -379,12 +521,46 @@ func main() {
 			return
 		}
 
+		if *signerMode {
+			if *signerSocket == "" {
+				glog.Fatal("Running a signing service requires -signerSocket")
+			}
+			am, err := eth.NewAccountManager(ethcommon.HexToAddress(*ethAcctAddr), keystoreDir, types.NewEIP155Signer(chainID))
+			if err != nil {
+				glog.Fatal("Error loading ETH account ", err)
+			}
+			if err := am.Unlock(*ethPassword); err != nil {
+				glog.Fatal("Error unlocking ETH account ", err)
+			}
+			sigCtx, cancel := context.WithCancel(ctx)
+			c := make(chan os.Signal, 1)
+			signal.Notify(c, os.Interrupt)
+			go func() {
+				sig := <-c
+				glog.Infof("Exiting signing service: %v", sig)
+				cancel()
+			}()
+			if err := eth.ServeSigner(sigCtx, am, *signerSocket); err != nil {
+				glog.Fatal("Error running signing service ", err)
+			}
+			return
+		}
+
 		var bigMaxGasPrice *big.Int
 		if *maxGasPrice > 0 {
 			bigMaxGasPrice = big.NewInt(int64(*maxGasPrice))
 		}
 
-		client, err := eth.NewClient(ethcommon.HexToAddress(*ethAcctAddr), keystoreDir, *ethPassword, backend, ethcommon.HexToAddress(*ethController), EthTxTimeout, bigMaxGasPrice)
+		var client eth.LivepeerEthClient
+		if *signerSocket != "" {
+			glog.Info("Delegating signing to the signing service on ", *signerSocket)
+			client, err = eth.NewRemoteSignerClient(*signerSocket, backend, ethcommon.HexToAddress(*ethController), EthTxTimeout, bigMaxGasPrice)
+			if err == nil && *ethAcctAddr != "" && client.Account().Address != ethcommon.HexToAddress(*ethAcctAddr) {
+				err = fmt.Errorf("signing service account %v does not match -ethAcctAddr", client.Account().Address.Hex())
+			}
+		} else {
+			client, err = eth.NewClient(ethcommon.HexToAddress(*ethAcctAddr), keystoreDir, *ethPassword, backend, ethcommon.HexToAddress(*ethController), EthTxTimeout, bigMaxGasPrice)
+		}
 		if err != nil {
 			glog.Errorf("Failed to create Livepeer Ethereum client: %v", err)
 			return
@@ -413,13 +589,19 @@ func main() {
 		}
 		topics := watchers.FilterTopics()
 
-		// Determine backfilling start block
-		originalLastSeenBlock, err := dbh.LastSeenBlock()
-		if err != nil {
+		// Determine backfilling start block. The DB and chain lookups are independent.
+		var originalLastSeenBlock *big.Int
+		lastSeenErr := make(chan error, 1)
+		go func() {
+			var err error
+			originalLastSeenBlock, err = dbh.LastSeenBlock()
+			lastSeenErr <- err
+		}()
+		currentRoundStartBlock, err := client.CurrentRoundStartBlock()
+		if err := <-lastSeenErr; err != nil {
 			glog.Errorf("db: failed to retrieve latest retained block: %v", err)
 			return
 		}
-		currentRoundStartBlock, err := client.CurrentRoundStartBlock()
 		if err != nil {
 			glog.Errorf("eth: failed to retrieve current round start block: %v", err)
 			return
@@ -699,19 +881,33 @@ func main() {
 		blockWatchCtx, cancel := context.WithCancel(ctx)
 		defer cancel()
 
-		// Backfill events that the node has missed since its last seen block. This method will block
-		// and the node will not continue setup until it finishes
-		if err := blockWatcher.BackfillEventsIfNeeded(blockWatchCtx); err != nil {
-			glog.Errorf("Failed to backfill events: %v", err)
-			return
-		}
-
 		blockWatcherErr := make(chan error, 1)
-		go func() {
-			if err := blockWatcher.Watch(blockWatchCtx); err != nil {
-				blockWatcherErr <- fmt.Errorf("block watcher error: %v", err)
+		// Backfill events that the node has missed since its last seen block before watching for new ones
+		backfillAndWatch := func() error {
+			if err := blockWatcher.BackfillEventsIfNeeded(blockWatchCtx); err != nil {
+				return fmt.Errorf("Failed to backfill events: %v", err)
 			}
-		}()
+			go func() {
+				if err := blockWatcher.Watch(blockWatchCtx); err != nil {
+					blockWatcherErr <- fmt.Errorf("block watcher error: %v", err)
+				}
+			}()
+			return nil
+		}
+		if n.NodeType == core.BroadcasterNode {
+			// Ingest doesn't depend on the backfilled events so it comes up in the meantime
+			pendingStartup.Add(1)
+			go func() {
+				defer pendingStartup.Done()
+				if err := backfillAndWatch(); err != nil {
+					blockWatcherErr <- err
+				}
+			}()
+		} else if err := backfillAndWatch(); err != nil {
+			// The node will not continue setup until the backfill finishes
+			glog.Error(err)
+			return
+		}
 
 		go func() {
 			var err error
@@ -743,7 +939,7 @@ func main() {
 			Path:   fmt.Sprintf("/%s", br[1]),
 			User:   url.UserPassword(cr[0], cr[1]),
 		}
-		glog.Warningf("-s3bucket and -s3creds are deprecated. Instead, you can use -objectStore %s", u.String())
+		glog.Warningf("-s3bucket and -s3creds are deprecated. Instead, you can use -objectStore %s", common.RedactURL(u.String()))
 		ustr := u.String()
 		objectstore = &ustr
 	}
@@ -754,18 +950,13 @@ func main() {
 			Host:     *gsBucket,
 			RawQuery: fmt.Sprintf("keyfile=%s", *gsKey),
 		}
-		glog.Warningf("-gsbucket and -gskey are deprecated. Instead, you can use -objectStore %s", u.String())
+		glog.Warningf("-gsbucket and -gskey are deprecated. Instead, you can use -objectStore %s", common.RedactURL(u.String()))
 		ustr := u.String()
 		objectstore = &ustr
 	}
 
 	if *objectstore != "" {
-		prepared, err := drivers.PrepareOSURL(*objectstore)
-		if err != nil {
-			glog.Error("Error creating object store driver: ", err)
-			return
-		}
-		drivers.NodeStorage, err = drivers.ParseOSURL(prepared, false)
+		drivers.NodeStorage, err = objectStoreDriver(ctx, *objectstore, false, *secretsRefreshInterval)
 		if err != nil {
 			glog.Error("Error creating object store driver: ", err)
 			return
@@ -773,16 +964,60 @@ func main() {
 	}
 
 	if *recordstore != "" {
-		prepared, err := drivers.PrepareOSURL(*recordstore)
+		drivers.RecordStorage, err = objectStoreDriver(ctx, *recordstore, true, *secretsRefreshInterval)
 		if err != nil {
 			glog.Error("Error creating recordings object store driver: ", err)
 			return
 		}
-		drivers.RecordStorage, err = drivers.ParseOSURL(prepared, true)
-		if err != nil {
-			glog.Error("Error creating recordings object store driver: ", err)
-			return
+	}
+	server.RecordFlushInterval = *recordFlushInterval
+	server.RecordAudioTrack = *recordAudioTrack
+	server.RecordChecksums = *recordChecksums
+	server.RecordingsRedirectExpiry = *recordingsRedirectExpiry
+	server.RecordingMP4 = *recordingMP4
+	server.StartOverWindow = *startOverWindow
+	server.DVRWindow = *dvrWindow
+	server.TranscodeProfileLimits = server.ProfileLimits{
+		MinDimension:      *profileMinDimension,
+		MaxDimension:      *profileMaxDimension,
+		EvenDimensions:    *profileEvenDimensions,
+		MinFPS:            *profileMinFPS,
+		MaxFPS:            *profileMaxFPS,
+		MinBitrate:        *profileMinBitrate,
+		MaxBitrate:        *profileMaxBitrate,
+		GOPWithinSegments: *profileGOPWithinSegments,
+	}
+	server.RenditionGOPAlignment, err = server.ParseGOPAlignment(*gopAlignment)
+	if err != nil {
+		glog.Errorf("Invalid -gopAlignment err=%v", err)
+		return
+	}
+	server.ManifestIDPolicy, err = server.ParseManifestIDRules(*manifestIDPattern, *manifestIDMaxLength, *manifestIDReservedPrefixes)
+	if err != nil {
+		glog.Errorf("Invalid manifest ID rules err=%v", err)
+		return
+	}
+	server.ResponseArchiveRetention = *responseArchiveRetention
+	server.SegmentJournalRetention = *segmentJournalRetention
+	server.SessionSnapshotMaxAge = *sessionSnapshotMaxAge
+	server.StreamStateMaxAge = *streamStateMaxAge
+	server.DrainTimeout = *drainTimeout
+	server.JobWorkers = *jobWorkers
+	server.JobMaxAttempts = *jobMaxAttempts
+	server.HLSKeyRotation = *hlsKeyRotation
+	if *hlsKeyServer != "" {
+		if *hlsKeyRotation == 0 {
+			glog.Fatal("-hlsKeyServer requires -hlsKeyRotation to be set")
+		}
+		if _, err := validateURL(*hlsKeyServer); err != nil {
+			glog.Fatal("Error setting HLS key server URL ", err)
 		}
+		var systemIDs []string
+		if *hlsKeyServerSystemIDs != "" {
+			systemIDs = strings.Split(*hlsKeyServerSystemIDs, ",")
+		}
+		glog.Info("Using HLS key server ", common.RedactURL(*hlsKeyServer))
+		server.HLSKeyServer = server.NewSPEKEKeySource(*hlsKeyServer, systemIDs)
 	}
 
 	core.MaxSessions = *maxSessions
@@ -790,14 +1025,119 @@ func main() {
 		lpmon.MaxSessions(core.MaxSessions)
 	}
 
+	if *auditLog != "" {
+		var redact []string
+		if *auditRedact != "" {
+			redact = strings.Split(*auditRedact, ",")
+		}
+		al, err := server.NewAuditLogger(*auditLog, *auditLogMaxSize, *auditLogMaxBackups, redact)
+		if err != nil {
+			glog.Fatal("Error setting up audit log ", err)
+		}
+		glog.Info("Writing audit log to ", *auditLog)
+		server.AuditLog = al
+	}
+
 	if *authWebhookURL != "" {
-		_, err := validateURL(*authWebhookURL)
+		whurl, err := secrets.Resolve(ctx, *authWebhookURL)
 		if err != nil {
+			glog.Fatal("Error getting auth webhook URL ", err)
+		}
+		if _, err := validateURL(whurl); err != nil {
 			glog.Fatal("Error setting auth webhook URL ", err)
 		}
-		glog.Info("Using auth webhook URL ", *authWebhookURL)
-		server.AuthWebhookURL = *authWebhookURL
+		if secrets.IsRef(*authWebhookURL) {
+			glog.Info("Using auth webhook URL from ", *authWebhookURL)
+		} else {
+			glog.Info("Using auth webhook URL ", common.RedactURL(whurl))
+		}
+		server.AuthWebhookURL = whurl
+		if secrets.IsRef(*authWebhookSecret) {
+			server.AuthWebhookSecret, err = secrets.Get(ctx, *authWebhookSecret)
+			if err != nil {
+				glog.Fatal("Error getting auth webhook secret ", err)
+			}
+		} else if *authWebhookSecret != "" {
+			server.AuthWebhookSecret, _ = common.GetPass(*authWebhookSecret)
+		}
+		if *authWebhookVerifyResponse && server.AuthWebhookSecret == "" {
+			glog.Fatal("-authWebhookVerifyResponse requires -authWebhookSecret")
+		}
+		server.AuthWebhookVerifyResponse = *authWebhookVerifyResponse
+		if *authWebhookRetries < 0 {
+			glog.Fatal("-authWebhookRetries must not be negative")
+		}
+		server.AuthWebhookRetries = *authWebhookRetries
+		server.AuthWebhookRetryBackoff = *authWebhookRetryBackoff
+		server.AuthWebhookCacheTTL = *authWebhookCacheTTL
+		server.AuthWebhookFailOpen = *authWebhookFailOpen
+	}
+
+	if *streamRegistry != "" {
+		if *authWebhookURL != "" {
+			glog.Fatal("Cannot use both -streamRegistry and -authWebhookUrl")
+		}
+		if *streamKeyMaxFailures <= 0 {
+			glog.Fatal("-streamKeyMaxFailures must be positive")
+		}
+		reg, err := server.NewStreamRegistry(*streamRegistry)
+		if err != nil {
+			glog.Fatal("Error reading stream registry ", err)
+		}
+		glog.Info("Authenticating ingest against the streams of ", *streamRegistry)
+		server.ProvisionedStreams = reg
+		server.StreamKeyMaxFailures = *streamKeyMaxFailures
+		server.StreamKeyLockout = *streamKeyLockout
+	}
+
+	if *meteringSink != "" {
+		m, err := server.NewUsageMeter(*meteringSink, *meteringBatchSize, *meteringFlushInterval)
+		if err != nil {
+			glog.Fatal("Error setting up metering ", err)
+		}
+		glog.Info("Delivering usage records to ", common.RedactURL(*meteringSink))
+		server.Metering = m
+	}
+
+	if *eventBus != "" {
+		p, err := server.NewEventPublisher(*eventBus, *eventBusSubjectPrefix)
+		if err != nil {
+			glog.Fatal("Error setting up event bus ", err)
+		}
+		glog.Info("Publishing events to ", common.RedactURL(*eventBus))
+		server.EventBus = p
+	}
+
+	if *streamEventWebhookURL != "" {
+		if _, err := validateURL(*streamEventWebhookURL); err != nil {
+			glog.Fatal("Error setting stream event webhook URL ", err)
+		}
+		glog.Info("Using stream event webhook URL ", common.RedactURL(*streamEventWebhookURL))
+		server.StreamEventWebhookURL = *streamEventWebhookURL
+		if secrets.IsRef(*streamEventWebhookSecret) {
+			server.StreamEventWebhookSecret, err = secrets.Get(ctx, *streamEventWebhookSecret)
+			if err != nil {
+				glog.Fatal("Error getting stream event webhook secret ", err)
+			}
+		} else if *streamEventWebhookSecret != "" {
+			server.StreamEventWebhookSecret, _ = common.GetPass(*streamEventWebhookSecret)
+		}
+	}
+
+	if *streamHeartbeatURL != "" {
+		if _, err := validateURL(*streamHeartbeatURL); err != nil {
+			glog.Fatal("Error setting stream heartbeat URL ", err)
+		}
+		glog.Info("Using stream heartbeat URL ", common.RedactURL(*streamHeartbeatURL))
+		server.StreamHeartbeatURL = *streamHeartbeatURL
+	}
+	server.StreamHeartbeatInterval = *streamHeartbeatInterval
+
+	tlsPolicy, err := server.NewTLSConfigPolicy(*tlsMinVersion, *tlsCipherSuites, *hstsMaxAge, *hstsIncludeSubdomains)
+	if err != nil {
+		glog.Fatal("Error setting TLS policy ", err)
 	}
+	server.TLSPolicy = tlsPolicy
 
 	if n.NodeType == core.BroadcasterNode {
 		// default lpms listener for broadcaster; same as default rpc port
@@ -807,15 +1147,26 @@ func main() {
 
 		bcast := core.NewBroadcaster(n)
 
+		discovery.OrchInfoCacheTTL = *orchInfoCacheTTL
+
 		// When the node is on-chain mode always cache the on-chain orchestrators and poll for updates
 		// Right now we rely on the DBOrchestratorPoolCache constructor to do this. Consider separating the logic
 		// caching/polling from the logic for fetching orchestrators during discovery
 		if *network != "offchain" {
 			ctx, cancel := context.WithCancel(ctx)
 			defer cancel()
-			dbOrchPoolCache, err := discovery.NewDBOrchestratorPoolCache(ctx, n, timeWatcher)
+			// Serve the orchestrators cached in the DB while the cache is refreshed
+			dbOrchPoolCache, refreshed, err := discovery.NewLazyDBOrchestratorPoolCache(ctx, n, timeWatcher)
 			if err != nil {
 				glog.Errorf("Could not create orchestrator pool with DB cache: %v", err)
+			} else {
+				pendingStartup.Add(1)
+				go func() {
+					defer pendingStartup.Done()
+					if err := <-refreshed; err != nil {
+						glog.Errorf("Could not refresh orchestrator pool DB cache: %v", err)
+					}
+				}()
 			}
 
 			n.OrchestratorPool = dbOrchPoolCache
@@ -823,11 +1174,19 @@ func main() {
 
 		// Set up orchestrator discovery
 		if *orchWebhookURL != "" {
-			whurl, err := validateURL(*orchWebhookURL)
+			resolved, err := secrets.Resolve(ctx, *orchWebhookURL)
+			if err != nil {
+				glog.Fatal("Error getting orch webhook URL ", err)
+			}
+			whurl, err := validateURL(resolved)
 			if err != nil {
 				glog.Fatal("Error setting orch webhook URL ", err)
 			}
-			glog.Info("Using orchestrator webhook URL ", whurl)
+			if secrets.IsRef(*orchWebhookURL) {
+				glog.Info("Using orchestrator webhook URL from ", *orchWebhookURL)
+			} else {
+				glog.Info("Using orchestrator webhook URL ", common.RedactURL(whurl.String()))
+			}
 			n.OrchestratorPool = discovery.NewWebhookPool(bcast, whurl)
 		} else if len(orchURLs) > 0 {
 			n.OrchestratorPool = discovery.NewOrchestratorPool(bcast, orchURLs)
@@ -843,8 +1202,8 @@ func main() {
 			glog.Errorf("Error checking for local -httpAddr: %v", err)
 			return
 		}
-		if !isFlagSet["httpIngest"] && !isLocalHTTP && server.AuthWebhookURL == "" {
-			glog.Warning("HTTP ingest is disabled because -httpAddr is publicly accessible. To enable, configure -authWebhookUrl or use the -httpIngest flag")
+		if !isFlagSet["httpIngest"] && !isLocalHTTP && server.AuthWebhookURL == "" && server.ProvisionedStreams == nil {
+			glog.Warning("HTTP ingest is disabled because -httpAddr is publicly accessible. To enable, configure -authWebhookUrl or -streamRegistry, or use the -httpIngest flag")
 			*httpIngest = false
 		}
 
@@ -859,7 +1218,7 @@ func main() {
 			if err != nil {
 				glog.Fatal("Error setting verifier URL ", err)
 			}
-			glog.Info("Using the Epic Labs classifier for verification at ", *verifierURL)
+			glog.Info("Using the Epic Labs classifier for verification at ", common.RedactURL(*verifierURL))
 			server.Policy = &verification.Policy{Retries: 2, Verifier: &verification.EpicClassifier{Addr: *verifierURL}}
 
 			// Set the verifier path. Remove once [1] is implemented!
@@ -873,8 +1232,126 @@ func main() {
 			server.Policy = &verification.Policy{Retries: 2}
 		}
 
+		server.OrchNotifications = *orchNotifications
+		server.MaintenanceLookahead = *maintenanceLookahead
+		server.SegmentEncryption = *segmentEncryption
+
+		if *streamCreateRate > 0 {
+			glog.Infof("Admitting up to %v new streams per second with a burst of %d", *streamCreateRate, *streamCreateBurst)
+			server.StreamAdmission = server.NewAdmissionQueue(*streamCreateRate, *streamCreateBurst, *streamCreateQueue, *streamCreateTimeout)
+		}
+
+		if *uploadBandwidth > 0 || *orchUploadBandwidth > 0 {
+			glog.Infof("Pacing segment uploads to bitrate=%d per orchestrator bitrate=%d", *uploadBandwidth, *orchUploadBandwidth)
+			server.UploadPacing = server.NewUploadPacer(*uploadBandwidth, *orchUploadBandwidth)
+		}
+
+		server.StartupReady = server.NewStartupGate(*startupReadyTimeout)
+		go func() {
+			pendingStartup.Wait()
+			glog.Info("Node is ready to transcode")
+			server.StartupReady.Open()
+		}()
+
+		if *segmentWorkers > 0 {
+			glog.Infof("Processing up to %d segments at once", *segmentWorkers)
+			server.SegmentWorkers = server.NewSegmentPool(*segmentWorkers, *segmentQueue)
+		}
+
+		transportCfg := common.DefaultTransportConfig
+		transportCfg.MaxIdleConnsPerHost = *httpIdleConnsPerHost
+		transportCfg.IdleConnTimeout = *httpIdleConnTimeout
+		transportCfg.KeepAlive = *httpKeepAlive
+		if *segmentClientCert != "" {
+			cert, err := tls.LoadX509KeyPair(*segmentClientCert, *segmentClientKey)
+			if err != nil {
+				glog.Fatal("Error loading segment client certificate ", err)
+			}
+			transportCfg.ClientCertificates = []tls.Certificate{cert}
+		}
+		server.SetTransportConfig(transportCfg)
+
+		httpCfg := server.DefaultHTTPServerConfig
+		httpCfg.ReadHeaderTimeout = *httpReadHeaderTimeout
+		httpCfg.ReadTimeout = *httpReadTimeout
+		httpCfg.WriteTimeout = *httpWriteTimeout
+		httpCfg.IdleTimeout = *httpIdleTimeout
+		httpCfg.MaxBodyBytes = *httpMaxBodySize
+		httpCfg.MaxConcurrentRequests = *httpMaxRequests
+		if *httpCert != "" {
+			if *httpKey == "" {
+				glog.Fatal("-httpCert requires -httpKey")
+			}
+			httpCfg.CertFile = *httpCert
+			httpCfg.KeyFile = *httpKey
+		}
+		server.MediaServerConfig = httpCfg
+
 		// Set max transcode attempts. <=0 is OK; it just means "don't transcode"
 		server.MaxAttempts = *maxAttempts
+		server.RTMPReconnectGrace = *rtmpReconnectGrace
+		if server.SelectionWeights, err = core.ParseSelectionWeights(*selectionWeights); err != nil {
+			glog.Fatalf("Invalid -selectionWeights: %v", err)
+		}
+		server.MaxPushBodySize = *maxPushBodySize
+		if *defaultSegmentDuration <= 0 {
+			glog.Fatalf("Invalid -defaultSegmentDuration=%s", *defaultSegmentDuration)
+		}
+		server.DefaultSegmentDuration = *defaultSegmentDuration
+		core.BlockingPlaylistReload = *hlsBlockingReload
+		server.CheckPushBodies = *checkPushBodies
+		if *ingestNodes != "" {
+			cluster, err := server.NewIngestCluster(*ingestNode, strings.Split(*ingestNodes, ","))
+			if err != nil {
+				glog.Fatalf("Invalid -ingestNodes: %v", err)
+			}
+			server.IngestNodes = cluster
+		}
+		if *clusterStore != "" {
+			if *clusterTTL < time.Second {
+				glog.Fatal("-clusterTTL must be at least a second")
+			}
+			store, err := server.NewClusterStore(*clusterStore)
+			if err != nil {
+				glog.Fatalf("Invalid -clusterStore: %v", err)
+			}
+			cluster, err := server.NewStreamCluster(store, *ingestNode, *clusterTTL)
+			if err != nil {
+				glog.Fatalf("Invalid -ingestNode for -clusterStore: %v", err)
+			}
+			server.Cluster = cluster
+			go cluster.Run(ctx)
+		}
+		if *keyframeSegmentation {
+			if *segmentMinDuration <= 0 || (*segmentMaxDuration > 0 && *segmentMaxDuration < *segmentMinDuration) {
+				glog.Fatal("-segmentMinDuration must be positive and no greater than -segmentMaxDuration")
+			}
+			server.KeyframeSegmentation = &server.SegmentBounds{Min: *segmentMinDuration, Max: *segmentMaxDuration}
+		}
+		if *streamSegmentDurationMin <= 0 || *streamSegmentDurationMax < *streamSegmentDurationMin {
+			glog.Fatal("-streamSegmentDurationMin must be positive and no greater than -streamSegmentDurationMax")
+		}
+		server.SegmentDurationMin, server.SegmentDurationMax = *streamSegmentDurationMin, *streamSegmentDurationMax
+		server.SanitizeTimestamps = *sanitizeTimestamps
+		if *avSyncDriftLimit <= 0 {
+			glog.Fatal("-avSyncDriftLimit must be positive")
+		}
+		if *avSyncCorrection && !*sanitizeTimestamps {
+			glog.Fatal("-avSyncCorrection requires -sanitizeTimestamps")
+		}
+		server.AVSyncDriftLimit = *avSyncDriftLimit
+		server.AVSyncCorrection = *avSyncCorrection
+		server.RestreamFFmpegPath = *restreamFFmpeg
+		server.DegradeCapabilities = *degradeCapabilities
+		server.FormatFallbackAttempts = *formatFallbackAttempts
+		if *maxSourceResolution != "" || *maxSourceFPS > 0 {
+			limits, err := server.NewSourceLimits(*maxSourceResolution, *maxSourceFPS, *sourceLimitAction)
+			if err != nil {
+				glog.Fatal("Error setting source limits: ", err)
+			}
+			glog.Infof("Capping stream sources at %v action=%s", limits, *sourceLimitAction)
+			server.SourceCaps = limits
+		}
 
 	} else if n.NodeType == core.OrchestratorNode {
 		suri, err := getServiceURI(n, *serviceAddr)
@@ -886,19 +1363,54 @@ func main() {
 		// take the port to listen to from the service URI
 		*httpAddr = defaultAddr(*httpAddr, "", n.GetServiceURI().Port())
 
-		n.Capabilities = core.NewCapabilities(defaultCapabilities, mandatoryCapabilities)
+		n.Capabilities = core.NewCapabilities(defaultCapabilities, mandatoryCapabilities).Without(failedCapabilities)
 
 		if !*transcoder && n.OrchSecret == "" {
 			glog.Fatal("Running an orchestrator requires an -orchSecret for standalone mode or -transcoder for orchestrator+transcoder mode")
 		}
+
+		if *maintenanceWindows != "" {
+			windows, err := server.ParseMaintenanceWindows(*maintenanceWindows)
+			if err != nil {
+				glog.Fatal("Error parsing -maintenanceWindows: ", err)
+			}
+			server.SetMaintenanceWindows(windows)
+		}
+
+		if *serviceURICheckers != "" {
+			for _, checker := range strings.Split(*serviceURICheckers, ",") {
+				u, err := url.ParseRequestURI(strings.TrimSpace(checker))
+				if err != nil {
+					glog.Fatal("Error parsing -serviceURICheckers: ", err)
+				}
+				server.ServiceURICheckers = append(server.ServiceURICheckers, u)
+			}
+			server.ServiceURICheckInterval = *serviceURICheckInterval
+		}
 	}
 	*cliAddr = defaultAddr(*cliAddr, "127.0.0.1", CliPort)
+	if *cliUsers != "" {
+		auth, err := server.NewCLIAuthorizer(*cliUsers)
+		if err != nil {
+			glog.Fatal("Error loading CLI users ", err)
+		}
+		glog.Info("Requiring tokens for CLI requests")
+		server.CLIAuth = auth
+	}
 
 	if drivers.NodeStorage == nil {
 		// base URI will be empty for broadcasters; that's OK
 		drivers.NodeStorage = drivers.NewMemoryDriver(n.GetServiceURI())
 	}
 
+	if *memoryBudget > 0 {
+		glog.Infof("Limiting segments held in memory to %d bytes", *memoryBudget)
+		server.MemBudget = common.NewMemoryBudget(*memoryBudget)
+		if mem, ok := drivers.NodeStorage.(*drivers.MemoryOS); ok {
+			mem.SetBudget(server.MemBudget)
+		}
+	}
+
 	//Create Livepeer Node
 
 	//Set up the media server
@@ -913,6 +1425,26 @@ func main() {
 	msCtx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	if *playbackSigningKeys != "" {
+		keys, err := secrets.Resolve(ctx, *playbackSigningKeys)
+		if err != nil {
+			glog.Fatal("Error getting playback signing keys ", err)
+		}
+		signer, err := server.ParsePlaybackKeys(keys)
+		if err != nil {
+			glog.Fatal("Error parsing playback signing keys ", err)
+		}
+		if secrets.IsRef(*playbackSigningKeys) && *secretsRefreshInterval > 0 {
+			go secrets.Watch(msCtx, *playbackSigningKeys, keys, *secretsRefreshInterval, func(keys string) {
+				if err := signer.SetKeys(keys); err != nil {
+					glog.Error("Error parsing rotated playback signing keys, keeping the current keys: ", err)
+				}
+			})
+		}
+		glog.Info("Requiring signed URLs for playback")
+		s.Use(signer.Middleware())
+	}
+
 	if *currentManifest {
 		glog.Info("Current ManifestID will be available over ", *httpAddr)
 		s.ExposeCurrentManifest = *currentManifest
@@ -933,6 +1465,19 @@ func main() {
 			return
 		}
 
+		if *segmentClientCA != "" {
+			var allowlist []string
+			if *segmentClientAllowlist != "" {
+				allowlist = strings.Split(*segmentClientAllowlist, ",")
+			}
+			auth, err := server.NewClientAuth(*segmentClientCA, allowlist)
+			if err != nil {
+				glog.Fatal("Error loading segment client CAs ", err)
+			}
+			glog.Info("Requiring client certificates from broadcasters")
+			server.SegmentClientAuth = auth
+		}
+
 		orch := core.NewOrchestrator(s.LivepeerNode, timeWatcher)
 
 		go func() {
@@ -949,6 +1494,9 @@ func main() {
 			tc <- struct{}{}
 		}
 
+		// and whether it can be reached from outside
+		go server.WatchServiceURI(msCtx, n)
+
 	}()
 
 	if n.NodeType == core.TranscoderNode {
@@ -976,6 +1524,19 @@ func main() {
 
 	c := make(chan os.Signal)
 	signal.Notify(c, os.Interrupt)
+	if n.NodeType == core.BroadcasterNode && *drainTimeout > 0 {
+		// Broadcasters let their live streams end before exiting on SIGTERM,
+		// e.g. during rollouts. Another SIGTERM exits right away.
+		term := make(chan os.Signal, 1)
+		signal.Notify(term, syscall.SIGTERM)
+		go func() {
+			sig := <-term
+			glog.Infof("Draining broadcaster before exiting: %v", sig)
+			signal.Stop(term)
+			signal.Notify(c, syscall.SIGTERM)
+			s.StartDrain()
+		}()
+	}
 	select {
 	case err := <-watcherErr:
 		glog.Error(err)
@@ -995,6 +1556,9 @@ func main() {
 	case <-wc:
 		glog.Infof("CLI webserver shut down")
 		return
+	case <-s.Drained():
+		glog.Infof("Broadcaster drained; exiting Livepeer")
+		return
 	case sig := <-c:
 		glog.Infof("Exiting Livepeer: %v", sig)
 		time.Sleep(time.Millisecond * 500) //Give time for other processes to shut down completely
@@ -1002,13 +1566,47 @@ func main() {
 	}
 }
 
+// objectStoreDriver creates the driver for the object store URL `osURL`, which
+// may be a secret reference. Drivers created from a secret are replaced
+// whenever the secret is rotated.
+func objectStoreDriver(ctx context.Context, osURL string, useFullAPI bool, refresh time.Duration) (drivers.OSDriver, error) {
+	parse := func(u string) (drivers.OSDriver, error) {
+		prepared, err := drivers.PrepareOSURL(u)
+		if err != nil {
+			return nil, err
+		}
+		return drivers.ParseOSURL(prepared, useFullAPI)
+	}
+	if !secrets.IsRef(osURL) {
+		return parse(osURL)
+	}
+	u, err := secrets.Get(ctx, osURL)
+	if err != nil {
+		return nil, err
+	}
+	driver, err := parse(u)
+	if err != nil || refresh <= 0 {
+		return driver, err
+	}
+	rotating := drivers.NewRotatingOS(driver)
+	go secrets.Watch(ctx, osURL, u, refresh, func(u string) {
+		driver, err := parse(u)
+		if err != nil {
+			glog.Error("Error creating object store driver from rotated secret, keeping the current driver: ", err)
+			return
+		}
+		rotating.Set(driver)
+	})
+	return rotating, nil
+}
+
 func validateURL(u string) (*url.URL, error) {
 	if u == "" {
 		return nil, nil
 	}
 	p, err := url.ParseRequestURI(u)
 	if err != nil {
-		return nil, err
+		return nil, common.RedactError(err)
 	}
 	if p.Scheme != "http" && p.Scheme != "https" {
 		return nil, errors.New("URL should be HTTP or HTTPS")
@@ -29,6 +29,11 @@ func main() {
 			Usage: "host for the Livepeer node",
 			Value: "localhost",
 		},
+		cli.StringFlag{
+			Name:   "token",
+			Usage:  "token of the CLI user, if the node requires one",
+			EnvVar: "LP_CLI_TOKEN",
+		},
 		cli.IntFlag{
 			Name:  "loglevel",
 			Value: 4,
@@ -44,6 +49,10 @@ func main() {
 		log.Root().SetHandler(log.LvlFilterHandler(log.Lvl(c.Int("loglevel")), log.StreamHandler(os.Stdout, log.TerminalFormat(true))))
 		rand.Seed(time.Now().UnixNano())
 
+		if token := c.String("token"); token != "" {
+			http.DefaultClient.Transport = &tokenTransport{token: token, next: http.DefaultTransport}
+		}
+
 		// Start the wizard and relinquish control
 		w := &wizard{
 			endpoint: fmt.Sprintf("http://%v:%v/status", c.String("host"), c.String("http")),
@@ -175,3 +184,15 @@ func (w *wizard) checkNet() {
 	w.testnet = nID == RinkebyChainID || nID == DevenvChainID
 	w.offchain = nID == "0"
 }
+
+// tokenTransport authenticates requests to the node as a CLI user
+type tokenTransport struct {
+	token string
+	next  http.RoundTripper
+}
+
+func (t *tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.token)
+	return t.next.RoundTrip(req)
+}
@@ -0,0 +1,61 @@
+package common
+
+import (
+	"bytes"
+	"encoding/base64"
+	"hash"
+	"sync"
+
+	"golang.org/x/crypto/sha3"
+)
+
+// Buffers larger than this are left to the garbage collector rather than
+// pooled, so that an occasional large body doesn't stay pinned in memory
+const maxPooledBuffer = 16 << 20
+
+var bufPool = sync.Pool{
+	New: func() interface{} { return new(bytes.Buffer) },
+}
+
+var keccakPool = sync.Pool{
+	New: func() interface{} { return sha3.NewLegacyKeccak256() },
+}
+
+// GetBuffer returns an empty buffer from the pool. It should be returned
+// with PutBuffer once its contents are no longer referenced.
+func GetBuffer() *bytes.Buffer {
+	return bufPool.Get().(*bytes.Buffer)
+}
+
+// PutBuffer returns a buffer obtained from GetBuffer to the pool
+func PutBuffer(b *bytes.Buffer) {
+	if b == nil || b.Cap() > maxPooledBuffer {
+		return
+	}
+	b.Reset()
+	bufPool.Put(b)
+}
+
+// EncodeBase64 returns the standard base64 encoding of data, using pooled
+// scratch space for the encoding
+func EncodeBase64(data []byte) string {
+	b := GetBuffer()
+	defer PutBuffer(b)
+	n := base64.StdEncoding.EncodedLen(len(data))
+	b.Grow(n)
+	enc := b.Bytes()[:n]
+	base64.StdEncoding.Encode(enc, data)
+	return string(enc)
+}
+
+// Keccak256 returns the Keccak256 hash of data, the same as the go-ethereum
+// crypto package, reusing the hash state between calls
+func Keccak256(data ...[]byte) []byte {
+	d := keccakPool.Get().(hash.Hash)
+	defer keccakPool.Put(d)
+	d.Reset()
+	for _, b := range data {
+		d.Write(b)
+	}
+	return d.Sum(nil)
+}
@@ -0,0 +1,53 @@
+package common
+
+import (
+	"bytes"
+	"encoding/base64"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBufferPool(t *testing.T) {
+	assert := assert.New(t)
+
+	b := GetBuffer()
+	assert.Equal(0, b.Len())
+	b.WriteString("hello")
+	PutBuffer(b)
+	// buffers come back empty
+	b = GetBuffer()
+	assert.Equal(0, b.Len())
+	PutBuffer(b)
+
+	// oversized buffers aren't kept
+	b = bytes.NewBuffer(make([]byte, 0, maxPooledBuffer+1))
+	PutBuffer(b)
+	assert.Equal(maxPooledBuffer+1, b.Cap())
+	PutBuffer(nil)
+}
+
+func TestEncodeBase64(t *testing.T) {
+	assert := assert.New(t)
+	for _, data := range [][]byte{nil, []byte("a"), []byte("ab"), []byte("abc"), bytes.Repeat([]byte{0xfe}, 1000)} {
+		assert.Equal(base64.StdEncoding.EncodeToString(data), EncodeBase64(data))
+	}
+}
+
+func TestKeccak256(t *testing.T) {
+	assert := assert.New(t)
+	data := [][]byte{nil, []byte("livepeer"), bytes.Repeat([]byte{1, 2, 3}, 1000)}
+	for _, d := range data {
+		assert.Equal(crypto.Keccak256(d), Keccak256(d))
+	}
+	assert.Equal(crypto.Keccak256(data...), Keccak256(data...))
+}
+
+func BenchmarkEncodeBase64(b *testing.B) {
+	data := bytes.Repeat([]byte{0xfe}, 512)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		EncodeBase64(data)
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"strconv"
 	"strings"
 	"text/template"
+	"time"
 
 	ethcommon "github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
@@ -41,6 +42,21 @@ type DB struct {
 	findLatestMiniHeader             *sql.Stmt
 	findAllMiniHeadersSortedByNumber *sql.Stmt
 	deleteMiniHeader                 *sql.Stmt
+	insertSegment                    *sql.Stmt
+	selectSegments                   *sql.Stmt
+	pruneSegments                    *sql.Stmt
+	updateJob                        *sql.Stmt
+	selectJobs                       *sql.Stmt
+	deleteJob                        *sql.Stmt
+	updateBroadcastSession           *sql.Stmt
+	selectBroadcastSessions          *sql.Stmt
+	deleteBroadcastSession           *sql.Stmt
+	deleteBroadcastSessions          *sql.Stmt
+	pruneBroadcastSessions           *sql.Stmt
+	updateStreamState                *sql.Stmt
+	selectStreamState                *sql.Stmt
+	deleteStreamState                *sql.Stmt
+	pruneStreamStates                *sql.Stmt
 }
 
 // DBOrch is the type binding for a row result from the orchestrators table
@@ -61,6 +77,72 @@ type DBUnbondingLock struct {
 	WithdrawRound int64
 }
 
+// DBSegment is the type binding for a row of the segmentJournal table: the
+// outcome of a source segment of a stream
+type DBSegment struct {
+	ManifestID string
+	SeqNo      uint64
+	StartedAt  time.Time
+	FinishedAt time.Time
+	// Transcoder URI of the orchestrator last tried for the segment, if any
+	Orchestrator string
+	Status       string
+	Pixels       int64
+	// Cost of the pixels at the price of the orchestrator, in wei
+	Cost *big.Rat
+}
+
+// DBJob is the type binding for a row of the jobs table: a long running task
+// of the node, e.g. a VOD transcode, queued to be run and retried
+type DBJob struct {
+	ID   string
+	Kind string
+	// Key of the job among those of its kind, e.g. the manifest ID it is for
+	Key string
+	// JSON encoded arguments of the job, and its result once it has one
+	Payload  []byte
+	Result   []byte
+	State    string
+	Attempts int
+	Progress float64
+	// JSON encoded error of the last attempt, if it failed
+	Error     []byte
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// DBBroadcastSession is the type binding for a row of the broadcastSessions
+// table: the state of a session of a stream with an orchestrator, enough for
+// the broadcaster to resume it after a restart
+type DBBroadcastSession struct {
+	ManifestID string
+	// Transcoder URI of the orchestrator
+	Orchestrator string
+	// Protobuf encoded net.OrchestratorInfo of the session, holding its auth
+	// token and ticket params
+	Info []byte
+	// Nonce of the last ticket sent to the orchestrator
+	SenderNonce uint32
+	// Credit of the broadcaster with the orchestrator, in wei
+	Balance   *big.Rat
+	UpdatedAt time.Time
+}
+
+// DBStreamState is the type binding for a row of the streamStates table: the
+// state of a stream, enough for the broadcaster to resume it after a restart
+type DBStreamState struct {
+	// Manifest ID the stream is pushed to
+	ManifestID string
+	// Manifest ID the stream is known by internally, e.g. as set by the auth
+	// webhook
+	InternalID string
+	// JSON encoded parameters of the stream
+	State []byte
+	// Sequence number following that of the last segment of the stream
+	NextSeqNo uint64
+	UpdatedAt time.Time
+}
+
 // DBOrchFilter is an object used to attach a filter to a selectOrch query
 type DBOrchFilter struct {
 	MaxPrice     *big.Rat
@@ -144,6 +226,53 @@ var schema = `
 	);
 
 	CREATE INDEX IF NOT EXISTS idx_blockheaders_number ON blockheaders(number);
+
+	CREATE TABLE IF NOT EXISTS segmentJournal (
+		manifestID STRING,
+		seqNo int64,
+		startedAt int64,
+		finishedAt int64,
+		orchestrator STRING,
+		status STRING,
+		pixels int64,
+		cost TEXT,
+		PRIMARY KEY(manifestID, seqNo)
+	);
+	CREATE INDEX IF NOT EXISTS idx_segmentjournal_finishedat ON segmentJournal(finishedAt);
+
+	CREATE TABLE IF NOT EXISTS jobs (
+		id STRING PRIMARY KEY,
+		kind STRING,
+		key STRING,
+		payload BLOB,
+		result BLOB,
+		state STRING,
+		attempts int64,
+		progress REAL,
+		error BLOB,
+		createdAt int64,
+		updatedAt int64
+	);
+
+	CREATE TABLE IF NOT EXISTS broadcastSessions (
+		manifestID STRING,
+		orchestrator STRING,
+		info BLOB,
+		senderNonce int64,
+		balance TEXT,
+		updatedAt int64,
+		PRIMARY KEY(manifestID, orchestrator)
+	);
+	CREATE INDEX IF NOT EXISTS idx_broadcastsessions_updatedat ON broadcastSessions(updatedAt);
+
+	CREATE TABLE IF NOT EXISTS streamStates (
+		manifestID STRING PRIMARY KEY,
+		internalID STRING,
+		state BLOB,
+		nextSeqNo int64,
+		updatedAt int64
+	);
+	CREATE INDEX IF NOT EXISTS idx_streamstates_updatedat ON streamStates(updatedAt);
 `
 
 func NewDBOrch(ethereumAddr string, serviceURI string, pricePerPixel int64, activationRound int64, deactivationRound int64, stake int64) *DBOrch {
@@ -373,6 +502,131 @@ func InitDB(dbPath string) (*DB, error) {
 	}
 	d.deleteMiniHeader = stmt
 
+	// Segment journal prepared statements
+	stmt, err = db.Prepare(`
+	INSERT OR REPLACE INTO segmentJournal(manifestID, seqNo, startedAt, finishedAt, orchestrator, status, pixels, cost)
+	VALUES(:manifestID, :seqNo, :startedAt, :finishedAt, :orchestrator, :status, :pixels, :cost)
+	`)
+	if err != nil {
+		glog.Error("Unable to prepare insertSegment ", err)
+		d.Close()
+		return nil, err
+	}
+	d.insertSegment = stmt
+	stmt, err = db.Prepare("SELECT manifestID, seqNo, startedAt, finishedAt, orchestrator, status, pixels, cost FROM segmentJournal WHERE manifestID=? AND seqNo >= ? ORDER BY seqNo ASC LIMIT ?")
+	if err != nil {
+		glog.Error("Unable to prepare selectSegments ", err)
+		d.Close()
+		return nil, err
+	}
+	d.selectSegments = stmt
+	stmt, err = db.Prepare("DELETE FROM segmentJournal WHERE finishedAt < ?")
+	if err != nil {
+		glog.Error("Unable to prepare pruneSegments ", err)
+		d.Close()
+		return nil, err
+	}
+	d.pruneSegments = stmt
+
+	// Job queue prepared statements
+	stmt, err = db.Prepare(`
+	INSERT OR REPLACE INTO jobs(id, kind, key, payload, result, state, attempts, progress, error, createdAt, updatedAt)
+	VALUES(:id, :kind, :key, :payload, :result, :state, :attempts, :progress, :error, :createdAt, :updatedAt)
+	`)
+	if err != nil {
+		glog.Error("Unable to prepare updateJob ", err)
+		d.Close()
+		return nil, err
+	}
+	d.updateJob = stmt
+	stmt, err = db.Prepare("SELECT id, kind, key, payload, result, state, attempts, progress, error, createdAt, updatedAt FROM jobs ORDER BY createdAt ASC")
+	if err != nil {
+		glog.Error("Unable to prepare selectJobs ", err)
+		d.Close()
+		return nil, err
+	}
+	d.selectJobs = stmt
+	stmt, err = db.Prepare("DELETE FROM jobs WHERE id=?")
+	if err != nil {
+		glog.Error("Unable to prepare deleteJob ", err)
+		d.Close()
+		return nil, err
+	}
+	d.deleteJob = stmt
+
+	// Broadcast session prepared statements
+	stmt, err = db.Prepare(`
+	INSERT OR REPLACE INTO broadcastSessions(manifestID, orchestrator, info, senderNonce, balance, updatedAt)
+	VALUES(:manifestID, :orchestrator, :info, :senderNonce, :balance, :updatedAt)
+	`)
+	if err != nil {
+		glog.Error("Unable to prepare updateBroadcastSession ", err)
+		d.Close()
+		return nil, err
+	}
+	d.updateBroadcastSession = stmt
+	stmt, err = db.Prepare("SELECT manifestID, orchestrator, info, senderNonce, balance, updatedAt FROM broadcastSessions WHERE manifestID=? AND updatedAt >= ? ORDER BY orchestrator ASC")
+	if err != nil {
+		glog.Error("Unable to prepare selectBroadcastSessions ", err)
+		d.Close()
+		return nil, err
+	}
+	d.selectBroadcastSessions = stmt
+	stmt, err = db.Prepare("DELETE FROM broadcastSessions WHERE manifestID=? AND orchestrator=?")
+	if err != nil {
+		glog.Error("Unable to prepare deleteBroadcastSession ", err)
+		d.Close()
+		return nil, err
+	}
+	d.deleteBroadcastSession = stmt
+	stmt, err = db.Prepare("DELETE FROM broadcastSessions WHERE manifestID=?")
+	if err != nil {
+		glog.Error("Unable to prepare deleteBroadcastSessions ", err)
+		d.Close()
+		return nil, err
+	}
+	d.deleteBroadcastSessions = stmt
+	stmt, err = db.Prepare("DELETE FROM broadcastSessions WHERE updatedAt < ?")
+	if err != nil {
+		glog.Error("Unable to prepare pruneBroadcastSessions ", err)
+		d.Close()
+		return nil, err
+	}
+	d.pruneBroadcastSessions = stmt
+
+	// Stream state prepared statements
+	stmt, err = db.Prepare(`
+	INSERT OR REPLACE INTO streamStates(manifestID, internalID, state, nextSeqNo, updatedAt)
+	VALUES(:manifestID, :internalID, :state, :nextSeqNo, :updatedAt)
+	`)
+	if err != nil {
+		glog.Error("Unable to prepare updateStreamState ", err)
+		d.Close()
+		return nil, err
+	}
+	d.updateStreamState = stmt
+	stmt, err = db.Prepare("SELECT manifestID, internalID, state, nextSeqNo, updatedAt FROM streamStates WHERE manifestID=? AND updatedAt >= ?")
+	if err != nil {
+		glog.Error("Unable to prepare selectStreamState ", err)
+		d.Close()
+		return nil, err
+	}
+	d.selectStreamState = stmt
+	stmt, err = db.Prepare("DELETE FROM streamStates WHERE manifestID=?")
+	if err != nil {
+		glog.Error("Unable to prepare deleteStreamState ", err)
+		d.Close()
+		return nil, err
+	}
+	d.deleteStreamState = stmt
+	stmt, err = db.Prepare("DELETE FROM streamStates WHERE updatedAt < ?")
+	if err != nil {
+		glog.Error("Unable to prepare pruneStreamStates ", err)
+		d.Close()
+		return nil, err
+	}
+	d.pruneStreamStates = stmt
+
 	glog.V(DEBUG).Info("Initialized DB node")
 	return &d, nil
 }
@@ -430,6 +684,51 @@ func (db *DB) Close() {
 	if db.deleteMiniHeader != nil {
 		db.deleteMiniHeader.Close()
 	}
+	if db.insertSegment != nil {
+		db.insertSegment.Close()
+	}
+	if db.selectSegments != nil {
+		db.selectSegments.Close()
+	}
+	if db.pruneSegments != nil {
+		db.pruneSegments.Close()
+	}
+	if db.updateJob != nil {
+		db.updateJob.Close()
+	}
+	if db.selectJobs != nil {
+		db.selectJobs.Close()
+	}
+	if db.deleteJob != nil {
+		db.deleteJob.Close()
+	}
+	if db.updateBroadcastSession != nil {
+		db.updateBroadcastSession.Close()
+	}
+	if db.selectBroadcastSessions != nil {
+		db.selectBroadcastSessions.Close()
+	}
+	if db.deleteBroadcastSession != nil {
+		db.deleteBroadcastSession.Close()
+	}
+	if db.deleteBroadcastSessions != nil {
+		db.deleteBroadcastSessions.Close()
+	}
+	if db.pruneBroadcastSessions != nil {
+		db.pruneBroadcastSessions.Close()
+	}
+	if db.updateStreamState != nil {
+		db.updateStreamState.Close()
+	}
+	if db.selectStreamState != nil {
+		db.selectStreamState.Close()
+	}
+	if db.deleteStreamState != nil {
+		db.deleteStreamState.Close()
+	}
+	if db.pruneStreamStates != nil {
+		db.pruneStreamStates.Close()
+	}
 	if db.dbh != nil {
 		db.dbh.Close()
 	}
@@ -937,6 +1236,280 @@ func (db *DB) DeleteMiniHeader(hash ethcommon.Hash) error {
 	return nil
 }
 
+// InsertSegment journals the outcome of a segment, replacing any earlier
+// outcome of the same segment
+func (db *DB) InsertSegment(seg *DBSegment) error {
+	if seg == nil {
+		return errors.New("cannot store nil segment")
+	}
+	var cost sql.NullString
+	if seg.Cost != nil {
+		cost = sql.NullString{String: seg.Cost.RatString(), Valid: true}
+	}
+	_, err := db.insertSegment.Exec(
+		sql.Named("manifestID", seg.ManifestID),
+		sql.Named("seqNo", int64(seg.SeqNo)),
+		sql.Named("startedAt", unixMillis(seg.StartedAt)),
+		sql.Named("finishedAt", unixMillis(seg.FinishedAt)),
+		sql.Named("orchestrator", seg.Orchestrator),
+		sql.Named("status", seg.Status),
+		sql.Named("pixels", seg.Pixels),
+		sql.Named("cost", cost),
+	)
+	if err != nil {
+		return errors.Wrapf(err, "failed inserting segment manifestID=%s seqNo=%d", seg.ManifestID, seg.SeqNo)
+	}
+	return nil
+}
+
+// SelectSegments returns up to `limit` journaled segments of a stream, in
+// order, from sequence number `fromSeqNo` on
+func (db *DB) SelectSegments(manifestID string, fromSeqNo uint64, limit int) ([]*DBSegment, error) {
+	rows, err := db.selectSegments.Query(manifestID, int64(fromSeqNo), limit)
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not retrieve segments manifestID=%s", manifestID)
+	}
+	defer rows.Close()
+	var segs []*DBSegment
+	for rows.Next() {
+		var (
+			seg                   DBSegment
+			seqNo                 int64
+			startedAt, finishedAt int64
+			cost                  sql.NullString
+		)
+		if err := rows.Scan(&seg.ManifestID, &seqNo, &startedAt, &finishedAt, &seg.Orchestrator, &seg.Status, &seg.Pixels, &cost); err != nil {
+			return nil, errors.Wrapf(err, "could not scan segment manifestID=%s", manifestID)
+		}
+		seg.SeqNo = uint64(seqNo)
+		seg.StartedAt = fromUnixMillis(startedAt)
+		seg.FinishedAt = fromUnixMillis(finishedAt)
+		if cost.Valid {
+			c, ok := new(big.Rat).SetString(cost.String)
+			if !ok {
+				return nil, fmt.Errorf("invalid cost manifestID=%s seqNo=%d cost=%s", manifestID, seqNo, cost.String)
+			}
+			seg.Cost = c
+		}
+		segs = append(segs, &seg)
+	}
+	return segs, rows.Err()
+}
+
+// PruneSegments removes the segments journaled as finished before `before`,
+// returning how many were removed
+func (db *DB) PruneSegments(before time.Time) (int64, error) {
+	res, err := db.pruneSegments.Exec(unixMillis(before))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed pruning segments")
+	}
+	return res.RowsAffected()
+}
+
+// UpdateJob stores a job, replacing what was stored of it before
+func (db *DB) UpdateJob(job *DBJob) error {
+	if job == nil {
+		return errors.New("cannot store nil job")
+	}
+	_, err := db.updateJob.Exec(
+		sql.Named("id", job.ID),
+		sql.Named("kind", job.Kind),
+		sql.Named("key", job.Key),
+		sql.Named("payload", job.Payload),
+		sql.Named("result", job.Result),
+		sql.Named("state", job.State),
+		sql.Named("attempts", job.Attempts),
+		sql.Named("progress", job.Progress),
+		sql.Named("error", job.Error),
+		sql.Named("createdAt", unixMillis(job.CreatedAt)),
+		sql.Named("updatedAt", unixMillis(job.UpdatedAt)),
+	)
+	if err != nil {
+		return errors.Wrapf(err, "failed storing job id=%s", job.ID)
+	}
+	return nil
+}
+
+// SelectJobs returns all stored jobs, oldest first
+func (db *DB) SelectJobs() ([]*DBJob, error) {
+	rows, err := db.selectJobs.Query()
+	if err != nil {
+		return nil, errors.Wrap(err, "could not retrieve jobs")
+	}
+	defer rows.Close()
+	var jobs []*DBJob
+	for rows.Next() {
+		var (
+			job                  DBJob
+			createdAt, updatedAt int64
+		)
+		if err := rows.Scan(&job.ID, &job.Kind, &job.Key, &job.Payload, &job.Result, &job.State, &job.Attempts, &job.Progress, &job.Error, &createdAt, &updatedAt); err != nil {
+			return nil, errors.Wrap(err, "could not scan job")
+		}
+		job.CreatedAt = fromUnixMillis(createdAt)
+		job.UpdatedAt = fromUnixMillis(updatedAt)
+		jobs = append(jobs, &job)
+	}
+	return jobs, rows.Err()
+}
+
+// DeleteJob removes a stored job
+func (db *DB) DeleteJob(id string) error {
+	if _, err := db.deleteJob.Exec(id); err != nil {
+		return errors.Wrapf(err, "failed deleting job id=%s", id)
+	}
+	return nil
+}
+
+// UpdateBroadcastSession stores the state of a broadcast session, replacing
+// what was stored of it before
+func (db *DB) UpdateBroadcastSession(sess *DBBroadcastSession) error {
+	if sess == nil {
+		return errors.New("cannot store nil broadcast session")
+	}
+	var balance sql.NullString
+	if sess.Balance != nil {
+		balance = sql.NullString{String: sess.Balance.RatString(), Valid: true}
+	}
+	_, err := db.updateBroadcastSession.Exec(
+		sql.Named("manifestID", sess.ManifestID),
+		sql.Named("orchestrator", sess.Orchestrator),
+		sql.Named("info", sess.Info),
+		sql.Named("senderNonce", int64(sess.SenderNonce)),
+		sql.Named("balance", balance),
+		sql.Named("updatedAt", unixMillis(sess.UpdatedAt)),
+	)
+	if err != nil {
+		return errors.Wrapf(err, "failed storing broadcast session manifestID=%s orchestrator=%s", sess.ManifestID, sess.Orchestrator)
+	}
+	return nil
+}
+
+// SelectBroadcastSessions returns the broadcast sessions of a stream stored
+// since `since`, ordered by orchestrator
+func (db *DB) SelectBroadcastSessions(manifestID string, since time.Time) ([]*DBBroadcastSession, error) {
+	rows, err := db.selectBroadcastSessions.Query(manifestID, unixMillis(since))
+	if err != nil {
+		return nil, errors.Wrapf(err, "could not retrieve broadcast sessions manifestID=%s", manifestID)
+	}
+	defer rows.Close()
+	var sessions []*DBBroadcastSession
+	for rows.Next() {
+		var (
+			sess        DBBroadcastSession
+			senderNonce int64
+			balance     sql.NullString
+			updatedAt   int64
+		)
+		if err := rows.Scan(&sess.ManifestID, &sess.Orchestrator, &sess.Info, &senderNonce, &balance, &updatedAt); err != nil {
+			return nil, errors.Wrapf(err, "could not scan broadcast session manifestID=%s", manifestID)
+		}
+		sess.SenderNonce = uint32(senderNonce)
+		sess.UpdatedAt = fromUnixMillis(updatedAt)
+		if balance.Valid {
+			b, ok := new(big.Rat).SetString(balance.String)
+			if !ok {
+				return nil, fmt.Errorf("invalid balance manifestID=%s orchestrator=%s balance=%s", manifestID, sess.Orchestrator, balance.String)
+			}
+			sess.Balance = b
+		}
+		sessions = append(sessions, &sess)
+	}
+	return sessions, rows.Err()
+}
+
+// DeleteBroadcastSession removes the stored broadcast session of a stream
+// with an orchestrator
+func (db *DB) DeleteBroadcastSession(manifestID, orchestrator string) error {
+	if _, err := db.deleteBroadcastSession.Exec(manifestID, orchestrator); err != nil {
+		return errors.Wrapf(err, "failed deleting broadcast session manifestID=%s orchestrator=%s", manifestID, orchestrator)
+	}
+	return nil
+}
+
+// DeleteBroadcastSessions removes the stored broadcast sessions of a stream
+func (db *DB) DeleteBroadcastSessions(manifestID string) error {
+	if _, err := db.deleteBroadcastSessions.Exec(manifestID); err != nil {
+		return errors.Wrapf(err, "failed deleting broadcast sessions manifestID=%s", manifestID)
+	}
+	return nil
+}
+
+// PruneBroadcastSessions removes the broadcast sessions last stored before
+// `before`, returning how many were removed
+func (db *DB) PruneBroadcastSessions(before time.Time) (int64, error) {
+	res, err := db.pruneBroadcastSessions.Exec(unixMillis(before))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed pruning broadcast sessions")
+	}
+	return res.RowsAffected()
+}
+
+// UpdateStreamState stores the state of a stream, replacing what was stored
+// of it before
+func (db *DB) UpdateStreamState(state *DBStreamState) error {
+	if state == nil {
+		return errors.New("cannot store nil stream state")
+	}
+	_, err := db.updateStreamState.Exec(
+		sql.Named("manifestID", state.ManifestID),
+		sql.Named("internalID", state.InternalID),
+		sql.Named("state", state.State),
+		sql.Named("nextSeqNo", int64(state.NextSeqNo)),
+		sql.Named("updatedAt", unixMillis(state.UpdatedAt)),
+	)
+	if err != nil {
+		return errors.Wrapf(err, "failed storing stream state manifestID=%s", state.ManifestID)
+	}
+	return nil
+}
+
+// SelectStreamState returns the state of a stream stored since `since`, or
+// nil if there is none
+func (db *DB) SelectStreamState(manifestID string, since time.Time) (*DBStreamState, error) {
+	row := db.selectStreamState.QueryRow(manifestID, unixMillis(since))
+	var (
+		state     DBStreamState
+		nextSeqNo int64
+		updatedAt int64
+	)
+	if err := row.Scan(&state.ManifestID, &state.InternalID, &state.State, &nextSeqNo, &updatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(err, "could not retrieve stream state manifestID=%s", manifestID)
+	}
+	state.NextSeqNo = uint64(nextSeqNo)
+	state.UpdatedAt = fromUnixMillis(updatedAt)
+	return &state, nil
+}
+
+// DeleteStreamState removes the stored state of a stream
+func (db *DB) DeleteStreamState(manifestID string) error {
+	if _, err := db.deleteStreamState.Exec(manifestID); err != nil {
+		return errors.Wrapf(err, "failed deleting stream state manifestID=%s", manifestID)
+	}
+	return nil
+}
+
+// PruneStreamStates removes the stream states last stored before `before`,
+// returning how many were removed
+func (db *DB) PruneStreamStates(before time.Time) (int64, error) {
+	res, err := db.pruneStreamStates.Exec(unixMillis(before))
+	if err != nil {
+		return 0, errors.Wrap(err, "failed pruning stream states")
+	}
+	return res.RowsAffected()
+}
+
+func unixMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func fromUnixMillis(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
+
 func encodeLogsJSON(logs []types.Log) ([]byte, error) {
 	logsEnc, err := json.Marshal(logs)
 	if err != nil {
@@ -1253,6 +1253,199 @@ func TestDeleteMiniHeader(t *testing.T) {
 	assert.Equal(headers[0].Hash, h1.Hash)
 }
 
+func TestSegmentJournal(t *testing.T) {
+	dbh, dbraw, err := TempDB(t)
+	defer dbh.Close()
+	defer dbraw.Close()
+	assert := assert.New(t)
+	require := require.New(t)
+	require.Nil(err)
+
+	start := time.Unix(1600000000, 0)
+	segs := []*DBSegment{
+		{ManifestID: "mid", SeqNo: 0, StartedAt: start, FinishedAt: start.Add(500 * time.Millisecond), Orchestrator: "https://o1", Status: "transcoded", Pixels: 1000, Cost: big.NewRat(2000, 3)},
+		{ManifestID: "mid", SeqNo: 1, StartedAt: start.Add(2 * time.Second), FinishedAt: start.Add(3 * time.Second), Status: "untranscoded"},
+		{ManifestID: "mid", SeqNo: 2, StartedAt: start.Add(4 * time.Second), FinishedAt: start.Add(5 * time.Second), Orchestrator: "https://o2", Status: "failed:transcode"},
+		{ManifestID: "other", SeqNo: 0, StartedAt: start, FinishedAt: start.Add(time.Hour), Status: "transcoded"},
+	}
+	for _, seg := range segs {
+		require.Nil(dbh.InsertSegment(seg))
+	}
+	assert.EqualError(dbh.InsertSegment(nil), "cannot store nil segment")
+
+	res, err := dbh.SelectSegments("mid", 0, 10)
+	require.Nil(err)
+	assert.Equal(segs[:3], res)
+	res, err = dbh.SelectSegments("mid", 1, 1)
+	require.Nil(err)
+	assert.Equal(segs[1:2], res)
+	res, err = dbh.SelectSegments("unknown", 0, 10)
+	require.Nil(err)
+	assert.Empty(res)
+
+	// segments processed again replace their earlier outcome
+	retried := *segs[2]
+	retried.Status = "transcoded"
+	retried.Cost = big.NewRat(5, 1)
+	require.Nil(dbh.InsertSegment(&retried))
+	res, err = dbh.SelectSegments("mid", 2, 10)
+	require.Nil(err)
+	assert.Equal([]*DBSegment{&retried}, res)
+
+	// segments finished before the cutoff are pruned
+	n, err := dbh.PruneSegments(start.Add(3 * time.Second))
+	require.Nil(err)
+	assert.Equal(int64(1), n)
+	res, err = dbh.SelectSegments("mid", 0, 10)
+	require.Nil(err)
+	assert.Equal([]*DBSegment{segs[1], &retried}, res)
+	assert.Equal(3, getRowCountOrFatal("SELECT count(*) FROM segmentJournal", dbraw, t))
+}
+
+func TestJobs(t *testing.T) {
+	dbh, dbraw, err := TempDB(t)
+	defer dbh.Close()
+	defer dbraw.Close()
+	assert := assert.New(t)
+	require := require.New(t)
+	require.Nil(err)
+
+	created := time.Unix(1600000000, 0)
+	jobs := []*DBJob{
+		{ID: "j1", Kind: "vod", Payload: []byte(`{"url":"https://in"}`), State: "running", Attempts: 1, Progress: 0.5, CreatedAt: created, UpdatedAt: created.Add(time.Second)},
+		{ID: "j2", Kind: "recordingTranscode", Key: "mid", Payload: []byte(`{}`), State: "queued", CreatedAt: created.Add(time.Minute), UpdatedAt: created.Add(time.Minute)},
+	}
+	for i := len(jobs) - 1; i >= 0; i-- {
+		require.Nil(dbh.UpdateJob(jobs[i]))
+	}
+	assert.EqualError(dbh.UpdateJob(nil), "cannot store nil job")
+
+	res, err := dbh.SelectJobs()
+	require.Nil(err)
+	assert.Equal(jobs, res)
+
+	// updates replace the stored job
+	done := *jobs[0]
+	done.State, done.Progress, done.Result = "completed", 1, []byte(`{"playlist":"index.m3u8"}`)
+	require.Nil(dbh.UpdateJob(&done))
+	failed := *jobs[1]
+	failed.State, failed.Error = "failed", []byte(`{"message":"boom"}`)
+	require.Nil(dbh.UpdateJob(&failed))
+	res, err = dbh.SelectJobs()
+	require.Nil(err)
+	assert.Equal([]*DBJob{&done, &failed}, res)
+
+	require.Nil(dbh.DeleteJob("j1"))
+	require.Nil(dbh.DeleteJob("unknown"))
+	res, err = dbh.SelectJobs()
+	require.Nil(err)
+	assert.Equal([]*DBJob{&failed}, res)
+}
+
+func TestBroadcastSessions(t *testing.T) {
+	dbh, dbraw, err := TempDB(t)
+	defer dbh.Close()
+	defer dbraw.Close()
+	assert := assert.New(t)
+	require := require.New(t)
+	require.Nil(err)
+
+	updated := time.Unix(1600000000, 0)
+	sessions := []*DBBroadcastSession{
+		{ManifestID: "mid", Orchestrator: "https://o1", Info: []byte("info1"), SenderNonce: 5, Balance: big.NewRat(2000, 3), UpdatedAt: updated},
+		{ManifestID: "mid", Orchestrator: "https://o2", Info: []byte("info2"), UpdatedAt: updated.Add(time.Minute)},
+		{ManifestID: "other", Orchestrator: "https://o1", Info: []byte("info3"), SenderNonce: 1, UpdatedAt: updated},
+	}
+	for _, sess := range sessions {
+		require.Nil(dbh.UpdateBroadcastSession(sess))
+	}
+	assert.EqualError(dbh.UpdateBroadcastSession(nil), "cannot store nil broadcast session")
+
+	res, err := dbh.SelectBroadcastSessions("mid", updated)
+	require.Nil(err)
+	assert.Equal(sessions[:2], res)
+	res, err = dbh.SelectBroadcastSessions("mid", updated.Add(time.Second))
+	require.Nil(err)
+	assert.Equal(sessions[1:2], res)
+	res, err = dbh.SelectBroadcastSessions("unknown", updated)
+	require.Nil(err)
+	assert.Empty(res)
+
+	// updates replace the stored session
+	paid := *sessions[0]
+	paid.SenderNonce, paid.Balance, paid.UpdatedAt = 7, big.NewRat(1, 3), updated.Add(2*time.Minute)
+	require.Nil(dbh.UpdateBroadcastSession(&paid))
+	res, err = dbh.SelectBroadcastSessions("mid", updated)
+	require.Nil(err)
+	assert.Equal([]*DBBroadcastSession{&paid, sessions[1]}, res)
+
+	require.Nil(dbh.DeleteBroadcastSession("mid", "https://o2"))
+	require.Nil(dbh.DeleteBroadcastSession("mid", "unknown"))
+	res, err = dbh.SelectBroadcastSessions("mid", updated)
+	require.Nil(err)
+	assert.Equal([]*DBBroadcastSession{&paid}, res)
+
+	// sessions last stored before the cutoff are pruned
+	n, err := dbh.PruneBroadcastSessions(updated.Add(time.Minute))
+	require.Nil(err)
+	assert.Equal(int64(1), n)
+	res, err = dbh.SelectBroadcastSessions("other", updated)
+	require.Nil(err)
+	assert.Empty(res)
+
+	require.Nil(dbh.DeleteBroadcastSessions("mid"))
+	assert.Equal(0, getRowCountOrFatal("SELECT count(*) FROM broadcastSessions", dbraw, t))
+}
+
+func TestStreamStates(t *testing.T) {
+	dbh, dbraw, err := TempDB(t)
+	defer dbh.Close()
+	defer dbraw.Close()
+	assert := assert.New(t)
+	require := require.New(t)
+	require.Nil(err)
+
+	updated := time.Unix(1600000000, 0)
+	states := []*DBStreamState{
+		{ManifestID: "ext", InternalID: "mid", State: []byte(`{"profiles":[]}`), NextSeqNo: 5, UpdatedAt: updated},
+		{ManifestID: "other", InternalID: "other", State: []byte(`{}`), UpdatedAt: updated.Add(time.Minute)},
+	}
+	for _, state := range states {
+		require.Nil(dbh.UpdateStreamState(state))
+	}
+	assert.EqualError(dbh.UpdateStreamState(nil), "cannot store nil stream state")
+
+	res, err := dbh.SelectStreamState("ext", updated)
+	require.Nil(err)
+	assert.Equal(states[0], res)
+	res, err = dbh.SelectStreamState("ext", updated.Add(time.Second))
+	require.Nil(err)
+	assert.Nil(res)
+	res, err = dbh.SelectStreamState("unknown", updated)
+	require.Nil(err)
+	assert.Nil(res)
+
+	// updates replace the stored state
+	later := *states[0]
+	later.NextSeqNo, later.UpdatedAt = 9, updated.Add(2*time.Minute)
+	require.Nil(dbh.UpdateStreamState(&later))
+	res, err = dbh.SelectStreamState("ext", updated)
+	require.Nil(err)
+	assert.Equal(&later, res)
+
+	// states last stored before the cutoff are pruned
+	n, err := dbh.PruneStreamStates(updated.Add(2 * time.Minute))
+	require.Nil(err)
+	assert.Equal(int64(1), n)
+	res, err = dbh.SelectStreamState("other", updated)
+	require.Nil(err)
+	assert.Nil(res)
+
+	require.Nil(dbh.DeleteStreamState("ext"))
+	require.Nil(dbh.DeleteStreamState("unknown"))
+	assert.Equal(0, getRowCountOrFatal("SELECT count(*) FROM streamStates", dbraw, t))
+}
+
 func defaultWinningTicket(t *testing.T) (sessionID string, ticket *pm.Ticket, sig []byte, recipientRand *big.Int) {
 	sessionID = "foo bar"
 	ticket = &pm.Ticket{
@@ -0,0 +1,50 @@
+package common
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIPFilter_Nil(t *testing.T) {
+	assert := assert.New(t)
+	f, err := NewIPFilter(nil, nil)
+	assert.Nil(err)
+	assert.Nil(f)
+	assert.True(f.Allowed(net.ParseIP("1.2.3.4")))
+	assert.True(f.Allowed(nil))
+}
+
+func TestIPFilter_Allowed(t *testing.T) {
+	assert := assert.New(t)
+
+	f, err := NewIPFilter([]string{"10.0.0.0/8", "192.168.1.1", "2001:db8::/32"}, []string{"10.1.0.0/16"})
+	assert.Nil(err)
+	assert.True(f.Allowed(net.ParseIP("10.2.3.4")))
+	assert.True(f.Allowed(net.ParseIP("192.168.1.1")))
+	assert.True(f.Allowed(net.ParseIP("2001:db8::1")))
+	assert.False(f.Allowed(net.ParseIP("10.1.2.3")))
+	assert.False(f.Allowed(net.ParseIP("192.168.1.2")))
+	assert.False(f.Allowed(net.ParseIP("8.8.8.8")))
+	assert.False(f.Allowed(nil))
+
+	// deny only
+	f, err = NewIPFilter(nil, []string{"8.8.8.8"})
+	assert.Nil(err)
+	assert.False(f.Allowed(net.ParseIP("8.8.8.8")))
+	assert.True(f.Allowed(net.ParseIP("8.8.4.4")))
+
+	_, err = NewIPFilter([]string{"10.0.0.0/33"}, nil)
+	assert.NotNil(err)
+	_, err = NewIPFilter(nil, []string{"nope"})
+	assert.NotNil(err)
+}
+
+func TestRemoteIP(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal(net.ParseIP("1.2.3.4"), RemoteIP("1.2.3.4:5678"))
+	assert.Equal(net.ParseIP("::1"), RemoteIP("[::1]:5678"))
+	assert.Equal(net.ParseIP("1.2.3.4"), RemoteIP("1.2.3.4"))
+	assert.Nil(RemoteIP("pipe"))
+}
@@ -0,0 +1,141 @@
+package common
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrMemoryBudget is returned when memory can not be reserved without
+// exceeding the budget
+var ErrMemoryBudget = errors.New("ErrMemoryBudget")
+
+// MemoryBudget accounts for the memory held on behalf of each stream, such as
+// segments in flight and data cached in the memory object store, and bounds
+// the total so that load is shed before the process runs out of memory.
+//
+// A nil *MemoryBudget is valid and accounts for nothing.
+type MemoryBudget struct {
+	limit int64
+
+	mu         sync.Mutex
+	used       int64
+	streams    map[string]int64
+	reclaimers []func(need int64) int64
+}
+
+// NewMemoryBudget returns a budget of `limit` bytes
+func NewMemoryBudget(limit int64) *MemoryBudget {
+	return &MemoryBudget{
+		limit:   limit,
+		streams: make(map[string]int64),
+	}
+}
+
+// AddReclaimer registers a function that is asked to free memory, e.g. by
+// evicting cached data, when a reservation would exceed the budget. It is
+// called with the number of bytes needed and returns the number of bytes
+// freed. Reclaimers must release what they free through Release.
+func (b *MemoryBudget) AddReclaimer(f func(need int64) int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.reclaimers = append(b.reclaimers, f)
+}
+
+// Reserve accounts for `n` bytes held by the stream. If that would exceed the
+// budget the reclaimers are asked to free memory first; if there still isn't
+// enough room nothing is reserved and ErrMemoryBudget is returned.
+func (b *MemoryBudget) Reserve(stream string, n int64) error {
+	return b.ReserveBelow(stream, n, b.Limit())
+}
+
+// ReserveBelow is like Reserve but fails once `limit` bytes, rather than the
+// whole budget, would be used. Lower limits shed the load of less important
+// streams before that of others.
+func (b *MemoryBudget) ReserveBelow(stream string, n, limit int64) error {
+	if b == nil {
+		return nil
+	}
+	if limit > b.limit {
+		limit = b.limit
+	}
+	b.mu.Lock()
+	if b.used+n > limit {
+		need := b.used + n - limit
+		reclaimers := b.reclaimers
+		// Reclaimers release memory through the budget so can't run under the lock
+		b.mu.Unlock()
+		for _, f := range reclaimers {
+			need -= f(need)
+			if need <= 0 {
+				break
+			}
+		}
+		b.mu.Lock()
+		if b.used+n > limit {
+			b.mu.Unlock()
+			return ErrMemoryBudget
+		}
+	}
+	b.add(stream, n)
+	b.mu.Unlock()
+	return nil
+}
+
+// Charge accounts for `n` bytes held by the stream regardless of the budget,
+// for memory that has already been allocated
+func (b *MemoryBudget) Charge(stream string, n int64) {
+	if b == nil || n == 0 {
+		return
+	}
+	b.mu.Lock()
+	b.add(stream, n)
+	b.mu.Unlock()
+}
+
+// Release returns `n` bytes reserved or charged for the stream
+func (b *MemoryBudget) Release(stream string, n int64) {
+	if b == nil || n == 0 {
+		return
+	}
+	b.mu.Lock()
+	b.add(stream, -n)
+	b.mu.Unlock()
+}
+
+func (b *MemoryBudget) add(stream string, n int64) {
+	b.used += n
+	usage := b.streams[stream] + n
+	if usage <= 0 {
+		delete(b.streams, stream)
+		return
+	}
+	b.streams[stream] = usage
+}
+
+// Limit returns the size of the budget in bytes
+func (b *MemoryBudget) Limit() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.limit
+}
+
+// Used returns the number of bytes currently accounted for
+func (b *MemoryBudget) Used() int64 {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
+
+// StreamUsage returns the number of bytes currently held by the stream
+func (b *MemoryBudget) StreamUsage(stream string) int64 {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.streams[stream]
+}
@@ -0,0 +1,101 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryBudget_Nil(t *testing.T) {
+	assert := assert.New(t)
+	var b *MemoryBudget
+	assert.Nil(b.Reserve("a", 100))
+	b.Charge("a", 100)
+	b.Release("a", 100)
+	assert.Equal(int64(0), b.Used())
+	assert.Equal(int64(0), b.Limit())
+	assert.Equal(int64(0), b.StreamUsage("a"))
+}
+
+func TestMemoryBudget_Accounting(t *testing.T) {
+	assert := assert.New(t)
+	b := NewMemoryBudget(100)
+
+	assert.Nil(b.Reserve("a", 60))
+	assert.Nil(b.Reserve("b", 40))
+	assert.Equal(ErrMemoryBudget, b.Reserve("b", 1))
+	assert.Equal(int64(100), b.Used())
+	assert.Equal(int64(60), b.StreamUsage("a"))
+	assert.Equal(int64(40), b.StreamUsage("b"))
+
+	// charges go over the limit
+	b.Charge("b", 10)
+	assert.Equal(int64(110), b.Used())
+	assert.Equal(int64(50), b.StreamUsage("b"))
+
+	b.Release("a", 60)
+	assert.Equal(int64(50), b.Used())
+	assert.Equal(int64(0), b.StreamUsage("a"))
+	assert.NotContains(b.streams, "a")
+	assert.Nil(b.Reserve("a", 50))
+}
+
+func TestMemoryBudget_Reclaim(t *testing.T) {
+	assert := assert.New(t)
+	b := NewMemoryBudget(100)
+	b.Charge("a", 80)
+
+	var asked []int64
+	b.AddReclaimer(func(need int64) int64 {
+		asked = append(asked, need)
+		b.Release("a", 10)
+		return 10
+	})
+	b.AddReclaimer(func(need int64) int64 {
+		asked = append(asked, need)
+		b.Release("a", need)
+		return need
+	})
+
+	// fits without reclaiming
+	assert.Nil(b.Reserve("b", 20))
+	assert.Empty(asked)
+
+	// reclaimers are asked in order for what is still missing
+	assert.Nil(b.Reserve("b", 30))
+	assert.Equal([]int64{30, 20}, asked)
+	assert.Equal(int64(100), b.Used())
+	assert.Equal(int64(50), b.StreamUsage("a"))
+
+	// nothing is reserved if reclaimers can't free enough
+	b = NewMemoryBudget(100)
+	b.Charge("a", 100)
+	b.AddReclaimer(func(need int64) int64 { return 0 })
+	assert.Equal(ErrMemoryBudget, b.Reserve("b", 1))
+	assert.Equal(int64(0), b.StreamUsage("b"))
+}
+
+func TestMemoryBudget_ReserveBelow(t *testing.T) {
+	assert := assert.New(t)
+	b := NewMemoryBudget(100)
+
+	assert.Nil(b.ReserveBelow("a", 70, 80))
+	assert.Equal(ErrMemoryBudget, b.ReserveBelow("a", 20, 80))
+	// the rest of the budget is still there for others
+	assert.Nil(b.ReserveBelow("b", 20, 100))
+	assert.Equal(int64(90), b.Used())
+
+	// limits past the budget are capped to it
+	assert.Equal(ErrMemoryBudget, b.ReserveBelow("b", 20, 200))
+
+	// reclaimers are asked for what is missing below the limit
+	var asked []int64
+	b.AddReclaimer(func(need int64) int64 {
+		asked = append(asked, need)
+		b.Release("a", need)
+		return need
+	})
+	assert.Nil(b.ReserveBelow("c", 10, 50))
+	assert.Equal([]int64{50}, asked)
+	assert.Equal(int64(50), b.Used())
+}
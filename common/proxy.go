@@ -0,0 +1,71 @@
+package common
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sync/atomic"
+
+	"golang.org/x/net/http/httpproxy"
+)
+
+// ProxyConfig routes the outbound HTTP traffic of the node through a proxy
+type ProxyConfig struct {
+	// URL of the proxy, as http://, https:// or socks5://. Credentials for
+	// authenticated proxies go in its user info.
+	URL string
+	// NoProxy is a comma separated list of destinations reached directly, as
+	// in the NO_PROXY environment variable: host names, domain names starting
+	// with a dot for their subdomains, IP addresses and CIDR ranges, each with
+	// an optional port, or * for every destination
+	NoProxy string
+}
+
+var proxyFunc atomic.Value
+
+func init() {
+	setProxyFunc(httpproxy.FromEnvironment())
+}
+
+func setProxyFunc(cfg *httpproxy.Config) {
+	proxyFunc.Store(cfg.ProxyFunc())
+}
+
+// Proxy returns the URL of the proxy to reach the destination of `req`
+// through, or nil to reach it directly. Loopback destinations are always
+// reached directly.
+func Proxy(req *http.Request) (*url.URL, error) {
+	return proxyFunc.Load().(func(*url.URL) (*url.URL, error))(req.URL)
+}
+
+// SetProxy routes the outbound HTTP traffic of the node according to `cfg`.
+// Fields left empty keep the HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+// environment variables, which apply by default.
+func SetProxy(cfg ProxyConfig) error {
+	pcfg := httpproxy.FromEnvironment()
+	if cfg.URL != "" {
+		u, err := url.Parse(cfg.URL)
+		if err != nil {
+			return fmt.Errorf("invalid proxy URL: %v", RedactError(err))
+		}
+		if u.Host == "" || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") {
+			return fmt.Errorf("invalid proxy URL %s", RedactURL(cfg.URL))
+		}
+		pcfg.HTTPProxy, pcfg.HTTPSProxy = cfg.URL, cfg.URL
+	}
+	if cfg.NoProxy != "" {
+		pcfg.NoProxy = cfg.NoProxy
+	}
+	setProxyFunc(pcfg)
+
+	// Clients without a transport of their own, such as webhooks, object
+	// storage SDKs and the eth RPC client, use the default transport
+	if t, ok := http.DefaultTransport.(*http.Transport); ok {
+		t.Proxy = Proxy
+	}
+	// gRPC connections look the proxy up in the environment of the process
+	os.Setenv("HTTPS_PROXY", pcfg.HTTPSProxy)
+	os.Setenv("NO_PROXY", pcfg.NoProxy)
+	return nil
+}
@@ -0,0 +1,70 @@
+package common
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func restoreProxy(t *testing.T) {
+	fn, defaultProxy := proxyFunc.Load(), http.DefaultTransport.(*http.Transport).Proxy
+	httpsProxy, noProxy := os.Getenv("HTTPS_PROXY"), os.Getenv("NO_PROXY")
+	t.Cleanup(func() {
+		proxyFunc.Store(fn)
+		http.DefaultTransport.(*http.Transport).Proxy = defaultProxy
+		os.Setenv("HTTPS_PROXY", httpsProxy)
+		os.Setenv("NO_PROXY", noProxy)
+	})
+}
+
+func TestSetProxy(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	restoreProxy(t)
+
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") != "Basic dXNlcjpwYXNz" {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		// proxies get the absolute URL of the destination
+		w.Write([]byte(r.URL.Host + r.URL.Path))
+	}))
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+	proxyURL.User = url.UserPassword("user", "pass")
+
+	require.Nil(SetProxy(ProxyConfig{URL: proxyURL.String(), NoProxy: "direct.example,.internal.example,10.0.0.0/8"}))
+
+	// segment and webhook clients both go through the proxy
+	for _, client := range []*http.Client{{Transport: NewHTTPTransport(DefaultTransportConfig)}, {}} {
+		resp, err := client.Get("http://orch.example/segment")
+		require.Nil(err)
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.Equal(http.StatusOK, resp.StatusCode)
+		assert.Equal("orch.example/segment", string(body))
+	}
+
+	direct := func(uri string) bool {
+		req, _ := http.NewRequest("GET", uri, nil)
+		u, err := Proxy(req)
+		require.Nil(err)
+		return u == nil
+	}
+	assert.False(direct("https://orch.example:8935"))
+	assert.True(direct("https://direct.example"))
+	assert.True(direct("https://a.internal.example"))
+	assert.True(direct("http://10.1.2.3:8935"))
+	assert.True(direct("http://127.0.0.1:8935"))
+	assert.Equal(proxyURL.String(), os.Getenv("HTTPS_PROXY"))
+
+	assert.Error(SetProxy(ProxyConfig{URL: "ftp://proxy.example"}))
+	assert.Error(SetProxy(ProxyConfig{URL: "proxy.example:3128"}))
+}
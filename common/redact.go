@@ -0,0 +1,105 @@
+package common
+
+import (
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const redacted = "xxxxx"
+
+// query parameters whose values are masked by RedactURL
+var sensitiveParams = map[string]bool{
+	"key":                  true,
+	"keyfile":              true,
+	"token":                true,
+	"access_token":         true,
+	"apikey":               true,
+	"api_key":              true,
+	"secret":               true,
+	"password":             true,
+	"sig":                  true,
+	"signature":            true,
+	"x-amz-signature":      true,
+	"x-amz-credential":     true,
+	"x-amz-security-token": true,
+	"x-goog-signature":     true,
+	"x-goog-credential":    true,
+}
+
+var (
+	urlRegexp      = regexp.MustCompile(`[a-zA-Z][a-zA-Z0-9+.-]*://[^\s"'<>]+`)
+	userinfoRegexp = regexp.MustCompile(`://[^/?#\s]*@`)
+)
+
+// RedactURL masks the credentials in `u` so that it can be logged or shown to
+// clients: the password, or the whole user info if there is no password (as
+// for tokens and the key of gs:// object stores), and the values of query
+// parameters that commonly carry keys or signatures.
+func RedactURL(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return userinfoRegexp.ReplaceAllString(u, "://"+redacted+"@")
+	}
+	if parsed.User != nil {
+		if _, ok := parsed.User.Password(); ok {
+			parsed.User = url.UserPassword(parsed.User.Username(), redacted)
+		} else {
+			parsed.User = url.User(redacted)
+		}
+	}
+	if parsed.RawQuery != "" {
+		params := strings.Split(parsed.RawQuery, "&")
+		for i, p := range params {
+			kv := strings.SplitN(p, "=", 2)
+			name, err := url.QueryUnescape(kv[0])
+			if err != nil {
+				name = kv[0]
+			}
+			if len(kv) == 2 && sensitiveParams[strings.ToLower(name)] {
+				params[i] = kv[0] + "=" + redacted
+			}
+		}
+		parsed.RawQuery = strings.Join(params, "&")
+	}
+	return parsed.String()
+}
+
+// RedactStreamKeyURL masks the credentials in `u` like RedactURL, as well as
+// the last element of its path, which carries the stream key of RTMP ingest
+// URLs.
+func RedactStreamKeyURL(u string) string {
+	u = RedactURL(u)
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+	if i := strings.LastIndex(parsed.Path, "/"); i >= 0 && i < len(parsed.Path)-1 {
+		parsed.Path = parsed.Path[:i+1] + redacted
+		parsed.RawPath = ""
+	}
+	return parsed.String()
+}
+
+// Redact masks the credentials of every URL found in `s`, such as an error
+// message
+func Redact(s string) string {
+	return urlRegexp.ReplaceAllStringFunc(s, RedactURL)
+}
+
+// RedactError returns `err` with the credentials of the URLs in its message
+// masked. URL errors keep their type.
+func RedactError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if uerr, ok := err.(*url.Error); ok {
+		return &url.Error{Op: uerr.Op, URL: RedactURL(uerr.URL), Err: RedactError(uerr.Err)}
+	}
+	msg := err.Error()
+	if red := Redact(msg); red != msg {
+		return errors.New(red)
+	}
+	return err
+}
@@ -0,0 +1,192 @@
+package common
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"sync"
+	"sync/atomic"
+)
+
+// Pooled segment buffers come in power of two size classes from 64KiB to 16MiB
+const (
+	segBufMinShift = 16
+	segBufClasses  = 9
+)
+
+var segBufPools [segBufClasses]sync.Pool
+
+// SegmentBuffer holds the data of a single segment so it can be shared by
+// ingest, the memory object store and transcode submission without copying.
+// Each holder calls Retain before keeping a reference and Release once done.
+// When the last reference is released the memory is returned to a pool.
+type SegmentBuffer struct {
+	data    []byte
+	refs    int32
+	class   int // size class of the pooled memory or -1 if not pooled
+	escaped int32
+}
+
+// ErrSegmentTooLarge is returned when reading more segment data than allowed
+var ErrSegmentTooLarge = errors.New("segment too large")
+
+// ReadSegmentBuffer reads all of `r` into a new segment buffer holding a
+// single reference. `size` is the expected length of the data, or -1 if
+// unknown.
+func ReadSegmentBuffer(r io.Reader, size int64) (*SegmentBuffer, error) {
+	return ReadSegmentBufferLimit(r, size, 0)
+}
+
+// ReadSegmentBufferLimit is ReadSegmentBuffer failing with ErrSegmentTooLarge
+// once more than `limit` bytes are read, unless `limit` is 0. Data is read
+// into pooled memory as it arrives; data of unknown size moves up the size
+// classes as it grows rather than being buffered in full first.
+func ReadSegmentBufferLimit(r io.Reader, size, limit int64) (*SegmentBuffer, error) {
+	if limit > 0 {
+		if size > limit {
+			return nil, ErrSegmentTooLarge
+		}
+		// read one more byte to tell data at the limit from data beyond it
+		r = io.LimitReader(r, limit+1)
+	}
+	b, err := readSegmentBuffer(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && int64(b.Len()) > limit {
+		b.Release()
+		return nil, ErrSegmentTooLarge
+	}
+	return b, nil
+}
+
+func readSegmentBuffer(r io.Reader, size int64) (*SegmentBuffer, error) {
+	if size < 0 {
+		return readGrowing(r)
+	}
+	class := segBufClass(size)
+	if class < 0 {
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return NewSegmentBuffer(data), nil
+	}
+
+	b := &SegmentBuffer{data: pooledBytes(class)[:size], refs: 1, class: class}
+	if _, err := io.ReadFull(r, b.data); err != nil {
+		b.Release()
+		return nil, err
+	}
+	// The reader had more data than announced; fall back to unpooled memory
+	rest, err := ioutil.ReadAll(r)
+	if err != nil {
+		b.Release()
+		return nil, err
+	}
+	if len(rest) > 0 {
+		full := append(append(make([]byte, 0, b.Len()+len(rest)), b.data...), rest...)
+		b.Release()
+		return NewSegmentBuffer(full), nil
+	}
+	return b, nil
+}
+
+// readGrowing reads data of unknown size, starting with the smallest size
+// class and moving the data up a class whenever it is full. Data larger than
+// the largest class falls back to unpooled memory.
+func readGrowing(r io.Reader) (*SegmentBuffer, error) {
+	b := &SegmentBuffer{data: pooledBytes(0), refs: 1, class: 0}
+	for {
+		if len(b.data) == cap(b.data) {
+			if b.class+1 == segBufClasses {
+				rest, err := ioutil.ReadAll(r)
+				if err != nil {
+					b.Release()
+					return nil, err
+				}
+				full := append(append(make([]byte, 0, b.Len()+len(rest)), b.data...), rest...)
+				b.Release()
+				return NewSegmentBuffer(full), nil
+			}
+			next := &SegmentBuffer{data: append(pooledBytes(b.class+1), b.data...), refs: 1, class: b.class + 1}
+			b.Release()
+			b = next
+		}
+		n, err := r.Read(b.data[len(b.data):cap(b.data)])
+		b.data = b.data[:len(b.data)+n]
+		if err == io.EOF {
+			return b, nil
+		}
+		if err != nil {
+			b.Release()
+			return nil, err
+		}
+	}
+}
+
+// pooledBytes returns an empty slice with the capacity of the size class
+func pooledBytes(class int) []byte {
+	if p, ok := segBufPools[class].Get().(*[]byte); ok {
+		return (*p)[:0]
+	}
+	return make([]byte, 0, 1<<uint(segBufMinShift+class))
+}
+
+// NewSegmentBuffer wraps existing data in a segment buffer holding a single
+// reference. The data is left to the garbage collector once released.
+func NewSegmentBuffer(data []byte) *SegmentBuffer {
+	return &SegmentBuffer{data: data, refs: 1, class: -1}
+}
+
+// Bytes returns the segment data. The slice must not be used after the
+// caller's reference has been released.
+func (b *SegmentBuffer) Bytes() []byte {
+	return b.data
+}
+
+// Len returns the length of the segment data
+func (b *SegmentBuffer) Len() int {
+	return len(b.data)
+}
+
+// Retain adds a reference to the buffer
+func (b *SegmentBuffer) Retain() *SegmentBuffer {
+	atomic.AddInt32(&b.refs, 1)
+	return b
+}
+
+// Release drops a reference to the buffer
+func (b *SegmentBuffer) Release() {
+	refs := atomic.AddInt32(&b.refs, -1)
+	if refs > 0 {
+		return
+	}
+	if refs < 0 {
+		panic("segment buffer released too many times")
+	}
+	if b.class < 0 || atomic.LoadInt32(&b.escaped) != 0 {
+		return
+	}
+	data := b.data[:0]
+	b.data = nil
+	segBufPools[b.class].Put(&data)
+}
+
+// Escape marks the data as referenced outside of the reference count, e.g.
+// by an HTTP response still being written, so it is never reused.
+func (b *SegmentBuffer) Escape() {
+	atomic.StoreInt32(&b.escaped, 1)
+}
+
+func segBufClass(size int64) int {
+	if size < 0 {
+		return -1
+	}
+	for i := 0; i < segBufClasses; i++ {
+		if size <= 1<<uint(segBufMinShift+i) {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,100 @@
+package common
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSegmentBuffer_Read(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	// known size uses pooled memory
+	b, err := ReadSegmentBuffer(strings.NewReader("hello"), 5)
+	require.Nil(err)
+	assert.Equal("hello", string(b.Bytes()))
+	assert.Equal(5, b.Len())
+	assert.Equal(0, b.class)
+
+	// unknown size is pooled too, moving up the size classes
+	b, err = ReadSegmentBuffer(strings.NewReader("hello"), -1)
+	require.Nil(err)
+	assert.Equal("hello", string(b.Bytes()))
+	assert.Equal(0, b.class)
+	data := bytes.Repeat([]byte("a"), 200000)
+	b, err = ReadSegmentBuffer(bytes.NewReader(data), -1)
+	require.Nil(err)
+	assert.Equal(data, b.Bytes())
+	assert.Equal(2, b.class)
+
+	// too large to pool
+	b, err = ReadSegmentBuffer(strings.NewReader("hello"), 1<<30)
+	require.Nil(err)
+	assert.Equal("hello", string(b.Bytes()))
+	assert.Equal(-1, b.class)
+
+	// more data than announced
+	b, err = ReadSegmentBuffer(strings.NewReader("hello world"), 5)
+	require.Nil(err)
+	assert.Equal("hello world", string(b.Bytes()))
+	assert.Equal(-1, b.class)
+
+	// less data than announced
+	b, err = ReadSegmentBuffer(strings.NewReader("hello"), 10)
+	assert.Equal(io.ErrUnexpectedEOF, err)
+	assert.Nil(b)
+}
+
+func TestSegmentBuffer_ReadLimit(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	b, err := ReadSegmentBufferLimit(strings.NewReader("hello"), -1, 5)
+	require.Nil(err)
+	assert.Equal("hello", string(b.Bytes()))
+	b, err = ReadSegmentBufferLimit(strings.NewReader("hello"), 5, 0)
+	require.Nil(err)
+	assert.Equal("hello", string(b.Bytes()))
+
+	// rejected up front when the size is known
+	_, err = ReadSegmentBufferLimit(strings.NewReader("hello"), 5, 4)
+	assert.Equal(ErrSegmentTooLarge, err)
+	// otherwise once read
+	_, err = ReadSegmentBufferLimit(strings.NewReader("hello"), -1, 4)
+	assert.Equal(ErrSegmentTooLarge, err)
+	_, err = ReadSegmentBufferLimit(strings.NewReader("hello world"), 5, 8)
+	assert.Equal(ErrSegmentTooLarge, err)
+}
+
+func TestSegmentBuffer_Refs(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	data := bytes.Repeat([]byte("a"), 100)
+	b, err := ReadSegmentBuffer(bytes.NewReader(data), int64(len(data)))
+	require.Nil(err)
+	b.Retain()
+	b.Release()
+	// still referenced
+	assert.Equal(data, b.Bytes())
+	b.Release()
+	assert.Nil(b.Bytes())
+	assert.Panics(func() { b.Release() })
+
+	// escaped buffers are not recycled
+	b, err = ReadSegmentBuffer(bytes.NewReader(data), int64(len(data)))
+	require.Nil(err)
+	b.Escape()
+	b.Release()
+	assert.Equal(data, b.Bytes())
+
+	// wrapped data is never recycled
+	b = NewSegmentBuffer(data)
+	b.Release()
+	assert.Equal(data, b.Bytes())
+}
@@ -0,0 +1,64 @@
+package common
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+)
+
+// TransportConfig tunes the HTTP transports used to submit segments to
+// orchestrators and to download the transcoded results
+type TransportConfig struct {
+	// DialTimeout bounds the time to establish a TCP connection
+	DialTimeout time.Duration
+	// KeepAlive is the TCP keepalive period of pooled connections
+	KeepAlive time.Duration
+	// TLSHandshakeTimeout bounds the time spent on the TLS handshake
+	TLSHandshakeTimeout time.Duration
+	// MaxIdleConnsPerHost is the number of idle connections kept per host.
+	// HTTP/2 multiplexes requests over a single connection so this mostly
+	// matters for HTTP/1.1 hosts.
+	MaxIdleConnsPerHost int
+	// IdleConnTimeout is how long an idle connection is kept in the pool
+	IdleConnTimeout time.Duration
+	// ReadBufferSize and WriteBufferSize size the per connection buffers
+	ReadBufferSize  int
+	WriteBufferSize int
+	// ClientCertificates are presented to servers that ask for one
+	ClientCertificates []tls.Certificate
+}
+
+// DefaultTransportConfig keeps connections to orchestrators warm across
+// segments so that every push doesn't pay for a new TCP and TLS handshake
+var DefaultTransportConfig = TransportConfig{
+	DialTimeout:         2 * time.Second,
+	KeepAlive:           30 * time.Second,
+	TLSHandshakeTimeout: 5 * time.Second,
+	MaxIdleConnsPerHost: 16,
+	IdleConnTimeout:     90 * time.Second,
+	ReadBufferSize:      64 * 1024,
+	WriteBufferSize:     64 * 1024,
+}
+
+// NewHTTPTransport returns a pooling transport that negotiates HTTP/2 with
+// TLS servers and falls back to HTTP/1.1 otherwise. Server certificates are
+// not verified, as orchestrators generally use self-signed certificates.
+// Connections go through the proxy set with SetProxy.
+func NewHTTPTransport(cfg TransportConfig) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   cfg.DialTimeout,
+		KeepAlive: cfg.KeepAlive,
+	}
+	return &http.Transport{
+		Proxy:               Proxy,
+		DialContext:         dialer.DialContext,
+		TLSClientConfig:     &tls.Config{InsecureSkipVerify: true, Certificates: cfg.ClientCertificates},
+		ForceAttemptHTTP2:   true,
+		TLSHandshakeTimeout: cfg.TLSHandshakeTimeout,
+		MaxIdleConnsPerHost: cfg.MaxIdleConnsPerHost,
+		IdleConnTimeout:     cfg.IdleConnTimeout,
+		ReadBufferSize:      cfg.ReadBufferSize,
+		WriteBufferSize:     cfg.WriteBufferSize,
+	}
+}
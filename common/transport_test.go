@@ -0,0 +1,39 @@
+package common
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewHTTPTransport_ReusesConnections(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var conns int32
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	ts.EnableHTTP2 = true
+	ts.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	client := &http.Client{Transport: NewHTTPTransport(DefaultTransportConfig)}
+	for i := 0; i < 5; i++ {
+		resp, err := client.Post(ts.URL, "video/MP2T", nil)
+		require.Nil(err)
+		assert.Equal(2, resp.ProtoMajor)
+		resp.Body.Close()
+	}
+	assert.Equal(int32(1), atomic.LoadInt32(&conns))
+}
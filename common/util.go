@@ -45,6 +45,7 @@ var (
 	ext2mime = map[string]string{
 		".ts":  "video/mp2t",
 		".mp4": "video/mp4",
+		".m4s": "video/iso.segment",
 	}
 )
 
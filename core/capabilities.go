@@ -2,6 +2,9 @@ package core
 
 import (
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
 
 	"github.com/livepeer/go-livepeer/drivers"
 	"github.com/livepeer/go-livepeer/net"
@@ -15,6 +18,7 @@ type Capabilities struct {
 	bitstring   CapabilityString
 	mandatories CapabilityString
 	constraints Constraints
+	version     uint32
 }
 
 // Do not rearrange these values! Only append.
@@ -36,6 +40,36 @@ const (
 	Capability_AuthToken
 )
 
+// capabilityNames names the capabilities; every capability needs a name
+var capabilityNames = map[Capability]string{
+	Capability_H264:                       "H264",
+	Capability_MPEGTS:                     "MPEGTS",
+	Capability_MP4:                        "MP4",
+	Capability_FractionalFramerates:       "FractionalFramerates",
+	Capability_StorageDirect:              "StorageDirect",
+	Capability_StorageS3:                  "StorageS3",
+	Capability_StorageGCS:                 "StorageGCS",
+	Capability_ProfileH264Baseline:        "ProfileH264Baseline",
+	Capability_ProfileH264Main:            "ProfileH264Main",
+	Capability_ProfileH264High:            "ProfileH264High",
+	Capability_ProfileH264ConstrainedHigh: "ProfileH264ConstrainedHigh",
+	Capability_GOP:                        "GOP",
+	Capability_AuthToken:                  "AuthToken",
+}
+
+// CapabilitiesVersion is the version of the list of capabilities known to
+// this node, advertised by orchestrators so that broadcasters can tell
+// capabilities that are unknown to an orchestrator from those it does not
+// support
+var CapabilitiesVersion = uint32(len(capabilityNames))
+
+func (c Capability) String() string {
+	if name, ok := capabilityNames[c]; ok {
+		return name
+	}
+	return fmt.Sprintf("Capability(%d)", int(c))
+}
+
 var capFormatConv = errors.New("capability: unknown format")
 var capStorageConv = errors.New("capability: unknown storage")
 var capProfileConv = errors.New("capability: unknown profile")
@@ -58,6 +92,21 @@ func NewCapabilityString(caps []Capability) CapabilityString {
 
 }
 
+// without returns a copy of the capability string with `caps` cleared
+func (c CapabilityString) without(caps []Capability) CapabilityString {
+	if c == nil {
+		return nil
+	}
+	out := append(CapabilityString{}, c...)
+	for _, v := range caps {
+		if v <= Capability_Unused || int(v)/64 >= len(out) {
+			continue
+		}
+		out[int(v)/64] &^= uint64(1 << (int(v) % 64))
+	}
+	return out
+}
+
 func (c1 CapabilityString) CompatibleWith(c2 CapabilityString) bool {
 	// checks: ( c1 AND c2 ) == c1
 	if len(c1) > len(c2) {
@@ -80,28 +129,12 @@ func JobCapabilities(params *StreamParameters) (*Capabilities, error) {
 
 	// capabilities based on requested output
 	for _, v := range params.Profiles {
-		// set format
-		c, err := formatToCapability(v.Format)
-		if err != nil {
-			return nil, err
-		}
-		caps[c] = true
-
-		// fractional framerates
-		if v.FramerateDen > 0 {
-			caps[Capability_FractionalFramerates] = true
-		}
-
-		// set profiles
-		c, err = profileToCapability(v.Profile)
+		profCaps, err := profileCapabilities(v)
 		if err != nil {
 			return nil, err
 		}
-		caps[c] = true
-
-		// gop
-		if v.GOP != 0 {
-			caps[Capability_GOP] = true
+		for _, c := range profCaps {
+			caps[c] = true
 		}
 	}
 
@@ -123,6 +156,145 @@ func JobCapabilities(params *StreamParameters) (*Capabilities, error) {
 	return &Capabilities{bitstring: NewCapabilityString(capList)}, nil
 }
 
+// profileCapabilities returns the capabilities needed for a rendition
+func profileCapabilities(v ffmpeg.VideoProfile) ([]Capability, error) {
+	var caps []Capability
+
+	// set format
+	c, err := formatToCapability(v.Format)
+	if err != nil {
+		return nil, err
+	}
+	caps = append(caps, c)
+
+	// fractional framerates
+	if v.FramerateDen > 0 {
+		caps = append(caps, Capability_FractionalFramerates)
+	}
+
+	// set profiles
+	c, err = profileToCapability(v.Profile)
+	if err != nil {
+		return nil, err
+	}
+	caps = append(caps, c)
+
+	// gop
+	if v.GOP != 0 {
+		caps = append(caps, Capability_GOP)
+	}
+	return caps, nil
+}
+
+// DegradeProfiles drops the renditions of `profiles` that need any of the
+// `missing` capabilities. Returns an error if a missing capability is needed
+// by the job as a whole rather than by some of its renditions, or if no
+// renditions remain.
+func DegradeProfiles(profiles []ffmpeg.VideoProfile, missing []Capability) ([]ffmpeg.VideoProfile, error) {
+	isMissing := make(map[Capability]bool, len(missing))
+	for _, c := range missing {
+		isMissing[c] = true
+	}
+	var degraded []ffmpeg.VideoProfile
+	needed := make(map[Capability]bool)
+	for _, v := range profiles {
+		caps, err := profileCapabilities(v)
+		if err != nil {
+			return nil, err
+		}
+		keep := true
+		for _, c := range caps {
+			if isMissing[c] {
+				needed[c] = true
+				keep = false
+			}
+		}
+		if keep {
+			degraded = append(degraded, v)
+		}
+	}
+	for _, c := range missing {
+		if !needed[c] {
+			return nil, fmt.Errorf("capability: %v is not needed by renditions", c)
+		}
+	}
+	if len(degraded) == 0 {
+		return nil, errors.New("capability: no renditions left")
+	}
+	return degraded, nil
+}
+
+// Missing returns the capabilities of the job that `orch` does not support
+func (bcast *Capabilities) Missing(orch *net.Capabilities) []Capability {
+	if bcast == nil {
+		return nil
+	}
+	var missing []Capability
+	supported := CapabilityString(orch.GetBitstring())
+	for i, bits := range bcast.bitstring {
+		if i < len(supported) {
+			bits &^= supported[i]
+		}
+		for j := 0; j < 64; j++ {
+			if bits&(1<<uint(j)) != 0 {
+				missing = append(missing, Capability(i*64+j))
+			}
+		}
+	}
+	return missing
+}
+
+// Unknown returns those of `caps` that are past the version of the list of
+// capabilities known to `orch`. Orchestrators that do not advertise a version
+// know none of them for sure, so none are reported.
+func Unknown(caps []Capability, orch *net.Capabilities) []Capability {
+	version := orch.GetVersion()
+	if version == 0 {
+		return nil
+	}
+	var unknown []Capability
+	for _, c := range caps {
+		if uint32(c) >= version {
+			unknown = append(unknown, c)
+		}
+	}
+	return unknown
+}
+
+// ParseCapabilities returns the capabilities named in the comma-separated
+// list `names`
+func ParseCapabilities(names string) ([]Capability, error) {
+	var caps []Capability
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		found := false
+		for c, n := range capabilityNames {
+			if strings.EqualFold(n, name) {
+				caps = append(caps, c)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown capability %q", name)
+		}
+	}
+	return caps, nil
+}
+
+// CapabilityNames returns the names of `caps`, sorted, for logs and errors
+func CapabilityNames(caps []Capability) string {
+	names := make([]string, 0, len(caps))
+	for _, c := range caps {
+		names = append(names, c.String())
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
 func (bcast *Capabilities) CompatibleWith(orch *net.Capabilities) bool {
 	// Ensure bcast and orch are compatible with one another.
 
@@ -151,7 +323,21 @@ func (c *Capabilities) ToNetCapabilities() *net.Capabilities {
 	if c == nil {
 		return nil
 	}
-	return &net.Capabilities{Bitstring: c.bitstring, Mandatories: c.mandatories}
+	return &net.Capabilities{Bitstring: c.bitstring, Mandatories: c.mandatories, Version: c.version}
+}
+
+// Without returns a copy of the capabilities that neither advertises nor
+// requires `caps`
+func (c *Capabilities) Without(caps []Capability) *Capabilities {
+	if c == nil {
+		return nil
+	}
+	return &Capabilities{
+		bitstring:   c.bitstring.without(caps),
+		mandatories: c.mandatories.without(caps),
+		constraints: c.constraints,
+		version:     c.version,
+	}
 }
 
 func CapabilitiesFromNetCapabilities(caps *net.Capabilities) *Capabilities {
@@ -161,11 +347,12 @@ func CapabilitiesFromNetCapabilities(caps *net.Capabilities) *Capabilities {
 	return &Capabilities{
 		bitstring:   caps.Bitstring,
 		mandatories: caps.Mandatories,
+		version:     caps.Version,
 	}
 }
 
 func NewCapabilities(caps []Capability, m []Capability) *Capabilities {
-	c := &Capabilities{}
+	c := &Capabilities{version: CapabilitiesVersion}
 	if caps != nil && len(caps) > 0 {
 		c.bitstring = NewCapabilityString(caps)
 	}
@@ -348,3 +348,86 @@ func TestCapabilities_LegacyCheck(t *testing.T) {
 
 	assert.Len(legacyCapabilities, legacyLen) // sanity check no modifications
 }
+
+func TestCapability_Missing(t *testing.T) {
+	assert := assert.New(t)
+
+	bcast := NewCapabilities([]Capability{Capability_H264, Capability_GOP, 70}, nil)
+	assert.Nil(bcast.Missing(bcast.ToNetCapabilities()))
+	assert.Equal([]Capability{Capability_GOP, 70}, bcast.Missing(NewCapabilities([]Capability{Capability_H264}, nil).ToNetCapabilities()))
+	assert.Equal([]Capability{Capability_H264, Capability_GOP, 70}, bcast.Missing(nil))
+	assert.Nil((*Capabilities)(nil).Missing(nil))
+
+	// capabilities past the version of the orchestrator are unknown to it
+	orch := &net.Capabilities{Version: uint32(Capability_GOP)}
+	assert.Equal([]Capability{Capability_GOP, 70}, Unknown([]Capability{Capability_H264, Capability_GOP, 70}, orch))
+	assert.Nil(Unknown([]Capability{Capability_GOP}, &net.Capabilities{}))
+
+	// orchestrators advertise the version of their capabilities
+	assert.Equal(CapabilitiesVersion, NewCapabilities(nil, nil).ToNetCapabilities().Version)
+	assert.Equal(uint32(Capability_AuthToken)+1, CapabilitiesVersion)
+
+	assert.Equal("GOP", Capability_GOP.String())
+	assert.Equal("Capability(70)", Capability(70).String())
+	assert.Equal("Capability(70),GOP,H264", CapabilityNames([]Capability{70, Capability_H264, Capability_GOP}))
+}
+
+func TestCapability_DegradeProfiles(t *testing.T) {
+	assert := assert.New(t)
+
+	gop := ffmpeg.VideoProfile{Name: "gop", GOP: 1}
+	mp4 := ffmpeg.VideoProfile{Name: "mp4", Format: ffmpeg.FormatMP4}
+	high := ffmpeg.VideoProfile{Name: "high", Profile: ffmpeg.ProfileH264High}
+	plain := ffmpeg.VideoProfile{Name: "plain"}
+	profiles := []ffmpeg.VideoProfile{gop, mp4, high, plain}
+
+	degraded, err := DegradeProfiles(profiles, []Capability{Capability_GOP})
+	assert.Nil(err)
+	assert.Equal([]ffmpeg.VideoProfile{mp4, high, plain}, degraded)
+
+	degraded, err = DegradeProfiles(profiles, []Capability{Capability_MP4, Capability_ProfileH264High})
+	assert.Nil(err)
+	assert.Equal([]ffmpeg.VideoProfile{gop, plain}, degraded)
+
+	// capabilities of the job as a whole cannot be done without
+	_, err = DegradeProfiles(profiles, []Capability{Capability_GOP, Capability_StorageS3})
+	assert.EqualError(err, "capability: StorageS3 is not needed by renditions")
+
+	// nor can all renditions be dropped
+	_, err = DegradeProfiles([]ffmpeg.VideoProfile{gop}, []Capability{Capability_GOP})
+	assert.EqualError(err, "capability: no renditions left")
+
+	_, err = DegradeProfiles([]ffmpeg.VideoProfile{{Format: -1}}, []Capability{Capability_GOP})
+	assert.Equal(capFormatConv, err)
+}
+
+func TestCapabilities_Without(t *testing.T) {
+	assert := assert.New(t)
+
+	caps := NewCapabilities([]Capability{Capability_H264, Capability_GOP, 70}, []Capability{Capability_H264})
+	without := caps.Without([]Capability{Capability_GOP, Capability_H264, 130})
+	assert.Equal([]Capability{Capability_H264, Capability_GOP}, NewCapabilities([]Capability{Capability_H264, Capability_GOP}, nil).Missing(without.ToNetCapabilities()))
+	assert.Nil(NewCapabilities([]Capability{70}, nil).Missing(without.ToNetCapabilities()))
+	assert.Equal(CapabilityString{0}, without.mandatories)
+	// copied rather than changed
+	assert.Nil(caps.Missing(caps.ToNetCapabilities()))
+	assert.Equal(NewCapabilityString([]Capability{Capability_H264}), caps.mandatories)
+
+	assert.Nil((*Capabilities)(nil).Without([]Capability{Capability_GOP}))
+	assert.Nil(NewCapabilities(nil, nil).Without([]Capability{Capability_GOP}).bitstring)
+}
+
+func TestCapability_Parse(t *testing.T) {
+	assert := assert.New(t)
+
+	caps, err := ParseCapabilities("GOP, profileh264high,,")
+	assert.Nil(err)
+	assert.Equal([]Capability{Capability_GOP, Capability_ProfileH264High}, caps)
+
+	caps, err = ParseCapabilities("")
+	assert.Nil(err)
+	assert.Empty(caps)
+
+	_, err = ParseCapabilities("GOP,Teleport")
+	assert.EqualError(err, `unknown capability "Teleport"`)
+}
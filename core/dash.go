@@ -0,0 +1,212 @@
+package core
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/livepeer/m3u8"
+)
+
+// ErrNoDASHRenditions is returned for streams without renditions that can be
+// played over DASH
+var ErrNoDASHRenditions = errors.New("no MPEG-TS or CMAF renditions")
+
+const dashTimescale = 1000
+
+// DASH profiles of the MPEG-TS and the CMAF renditions
+const (
+	dashProfileMP2T = "urn:mpeg:dash:profile:mp2t-simple:2011"
+	dashProfileISO  = "urn:mpeg:dash:profile:isoff-main:2011"
+)
+
+// CMAFSegmentExt is the extension of CMAF media segments
+const CMAFSegmentExt = ".m4s"
+
+type dashMPD struct {
+	XMLName               xml.Name   `xml:"urn:mpeg:dash:schema:mpd:2011 MPD"`
+	Profiles              string     `xml:"profiles,attr"`
+	Type                  string     `xml:"type,attr"`
+	AvailabilityStartTime string     `xml:"availabilityStartTime,attr"`
+	PublishTime           string     `xml:"publishTime,attr"`
+	MinimumUpdatePeriod   string     `xml:"minimumUpdatePeriod,attr"`
+	MinBufferTime         string     `xml:"minBufferTime,attr"`
+	TimeShiftBufferDepth  string     `xml:"timeShiftBufferDepth,attr"`
+	Period                dashPeriod `xml:"Period"`
+}
+
+type dashPeriod struct {
+	ID             string              `xml:"id,attr"`
+	Start          string              `xml:"start,attr"`
+	AdaptationSets []dashAdaptationSet `xml:"AdaptationSet"`
+}
+
+type dashAdaptationSet struct {
+	MimeType         string               `xml:"mimeType,attr"`
+	SegmentAlignment bool                 `xml:"segmentAlignment,attr"`
+	Representations  []dashRepresentation `xml:"Representation"`
+}
+
+type dashRepresentation struct {
+	ID          string          `xml:"id,attr"`
+	Bandwidth   uint32          `xml:"bandwidth,attr"`
+	Width       int             `xml:"width,attr,omitempty"`
+	Height      int             `xml:"height,attr,omitempty"`
+	SegmentList dashSegmentList `xml:"SegmentList"`
+}
+
+type dashSegmentList struct {
+	Timescale      int              `xml:"timescale,attr"`
+	StartNumber    uint64           `xml:"startNumber,attr"`
+	Initialization *dashInit        `xml:"Initialization,omitempty"`
+	Timeline       []dashTimelineS  `xml:"SegmentTimeline>S"`
+	URLs           []dashSegmentURL `xml:"SegmentURL"`
+}
+
+type dashInit struct {
+	SourceURL string `xml:"sourceURL,attr"`
+}
+
+type dashTimelineS struct {
+	T uint64 `xml:"t,attr"`
+	D uint64 `xml:"d,attr"`
+}
+
+type dashSegmentURL struct {
+	Media string `xml:"media,attr"`
+}
+
+// GetDASHManifest returns a dynamic MPEG-DASH manifest of the MPEG-TS and
+// CMAF renditions of the stream, listing the segments of their live playlists
+func (mgr *BasicPlaylistManager) GetDASHManifest(now time.Time) ([]byte, error) {
+	mgr.mapSync.RLock()
+	defer mgr.mapSync.RUnlock()
+
+	// one adaptation set per container, in the order of the renditions
+	var sets []dashAdaptationSet
+	var profiles []string
+	var target, depth float64
+	for _, v := range mgr.masterPList.Variants {
+		if v == nil || v.Chunklist == nil {
+			continue
+		}
+		rep, mimeType, window, ok := mgr.dashRepresentation(v)
+		if !ok {
+			continue
+		}
+		i := 0
+		for ; i < len(sets) && sets[i].MimeType != mimeType; i++ {
+		}
+		if i == len(sets) {
+			sets = append(sets, dashAdaptationSet{MimeType: mimeType, SegmentAlignment: true})
+			profile := dashProfileMP2T
+			if mimeType != "video/mp2t" {
+				profile = dashProfileISO
+			}
+			profiles = append(profiles, profile)
+		}
+		sets[i].Representations = append(sets[i].Representations, rep)
+		if v.Chunklist.TargetDuration > target {
+			target = v.Chunklist.TargetDuration
+		}
+		if window > depth {
+			depth = window
+		}
+	}
+	if len(sets) == 0 {
+		return nil, ErrNoDASHRenditions
+	}
+	mpd := dashMPD{
+		Profiles:              strings.Join(profiles, ","),
+		Type:                  "dynamic",
+		AvailabilityStartTime: mgr.created.UTC().Format(time.RFC3339),
+		PublishTime:           now.UTC().Format(time.RFC3339),
+		MinimumUpdatePeriod:   dashDuration(target),
+		MinBufferTime:         dashDuration(2 * target),
+		TimeShiftBufferDepth:  dashDuration(depth),
+		Period:                dashPeriod{ID: "0", Start: "PT0S", AdaptationSets: sets},
+	}
+	out, err := xml.MarshalIndent(mpd, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
+
+// dashRepresentation returns the representation of the variant `v`, its MIME
+// type and the duration of its segments, if it is an MPEG-TS or a CMAF
+// rendition with segments. The segments of CMAF renditions are listed from
+// the last change of their initialization segment. Must be called with
+// mapSync held.
+func (mgr *BasicPlaylistManager) dashRepresentation(v *m3u8.Variant) (dashRepresentation, string, float64, bool) {
+	name := strings.TrimSuffix(path.Base(v.URI), ".m3u8")
+	rep := dashRepresentation{
+		ID:        name,
+		Bandwidth: v.Bandwidth,
+	}
+	fmt.Sscanf(v.Resolution, "%dx%d", &rep.Width, &rep.Height)
+	mimeType := ""
+	var list dashSegmentList
+	var window float64
+	for _, seg := range v.Chunklist.Segments {
+		if seg == nil {
+			continue
+		}
+		segType := ""
+		switch ext := path.Ext(strings.SplitN(seg.URI, "?", 2)[0]); {
+		case ext == ".ts" && seg.Map == nil:
+			segType = "video/mp2t"
+		case ext == CMAFSegmentExt && seg.Map != nil:
+			segType = "video/mp4"
+		}
+		if segType == "" || mimeType != "" && segType != mimeType {
+			return rep, "", 0, false
+		}
+		mimeType = segType
+		start, ok := mgr.segmentStarts[seg.SeqId]
+		if !ok {
+			continue
+		}
+		if seg.Map != nil && (list.Initialization == nil || list.Initialization.SourceURL != seg.Map.URI) {
+			list, window = dashSegmentList{Initialization: &dashInit{SourceURL: seg.Map.URI}}, 0
+		}
+		if len(list.URLs) == 0 {
+			list.StartNumber = seg.SeqId
+		}
+		list.Timeline = append(list.Timeline, dashTimelineS{
+			T: uint64(math.Round(start * dashTimescale)),
+			D: uint64(math.Round(seg.Duration * dashTimescale)),
+		})
+		list.URLs = append(list.URLs, dashSegmentURL{Media: seg.URI})
+		window += seg.Duration
+	}
+	list.Timescale = dashTimescale
+	rep.SegmentList = list
+	return rep, mimeType, window, len(list.URLs) > 0
+}
+
+// timeSegment gives the segment `seqNo` of duration `duration` seconds its
+// start time in the stream, shared by all its renditions. Must be called with
+// mapSync held.
+func (mgr *BasicPlaylistManager) timeSegment(seqNo uint64, duration float64) {
+	if _, ok := mgr.segmentStarts[seqNo]; ok {
+		return
+	}
+	mgr.segmentStarts[seqNo] = mgr.nextSegmentStart
+	mgr.nextSegmentStart += duration
+	// keep the start times of the segments of the live playlists
+	for seq := range mgr.segmentStarts {
+		if seq+4*uint64(LIVE_LIST_LENGTH) < seqNo {
+			delete(mgr.segmentStarts, seq)
+		}
+	}
+}
+
+// dashDuration formats `secs` as an xs:duration
+func dashDuration(secs float64) string {
+	return fmt.Sprintf("PT%.3fS", secs)
+}
@@ -0,0 +1,78 @@
+package core
+
+import (
+	"encoding/xml"
+	"fmt"
+	"testing"
+	"time"
+
+	ffmpeg "github.com/livepeer/lpms/ffmpeg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDASHManifest(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	c := NewBasicPlaylistManager("mani", nil, nil)
+	now := time.Unix(1600000000, 0)
+	_, err := c.GetDASHManifest(now)
+	assert.Equal(ErrNoDASHRenditions, err)
+
+	source := ffmpeg.P720p30fps16x9
+	source.Name = "source"
+	for i := 1; i <= 8; i++ {
+		require.Nil(c.InsertHLSSegment(&source, uint64(i), fmt.Sprintf("/stream/mani/source/%d.ts", i), 2.5))
+	}
+	// a rendition missing a segment stays aligned with the source
+	rendition := ffmpeg.P144p30fps16x9
+	for _, i := range []int{7, 8} {
+		require.Nil(c.InsertHLSSegment(&rendition, uint64(i), fmt.Sprintf("/stream/mani/%s/%d.ts", rendition.Name, i), 2.5))
+	}
+	// other formats can't be played
+	mp4 := ffmpeg.P240p30fps16x9
+	require.Nil(c.InsertHLSSegment(&mp4, 8, "/stream/mani/P240p30fps16x9/8.mp4", 2.5))
+	// CMAF renditions are listed from their last initialization segment
+	cmaf := ffmpeg.P360p30fps16x9
+	require.Nil(c.InsertCMAFSegment(&cmaf, 7, "/stream/mani/P360p30fps16x9/7.m4s", "/stream/mani/P360p30fps16x9/init/init_1.mp4", 2.5))
+	require.Nil(c.InsertCMAFSegment(&cmaf, 8, "/stream/mani/P360p30fps16x9/8.m4s", "/stream/mani/P360p30fps16x9/init/init_2.mp4", 2.5))
+
+	out, err := c.GetDASHManifest(now)
+	require.Nil(err)
+	var mpd dashMPD
+	require.Nil(xml.Unmarshal(out, &mpd))
+	assert.Equal(dashProfileMP2T+","+dashProfileISO, mpd.Profiles)
+	assert.Equal("dynamic", mpd.Type)
+	assert.Equal("2020-09-13T12:26:40Z", mpd.PublishTime)
+	assert.Equal("PT15.000S", mpd.TimeShiftBufferDepth)
+	require.Len(mpd.Period.AdaptationSets, 2)
+	set := mpd.Period.AdaptationSets[0]
+	assert.Equal("video/mp2t", set.MimeType)
+	require.Len(set.Representations, 2)
+
+	src := set.Representations[0]
+	assert.Equal("source", src.ID)
+	assert.Equal(1280, src.Width)
+	assert.Equal(720, src.Height)
+	// the live playlist holds the last segments
+	assert.Equal(uint64(3), src.SegmentList.StartNumber)
+	assert.Nil(src.SegmentList.Initialization)
+	require.Len(src.SegmentList.Timeline, 6)
+	assert.Equal(dashTimelineS{T: 5000, D: 2500}, src.SegmentList.Timeline[0])
+	assert.Equal(dashSegmentURL{Media: "/stream/mani/source/3.ts"}, src.SegmentList.URLs[0])
+
+	rend := set.Representations[1]
+	assert.Equal(rendition.Name, rend.ID)
+	assert.Equal(uint64(7), rend.SegmentList.StartNumber)
+	assert.Equal([]dashTimelineS{{T: 15000, D: 2500}, {T: 17500, D: 2500}}, rend.SegmentList.Timeline)
+
+	set = mpd.Period.AdaptationSets[1]
+	assert.Equal("video/mp4", set.MimeType)
+	require.Len(set.Representations, 1)
+	list := set.Representations[0].SegmentList
+	assert.Equal(uint64(8), list.StartNumber)
+	assert.Equal(&dashInit{SourceURL: "/stream/mani/P360p30fps16x9/init/init_2.mp4"}, list.Initialization)
+	assert.Equal([]dashTimelineS{{T: 17500, D: 2500}}, list.Timeline)
+	assert.Equal([]dashSegmentURL{{Media: "/stream/mani/P360p30fps16x9/8.m4s"}}, list.URLs)
+}
@@ -0,0 +1,102 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/livepeer/go-livepeer/net"
+	"github.com/livepeer/lpms/ffmpeg"
+)
+
+// EncoderSettings are the settings an encoder was configured with to produce
+// a rendition, for checking that the requested profile was honored
+type EncoderSettings struct {
+	Codec       string `json:"codec,omitempty"`
+	Profile     string `json:"profile,omitempty"`
+	Preset      string `json:"preset,omitempty"`
+	BitrateMode string `json:"bitrate_mode,omitempty"`
+	Bitrate     string `json:"bitrate,omitempty"`
+	Resolution  string `json:"resolution,omitempty"`
+	Framerate   string `json:"framerate,omitempty"`
+	GOP         string `json:"gop,omitempty"`
+}
+
+// encoderCodecs are the encoders lpms transcodes H.264 with
+var encoderCodecs = map[ffmpeg.Acceleration]string{
+	ffmpeg.Software: "libx264",
+	ffmpeg.Nvidia:   "h264_nvenc",
+}
+
+var encoderProfiles = map[ffmpeg.Profile]string{
+	ffmpeg.ProfileH264Baseline:        "baseline",
+	ffmpeg.ProfileH264Main:            "main",
+	ffmpeg.ProfileH264High:            "high",
+	ffmpeg.ProfileH264ConstrainedHigh: "constrained_high",
+}
+
+// encoderSettings returns the settings lpms configures the encoder with to
+// transcode into `opts`. lpms sets no preset, so encoders run with their
+// default one, and pins the rate of profiles with a bitrate to it.
+func encoderSettings(opts ffmpeg.TranscodeOptions) *EncoderSettings {
+	p := opts.Profile
+	s := &EncoderSettings{
+		Codec:      encoderCodecs[opts.Accel],
+		Profile:    encoderProfiles[p.Profile],
+		Preset:     "medium",
+		Bitrate:    p.Bitrate,
+		Resolution: p.Resolution,
+	}
+	switch {
+	case p.Bitrate != "":
+		s.BitrateMode = "cbr"
+	case opts.Accel == ffmpeg.Software:
+		s.BitrateMode = "crf"
+	}
+	if p.Framerate > 0 {
+		s.Framerate = fmt.Sprint(p.Framerate)
+		if p.FramerateDen > 1 {
+			s.Framerate += fmt.Sprintf("/%d", p.FramerateDen)
+		}
+	}
+	switch {
+	case p.GOP == ffmpeg.GOPIntraOnly:
+		s.GOP = "intra"
+	case p.GOP > 0:
+		s.GOP = p.GOP.String()
+	}
+	return s
+}
+
+// NetEncoderSettings converts `s` for a TranscodedSegmentData message
+func NetEncoderSettings(s *EncoderSettings) *net.TranscodedSegmentData_EncoderSettings {
+	if s == nil {
+		return nil
+	}
+	return &net.TranscodedSegmentData_EncoderSettings{
+		Codec:       s.Codec,
+		Profile:     s.Profile,
+		Preset:      s.Preset,
+		BitrateMode: s.BitrateMode,
+		Bitrate:     s.Bitrate,
+		Resolution:  s.Resolution,
+		Framerate:   s.Framerate,
+		Gop:         s.GOP,
+	}
+}
+
+// EncoderSettingsFromNet converts the settings of a TranscodedSegmentData
+// message; nil if the orchestrator didn't send any
+func EncoderSettingsFromNet(s *net.TranscodedSegmentData_EncoderSettings) *EncoderSettings {
+	if s == nil {
+		return nil
+	}
+	return &EncoderSettings{
+		Codec:       s.Codec,
+		Profile:     s.Profile,
+		Preset:      s.Preset,
+		BitrateMode: s.BitrateMode,
+		Bitrate:     s.Bitrate,
+		Resolution:  s.Resolution,
+		Framerate:   s.Framerate,
+		GOP:         s.Gop,
+	}
+}
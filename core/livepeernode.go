@@ -15,6 +15,7 @@ import (
 	"math/big"
 	"math/rand"
 	"net/url"
+	"reflect"
 	"sync"
 	"time"
 
@@ -72,6 +73,7 @@ type LivepeerNode struct {
 	// Transcoder private fields
 	priceInfo    *big.Rat
 	serviceURI   url.URL
+	disabledCaps []Capability
 	segmentMutex *sync.RWMutex
 }
 
@@ -112,3 +114,26 @@ func (n *LivepeerNode) GetBasePrice() *big.Rat {
 	defer n.mu.RUnlock()
 	return n.priceInfo
 }
+
+// EffectiveCapabilities gets the capabilities an orchestrator advertises,
+// which are its Capabilities without the disabled ones
+func (n *LivepeerNode) EffectiveCapabilities() *Capabilities {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if len(n.disabledCaps) == 0 {
+		return n.Capabilities
+	}
+	return n.Capabilities.Without(n.disabledCaps)
+}
+
+// SetDisabledCapabilities stops the orchestrator from advertising and
+// requiring `caps`, replacing the previously disabled ones. It returns
+// whether the capabilities changed.
+func (n *LivepeerNode) SetDisabledCapabilities(caps []Capability) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	before := n.Capabilities.Without(n.disabledCaps).ToNetCapabilities()
+	n.disabledCaps = caps
+	after := n.Capabilities.Without(n.disabledCaps).ToNetCapabilities()
+	return !reflect.DeepEqual(before, after)
+}
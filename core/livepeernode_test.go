@@ -164,3 +164,24 @@ func TestSetAndGetBasePrice(t *testing.T) {
 	assert.Zero(n.priceInfo.Cmp(price))
 	assert.Zero(n.GetBasePrice().Cmp(price))
 }
+
+func TestSetDisabledCapabilities(t *testing.T) {
+	assert := assert.New(t)
+
+	n, err := NewLivepeerNode(nil, "", nil)
+	require.Nil(t, err)
+	assert.Nil(n.EffectiveCapabilities())
+	assert.False(n.SetDisabledCapabilities([]Capability{Capability_GOP}))
+
+	caps := NewCapabilities([]Capability{Capability_H264, Capability_GOP}, nil)
+	n.Capabilities = caps
+	assert.Equal([]Capability{Capability_GOP}, NewCapabilities([]Capability{Capability_GOP}, nil).Missing(n.EffectiveCapabilities().ToNetCapabilities()))
+
+	// only actual changes are reported
+	assert.False(n.SetDisabledCapabilities([]Capability{Capability_GOP}))
+	assert.True(n.SetDisabledCapabilities(nil))
+	assert.Equal(caps, n.EffectiveCapabilities())
+	assert.False(n.SetDisabledCapabilities([]Capability{Capability_MP4}))
+	assert.True(n.SetDisabledCapabilities([]Capability{Capability_GOP, Capability_MP4}))
+	assert.Nil(NewCapabilities([]Capability{Capability_H264}, nil).Missing(n.EffectiveCapabilities().ToNetCapabilities()))
+}
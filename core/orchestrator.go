@@ -322,7 +322,7 @@ func (orch *orchestrator) Capabilities() *net.Capabilities {
 	if orch.node == nil {
 		return nil
 	}
-	return orch.node.Capabilities.ToNetCapabilities()
+	return orch.node.EffectiveCapabilities().ToNetCapabilities()
 }
 
 func (orch *orchestrator) AuthToken(sessionID string, expiration int64) *net.AuthToken {
@@ -383,8 +383,9 @@ type TranscodeData struct {
 
 // TranscodedSegmentData contains encoded data for a profile
 type TranscodedSegmentData struct {
-	Data   []byte
-	Pixels int64 // Encoded pixels
+	Data    []byte
+	Pixels  int64 // Encoded pixels
+	Encoder *EncoderSettings
 }
 
 type SegChanData struct {
@@ -555,6 +556,11 @@ func (n *LivepeerNode) transcodeSeg(config transcodeConfig, seg *stream.HLSSegme
 
 	took := time.Since(start)
 	glog.V(common.DEBUG).Infof("Transcoding of segment manifestID=%s sessionID=%s seqNo=%d took=%v", string(md.ManifestID), md.AuthToken.SessionId, seg.SeqNo, took)
+	if len(md.Metadata) > 0 {
+		// Record of the metadata each segment was transcoded with, such as
+		// viewer or epoch IDs. The metadata is not applied to the video.
+		glog.Infof("Transcoded segment with metadata manifestID=%s sessionID=%s seqNo=%d metadata=%v", string(md.ManifestID), md.AuthToken.SessionId, seg.SeqNo, md.Metadata)
+	}
 	if monitor.Enabled {
 		monitor.SegmentTranscoded(0, seg.SeqNo, md.Duration, took, common.ProfilesNames(md.Profiles))
 	}
@@ -571,7 +577,7 @@ func (n *LivepeerNode) transcodeSeg(config transcodeConfig, seg *stream.HLSSegme
 		}
 		glog.V(common.DEBUG).Infof("Transcoded segment manifestID=%s sessionID=%s seqNo=%d profile=%s len=%d",
 			string(md.ManifestID), md.AuthToken.SessionId, seg.SeqNo, md.Profiles[i].Name, len(tSegments[i].Data))
-		hash := crypto.Keccak256(tSegments[i].Data)
+		hash := common.Keccak256(tSegments[i].Data)
 		segHashes[i] = hash
 	}
 	os.Remove(fname)
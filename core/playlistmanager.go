@@ -1,6 +1,7 @@
 package core
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"sort"
@@ -18,6 +19,12 @@ import (
 
 const LIVE_LIST_LENGTH uint = 6
 
+// BlockingPlaylistReload makes live media playlists advertise that requests
+// for them may ask to be held until a given segment is in the playlist, as
+// in the blocking playlist reloads of Low-Latency HLS. Partial segments and
+// preload hints, the rest of Low-Latency HLS, are not served.
+var BlockingPlaylistReload bool
+
 const jsonPlaylistRotationInterval = 60 * 60 * 1000 // 1 hour (in ms)
 
 //	PlaylistManager manages playlists and data for one video stream, backed by one object storage.
@@ -27,18 +34,40 @@ type PlaylistManager interface {
 	// Inserts in media playlist given a link to a segment
 	InsertHLSSegment(profile *ffmpeg.VideoProfile, seqNo uint64, uri string, duration float64) error
 
-	InsertHLSSegmentJSON(profile *ffmpeg.VideoProfile, seqNo uint64, uri string, duration float64)
+	// InsertCMAFSegment inserts a CMAF media segment, played after the
+	// initialization segment at `initURI`
+	InsertCMAFSegment(profile *ffmpeg.VideoProfile, seqNo uint64, uri, initURI string, duration float64) error
+
+	// InsertHLSSegmentJSON inserts a segment into the recording playlist,
+	// along with the settings it was encoded with if known
+	InsertHLSSegmentJSON(profile *ffmpeg.VideoProfile, seqNo uint64, uri string, duration float64, encoder *EncoderSettings)
 
 	GetHLSMasterPlaylist() *m3u8.MasterPlaylist
 
 	GetHLSMediaPlaylist(rendition string) *m3u8.MediaPlaylist
 
+	// SegmentInserted returns a channel that is closed once a segment is
+	// next inserted into a media playlist
+	SegmentInserted() <-chan struct{}
+
+	// GetDASHManifest returns an MPEG-DASH manifest of the live playlists
+	GetDASHManifest(now time.Time) ([]byte, error)
+
 	GetOSSession() drivers.OSSession
 
 	GetRecordOSSession() drivers.OSSession
 
 	FlushRecord()
 
+	// MarkDiscontinuity marks the segments with `seqNo` as following a
+	// discontinuity, such as a reconnect of the publisher
+	MarkDiscontinuity(seqNo uint64)
+
+	// GetRecording returns the segments saved to the record store so far, as
+	// far back as the recording history goes; nil if the stream is not
+	// recorded
+	GetRecording() *JsonPlaylist
+
 	Cleanup()
 }
 
@@ -52,13 +81,36 @@ type BasicPlaylistManager struct {
 	mapSync      *sync.RWMutex
 	jsonList     *JsonPlaylist
 	jsonListSync *sync.Mutex
+	// Recording playlist saves are batched over this interval, or written on
+	// every flush if zero
+	recordFlushInterval time.Duration
+	recordFlushTimer    *time.Timer
+	// Only one recording playlist save is in flight at a time; flushes in
+	// the meantime are coalesced into one save once it completes
+	recordSaving bool
+	recordDirty  bool
+	// seqNos of segments that follow a discontinuity; protected by mapSync
+	discontinuities map[uint64]bool
+	// closed and replaced on every segment insertion; protected by mapSync
+	inserted chan struct{}
+	// start times in seconds of the live segments by seqNo, for the DASH
+	// manifest; protected by mapSync
+	created          time.Time
+	segmentStarts    map[uint64]float64
+	nextSegmentStart float64
+	// JSON playlists rotated out of jsonList, oldest first, kept for
+	// GetRecording while they are within recordHistory of the live edge
+	recordHistory     time.Duration
+	recordedPlaylists []*JsonPlaylist
 }
 
 type jsonSeg struct {
 	SeqNo         uint64 `json:"seq_no,omitempty"`
 	URI           string `json:"uri,omitempty"`
 	DurationMs    uint64 `json:"duration_ms,omitempty"`
-	discontinuity bool
+	Discontinuity bool   `json:"discontinuity,omitempty"`
+	// Settings the orchestrator encoded the segment with
+	Encoder *EncoderSettings `json:"encoder,omitempty"`
 }
 
 type JsonPlaylist struct {
@@ -113,7 +165,7 @@ func (jpl *JsonPlaylist) AddSegmentsToMPL(manifestIDs []string, trackName string
 		mseg := &m3u8.MediaSegment{
 			URI:           uri,
 			Duration:      float64(seg.DurationMs) / 1000.0,
-			Discontinuity: seg.discontinuity,
+			Discontinuity: seg.Discontinuity,
 		}
 		mpl.InsertSegment(seg.SeqNo, mseg)
 	}
@@ -140,7 +192,7 @@ func (jpl *JsonPlaylist) AddDiscontinuedTrack(ajpl *JsonPlaylist, trackName stri
 	}
 	for i, seg := range ajpl.Segments[trackName] {
 		if i == 0 {
-			seg.discontinuity = disc
+			seg.Discontinuity = disc
 		}
 		seg.SeqNo += lastSeq
 		curSegs = append(curSegs, seg)
@@ -186,7 +238,7 @@ func (jpl *JsonPlaylist) AddTrack(ajpl *JsonPlaylist, trackName string) {
 }
 
 func (jpl *JsonPlaylist) InsertHLSSegment(profile *ffmpeg.VideoProfile, seqNo uint64, uri string,
-	duration float64) {
+	duration float64, encoder *EncoderSettings) {
 
 	durationMs := uint64(duration * 1000)
 	if profile.Name == "source" {
@@ -204,6 +256,7 @@ func (jpl *JsonPlaylist) InsertHLSSegment(profile *ffmpeg.VideoProfile, seqNo ui
 		URI:        uri,
 		DurationMs: durationMs,
 		SeqNo:      seqNo,
+		Encoder:    encoder,
 	})
 }
 
@@ -218,6 +271,11 @@ func NewBasicPlaylistManager(manifestID ManifestID,
 		masterPList:    m3u8.NewMasterPlaylist(),
 		mediaLists:     make(map[string]*m3u8.MediaPlaylist),
 		mapSync:        &sync.RWMutex{},
+
+		discontinuities: make(map[uint64]bool),
+		inserted:        make(chan struct{}),
+		created:         time.Now(),
+		segmentStarts:   make(map[uint64]float64),
 	}
 	if recordSession != nil {
 		bplm.jsonList = NewJSONPlaylist()
@@ -231,9 +289,41 @@ func (mgr *BasicPlaylistManager) ManifestID() ManifestID {
 }
 
 func (mgr *BasicPlaylistManager) Cleanup() {
+	if mgr.recordSession != nil {
+		// Save any batched recording playlist updates
+		mgr.jsonListSync.Lock()
+		if mgr.recordFlushTimer != nil && mgr.recordFlushTimer.Stop() {
+			mgr.recordFlushTimer = nil
+			mgr.saveRecord()
+		}
+		mgr.jsonListSync.Unlock()
+	}
 	mgr.storageSession.EndSession()
 }
 
+// SetRecordFlushInterval batches recording playlist saves so that the
+// playlist is written at most once per interval rather than on every flush
+func (mgr *BasicPlaylistManager) SetRecordFlushInterval(interval time.Duration) {
+	if mgr.recordSession == nil {
+		return
+	}
+	mgr.jsonListSync.Lock()
+	mgr.recordFlushInterval = interval
+	mgr.jsonListSync.Unlock()
+}
+
+// SetRecordHistory keeps the recording playlists covering the last `history`
+// of the stream in memory for GetRecording, rather than only the one being
+// written to
+func (mgr *BasicPlaylistManager) SetRecordHistory(history time.Duration) {
+	if mgr.recordSession == nil {
+		return
+	}
+	mgr.jsonListSync.Lock()
+	mgr.recordHistory = history
+	mgr.jsonListSync.Unlock()
+}
+
 func (mgr *BasicPlaylistManager) GetOSSession() drivers.OSSession {
 	return mgr.storageSession
 }
@@ -246,30 +336,104 @@ func (mgr *BasicPlaylistManager) FlushRecord() {
 	if mgr.recordSession != nil {
 		mgr.jsonListSync.Lock()
 		defer mgr.jsonListSync.Unlock()
-		b, err := json.Marshal(mgr.jsonList)
-		if err != nil {
-			glog.Error("Error encoding playlist: ", err)
+		if mgr.recordFlushInterval <= 0 {
+			mgr.saveRecord()
 			return
 		}
-		go func(name string, data []byte) {
-			now := time.Now()
-			_, err := mgr.recordSession.SaveData(name, b, nil)
-			took := time.Since(now)
-			if err != nil {
-				glog.Errorf("Error saving json playlist name=%s bytes=%d took=%s err=%v", name,
-					len(b), took, err)
-			} else {
-				glog.V(common.VERBOSE).Infof("Saving json playlist name=%s bytes=%d took=%s err=%v", name,
-					len(b), took, err)
-			}
-			if monitor.Enabled {
-				monitor.RecordingPlaylistSaved(took, err)
-			}
-		}(mgr.jsonList.name, b)
-		if mgr.jsonList.DurationMs > jsonPlaylistRotationInterval {
-			mgr.jsonList = NewJSONPlaylist()
+		if mgr.recordFlushTimer == nil {
+			mgr.recordFlushTimer = time.AfterFunc(mgr.recordFlushInterval, func() {
+				mgr.jsonListSync.Lock()
+				defer mgr.jsonListSync.Unlock()
+				mgr.recordFlushTimer = nil
+				mgr.saveRecord()
+			})
+		}
+	}
+}
+
+// saveRecord writes the JSON playlist to the record store, or marks it to be
+// written once the save in flight completes. Must be called with jsonListSync
+// held.
+func (mgr *BasicPlaylistManager) saveRecord() {
+	if mgr.recordSaving {
+		mgr.recordDirty = true
+		return
+	}
+	b, err := json.Marshal(mgr.jsonList)
+	if err != nil {
+		glog.Error("Error encoding playlist: ", err)
+		return
+	}
+	mgr.recordSaving = true
+	go func(name string, data []byte) {
+		now := time.Now()
+		_, err := mgr.recordSession.SaveData(name, data, nil)
+		took := time.Since(now)
+		if err != nil {
+			glog.Errorf("Error saving json playlist name=%s bytes=%d took=%s err=%v", name,
+				len(data), took, err)
+		} else {
+			glog.V(common.VERBOSE).Infof("Saving json playlist name=%s bytes=%d took=%s err=%v", name,
+				len(data), took, err)
+		}
+		if monitor.Enabled {
+			monitor.RecordingPlaylistSaved(took, err)
+		}
+		mgr.jsonListSync.Lock()
+		defer mgr.jsonListSync.Unlock()
+		mgr.recordSaving = false
+		if mgr.recordDirty {
+			mgr.recordDirty = false
+			mgr.saveRecord()
+		}
+	}(mgr.jsonList.name, b)
+	if mgr.jsonList.DurationMs > jsonPlaylistRotationInterval {
+		mgr.keepRecorded(mgr.jsonList)
+		mgr.jsonList = NewJSONPlaylist()
+	}
+}
+
+// keepRecorded adds a playlist rotated out of jsonList to the recording
+// history, dropping the playlists that fell out of it. Must be called with
+// jsonListSync held.
+func (mgr *BasicPlaylistManager) keepRecorded(jpl *JsonPlaylist) {
+	if mgr.recordHistory <= 0 {
+		return
+	}
+	mgr.recordedPlaylists = append(mgr.recordedPlaylists, jpl)
+	historyMs := uint64(mgr.recordHistory / time.Millisecond)
+	var total uint64
+	for _, jpl := range mgr.recordedPlaylists {
+		total += jpl.DurationMs
+	}
+	for len(mgr.recordedPlaylists) > 0 && total-mgr.recordedPlaylists[0].DurationMs >= historyMs {
+		total -= mgr.recordedPlaylists[0].DurationMs
+		mgr.recordedPlaylists[0] = nil
+		mgr.recordedPlaylists = mgr.recordedPlaylists[1:]
+	}
+}
+
+// GetRecording returns a copy of the recording playlists of the stream
+// joined together
+func (mgr *BasicPlaylistManager) GetRecording() *JsonPlaylist {
+	if mgr.recordSession == nil {
+		return nil
+	}
+	mgr.jsonListSync.Lock()
+	defer mgr.jsonListSync.Unlock()
+	rec := NewJSONPlaylist()
+	add := func(jpl *JsonPlaylist) {
+		rec.AddMaster(jpl)
+		for _, track := range jpl.Tracks {
+			rec.AddTrack(jpl, track.Name)
 		}
+		rec.DurationMs += jpl.DurationMs
+	}
+	for _, jpl := range mgr.recordedPlaylists {
+		add(jpl)
 	}
+	add(mgr.jsonList)
+	return rec
 }
 
 func (mgr *BasicPlaylistManager) getPL(rendition string) *m3u8.MediaPlaylist {
@@ -290,6 +454,9 @@ func (mgr *BasicPlaylistManager) getOrCreatePL(profile *ffmpeg.VideoProfile) (*m
 		glog.Error(err)
 		return nil, err
 	}
+	if BlockingPlaylistReload {
+		mpl.SetCustomTag(serverControlTag{})
+	}
 	mgr.mediaLists[profile.Name] = mpl
 	vParams := ffmpeg.VideoProfileToVariantParams(*profile)
 	url := fmt.Sprintf("%v/%v.m3u8", mgr.manifestID, profile.Name)
@@ -298,11 +465,15 @@ func (mgr *BasicPlaylistManager) getOrCreatePL(profile *ffmpeg.VideoProfile) (*m
 }
 
 func (mgr *BasicPlaylistManager) InsertHLSSegmentJSON(profile *ffmpeg.VideoProfile, seqNo uint64, uri string,
-	duration float64) {
+	duration float64, encoder *EncoderSettings) {
 
 	if mgr.jsonList != nil {
 		mgr.jsonListSync.Lock()
-		mgr.jsonList.InsertHLSSegment(profile, seqNo, uri, duration)
+		mgr.jsonList.InsertHLSSegment(profile, seqNo, uri, duration, encoder)
+		if mgr.isDiscontinuity(seqNo) {
+			segs := mgr.jsonList.Segments[profile.Name]
+			segs[len(segs)-1].Discontinuity = true
+		}
 		mgr.jsonListSync.Unlock()
 	}
 }
@@ -310,11 +481,27 @@ func (mgr *BasicPlaylistManager) InsertHLSSegmentJSON(profile *ffmpeg.VideoProfi
 func (mgr *BasicPlaylistManager) InsertHLSSegment(profile *ffmpeg.VideoProfile, seqNo uint64, uri string,
 	duration float64) error {
 
+	return mgr.insertSegment(profile, seqNo, newMediaSegment(uri, duration))
+}
+
+func (mgr *BasicPlaylistManager) InsertCMAFSegment(profile *ffmpeg.VideoProfile, seqNo uint64, uri, initURI string,
+	duration float64) error {
+
+	mseg := newMediaSegment(uri, duration)
+	mseg.Map = &m3u8.Map{URI: initURI}
+	return mgr.insertSegment(profile, seqNo, mseg)
+}
+
+func (mgr *BasicPlaylistManager) insertSegment(profile *ffmpeg.VideoProfile, seqNo uint64, mseg *m3u8.MediaSegment) error {
 	mpl, err := mgr.getOrCreatePL(profile)
 	if err != nil {
 		return err
 	}
-	mseg := newMediaSegment(uri, duration)
+	// EXT-X-MAP needs version 6 outside of I-frame playlists
+	if mseg.Map != nil && mpl.Version() < 6 {
+		mpl.SetVersion(6)
+	}
+	mseg.Discontinuity = mgr.isDiscontinuity(seqNo)
 	if mpl.Count() >= mpl.WinSize() {
 		mpl.Remove()
 	}
@@ -322,7 +509,35 @@ func (mgr *BasicPlaylistManager) InsertHLSSegment(profile *ffmpeg.VideoProfile,
 		mpl.SeqNo = mseg.SeqId
 	}
 
-	return mpl.InsertSegment(seqNo, mseg)
+	if err := mpl.InsertSegment(seqNo, mseg); err != nil {
+		return err
+	}
+	mgr.mapSync.Lock()
+	mgr.timeSegment(seqNo, mseg.Duration)
+	close(mgr.inserted)
+	mgr.inserted = make(chan struct{})
+	mgr.mapSync.Unlock()
+	return nil
+}
+
+// SegmentInserted returns a channel that is closed once a segment is next
+// inserted into a media playlist
+func (mgr *BasicPlaylistManager) SegmentInserted() <-chan struct{} {
+	mgr.mapSync.RLock()
+	defer mgr.mapSync.RUnlock()
+	return mgr.inserted
+}
+
+func (mgr *BasicPlaylistManager) MarkDiscontinuity(seqNo uint64) {
+	mgr.mapSync.Lock()
+	defer mgr.mapSync.Unlock()
+	mgr.discontinuities[seqNo] = true
+}
+
+func (mgr *BasicPlaylistManager) isDiscontinuity(seqNo uint64) bool {
+	mgr.mapSync.RLock()
+	defer mgr.mapSync.RUnlock()
+	return mgr.discontinuities[seqNo]
 }
 
 // GetHLSMasterPlaylist ..
@@ -335,6 +550,21 @@ func (mgr *BasicPlaylistManager) GetHLSMediaPlaylist(rendition string) *m3u8.Med
 	return mgr.getPL(rendition)
 }
 
+// serverControlTag is the EXT-X-SERVER-CONTROL tag of live media playlists
+type serverControlTag struct{}
+
+func (serverControlTag) TagName() string {
+	return "#EXT-X-SERVER-CONTROL"
+}
+
+func (t serverControlTag) Encode() *bytes.Buffer {
+	return bytes.NewBufferString(t.String())
+}
+
+func (serverControlTag) String() string {
+	return "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES"
+}
+
 func newMediaSegment(uri string, duration float64) *m3u8.MediaSegment {
 	return &m3u8.MediaSegment{
 		URI:      uri,
@@ -3,6 +3,8 @@ package core
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/url"
 	"testing"
@@ -19,16 +21,16 @@ func TestJSONList1(t *testing.T) {
 	jspl1 := NewJSONPlaylist()
 	vProfile := ffmpeg.P144p30fps16x9
 	vProfile.Name = "source"
-	jspl1.InsertHLSSegment(&vProfile, 1, "manifestID/test_seg/1.ts", 2.1)
-	jspl1.InsertHLSSegment(&vProfile, 3, "manifestID/test_seg/3.ts", 2.5)
-	jspl1.InsertHLSSegment(&vProfile, 4, "manifestID/test_seg/4.ts", 2.5)
+	jspl1.InsertHLSSegment(&vProfile, 1, "manifestID/test_seg/1.ts", 2.1, nil)
+	jspl1.InsertHLSSegment(&vProfile, 3, "manifestID/test_seg/3.ts", 2.5, nil)
+	jspl1.InsertHLSSegment(&vProfile, 4, "manifestID/test_seg/4.ts", 2.5, nil)
 	assert.Len(jspl1.Segments, 1)
 	assert.Len(jspl1.Segments["source"], 3)
 	assert.Equal(uint64(2100+2500+2500), jspl1.DurationMs)
 
 	jspl2 := NewJSONPlaylist()
-	jspl2.InsertHLSSegment(&vProfile, 2, "manifestID/test_seg/2.ts", 2)
-	jspl2.InsertHLSSegment(&vProfile, 4, "manifestID/test_seg/4.ts", 2)
+	jspl2.InsertHLSSegment(&vProfile, 2, "manifestID/test_seg/2.ts", 2, nil)
+	jspl2.InsertHLSSegment(&vProfile, 4, "manifestID/test_seg/4.ts", 2, nil)
 	assert.Len(jspl2.Segments, 1)
 	assert.Len(jspl2.Tracks, 1)
 	assert.Len(jspl2.Segments["source"], 2)
@@ -37,8 +39,8 @@ func TestJSONList1(t *testing.T) {
 	vProfile = ffmpeg.P144p30fps16x9
 	vProfile.Name = "trans1"
 	jspl3 := NewJSONPlaylist()
-	jspl3.InsertHLSSegment(&vProfile, 1, "manifestID/test_seg/1.ts", 2)
-	jspl3.InsertHLSSegment(&vProfile, 4, "manifestID/test_seg/4.ts", 2)
+	jspl3.InsertHLSSegment(&vProfile, 1, "manifestID/test_seg/1.ts", 2, nil)
+	jspl3.InsertHLSSegment(&vProfile, 4, "manifestID/test_seg/4.ts", 2, nil)
 	assert.Len(jspl3.Segments, 1)
 	assert.Len(jspl3.Tracks, 1)
 	assert.Len(jspl3.Segments["trans1"], 2)
@@ -76,28 +78,52 @@ func TestJSONList1(t *testing.T) {
 	assert.Equal("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-TARGETDURATION:3\n#EXTINF:2.100,\ntest_seg/1.ts\n#EXTINF:2.000,\ntest_seg/2.ts\n#EXTINF:2.500,\ntest_seg/3.ts\n#EXTINF:2.500,\ntest_seg/4.ts\n#EXT-X-ENDLIST\n", mpls)
 }
 
+func TestJSONList_Encoder(t *testing.T) {
+	assert := assert.New(t)
+	jspl := NewJSONPlaylist()
+	vProfile := ffmpeg.P144p30fps16x9
+	jspl.InsertHLSSegment(&vProfile, 1, "manifestID/test_seg/1.ts", 2, &EncoderSettings{Codec: "libx264", BitrateMode: "cbr"})
+	jspl.InsertHLSSegment(&vProfile, 2, "manifestID/test_seg/2.ts", 2, nil)
+
+	b, err := json.Marshal(jspl)
+	assert.Nil(err)
+	var res struct {
+		Segments map[string][]map[string]interface{} `json:"segments"`
+	}
+	assert.Nil(json.Unmarshal(b, &res))
+	segs := res.Segments[vProfile.Name]
+	assert.Len(segs, 2)
+	assert.Equal(map[string]interface{}{"codec": "libx264", "bitrate_mode": "cbr"}, segs[0]["encoder"])
+	assert.NotContains(segs[1], "encoder")
+
+	// kept when joining playlists
+	mjspl := NewJSONPlaylist()
+	mjspl.AddTrack(jspl, vProfile.Name)
+	assert.Equal("libx264", mjspl.Segments[vProfile.Name][0].Encoder.Codec)
+}
+
 func TestJSONListJoin(t *testing.T) {
 	assert := assert.New(t)
 	jspl1 := NewJSONPlaylist()
 	vProfile := ffmpeg.P144p30fps16x9
 	vProfile.Name = "source"
-	jspl1.InsertHLSSegment(&vProfile, 1, "manifestID/test_seg/1.ts", 2.1)
-	jspl1.InsertHLSSegment(&vProfile, 3, "manifestID/test_seg/3.ts", 2.5)
-	jspl1.InsertHLSSegment(&vProfile, 4, "manifestID/test_seg/4.ts", 2.5)
+	jspl1.InsertHLSSegment(&vProfile, 1, "manifestID/test_seg/1.ts", 2.1, nil)
+	jspl1.InsertHLSSegment(&vProfile, 3, "manifestID/test_seg/3.ts", 2.5, nil)
+	jspl1.InsertHLSSegment(&vProfile, 4, "manifestID/test_seg/4.ts", 2.5, nil)
 	assert.Len(jspl1.Segments, 1)
 	assert.Len(jspl1.Segments["source"], 3)
 	assert.Equal(uint64(2100+2500+2500), jspl1.DurationMs)
 
 	jspl2 := NewJSONPlaylist()
-	jspl2.InsertHLSSegment(&vProfile, 2, "manifestID2/test_seg/2.ts", 2)
-	jspl2.InsertHLSSegment(&vProfile, 4, "manifestID2/test_seg/4.ts", 2)
+	jspl2.InsertHLSSegment(&vProfile, 2, "manifestID2/test_seg/2.ts", 2, nil)
+	jspl2.InsertHLSSegment(&vProfile, 4, "manifestID2/test_seg/4.ts", 2, nil)
 	assert.Len(jspl2.Segments, 1)
 	assert.Len(jspl2.Tracks, 1)
 	assert.Len(jspl2.Segments["source"], 2)
 	assert.Equal(uint64(4000), jspl2.DurationMs)
 	jspl1.AddDiscontinuedTrack(jspl2, "source")
 	assert.Len(jspl1.Segments["source"], 5)
-	assert.True(jspl1.Segments["source"][3].discontinuity)
+	assert.True(jspl1.Segments["source"][3].Discontinuity)
 }
 
 func TestJsonFlush(t *testing.T) {
@@ -111,7 +137,7 @@ func TestJsonFlush(t *testing.T) {
 	c := NewBasicPlaylistManager(mid, nil, msess)
 	assert.Equal(msess, c.GetRecordOSSession())
 	segName := "test_seg/1.ts"
-	c.InsertHLSSegmentJSON(&vProfile, 1, segName, 12*60*60)
+	c.InsertHLSSegmentJSON(&vProfile, 1, segName, 12*60*60, nil)
 	assert.NotNil(c.jsonList)
 	assert.True(c.jsonList.hasTrack(vProfile.Name))
 	assert.Len(c.jsonList.Segments, 1)
@@ -130,6 +156,134 @@ func TestJsonFlush(t *testing.T) {
 	assert.Equal(`{"duration_ms":43200000,"tracks":[{"name":"source","bandwidth":400000,"resolution":"256x144"}],"segments":{"source":[{"seq_no":1,"uri":"test_seg/1.ts","duration_ms":43200000}]}}`, string(data))
 }
 
+type recordOSSession struct {
+	drivers.OSSession
+	saved   chan string
+	release chan struct{}
+}
+
+func newRecordOSSession() *recordOSSession {
+	return &recordOSSession{
+		OSSession: drivers.NewMemoryDriver(nil).NewSession("rec"),
+		saved:     make(chan string, 10),
+	}
+}
+
+func (s *recordOSSession) SaveData(name string, data []byte, meta map[string]string) (string, error) {
+	if s.release != nil {
+		<-s.release
+	}
+	s.saved <- string(data)
+	return name, nil
+}
+
+func (s *recordOSSession) waitSave(t *testing.T) string {
+	select {
+	case data := <-s.saved:
+		return data
+	case <-time.After(time.Second):
+		t.Fatal("playlist was not saved")
+	}
+	return ""
+}
+
+func TestJsonFlush_Batched(t *testing.T) {
+	assert := assert.New(t)
+	rec := newRecordOSSession()
+	vProfile := ffmpeg.P144p30fps16x9
+	vProfile.Name = "source"
+	c := NewBasicPlaylistManager(RandomManifestID(), nil, rec)
+	c.SetRecordFlushInterval(30 * time.Millisecond)
+
+	c.InsertHLSSegmentJSON(&vProfile, 1, "test_seg/1.ts", 2, nil)
+	c.FlushRecord()
+	c.InsertHLSSegmentJSON(&vProfile, 2, "test_seg/2.ts", 2, nil)
+	c.FlushRecord()
+	assert.Len(rec.saved, 0)
+
+	// both segments are saved at once after the interval
+	data := rec.waitSave(t)
+	assert.Contains(data, "test_seg/1.ts")
+	assert.Contains(data, "test_seg/2.ts")
+	time.Sleep(50 * time.Millisecond)
+	assert.Len(rec.saved, 0)
+
+	// pending updates are saved on cleanup
+	c.storageSession = drivers.NewMemoryDriver(nil).NewSession("live")
+	c.SetRecordFlushInterval(time.Hour)
+	c.InsertHLSSegmentJSON(&vProfile, 3, "test_seg/3.ts", 2, nil)
+	c.FlushRecord()
+	c.Cleanup()
+	assert.Contains(rec.waitSave(t), "test_seg/3.ts")
+}
+
+func TestJsonFlush_Coalesced(t *testing.T) {
+	assert := assert.New(t)
+	rec := newRecordOSSession()
+	rec.release = make(chan struct{})
+	vProfile := ffmpeg.P144p30fps16x9
+	vProfile.Name = "source"
+	c := NewBasicPlaylistManager(RandomManifestID(), nil, rec)
+
+	// flushes while a save is in flight are folded into a single save
+	for i := 1; i <= 3; i++ {
+		c.InsertHLSSegmentJSON(&vProfile, uint64(i), fmt.Sprintf("test_seg/%d.ts", i), 2, nil)
+		c.FlushRecord()
+	}
+	close(rec.release)
+	data := rec.waitSave(t)
+	assert.Contains(data, "test_seg/1.ts")
+	assert.NotContains(data, "test_seg/2.ts")
+	data = rec.waitSave(t)
+	assert.Contains(data, "test_seg/3.ts")
+	time.Sleep(20 * time.Millisecond)
+	assert.Len(rec.saved, 0)
+}
+
+func TestGetRecording(t *testing.T) {
+	assert := assert.New(t)
+	vProfile := ffmpeg.P144p30fps16x9
+	vProfile.Name = "source"
+
+	assert.Nil(NewBasicPlaylistManager(RandomManifestID(), nil, nil).GetRecording())
+
+	rec := newRecordOSSession()
+	c := NewBasicPlaylistManager(RandomManifestID(), nil, rec)
+	c.SetRecordHistory(90 * time.Minute)
+	assert.Empty(c.GetRecording().Segments)
+
+	// playlists rotated out are kept as long as they are within the history
+	for i := 1; i <= 4; i++ {
+		c.InsertHLSSegmentJSON(&vProfile, uint64(i), fmt.Sprintf("test_seg/%d.ts", i), 61*60, nil)
+		c.FlushRecord()
+		rec.waitSave(t)
+	}
+	c.InsertHLSSegmentJSON(&vProfile, 5, "test_seg/5.ts", 2, nil)
+	recording := c.GetRecording()
+	assert.Len(c.recordedPlaylists, 2)
+	assert.Equal([]JsonMediaTrack{{Name: "source", Bandwidth: 400000, Resolution: "256x144"}}, recording.Tracks)
+	var seqNos []uint64
+	for _, seg := range recording.Segments["source"] {
+		seqNos = append(seqNos, seg.SeqNo)
+	}
+	assert.Equal([]uint64{3, 4, 5}, seqNos)
+	assert.Equal(uint64(2*61*60*1000+2000), recording.DurationMs)
+
+	// copies are not changed by later segments
+	c.InsertHLSSegmentJSON(&vProfile, 6, "test_seg/6.ts", 2, nil)
+	assert.Len(recording.Segments["source"], 3)
+	assert.Len(c.GetRecording().Segments["source"], 4)
+
+	// only the current playlist without a history
+	c = NewBasicPlaylistManager(RandomManifestID(), nil, rec)
+	c.InsertHLSSegmentJSON(&vProfile, 1, "test_seg/1.ts", 61*60, nil)
+	c.FlushRecord()
+	rec.waitSave(t)
+	c.InsertHLSSegmentJSON(&vProfile, 2, "test_seg/2.ts", 2, nil)
+	assert.Len(c.GetRecording().Segments["source"], 1)
+	assert.Empty(c.recordedPlaylists)
+}
+
 func TestGetMasterPlaylist(t *testing.T) {
 	assert := assert.New(t)
 	vProfile := ffmpeg.P144p30fps16x9
@@ -270,6 +424,42 @@ func TestPlaylists(t *testing.T) {
 
 }
 
+func TestSegmentInserted(t *testing.T) {
+	assert := assert.New(t)
+
+	c := NewBasicPlaylistManager(RandomManifestID(), nil, nil)
+	vProfile := &ffmpeg.P144p30fps16x9
+	inserted := c.SegmentInserted()
+	select {
+	case <-inserted:
+		t.Error("Notified before any insertion")
+	default:
+	}
+	assert.Nil(c.InsertHLSSegment(vProfile, 1, "abc", 2))
+	select {
+	case <-inserted:
+	default:
+		t.Error("Not notified of insertion")
+	}
+
+	// segments that fail to be inserted aren't notified
+	inserted = c.SegmentInserted()
+	assert.NotNil(c.InsertHLSSegment(vProfile, 1, "abc", 2))
+	select {
+	case <-inserted:
+		t.Error("Notified of a failed insertion")
+	default:
+	}
+	assert.NotContains(c.GetHLSMediaPlaylist(vProfile.Name).String(), "#EXT-X-SERVER-CONTROL")
+
+	// blocking reloads are advertised
+	BlockingPlaylistReload = true
+	defer func() { BlockingPlaylistReload = false }()
+	c = NewBasicPlaylistManager(RandomManifestID(), nil, nil)
+	assert.Nil(c.InsertHLSSegment(vProfile, 1, "abc", 2))
+	assert.Contains(c.GetHLSMediaPlaylist(vProfile.Name).String(), "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES\n")
+}
+
 func TestCleanup(t *testing.T) {
 	vProfile := ffmpeg.P144p30fps16x9
 	hlsStrmID := MakeStreamID(RandomManifestID(), &vProfile)
@@ -301,3 +491,18 @@ func TestCleanup(t *testing.T) {
 		t.Fatal("Data should be cleaned up")
 	}
 }
+
+func TestInsertCMAFSegment(t *testing.T) {
+	assert := assert.New(t)
+
+	c := NewBasicPlaylistManager(RandomManifestID(), nil, nil)
+	vProfile := &ffmpeg.P144p30fps16x9
+	assert.Nil(c.InsertCMAFSegment(vProfile, 1, "1.m4s", "init/init_1.mp4", 2))
+	assert.Nil(c.InsertCMAFSegment(vProfile, 2, "2.m4s", "init/init_2.mp4", 2))
+	assert.NotNil(c.InsertCMAFSegment(vProfile, 2, "2.m4s", "init/init_2.mp4", 2))
+
+	pl := c.GetHLSMediaPlaylist(vProfile.Name).String()
+	assert.Contains(pl, "#EXT-X-VERSION:6\n")
+	assert.Contains(pl, "#EXT-X-MAP:URI=\"init/init_1.mp4\"\n#EXTINF:2.000,\n1.m4s\n")
+	assert.Contains(pl, "#EXT-X-MAP:URI=\"init/init_2.mp4\"\n#EXTINF:2.000,\n2.m4s\n")
+}
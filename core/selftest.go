@@ -0,0 +1,123 @@
+package core
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/lpms/ffmpeg"
+)
+
+// "145x1" is the minimal resolution that succeeds on Windows, so use "145x145"
+var selfTestProfile = ffmpeg.VideoProfile{Name: "selftest", Resolution: "145x145", Bitrate: "1k", Format: ffmpeg.FormatMPEGTS}
+
+// capabilitySelfTests turn selfTestProfile into renditions exercising the
+// capabilities that depend on the transcoder. H264 and MPEGTS are exercised
+// by selfTestProfile itself. Other capabilities, such as storage, are not
+// tested.
+var capabilitySelfTests = map[Capability]func(p *ffmpeg.VideoProfile){
+	Capability_MP4:                        func(p *ffmpeg.VideoProfile) { p.Format = ffmpeg.FormatMP4 },
+	Capability_FractionalFramerates:       func(p *ffmpeg.VideoProfile) { p.Framerate, p.FramerateDen = 30000, 1001 },
+	Capability_ProfileH264Baseline:        func(p *ffmpeg.VideoProfile) { p.Profile = ffmpeg.ProfileH264Baseline },
+	Capability_ProfileH264Main:            func(p *ffmpeg.VideoProfile) { p.Profile = ffmpeg.ProfileH264Main },
+	Capability_ProfileH264High:            func(p *ffmpeg.VideoProfile) { p.Profile = ffmpeg.ProfileH264High },
+	Capability_ProfileH264ConstrainedHigh: func(p *ffmpeg.VideoProfile) { p.Profile = ffmpeg.ProfileH264ConstrainedHigh },
+	Capability_GOP:                        func(p *ffmpeg.VideoProfile) { p.GOP = time.Second },
+}
+
+type localTestSession struct {
+	Transcoder
+}
+
+func (localTestSession) Stop() {}
+
+// SelfTestCapabilities transcodes a test segment on each of the comma
+// separated Nvidia devices `gpu`, or on the CPU if empty, once for each of
+// `caps` that depends on the transcoder. It returns the capabilities that
+// failed on any device, which shouldn't be advertised as segments needing
+// them may be sent to any device. Fails if the test segment can't be
+// transcoded at all.
+func SelfTestCapabilities(gpu string, caps []Capability) ([]Capability, error) {
+	if gpu == "" {
+		return selfTestCapabilities([]string{""}, func(string) TranscoderSession {
+			return localTestSession{NewLocalTranscoder(WorkDir)}
+		}, caps)
+	}
+	return selfTestCapabilities(strings.Split(gpu, ","), NewNvidiaTranscoder, caps)
+}
+
+func selfTestCapabilities(devices []string, newT newTranscoderFn, caps []Capability) ([]Capability, error) {
+	fname, err := writeTestSegment()
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(fname)
+
+	failed := make(map[Capability]bool)
+	for _, device := range devices {
+		t := newT(device)
+		if err := selfTestTranscode(t, fname, selfTestProfile); err != nil {
+			t.Stop()
+			return nil, fmt.Errorf("device=%s: %v", device, err)
+		}
+		for _, c := range caps {
+			set, ok := capabilitySelfTests[c]
+			if !ok || failed[c] {
+				continue
+			}
+			p := selfTestProfile
+			set(&p)
+			if err := selfTestTranscode(t, fname, p); err != nil {
+				glog.Errorf("Capability failed self test capability=%s device=%s err=%v", c, device, err)
+				failed[c] = true
+			}
+		}
+		t.Stop()
+	}
+
+	var res []Capability
+	for _, c := range caps {
+		if failed[c] {
+			res = append(res, c)
+		}
+	}
+	return res, nil
+}
+
+func selfTestTranscode(t Transcoder, fname string, p ffmpeg.VideoProfile) error {
+	md := &SegTranscodingMetadata{Fname: fname, Profiles: []ffmpeg.VideoProfile{p, p, p, p}}
+	td, err := t.Transcode(md)
+	if err != nil {
+		return err
+	}
+	if len(td.Segments) == 0 || td.Pixels == 0 {
+		return errors.New("Empty transcoded segment")
+	}
+	return nil
+}
+
+// writeTestSegment writes the test segment to the work dir, returning its
+// path
+func writeTestSegment() (string, error) {
+	z, err := gzip.NewReader(bytes.NewReader(testSegment))
+	if err != nil {
+		return "", err
+	}
+	mp4testSeg, err := ioutil.ReadAll(z)
+	z.Close()
+	if err != nil {
+		return "", err
+	}
+	fname := filepath.Join(WorkDir, "testseg.tempfile")
+	if err := ioutil.WriteFile(fname, mp4testSeg, 0644); err != nil {
+		return "", err
+	}
+	return fname, nil
+}
@@ -0,0 +1,73 @@
+package core
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/livepeer/lpms/ffmpeg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type selfTestTranscoder struct {
+	device  string
+	fail    func(device string, p ffmpeg.VideoProfile) bool
+	stopped *int
+}
+
+func (t *selfTestTranscoder) Transcode(md *SegTranscodingMetadata) (*TranscodeData, error) {
+	if _, err := os.Stat(md.Fname); err != nil {
+		return nil, err
+	}
+	if t.fail(t.device, md.Profiles[0]) {
+		return nil, errors.New("unsupported")
+	}
+	return &TranscodeData{Segments: []*TranscodedSegmentData{{Data: []byte("foo")}}, Pixels: 1}, nil
+}
+
+func (t *selfTestTranscoder) Stop() {
+	*t.stopped++
+}
+
+func TestSelfTestCapabilities(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	defer func(wd string) { WorkDir = wd }(WorkDir)
+	dir, err := ioutil.TempDir("", "selftest")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+	WorkDir = dir
+
+	var stopped int
+	fail := func(device string, p ffmpeg.VideoProfile) bool { return false }
+	newT := func(device string) TranscoderSession {
+		return &selfTestTranscoder{device: device, fail: func(d string, p ffmpeg.VideoProfile) bool { return fail(d, p) }, stopped: &stopped}
+	}
+	caps := []Capability{Capability_H264, Capability_MPEGTS, Capability_MP4, Capability_ProfileH264High, Capability_GOP, Capability_StorageS3}
+
+	failed, err := selfTestCapabilities([]string{"0", "1"}, newT, caps)
+	require.Nil(err)
+	assert.Empty(failed)
+	assert.Equal(2, stopped)
+
+	// capabilities failing on any device are reported
+	fail = func(device string, p ffmpeg.VideoProfile) bool {
+		return (device == "1" && p.Format == ffmpeg.FormatMP4) || (device == "0" && p.GOP > 0)
+	}
+	failed, err = selfTestCapabilities([]string{"0", "1"}, newT, caps)
+	require.Nil(err)
+	assert.Equal([]Capability{Capability_MP4, Capability_GOP}, failed)
+
+	// devices that can't transcode at all fail the test
+	fail = func(device string, p ffmpeg.VideoProfile) bool { return device == "1" }
+	_, err = selfTestCapabilities([]string{"0", "1"}, newT, caps)
+	assert.EqualError(err, "device=1: unsupported")
+
+	// the test segment is cleaned up
+	files, err := ioutil.ReadDir(dir)
+	require.Nil(err)
+	assert.Empty(files)
+}
@@ -34,6 +34,89 @@ func TestStreamParameters(t *testing.T) {
 	assert.Equal("abc/source", s.StreamID())
 }
 
+func TestSegmentMetadata(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil((*SegmentMetadata)(nil).Get())
+
+	md := map[string]string{"viewer": "viewer1"}
+	m := NewSegmentMetadata(md)
+	// copied rather than shared with the caller
+	md["viewer"] = "viewer2"
+	assert.Equal(map[string]string{"viewer": "viewer1"}, m.Get())
+
+	// replaced rather than merged
+	prev := m.Get()
+	m.Set(map[string]string{"epoch": "2"})
+	assert.Equal(map[string]string{"epoch": "2"}, m.Get())
+	assert.Equal(map[string]string{"viewer": "viewer1"}, prev)
+
+	assert.NotNil(NewSegmentMetadata(nil).Get())
+}
+
+func TestStreamLabels(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil((*StreamLabels)(nil).Get())
+
+	labels := map[string]string{"title": "Live", "customer": "cust1"}
+	l := NewStreamLabels(labels)
+	labels["title"] = "Replay"
+	assert.Equal(map[string]string{"title": "Live", "customer": "cust1"}, l.Get())
+
+	// merged, with empty values removed
+	prev := l.Get()
+	l.Update(map[string]string{"title": "", "tags": "music"})
+	assert.Equal(map[string]string{"customer": "cust1", "tags": "music"}, l.Get())
+	assert.Equal(map[string]string{"title": "Live", "customer": "cust1"}, prev)
+
+	assert.NotNil(NewStreamLabels(nil).Get())
+}
+
+func TestStreamPriority(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(PriorityNormal, StreamParameters{}.Priority)
+
+	for _, p := range []StreamPriority{PriorityLow, PriorityNormal, PriorityHigh} {
+		parsed, err := ParseStreamPriority(p.String())
+		assert.Nil(err)
+		assert.Equal(p, parsed)
+	}
+	p, err := ParseStreamPriority("")
+	assert.Nil(err)
+	assert.Equal(PriorityNormal, p)
+	p, err = ParseStreamPriority("HIGH")
+	assert.Nil(err)
+	assert.Equal(PriorityHigh, p)
+
+	_, err = ParseStreamPriority("premium")
+	assert.EqualError(err, `unknown priority class "premium"`)
+	assert.Equal("StreamPriority(5)", StreamPriority(5).String())
+}
+
+func TestParseSelectionWeights(t *testing.T) {
+	assert := assert.New(t)
+
+	w, err := ParseSelectionWeights("stake=1, price=0.5,LATENCY=2")
+	assert.Nil(err)
+	assert.Equal(SelectionWeights{Stake: 1, Price: 0.5, Latency: 2}, w)
+	assert.False(w.IsZero())
+
+	w, err = ParseSelectionWeights("")
+	assert.Nil(err)
+	assert.True(w.IsZero())
+
+	_, err = ParseSelectionWeights("stake")
+	assert.EqualError(err, `invalid selection weight "stake"`)
+	_, err = ParseSelectionWeights("stake=high")
+	assert.EqualError(err, `invalid selection weight "stake=high"`)
+	_, err = ParseSelectionWeights("uptime=1")
+	assert.EqualError(err, `unknown selection weight "uptime"`)
+	_, err = ParseSelectionWeights("errors=-1")
+	assert.EqualError(err, "invalid selection weight -1")
+}
+
 func TestSegmentFlatten(t *testing.T) {
 	md := SegTranscodingMetadata{
 		ManifestID: ManifestID("abcdef"),
@@ -3,8 +3,11 @@ package core
 import (
 	"errors"
 	"fmt"
+	"math"
 	"math/big"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	ethcommon "github.com/ethereum/go-ethereum/common"
@@ -31,6 +34,238 @@ type StreamParameters struct {
 	OS           drivers.OSSession
 	RecordOS     drivers.OSSession
 	Capabilities *Capabilities
+	// Interval over which recording playlist saves are batched
+	RecordFlushInterval time.Duration
+	// Client addresses that may push or play the stream; nil for any
+	IngestFilter   *common.IPFilter
+	PlaybackFilter *common.IPFilter
+	// Secret that pushes to the stream must be signed with; empty to accept
+	// unsigned pushes
+	PushSecret string
+	// External endpoints the stream is pushed to
+	Restream []RestreamTarget
+	// Derive Profiles from the source rather than use them as they are
+	AutoLadder bool
+	// Base URL of the broadcaster the stream is pinned to, if not this one
+	IngestNode string
+	// Only record the source, leaving the recording to be transcoded into
+	// Profiles later
+	RecordOnly bool
+	// Metadata sent to orchestrators along each segment of the stream; nil
+	// if the stream carries none
+	Metadata *SegmentMetadata
+	// Labels describing the stream to its operator, which are not sent to
+	// orchestrators
+	Labels *StreamLabels
+	// Priority class of the stream, deciding how much of the node's and the
+	// network's resources it gets under stress
+	Priority StreamPriority
+	// Manifest ID the recording of the stream is served under by /recordings/
+	RecordingID ManifestID
+	// Manifest IDs of earlier sessions the recording of the stream continues
+	PreviousSessions []string
+	// URL of the store RecordOS is a session of; empty for that of the node
+	RecordStore string
+	// URL the recording is read from, if not the record store
+	RecordExtURL string
+	// Names of the MP4 renditions packaged as CMAF, into initialization and
+	// media segments that both the HLS and the DASH manifests list
+	CMAF map[string]bool
+	// Weights orchestrators are scored by when selected for the stream; nil
+	// for those of the node
+	SelectionWeights *SelectionWeights
+	// Duration the source is cut into segments at; zero for that of the node
+	SegmentDuration time.Duration
+	// Audio-only renditions, remuxed from the audio of the source by the
+	// broadcaster rather than transcoded
+	AudioProfiles []ffmpeg.VideoProfile
+	// URL the heartbeats of the stream are posted to; empty for that of the
+	// node
+	HeartbeatURL string
+}
+
+// StreamPriority is the priority class of a stream. The zero value is the
+// normal class.
+type StreamPriority int
+
+const (
+	PriorityLow StreamPriority = iota - 1
+	PriorityNormal
+	PriorityHigh
+)
+
+// NumStreamPriorities is the number of priority classes
+const NumStreamPriorities = int(PriorityHigh-PriorityLow) + 1
+
+var streamPriorityNames = map[StreamPriority]string{
+	PriorityLow:    "low",
+	PriorityNormal: "normal",
+	PriorityHigh:   "high",
+}
+
+func (p StreamPriority) String() string {
+	if name, ok := streamPriorityNames[p]; ok {
+		return name
+	}
+	return fmt.Sprintf("StreamPriority(%d)", int(p))
+}
+
+// ParseStreamPriority returns the priority class named `name`; an empty name
+// is the normal class
+func ParseStreamPriority(name string) (StreamPriority, error) {
+	if name == "" {
+		return PriorityNormal, nil
+	}
+	for p, n := range streamPriorityNames {
+		if strings.EqualFold(n, name) {
+			return p, nil
+		}
+	}
+	return PriorityNormal, fmt.Errorf("unknown priority class %q", name)
+}
+
+// SelectionWeights weigh the stake, price, recent latency and error rate of
+// orchestrators when scoring them for selection. The zero value selects
+// orchestrators at random, weighted by stake alone.
+type SelectionWeights struct {
+	Stake   float64 `json:"stake"`
+	Price   float64 `json:"price"`
+	Latency float64 `json:"latency"`
+	Errors  float64 `json:"errors"`
+}
+
+// IsZero tells whether no weights are set
+func (w SelectionWeights) IsZero() bool {
+	return w == SelectionWeights{}
+}
+
+// Validate checks that none of the weights are negative
+func (w SelectionWeights) Validate() error {
+	for _, v := range []float64{w.Stake, w.Price, w.Latency, w.Errors} {
+		if v < 0 || math.IsNaN(v) || math.IsInf(v, 0) {
+			return fmt.Errorf("invalid selection weight %v", v)
+		}
+	}
+	return nil
+}
+
+// ParseSelectionWeights parses weights given as comma separated
+// <name>=<weight> pairs, e.g. "stake=1,price=2", where the names are stake,
+// price, latency and errors; unset weights are 0
+func ParseSelectionWeights(s string) (SelectionWeights, error) {
+	var w SelectionWeights
+	if strings.TrimSpace(s) == "" {
+		return w, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 {
+			return SelectionWeights{}, fmt.Errorf("invalid selection weight %q", pair)
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+		if err != nil {
+			return SelectionWeights{}, fmt.Errorf("invalid selection weight %q", pair)
+		}
+		switch strings.ToLower(strings.TrimSpace(kv[0])) {
+		case "stake":
+			w.Stake = v
+		case "price":
+			w.Price = v
+		case "latency":
+			w.Latency = v
+		case "errors":
+			w.Errors = v
+		default:
+			return SelectionWeights{}, fmt.Errorf("unknown selection weight %q", kv[0])
+		}
+	}
+	return w, w.Validate()
+}
+
+// RestreamTarget is an external RTMP(S) endpoint, such as that of YouTube or
+// Twitch, that a stream is pushed to
+type RestreamTarget struct {
+	URL string `json:"url"`
+	// Name of the rendition to push; the source if empty
+	Profile string `json:"profile,omitempty"`
+}
+
+// SegmentMetadata is metadata of a stream, such as viewer or epoch IDs, that
+// the control plane may change while the stream is live. Changes apply to
+// the segments sent after them.
+type SegmentMetadata struct {
+	mu sync.RWMutex
+	md map[string]string
+}
+
+func NewSegmentMetadata(md map[string]string) *SegmentMetadata {
+	m := &SegmentMetadata{}
+	m.Set(md)
+	return m
+}
+
+// Get returns the current metadata, which must not be modified
+func (m *SegmentMetadata) Get() map[string]string {
+	if m == nil {
+		return nil
+	}
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.md
+}
+
+// Set replaces the metadata for the segments to come
+func (m *SegmentMetadata) Set(md map[string]string) {
+	cp := make(map[string]string, len(md))
+	for k, v := range md {
+		cp[k] = v
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.md = cp
+}
+
+// StreamLabels are key/values describing a stream, such as its title,
+// customer ID or tags, that the control plane may change while the stream is
+// live
+type StreamLabels struct {
+	mu     sync.RWMutex
+	labels map[string]string
+}
+
+func NewStreamLabels(labels map[string]string) *StreamLabels {
+	l := &StreamLabels{labels: make(map[string]string, len(labels))}
+	l.Update(labels)
+	return l
+}
+
+// Get returns the current labels, which must not be modified
+func (l *StreamLabels) Get() map[string]string {
+	if l == nil {
+		return nil
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.labels
+}
+
+// Update sets the labels in `labels`, removing those set to an empty value
+func (l *StreamLabels) Update(labels map[string]string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// copied, as earlier labels may still be read
+	cp := make(map[string]string, len(l.labels)+len(labels))
+	for k, v := range l.labels {
+		cp[k] = v
+	}
+	for k, v := range labels {
+		if v == "" {
+			delete(cp, k)
+		} else {
+			cp[k] = v
+		}
+	}
+	l.labels = cp
 }
 
 func (s *StreamParameters) StreamID() string {
@@ -47,6 +282,9 @@ type SegTranscodingMetadata struct {
 	Duration   time.Duration
 	Caps       *Capabilities
 	AuthToken  *net.AuthToken
+	Metadata   map[string]string
+	// Public key the broadcaster encrypted the segment data with, if any
+	SegmentKey []byte
 }
 
 func (md *SegTranscodingMetadata) Flatten() []byte {
@@ -81,6 +319,8 @@ func NetSegData(md *SegTranscodingMetadata) (*net.SegData, error) {
 		Duration:     int32(md.Duration / time.Millisecond),
 		Capabilities: md.Caps.ToNetCapabilities(),
 		AuthToken:    md.AuthToken,
+		Metadata:     md.Metadata,
+		SegmentKey:   md.SegmentKey,
 		// Triggers failure on Os that don't know how to use FullProfiles/2/3
 		Profiles: []byte("invalid"),
 	}
@@ -147,14 +387,10 @@ func MakeStreamID(mid ManifestID, profile *ffmpeg.VideoProfile) StreamID {
 }
 
 func SplitStreamIDString(str string) StreamID {
-	parts := strings.SplitN(str, "/", 2)
-	if len(parts) <= 0 {
-		return MakeStreamIDFromString("", "")
-	}
-	if len(parts) == 1 {
-		return MakeStreamIDFromString(parts[0], "")
+	if i := strings.IndexByte(str, '/'); i >= 0 {
+		return MakeStreamIDFromString(str[:i], str[i+1:])
 	}
-	return MakeStreamIDFromString(parts[0], parts[1])
+	return MakeStreamIDFromString(str, "")
 }
 
 func (id StreamID) String() string {
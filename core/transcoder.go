@@ -1,13 +1,10 @@
 package core
 
 import (
-	"bytes"
-	"compress/gzip"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
@@ -95,44 +92,6 @@ func (nv *NvidiaTranscoder) Transcode(md *SegTranscodingMetadata) (*TranscodeDat
 	return resToTranscodeData(res, out)
 }
 
-// TestNvidiaTranscoder tries to transcode test segment on all the devices
-func TestNvidiaTranscoder(gpu string) error {
-	devices := strings.Split(gpu, ",")
-	b := bytes.NewReader(testSegment)
-	z, err := gzip.NewReader(b)
-	if err != nil {
-		return err
-	}
-	mp4testSeg, err := ioutil.ReadAll(z)
-	z.Close()
-	if err != nil {
-		return err
-	}
-	fname := filepath.Join(WorkDir, "testseg.tempfile")
-	err = ioutil.WriteFile(fname, mp4testSeg, 0644)
-	if err != nil {
-		return err
-	}
-	defer os.Remove(fname)
-	for _, device := range devices {
-		t1 := NewNvidiaTranscoder(device)
-		// "145x1" is the minimal resolution that succeeds on Windows, so use "145x145"
-		p := ffmpeg.VideoProfile{Resolution: "145x145", Bitrate: "1k", Format: ffmpeg.FormatMP4}
-		md := &SegTranscodingMetadata{Fname: fname, Profiles: []ffmpeg.VideoProfile{p, p, p, p}}
-		td, err := t1.Transcode(md)
-
-		t1.Stop()
-		if err != nil {
-			return err
-		}
-		if len(td.Segments) == 0 || td.Pixels == 0 {
-			return errors.New("Empty transcoded segment")
-		}
-	}
-
-	return nil
-}
-
 func NewNvidiaTranscoder(gpu string) TranscoderSession {
 	return &NvidiaTranscoder{
 		device:  gpu,
@@ -171,7 +130,7 @@ func resToTranscodeData(res *ffmpeg.TranscodeResults, opts []ffmpeg.TranscodeOpt
 			glog.Error("Cannot read transcoded output for ", oname)
 			return nil, err
 		}
-		segments[i] = &TranscodedSegmentData{Data: o, Pixels: res.Encoded[i].Pixels}
+		segments[i] = &TranscodedSegmentData{Data: o, Pixels: res.Encoded[i].Pixels, Encoder: encoderSettings(opts[i])}
 		os.Remove(oname)
 	}
 
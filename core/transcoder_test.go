@@ -4,6 +4,7 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/livepeer/go-livepeer/common"
 	"github.com/livepeer/go-livepeer/net"
@@ -149,10 +150,46 @@ func TestResToTranscodeData(t *testing.T) {
 	assert.Equal(2, len(tData.Segments))
 	assert.Equal(int64(200), tData.Segments[0].Pixels)
 	assert.Equal(int64(300), tData.Segments[1].Pixels)
+	assert.NotNil(tData.Segments[0].Encoder)
 	assert.True(fileDNE(file1.Name()))
 	assert.True(fileDNE(file2.Name()))
 }
 
+func TestEncoderSettings(t *testing.T) {
+	assert := assert.New(t)
+
+	p := ffmpeg.P240p30fps16x9
+	p.Profile = ffmpeg.ProfileH264Main
+	p.GOP = 2 * time.Second
+	assert.Equal(&EncoderSettings{
+		Codec:       "libx264",
+		Profile:     "main",
+		Preset:      "medium",
+		BitrateMode: "cbr",
+		Bitrate:     p.Bitrate,
+		Resolution:  p.Resolution,
+		Framerate:   "30",
+		GOP:         "2s",
+	}, encoderSettings(ffmpeg.TranscodeOptions{Profile: p, Accel: ffmpeg.Software}))
+
+	// encoder defaults are left out
+	p = ffmpeg.VideoProfile{Resolution: "1280x720", Framerate: 30000, FramerateDen: 1001, GOP: ffmpeg.GOPIntraOnly}
+	assert.Equal(&EncoderSettings{
+		Codec:      "h264_nvenc",
+		Preset:     "medium",
+		Resolution: "1280x720",
+		Framerate:  "30000/1001",
+		GOP:        "intra",
+	}, encoderSettings(ffmpeg.TranscodeOptions{Profile: p, Accel: ffmpeg.Nvidia}))
+	assert.Equal("crf", encoderSettings(ffmpeg.TranscodeOptions{Profile: p, Accel: ffmpeg.Software}).BitrateMode)
+
+	// converted for the wire and back
+	s := encoderSettings(ffmpeg.TranscodeOptions{Profile: ffmpeg.P144p30fps16x9, Accel: ffmpeg.Software})
+	assert.Equal(s, EncoderSettingsFromNet(NetEncoderSettings(s)))
+	assert.Nil(NetEncoderSettings(nil))
+	assert.Nil(EncoderSettingsFromNet(nil))
+}
+
 func TestProfilesToTranscodeOptions(t *testing.T) {
 	workDir := "foo"
 
@@ -38,31 +38,57 @@ type DBOrchestratorPoolCache struct {
 }
 
 func NewDBOrchestratorPoolCache(ctx context.Context, node *core.LivepeerNode, rm common.RoundsManager) (*DBOrchestratorPoolCache, error) {
+	dbo, err := newDBOrchestratorPoolCache(node, rm)
+	if err != nil {
+		return nil, err
+	}
+	if err := dbo.warm(ctx); err != nil {
+		return nil, err
+	}
+	return dbo, nil
+}
+
+// NewLazyDBOrchestratorPoolCache returns a pool that immediately serves the
+// orchestrators cached in the DB by previous runs of the node while the cache
+// is refreshed from the chain in the background. The result of the refresh is
+// sent on the returned channel.
+func NewLazyDBOrchestratorPoolCache(ctx context.Context, node *core.LivepeerNode, rm common.RoundsManager) (*DBOrchestratorPoolCache, <-chan error, error) {
+	dbo, err := newDBOrchestratorPoolCache(node, rm)
+	if err != nil {
+		return nil, nil, err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- dbo.warm(ctx)
+	}()
+	return dbo, done, nil
+}
+
+func newDBOrchestratorPoolCache(node *core.LivepeerNode, rm common.RoundsManager) (*DBOrchestratorPoolCache, error) {
 	if node.Eth == nil {
 		return nil, fmt.Errorf("could not create DBOrchestratorPoolCache: LivepeerEthClient is nil")
 	}
 
-	dbo := &DBOrchestratorPoolCache{
+	return &DBOrchestratorPoolCache{
 		store:                 node.Database,
 		lpEth:                 node.Eth,
 		ticketParamsValidator: node.Sender,
 		rm:                    rm,
 		bcast:                 core.NewBroadcaster(node),
-	}
+	}, nil
+}
 
+// warm caches the orchestrators registered on chain and starts polling them
+func (dbo *DBOrchestratorPoolCache) warm(ctx context.Context) error {
 	if err := dbo.cacheTranscoderPool(); err != nil {
-		return nil, err
+		return err
 	}
 
 	if err := dbo.cacheOrchestratorStake(); err != nil {
-		return nil, err
-	}
-
-	if err := dbo.pollOrchestratorInfo(ctx); err != nil {
-		return nil, err
+		return err
 	}
 
-	return dbo, nil
+	return dbo.pollOrchestratorInfo(ctx)
 }
 
 func (dbo *DBOrchestratorPoolCache) getURLs() ([]*url.URL, error) {
@@ -80,7 +80,7 @@ func (o *orchestratorPool) GetOrchestrators(numOrchestrators int, suspender comm
 		return caps.CompatibleWith(info.Capabilities)
 	}
 	getOrchInfo := func(uri *url.URL) {
-		info, err := serverGetOrchInfo(ctx, o.bcast, uri)
+		info, err := orchInfos.get(ctx, o.bcast, uri, OrchInfoCacheTTL)
 		if err == nil && isCompatible(info) {
 			infoCh <- info
 			return
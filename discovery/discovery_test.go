@@ -346,6 +346,75 @@ func TestNewDBOrchestratorPoolCache_TestURLs_Empty(t *testing.T) {
 	assert.Len(urls, 0)
 }
 
+func TestNewLazyDBOrchestratorPoolCache(t *testing.T) {
+	dbh, dbraw, err := common.TempDB(t)
+	defer dbh.Close()
+	defer dbraw.Close()
+	require := require.New(t)
+	assert := assert.New(t)
+	require.Nil(err)
+
+	// orchestrators cached in the DB by a previous run
+	orchestrators := StubOrchestrators([]string{"https://127.0.0.1:8937", "https://127.0.0.1:8938"})
+	for _, o := range orchestrators {
+		dbh.UpdateOrch(ethOrchToDBOrch(o))
+	}
+
+	release := make(chan struct{})
+	oldOrchInfo := serverGetOrchInfo
+	defer func() { serverGetOrchInfo = oldOrchInfo }()
+	serverGetOrchInfo = func(ctx context.Context, bcast common.Broadcaster, orchestratorServer *url.URL) (*net.OrchestratorInfo, error) {
+		select {
+		case <-release:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		return &net.OrchestratorInfo{
+			Transcoder: "transcoderfromtestserver",
+			PriceInfo: &net.PriceInfo{
+				PricePerUnit:  1,
+				PixelsPerUnit: 1,
+			},
+		}, nil
+	}
+
+	node := &core.LivepeerNode{
+		Database: dbh,
+		Eth: &eth.StubClient{
+			Orchestrators: orchestrators,
+		},
+		Sender: &pm.MockSender{},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pool, done, err := NewLazyDBOrchestratorPoolCache(ctx, node, &stubRoundsManager{})
+	require.NoError(err)
+	// served from the DB while the cache is being refreshed
+	assert.Len(pool.GetURLs(), 2)
+	select {
+	case err := <-done:
+		t.Fatalf("refresh finished early err=%v", err)
+	default:
+	}
+
+	close(release)
+	select {
+	case err := <-done:
+		assert.Nil(err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("refresh did not finish")
+	}
+	assert.Equal(2, pool.Size())
+
+	// errors are still returned straight away
+	node.Eth = nil
+	pool, done, err = NewLazyDBOrchestratorPoolCache(ctx, node, &stubRoundsManager{})
+	assert.Nil(pool)
+	assert.Nil(done)
+	assert.EqualError(err, "could not create DBOrchestratorPoolCache: LivepeerEthClient is nil")
+}
+
 func TestNewDBOrchestorPoolCache_PollOrchestratorInfo(t *testing.T) {
 	cachedOrchInfo := &net.OrchestratorInfo{
 		Transcoder: "transcoderFromTest",
@@ -0,0 +1,102 @@
+package discovery
+
+import (
+	"context"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/net"
+
+	"github.com/golang/glog"
+)
+
+// OrchInfoCacheTTL is how long the responses of orchestrators to discovery
+// requests are reused before asking them again; 0 to ask them on every
+// request. Responses older than half of it are served while being refreshed
+// in the background, so that starting streams doesn't wait on a round of
+// discovery as long as orchestrators keep answering.
+var OrchInfoCacheTTL time.Duration
+
+var orchInfos = newOrchInfoCache()
+
+type orchInfoCache struct {
+	mu sync.Mutex
+	// by orchestrator URI
+	entries map[string]*orchInfoEntry
+	now     func() time.Time
+}
+
+type orchInfoEntry struct {
+	info       *net.OrchestratorInfo
+	fetched    time.Time
+	refreshing bool
+}
+
+func newOrchInfoCache() *orchInfoCache {
+	return &orchInfoCache{entries: make(map[string]*orchInfoEntry), now: time.Now}
+}
+
+// get returns the response of the orchestrator `uri` to a discovery request,
+// from the cache if it is younger than `ttl`. Only successful responses are
+// cached; compatibility with the stream is left to the caller since it
+// differs between requests.
+func (c *orchInfoCache) get(ctx context.Context, bcast common.Broadcaster, uri *url.URL, ttl time.Duration) (*net.OrchestratorInfo, error) {
+	if ttl <= 0 {
+		return serverGetOrchInfo(ctx, bcast, uri)
+	}
+	key := uri.String()
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	if ok && c.now().Sub(e.fetched) < ttl {
+		info := e.info
+		if !e.refreshing && c.now().Sub(e.fetched) >= ttl/2 {
+			e.refreshing = true
+			go c.refresh(bcast, uri, ttl)
+		}
+		c.mu.Unlock()
+		return info, nil
+	}
+	c.mu.Unlock()
+
+	info, err := serverGetOrchInfo(ctx, bcast, uri)
+	if err != nil {
+		return nil, err
+	}
+	c.store(key, info, ttl)
+	return info, nil
+}
+
+// refresh asks the orchestrator `uri` again for the cached response, keeping
+// the cached one until it expires if that fails
+func (c *orchInfoCache) refresh(bcast common.Broadcaster, uri *url.URL, ttl time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), getOrchestratorsTimeoutLoop)
+	defer cancel()
+	key := uri.String()
+	info, err := serverGetOrchInfo(ctx, bcast, uri)
+	if err != nil {
+		glog.V(common.DEBUG).Infof("Could not refresh cached orchestrator info uri=%s err=%v", key, err)
+		c.mu.Lock()
+		if e, ok := c.entries[key]; ok {
+			e.refreshing = false
+		}
+		c.mu.Unlock()
+		return
+	}
+	c.store(key, info, ttl)
+}
+
+// store caches `info` for `key`, dropping the responses of orchestrators no
+// longer asked for, such as those removed from the pool
+func (c *orchInfoCache) store(key string, info *net.OrchestratorInfo, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for k, e := range c.entries {
+		if !e.refreshing && now.Sub(e.fetched) >= 2*ttl {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = &orchInfoEntry{info: info, fetched: now}
+}
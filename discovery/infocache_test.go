@@ -0,0 +1,158 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/net"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOrchInfoCache_Get(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var mu sync.Mutex
+	calls := 0
+	var fail bool
+	oldOrchInfo := serverGetOrchInfo
+	defer func() { serverGetOrchInfo = oldOrchInfo }()
+	serverGetOrchInfo = func(ctx context.Context, bcast common.Broadcaster, orchestratorServer *url.URL) (*net.OrchestratorInfo, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if fail {
+			return nil, errors.New("unreachable")
+		}
+		return &net.OrchestratorInfo{Transcoder: orchestratorServer.String(), PriceInfo: &net.PriceInfo{PricePerUnit: int64(calls)}}, nil
+	}
+	callCount := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return calls
+	}
+	setFail := func(f bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		fail = f
+	}
+
+	c := newOrchInfoCache()
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	uri := stringsToURIs([]string{"https://127.0.0.1:8936"})[0]
+	refreshing := func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		e, ok := c.entries[uri.String()]
+		return ok && e.refreshing
+	}
+	ttl := 10 * time.Second
+
+	// not cached without a TTL
+	_, err := c.get(context.Background(), nil, uri, 0)
+	require.Nil(err)
+	assert.Empty(c.entries)
+
+	// fetched once, then served from the cache
+	info, err := c.get(context.Background(), nil, uri, ttl)
+	require.Nil(err)
+	assert.Equal(int64(2), info.PriceInfo.PricePerUnit)
+	info, err = c.get(context.Background(), nil, uri, ttl)
+	require.Nil(err)
+	assert.Equal(int64(2), info.PriceInfo.PricePerUnit)
+	assert.Equal(2, callCount())
+
+	// past half of the TTL, served from the cache while refreshed
+	now = now.Add(ttl / 2)
+	info, err = c.get(context.Background(), nil, uri, ttl)
+	require.Nil(err)
+	assert.Equal(int64(2), info.PriceInfo.PricePerUnit)
+	require.Eventually(func() bool { return callCount() == 3 && !refreshing() }, time.Second, 10*time.Millisecond)
+	info, err = c.get(context.Background(), nil, uri, ttl)
+	require.Nil(err)
+	assert.Equal(int64(3), info.PriceInfo.PricePerUnit)
+	assert.Equal(3, callCount())
+
+	// failed refreshes keep the cached response until it expires
+	setFail(true)
+	now = now.Add(ttl / 2)
+	info, err = c.get(context.Background(), nil, uri, ttl)
+	require.Nil(err)
+	assert.Equal(int64(3), info.PriceInfo.PricePerUnit)
+	require.Eventually(func() bool { return callCount() == 4 && !refreshing() }, time.Second, 10*time.Millisecond)
+	now = now.Add(ttl / 2)
+	_, err = c.get(context.Background(), nil, uri, ttl)
+	assert.EqualError(err, "unreachable")
+	assert.Equal(5, callCount())
+
+	// responses of orchestrators no longer asked for are dropped
+	setFail(false)
+	other := stringsToURIs([]string{"https://127.0.0.1:8937"})[0]
+	now = now.Add(2 * ttl)
+	_, err = c.get(context.Background(), nil, other, ttl)
+	require.Nil(err)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	assert.Len(c.entries, 1)
+	assert.Contains(c.entries, other.String())
+}
+
+func TestOrchestratorPool_GetOrchestrators_Cached(t *testing.T) {
+	assert := assert.New(t)
+
+	addresses := stringsToURIs([]string{"https://127.0.0.1:8936", "https://127.0.0.1:8937", "https://127.0.0.1:8938"})
+
+	var mu sync.Mutex
+	calls := 0
+	oldOrchInfo, oldTTL, oldCache := serverGetOrchInfo, OrchInfoCacheTTL, orchInfos
+	defer func() { serverGetOrchInfo, OrchInfoCacheTTL, orchInfos = oldOrchInfo, oldTTL, oldCache }()
+	serverGetOrchInfo = func(ctx context.Context, bcast common.Broadcaster, server *url.URL) (*net.OrchestratorInfo, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		return &net.OrchestratorInfo{Transcoder: server.String()}, nil
+	}
+	OrchInfoCacheTTL = time.Minute
+	orchInfos = newOrchInfoCache()
+
+	pool := NewOrchestratorPool(nil, addresses)
+	res, err := pool.GetOrchestrators(len(addresses), newStubSuspender(), newStubCapabilities())
+	assert.Nil(err)
+	assert.Len(res, len(addresses))
+	assert.Eventually(func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return calls == len(addresses)
+	}, time.Second, 10*time.Millisecond)
+
+	// cached responses are still filtered for each request
+	sus := newStubSuspender()
+	sus.list["https://127.0.0.1:8938"] = 5
+	res, err = pool.GetOrchestrators(2, sus, newStubCapabilities())
+	assert.Nil(err)
+	assert.Len(res, 2)
+	for _, info := range res {
+		assert.NotEqual("https://127.0.0.1:8938", info.Transcoder)
+	}
+	pool.pred = func(info *net.OrchestratorInfo) bool { return info.Transcoder == "https://127.0.0.1:8936" }
+	res, err = pool.GetOrchestrators(len(addresses), newStubSuspender(), newStubCapabilities())
+	assert.Nil(err)
+	assert.Len(res, 1)
+
+	// orchestrators joining the pool are asked right away
+	pool.uris = append(pool.uris, stringsToURIs([]string{"https://127.0.0.1:8939"})...)
+	pool.pred = nil
+	res, err = pool.GetOrchestrators(len(pool.uris), newStubSuspender(), newStubCapabilities())
+	assert.Nil(err)
+	assert.Len(res, len(pool.uris))
+	mu.Lock()
+	assert.Equal(len(pool.uris), calls)
+	mu.Unlock()
+}
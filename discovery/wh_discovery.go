@@ -110,6 +110,7 @@ var getURLsfromWebhook = func(cbUrl *url.URL) ([]byte, error) {
 	}
 	resp, err := httpc.Get(cbUrl.String())
 	if err != nil {
+		err = common.RedactError(err)
 		glog.Error("Unable to make webhook request ", err)
 		return nil, err
 	}
@@ -3,7 +3,6 @@ package drivers
 
 import (
 	"context"
-	"crypto/tls"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -80,6 +79,22 @@ type OSSession interface {
 	ReadData(ctx context.Context, name string) (*FileInfoReader, error)
 }
 
+// StreamSaver is implemented by sessions that can save data straight from a
+// reader without it being buffered in memory first
+type StreamSaver interface {
+	// SaveStream saves the contents of `r`. `size` is the length of the data
+	// or -1 if unknown.
+	SaveStream(name string, r io.Reader, size int64, meta map[string]string) (string, error)
+}
+
+// URLSigner is implemented by sessions that can hand out time limited URLs
+// granting direct read access to stored data, so that it can be served
+// without being proxied through this node
+type URLSigner interface {
+	// SignedURL returns a URL to read `name` that is valid for `expires`
+	SignedURL(name string, expires time.Duration) (string, error)
+}
+
 // NewSession returns new session based on OSInfo received from the network
 func NewSession(info *net.OSInfo) OSSession {
 	if info == nil {
@@ -98,12 +113,18 @@ func GetSegmentData(uri string) ([]byte, error) {
 	return getSegmentDataHTTP(uri)
 }
 
+// GetSegmentReader opens the segment at `uri` for reading along with its
+// length, or -1 if unknown. The caller must close the reader.
+func GetSegmentReader(uri string) (io.ReadCloser, int64, error) {
+	return getSegmentReaderHTTP(uri)
+}
+
 // PrepareOSURL used for resolving files when necessary and turning into a URL. Don't use
 // this when the URL comes from untrusted sources e.g. AuthWebhookUrl.
 func PrepareOSURL(input string) (string, error) {
 	u, err := url.Parse(input)
 	if err != nil {
-		return "", err
+		return "", common.RedactError(err)
 	}
 	if u.Scheme == "gs" {
 		m, err := url.ParseQuery(u.RawQuery)
@@ -129,7 +150,7 @@ func PrepareOSURL(input string) (string, error) {
 func ParseOSURL(input string, useFullAPI bool) (OSDriver, error) {
 	u, err := url.Parse(input)
 	if err != nil {
-		return nil, err
+		return nil, common.RedactError(err)
 	}
 	if u.Scheme == "s3" {
 		pw, ok := u.User.Password()
@@ -179,6 +200,34 @@ func ParseOSURL(input string, useFullAPI bool) (OSDriver, error) {
 	return nil, fmt.Errorf("unrecognized OS scheme: %s", u.Scheme)
 }
 
+// RotatingOS hands out sessions of a driver that may be replaced, eg when the
+// credentials of an object store are rotated. Sessions already started keep
+// the driver they were started with.
+type RotatingOS struct {
+	mu sync.RWMutex
+	os OSDriver
+}
+
+// NewRotatingOS returns a RotatingOS starting with `os`
+func NewRotatingOS(os OSDriver) *RotatingOS {
+	return &RotatingOS{os: os}
+}
+
+// Set replaces the driver used for new sessions
+func (r *RotatingOS) Set(os OSDriver) {
+	r.mu.Lock()
+	r.os = os
+	r.mu.Unlock()
+}
+
+// NewSession starts a session on the current driver
+func (r *RotatingOS) NewSession(path string) OSSession {
+	r.mu.RLock()
+	os := r.os
+	r.mu.RUnlock()
+	return os.NewSession(path)
+}
+
 // SaveRetried tries to SaveData specified number of times
 func SaveRetried(sess OSSession, name string, data []byte, meta map[string]string, retryCount int) (string, error) {
 	if retryCount < 1 {
@@ -196,29 +245,43 @@ func SaveRetried(sess OSSession, name string, data []byte, meta map[string]strin
 }
 
 var httpc = &http.Client{
-	Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+	Transport: common.NewHTTPTransport(common.DefaultTransportConfig),
 	Timeout:   common.HTTPTimeout / 2,
 }
 
+// SetTransportConfig replaces the transport used to download segments
+func SetTransportConfig(cfg common.TransportConfig) {
+	httpc.Transport = common.NewHTTPTransport(cfg)
+}
+
 func getSegmentDataHTTP(uri string) ([]byte, error) {
-	glog.V(common.VERBOSE).Infof("Downloading uri=%s", uri)
 	started := time.Now()
-	resp, err := httpc.Get(uri)
+	body, _, err := getSegmentReaderHTTP(uri)
 	if err != nil {
-		glog.Errorf("Error getting HTTP uri=%s err=%v", uri, err)
 		return nil, err
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != 200 {
-		glog.Errorf("Non-200 response for status=%v uri=%s", resp.Status, uri)
-		return nil, fmt.Errorf(resp.Status)
-	}
-	body, err := ioutil.ReadAll(resp.Body)
+	defer body.Close()
+	data, err := ioutil.ReadAll(body)
 	if err != nil {
 		glog.Errorf("Error reading body uri=%s err=%v", uri, err)
 		return nil, err
 	}
 	took := time.Since(started)
 	glog.V(common.VERBOSE).Infof("Downloaded uri=%s dur=%s", uri, took)
-	return body, nil
+	return data, nil
+}
+
+func getSegmentReaderHTTP(uri string) (io.ReadCloser, int64, error) {
+	glog.V(common.VERBOSE).Infof("Downloading uri=%s", uri)
+	resp, err := httpc.Get(uri)
+	if err != nil {
+		glog.Errorf("Error getting HTTP uri=%s err=%v", uri, err)
+		return nil, 0, err
+	}
+	if resp.StatusCode != 200 {
+		resp.Body.Close()
+		glog.Errorf("Non-200 response for status=%v uri=%s", resp.Status, uri)
+		return nil, 0, fmt.Errorf(resp.Status)
+	}
+	return resp.Body, resp.ContentLength, nil
 }
@@ -84,3 +84,15 @@ func TestGSURL(t *testing.T) {
 	assert.Equal("https://bucket-name.storage.googleapis.com", gs.s3OS.host)
 	assert.Equal("bucket-name", gs.s3OS.bucket)
 }
+
+func TestRotatingOS(t *testing.T) {
+	assert := assert.New(t)
+	first, second := NewMemoryDriver(nil), NewMemoryDriver(nil)
+	r := NewRotatingOS(first)
+	sess := r.NewSession("a")
+	assert.Same(first, sess.OS())
+	r.Set(second)
+	assert.Same(second, r.NewSession("a").OS())
+	// sessions already started keep their driver
+	assert.Same(first, sess.OS())
+}
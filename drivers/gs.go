@@ -317,6 +317,19 @@ func (os *gsSession) ReadData(ctx context.Context, name string) (*FileInfoReader
 	return res, nil
 }
 
+func (os *gsSession) SignedURL(name string, expires time.Duration) (string, error) {
+	if !os.useFullAPI {
+		return "", errors.New("Not implemented")
+	}
+	signer := os.gos.gsSigner
+	return storage.SignedURL(os.bucket, name, &storage.SignedURLOptions{
+		GoogleAccessID: signer.clientEmail(),
+		PrivateKey:     []byte(signer.jsKey.PrivateKey),
+		Method:         "GET",
+		Expires:        time.Now().Add(expires),
+	})
+}
+
 func gsGetFields(sess *s3Session) map[string]string {
 	return map[string]string{
 		"GoogleAccessId": sess.credential,
@@ -5,12 +5,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/url"
 	"path"
 	"strings"
 	"sync"
 
+	"github.com/livepeer/go-livepeer/common"
 	"github.com/livepeer/go-livepeer/net"
 )
 
@@ -20,6 +22,7 @@ type MemoryOS struct {
 	baseURI  *url.URL
 	sessions map[string]*MemorySession
 	lock     sync.RWMutex
+	budget   *common.MemoryBudget
 }
 
 type MemorySession struct {
@@ -28,6 +31,9 @@ type MemorySession struct {
 	ended  bool
 	dCache map[string]*dataCache
 	dLock  sync.RWMutex
+	budget *common.MemoryBudget
+	size   int64  // bytes cached by the session
+	seq    uint64 // insertion order of cached items
 }
 
 func NewMemoryDriver(baseURI *url.URL) *MemoryOS {
@@ -49,11 +55,56 @@ func (ostore *MemoryOS) NewSession(path string) OSSession {
 		path:   path,
 		dCache: make(map[string]*dataCache),
 		dLock:  sync.RWMutex{},
+		budget: ostore.budget,
 	}
 	ostore.sessions[path] = session
 	return session
 }
 
+// SetBudget accounts for the data cached by new sessions against the memory
+// budget. When the budget runs out of room the oldest data of the sessions
+// holding the most memory is evicted.
+func (ostore *MemoryOS) SetBudget(b *common.MemoryBudget) {
+	ostore.lock.Lock()
+	ostore.budget = b
+	ostore.lock.Unlock()
+	b.AddReclaimer(ostore.reclaim)
+}
+
+// reclaim evicts cached data until `need` bytes have been freed, returning
+// the number of bytes freed
+func (ostore *MemoryOS) reclaim(need int64) int64 {
+	ostore.lock.RLock()
+	sessions := make([]*MemorySession, 0, len(ostore.sessions))
+	sizes := make([]int64, 0, len(ostore.sessions))
+	for _, sess := range ostore.sessions {
+		sessions = append(sessions, sess)
+		sizes = append(sizes, sess.usage())
+	}
+	ostore.lock.RUnlock()
+
+	var freed int64
+	for freed < need {
+		largest := -1
+		for i, size := range sizes {
+			if size > 0 && (largest < 0 || size > sizes[largest]) {
+				largest = i
+			}
+		}
+		if largest < 0 {
+			break
+		}
+		n, ok := sessions[largest].evictOldest()
+		if !ok {
+			sizes[largest] = 0
+			continue
+		}
+		sizes[largest] -= n
+		freed += n
+	}
+	return freed
+}
+
 func (ostore *MemoryOS) GetSession(path string) *MemorySession {
 	ostore.lock.Lock()
 	defer ostore.lock.Unlock()
@@ -71,9 +122,13 @@ func (ostore *MemorySession) OS() OSDriver {
 func (ostore *MemorySession) EndSession() {
 	ostore.dLock.Lock()
 	ostore.ended = true
-	for k := range ostore.dCache {
+	var freed int64
+	for k, dc := range ostore.dCache {
+		freed += dc.clear()
 		delete(ostore.dCache, k)
 	}
+	ostore.size -= freed
+	ostore.budget.Release(ostore.path, freed)
 	ostore.dLock.Unlock()
 
 	ostore.os.lock.Lock()
@@ -157,6 +212,26 @@ func (ostore *MemorySession) ReadData(ctx context.Context, name string) (*FileIn
 // - /stream/ + ostore.path + path + file (if ostore.os.baseURI is empty)
 // - ostore.path + path + file
 func (ostore *MemorySession) GetData(name string) []byte {
+	var data []byte
+	ostore.lookup(name, func(dc *dataCache, file string) {
+		data = dc.GetData(file)
+	})
+	return data
+}
+
+// GetBuffer returns the cached data for a name as a segment buffer holding a
+// reference for the caller, or nil if not found. Unlike GetData the memory
+// can be reused once the caller releases the buffer.
+func (ostore *MemorySession) GetBuffer(name string) *common.SegmentBuffer {
+	var buf *common.SegmentBuffer
+	ostore.lookup(name, func(dc *dataCache, file string) {
+		buf = dc.getBuffer(file)
+	})
+	return buf
+}
+
+// lookup calls f with the cache holding the name, if any, under the read lock
+func (ostore *MemorySession) lookup(name string, f func(dc *dataCache, file string)) {
 	// Since the memory cache uses the path as the key for fetching data we make sure that
 	// ostore.os.baseURI and /stream/ are stripped before splitting into a path and a filename
 	prefix := ""
@@ -177,9 +252,8 @@ func (ostore *MemorySession) GetData(name string) []byte {
 		}
 	}
 	if cache, ok := dCache[path]; ok {
-		return cache.GetData(file)
+		f(cache, file)
 	}
-	return nil
 }
 
 func (ostore *MemorySession) IsExternal() bool {
@@ -204,12 +278,83 @@ func (ostore *MemorySession) SaveData(name string, data []byte, meta map[string]
 		return "", fmt.Errorf("Session ended")
 	}
 
-	dc := ostore.getCacheForStream(path)
-	dc.Insert(file, data)
+	ostore.insert(path, dataCacheItem{name: file, data: data})
 
 	return ostore.getAbsoluteURI(name), nil
 }
 
+// SaveBuffer stores the segment buffer without copying its data. The session
+// holds its own reference to the buffer until the data is evicted.
+func (ostore *MemorySession) SaveBuffer(name string, buf *common.SegmentBuffer) (string, error) {
+	path, file := path.Split(ostore.getAbsolutePath(name))
+
+	ostore.dLock.Lock()
+	defer ostore.dLock.Unlock()
+
+	if ostore.ended {
+		return "", fmt.Errorf("Session ended")
+	}
+
+	ostore.insert(path, dataCacheItem{name: file, data: buf.Bytes(), buf: buf.Retain()})
+
+	return ostore.getAbsoluteURI(name), nil
+}
+
+// SaveStream reads the data into a segment buffer that is stored without
+// further copies
+func (ostore *MemorySession) SaveStream(name string, r io.Reader, size int64, meta map[string]string) (string, error) {
+	buf, err := common.ReadSegmentBuffer(r, size)
+	if err != nil {
+		return "", err
+	}
+	defer buf.Release()
+	return ostore.SaveBuffer(name, buf)
+}
+
+// insert caches the item and accounts for the memory it holds. Must be called
+// with dLock held.
+func (ostore *MemorySession) insert(path string, item dataCacheItem) {
+	item.seq = ostore.seq
+	ostore.seq++
+	size := int64(len(item.data))
+	freed := ostore.getCacheForStream(path).insertItem(item)
+	ostore.size += size - freed
+	ostore.budget.Charge(ostore.path, size)
+	ostore.budget.Release(ostore.path, freed)
+}
+
+// evictOldest drops the earliest cached item of the session. Returns the
+// number of bytes freed, and false if there was nothing to evict.
+func (ostore *MemorySession) evictOldest() (int64, bool) {
+	ostore.dLock.Lock()
+	defer ostore.dLock.Unlock()
+	var oldest *dataCache
+	idx := -1
+	for _, dc := range ostore.dCache {
+		for i := range dc.cache {
+			if dc.cache[i].name == "" {
+				continue
+			}
+			if idx < 0 || dc.cache[i].seq < oldest.cache[idx].seq {
+				oldest, idx = dc, i
+			}
+		}
+	}
+	if idx < 0 {
+		return 0, false
+	}
+	freed := oldest.evict(idx)
+	ostore.size -= freed
+	ostore.budget.Release(ostore.path, freed)
+	return freed, true
+}
+
+func (ostore *MemorySession) usage() int64 {
+	ostore.dLock.RLock()
+	defer ostore.dLock.RUnlock()
+	return ostore.size
+}
+
 func (ostore *MemorySession) getCacheForStream(streamID string) *dataCache {
 	sc, ok := ostore.dCache[streamID]
 	if !ok {
@@ -240,6 +385,14 @@ type dataCache struct {
 type dataCacheItem struct {
 	name string
 	data []byte
+	buf  *common.SegmentBuffer // set if data is backed by a segment buffer
+	seq  uint64
+}
+
+func (item *dataCacheItem) release() {
+	if item.buf != nil {
+		item.buf.Release()
+	}
 }
 
 func newDataCache(len int) *dataCache {
@@ -247,30 +400,69 @@ func newDataCache(len int) *dataCache {
 }
 
 func (dc *dataCache) Insert(name string, data []byte) {
+	dc.insertItem(dataCacheItem{name: name, data: data})
+}
+
+// insertItem returns the number of bytes freed by replacing or evicting an
+// existing item
+func (dc *dataCache) insertItem(item dataCacheItem) int64 {
 	// replace existing item
-	for i, item := range dc.cache {
-		if item.name == name {
-			dc.cache[i] = dataCacheItem{name: name, data: data}
-			return
+	for i := range dc.cache {
+		if dc.cache[i].name == item.name {
+			freed := dc.evict(i)
+			dc.cache[i] = item
+			return freed
 		}
 	}
-	dc.cache[dc.nextFree].name = name
-	dc.cache[dc.nextFree].data = data
+	freed := dc.evict(dc.nextFree)
+	dc.cache[dc.nextFree] = item
 	dc.nextFree++
 	if dc.nextFree >= dc.cacheLen {
 		dc.nextFree = 0
 	}
+	return freed
+}
+
+func (dc *dataCache) evict(i int) int64 {
+	freed := int64(len(dc.cache[i].data))
+	dc.cache[i].release()
+	dc.cache[i] = dataCacheItem{}
+	return freed
 }
 
 func (dc *dataCache) GetData(name string) []byte {
 	for _, s := range dc.cache {
 		if s.name == name {
+			if s.buf != nil {
+				// The caller may hold on to the data past eviction
+				s.buf.Escape()
+			}
 			return s.data
 		}
 	}
 	return nil
 }
 
+func (dc *dataCache) getBuffer(name string) *common.SegmentBuffer {
+	for _, s := range dc.cache {
+		if s.name == name {
+			if s.buf != nil {
+				return s.buf.Retain()
+			}
+			return common.NewSegmentBuffer(s.data)
+		}
+	}
+	return nil
+}
+
+func (dc *dataCache) clear() int64 {
+	var freed int64
+	for i := range dc.cache {
+		freed += dc.evict(i)
+	}
+	return freed
+}
+
 type singlePageInfo struct {
 	files       []FileInfo
 	directories []string
@@ -2,10 +2,13 @@ package drivers
 
 import (
 	"fmt"
+	"io"
 	"net/url"
+	"strings"
 	"testing"
 
 	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -62,3 +65,154 @@ func TestLocalOS(t *testing.T) {
 	data = sess.GetData(path)
 	assert.Equal(tempData1, string(data))
 }
+
+func TestLocalOS_SaveBuffer(t *testing.T) {
+	oldDataCacheLen := dataCacheLen
+	dataCacheLen = 1
+	defer func() {
+		dataCacheLen = oldDataCacheLen
+	}()
+	assert := assert.New(t)
+	os := NewMemoryDriver(nil)
+	sess := os.NewSession("sesspath").(*MemorySession)
+
+	buf := common.NewSegmentBuffer(copyBytes("segdata"))
+	path, err := sess.SaveBuffer("name1/1.ts", buf)
+	assert.Nil(err)
+	assert.Equal("/stream/sesspath/name1/1.ts", path)
+	// the session holds its own reference
+	buf.Release()
+	assert.Equal("segdata", string(sess.GetData(path)))
+
+	// eviction releases the session's reference
+	_, err = sess.SaveData("name1/2.ts", copyBytes("other"), nil)
+	assert.Nil(err)
+	assert.Nil(sess.GetData(path))
+	assert.Panics(func() { buf.Release() })
+
+	buf = common.NewSegmentBuffer(copyBytes("segdata"))
+	_, err = sess.SaveBuffer("name1/3.ts", buf)
+	assert.Nil(err)
+	sess.EndSession()
+	buf.Release()
+	assert.Panics(func() { buf.Release() })
+
+	_, err = sess.SaveBuffer("name1/4.ts", common.NewSegmentBuffer(nil))
+	assert.EqualError(err, "Session ended")
+}
+
+func TestLocalOS_SaveStream(t *testing.T) {
+	assert := assert.New(t)
+	os := NewMemoryDriver(nil)
+	sess := os.NewSession("sesspath").(*MemorySession)
+	var _ StreamSaver = sess
+
+	path, err := sess.SaveStream("name1/1.ts", strings.NewReader("segdata"), 7, nil)
+	assert.Nil(err)
+	assert.Equal("/stream/sesspath/name1/1.ts", path)
+	assert.Equal("segdata", string(sess.GetData(path)))
+
+	// unknown length
+	path, err = sess.SaveStream("name1/2.ts", strings.NewReader("moredata"), -1, nil)
+	assert.Nil(err)
+	buf := sess.GetBuffer(path)
+	assert.NotNil(buf)
+	assert.Equal("moredata", string(buf.Bytes()))
+	buf.Release()
+
+	// short reads are errors
+	_, err = sess.SaveStream("name1/3.ts", strings.NewReader("short"), 10, nil)
+	assert.Equal(io.ErrUnexpectedEOF, err)
+	assert.Nil(sess.GetBuffer("/stream/sesspath/name1/3.ts"))
+
+	sess.EndSession()
+	_, err = sess.SaveStream("name1/4.ts", strings.NewReader("segdata"), 7, nil)
+	assert.EqualError(err, "Session ended")
+}
+
+func TestLocalOS_GetBuffer(t *testing.T) {
+	oldDataCacheLen := dataCacheLen
+	dataCacheLen = 1
+	defer func() {
+		dataCacheLen = oldDataCacheLen
+	}()
+	assert := assert.New(t)
+	os := NewMemoryDriver(nil)
+	sess := os.NewSession("sesspath").(*MemorySession)
+
+	assert.Nil(sess.GetBuffer("/stream/sesspath/name1/1.ts"))
+
+	// data saved without a buffer gets wrapped
+	path, err := sess.SaveData("name1/1.ts", copyBytes("segdata"), nil)
+	assert.Nil(err)
+	buf := sess.GetBuffer(path)
+	assert.Equal("segdata", string(buf.Bytes()))
+	buf.Release()
+
+	// the caller's reference keeps the data alive past eviction
+	buf = common.NewSegmentBuffer(copyBytes("buffered"))
+	path, err = sess.SaveBuffer("name1/2.ts", buf)
+	assert.Nil(err)
+	buf.Release()
+	buf = sess.GetBuffer(path)
+	_, err = sess.SaveData("name1/3.ts", copyBytes("other"), nil)
+	assert.Nil(err)
+	assert.Nil(sess.GetBuffer(path))
+	assert.Equal("buffered", string(buf.Bytes()))
+	buf.Release()
+	assert.Panics(func() { buf.Release() })
+}
+
+func TestLocalOS_MemoryBudget(t *testing.T) {
+	oldDataCacheLen := dataCacheLen
+	dataCacheLen = 2
+	defer func() {
+		dataCacheLen = oldDataCacheLen
+	}()
+	assert := assert.New(t)
+	budget := common.NewMemoryBudget(20)
+	os := NewMemoryDriver(nil)
+	os.SetBudget(budget)
+	big := os.NewSession("big").(*MemorySession)
+	small := os.NewSession("small").(*MemorySession)
+
+	// saves are accounted for per session
+	_, err := big.SaveData("P144p/1.ts", copyBytes("12345"), nil)
+	assert.Nil(err)
+	_, err = big.SaveData("P240p/1.ts", copyBytes("123456"), nil)
+	assert.Nil(err)
+	_, err = big.SaveBuffer("P144p/2.ts", common.NewSegmentBuffer(copyBytes("1234567")))
+	assert.Nil(err)
+	_, err = small.SaveData("P144p/1.ts", copyBytes("12"), nil)
+	assert.Nil(err)
+	assert.Equal(int64(18), budget.StreamUsage("big"))
+	assert.Equal(int64(2), budget.StreamUsage("small"))
+
+	// replacing or rotating out of the cache releases the old data
+	_, err = big.SaveData("P144p/1.ts", copyBytes("1"), nil)
+	assert.Nil(err)
+	assert.Equal(int64(14), budget.StreamUsage("big"))
+	_, err = small.SaveData("P144p/2.ts", copyBytes("2"), nil)
+	assert.Nil(err)
+	_, err = small.SaveData("P144p/3.ts", copyBytes("3"), nil)
+	assert.Nil(err)
+	assert.Equal(int64(2), budget.StreamUsage("small"))
+	assert.Nil(small.GetData("small/P144p/1.ts"))
+
+	// reserving past the limit evicts the oldest data of the largest session
+	assert.Nil(budget.Reserve("other", 8))
+	assert.Nil(big.GetData("big/P240p/1.ts"))
+	assert.NotNil(big.GetData("big/P144p/1.ts"))
+	assert.NotNil(big.GetData("big/P144p/2.ts"))
+	assert.Equal(int64(8), budget.StreamUsage("big"))
+	assert.Equal(int64(2), budget.StreamUsage("small"))
+	assert.Equal(int64(18), budget.Used())
+
+	// reserving more than can be reclaimed fails
+	assert.Equal(common.ErrMemoryBudget, budget.Reserve("other", 20))
+
+	small.EndSession()
+	big.EndSession()
+	assert.Equal(int64(8), budget.Used())
+	assert.Equal(int64(0), budget.StreamUsage("big"))
+}
@@ -3,6 +3,7 @@ package drivers
 import (
 	"context"
 	"io/ioutil"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
@@ -87,3 +88,77 @@ func ParallelReadFiles(ctx context.Context, sess OSSession, filesNames []string,
 	}
 	return firs, data, err
 }
+
+// ListResult holds all the pages of a listing
+type ListResult struct {
+	Files       []FileInfo
+	Directories []string
+}
+
+// ParallelListFiles lists the prefixes in parallel, using specified number of
+// jobs. All pages of each listing are fetched. Results are in the order of the
+// prefixes; the first error stops any listings not yet started.
+func ParallelListFiles(ctx context.Context, sess OSSession, prefixes []string, delim string, workers int) ([]ListResult, error) {
+	if len(prefixes) < workers {
+		workers = len(prefixes)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make([]ListResult, len(prefixes))
+	errs := make([]error, len(prefixes))
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range indices {
+				results[idx], errs[idx] = listAllPages(ctx, sess, prefixes[idx], delim)
+				if errs[idx] != nil {
+					cancel()
+				}
+			}
+		}()
+	}
+loop:
+	for i := range prefixes {
+		select {
+		case indices <- i:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	close(indices)
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Listings may have been skipped if the parent context was cancelled
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func listAllPages(ctx context.Context, sess OSSession, prefix, delim string) (ListResult, error) {
+	var res ListResult
+	now := time.Now()
+	page, err := sess.ListFiles(ctx, prefix, delim)
+	if err != nil {
+		return res, err
+	}
+	for {
+		res.Files = append(res.Files, page.Files()...)
+		res.Directories = append(res.Directories, page.Directories()...)
+		if !page.HasNextPage() {
+			break
+		}
+		if page, err = page.NextPage(); err != nil {
+			return res, err
+		}
+	}
+	glog.V(common.VERBOSE).Infof("Listing prefix=%s files=%d directories=%d took=%s", prefix, len(res.Files), len(res.Directories), time.Since(now))
+	return res, nil
+}
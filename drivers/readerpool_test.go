@@ -68,3 +68,91 @@ func TestReaderPoolShouldReadInParallel(t *testing.T) {
 	assert.Equal(fis[1].Name, "f2")
 	assert.Nil(err)
 }
+
+type listPage struct {
+	files []FileInfo
+	dirs  []string
+	next  *listPage
+}
+
+func (p *listPage) Files() []FileInfo     { return p.files }
+func (p *listPage) Directories() []string { return p.dirs }
+func (p *listPage) HasNextPage() bool     { return p.next != nil }
+func (p *listPage) NextPage() (PageInfo, error) {
+	if p.next == nil {
+		return nil, ErrNoNextPage
+	}
+	return p.next, nil
+}
+
+type listOSSession struct {
+	MockOSSession
+	pages   map[string]*listPage
+	started chan string
+	wait    chan struct{}
+}
+
+func (s *listOSSession) ListFiles(ctx context.Context, prefix, delim string) (PageInfo, error) {
+	if s.started != nil {
+		s.started <- prefix
+		select {
+		case <-s.wait:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	if p, ok := s.pages[prefix]; ok {
+		return p, nil
+	}
+	return nil, errors.New("ListFiles error")
+}
+
+func TestParallelListFiles(t *testing.T) {
+	assert := assert.New(t)
+	sess := &listOSSession{pages: map[string]*listPage{
+		"a/": {dirs: []string{"a/x/"}, next: &listPage{dirs: []string{"a/y/"}}},
+		"b/": {files: []FileInfo{{Name: "b/1.json"}}},
+		"c/": {},
+	}}
+
+	res, err := ParallelListFiles(context.Background(), sess, []string{"a/", "b/", "c/"}, "/", 2)
+	assert.Nil(err)
+	assert.Equal([]ListResult{
+		{Directories: []string{"a/x/", "a/y/"}},
+		{Files: []FileInfo{{Name: "b/1.json"}}},
+		{},
+	}, res)
+
+	res, err = ParallelListFiles(context.Background(), sess, nil, "/", 2)
+	assert.Nil(err)
+	assert.Empty(res)
+
+	_, err = ParallelListFiles(context.Background(), sess, []string{"a/", "missing/"}, "/", 2)
+	assert.EqualError(err, "ListFiles error")
+}
+
+func TestParallelListFiles_Concurrent(t *testing.T) {
+	assert := assert.New(t)
+	sess := &listOSSession{
+		pages:   map[string]*listPage{"a/": {}, "b/": {}, "c/": {}},
+		started: make(chan string, 3),
+		wait:    make(chan struct{}),
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := ParallelListFiles(context.Background(), sess, []string{"a/", "b/", "c/"}, "/", 2)
+		done <- err
+	}()
+	// two listings run at once, the third waits for a free worker
+	<-sess.started
+	<-sess.started
+	select {
+	case <-sess.started:
+		t.Error("more listings than workers")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(sess.wait)
+	assert.Nil(<-done)
+	assert.Len(sess.started, 1)
+}
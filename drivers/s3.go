@@ -13,6 +13,7 @@ import (
 	"net/http"
 	"path"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang/glog"
@@ -273,6 +274,17 @@ func (os *s3Session) ReadData(ctx context.Context, name string) (*FileInfoReader
 	return res, nil
 }
 
+func (os *s3Session) SignedURL(name string, expires time.Duration) (string, error) {
+	if os.s3svc == nil {
+		return "", fmt.Errorf("Not implemented")
+	}
+	req, _ := os.s3svc.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(os.bucket),
+		Key:    aws.String(name),
+	})
+	return req.Presign(expires)
+}
+
 func (os *s3Session) saveDataPut(name string, data []byte, meta map[string]string) (string, error) {
 	now := time.Now()
 	bucket := aws.String(os.bucket)
@@ -448,7 +460,7 @@ func createPolicy(key, bucket, region, secret, path string) (string, string, str
 
 func newfileUploadRequest(uri string, params map[string]string, fData io.Reader, fileName string) (*http.Request, context.CancelFunc, error) {
 	glog.Infof("Posting data to %s (params %+v)", uri, params)
-	body := &bytes.Buffer{}
+	body := common.GetBuffer()
 	writer := multipart.NewWriter(body)
 	for key, val := range params {
 		err := writer.WriteField(key, val)
@@ -458,21 +470,41 @@ func newfileUploadRequest(uri string, params map[string]string, fData io.Reader,
 	}
 	part, err := writer.CreateFormFile("file", fileName)
 	if err != nil {
+		common.PutBuffer(body)
 		return nil, nil, err
 	}
 	_, err = io.Copy(part, fData)
 
 	err = writer.Close()
 	if err != nil {
+		common.PutBuffer(body)
 		return nil, nil, err
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), saveTimeout)
-	req, err := http.NewRequestWithContext(ctx, "POST", uri, body)
+	// The transport closes the body once it is done with it, which returns
+	// the buffer to the pool
+	req, err := http.NewRequestWithContext(ctx, "POST", uri, &pooledBody{Reader: bytes.NewReader(body.Bytes()), buf: body})
 	if err != nil {
 		cancel()
+		common.PutBuffer(body)
 		return nil, nil, err
 	}
+	req.ContentLength = int64(body.Len())
 	req.Header.Set("Content-Type", writer.FormDataContentType())
 	return req, cancel, err
 }
+
+// pooledBody is a request body backed by a pooled buffer
+type pooledBody struct {
+	*bytes.Reader
+	buf  *bytes.Buffer
+	once sync.Once
+}
+
+func (b *pooledBody) Close() error {
+	b.once.Do(func() {
+		common.PutBuffer(b.buf)
+	})
+	return nil
+}
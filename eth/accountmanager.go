@@ -125,18 +125,23 @@ func (am *accountManager) CreateTransactOpts(gasLimit uint64, gasPrice *big.Int)
 		return nil, ErrLocked
 	}
 
+	return newTransactOpts(am, gasLimit, gasPrice), nil
+}
+
+func newTransactOpts(am AccountManager, gasLimit uint64, gasPrice *big.Int) *bind.TransactOpts {
+	from := am.Account().Address
 	return &bind.TransactOpts{
-		From:     am.account.Address,
+		From:     from,
 		GasLimit: gasLimit,
 		GasPrice: gasPrice,
 		Signer: func(signer types.Signer, address ethcommon.Address, tx *types.Transaction) (*types.Transaction, error) {
-			if address != am.account.Address {
+			if address != from {
 				return nil, errors.New("not authorized to sign this account")
 			}
 
 			return am.SignTx(tx)
 		},
-	}, nil
+	}
 }
 
 // Sign a transaction. Account must be unlocked
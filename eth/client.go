@@ -150,6 +150,29 @@ type client struct {
 }
 
 func NewClient(accountAddr ethcommon.Address, keystoreDir, password string, eth *ethclient.Client, controllerAddr ethcommon.Address, txTimeout time.Duration, maxGasPrice *big.Int) (LivepeerEthClient, error) {
+	return newClient(eth, controllerAddr, txTimeout, maxGasPrice, func(signer types.Signer) (AccountManager, error) {
+		am, err := NewAccountManager(accountAddr, keystoreDir, signer)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := am.Unlock(password); err != nil {
+			return nil, err
+		}
+		return am, nil
+	})
+}
+
+// NewRemoteSignerClient returns a client that has transactions and messages
+// signed by the signing service listening on `signerSocket`, so that this
+// process needs no access to the keystore
+func NewRemoteSignerClient(signerSocket string, eth *ethclient.Client, controllerAddr ethcommon.Address, txTimeout time.Duration, maxGasPrice *big.Int) (LivepeerEthClient, error) {
+	return newClient(eth, controllerAddr, txTimeout, maxGasPrice, func(signer types.Signer) (AccountManager, error) {
+		return NewRemoteAccountManager(signerSocket, signer)
+	})
+}
+
+func newClient(eth *ethclient.Client, controllerAddr ethcommon.Address, txTimeout time.Duration, maxGasPrice *big.Int, newAccountManager func(types.Signer) (AccountManager, error)) (LivepeerEthClient, error) {
 	chainID, err := eth.ChainID(context.Background())
 	if err != nil {
 		return nil, err
@@ -163,15 +186,11 @@ func NewClient(accountAddr ethcommon.Address, keystoreDir, password string, eth
 	}
 	backend.SetMaxGasPrice(maxGasPrice)
 
-	am, err := NewAccountManager(accountAddr, keystoreDir, signer)
+	am, err := newAccountManager(signer)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := am.Unlock(password); err != nil {
-		return nil, err
-	}
-
 	return &client{
 		accountManager: am,
 		backend:        backend,
@@ -0,0 +1,230 @@
+package eth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common"
+)
+
+// Signing can be split off into its own process so that the process serving
+// media, which faces the network, never holds the keystore or its passphrase.
+// The signing service unlocks the account and answers requests to sign
+// transactions and messages over a unix socket only accessible to the user it
+// runs as; the media process uses a remote account manager that forwards to it.
+
+const maxSignRequestSize = 1 << 20
+
+var errSignerAccountMismatch = errors.New("signing service signed with an unexpected account")
+
+var signerTimeout = 30 * time.Second
+
+type signerAccount struct {
+	Address string `json:"address"`
+}
+
+// ServeSigner serves signing requests for the unlocked account of `am` on the
+// unix socket `socketPath` until `ctx` is done
+func ServeSigner(ctx context.Context, am AccountManager, socketPath string) error {
+	// remove a socket left behind by a previous run
+	if err := os.Remove(socketPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(socketPath, 0600); err != nil {
+		l.Close()
+		return err
+	}
+	srv := &http.Server{Handler: signerHandler(am)}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	glog.Infof("Signing service for ETH account %v listening on %s", am.Account().Address.Hex(), socketPath)
+	if err := srv.Serve(l); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func signerHandler(am AccountManager) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/account", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(signerAccount{Address: am.Account().Address.Hex()})
+	})
+	mux.HandleFunc("/signTx", func(w http.ResponseWriter, r *http.Request) {
+		body, err := readSignRequest(w, r)
+		if err != nil {
+			return
+		}
+		tx := new(types.Transaction)
+		if err := rlp.DecodeBytes(body, tx); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		signed, err := am.SignTx(tx)
+		if err != nil {
+			glog.Errorf("Error signing transaction for signing service client err=%v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		data, err := rlp.EncodeToBytes(signed)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		glog.V(common.VERBOSE).Infof("Signed transaction hash=%v nonce=%d", signed.Hash().Hex(), signed.Nonce())
+		w.Write(data)
+	})
+	mux.HandleFunc("/sign", func(w http.ResponseWriter, r *http.Request) {
+		msg, err := readSignRequest(w, r)
+		if err != nil {
+			return
+		}
+		sig, err := am.Sign(msg)
+		if err != nil {
+			glog.Errorf("Error signing message for signing service client err=%v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(sig)
+	})
+	return mux
+}
+
+func readSignRequest(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	if r.Method != "POST" {
+		http.Error(w, "POST required", http.StatusMethodNotAllowed)
+		return nil, errors.New("bad method")
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxSignRequestSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, err
+	}
+	return body, nil
+}
+
+// remoteAccountManager signs with the account of a signing service
+type remoteAccountManager struct {
+	account accounts.Account
+	signer  types.Signer
+	client  *http.Client
+}
+
+// NewRemoteAccountManager returns an account manager that delegates signing
+// to the signing service listening on the unix socket `socketPath`. The
+// service unlocks the account itself, so Unlock and Lock do nothing.
+func NewRemoteAccountManager(socketPath string, signer types.Signer) (AccountManager, error) {
+	am := &remoteAccountManager{
+		signer: signer,
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socketPath)
+				},
+			},
+			Timeout: signerTimeout,
+		},
+	}
+	resp, err := am.client.Get("http://signer/account")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var acct signerAccount
+	if err := json.NewDecoder(resp.Body).Decode(&acct); err != nil {
+		return nil, err
+	}
+	if !ethcommon.IsHexAddress(acct.Address) {
+		return nil, fmt.Errorf("signing service returned invalid account %q", acct.Address)
+	}
+	am.account = accounts.Account{Address: ethcommon.HexToAddress(acct.Address)}
+	glog.Infof("Using Ethereum account of signing service: %v", am.account.Address.Hex())
+	return am, nil
+}
+
+func (am *remoteAccountManager) post(path string, data []byte) ([]byte, error) {
+	resp, err := am.client.Post("http://signer"+path, "application/octet-stream", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("signing service status=%d error=%s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
+
+func (am *remoteAccountManager) Unlock(passphrase string) error {
+	return nil
+}
+
+func (am *remoteAccountManager) Lock() error {
+	return nil
+}
+
+func (am *remoteAccountManager) CreateTransactOpts(gasLimit uint64, gasPrice *big.Int) (*bind.TransactOpts, error) {
+	return newTransactOpts(am, gasLimit, gasPrice), nil
+}
+
+// SignTx has the signing service sign `tx` and checks that it was signed by
+// the expected account for this chain
+func (am *remoteAccountManager) SignTx(tx *types.Transaction) (*types.Transaction, error) {
+	data, err := rlp.EncodeToBytes(tx)
+	if err != nil {
+		return nil, err
+	}
+	data, err = am.post("/signTx", data)
+	if err != nil {
+		return nil, err
+	}
+	signed := new(types.Transaction)
+	if err := rlp.DecodeBytes(data, signed); err != nil {
+		return nil, err
+	}
+	if am.signer.Hash(signed) != am.signer.Hash(tx) {
+		return nil, errors.New("signing service returned a different transaction")
+	}
+	sender, err := types.Sender(am.signer, signed)
+	if err != nil {
+		return nil, err
+	}
+	if sender != am.account.Address {
+		return nil, errSignerAccountMismatch
+	}
+	return signed, nil
+}
+
+func (am *remoteAccountManager) Sign(msg []byte) ([]byte, error) {
+	return am.post("/sign", msg)
+}
+
+func (am *remoteAccountManager) Account() accounts.Account {
+	return am.account
+}
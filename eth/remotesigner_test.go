@@ -0,0 +1,84 @@
+package eth
+
+import (
+	"context"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/livepeer/go-livepeer/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteSigner(t *testing.T) {
+	require := require.New(t)
+	assert := assert.New(t)
+
+	dir, ks := tmpKeyStore(t, true)
+	defer os.RemoveAll(dir)
+
+	a, err := ks.NewAccount("")
+	require.Nil(err)
+	signer := types.NewEIP155Signer(big.NewInt(1337))
+	am, err := NewAccountManager(a.Address, dir, signer)
+	require.Nil(err)
+	require.Nil(am.Unlock(""))
+
+	socket := filepath.Join(dir, "signer.sock")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() { served <- ServeSigner(ctx, am, socket) }()
+
+	var remote AccountManager
+	for i := 0; i < 100; i++ {
+		if remote, err = NewRemoteAccountManager(socket, signer); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.Nil(err)
+	assert.Equal(a.Address, remote.Account().Address)
+
+	// only the user running the signing service may connect
+	info, err := os.Stat(socket)
+	require.Nil(err)
+	assert.Equal(os.FileMode(0600), info.Mode().Perm())
+
+	sig, err := remote.Sign([]byte("foo"))
+	require.Nil(err)
+	assert.True(crypto.VerifySig(a.Address, []byte("foo"), sig))
+
+	tx := types.NewTransaction(1, ethcommon.Address{1}, big.NewInt(2), 21000, big.NewInt(3), []byte("data"))
+	opts, err := remote.CreateTransactOpts(0, nil)
+	require.Nil(err)
+	assert.Equal(a.Address, opts.From)
+	signed, err := opts.Signer(signer, a.Address, tx)
+	require.Nil(err)
+	sender, err := types.Sender(signer, signed)
+	require.Nil(err)
+	assert.Equal(a.Address, sender)
+	_, err = opts.Signer(signer, ethcommon.Address{2}, tx)
+	assert.NotNil(err)
+
+	// a signing service for another chain is caught
+	other, err := NewRemoteAccountManager(socket, types.NewEIP155Signer(big.NewInt(1)))
+	require.Nil(err)
+	_, err = other.SignTx(tx)
+	assert.NotNil(err)
+
+	// errors of the signing service are passed on
+	require.Nil(am.Lock())
+	_, err = remote.Sign([]byte("foo"))
+	assert.Contains(err.Error(), "status=500")
+
+	cancel()
+	assert.Nil(<-served)
+	_, err = remote.Sign([]byte("foo"))
+	assert.NotNil(err)
+}
@@ -90,6 +90,9 @@ type (
 		kRecipient                    tag.Key
 		kManifestID                   tag.Key
 		kSegmentType                  tag.Key
+		kErrorCategory                tag.Key
+		kRetryable                    tag.Key
+		kRestreamTarget               tag.Key
 		mSegmentSourceAppeared        *stats.Int64Measure
 		mSegmentEmerged               *stats.Int64Measure
 		mSegmentEmergedUnprocessed    *stats.Int64Measure
@@ -122,6 +125,12 @@ type (
 		mAuthWebhookTime              *stats.Float64Measure
 		mSourceSegmentDuration        *stats.Float64Measure
 		mHTTPClientTimeout1           *stats.Int64Measure
+		mHTTPPushFailed               *stats.Int64Measure
+		mHTTPPushRejected             *stats.Int64Measure
+		mRestreamConnects             *stats.Int64Measure
+		mRestreamFailures             *stats.Int64Measure
+		mRestreamBytes                *stats.Int64Measure
+		mRestreamDropped              *stats.Int64Measure
 		mHTTPClientTimeout2           *stats.Int64Measure
 		mRealtime3x                   *stats.Int64Measure
 		mRealtime2x                   *stats.Int64Measure
@@ -207,11 +216,20 @@ func InitCensus(nodeType NodeType, version string) {
 	census.kRecipient = tag.MustNewKey("recipient")
 	census.kManifestID = tag.MustNewKey("manifestID")
 	census.kSegmentType = tag.MustNewKey("seg_type")
+	census.kErrorCategory = tag.MustNewKey("error_category")
+	census.kRetryable = tag.MustNewKey("retryable")
+	census.kRestreamTarget = tag.MustNewKey("restream_target")
 	census.ctx, err = tag.New(ctx, tag.Insert(census.kNodeType, string(nodeType)), tag.Insert(census.kNodeID, NodeID))
 	if err != nil {
 		glog.Fatal("Error creating context", err)
 	}
 	census.mHTTPClientTimeout1 = stats.Int64("http_client_timeout_1", "Number of times HTTP connection was dropped before transcoding complete", "tot")
+	census.mHTTPPushFailed = stats.Int64("http_push_failed_total", "Number of HTTP push requests that failed", "tot")
+	census.mHTTPPushRejected = stats.Int64("http_push_rejected_total", "Number of HTTP push requests rejected for an empty or malformed segment", "tot")
+	census.mRestreamConnects = stats.Int64("restream_connects_total", "Number of times streams were connected to their restream targets", "tot")
+	census.mRestreamFailures = stats.Int64("restream_failures_total", "Number of times pushes to restream targets failed", "tot")
+	census.mRestreamBytes = stats.Int64("restream_bytes_sent", "Bytes pushed to restream targets", "By")
+	census.mRestreamDropped = stats.Int64("restream_segments_dropped_total", "Number of segments dropped for restream targets too slow to keep up", "tot")
 	census.mHTTPClientTimeout2 = stats.Int64("http_client_timeout_2", "Number of times HTTP connection was dropped before transcoded segments was sent back to client", "tot")
 	census.mRealtime3x = stats.Int64("http_client_segment_transcoded_realtime_3x", "Number of segment transcoded 3x faster than realtime", "tot")
 	census.mRealtime2x = stats.Int64("http_client_segment_transcoded_realtime_2x", "Number of segment transcoded 2x faster than realtime", "tot")
@@ -334,6 +352,48 @@ func InitCensus(nodeType NodeType, version string) {
 			TagKeys:     baseTags,
 			Aggregation: view.Count(),
 		},
+		{
+			Name:        "http_push_failed_total",
+			Measure:     census.mHTTPPushFailed,
+			Description: "Number of HTTP push requests that failed",
+			TagKeys:     append([]tag.Key{census.kErrorCategory, census.kRetryable}, baseTags...),
+			Aggregation: view.Count(),
+		},
+		{
+			Name:        "http_push_rejected_total",
+			Measure:     census.mHTTPPushRejected,
+			Description: "Number of HTTP push requests rejected for an empty or malformed segment",
+			TagKeys:     append([]tag.Key{census.kErrorCode}, baseTags...),
+			Aggregation: view.Count(),
+		},
+		{
+			Name:        "restream_connects_total",
+			Measure:     census.mRestreamConnects,
+			Description: "Number of times streams were connected to their restream targets",
+			TagKeys:     append([]tag.Key{census.kManifestID, census.kRestreamTarget, census.kProfile}, baseTags...),
+			Aggregation: view.Count(),
+		},
+		{
+			Name:        "restream_failures_total",
+			Measure:     census.mRestreamFailures,
+			Description: "Number of times pushes to restream targets failed",
+			TagKeys:     append([]tag.Key{census.kManifestID, census.kRestreamTarget, census.kProfile}, baseTags...),
+			Aggregation: view.Count(),
+		},
+		{
+			Name:        "restream_bytes_sent",
+			Measure:     census.mRestreamBytes,
+			Description: "Bytes pushed to restream targets",
+			TagKeys:     append([]tag.Key{census.kManifestID, census.kRestreamTarget, census.kProfile}, baseTags...),
+			Aggregation: view.Sum(),
+		},
+		{
+			Name:        "restream_segments_dropped_total",
+			Measure:     census.mRestreamDropped,
+			Description: "Number of segments dropped for restream targets too slow to keep up",
+			TagKeys:     append([]tag.Key{census.kManifestID, census.kRestreamTarget, census.kProfile}, baseTags...),
+			Aggregation: view.Count(),
+		},
 		{
 			Name:        "http_client_timeout_1",
 			Measure:     census.mHTTPClientTimeout1,
@@ -1013,6 +1073,58 @@ func (cen *censusMetricsCounter) segmentDownloaded(nonce, seqNo uint64, download
 	stats.Record(cen.ctx, cen.mSegmentDownloaded.M(1), cen.mDownloadTime.M(downloadDur.Seconds()))
 }
 
+// HTTPPushFailed records a failed HTTP push request by the category of its
+// error and whether it may be retried
+func HTTPPushFailed(category string, retryable bool) {
+	ctx, err := tag.New(census.ctx, tag.Insert(census.kErrorCategory, category), tag.Insert(census.kRetryable, strconv.FormatBool(retryable)))
+	if err != nil {
+		glog.Error("Error creating context", err)
+		return
+	}
+	stats.Record(ctx, census.mHTTPPushFailed.M(1))
+}
+
+// HTTPPushRejected records an HTTP push request rejected for its segment
+// being empty, truncated or corrupt
+func HTTPPushRejected(reason string) {
+	ctx, err := tag.New(census.ctx, tag.Insert(census.kErrorCode, reason))
+	if err != nil {
+		glog.Error("Error creating context", err)
+		return
+	}
+	stats.Record(ctx, census.mHTTPPushRejected.M(1))
+}
+
+// RestreamConnected records a connection of the stream `manifestID` to the
+// restream target at `target`, pushing the rendition `profile`
+func RestreamConnected(manifestID, target, profile string) {
+	recordRestream(manifestID, target, profile, census.mRestreamConnects.M(1))
+}
+
+// RestreamFailed records a failed push to a restream target
+func RestreamFailed(manifestID, target, profile string) {
+	recordRestream(manifestID, target, profile, census.mRestreamFailures.M(1))
+}
+
+// RestreamBytesSent records bytes pushed to a restream target
+func RestreamBytesSent(manifestID, target, profile string, bytes int64) {
+	recordRestream(manifestID, target, profile, census.mRestreamBytes.M(bytes))
+}
+
+// RestreamSegmentDropped records a segment dropped for a restream target
+func RestreamSegmentDropped(manifestID, target, profile string) {
+	recordRestream(manifestID, target, profile, census.mRestreamDropped.M(1))
+}
+
+func recordRestream(manifestID, target, profile string, m stats.Measurement) {
+	ctx, err := tag.New(census.ctx, tag.Insert(census.kManifestID, manifestID), tag.Insert(census.kRestreamTarget, target), tag.Insert(census.kProfile, profile))
+	if err != nil {
+		glog.Error("Error creating context", err)
+		return
+	}
+	stats.Record(ctx, m)
+}
+
 func HTTPClientTimedOut1() {
 	stats.Record(census.ctx, census.mHTTPClientTimeout1.M(1))
 }
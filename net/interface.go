@@ -12,6 +12,17 @@ type RemoteTranscoderInfo struct {
 type StreamInfo struct {
 	SourceBytes     uint64
 	TranscodedBytes uint64
+	MemoryBytes     int64 // held in memory against the memory budget
+	SegmentsQueued  int   // waiting for a segment worker
+	// SourceWarnings are problems found with the encoder of the source
+	SourceWarnings []string
+	// AVDrift is how far, in seconds, the audio of the source has drifted
+	// from its video since the start of the stream
+	AVDrift float64
+	// Priority class of the stream
+	Priority string
+	// Labels the stream was given by the auth webhook or over the CLI
+	Labels map[string]string
 }
 
 type NodeStatus struct {
@@ -28,5 +39,9 @@ type NodeStatus struct {
 	RegisteredTranscodersNumber int
 	RegisteredTranscoders       []RemoteTranscoderInfo
 	LocalTranscoding            bool // Indicates orchestrator that is also transcoder
+	MemoryUsed                  int64
+	MemoryLimit                 int64 // 0 if there is no memory budget
+	SegmentsQueued              int
+	SegmentWorkersBusy          int
 	// xxx add transcoder's version here
 }
@@ -112,6 +112,34 @@ func (VideoProfile_Profile) EnumDescriptor() ([]byte, []int) {
 	return fileDescriptor_034e29c79f9ba827, []int{9, 1}
 }
 
+type OrchestratorNotification_Type int32
+
+const (
+	OrchestratorNotification_PRICE_CHANGE        OrchestratorNotification_Type = 0
+	OrchestratorNotification_CAPABILITIES_CHANGE OrchestratorNotification_Type = 1
+	OrchestratorNotification_DRAIN               OrchestratorNotification_Type = 2
+)
+
+var OrchestratorNotification_Type_name = map[int32]string{
+	0: "PRICE_CHANGE",
+	1: "CAPABILITIES_CHANGE",
+	2: "DRAIN",
+}
+
+var OrchestratorNotification_Type_value = map[string]int32{
+	"PRICE_CHANGE":        0,
+	"CAPABILITIES_CHANGE": 1,
+	"DRAIN":               2,
+}
+
+func (x OrchestratorNotification_Type) String() string {
+	return proto.EnumName(OrchestratorNotification_Type_name, int32(x))
+}
+
+func (OrchestratorNotification_Type) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_034e29c79f9ba827, []int{19, 0}
+}
+
 type PingPong struct {
 	// Implementation defined
 	Value                []byte   `protobuf:"bytes,1,opt,name=value,proto3" json:"value,omitempty"`
@@ -158,10 +186,13 @@ type OrchestratorRequest struct {
 	// Ethereum address of the broadcaster
 	Address []byte `protobuf:"bytes,1,opt,name=address,proto3" json:"address,omitempty"`
 	// Broadcaster's signature over its address
-	Sig                  []byte   `protobuf:"bytes,2,opt,name=sig,proto3" json:"sig,omitempty"`
-	XXX_NoUnkeyedLiteral struct{} `json:"-"`
-	XXX_unrecognized     []byte   `json:"-"`
-	XXX_sizecache        int32    `json:"-"`
+	Sig []byte `protobuf:"bytes,2,opt,name=sig,proto3" json:"sig,omitempty"`
+	// Auth token of the session the broadcaster wants to continue, if any,
+	// so that its segments keep their transcode contexts
+	AuthToken            *AuthToken `protobuf:"bytes,3,opt,name=auth_token,json=authToken,proto3" json:"auth_token,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}   `json:"-"`
+	XXX_unrecognized     []byte     `json:"-"`
+	XXX_sizecache        int32      `json:"-"`
 }
 
 func (m *OrchestratorRequest) Reset()         { *m = OrchestratorRequest{} }
@@ -203,6 +234,13 @@ func (m *OrchestratorRequest) GetSig() []byte {
 	return nil
 }
 
+func (m *OrchestratorRequest) GetAuthToken() *AuthToken {
+	if m != nil {
+		return m.AuthToken
+	}
+	return nil
+}
+
 //
 //OSInfo needed to negotiate storages that will be used.
 //It carries info needed to write to the storage.
@@ -394,7 +432,10 @@ type Capabilities struct {
 	// Bit string of supported features - one bit per feature
 	Bitstring []uint64 `protobuf:"varint,1,rep,packed,name=bitstring,proto3" json:"bitstring,omitempty"`
 	// Bit string of features that are required to be supported
-	Mandatories          []uint64 `protobuf:"varint,2,rep,packed,name=mandatories,proto3" json:"mandatories,omitempty"`
+	Mandatories []uint64 `protobuf:"varint,2,rep,packed,name=mandatories,proto3" json:"mandatories,omitempty"`
+	// Version of the list of capabilities known to the node. Capabilities
+	// from the version on are unknown to the node rather than unsupported.
+	Version              uint32   `protobuf:"varint,3,opt,name=version,proto3" json:"version,omitempty"`
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
 	XXX_unrecognized     []byte   `json:"-"`
 	XXX_sizecache        int32    `json:"-"`
@@ -439,6 +480,13 @@ func (m *Capabilities) GetMandatories() []uint64 {
 	return nil
 }
 
+func (m *Capabilities) GetVersion() uint32 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
 // Non-binary capability constraints, such as supported ranges.
 type Capabilities_Constraints struct {
 	XXX_NoUnkeyedLiteral struct{} `json:"-"`
@@ -486,6 +534,10 @@ type OrchestratorInfo struct {
 	Capabilities *Capabilities `protobuf:"bytes,5,opt,name=capabilities,proto3" json:"capabilities,omitempty"`
 	// Data for transcoding authentication
 	AuthToken *AuthToken `protobuf:"bytes,6,opt,name=auth_token,json=authToken,proto3" json:"auth_token,omitempty"`
+	// Upcoming maintenance windows of the orchestrator
+	MaintenanceWindows []*OrchestratorInfo_MaintenanceWindow `protobuf:"bytes,7,rep,name=maintenance_windows,json=maintenanceWindows,proto3" json:"maintenance_windows,omitempty"`
+	// X25519 public key of the session, for broadcasters to encrypt segments to
+	SegmentKey []byte `protobuf:"bytes,8,opt,name=segment_key,json=segmentKey,proto3" json:"segment_key,omitempty"`
 	// Orchestrator returns info about own input object storage, if it wants it to be used.
 	Storage              []*OSInfo `protobuf:"bytes,32,rep,name=storage,proto3" json:"storage,omitempty"`
 	XXX_NoUnkeyedLiteral struct{}  `json:"-"`
@@ -560,6 +612,20 @@ func (m *OrchestratorInfo) GetAuthToken() *AuthToken {
 	return nil
 }
 
+func (m *OrchestratorInfo) GetMaintenanceWindows() []*OrchestratorInfo_MaintenanceWindow {
+	if m != nil {
+		return m.MaintenanceWindows
+	}
+	return nil
+}
+
+func (m *OrchestratorInfo) GetSegmentKey() []byte {
+	if m != nil {
+		return m.SegmentKey
+	}
+	return nil
+}
+
 func (m *OrchestratorInfo) GetStorage() []*OSInfo {
 	if m != nil {
 		return m.Storage
@@ -567,6 +633,56 @@ func (m *OrchestratorInfo) GetStorage() []*OSInfo {
 	return nil
 }
 
+// Period during which the orchestrator is not expected to transcode
+type OrchestratorInfo_MaintenanceWindow struct {
+	// Unix timestamp (seconds) at which the window starts
+	Start int64 `protobuf:"varint,1,opt,name=start,proto3" json:"start,omitempty"`
+	// Unix timestamp (seconds) at which the window ends
+	End                  int64    `protobuf:"varint,2,opt,name=end,proto3" json:"end,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *OrchestratorInfo_MaintenanceWindow) Reset()         { *m = OrchestratorInfo_MaintenanceWindow{} }
+func (m *OrchestratorInfo_MaintenanceWindow) String() string { return proto.CompactTextString(m) }
+func (*OrchestratorInfo_MaintenanceWindow) ProtoMessage()    {}
+func (*OrchestratorInfo_MaintenanceWindow) Descriptor() ([]byte, []int) {
+	return fileDescriptor_034e29c79f9ba827, []int{6, 0}
+}
+
+func (m *OrchestratorInfo_MaintenanceWindow) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_OrchestratorInfo_MaintenanceWindow.Unmarshal(m, b)
+}
+func (m *OrchestratorInfo_MaintenanceWindow) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_OrchestratorInfo_MaintenanceWindow.Marshal(b, m, deterministic)
+}
+func (m *OrchestratorInfo_MaintenanceWindow) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_OrchestratorInfo_MaintenanceWindow.Merge(m, src)
+}
+func (m *OrchestratorInfo_MaintenanceWindow) XXX_Size() int {
+	return xxx_messageInfo_OrchestratorInfo_MaintenanceWindow.Size(m)
+}
+func (m *OrchestratorInfo_MaintenanceWindow) XXX_DiscardUnknown() {
+	xxx_messageInfo_OrchestratorInfo_MaintenanceWindow.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_OrchestratorInfo_MaintenanceWindow proto.InternalMessageInfo
+
+func (m *OrchestratorInfo_MaintenanceWindow) GetStart() int64 {
+	if m != nil {
+		return m.Start
+	}
+	return 0
+}
+
+func (m *OrchestratorInfo_MaintenanceWindow) GetEnd() int64 {
+	if m != nil {
+		return m.End
+	}
+	return 0
+}
+
 // Data for transcoding authentication that is included in the OrchestratorInfo message during discovery
 type AuthToken struct {
 	// Record used to authenticate for a transcode session
@@ -646,6 +762,12 @@ type SegData struct {
 	Capabilities *Capabilities `protobuf:"bytes,7,opt,name=capabilities,proto3" json:"capabilities,omitempty"`
 	// Data for transcoding authentication
 	AuthToken *AuthToken `protobuf:"bytes,8,opt,name=auth_token,json=authToken,proto3" json:"auth_token,omitempty"`
+	// Metadata of the stream, such as viewer or epoch IDs, logged with the
+	// transcode of the segment
+	Metadata map[string]string `protobuf:"bytes,9,rep,name=metadata,proto3" json:"metadata,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	// X25519 public key of the broadcaster the segment data is encrypted with,
+	// if it is encrypted
+	SegmentKey []byte `protobuf:"bytes,10,opt,name=segment_key,json=segmentKey,proto3" json:"segment_key,omitempty"`
 	// Broadcaster's preferred storage medium(s)
 	// XXX should we include this in a sig somewhere until certs are authenticated?
 	Storage []*OSInfo `protobuf:"bytes,32,rep,name=storage,proto3" json:"storage,omitempty"`
@@ -743,6 +865,20 @@ func (m *SegData) GetAuthToken() *AuthToken {
 	return nil
 }
 
+func (m *SegData) GetMetadata() map[string]string {
+	if m != nil {
+		return m.Metadata
+	}
+	return nil
+}
+
+func (m *SegData) GetSegmentKey() []byte {
+	if m != nil {
+		return m.SegmentKey
+	}
+	return nil
+}
+
 func (m *SegData) GetStorage() []*OSInfo {
 	if m != nil {
 		return m.Storage
@@ -887,10 +1023,11 @@ type TranscodedSegmentData struct {
 	// URL where the transcoded data can be downloaded from.
 	Url string `protobuf:"bytes,1,opt,name=url,proto3" json:"url,omitempty"`
 	// Amount of pixels processed (output pixels)
-	Pixels               int64    `protobuf:"varint,2,opt,name=pixels,proto3" json:"pixels,omitempty"`
-	XXX_NoUnkeyedLiteral struct{} `json:"-"`
-	XXX_unrecognized     []byte   `json:"-"`
-	XXX_sizecache        int32    `json:"-"`
+	Pixels               int64                                  `protobuf:"varint,2,opt,name=pixels,proto3" json:"pixels,omitempty"`
+	EncoderSettings      *TranscodedSegmentData_EncoderSettings `protobuf:"bytes,3,opt,name=encoder_settings,json=encoderSettings,proto3" json:"encoder_settings,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}                               `json:"-"`
+	XXX_unrecognized     []byte                                 `json:"-"`
+	XXX_sizecache        int32                                  `json:"-"`
 }
 
 func (m *TranscodedSegmentData) Reset()         { *m = TranscodedSegmentData{} }
@@ -932,6 +1069,117 @@ func (m *TranscodedSegmentData) GetPixels() int64 {
 	return 0
 }
 
+func (m *TranscodedSegmentData) GetEncoderSettings() *TranscodedSegmentData_EncoderSettings {
+	if m != nil {
+		return m.EncoderSettings
+	}
+	return nil
+}
+
+// Settings the encoder was configured with to produce the segment
+type TranscodedSegmentData_EncoderSettings struct {
+	// Encoder, such as libx264 or h264_nvenc
+	Codec string `protobuf:"bytes,1,opt,name=codec,proto3" json:"codec,omitempty"`
+	// Codec profile, empty for the encoder default
+	Profile string `protobuf:"bytes,2,opt,name=profile,proto3" json:"profile,omitempty"`
+	// Encoder preset
+	Preset string `protobuf:"bytes,3,opt,name=preset,proto3" json:"preset,omitempty"`
+	// Rate control of the encoder, such as cbr
+	BitrateMode string `protobuf:"bytes,4,opt,name=bitrate_mode,json=bitrateMode,proto3" json:"bitrate_mode,omitempty"`
+	// Target bitrate, as in the requested profile
+	Bitrate string `protobuf:"bytes,5,opt,name=bitrate,proto3" json:"bitrate,omitempty"`
+	// Output resolution, as WxH
+	Resolution string `protobuf:"bytes,6,opt,name=resolution,proto3" json:"resolution,omitempty"`
+	// Output frame rate, as a number or a fraction; empty if passed through
+	Framerate string `protobuf:"bytes,7,opt,name=framerate,proto3" json:"framerate,omitempty"`
+	// GOP length, as a duration or "intra"; empty for the encoder default
+	Gop                  string   `protobuf:"bytes,8,opt,name=gop,proto3" json:"gop,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *TranscodedSegmentData_EncoderSettings) Reset()         { *m = TranscodedSegmentData_EncoderSettings{} }
+func (m *TranscodedSegmentData_EncoderSettings) String() string { return proto.CompactTextString(m) }
+func (*TranscodedSegmentData_EncoderSettings) ProtoMessage()    {}
+func (*TranscodedSegmentData_EncoderSettings) Descriptor() ([]byte, []int) {
+	return fileDescriptor_034e29c79f9ba827, []int{10, 0}
+}
+
+func (m *TranscodedSegmentData_EncoderSettings) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_TranscodedSegmentData_EncoderSettings.Unmarshal(m, b)
+}
+func (m *TranscodedSegmentData_EncoderSettings) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_TranscodedSegmentData_EncoderSettings.Marshal(b, m, deterministic)
+}
+func (m *TranscodedSegmentData_EncoderSettings) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_TranscodedSegmentData_EncoderSettings.Merge(m, src)
+}
+func (m *TranscodedSegmentData_EncoderSettings) XXX_Size() int {
+	return xxx_messageInfo_TranscodedSegmentData_EncoderSettings.Size(m)
+}
+func (m *TranscodedSegmentData_EncoderSettings) XXX_DiscardUnknown() {
+	xxx_messageInfo_TranscodedSegmentData_EncoderSettings.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_TranscodedSegmentData_EncoderSettings proto.InternalMessageInfo
+
+func (m *TranscodedSegmentData_EncoderSettings) GetCodec() string {
+	if m != nil {
+		return m.Codec
+	}
+	return ""
+}
+
+func (m *TranscodedSegmentData_EncoderSettings) GetProfile() string {
+	if m != nil {
+		return m.Profile
+	}
+	return ""
+}
+
+func (m *TranscodedSegmentData_EncoderSettings) GetPreset() string {
+	if m != nil {
+		return m.Preset
+	}
+	return ""
+}
+
+func (m *TranscodedSegmentData_EncoderSettings) GetBitrateMode() string {
+	if m != nil {
+		return m.BitrateMode
+	}
+	return ""
+}
+
+func (m *TranscodedSegmentData_EncoderSettings) GetBitrate() string {
+	if m != nil {
+		return m.Bitrate
+	}
+	return ""
+}
+
+func (m *TranscodedSegmentData_EncoderSettings) GetResolution() string {
+	if m != nil {
+		return m.Resolution
+	}
+	return ""
+}
+
+func (m *TranscodedSegmentData_EncoderSettings) GetFramerate() string {
+	if m != nil {
+		return m.Framerate
+	}
+	return ""
+}
+
+func (m *TranscodedSegmentData_EncoderSettings) GetGop() string {
+	if m != nil {
+		return m.Gop
+	}
+	return ""
+}
+
 // A set of transcoded segments following the profiles specified in the job.
 type TranscodeData struct {
 	// Transcoded data, in the order specified in the job options
@@ -1479,10 +1727,70 @@ func (m *Payment) GetExpectedPrice() *PriceInfo {
 	return nil
 }
 
+// Sent by the orchestrator to subscribed broadcasters when something that
+// affects orchestrator selection is about to change.
+type OrchestratorNotification struct {
+	Type OrchestratorNotification_Type `protobuf:"varint,1,opt,name=type,proto3,enum=net.OrchestratorNotification_Type" json:"type,omitempty"`
+	// Updated orchestrator info for the subscribed broadcaster, if applicable
+	Info *OrchestratorInfo `protobuf:"bytes,2,opt,name=info,proto3" json:"info,omitempty"`
+	// Unix timestamp (seconds) at which the change takes effect
+	EffectiveAt          int64    `protobuf:"varint,3,opt,name=effective_at,json=effectiveAt,proto3" json:"effective_at,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *OrchestratorNotification) Reset()         { *m = OrchestratorNotification{} }
+func (m *OrchestratorNotification) String() string { return proto.CompactTextString(m) }
+func (*OrchestratorNotification) ProtoMessage()    {}
+func (*OrchestratorNotification) Descriptor() ([]byte, []int) {
+	return fileDescriptor_034e29c79f9ba827, []int{19}
+}
+
+func (m *OrchestratorNotification) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_OrchestratorNotification.Unmarshal(m, b)
+}
+func (m *OrchestratorNotification) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_OrchestratorNotification.Marshal(b, m, deterministic)
+}
+func (m *OrchestratorNotification) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_OrchestratorNotification.Merge(m, src)
+}
+func (m *OrchestratorNotification) XXX_Size() int {
+	return xxx_messageInfo_OrchestratorNotification.Size(m)
+}
+func (m *OrchestratorNotification) XXX_DiscardUnknown() {
+	xxx_messageInfo_OrchestratorNotification.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_OrchestratorNotification proto.InternalMessageInfo
+
+func (m *OrchestratorNotification) GetType() OrchestratorNotification_Type {
+	if m != nil {
+		return m.Type
+	}
+	return OrchestratorNotification_PRICE_CHANGE
+}
+
+func (m *OrchestratorNotification) GetInfo() *OrchestratorInfo {
+	if m != nil {
+		return m.Info
+	}
+	return nil
+}
+
+func (m *OrchestratorNotification) GetEffectiveAt() int64 {
+	if m != nil {
+		return m.EffectiveAt
+	}
+	return 0
+}
+
 func init() {
 	proto.RegisterEnum("net.OSInfo_StorageType", OSInfo_StorageType_name, OSInfo_StorageType_value)
 	proto.RegisterEnum("net.VideoProfile_Format", VideoProfile_Format_name, VideoProfile_Format_value)
 	proto.RegisterEnum("net.VideoProfile_Profile", VideoProfile_Profile_name, VideoProfile_Profile_value)
+	proto.RegisterEnum("net.OrchestratorNotification_Type", OrchestratorNotification_Type_name, OrchestratorNotification_Type_value)
 	proto.RegisterType((*PingPong)(nil), "net.PingPong")
 	proto.RegisterType((*OrchestratorRequest)(nil), "net.OrchestratorRequest")
 	proto.RegisterType((*OSInfo)(nil), "net.OSInfo")
@@ -1491,10 +1799,13 @@ func init() {
 	proto.RegisterType((*Capabilities)(nil), "net.Capabilities")
 	proto.RegisterType((*Capabilities_Constraints)(nil), "net.Capabilities.Constraints")
 	proto.RegisterType((*OrchestratorInfo)(nil), "net.OrchestratorInfo")
+	proto.RegisterType((*OrchestratorInfo_MaintenanceWindow)(nil), "net.OrchestratorInfo.MaintenanceWindow")
 	proto.RegisterType((*AuthToken)(nil), "net.AuthToken")
 	proto.RegisterType((*SegData)(nil), "net.SegData")
+	proto.RegisterMapType((map[string]string)(nil), "net.SegData.MetadataEntry")
 	proto.RegisterType((*VideoProfile)(nil), "net.VideoProfile")
 	proto.RegisterType((*TranscodedSegmentData)(nil), "net.TranscodedSegmentData")
+	proto.RegisterType((*TranscodedSegmentData_EncoderSettings)(nil), "net.TranscodedSegmentData.EncoderSettings")
 	proto.RegisterType((*TranscodeData)(nil), "net.TranscodeData")
 	proto.RegisterType((*TranscodeResult)(nil), "net.TranscodeResult")
 	proto.RegisterType((*RegisterRequest)(nil), "net.RegisterRequest")
@@ -1503,106 +1814,131 @@ func init() {
 	proto.RegisterType((*TicketSenderParams)(nil), "net.TicketSenderParams")
 	proto.RegisterType((*TicketExpirationParams)(nil), "net.TicketExpirationParams")
 	proto.RegisterType((*Payment)(nil), "net.Payment")
+	proto.RegisterType((*OrchestratorNotification)(nil), "net.OrchestratorNotification")
 }
 
 func init() { proto.RegisterFile("net/lp_rpc.proto", fileDescriptor_034e29c79f9ba827) }
 
 var fileDescriptor_034e29c79f9ba827 = []byte{
-	// 1494 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xac, 0x57, 0xdd, 0x6e, 0xdb, 0xca,
-	0x11, 0xb6, 0x44, 0x59, 0x3f, 0x23, 0xc9, 0xa6, 0xd7, 0x3f, 0xa1, 0xdd, 0x9e, 0x03, 0x85, 0x3d,
-	0x29, 0x7c, 0x2e, 0xe2, 0x06, 0x72, 0x92, 0x22, 0x77, 0x95, 0x6d, 0xc5, 0x56, 0x90, 0xc8, 0xc2,
-	0x4a, 0x09, 0xd0, 0x8b, 0x42, 0xa5, 0xc9, 0x95, 0xb4, 0xb5, 0x4c, 0x32, 0xdc, 0x55, 0x63, 0xe7,
-	0x0d, 0xfa, 0x06, 0x6d, 0x6f, 0x0a, 0x14, 0xe8, 0x7b, 0xf4, 0x0d, 0xfa, 0x4a, 0xc5, 0xce, 0x2e,
-	0x29, 0xca, 0x76, 0x81, 0x20, 0xe8, 0xdd, 0xce, 0x37, 0xb3, 0x3b, 0xc3, 0x6f, 0x67, 0xbf, 0x5d,
-	0x82, 0x1d, 0x32, 0xf9, 0x9b, 0x79, 0x3c, 0x4e, 0x62, 0xff, 0x28, 0x4e, 0x22, 0x19, 0x11, 0x2b,
-	0x64, 0xd2, 0x6d, 0x41, 0x75, 0xc0, 0xc3, 0xe9, 0x20, 0x0a, 0xa7, 0x64, 0x07, 0xd6, 0xff, 0xec,
-	0xcd, 0x17, 0xcc, 0x29, 0xb4, 0x0a, 0x87, 0x0d, 0xaa, 0x0d, 0xb7, 0x03, 0xdb, 0x97, 0x89, 0x3f,
-	0x63, 0x42, 0x26, 0x9e, 0x8c, 0x12, 0xca, 0x3e, 0x2f, 0x98, 0x90, 0xc4, 0x81, 0x8a, 0x17, 0x04,
-	0x09, 0x13, 0xc2, 0x84, 0xa7, 0x26, 0xb1, 0xc1, 0x12, 0x7c, 0xea, 0x14, 0x11, 0x55, 0x43, 0xf7,
-	0x6f, 0x05, 0x28, 0x5f, 0x0e, 0x7b, 0xe1, 0x24, 0x22, 0x6f, 0xa0, 0x2e, 0x64, 0x94, 0x78, 0x53,
-	0x36, 0xba, 0x8b, 0x75, 0xa6, 0x8d, 0xf6, 0x93, 0xa3, 0x90, 0xc9, 0x23, 0x1d, 0x71, 0x34, 0x5c,
-	0xba, 0x69, 0x3e, 0x96, 0x3c, 0x83, 0xb2, 0x38, 0xe6, 0xe1, 0x24, 0x72, 0xec, 0x56, 0xe1, 0xb0,
-	0xde, 0x6e, 0xe2, 0xac, 0xe1, 0xb1, 0x9e, 0x47, 0x8d, 0xd3, 0x7d, 0x0e, 0xf5, 0xdc, 0x12, 0x04,
-	0xa0, 0x7c, 0xd6, 0xa3, 0xdd, 0xd3, 0x91, 0xbd, 0x46, 0xca, 0x50, 0x1c, 0x1e, 0xdb, 0x05, 0x85,
-	0x9d, 0x5f, 0x5e, 0x9e, 0xbf, 0xef, 0xda, 0x45, 0xf7, 0x9f, 0x05, 0xa8, 0xa6, 0x6b, 0x10, 0x02,
-	0xa5, 0x59, 0x24, 0x24, 0x96, 0x55, 0xa3, 0x38, 0x56, 0x9f, 0x73, 0xcd, 0xee, 0xf0, 0x73, 0x6a,
-	0x54, 0x0d, 0xc9, 0x1e, 0x94, 0xe3, 0x68, 0xce, 0xfd, 0x3b, 0xc7, 0x42, 0xd0, 0x58, 0xe4, 0x97,
-	0x50, 0x13, 0x7c, 0x1a, 0x7a, 0x72, 0x91, 0x30, 0xa7, 0x84, 0xae, 0x25, 0x40, 0x7e, 0x04, 0xf0,
-	0x13, 0x16, 0xb0, 0x50, 0x72, 0x6f, 0xee, 0xac, 0xa3, 0x3b, 0x87, 0x90, 0x03, 0xa8, 0xde, 0x76,
-	0x6e, 0xbe, 0x9e, 0x79, 0x92, 0x39, 0x65, 0xf4, 0x66, 0xb6, 0xfb, 0x11, 0x6a, 0x83, 0x84, 0xfb,
-	0x0c, 0x8b, 0x74, 0xa1, 0x11, 0x2b, 0x63, 0xc0, 0x92, 0x8f, 0x21, 0xd7, 0xc5, 0x5a, 0x74, 0x05,
-	0x23, 0x3f, 0x41, 0x33, 0xe6, 0xb7, 0x6c, 0x2e, 0xd2, 0xa0, 0x22, 0x06, 0xad, 0x82, 0xee, 0x1f,
-	0xa0, 0x71, 0xea, 0xc5, 0xde, 0x15, 0x9f, 0x73, 0xc9, 0x99, 0x50, 0x1f, 0x70, 0xc5, 0xa5, 0x90,
-	0x09, 0x0f, 0xa7, 0x4e, 0xa1, 0x65, 0x1d, 0x96, 0xe8, 0x12, 0x20, 0x2d, 0xa8, 0xdf, 0x78, 0x61,
-	0xa0, 0x9a, 0x80, 0x33, 0xe1, 0x14, 0xd1, 0x9f, 0x87, 0x0e, 0x9a, 0x50, 0x3f, 0x8d, 0x42, 0xd5,
-	0x28, 0x3c, 0x94, 0xc2, 0xfd, 0x77, 0x11, 0xec, 0x7c, 0xeb, 0x60, 0xf5, 0x3f, 0x02, 0xc8, 0xc4,
-	0x0b, 0x85, 0x1f, 0x05, 0x2c, 0x31, 0x44, 0xe7, 0x10, 0xf2, 0x1a, 0x9a, 0x92, 0xfb, 0xd7, 0x4c,
-	0x8e, 0x63, 0x2f, 0xf1, 0x6e, 0x04, 0x56, 0x5e, 0x6f, 0x6f, 0xe1, 0x66, 0x8f, 0xd0, 0x33, 0x40,
-	0x07, 0x6d, 0xc8, 0x9c, 0x45, 0x9e, 0x03, 0x20, 0x03, 0x63, 0xec, 0x10, 0x0b, 0x27, 0x6d, 0xe0,
-	0xa4, 0x8c, 0x39, 0x5a, 0x8b, 0x33, 0x12, 0x73, 0xed, 0x5b, 0x5a, 0x6d, 0xdf, 0x57, 0xd0, 0xf0,
-	0x73, 0xa4, 0xe0, 0x4e, 0xa5, 0xf9, 0xf3, 0x6c, 0xd1, 0x95, 0x30, 0x95, 0xdf, 0x5b, 0xc8, 0xd9,
-	0x58, 0x46, 0xd7, 0x2c, 0xc4, 0x0d, 0x4c, 0xf3, 0x77, 0x16, 0x72, 0x36, 0x52, 0x28, 0xad, 0x79,
-	0xe9, 0x90, 0x3c, 0x83, 0x8a, 0xe9, 0x6d, 0xa7, 0xd5, 0xb2, 0x0e, 0xeb, 0xed, 0x7a, 0xee, 0x0c,
-	0xd0, 0xd4, 0xe7, 0xfe, 0x11, 0x6a, 0xd9, 0x74, 0x75, 0x3e, 0xf5, 0xea, 0xe6, 0x7c, 0xa2, 0x41,
-	0x7e, 0x00, 0x10, 0x4c, 0x08, 0x1e, 0x85, 0x63, 0x1e, 0x98, 0x36, 0xad, 0x19, 0xa4, 0x17, 0x28,
-	0xbe, 0xd9, 0x6d, 0xcc, 0x13, 0x4f, 0xf2, 0x28, 0x44, 0x5e, 0x2c, 0x9a, 0x43, 0xdc, 0xff, 0x58,
-	0x50, 0x19, 0xb2, 0xe9, 0x99, 0x27, 0x3d, 0x15, 0x7b, 0xe3, 0x85, 0x7c, 0xc2, 0x84, 0xec, 0x05,
-	0x26, 0x4b, 0x0e, 0xc1, 0x93, 0xcd, 0x3e, 0x9b, 0x5e, 0x52, 0x43, 0x3c, 0x30, 0x9e, 0x98, 0xe1,
-	0xba, 0x0d, 0x8a, 0x63, 0xd5, 0xc8, 0x71, 0x12, 0x4d, 0xf8, 0x9c, 0xa5, 0xdc, 0x66, 0x76, 0xaa,
-	0x0d, 0xeb, 0x99, 0x36, 0xa8, 0xe8, 0x60, 0x61, 0xaa, 0x53, 0xac, 0xad, 0xd3, 0xcc, 0x7e, 0xb0,
-	0x15, 0x95, 0xef, 0xd9, 0x8a, 0xea, 0xff, 0x67, 0x2b, 0x54, 0x31, 0x93, 0xc5, 0x7c, 0x3e, 0x48,
-	0x3f, 0xed, 0x29, 0xc6, 0xea, 0x62, 0x3e, 0xf1, 0x80, 0x45, 0xc6, 0x43, 0x57, 0xc2, 0xc8, 0x6f,
-	0xa1, 0x99, 0xb7, 0xdb, 0x8e, 0xfb, 0xbf, 0xe6, 0xad, 0xc6, 0xdd, 0x9f, 0x78, 0xec, 0xfc, 0xea,
-	0x9b, 0x26, 0x1e, 0xbb, 0x7f, 0xb5, 0xa0, 0x91, 0xf7, 0xab, 0x4d, 0x0a, 0xbd, 0x1b, 0x86, 0xb2,
-	0x59, 0xa3, 0x38, 0x56, 0xbd, 0xf4, 0x85, 0x07, 0x72, 0xe6, 0x6c, 0x21, 0xe7, 0xda, 0x50, 0xca,
-	0x36, 0x63, 0x7c, 0x3a, 0x93, 0x0e, 0x41, 0xd8, 0x58, 0xea, 0xb4, 0x5c, 0x71, 0x75, 0x88, 0x99,
-	0xb3, 0x8d, 0x8e, 0xd4, 0x54, 0x1b, 0x3a, 0x89, 0x85, 0xb3, 0xd3, 0x2a, 0x1c, 0x36, 0xa9, 0x1a,
-	0x92, 0x17, 0x50, 0x9e, 0x44, 0xc9, 0x8d, 0x27, 0x9d, 0x5d, 0x14, 0x77, 0xe7, 0x41, 0xc1, 0x47,
-	0x6f, 0xd1, 0x4f, 0x4d, 0x9c, 0xca, 0x3a, 0x89, 0xc5, 0x19, 0x0b, 0x9d, 0x3d, 0x5c, 0xc6, 0x58,
-	0xe4, 0x18, 0x2a, 0xa6, 0x71, 0x9c, 0x27, 0xb8, 0xd4, 0xfe, 0xc3, 0xa5, 0x52, 0x0e, 0xd2, 0x48,
-	0x55, 0xd0, 0x34, 0x8a, 0x1d, 0x07, 0xcb, 0x54, 0x43, 0xf7, 0x07, 0x28, 0xeb, 0x84, 0x4a, 0xf7,
-	0x3f, 0x0c, 0xba, 0xe7, 0xa3, 0xa1, 0xbd, 0x46, 0x2a, 0x60, 0x7d, 0x18, 0xbc, 0xb4, 0x0b, 0xee,
-	0x9f, 0xa0, 0x92, 0x12, 0xb5, 0x0d, 0x9b, 0xdd, 0xfe, 0xe9, 0xe5, 0x59, 0x97, 0x8e, 0xcf, 0xba,
-	0x6f, 0x3b, 0x1f, 0xdf, 0xab, 0x4b, 0x63, 0x0b, 0x9a, 0x17, 0xed, 0xd7, 0x2f, 0xc7, 0x27, 0x9d,
-	0x61, 0xf7, 0x7d, 0xaf, 0xdf, 0xb5, 0x0b, 0xa4, 0x09, 0x35, 0x84, 0x3e, 0x74, 0x7a, 0x7d, 0xbb,
-	0x98, 0x99, 0x17, 0xbd, 0xf3, 0x0b, 0xdb, 0x22, 0xfb, 0xb0, 0x8b, 0xe6, 0xe9, 0x65, 0x7f, 0x38,
-	0xa2, 0x9d, 0x5e, 0xbf, 0x7b, 0xa6, 0x5d, 0x25, 0xb7, 0x03, 0xbb, 0xa3, 0x54, 0xea, 0x82, 0x21,
-	0x9b, 0xde, 0xb0, 0x50, 0xe2, 0xc9, 0xb3, 0xc1, 0x5a, 0x24, 0x73, 0x23, 0x87, 0x6a, 0x88, 0x97,
-	0x0c, 0x8a, 0xb5, 0x39, 0x6e, 0xc6, 0x72, 0x7f, 0x0f, 0xcd, 0x6c, 0x09, 0x9c, 0xfa, 0x1a, 0xaa,
-	0x42, 0xaf, 0x24, 0x50, 0xb3, 0xeb, 0xed, 0x03, 0xad, 0x95, 0x8f, 0x25, 0xa2, 0x59, 0xec, 0x23,
-	0xd7, 0xf4, 0xdf, 0x0b, 0xb0, 0x99, 0xcd, 0xa2, 0x4c, 0x2c, 0xe6, 0x32, 0x3d, 0xf2, 0x85, 0xe5,
-	0x91, 0xdf, 0x83, 0x75, 0x96, 0x24, 0x51, 0xa2, 0xa5, 0xe6, 0x62, 0x8d, 0x6a, 0x93, 0x1c, 0x42,
-	0x29, 0xf0, 0xa4, 0x67, 0xa4, 0x97, 0xac, 0xd6, 0xa0, 0x72, 0x5f, 0xac, 0x51, 0x8c, 0x20, 0x3f,
-	0x43, 0x29, 0x77, 0x8d, 0xef, 0xea, 0xd3, 0x76, 0xef, 0x9e, 0xa0, 0x18, 0x72, 0x52, 0x85, 0x72,
-	0x82, 0x85, 0xb8, 0x5d, 0xd8, 0xa4, 0x6c, 0xca, 0x85, 0x64, 0xd9, 0x13, 0x64, 0x0f, 0xca, 0x82,
-	0xf9, 0x09, 0x4b, 0xef, 0x6b, 0x63, 0x29, 0x49, 0x51, 0x7a, 0xe0, 0x73, 0x79, 0x67, 0xc8, 0xcb,
-	0x6c, 0xf7, 0x2f, 0x05, 0x68, 0xf6, 0x23, 0xc9, 0x27, 0x77, 0x86, 0x95, 0x47, 0xa8, 0xff, 0x35,
-	0x54, 0x84, 0x56, 0x44, 0xf3, 0x31, 0x0d, 0xfd, 0xd2, 0xd0, 0x18, 0x4d, 0x9d, 0x2a, 0xbf, 0xf4,
-	0xc4, 0x75, 0x2f, 0xc0, 0x2f, 0xb1, 0xa8, 0xb1, 0x56, 0x04, 0x70, 0x6b, 0x55, 0x00, 0xdf, 0x95,
-	0xaa, 0x45, 0xdb, 0x7a, 0x57, 0xaa, 0x3e, 0xb5, 0x5d, 0xf7, 0x1f, 0x45, 0x68, 0xe4, 0x6f, 0x34,
-	0x75, 0xff, 0x26, 0xcc, 0xe7, 0x31, 0x67, 0xa1, 0x34, 0xf2, 0xbb, 0x04, 0x94, 0xd0, 0x4f, 0x3c,
-	0x9f, 0x8d, 0xf5, 0x1b, 0x4d, 0xef, 0x5b, 0x4d, 0x21, 0x9f, 0x14, 0x40, 0xf6, 0xa1, 0xfa, 0x85,
-	0x87, 0xe3, 0x38, 0x89, 0xae, 0x8c, 0x1c, 0x57, 0xbe, 0xf0, 0x70, 0x90, 0x44, 0x57, 0xe4, 0x08,
-	0xb6, 0xb3, 0x65, 0xc6, 0x89, 0x17, 0x06, 0x63, 0x14, 0x6d, 0x2d, 0xce, 0x5b, 0x99, 0x8b, 0x7a,
-	0x61, 0x70, 0xa1, 0x14, 0x9c, 0x40, 0x49, 0x30, 0x16, 0x18, 0x99, 0xc6, 0x31, 0xf9, 0x19, 0xec,
-	0xe5, 0xad, 0x31, 0xbe, 0x9a, 0x47, 0xfe, 0x35, 0xea, 0x75, 0x83, 0x6e, 0x2e, 0xf1, 0x13, 0x05,
-	0x93, 0x0b, 0xd8, 0xca, 0x85, 0x9a, 0x6b, 0x5c, 0x6b, 0xf7, 0x2f, 0x72, 0xd7, 0x78, 0x37, 0x8b,
-	0x31, 0x17, 0x7a, 0x2e, 0x81, 0x46, 0xdc, 0x1e, 0x10, 0x1d, 0x3b, 0x64, 0x61, 0xc0, 0x12, 0x43,
-	0xd3, 0x53, 0x68, 0x08, 0xb4, 0xc7, 0x61, 0x14, 0xfa, 0xfa, 0x11, 0xd9, 0xa4, 0x75, 0x8d, 0xf5,
-	0x15, 0xf4, 0x48, 0x73, 0x7f, 0x85, 0xbd, 0xc7, 0xd3, 0x92, 0x67, 0xb0, 0xe1, 0x27, 0x4c, 0x17,
-	0x9b, 0x44, 0x8b, 0x30, 0x30, 0xdd, 0xde, 0x4c, 0x51, 0xaa, 0x40, 0xf2, 0x06, 0xf6, 0x57, 0xc3,
-	0x34, 0x09, 0x9a, 0x4a, 0x9d, 0x68, 0x6f, 0x65, 0x06, 0x92, 0xa1, 0xf8, 0x74, 0xff, 0x55, 0x84,
-	0xca, 0xc0, 0xbb, 0xc3, 0x76, 0x7b, 0xf0, 0xbe, 0x29, 0x7c, 0xdb, 0xfb, 0x06, 0x9b, 0x5d, 0x7d,
-	0xa0, 0xc9, 0x65, 0xac, 0xc7, 0xc9, 0xb6, 0xbe, 0x83, 0x6c, 0xd2, 0x83, 0x1d, 0x53, 0x99, 0x61,
-	0xd7, 0x2c, 0x56, 0x42, 0x51, 0x79, 0x92, 0x5b, 0x2c, 0xbf, 0x1b, 0x94, 0xc8, 0x87, 0x3b, 0xf4,
-	0x0a, 0x36, 0xd8, 0x6d, 0xcc, 0x7c, 0xc9, 0x82, 0x31, 0xbe, 0xb9, 0xcc, 0x2b, 0xea, 0xfe, 0x83,
-	0xac, 0x99, 0x46, 0x21, 0xd4, 0xbe, 0x85, 0x46, 0x5e, 0x07, 0xc8, 0x09, 0x6c, 0x9e, 0x33, 0xb9,
-	0x02, 0x39, 0x0f, 0xd4, 0xc2, 0xa8, 0xc1, 0xc1, 0xe3, 0x3a, 0x42, 0x7e, 0x82, 0x92, 0xfa, 0xc1,
-	0x21, 0xfa, 0x6f, 0x21, 0xfd, 0xd7, 0x39, 0x58, 0x35, 0xdb, 0x7d, 0x80, 0xd1, 0xf2, 0x0d, 0xfa,
-	0x3b, 0x20, 0xa9, 0xd6, 0xe4, 0xd0, 0x1d, 0x9c, 0x72, 0x4f, 0x84, 0x0e, 0xb4, 0xd0, 0xad, 0x48,
-	0xca, 0x8b, 0xc2, 0x55, 0x19, 0x7f, 0xb1, 0x8e, 0xff, 0x1b, 0x00, 0x00, 0xff, 0xff, 0x06, 0xab,
-	0x69, 0x9b, 0x76, 0x0d, 0x00, 0x00,
+	// 1873 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0x03, 0x9c, 0x58, 0xdd, 0x72, 0x1b, 0x49,
+	0x15, 0xf6, 0x48, 0xb2, 0x7e, 0x8e, 0xa4, 0x78, 0xdc, 0x4e, 0x9c, 0x89, 0x20, 0x8b, 0x33, 0x6c,
+	0xc0, 0x4b, 0xd5, 0x8a, 0x94, 0xbd, 0x6b, 0x58, 0x96, 0x0b, 0x64, 0x5b, 0x6b, 0x2b, 0xc4, 0xb6,
+	0xaa, 0xe5, 0x2c, 0x70, 0x35, 0x8c, 0x67, 0x5a, 0x72, 0x63, 0xa9, 0x67, 0xd2, 0xdd, 0x8a, 0xe3,
+	0xbd, 0xe3, 0x92, 0x37, 0x00, 0x6e, 0xa8, 0xa2, 0x8a, 0x37, 0xe1, 0x39, 0xb8, 0x83, 0x67, 0xe0,
+	0x92, 0xea, 0x9f, 0x19, 0x8d, 0x6c, 0xb3, 0xd9, 0xca, 0x95, 0xfa, 0x7c, 0x7d, 0xfa, 0xef, 0xf4,
+	0xd7, 0xdf, 0x39, 0x23, 0x70, 0x19, 0x91, 0x3f, 0x9d, 0xa6, 0x01, 0x4f, 0xa3, 0x6e, 0xca, 0x13,
+	0x99, 0xa0, 0x32, 0x23, 0xd2, 0xdf, 0x82, 0xfa, 0x90, 0xb2, 0xc9, 0x30, 0x61, 0x13, 0xf4, 0x10,
+	0x56, 0xdf, 0x86, 0xd3, 0x39, 0xf1, 0x9c, 0x2d, 0x67, 0xbb, 0x85, 0x8d, 0xe1, 0xa7, 0xb0, 0x71,
+	0xc6, 0xa3, 0x4b, 0x22, 0x24, 0x0f, 0x65, 0xc2, 0x31, 0x79, 0x33, 0x27, 0x42, 0x22, 0x0f, 0x6a,
+	0x61, 0x1c, 0x73, 0x22, 0x84, 0x75, 0xcf, 0x4c, 0xe4, 0x42, 0x59, 0xd0, 0x89, 0x57, 0xd2, 0xa8,
+	0x6a, 0xa2, 0x4f, 0x01, 0xc2, 0xb9, 0xbc, 0x0c, 0x64, 0x72, 0x45, 0x98, 0x57, 0xde, 0x72, 0xb6,
+	0x9b, 0x3b, 0x0f, 0xba, 0x8c, 0xc8, 0x6e, 0x6f, 0x2e, 0x2f, 0xcf, 0x15, 0x8a, 0x1b, 0x61, 0xd6,
+	0xf4, 0xff, 0xe2, 0x40, 0xf5, 0x6c, 0x34, 0x60, 0xe3, 0x04, 0x7d, 0x01, 0x4d, 0x21, 0x13, 0x1e,
+	0x4e, 0xc8, 0xf9, 0x4d, 0x6a, 0x36, 0xf6, 0x60, 0xe7, 0xb1, 0x1e, 0x6a, 0x3c, 0xba, 0xa3, 0x45,
+	0x37, 0x2e, 0xfa, 0xa2, 0xe7, 0x50, 0x15, 0xbb, 0x94, 0x8d, 0x13, 0xcf, 0xd5, 0x0b, 0xb6, 0xf5,
+	0xa8, 0xd1, 0xae, 0x19, 0x87, 0x6d, 0xa7, 0xff, 0x29, 0x34, 0x0b, 0x53, 0x20, 0x80, 0xea, 0xe1,
+	0x00, 0xf7, 0x0f, 0xce, 0xdd, 0x15, 0x54, 0x85, 0xd2, 0x68, 0xd7, 0x75, 0x14, 0x76, 0x74, 0x76,
+	0x76, 0xf4, 0xaa, 0xef, 0x96, 0xfc, 0xbf, 0x3b, 0x50, 0xcf, 0xe6, 0x40, 0x08, 0x2a, 0x97, 0x89,
+	0x90, 0x7a, 0x5b, 0x0d, 0xac, 0xdb, 0xea, 0xf4, 0x57, 0xe4, 0x46, 0x9f, 0xbe, 0x81, 0x55, 0x13,
+	0x6d, 0x42, 0x35, 0x4d, 0xa6, 0x34, 0xba, 0xd1, 0x27, 0x6f, 0x60, 0x6b, 0xa1, 0xef, 0x43, 0x43,
+	0xd0, 0x09, 0x0b, 0xe5, 0x9c, 0x13, 0xaf, 0xa2, 0xbb, 0x16, 0x00, 0xfa, 0x08, 0x20, 0xe2, 0x24,
+	0x26, 0x4c, 0xd2, 0x70, 0xea, 0xad, 0xea, 0xee, 0x02, 0x82, 0x3a, 0x50, 0x7f, 0xd7, 0x9b, 0x7d,
+	0x73, 0x18, 0x4a, 0xe2, 0x55, 0x75, 0x6f, 0x6e, 0xfb, 0xaf, 0xa1, 0x31, 0xe4, 0x34, 0x22, 0x7a,
+	0x93, 0x3e, 0xb4, 0x52, 0x65, 0x0c, 0x09, 0x7f, 0xcd, 0xa8, 0xd9, 0x6c, 0x19, 0x2f, 0x61, 0xe8,
+	0x63, 0x68, 0xa7, 0xf4, 0x1d, 0x99, 0x8a, 0xcc, 0xa9, 0xa4, 0x9d, 0x96, 0x41, 0xff, 0x1a, 0x5a,
+	0x07, 0x61, 0x1a, 0x5e, 0xd0, 0x29, 0x95, 0x94, 0x08, 0x75, 0x80, 0x0b, 0x2a, 0x85, 0xe4, 0x94,
+	0x4d, 0x3c, 0x67, 0xab, 0xbc, 0x5d, 0xc1, 0x0b, 0x00, 0x6d, 0x41, 0x73, 0x16, 0xb2, 0x58, 0x71,
+	0x86, 0x12, 0xe1, 0x95, 0x74, 0x7f, 0x11, 0x52, 0x14, 0x7a, 0x4b, 0xb8, 0xa0, 0x89, 0xe1, 0x44,
+	0x1b, 0x67, 0x66, 0xa7, 0x0d, 0xcd, 0x83, 0x84, 0x29, 0xc6, 0x51, 0x26, 0x85, 0xff, 0xdf, 0x32,
+	0xb8, 0x45, 0x0e, 0xea, 0x73, 0x7d, 0x04, 0x20, 0x79, 0xc8, 0x44, 0x94, 0xc4, 0x84, 0xdb, 0x2b,
+	0x28, 0x20, 0x68, 0x0f, 0xda, 0x92, 0x46, 0x57, 0x44, 0x06, 0x69, 0xc8, 0xc3, 0x99, 0xd0, 0x67,
+	0x6a, 0xee, 0xac, 0x6b, 0x1a, 0x9c, 0xeb, 0x9e, 0xa1, 0xee, 0xc0, 0x2d, 0x59, 0xb0, 0x14, 0x59,
+	0x75, 0x6c, 0x02, 0xcd, 0x9d, 0x22, 0x59, 0xf3, 0x98, 0xe2, 0x46, 0x9a, 0x35, 0x8b, 0xef, 0xa0,
+	0xb2, 0xfc, 0x0e, 0x3e, 0x87, 0x56, 0x54, 0x08, 0x97, 0xb7, 0x5a, 0x58, 0xbf, 0x18, 0x47, 0xbc,
+	0xe4, 0x76, 0xeb, 0xb1, 0x54, 0xdf, 0xf3, 0x58, 0xd0, 0x6f, 0x61, 0x63, 0xa6, 0xa2, 0x44, 0x58,
+	0xc8, 0x22, 0x12, 0x5c, 0x53, 0x16, 0x27, 0xd7, 0xc2, 0xab, 0x6d, 0x95, 0xb7, 0x9b, 0x3b, 0x3f,
+	0x36, 0x2f, 0xe5, 0x56, 0xe8, 0xba, 0x27, 0x8b, 0x01, 0xbf, 0xd1, 0xfe, 0x18, 0xcd, 0x6e, 0x43,
+	0x02, 0xfd, 0x00, 0x9a, 0x82, 0x4c, 0x66, 0x84, 0xc9, 0x40, 0x31, 0xba, 0xae, 0x4f, 0x07, 0x16,
+	0xfa, 0x35, 0xb9, 0x41, 0xcf, 0xa1, 0x66, 0x1f, 0x9c, 0xb7, 0xa5, 0x97, 0x6b, 0x16, 0x1e, 0x26,
+	0xce, 0xfa, 0x3a, 0x5f, 0xc2, 0xfa, 0x9d, 0x05, 0x95, 0xd6, 0x08, 0x19, 0xf2, 0x8c, 0x8e, 0xc6,
+	0x50, 0x8f, 0x87, 0xb0, 0xd8, 0xb2, 0x4f, 0x35, 0xfd, 0xdf, 0x43, 0x23, 0x3f, 0xb6, 0x1a, 0x64,
+	0xa2, 0x62, 0x05, 0x4a, 0x1b, 0xe8, 0x29, 0x80, 0x20, 0x42, 0xf1, 0x26, 0xa0, 0xb1, 0x7d, 0x78,
+	0x0d, 0x8b, 0x0c, 0x62, 0xc5, 0x13, 0xf2, 0x2e, 0xa5, 0x3c, 0x94, 0x19, 0xd1, 0xca, 0xb8, 0x80,
+	0xf8, 0xff, 0xae, 0x40, 0x6d, 0x44, 0x26, 0x87, 0xa1, 0x0c, 0x95, 0xef, 0x2c, 0x64, 0x74, 0x4c,
+	0x84, 0x1c, 0xc4, 0x76, 0x95, 0x02, 0xa2, 0xa5, 0x8d, 0xbc, 0xc9, 0xf6, 0x27, 0xc8, 0x1b, 0x2d,
+	0x01, 0xa1, 0xb8, 0xd4, 0xf3, 0xb6, 0xb0, 0x6e, 0xab, 0xa7, 0x99, 0xf2, 0x64, 0x4c, 0xa7, 0x24,
+	0xe3, 0x44, 0x6e, 0x67, 0xe2, 0xb8, 0xba, 0x10, 0xc7, 0x0e, 0xd4, 0xe3, 0xb9, 0xdd, 0x9d, 0xba,
+	0xed, 0x55, 0x9c, 0xdb, 0x77, 0x28, 0x54, 0xfb, 0x10, 0x0a, 0xd5, 0xdf, 0x47, 0xa1, 0x3d, 0xa8,
+	0xcf, 0x88, 0x0c, 0xe3, 0x50, 0x86, 0x5e, 0x43, 0x5f, 0x64, 0xc7, 0x68, 0xa5, 0x89, 0x4a, 0xf7,
+	0xc4, 0x76, 0xf6, 0x99, 0xe4, 0x37, 0x38, 0xf7, 0xbd, 0x4d, 0x10, 0xf8, 0x40, 0x82, 0xa8, 0x53,
+	0x8e, 0xe7, 0xd3, 0xe9, 0x30, 0x8b, 0xd9, 0xb3, 0xad, 0x72, 0x7e, 0xca, 0xaf, 0x69, 0x4c, 0x12,
+	0xdb, 0x83, 0x97, 0xdc, 0xd0, 0xcf, 0xa0, 0x5d, 0xb4, 0x77, 0x3c, 0xff, 0xff, 0x8d, 0x5b, 0xf6,
+	0xbb, 0x3d, 0x70, 0xd7, 0xfb, 0xe1, 0x77, 0x1a, 0xb8, 0xdb, 0xf9, 0x12, 0xda, 0x4b, 0xb1, 0xc8,
+	0xc4, 0xde, 0x59, 0x88, 0x7d, 0x9e, 0x43, 0x0d, 0x0f, 0x8d, 0xf1, 0x8b, 0xd2, 0xcf, 0x1d, 0xff,
+	0xcf, 0x65, 0x68, 0x15, 0x27, 0x57, 0xd4, 0x61, 0xe1, 0x8c, 0xe8, 0xf4, 0xd4, 0xc0, 0xba, 0xad,
+	0x86, 0x5f, 0xd3, 0x58, 0x5e, 0x7a, 0xeb, 0x9a, 0x09, 0xc6, 0x50, 0x19, 0xe4, 0x92, 0xd0, 0xc9,
+	0xa5, 0xf4, 0x90, 0x86, 0xad, 0xa5, 0xb4, 0xe7, 0x82, 0xaa, 0x77, 0x4d, 0xbc, 0x0d, 0xdd, 0x91,
+	0x99, 0x6a, 0x63, 0xe3, 0x54, 0x78, 0x0f, 0xb5, 0xac, 0xaa, 0x26, 0x7a, 0x01, 0xd5, 0x71, 0xc2,
+	0x67, 0xa1, 0xf4, 0x1e, 0xe9, 0x24, 0xea, 0xdd, 0x39, 0x6d, 0xf7, 0x2b, 0xdd, 0x8f, 0xad, 0x9f,
+	0x5a, 0x75, 0x9c, 0x8a, 0x43, 0xc2, 0xbc, 0x4d, 0x3d, 0x8d, 0xb5, 0xd0, 0x2e, 0xd4, 0x2c, 0x9d,
+	0xbd, 0xc7, 0x7a, 0xaa, 0x27, 0x77, 0xa7, 0xb2, 0xbf, 0x38, 0xf3, 0x54, 0x1b, 0x9a, 0x24, 0xa9,
+	0xe7, 0xe9, 0x6d, 0xaa, 0xa6, 0xff, 0x14, 0xaa, 0x66, 0x41, 0x95, 0x5f, 0x4f, 0x86, 0xfd, 0xa3,
+	0xf3, 0x91, 0xbb, 0x82, 0x6a, 0x50, 0x3e, 0x19, 0x7e, 0xe6, 0x3a, 0xfe, 0x1f, 0xa0, 0x96, 0x05,
+	0x6a, 0x03, 0xd6, 0xfa, 0xa7, 0x07, 0x67, 0x87, 0x7d, 0x1c, 0x1c, 0xf6, 0xbf, 0xea, 0xbd, 0x7e,
+	0xa5, 0x92, 0xf3, 0x3a, 0xb4, 0x8f, 0x77, 0xf6, 0x3e, 0x0b, 0xf6, 0x7b, 0xa3, 0xfe, 0xab, 0xc1,
+	0x69, 0xdf, 0x75, 0x50, 0x1b, 0x1a, 0x1a, 0x3a, 0xe9, 0x0d, 0x4e, 0xdd, 0x52, 0x6e, 0x1e, 0x0f,
+	0x8e, 0x8e, 0xdd, 0x32, 0x7a, 0x02, 0x8f, 0xb4, 0x79, 0x70, 0x76, 0x3a, 0x3a, 0xc7, 0xbd, 0xc1,
+	0x69, 0xff, 0xd0, 0x74, 0x55, 0xfc, 0x3f, 0x96, 0xe1, 0xd1, 0x79, 0x96, 0x39, 0xe2, 0x91, 0x21,
+	0xb0, 0x16, 0x04, 0x17, 0xca, 0x73, 0x3e, 0xcd, 0x2e, 0x78, 0xce, 0xa7, 0x3a, 0x9b, 0xeb, 0xac,
+	0x68, 0x55, 0xc0, 0x5a, 0xe8, 0x35, 0xb8, 0x84, 0xa9, 0xf1, 0x3c, 0x10, 0x44, 0x4a, 0xca, 0x26,
+	0xc2, 0x26, 0x8f, 0x9f, 0x98, 0x8c, 0x73, 0xdf, 0xfc, 0xdd, 0xbe, 0x19, 0x32, 0xb2, 0x23, 0xf0,
+	0x1a, 0x59, 0x06, 0x3a, 0xff, 0x71, 0x60, 0xed, 0x96, 0x93, 0x22, 0x89, 0x02, 0x22, 0xbb, 0x2d,
+	0x63, 0x28, 0x32, 0x64, 0xd7, 0x62, 0xb8, 0x97, 0x99, 0x7a, 0xcb, 0x9c, 0x08, 0x22, 0xf3, 0x02,
+	0x44, 0x5b, 0xe8, 0x19, 0xb4, 0x2c, 0x5f, 0x82, 0x59, 0x12, 0x67, 0x35, 0x48, 0xd3, 0x62, 0x27,
+	0x49, 0x4c, 0x8a, 0x0c, 0x33, 0x25, 0x48, 0x66, 0x2a, 0xa9, 0xe4, 0x44, 0x24, 0xd3, 0x79, 0x2e,
+	0x5c, 0x0d, 0x5c, 0x40, 0x54, 0x71, 0x30, 0xe6, 0xe1, 0x8c, 0xe8, 0xb1, 0x35, 0x23, 0xca, 0x39,
+	0x90, 0xd1, 0xa1, 0x6e, 0xe2, 0xaa, 0xe8, 0xf0, 0x3b, 0x68, 0xe7, 0x21, 0xd2, 0xa1, 0xdf, 0x83,
+	0xba, 0x95, 0x12, 0xe1, 0x39, 0x05, 0x55, 0xba, 0x37, 0x90, 0x38, 0xf7, 0xbd, 0x5b, 0x7e, 0xfa,
+	0x7f, 0x75, 0x60, 0x2d, 0x1f, 0x85, 0x89, 0x98, 0x4f, 0x65, 0xa6, 0xe4, 0xce, 0x42, 0xc9, 0x37,
+	0x61, 0x95, 0x70, 0x9e, 0x70, 0x13, 0xbd, 0xe3, 0x15, 0x6c, 0x4c, 0xb4, 0x0d, 0x15, 0xad, 0x8c,
+	0xe6, 0x32, 0xd1, 0xf2, 0x1e, 0xd4, 0xda, 0xc7, 0x2b, 0x58, 0x7b, 0xa0, 0x4f, 0xa0, 0x52, 0xa8,
+	0x37, 0x1f, 0xdd, 0x9b, 0x7b, 0xb1, 0x76, 0xd9, 0xaf, 0x43, 0x95, 0xeb, 0x8d, 0xf8, 0x7d, 0x58,
+	0xc3, 0x64, 0x42, 0x85, 0x24, 0x79, 0x69, 0xbd, 0x09, 0x55, 0x41, 0x22, 0x4e, 0xb2, 0xc2, 0xd2,
+	0x5a, 0x2a, 0x53, 0x28, 0x99, 0x8f, 0xa8, 0xbc, 0xb1, 0xe4, 0xcb, 0x6d, 0xff, 0x4f, 0x0e, 0xb4,
+	0x4f, 0x13, 0x49, 0xc7, 0x37, 0x36, 0x2a, 0xf7, 0x50, 0xf7, 0x47, 0x50, 0x13, 0x46, 0xd2, 0xed,
+	0x61, 0x5a, 0x45, 0x99, 0xc7, 0x59, 0xa7, 0x5a, 0x5f, 0x86, 0xe2, 0x6a, 0x10, 0xeb, 0x93, 0x94,
+	0xb1, 0xb5, 0x96, 0xf2, 0xda, 0xfa, 0x72, 0x5e, 0x7b, 0x59, 0xa9, 0x97, 0xdc, 0xf2, 0xcb, 0x4a,
+	0xfd, 0x99, 0xeb, 0xfb, 0x7f, 0x2b, 0x41, 0xab, 0x58, 0x60, 0x29, 0x2e, 0x70, 0x12, 0xd1, 0x94,
+	0x12, 0x26, 0x6d, 0x56, 0x5d, 0x00, 0x2a, 0x7f, 0x8f, 0xc3, 0x88, 0x04, 0x0b, 0xdd, 0x6c, 0xe1,
+	0x86, 0x42, 0xbe, 0x56, 0x00, 0x7a, 0x02, 0xf5, 0x6b, 0xca, 0x82, 0x94, 0x27, 0x17, 0x36, 0xcb,
+	0xd6, 0xae, 0x29, 0x1b, 0xf2, 0xe4, 0x02, 0x75, 0x61, 0x23, 0x9f, 0x26, 0xe0, 0x21, 0x8b, 0x03,
+	0x9d, 0x8b, 0x4d, 0xce, 0x5d, 0xcf, 0xbb, 0x70, 0xc8, 0xe2, 0x63, 0x95, 0x98, 0x11, 0x54, 0x04,
+	0x21, 0xb1, 0xcd, 0xbe, 0xba, 0x8d, 0x3e, 0x01, 0x77, 0x51, 0x0c, 0x04, 0x17, 0xd3, 0x24, 0xba,
+	0xd2, 0x6c, 0x6e, 0xe1, 0xb5, 0x05, 0xbe, 0xaf, 0x60, 0x74, 0x0c, 0xeb, 0x05, 0x57, 0x5b, 0x55,
+	0x9a, 0x94, 0xfc, 0xbd, 0x42, 0x55, 0xd9, 0xcf, 0x7d, 0x6c, 0x7d, 0xe9, 0x92, 0x5b, 0x88, 0x3f,
+	0x00, 0x64, 0x7c, 0x47, 0x84, 0xc5, 0x84, 0xdb, 0x30, 0x3d, 0x83, 0x96, 0xd0, 0x76, 0xc0, 0x12,
+	0x16, 0x99, 0xaf, 0x9d, 0x36, 0x6e, 0x1a, 0xec, 0x54, 0x41, 0xf7, 0x90, 0xfb, 0x1b, 0xd8, 0xbc,
+	0x7f, 0x59, 0xf4, 0x1c, 0x1e, 0x44, 0x9c, 0x98, 0xcd, 0xf2, 0x64, 0xce, 0x62, 0xcb, 0xf6, 0x76,
+	0x86, 0x62, 0x05, 0xa2, 0x2f, 0xe0, 0xc9, 0xb2, 0x9b, 0x09, 0x82, 0x09, 0xa5, 0x59, 0x68, 0x73,
+	0x69, 0x84, 0x0e, 0x86, 0x8a, 0xa7, 0xff, 0x8f, 0x12, 0xd4, 0x86, 0xe1, 0x8d, 0xa6, 0xdb, 0x9d,
+	0x72, 0xdb, 0xf9, 0x6e, 0xe5, 0xb6, 0x26, 0xbb, 0x3a, 0xa0, 0x5d, 0xcb, 0x5a, 0xf7, 0x07, 0xbb,
+	0xfc, 0x01, 0xc1, 0x46, 0x03, 0x78, 0x68, 0x77, 0x66, 0xa3, 0x6b, 0x27, 0xab, 0x68, 0x51, 0x79,
+	0x5c, 0x98, 0xac, 0x78, 0x1b, 0x18, 0xc9, 0xbb, 0x37, 0xf4, 0x39, 0x3c, 0x20, 0xef, 0x52, 0x12,
+	0x49, 0x12, 0x07, 0xfa, 0x13, 0xc0, 0x5b, 0x2d, 0x14, 0x57, 0x8b, 0xef, 0x83, 0x76, 0xe6, 0xa5,
+	0x21, 0xff, 0x5f, 0x0e, 0x78, 0x45, 0x21, 0xd0, 0x0f, 0x95, 0x46, 0xa6, 0xc6, 0xdb, 0x83, 0x8a,
+	0x5c, 0x7c, 0xdb, 0xfa, 0x77, 0x54, 0xa3, 0xe8, 0xdc, 0xd5, 0x9f, 0xb9, 0xda, 0x3f, 0x57, 0x9b,
+	0xd2, 0x7b, 0xd5, 0x46, 0x11, 0x8b, 0x8c, 0xc7, 0x24, 0x92, 0xf4, 0x2d, 0x09, 0x42, 0x69, 0x8b,
+	0xe0, 0x66, 0x8e, 0xf5, 0xa4, 0xff, 0x4b, 0xa8, 0xa8, 0xb9, 0x91, 0x0b, 0xad, 0x21, 0x1e, 0x1c,
+	0xf4, 0x83, 0x83, 0xe3, 0xde, 0xe9, 0x51, 0xdf, 0x5d, 0x41, 0x8f, 0x61, 0xe3, 0xa0, 0x37, 0xec,
+	0xed, 0x0f, 0x5e, 0x0d, 0xce, 0x07, 0xfd, 0x51, 0xd6, 0xe1, 0xa0, 0x06, 0xac, 0x1e, 0x62, 0x9d,
+	0x6a, 0x77, 0xfe, 0xe9, 0x40, 0xab, 0xb8, 0x36, 0xda, 0x87, 0xb5, 0x23, 0x22, 0x97, 0x20, 0xef,
+	0xce, 0x0e, 0xad, 0xde, 0x75, 0xee, 0xdf, 0x3b, 0xfa, 0x18, 0x2a, 0xea, 0xaf, 0x09, 0x64, 0x3e,
+	0xdc, 0xb3, 0x7f, 0x29, 0x3a, 0xcb, 0x26, 0x7a, 0x09, 0xed, 0x62, 0x84, 0xc4, 0xb7, 0xac, 0xf3,
+	0xf4, 0x5b, 0x63, 0xfb, 0xc2, 0xd9, 0x39, 0x05, 0x38, 0x5f, 0x7c, 0x40, 0xfe, 0x0a, 0x50, 0xa6,
+	0xcc, 0x05, 0xf4, 0xa1, 0x9e, 0xe4, 0x96, 0x64, 0x77, 0x4c, 0x5a, 0x58, 0x12, 0xe0, 0x17, 0xce,
+	0x45, 0x55, 0xff, 0xd1, 0xb2, 0xfb, 0xbf, 0x01, 0x00, 0x66, 0x87, 0x7c, 0xe8, 0x7c, 0x11, 0x00,
+	0x00,
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -1620,6 +1956,9 @@ type OrchestratorClient interface {
 	// Called by the broadcaster to request transcoder info from an orchestrator.
 	GetOrchestrator(ctx context.Context, in *OrchestratorRequest, opts ...grpc.CallOption) (*OrchestratorInfo, error)
 	Ping(ctx context.Context, in *PingPong, opts ...grpc.CallOption) (*PingPong, error)
+	// Called by the broadcaster to be notified of upcoming price, capability
+	// and availability changes so it can reselect before segments fail.
+	Notifications(ctx context.Context, in *OrchestratorRequest, opts ...grpc.CallOption) (Orchestrator_NotificationsClient, error)
 }
 
 type orchestratorClient struct {
@@ -1648,11 +1987,46 @@ func (c *orchestratorClient) Ping(ctx context.Context, in *PingPong, opts ...grp
 	return out, nil
 }
 
+func (c *orchestratorClient) Notifications(ctx context.Context, in *OrchestratorRequest, opts ...grpc.CallOption) (Orchestrator_NotificationsClient, error) {
+	stream, err := c.cc.NewStream(ctx, &_Orchestrator_serviceDesc.Streams[0], "/net.Orchestrator/Notifications", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &orchestratorNotificationsClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Orchestrator_NotificationsClient interface {
+	Recv() (*OrchestratorNotification, error)
+	grpc.ClientStream
+}
+
+type orchestratorNotificationsClient struct {
+	grpc.ClientStream
+}
+
+func (x *orchestratorNotificationsClient) Recv() (*OrchestratorNotification, error) {
+	m := new(OrchestratorNotification)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // OrchestratorServer is the server API for Orchestrator service.
 type OrchestratorServer interface {
 	// Called by the broadcaster to request transcoder info from an orchestrator.
 	GetOrchestrator(context.Context, *OrchestratorRequest) (*OrchestratorInfo, error)
 	Ping(context.Context, *PingPong) (*PingPong, error)
+	// Called by the broadcaster to be notified of upcoming price, capability
+	// and availability changes so it can reselect before segments fail.
+	Notifications(*OrchestratorRequest, Orchestrator_NotificationsServer) error
 }
 
 // UnimplementedOrchestratorServer can be embedded to have forward compatible implementations.
@@ -1665,6 +2039,9 @@ func (*UnimplementedOrchestratorServer) GetOrchestrator(ctx context.Context, req
 func (*UnimplementedOrchestratorServer) Ping(ctx context.Context, req *PingPong) (*PingPong, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method Ping not implemented")
 }
+func (*UnimplementedOrchestratorServer) Notifications(req *OrchestratorRequest, srv Orchestrator_NotificationsServer) error {
+	return status.Errorf(codes.Unimplemented, "method Notifications not implemented")
+}
 
 func RegisterOrchestratorServer(s *grpc.Server, srv OrchestratorServer) {
 	s.RegisterService(&_Orchestrator_serviceDesc, srv)
@@ -1706,6 +2083,27 @@ func _Orchestrator_Ping_Handler(srv interface{}, ctx context.Context, dec func(i
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Orchestrator_Notifications_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(OrchestratorRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(OrchestratorServer).Notifications(m, &orchestratorNotificationsServer{stream})
+}
+
+type Orchestrator_NotificationsServer interface {
+	Send(*OrchestratorNotification) error
+	grpc.ServerStream
+}
+
+type orchestratorNotificationsServer struct {
+	grpc.ServerStream
+}
+
+func (x *orchestratorNotificationsServer) Send(m *OrchestratorNotification) error {
+	return x.ServerStream.SendMsg(m)
+}
+
 var _Orchestrator_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "net.Orchestrator",
 	HandlerType: (*OrchestratorServer)(nil),
@@ -1719,7 +2117,13 @@ var _Orchestrator_serviceDesc = grpc.ServiceDesc{
 			Handler:    _Orchestrator_Ping_Handler,
 		},
 	},
-	Streams:  []grpc.StreamDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Notifications",
+			Handler:       _Orchestrator_Notifications_Handler,
+			ServerStreams: true,
+		},
+	},
 	Metadata: "net/lp_rpc.proto",
 }
 
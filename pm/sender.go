@@ -22,6 +22,13 @@ type Sender interface {
 	// for creating new tickets
 	StartSession(ticketParams TicketParams) string
 
+	// ResumeSession creates a session for a given set of ticket params whose tickets
+	// were created up to senderNonce before, e.g. by a previous run of the node
+	ResumeSession(ticketParams TicketParams, senderNonce uint32) string
+
+	// SenderNonce returns the nonce of the last ticket created for a session
+	SenderNonce(sessionID string) (uint32, error)
+
 	// CreateTicketBatch returns a ticket batch of the specified size
 	CreateTicketBatch(sessionID string, size int) (*TicketBatch, error)
 
@@ -70,6 +77,39 @@ func (s *sender) StartSession(ticketParams TicketParams) string {
 	return sessionID
 }
 
+// ResumeSession creates a session for a given set of ticket params whose tickets
+// were created up to senderNonce before, so that the nonces of new tickets are not reused.
+// If the session already exists, its nonce is only ever moved forward.
+func (s *sender) ResumeSession(ticketParams TicketParams, senderNonce uint32) string {
+	sessionID := ticketParams.RecipientRandHash.Hex()
+
+	existing, loaded := s.sessions.LoadOrStore(sessionID, &session{
+		ticketParams: ticketParams,
+		senderNonce:  senderNonce,
+	})
+	if loaded {
+		session := existing.(*session)
+		for {
+			nonce := atomic.LoadUint32(&session.senderNonce)
+			if nonce >= senderNonce || atomic.CompareAndSwapUint32(&session.senderNonce, nonce, senderNonce) {
+				break
+			}
+		}
+	}
+
+	return sessionID
+}
+
+// SenderNonce returns the nonce of the last ticket created for a session
+func (s *sender) SenderNonce(sessionID string) (uint32, error) {
+	session, err := s.loadSession(sessionID)
+	if err != nil {
+		return 0, err
+	}
+
+	return atomic.LoadUint32(&session.senderNonce), nil
+}
+
 // EV returns the ticket EV for a session
 func (s *sender) EV(sessionID string) (*big.Rat, error) {
 	session, err := s.loadSession(sessionID)
@@ -375,6 +375,38 @@ func TestCreateTicketBatch_MultipleTickets(t *testing.T) {
 	}
 }
 
+func TestResumeSession_ContinuesFromSenderNonce(t *testing.T) {
+	sender := defaultSender(t)
+	ticketParams := defaultTicketParams(t, RandAddress())
+	sessionID := sender.StartSession(ticketParams)
+
+	_, err := sender.SenderNonce("foo")
+	assert.EqualError(t, err, "error loading session: 666f6f")
+
+	_, err = sender.CreateTicketBatch(sessionID, 3)
+	require.Nil(t, err)
+	nonce, err := sender.SenderNonce(sessionID)
+	require.Nil(t, err)
+	assert.Equal(t, uint32(3), nonce)
+
+	// a resumed session does not reuse the nonces of the tickets created before
+	resumed := defaultSender(t)
+	assert.Equal(t, sessionID, resumed.ResumeSession(ticketParams, nonce))
+	batch, err := resumed.CreateTicketBatch(sessionID, 2)
+	require.Nil(t, err)
+	assert.Equal(t, uint32(4), batch.SenderParams[0].SenderNonce)
+	assert.Equal(t, uint32(5), batch.SenderParams[1].SenderNonce)
+	nonce, err = resumed.SenderNonce(sessionID)
+	require.Nil(t, err)
+	assert.Equal(t, uint32(5), nonce)
+
+	// nor does resuming a session of the sender from an older nonce
+	resumed.ResumeSession(ticketParams, 3)
+	nonce, err = resumed.SenderNonce(sessionID)
+	require.Nil(t, err)
+	assert.Equal(t, uint32(5), nonce)
+}
+
 func TestCreateTicketBatch_SigningError_ReturnsError(t *testing.T) {
 	sender := defaultSender(t)
 	recipient := RandAddress()
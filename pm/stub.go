@@ -493,6 +493,19 @@ func (m *MockSender) StartSession(ticketParams TicketParams) string {
 	return args.String(0)
 }
 
+// ResumeSession creates a session for a given set of ticket params whose tickets
+// were created up to senderNonce before
+func (m *MockSender) ResumeSession(ticketParams TicketParams, senderNonce uint32) string {
+	args := m.Called(ticketParams, senderNonce)
+	return args.String(0)
+}
+
+// SenderNonce returns the nonce of the last ticket created for a session
+func (m *MockSender) SenderNonce(sessionID string) (uint32, error) {
+	args := m.Called(sessionID)
+	return uint32(args.Int(0)), args.Error(1)
+}
+
 // EV returns the ticket EV for a session
 func (m *MockSender) EV(sessionID string) (*big.Rat, error) {
 	args := m.Called(sessionID)
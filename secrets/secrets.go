@@ -0,0 +1,247 @@
+// Package secrets fetches credentials and keys from a secrets manager, so they
+// don't have to be given inline on the command line where they end up in
+// process listings and logs.
+//
+// A secret is referred to by a URL whose scheme names the secrets manager:
+//
+//	vault://<path>#<field>           HashiCorp Vault, KV version 1 or 2, eg vault://secret/data/livepeer#s3
+//	awssm://<region>/<name>#<field>  AWS Secrets Manager
+//	gcpsm://projects/<project>/secrets/<name>[/versions/<version>][#<field>]  GCP Secret Manager
+//
+// The field selects a key of a secret stored as a JSON object and may be
+// omitted for secrets stored as plain text. Vault is reached at $VAULT_ADDR
+// with the token in $VAULT_TOKEN; AWS and GCP credentials are taken from the
+// environment as by their SDKs.
+package secrets
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/golang/glog"
+	secretmanager "google.golang.org/api/secretmanager/v1"
+)
+
+// Timeout bounds each request to a secrets manager
+var Timeout = 10 * time.Second
+
+var vaultClient = &http.Client{}
+
+type fetcher func(ctx context.Context, u *url.URL) (string, error)
+
+var fetchers = map[string]fetcher{
+	"vault": fetchVault,
+	"awssm": fetchAWS,
+	"gcpsm": fetchGCP,
+}
+
+// IsRef returns whether `s` refers to a secret rather than holding its value
+func IsRef(s string) bool {
+	i := strings.Index(s, "://")
+	if i < 0 {
+		return false
+	}
+	_, ok := fetchers[s[:i]]
+	return ok
+}
+
+// Get fetches the secret `ref` refers to
+func Get(ctx context.Context, ref string) (string, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", err
+	}
+	fetch, ok := fetchers[u.Scheme]
+	if !ok {
+		return "", fmt.Errorf("unrecognized secret scheme: %s", u.Scheme)
+	}
+	ctx, cancel := context.WithTimeout(ctx, Timeout)
+	defer cancel()
+	val, err := fetch(ctx, u)
+	if err != nil {
+		return "", fmt.Errorf("error fetching secret %s: %v", redact(u), err)
+	}
+	if u.Fragment == "" {
+		return val, nil
+	}
+	return field(val, u.Fragment)
+}
+
+// Resolve returns the secret `s` refers to, or `s` itself if it isn't a
+// reference
+func Resolve(ctx context.Context, s string) (string, error) {
+	if !IsRef(s) {
+		return s, nil
+	}
+	return Get(ctx, s)
+}
+
+// Watch fetches the secret `ref` every `interval` until `ctx` is done and
+// calls `changed` whenever its value differs from `last`. Errors are logged
+// and the previous value kept.
+func Watch(ctx context.Context, ref, last string, interval time.Duration, changed func(string)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		val, err := Get(ctx, ref)
+		if err != nil {
+			glog.Errorf("Error refreshing secret err=%v", err)
+			continue
+		}
+		if val == last {
+			continue
+		}
+		glog.Infof("Secret rotated ref=%s", redactRef(ref))
+		last = val
+		changed(val)
+	}
+}
+
+// field returns the value of `name` in a secret stored as a JSON object
+func field(secret, name string) (string, error) {
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(secret), &fields); err != nil {
+		return "", fmt.Errorf("secret is not a JSON object, can not select field %q", name)
+	}
+	v, ok := fields[name]
+	if !ok {
+		return "", fmt.Errorf("secret has no field %q", name)
+	}
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	// nested objects, such as GCP service account keys, are returned as JSON
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// redact drops anything but the location of a secret from its reference, so
+// it can be logged
+func redact(u *url.URL) string {
+	return u.Scheme + "://" + u.Host + u.Path
+}
+
+func redactRef(ref string) string {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "<invalid>"
+	}
+	return redact(u)
+}
+
+// fetchVault reads a secret from Vault's HTTP API. The data of KV version 2
+// secrets is nested within a second "data" object.
+func fetchVault(ctx context.Context, u *url.URL) (string, error) {
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return "", fmt.Errorf("VAULT_ADDR is not set")
+	}
+	req, err := http.NewRequest("GET", strings.TrimSuffix(addr, "/")+"/v1/"+u.Host+u.Path, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", os.Getenv("VAULT_TOKEN"))
+	resp, err := vaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault status=%d", resp.StatusCode)
+	}
+	var secret struct {
+		Data map[string]json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(body, &secret); err != nil {
+		return "", err
+	}
+	data := secret.Data
+	if inner, ok := data["data"]; ok {
+		if _, ok := data["metadata"]; ok {
+			if err := json.Unmarshal(inner, &data); err != nil {
+				return "", err
+			}
+		}
+	}
+	if u.Fragment == "" {
+		if len(data) != 1 {
+			return "", fmt.Errorf("vault secret has %d fields, select one with #<field>", len(data))
+		}
+		for _, v := range data {
+			var s string
+			if err := json.Unmarshal(v, &s); err != nil {
+				return string(v), nil
+			}
+			return s, nil
+		}
+	}
+	b, err := json.Marshal(data)
+	return string(b), err
+}
+
+// fetchAWS reads a secret from AWS Secrets Manager
+func fetchAWS(ctx context.Context, u *url.URL) (string, error) {
+	name := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || name == "" {
+		return "", fmt.Errorf("expected awssm://<region>/<name>")
+	}
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(u.Host)})
+	if err != nil {
+		return "", err
+	}
+	out, err := secretsmanager.New(sess).GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(name),
+	})
+	if err != nil {
+		return "", err
+	}
+	if out.SecretString != nil {
+		return *out.SecretString, nil
+	}
+	return string(out.SecretBinary), nil
+}
+
+// fetchGCP reads a secret version from GCP Secret Manager, the latest one
+// unless the reference names a version
+func fetchGCP(ctx context.Context, u *url.URL) (string, error) {
+	name := u.Host + u.Path
+	if !strings.HasPrefix(name, "projects/") || !strings.Contains(name, "/secrets/") {
+		return "", fmt.Errorf("expected gcpsm://projects/<project>/secrets/<name>")
+	}
+	if !strings.Contains(name, "/versions/") {
+		name += "/versions/latest"
+	}
+	svc, err := secretmanager.NewService(ctx)
+	if err != nil {
+		return "", err
+	}
+	resp, err := svc.Projects.Secrets.Versions.Access(name).Context(ctx).Do()
+	if err != nil {
+		return "", err
+	}
+	data, err := base64.StdEncoding.DecodeString(resp.Payload.Data)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
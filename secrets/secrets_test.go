@@ -0,0 +1,114 @@
+package secrets
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsRef(t *testing.T) {
+	assert := assert.New(t)
+	assert.True(IsRef("vault://secret/data/livepeer#s3"))
+	assert.True(IsRef("awssm://us-east-1/livepeer/s3"))
+	assert.True(IsRef("gcpsm://projects/p/secrets/s"))
+	assert.False(IsRef("s3://key:secret@us-east-1/bucket"))
+	assert.False(IsRef("hunter2"))
+	assert.False(IsRef("/path/to/secret"))
+
+	v, err := Resolve(context.Background(), "hunter2")
+	assert.Nil(err)
+	assert.Equal("hunter2", v)
+	_, err = Get(context.Background(), "s3://bucket")
+	assert.NotNil(err)
+}
+
+func TestField(t *testing.T) {
+	assert := assert.New(t)
+	v, err := field(`{"user":"u","key":{"type":"service_account"}}`, "user")
+	assert.Nil(err)
+	assert.Equal("u", v)
+	v, err = field(`{"user":"u","key":{"type":"service_account"}}`, "key")
+	assert.Nil(err)
+	assert.JSONEq(`{"type":"service_account"}`, v)
+	_, err = field(`{"user":"u"}`, "nope")
+	assert.NotNil(err)
+	_, err = field("plain text", "user")
+	assert.NotNil(err)
+}
+
+func TestVault(t *testing.T) {
+	assert := assert.New(t)
+
+	var version int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/livepeer":
+			if atomic.LoadInt32(&version) == 0 {
+				w.Write([]byte(`{"data":{"data":{"s3":"s3://a:b@us-east-1/bucket","orch":"x"},"metadata":{"version":1}}}`))
+			} else {
+				w.Write([]byte(`{"data":{"data":{"s3":"s3://c:d@us-east-1/bucket","orch":"x"},"metadata":{"version":2}}}`))
+			}
+		case "/v1/kv/single":
+			w.Write([]byte(`{"data":{"password":"hunter2"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	defer os.Setenv("VAULT_ADDR", os.Getenv("VAULT_ADDR"))
+	defer os.Setenv("VAULT_TOKEN", os.Getenv("VAULT_TOKEN"))
+	os.Setenv("VAULT_ADDR", ts.URL)
+	os.Setenv("VAULT_TOKEN", "token")
+	ctx := context.Background()
+
+	// KV version 2
+	v, err := Resolve(ctx, "vault://secret/data/livepeer#s3")
+	require.Nil(t, err)
+	assert.Equal("s3://a:b@us-east-1/bucket", v)
+	_, err = Get(ctx, "vault://secret/data/livepeer")
+	assert.NotNil(err)
+
+	// KV version 1 with a single field
+	v, err = Get(ctx, "vault://kv/single")
+	require.Nil(t, err)
+	assert.Equal("hunter2", v)
+
+	_, err = Get(ctx, "vault://kv/missing#password")
+	assert.Contains(err.Error(), "status=404")
+	os.Setenv("VAULT_TOKEN", "wrong")
+	_, err = Get(ctx, "vault://kv/single")
+	assert.Contains(err.Error(), "status=403")
+	os.Setenv("VAULT_TOKEN", "token")
+
+	// rotation
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	changed := make(chan string, 1)
+	go Watch(ctx, "vault://secret/data/livepeer#s3", "s3://a:b@us-east-1/bucket", 10*time.Millisecond, func(v string) {
+		changed <- v
+	})
+	select {
+	case <-changed:
+		t.Fatal("unchanged secret reported as rotated")
+	case <-time.After(50 * time.Millisecond):
+	}
+	atomic.StoreInt32(&version, 1)
+	select {
+	case v := <-changed:
+		assert.Equal("s3://c:d@us-east-1/bucket", v)
+	case <-time.After(time.Second):
+		t.Fatal("rotated secret not reported")
+	}
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+var errAdmissionQueueFull = newError(ErrorCategoryIngest, true, "ErrAdmissionQueueFull")
+var errAdmissionTimeout = newError(ErrorCategoryIngest, true, "ErrAdmissionTimeout")
+
+// StreamAdmission, if set, throttles the creation of new streams so that
+// reconnect storms do not overwhelm the auth webhook and the node.
+var StreamAdmission *AdmissionQueue
+
+// AdmissionQueue is a token bucket with a bounded FIFO wait queue.
+// Requests are admitted immediately while tokens are available; once the
+// bucket is empty they are queued and admitted in arrival order as tokens
+// are refilled at a steady rate.
+type AdmissionQueue struct {
+	mu       sync.Mutex
+	rate     float64 // tokens per second
+	burst    float64
+	tokens   float64
+	last     time.Time
+	waiters  []chan struct{}
+	maxQueue int
+	timeout  time.Duration
+	draining bool
+}
+
+// NewAdmissionQueue returns a queue that admits `rate` requests per second
+// with bursts of up to `burst`. At most `maxQueue` requests wait for a token,
+// each for no longer than `timeout`. `rate` must be greater than zero.
+func NewAdmissionQueue(rate float64, burst, maxQueue int, timeout time.Duration) *AdmissionQueue {
+	if burst < 1 {
+		burst = 1
+	}
+	return &AdmissionQueue{
+		rate:     rate,
+		burst:    float64(burst),
+		tokens:   float64(burst),
+		last:     time.Now(),
+		maxQueue: maxQueue,
+		timeout:  timeout,
+	}
+}
+
+// Admit blocks until the request is allowed to proceed. It returns an error
+// if the wait queue is full or the request waited longer than the timeout.
+func (q *AdmissionQueue) Admit() error {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	q.refill()
+	if len(q.waiters) == 0 && q.tokens >= 1 {
+		q.tokens--
+		q.mu.Unlock()
+		return nil
+	}
+	if len(q.waiters) >= q.maxQueue {
+		q.mu.Unlock()
+		return errAdmissionQueueFull
+	}
+	ch := make(chan struct{})
+	q.waiters = append(q.waiters, ch)
+	if !q.draining {
+		q.draining = true
+		go q.drain()
+	}
+	q.mu.Unlock()
+
+	timer := time.NewTimer(q.timeout)
+	defer timer.Stop()
+	select {
+	case <-ch:
+		return nil
+	case <-timer.C:
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, w := range q.waiters {
+		if w == ch {
+			q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+			return errAdmissionTimeout
+		}
+	}
+	// Admitted while we were timing out
+	return nil
+}
+
+// Waiting returns the number of requests currently queued
+func (q *AdmissionQueue) Waiting() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.waiters)
+}
+
+// drain hands out tokens to queued requests in FIFO order until the queue is empty
+func (q *AdmissionQueue) drain() {
+	for {
+		q.mu.Lock()
+		q.refill()
+		for len(q.waiters) > 0 && q.tokens >= 1 {
+			q.tokens--
+			close(q.waiters[0])
+			q.waiters = q.waiters[1:]
+		}
+		if len(q.waiters) == 0 {
+			q.draining = false
+			q.mu.Unlock()
+			return
+		}
+		wait := time.Duration((1 - q.tokens) / q.rate * float64(time.Second))
+		q.mu.Unlock()
+		time.Sleep(wait)
+	}
+}
+
+// refill adds the tokens accrued since the last refill. Caller must hold the lock.
+func (q *AdmissionQueue) refill() {
+	now := time.Now()
+	q.tokens += now.Sub(q.last).Seconds() * q.rate
+	if q.tokens > q.burst {
+		q.tokens = q.burst
+	}
+	q.last = now
+}
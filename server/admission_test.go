@@ -0,0 +1,57 @@
+package server
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdmissionQueue_Burst(t *testing.T) {
+	assert := assert.New(t)
+
+	// nil queue admits everything
+	var nq *AdmissionQueue
+	assert.Nil(nq.Admit())
+
+	q := NewAdmissionQueue(1, 3, 0, time.Millisecond)
+	for i := 0; i < 3; i++ {
+		assert.Nil(q.Admit())
+	}
+	// bucket is empty and nothing may wait
+	assert.Equal(errAdmissionQueueFull, q.Admit())
+
+	q = NewAdmissionQueue(0.1, 1, 1, 10*time.Millisecond)
+	assert.Nil(q.Admit())
+	start := time.Now()
+	assert.Equal(errAdmissionTimeout, q.Admit())
+	assert.True(time.Since(start) >= 10*time.Millisecond)
+	assert.Equal(0, q.Waiting())
+}
+
+func TestAdmissionQueue_FIFO(t *testing.T) {
+	assert := assert.New(t)
+
+	// one token every 20ms
+	q := NewAdmissionQueue(50, 1, 10, time.Second)
+	assert.Nil(q.Admit())
+
+	var mu sync.Mutex
+	var order []int
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			assert.Nil(q.Admit())
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+		}(i)
+		time.Sleep(2 * time.Millisecond)
+	}
+	wg.Wait()
+	assert.Equal([]int{0, 1, 2, 3, 4}, order)
+	assert.Equal(0, q.Waiting())
+}
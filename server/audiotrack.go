@@ -0,0 +1,297 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math/bits"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/drivers"
+	"github.com/livepeer/lpms/ffmpeg"
+	"github.com/livepeer/lpms/stream"
+)
+
+// RecordAudioTrack makes the audio of recorded streams also be recorded on
+// its own, as an "audio" track of the recording, whatever renditions the
+// streams are transcoded into. The audio is remuxed from the source rather
+// than transcoded, so it keeps the codec and bitrate of the source.
+var RecordAudioTrack bool
+
+const audioTrackName = "audio"
+
+// recordAudioTrack saves the audio of the source segment `seg` to the
+// recording of the stream, in the background
+func (job *SegmentJob) recordAudioTrack(seg *stream.HLSSegment) {
+	cpl := job.cxn.pl
+	ros := cpl.GetRecordOSSession()
+	if !RecordAudioTrack || ros == nil {
+		return
+	}
+	data := extractTSAudio(seg.Data)
+	if data == nil {
+		glog.V(common.DEBUG).Infof("No audio to record manifestID=%s seqNo=%d", job.ManifestID, seg.SeqNo)
+		return
+	}
+	profile := ffmpeg.VideoProfile{Name: audioTrackName, Format: ffmpeg.FormatMPEGTS}
+	if seg.Duration > 0 {
+		profile.Bitrate = fmt.Sprint(int64(float64(len(data)*8) / seg.Duration))
+	}
+	name := fmt.Sprintf("%s/%d.ts", audioTrackName, seg.SeqNo)
+	go func() {
+		now := time.Now()
+		uri, err := drivers.SaveRetried(ros, name, data, map[string]string{"duration": getSegDurMsString(seg)}, 2)
+		if err != nil {
+			glog.Errorf("Error saving audio track manifestID=%s name=%s bytes=%d to record store err=%v",
+				job.ManifestID, name, len(data), err)
+			return
+		}
+		cpl.InsertHLSSegmentJSON(&profile, seg.SeqNo, uri, seg.Duration, nil)
+		job.cxn.checksums.add(audioTrackName, seg.SeqNo, uri, data)
+		glog.V(common.DEBUG).Infof("Saved audio track manifestID=%s name=%s bytes=%d took=%s", job.ManifestID, name, len(data), time.Since(now))
+		cpl.FlushRecord()
+	}()
+}
+
+// audioCodecAAC is the only codec of audio-only renditions: that of the
+// source audio the broadcaster takes in
+const audioCodecAAC = "aac"
+
+// audioProfile returns the audio-only rendition `name` of `profile`. Such
+// renditions are remuxed from the audio of the source, so only their bitrate
+// may be set, to be advertised in the master playlist; if unset, that of the
+// audio of the first segment is.
+func audioProfile(name string, profile jsonProfile) (ffmpeg.VideoProfile, error) {
+	if strings.ToLower(profile.AudioCodec) != audioCodecAAC {
+		return ffmpeg.VideoProfile{}, fmt.Errorf("unsupported audio codec profile=%s audioCodec=%s", name, profile.AudioCodec)
+	}
+	if profile.FPS != 0 || profile.FPSDen != 0 || profile.GOP != "" || profile.Profile != "" {
+		return ffmpeg.VideoProfile{}, fmt.Errorf("video settings in audio-only profile=%s", name)
+	}
+	if profile.Bitrate < 0 {
+		return ffmpeg.VideoProfile{}, errors.New("invalid bitrate value")
+	}
+	format, err := jsonProfileFormat(profile.Format)
+	if err != nil {
+		return ffmpeg.VideoProfile{}, err
+	}
+	if format != ffmpeg.FormatNone && format != ffmpeg.FormatMPEGTS {
+		return ffmpeg.VideoProfile{}, fmt.Errorf("audio-only profile not in mpegts profile=%s format=%s", name, profile.Format)
+	}
+	return ffmpeg.VideoProfile{
+		Name:    name,
+		Bitrate: fmt.Sprint(profile.Bitrate),
+		Format:  ffmpeg.FormatMPEGTS,
+	}, nil
+}
+
+// isAudioProfile tells whether `p` is an audio-only rendition, which has no
+// resolution
+func isAudioProfile(p ffmpeg.VideoProfile) bool {
+	return p.Resolution == ""
+}
+
+// splitAudioProfiles splits `profiles` into those transcoded by
+// orchestrators and the audio-only ones
+func splitAudioProfiles(profiles []ffmpeg.VideoProfile) (video, audio []ffmpeg.VideoProfile) {
+	for _, p := range profiles {
+		if isAudioProfile(p) {
+			audio = append(audio, p)
+		} else {
+			video = append(video, p)
+		}
+	}
+	return video, audio
+}
+
+// validateAudioProfiles checks that the audio-only renditions `audio` are
+// named apart from each other, from the `video` renditions and from those of
+// the source and of the recorded audio track
+func validateAudioProfiles(video, audio []ffmpeg.VideoProfile) error {
+	names := map[string]bool{"source": true, audioTrackName: true}
+	for _, p := range video {
+		names[p.Name] = true
+	}
+	for _, p := range audio {
+		if names[p.Name] {
+			return fmt.Errorf("duplicate profile name=%s", p.Name)
+		}
+		names[p.Name] = true
+	}
+	return nil
+}
+
+// storeAudioRenditions saves the audio of the source segment `seg` as the
+// audio-only renditions of the stream and inserts it into their playlists,
+// as well as into the recording of the stream if any
+func (job *SegmentJob) storeAudioRenditions(seg *stream.HLSSegment) {
+	profiles := job.cxn.params.AudioProfiles
+	if len(profiles) == 0 {
+		return
+	}
+	data := extractTSAudio(seg.Data)
+	if data == nil {
+		glog.V(common.DEBUG).Infof("No audio for audio-only renditions manifestID=%s seqNo=%d", job.ManifestID, seg.SeqNo)
+		return
+	}
+	cpl := job.cxn.pl
+	ros := cpl.GetRecordOSSession()
+	segDurMs := getSegDurMsString(seg)
+	for _, p := range profiles {
+		profile := p
+		if (profile.Bitrate == "" || profile.Bitrate == "0") && seg.Duration > 0 {
+			profile.Bitrate = fmt.Sprint(int64(float64(len(data)*8) / seg.Duration))
+		}
+		name := fmt.Sprintf("%s/%d.ts", profile.Name, seg.SeqNo)
+		uri, err := drivers.SaveRetried(cpl.GetOSSession(), name, data, map[string]string{"duration": segDurMs}, 2)
+		if err != nil {
+			glog.Errorf("Error saving audio-only rendition manifestID=%s name=%s bytes=%d err=%v", job.ManifestID, name, len(data), err)
+			continue
+		}
+		if err := cpl.InsertHLSSegment(&profile, seg.SeqNo, uri, seg.Duration); err != nil {
+			glog.Errorf("Error inserting audio-only rendition manifestID=%s name=%s err=%v", job.ManifestID, name, err)
+		}
+		if ros == nil {
+			continue
+		}
+		go func() {
+			uri, err := drivers.SaveRetried(ros, name, data, map[string]string{"duration": segDurMs}, 2)
+			if err != nil {
+				glog.Errorf("Error saving audio-only rendition manifestID=%s name=%s bytes=%d to record store err=%v",
+					job.ManifestID, name, len(data), err)
+				return
+			}
+			cpl.InsertHLSSegmentJSON(&profile, seg.SeqNo, uri, seg.Duration, nil)
+			job.cxn.checksums.add(profile.Name, seg.SeqNo, uri, data)
+			cpl.FlushRecord()
+		}()
+	}
+}
+
+// extractTSAudio returns the MPEG-TS segment `data` with only its first
+// audio stream, or nil if it has no audio. The video stream is dropped from
+// the program map, which is expected to fit in one packet.
+func extractTSAudio(data []byte) []byte {
+	out := make([]byte, 0, len(data)/4)
+	pmtPID, audioPID := -1, -1
+	found := false
+	for off := 0; off+tsPacketSize <= len(data); off += tsPacketSize {
+		pkt := data[off : off+tsPacketSize]
+		if pkt[0] != 0x47 {
+			continue
+		}
+		pusi := pkt[1]&0x40 != 0
+		pid := int(pkt[1]&0x1f)<<8 | int(pkt[2])
+		start := 4
+		if pkt[3]&0x20 != 0 {
+			start += 1 + int(pkt[4])
+		}
+		if start >= tsPacketSize {
+			continue
+		}
+		switch pid {
+		case 0:
+			if pusi {
+				if p := parsePAT(pkt[start:]); p >= 0 {
+					pmtPID = p
+				}
+			}
+			out = append(out, pkt...)
+		case pmtPID:
+			if !pusi {
+				continue
+			}
+			video, audio := parsePMT(pkt[start:])
+			if audio < 0 {
+				continue
+			}
+			pmt := audioOnlyPMT(pkt, start, video, audio)
+			if pmt == nil {
+				return nil
+			}
+			audioPID = audio
+			out = append(out, pmt...)
+		case audioPID:
+			out = append(out, pkt...)
+			found = true
+		}
+	}
+	if !found {
+		return nil
+	}
+	return out
+}
+
+// audioOnlyPMT returns a copy of the PMT packet `pkt`, with its section
+// starting at `start`, without the stream `video`. The program clock is
+// moved to the audio stream if it was carried by the video.
+func audioOnlyPMT(pkt []byte, start, video, audio int) []byte {
+	payload := pkt[start:]
+	secStart := 1 + int(payload[0])
+	sec := psiSection(payload)
+	if sec == nil {
+		return nil
+	}
+	res := make([]byte, 0, len(sec)+4)
+	res = append(res, sec[:12]...)
+	if pcr := int(sec[8]&0x1f)<<8 | int(sec[9]); pcr == video {
+		res[8], res[9] = sec[8]&0xe0|byte(audio>>8)&0x1f, byte(audio)
+	}
+	i := 12 + (int(sec[10]&0x0f)<<8 | int(sec[11]))
+	if i > len(sec) {
+		return nil
+	}
+	res = append(res, sec[12:i]...)
+	for i+5 <= len(sec) {
+		n := 5 + (int(sec[i+3]&0x0f)<<8 | int(sec[i+4]))
+		if i+n > len(sec) {
+			return nil
+		}
+		if pid := int(sec[i+1]&0x1f)<<8 | int(sec[i+2]); pid != video {
+			res = append(res, sec[i:i+n]...)
+		}
+		i += n
+	}
+	// section length counts from after the length field, CRC included
+	length := len(res) - 3 + 4
+	res[1], res[2] = res[1]&0xf0|byte(length>>8)&0x0f, byte(length)
+	crc := mpegCRC32(res)
+	res = append(res, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+
+	cp := append([]byte(nil), pkt[:start+secStart]...)
+	cp = append(cp, res...)
+	for len(cp) < tsPacketSize {
+		cp = append(cp, 0xff)
+	}
+	return cp
+}
+
+// mpegCRC32 is the CRC of MPEG-TS sections: CRC-32 as in the IEEE
+// polynomial, but most significant bit first and without a final XOR
+func mpegCRC32(b []byte) uint32 {
+	crc := ^uint32(0)
+	for _, c := range b {
+		crc = crc<<8 ^ mpegCRCTable[byte(crc>>24)^c]
+	}
+	return crc
+}
+
+var mpegCRCTable = func() (t [256]uint32) {
+	// the reflected IEEE polynomial reflected back
+	poly := bits.Reverse32(crc32.IEEE)
+	for i := range t {
+		c := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if c&0x80000000 != 0 {
+				c = c<<1 ^ poly
+			} else {
+				c <<= 1
+			}
+		}
+		t[i] = c
+	}
+	return
+}()
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/drivers"
+	"github.com/livepeer/lpms/ffmpeg"
+	"github.com/livepeer/lpms/stream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMPEGCRC32(t *testing.T) {
+	// PAT of a program with its PMT on PID 0x1000
+	pat := []byte{0x00, 0xb0, 0x0d, 0x00, 0x01, 0xc1, 0x00, 0x00, 0x00, 0x01, 0xf0, 0x00}
+	assert.Equal(t, uint32(0x2ab104b2), mpegCRC32(pat))
+}
+
+func TestExtractTSAudio(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	data := tsTestSegment(tsTestFrames(0, 3000, 3000), tsTestFrames(0, 1920))
+	out := extractTSAudio(data)
+	require.Len(out, 4*tsPacketSize)
+	// PAT and audio packets as they were
+	assert.Equal(data[:tsPacketSize], out[:tsPacketSize])
+	assert.Equal(data[5*tsPacketSize:], out[2*tsPacketSize:])
+
+	// the PMT lists the audio only, which carries the clock
+	pmt := out[tsPacketSize : 2*tsPacketSize]
+	video, audio := parsePMT(pmt[4:])
+	assert.Equal(-1, video)
+	assert.Equal(0x102, audio)
+	sec := psiSection(pmt[4:])
+	require.NotNil(sec)
+	assert.Equal(0x102, int(sec[8]&0x1f)<<8|int(sec[9]))
+	crc := mpegCRC32(sec)
+	assert.Equal([]byte{byte(crc >> 24), byte(crc >> 16), byte(crc >> 8), byte(crc)}, pmt[5+len(sec):9+len(sec)])
+
+	ts := parseTSTimestamps(out)
+	assert.Empty(ts.video)
+	assert.Equal([]int64{0, 1920}, ts.audio)
+
+	// nothing without audio
+	assert.Nil(extractTSAudio(tsTestSegment(tsTestFrames(0, 3000), nil)))
+	assert.Nil(extractTSAudio(nil))
+}
+
+func TestAudioProfiles(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	profiles, err := parseJSONProfiles([]jsonProfile{
+		{Name: "v", Width: 320, Height: 240, Bitrate: 400000},
+		{Name: "a", Bitrate: 64000, AudioCodec: "AAC"},
+		{Name: "b", AudioCodec: "aac", Format: "mpegts"},
+	})
+	require.Nil(err)
+	video, audio := splitAudioProfiles(profiles)
+	assert.Equal([]ffmpeg.VideoProfile{profiles[0]}, video)
+	assert.Equal([]ffmpeg.VideoProfile{
+		{Name: "a", Bitrate: "64000", Format: ffmpeg.FormatMPEGTS},
+		{Name: "b", Bitrate: "0", Format: ffmpeg.FormatMPEGTS},
+	}, audio)
+	assert.Nil(validateAudioProfiles(video, audio))
+
+	// only aac, remuxed into mpegts
+	for _, p := range []jsonProfile{
+		{Name: "a", AudioCodec: "opus"},
+		{Name: "a", AudioCodec: "aac", FPS: 30},
+		{Name: "a", AudioCodec: "aac", GOP: "2.0"},
+		{Name: "a", AudioCodec: "aac", Format: "cmaf"},
+		{Name: "a", AudioCodec: "aac", Bitrate: -1},
+	} {
+		_, err := parseJSONProfiles([]jsonProfile{p})
+		assert.NotNil(err, "profile %+v", p)
+	}
+
+	// named apart from the other renditions
+	assert.EqualError(validateAudioProfiles(video, []ffmpeg.VideoProfile{{Name: "v"}}), "duplicate profile name=v")
+	assert.EqualError(validateAudioProfiles(nil, []ffmpeg.VideoProfile{{Name: "a"}, {Name: "a"}}), "duplicate profile name=a")
+	assert.EqualError(validateAudioProfiles(nil, []ffmpeg.VideoProfile{{Name: audioTrackName}}), "duplicate profile name=audio")
+	// and not transcoded
+	assert.EqualError(validateProfiles(profiles), "audio-only profile not supported profile=a")
+}
+
+func TestAudioProfilesWebhook(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	s := setupServer()
+	defer serverCleanup(s)
+	defer func(profiles []ffmpeg.VideoProfile) { BroadcastJobVideoProfiles = profiles }(BroadcastJobVideoProfiles)
+	BroadcastJobVideoProfiles = []ffmpeg.VideoProfile{ffmpeg.P144p30fps16x9}
+
+	var resp string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(resp))
+	}))
+	defer ts.Close()
+	defer func(url string) { AuthWebhookURL = url }(AuthWebhookURL)
+	AuthWebhookURL = ts.URL
+	u, _ := url.Parse("rtmp://localhost/live/a")
+	params := func() *core.StreamParameters {
+		sid := createRTMPStreamIDHandler(s)(u)
+		if sid == nil {
+			return nil
+		}
+		return sid.(*core.StreamParameters)
+	}
+
+	// audio-only renditions alone keep the default ladder
+	resp = `{"manifestID":"a", "profiles": [{"name":"audio64","bitrate":64000,"audioCodec":"aac"}]}`
+	p := params()
+	require.NotNil(p)
+	assert.Equal(BroadcastJobVideoProfiles, p.Profiles)
+	assert.Equal([]ffmpeg.VideoProfile{{Name: "audio64", Bitrate: "64000", Format: ffmpeg.FormatMPEGTS}}, p.AudioProfiles)
+
+	resp = `{"manifestID":"a", "profiles": [{"name":"v","width":320,"height":240,"bitrate":400000}, {"name":"audio64","bitrate":64000,"audioCodec":"aac"}]}`
+	p = params()
+	require.NotNil(p)
+	require.Len(p.Profiles, 1)
+	assert.Equal("v", p.Profiles[0].Name)
+	assert.Len(p.AudioProfiles, 1)
+
+	resp = `{"manifestID":"a", "profiles": [{"name":"source","audioCodec":"aac"}]}`
+	assert.Nil(params())
+}
+
+func TestStoreAudioRenditions(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	mem, ok := drivers.NewMemoryDriver(nil).NewSession("mid").(*drivers.MemorySession)
+	require.True(ok)
+	cpl := core.NewBasicPlaylistManager("mid", mem, nil)
+	params := &core.StreamParameters{AudioProfiles: []ffmpeg.VideoProfile{
+		{Name: "a", Bitrate: "64000", Format: ffmpeg.FormatMPEGTS},
+		{Name: "b", Bitrate: "0", Format: ffmpeg.FormatMPEGTS},
+	}}
+	job := &SegmentJob{ManifestID: "mid", cxn: &rtmpConnection{params: params, pl: cpl}}
+
+	data := tsTestSegment(tsTestFrames(0, 3000, 3000), tsTestFrames(0, 1920))
+	job.storeAudioRenditions(&stream.HLSSegment{SeqNo: 3, Data: data, Duration: 2})
+	audio := extractTSAudio(data)
+	for _, name := range []string{"a", "b"} {
+		mpl := cpl.GetHLSMediaPlaylist(name)
+		require.NotNil(mpl, name)
+		require.Equal(uint(1), mpl.Count())
+		assert.True(strings.HasSuffix(mpl.Segments[0].URI, name+"/3.ts"))
+		assert.Equal(audio, mem.GetData(mpl.Segments[0].URI))
+	}
+
+	// advertised without a resolution, at the given or measured bitrate
+	master := cpl.GetHLSMasterPlaylist()
+	require.Len(master.Variants, 2)
+	assert.Equal("", master.Variants[0].Resolution)
+	assert.Equal(uint32(64000), master.Variants[0].Bandwidth)
+	assert.Equal(uint32(len(audio)*8/2), master.Variants[1].Bandwidth)
+
+	// nothing for segments without audio
+	job.storeAudioRenditions(&stream.HLSSegment{SeqNo: 4, Data: tsTestSegment(tsTestFrames(0, 3000), nil), Duration: 2})
+	assert.Equal(uint(1), cpl.GetHLSMediaPlaylist("a").Count())
+}
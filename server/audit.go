@@ -0,0 +1,388 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	gonet "net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/lpms/stream"
+)
+
+const (
+	auditCategoryIngest   = "ingest"
+	auditCategoryPlayback = "playback"
+	auditCategoryAdmin    = "admin"
+	auditCategoryAPI      = "api"
+	auditCategoryOther    = "other"
+)
+
+var errAuditRejected = errors.New("rejected")
+
+// Redaction options for the audit log
+const (
+	AuditRedactIP        = "ip"
+	AuditRedactKey       = "key"
+	AuditRedactQuery     = "query"
+	AuditRedactUserAgent = "ua"
+)
+
+// AuditLog receives a record for every RTMP publish and every ingest,
+// playback, API and admin request when set. Disabled by default.
+var AuditLog *AuditLogger
+
+// AuditLogger writes one JSON record per request or RTMP publish to a
+// rotating file or syslog
+type AuditLogger struct {
+	mu     sync.Mutex
+	out    io.Writer
+	redact map[string]bool
+}
+
+type auditRecord struct {
+	Time      string `json:"time"`
+	Category  string `json:"category"`
+	Protocol  string `json:"protocol"`
+	Method    string `json:"method"`
+	Path      string `json:"path"`
+	Query     string `json:"query,omitempty"`
+	KeyID     string `json:"keyID,omitempty"`
+	Addr      string `json:"addr,omitempty"`
+	UserAgent string `json:"ua,omitempty"`
+	Status    int    `json:"status,omitempty"`
+	Redirect  string `json:"redirect,omitempty"`
+	Error     string `json:"error,omitempty"`
+	BytesIn   int64  `json:"bytesIn"`
+	BytesOut  int64  `json:"bytesOut"`
+	Took      string `json:"took"`
+}
+
+// NewAuditLogger creates an audit logger. `dest` is either "syslog" or the
+// path of a file that is rotated once it grows past `maxSize` bytes, keeping
+// `maxBackups` old files around. `redact` lists the fields to redact.
+func NewAuditLogger(dest string, maxSize int64, maxBackups int, redact []string) (*AuditLogger, error) {
+	var out io.Writer
+	if dest == "syslog" {
+		w, err := newAuditSyslog()
+		if err != nil {
+			return nil, err
+		}
+		out = w
+	} else {
+		w, err := newRotatingFile(dest, maxSize, maxBackups)
+		if err != nil {
+			return nil, err
+		}
+		out = w
+	}
+	return newAuditLogger(out, redact)
+}
+
+func newAuditLogger(out io.Writer, redact []string) (*AuditLogger, error) {
+	l := &AuditLogger{out: out, redact: make(map[string]bool)}
+	for _, r := range redact {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		switch r {
+		case AuditRedactIP, AuditRedactKey, AuditRedactQuery, AuditRedactUserAgent:
+			l.redact[r] = true
+		default:
+			return nil, fmt.Errorf("unknown audit redaction option: %s", r)
+		}
+	}
+	return l, nil
+}
+
+// Handler wraps `h` so that every request it serves is audited under `category`
+func (l *AuditLogger) Handler(category string, h http.Handler) http.Handler {
+	if l == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		body := &countingReadCloser{ReadCloser: r.Body}
+		if r.Body != nil {
+			r.Body = body
+		}
+		aw := &auditResponseWriter{ResponseWriter: w, status: http.StatusOK}
+		// Capture before the handler gets a chance to rewrite the request URL
+		path, query := r.URL.Path, r.URL.RawQuery
+		h.ServeHTTP(aw, r)
+		cat := category
+		if cat == "" {
+			cat = auditCategory(r.Method, path)
+		}
+		var redirect string
+		if aw.status >= 300 && aw.status < 400 {
+			// such as playback of streams ingested by another cluster node
+			redirect = aw.Header().Get("Location")
+		}
+		l.log(&auditRecord{
+			Time:      start.UTC().Format(time.RFC3339Nano),
+			Category:  cat,
+			Protocol:  "http",
+			Method:    r.Method,
+			Path:      path,
+			Query:     query,
+			KeyID:     auditKeyID(path),
+			Addr:      r.RemoteAddr,
+			UserAgent: r.UserAgent(),
+			Status:    aw.status,
+			Redirect:  redirect,
+			BytesIn:   body.n,
+			BytesOut:  aw.n,
+			Took:      time.Since(start).String(),
+		})
+	})
+}
+
+type rtmpStreamIDHandler func(url *url.URL) stream.AppData
+type rtmpStreamHandler func(url *url.URL, rtmpStrm stream.RTMPVideoStream) error
+
+// RTMPPublishHandlers wraps the RTMP publish handlers of the media server so
+// that every publish, whether rejected or not, and its end are audited as
+// ingest
+func (l *AuditLogger) RTMPPublishHandlers(makeStreamID rtmpStreamIDHandler, gotStream, endStream rtmpStreamHandler) (rtmpStreamIDHandler, rtmpStreamHandler, rtmpStreamHandler) {
+	if l == nil {
+		return makeStreamID, gotStream, endStream
+	}
+	return func(url *url.URL) stream.AppData {
+			start := time.Now()
+			strmID := makeStreamID(url)
+			if strmID == nil {
+				// accepted publishes are audited once the stream is set up
+				l.logRTMP("PUBLISH", url, errAuditRejected, start)
+			}
+			return strmID
+		}, func(url *url.URL, rtmpStrm stream.RTMPVideoStream) error {
+			start := time.Now()
+			err := gotStream(url, rtmpStrm)
+			l.logRTMP("PUBLISH", url, err, start)
+			return err
+		}, func(url *url.URL, rtmpStrm stream.RTMPVideoStream) error {
+			start := time.Now()
+			err := endStream(url, rtmpStrm)
+			l.logRTMP("UNPUBLISH", url, err, start)
+			return err
+		}
+}
+
+func (l *AuditLogger) logRTMP(method string, u *url.URL, err error, start time.Time) {
+	rec := &auditRecord{
+		Time:     start.UTC().Format(time.RFC3339Nano),
+		Category: auditCategoryIngest,
+		Protocol: "rtmp",
+		Method:   method,
+		Path:     u.Path,
+		Query:    u.RawQuery,
+		KeyID:    auditKeyID(u.Path),
+		Took:     time.Since(start).String(),
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	l.log(rec)
+}
+
+func (l *AuditLogger) log(rec *auditRecord) {
+	if l.redact[AuditRedactIP] {
+		rec.Addr = redactAddr(rec.Addr)
+	}
+	if l.redact[AuditRedactKey] && rec.KeyID != "" {
+		rec.KeyID = redactHash(rec.KeyID)
+		rec.Path = strings.Replace(rec.Path, auditKeyID(rec.Path), rec.KeyID, 1)
+	}
+	if l.redact[AuditRedactQuery] && rec.Query != "" {
+		rec.Query = "redacted"
+	}
+	if l.redact[AuditRedactUserAgent] {
+		rec.UserAgent = ""
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		glog.Errorf("Error encoding audit record err=%v", err)
+		return
+	}
+	data = append(data, '\n')
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.out.Write(data); err != nil {
+		glog.Errorf("Error writing audit record err=%v", err)
+	}
+}
+
+// auditCategory infers the category of a request on the media server mux
+func auditCategory(method, path string) string {
+	switch {
+	case method == "DELETE" && strings.HasPrefix(path, "/live/"):
+		// operators ending streams
+		return auditCategoryAdmin
+	case strings.HasPrefix(path, "/live/"):
+		return auditCategoryIngest
+	case path == "/vod" || strings.HasPrefix(path, "/vod/"):
+		return auditCategoryAPI
+	case strings.HasPrefix(path, "/stream/"), strings.HasPrefix(path, "/recordings/"), strings.HasPrefix(path, hlsKeyPrefix):
+		return auditCategoryPlayback
+	}
+	return auditCategoryOther
+}
+
+// auditKeyID returns the stream key or manifest ID from the request path, if any
+func auditKeyID(path string) string {
+	for _, prefix := range []string{"/live/", "/stream/", "/recordings/"} {
+		if strings.HasPrefix(path, prefix) {
+			return string(parseManifestID(strings.TrimPrefix(path, prefix)))
+		}
+	}
+	return ""
+}
+
+// redactAddr drops the host part of an IPv4 or IPv6 address and the port
+func redactAddr(addr string) string {
+	host, _, err := gonet.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := gonet.ParseIP(host)
+	if ip == nil {
+		return "redacted"
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(gonet.CIDRMask(24, 32)).String()
+	}
+	return ip.Mask(gonet.CIDRMask(48, 128)).String()
+}
+
+func redactHash(s string) string {
+	h := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(h[:6])
+}
+
+type countingReadCloser struct {
+	io.ReadCloser
+	n int64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+type auditResponseWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	n           int64
+}
+
+func (w *auditResponseWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *auditResponseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.n += int64(n)
+	return n, err
+}
+
+// ReadFrom keeps the underlying writer's ReadFrom, and so sendfile, available
+// to handlers copying files into the response
+func (w *auditResponseWriter) ReadFrom(r io.Reader) (int64, error) {
+	w.wroteHeader = true
+	n, err := io.Copy(w.ResponseWriter, r)
+	w.n += n
+	return n, err
+}
+
+func (w *auditResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// rotatingFile is an append-only file that is renamed to `<path>.1` once it
+// exceeds its maximum size; older backups are shifted up to `maxBackups`
+type rotatingFile struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	f          *os.File
+	size       int64
+}
+
+func newRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	rf := &rotatingFile{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f = f
+	rf.size = fi.Size()
+	return nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.maxSize > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *rotatingFile) rotate() error {
+	if err := rf.f.Close(); err != nil {
+		return err
+	}
+	if rf.maxBackups <= 0 {
+		if err := os.Remove(rf.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return rf.open()
+	}
+	for i := rf.maxBackups - 1; i > 0; i-- {
+		from := fmt.Sprintf("%s.%d", rf.path, i)
+		if _, err := os.Stat(from); err == nil {
+			os.Rename(from, fmt.Sprintf("%s.%d", rf.path, i+1))
+		}
+	}
+	if err := os.Rename(rf.path, rf.path+".1"); err != nil {
+		return err
+	}
+	return rf.open()
+}
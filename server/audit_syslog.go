@@ -0,0 +1,13 @@
+//go:build !windows
+// +build !windows
+
+package server
+
+import (
+	"io"
+	"log/syslog"
+)
+
+func newAuditSyslog() (io.Writer, error) {
+	return syslog.New(syslog.LOG_INFO|syslog.LOG_AUTH, "livepeer-audit")
+}
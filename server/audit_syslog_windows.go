@@ -0,0 +1,10 @@
+package server
+
+import (
+	"errors"
+	"io"
+)
+
+func newAuditSyslog() (io.Writer, error) {
+	return nil, errors.New("syslog audit logging is not supported on windows")
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/lpms/stream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLogger_Handler(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var buf bytes.Buffer
+	l, err := newAuditLogger(&buf, nil)
+	require.Nil(err)
+
+	h := l.Handler("", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		w.WriteHeader(http.StatusTeapot)
+		w.Write([]byte("hello"))
+	}))
+	req := httptest.NewRequest("POST", "/live/mani/1.ts?foo=bar", strings.NewReader("abcd"))
+	req.RemoteAddr = "10.1.2.3:4567"
+	req.Header.Set("User-Agent", "lavf")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	var rec auditRecord
+	require.Nil(json.Unmarshal(buf.Bytes(), &rec))
+	assert.Equal(auditCategoryIngest, rec.Category)
+	assert.Equal("POST", rec.Method)
+	assert.Equal("/live/mani/1.ts", rec.Path)
+	assert.Equal("foo=bar", rec.Query)
+	assert.Equal("mani", rec.KeyID)
+	assert.Equal("10.1.2.3:4567", rec.Addr)
+	assert.Equal("lavf", rec.UserAgent)
+	assert.Equal(http.StatusTeapot, rec.Status)
+	assert.Equal(int64(4), rec.BytesIn)
+	assert.Equal(int64(5), rec.BytesOut)
+
+	// explicit category and playback inference
+	buf.Reset()
+	h = l.Handler(auditCategoryAdmin, http.NotFoundHandler())
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/status", nil))
+	require.Nil(json.Unmarshal(buf.Bytes(), &rec))
+	assert.Equal(auditCategoryAdmin, rec.Category)
+	assert.Equal(http.StatusNotFound, rec.Status)
+	assert.Equal(auditCategoryPlayback, auditCategory("GET", "/stream/mani.m3u8"))
+	assert.Equal(auditCategoryPlayback, auditCategory("GET", "/recordings/mani/index.m3u8"))
+	assert.Equal(auditCategoryPlayback, auditCategory("GET", "/hlskeys/mani/0.key"))
+	assert.Equal(auditCategoryAPI, auditCategory("GET", "/vod"))
+	assert.Equal(auditCategoryAPI, auditCategory("GET", "/vod/job1"))
+	assert.Equal(auditCategoryOther, auditCategory("GET", "/vodka"))
+	assert.Equal(auditCategoryOther, auditCategory("GET", "/"))
+	assert.Equal(auditCategoryAdmin, auditCategory("DELETE", "/live/mani"))
+
+	// redirects, such as to the cluster node ingesting a stream
+	buf.Reset()
+	h = l.Handler("", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://node2/stream/mani.m3u8", http.StatusTemporaryRedirect)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/stream/mani.m3u8", nil))
+	require.Nil(json.Unmarshal(buf.Bytes(), &rec))
+	assert.Equal(auditCategoryPlayback, rec.Category)
+	assert.Equal(http.StatusTemporaryRedirect, rec.Status)
+	assert.Equal("http://node2/stream/mani.m3u8", rec.Redirect)
+
+	// copies into the response keep going through ReadFrom
+	buf.Reset()
+	h = l.Handler("", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, ok := w.(io.ReaderFrom)
+		assert.True(ok)
+		io.Copy(w, strings.NewReader("segment"))
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/recordings/mani/1.ts", nil))
+	require.Nil(json.Unmarshal(buf.Bytes(), &rec))
+	assert.Equal(int64(7), rec.BytesOut)
+
+	// nil logger is a passthrough
+	var nl *AuditLogger
+	called := false
+	nl.Handler("", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true })).
+		ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	assert.True(called)
+}
+
+func TestAuditLogger_RTMP(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var buf bytes.Buffer
+	l, err := newAuditLogger(&buf, []string{"query"})
+	require.Nil(err)
+
+	var params stream.AppData
+	var gotErr error
+	makeStreamID, gotStream, endStream := l.RTMPPublishHandlers(
+		func(url *url.URL) stream.AppData { return params },
+		func(url *url.URL, rtmpStrm stream.RTMPVideoStream) error { return gotErr },
+		func(url *url.URL, rtmpStrm stream.RTMPVideoStream) error { return nil },
+	)
+	records := func() []auditRecord {
+		var recs []auditRecord
+		dec := json.NewDecoder(&buf)
+		for dec.More() {
+			var rec auditRecord
+			require.Nil(dec.Decode(&rec))
+			recs = append(recs, rec)
+		}
+		return recs
+	}
+	u, err := url.Parse("rtmp://localhost/live/mani?token=abc")
+	require.Nil(err)
+
+	// rejected before the stream is set up
+	assert.Nil(makeStreamID(u))
+	recs := records()
+	require.Len(recs, 1)
+	assert.Equal(auditCategoryIngest, recs[0].Category)
+	assert.Equal("rtmp", recs[0].Protocol)
+	assert.Equal("PUBLISH", recs[0].Method)
+	assert.Equal("/live/mani", recs[0].Path)
+	assert.Equal("redacted", recs[0].Query)
+	assert.Equal("mani", recs[0].KeyID)
+	assert.Equal("rejected", recs[0].Error)
+
+	// accepted publishes are audited once, as are their ends
+	params = &core.StreamParameters{ManifestID: "mani"}
+	assert.Equal(params, makeStreamID(u))
+	assert.Nil(gotStream(u, nil))
+	assert.Nil(endStream(u, nil))
+	recs = records()
+	require.Len(recs, 2)
+	assert.Equal("PUBLISH", recs[0].Method)
+	assert.Empty(recs[0].Error)
+	assert.Equal("UNPUBLISH", recs[1].Method)
+
+	gotErr = errAlreadyExists
+	assert.Equal(gotErr, gotStream(u, nil))
+	recs = records()
+	require.Len(recs, 1)
+	assert.Equal(gotErr.Error(), recs[0].Error)
+
+	// nil logger is a passthrough
+	var nl *AuditLogger
+	makeStreamID, _, _ = nl.RTMPPublishHandlers(
+		func(url *url.URL) stream.AppData { return params }, nil, nil)
+	assert.Equal(params, makeStreamID(u))
+}
+
+func TestAuditLogger_Redaction(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	_, err := newAuditLogger(&bytes.Buffer{}, []string{"ip", "nope"})
+	assert.EqualError(err, "unknown audit redaction option: nope")
+
+	var buf bytes.Buffer
+	l, err := newAuditLogger(&buf, []string{"ip", " key", "query", "ua"})
+	require.Nil(err)
+
+	h := l.Handler("", http.NotFoundHandler())
+	req := httptest.NewRequest("GET", "/stream/secretkey/source.m3u8?token=abc", nil)
+	req.RemoteAddr = "10.1.2.3:4567"
+	req.Header.Set("User-Agent", "curl")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	var rec auditRecord
+	require.Nil(json.Unmarshal(buf.Bytes(), &rec))
+	assert.Equal("10.1.2.0", rec.Addr)
+	assert.Equal(redactHash("secretkey"), rec.KeyID)
+	assert.NotContains(rec.Path, "secretkey")
+	assert.Equal("/stream/"+rec.KeyID+"/source.m3u8", rec.Path)
+	assert.Equal("redacted", rec.Query)
+	assert.Empty(rec.UserAgent)
+
+	assert.Equal("2001:db8:1::", redactAddr("[2001:db8:1:2::1]:80"))
+	assert.Equal("redacted", redactAddr("not-an-ip"))
+}
+
+func TestRotatingFile(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dir, err := ioutil.TempDir("", "audit")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+	fname := filepath.Join(dir, "audit.log")
+
+	rf, err := newRotatingFile(fname, 10, 2)
+	require.Nil(err)
+	for _, s := range []string{"aaaaaaaa", "bbbbbbbb", "cccccccc", "dddddddd"} {
+		_, err := rf.Write([]byte(s))
+		require.Nil(err)
+	}
+
+	read := func(name string) string {
+		data, err := ioutil.ReadFile(name)
+		require.Nil(err)
+		return string(data)
+	}
+	assert.Equal("dddddddd", read(fname))
+	assert.Equal("cccccccc", read(fname+".1"))
+	assert.Equal("bbbbbbbb", read(fname+".2"))
+	_, err = os.Stat(fname + ".3")
+	assert.True(os.IsNotExist(err))
+}
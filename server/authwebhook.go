@@ -0,0 +1,65 @@
+package server
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common"
+)
+
+// AuthWebhookRetries is the number of times the auth webhook is asked again
+// about a new stream after a network error, a 5xx or a 429 response
+var AuthWebhookRetries = 2
+
+// AuthWebhookRetryBackoff is the wait before the first retry of the auth
+// webhook, doubled after each retry
+var AuthWebhookRetryBackoff = 500 * time.Millisecond
+
+// AuthWebhookCacheTTL, if non-zero, makes new streams reuse the response of
+// the auth webhook for the same stream URL for this long, so that publishers
+// reconnecting don't hit the webhook every time
+var AuthWebhookCacheTTL time.Duration
+
+// AuthWebhookFailOpen makes the node accept new streams with the default
+// settings when the auth webhook is still failing after the retries, rather
+// than reject them. Streams the webhook denied are rejected either way.
+var AuthWebhookFailOpen bool
+
+var authWebhookCache = newTTLCache(time.Minute)
+
+// authenticateIngest authenticates the new stream at `url` with the auth
+// webhook, retrying temporary failures and reusing cached responses
+func authenticateIngest(url string) (*authWebhookResponse, error) {
+	if AuthWebhookURL == "" {
+		return nil, nil
+	}
+	if AuthWebhookCacheTTL > 0 {
+		if resp, ok := authWebhookCache.get(url); ok {
+			return resp.(*authWebhookResponse), nil
+		}
+	}
+	backoff := AuthWebhookRetryBackoff
+	for attempt := 0; ; attempt++ {
+		resp, err := authenticateStream(url)
+		if err == nil {
+			if AuthWebhookCacheTTL > 0 {
+				authWebhookCache.setFor(url, resp, AuthWebhookCacheTTL)
+			}
+			return resp, nil
+		}
+		if !isRetryable(err) {
+			return nil, err
+		}
+		if attempt >= AuthWebhookRetries {
+			if AuthWebhookFailOpen {
+				glog.Warningf("Auth webhook unavailable, accepting stream url=%s attempts=%d err=%v", common.RedactURL(url), attempt+1, err)
+				return nil, nil
+			}
+			return nil, err
+		}
+		glog.V(common.DEBUG).Infof("Error calling auth webhook url=%s retryIn=%v err=%v", common.RedactURL(url), backoff, err)
+		t := clock.NewTimer(backoff)
+		<-t.C()
+		backoff *= 2
+	}
+}
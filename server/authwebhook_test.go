@@ -0,0 +1,115 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthenticateIngest(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	c := useFakeClock(t)
+
+	defer func(url string, ttl time.Duration, failOpen bool) {
+		AuthWebhookURL, AuthWebhookCacheTTL, AuthWebhookFailOpen = url, ttl, failOpen
+		authWebhookCache = newTTLCache(time.Minute)
+	}(AuthWebhookURL, AuthWebhookCacheTTL, AuthWebhookFailOpen)
+
+	var calls int32
+	status, failures := int32(http.StatusInternalServerError), int32(2)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= atomic.LoadInt32(&failures) {
+			w.WriteHeader(int(atomic.LoadInt32(&status)))
+			return
+		}
+		w.Write([]byte(`{"manifestID":"mani"}`))
+	}))
+	defer ts.Close()
+	AuthWebhookURL = ts.URL
+
+	type result struct {
+		resp *authWebhookResponse
+		err  error
+	}
+	authenticate := func(url string) chan result {
+		atomic.StoreInt32(&calls, 0)
+		ch := make(chan result, 1)
+		go func() {
+			resp, err := authenticateIngest(url)
+			ch <- result{resp, err}
+		}()
+		return ch
+	}
+	wait := func(ch chan result) result {
+		select {
+		case res := <-ch:
+			return res
+		case <-time.After(time.Second):
+			t.Fatal("authentication did not finish")
+		}
+		return result{}
+	}
+
+	// temporary failures are retried with backoff
+	ch := authenticate("rtmp://localhost/live/a")
+	assert.True(c.waitTimers(1))
+	c.Advance(AuthWebhookRetryBackoff)
+	assert.True(c.waitTimers(1))
+	c.Advance(2 * AuthWebhookRetryBackoff)
+	res := wait(ch)
+	require.Nil(res.err)
+	assert.Equal("mani", res.resp.ManifestID)
+	assert.Equal(int32(3), atomic.LoadInt32(&calls))
+
+	// denials are not
+	atomic.StoreInt32(&status, http.StatusForbidden)
+	res = wait(authenticate("rtmp://localhost/live/a"))
+	assert.Error(res.err)
+	assert.Equal(int32(1), atomic.LoadInt32(&calls))
+
+	// nor is the webhook asked again within the time to live of its response
+	AuthWebhookCacheTTL = time.Minute
+	atomic.StoreInt32(&failures, 0)
+	res = wait(authenticate("rtmp://localhost/live/a"))
+	require.Nil(res.err)
+	res = wait(authenticate("rtmp://localhost/live/a"))
+	require.Nil(res.err)
+	assert.Equal("mani", res.resp.ManifestID)
+	assert.Equal(int32(0), atomic.LoadInt32(&calls))
+	c.Advance(AuthWebhookCacheTTL)
+	wait(authenticate("rtmp://localhost/live/a"))
+	assert.Equal(int32(1), atomic.LoadInt32(&calls))
+
+	// streams are rejected once retries run out, unless failing open
+	atomic.StoreInt32(&status, http.StatusServiceUnavailable)
+	atomic.StoreInt32(&failures, 100)
+	ch = authenticate("rtmp://localhost/live/b")
+	for i := 0; i < AuthWebhookRetries; i++ {
+		assert.True(c.waitTimers(1))
+		c.Advance(time.Minute)
+	}
+	res = wait(ch)
+	assert.Error(res.err)
+	assert.Equal(int32(AuthWebhookRetries+1), atomic.LoadInt32(&calls))
+
+	AuthWebhookFailOpen = true
+	ch = authenticate("rtmp://localhost/live/b")
+	for i := 0; i < AuthWebhookRetries; i++ {
+		assert.True(c.waitTimers(1))
+		c.Advance(time.Minute)
+	}
+	res = wait(ch)
+	assert.Nil(res.err)
+	assert.Nil(res.resp)
+
+	// but denials still stand
+	atomic.StoreInt32(&status, http.StatusForbidden)
+	res = wait(authenticate("rtmp://localhost/live/b"))
+	assert.Error(res.err)
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/m3u8"
+)
+
+// blockingReloadMSN returns the media sequence number a playlist request asks
+// to be held for with its _HLS_msn parameter, if any
+func blockingReloadMSN(u *url.URL) (uint64, bool) {
+	if !core.BlockingPlaylistReload {
+		return 0, false
+	}
+	msn, err := strconv.ParseUint(u.Query().Get("_HLS_msn"), 10, 64)
+	return msn, err == nil
+}
+
+// awaitPlaylistSegment holds a request for the media playlist of `rendition`
+// until it has the segment `msn`, for up to three target durations. It
+// returns false right away for segments more than two ahead of the playlist,
+// which are bad requests.
+func awaitPlaylistSegment(cpl core.PlaylistManager, rendition string, msn uint64) bool {
+	timeout := clock.NewTimer(3 * playlistTargetDuration(cpl.GetHLSMediaPlaylist(rendition)))
+	defer timeout.Stop()
+	for {
+		// get the channel first so that no insertion is missed in between
+		inserted := cpl.SegmentInserted()
+		if last, ok := lastSegmentSeq(cpl.GetHLSMediaPlaylist(rendition)); ok {
+			if msn > last+2 {
+				return false
+			}
+			if msn <= last {
+				return true
+			}
+		}
+		select {
+		case <-inserted:
+		case <-timeout.C():
+			return true
+		}
+	}
+}
+
+// lastSegmentSeq returns the highest media sequence number in `pl`
+func lastSegmentSeq(pl *m3u8.MediaPlaylist) (uint64, bool) {
+	if pl == nil {
+		return 0, false
+	}
+	var last uint64
+	found := false
+	for _, seg := range pl.Segments {
+		if seg != nil && (!found || seg.SeqId > last) {
+			last, found = seg.SeqId, true
+		}
+	}
+	return last, found
+}
+
+func playlistTargetDuration(pl *m3u8.MediaPlaylist) time.Duration {
+	if pl == nil || pl.TargetDuration <= 0 {
+		return SegLen
+	}
+	return time.Duration(pl.TargetDuration * float64(time.Second))
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"fmt"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/lpms/ffmpeg"
+	"github.com/livepeer/lpms/vidplayer"
+	"github.com/livepeer/m3u8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlockingReloadMSN(t *testing.T) {
+	assert := assert.New(t)
+
+	u, _ := url.Parse("/stream/mid/P144p30fps16x9.m3u8?_HLS_msn=7&_HLS_part=2")
+	_, ok := blockingReloadMSN(u)
+	assert.False(ok)
+
+	core.BlockingPlaylistReload = true
+	defer func() { core.BlockingPlaylistReload = false }()
+	msn, ok := blockingReloadMSN(u)
+	assert.True(ok)
+	assert.Equal(uint64(7), msn)
+	u, _ = url.Parse("/stream/mid/P144p30fps16x9.m3u8")
+	_, ok = blockingReloadMSN(u)
+	assert.False(ok)
+}
+
+func TestAwaitPlaylistSegment(t *testing.T) {
+	assert := assert.New(t)
+	c := useFakeClock(t)
+
+	cpl := core.NewBasicPlaylistManager("mid", nil, nil)
+	profile := &ffmpeg.P144p30fps16x9
+	assert.Nil(cpl.InsertHLSSegment(profile, 1, "1.ts", 2))
+
+	await := func(msn uint64) chan struct{} {
+		done := make(chan struct{})
+		go func() {
+			assert.True(awaitPlaylistSegment(cpl, profile.Name, msn))
+			close(done)
+		}()
+		return done
+	}
+	isDone := func(done chan struct{}) bool {
+		select {
+		case <-done:
+			return true
+		case <-time.After(20 * time.Millisecond):
+			return false
+		}
+	}
+
+	// segments in the playlist already, or too far ahead of it
+	assert.True(isDone(await(1)))
+	assert.False(awaitPlaylistSegment(cpl, profile.Name, 4))
+
+	// held until the segment is in the playlist
+	done := await(3)
+	assert.True(c.waitTimers(1))
+	assert.Nil(cpl.InsertHLSSegment(profile, 2, "2.ts", 2))
+	assert.False(isDone(done))
+	assert.Nil(cpl.InsertHLSSegment(profile, 3, "3.ts", 2))
+	assert.True(isDone(done))
+
+	// or for three target durations at most
+	done = await(5)
+	assert.True(c.waitTimers(1))
+	c.Advance(5 * time.Second)
+	assert.False(isDone(done))
+	c.Advance(time.Second)
+	assert.True(isDone(done))
+}
+
+func TestBlockingReload_Handler(t *testing.T) {
+	assert := assert.New(t)
+	s := setupServer()
+	defer serverCleanup(s)
+	core.BlockingPlaylistReload = true
+	defer func() { core.BlockingPlaylistReload = false }()
+
+	// the live playlist ends at segment 6
+	s.rtmpConnections.store("mani", startOverConnection(t))
+	defer s.rtmpConnections.delete("mani")
+	handler := getHLSMediaPlaylistHandler(s)
+	get := func(msn int) (*m3u8.MediaPlaylist, error) {
+		u, err := url.Parse(fmt.Sprintf("/stream/mani/source.m3u8?_HLS_msn=%d", msn))
+		require.Nil(t, err)
+		return handler(u)
+	}
+
+	mpl, err := get(6)
+	assert.Nil(err)
+	assert.Contains(mpl.String(), "mani/source/6.ts")
+
+	// more than two segments ahead of the playlist
+	_, err = get(9)
+	assert.Equal(vidplayer.ErrBadRequest, err)
+}
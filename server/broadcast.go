@@ -4,7 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"math"
+	"io"
 	"math/big"
 	"net/url"
 	"strconv"
@@ -36,8 +36,24 @@ var Policy *verification.Policy
 var BroadcastCfg = &BroadcastConfig{}
 var MaxAttempts = 3
 
+// DegradeCapabilities makes streams drop the renditions that need
+// capabilities an orchestrator rejected a segment for lacking, rather than
+// retry the segment with the same renditions elsewhere
+var DegradeCapabilities bool
+
+// FormatFallbackAttempts is how many attempts in a row at transcoding the
+// segments of a stream may fail with muxing errors before a rendition of the
+// stream in another container than MPEG-TS falls back to MPEG-TS; 0 to never
+// fall back
+var FormatFallbackAttempts = 2
+
+// formatErrorPatterns are parts of the errors of orchestrators, lowercased,
+// that tell of failures to write the container of a rendition
+var formatErrorPatterns = []string{"mux", "write header", "writing header"}
+
 var getOrchestratorInfoRPC = GetOrchestratorInfo
 var downloadSeg = drivers.GetSegmentData
+var downloadSegStream = drivers.GetSegmentReader
 
 type BroadcastConfig struct {
 	maxPrice *big.Rat
@@ -71,12 +87,15 @@ type BroadcastSessionsManager struct {
 	lastSess *BroadcastSession
 	numOrchs int // how many orchs to request at once
 	poolSize int
+	// refresh once fewer sessions than this are left
+	refreshBelow int
 
 	refreshing bool // only allow one refresh in-flight
 	finished   bool // set at stream end
 
 	createSessions func() ([]*BroadcastSession, error)
 	sus            *suspender
+	snapshots      *sessionSnapshots
 }
 
 func (bsm *BroadcastSessionsManager) selectSession() *BroadcastSession {
@@ -85,7 +104,7 @@ func (bsm *BroadcastSessionsManager) selectSession() *BroadcastSession {
 
 	checkSessions := func(m *BroadcastSessionsManager) bool {
 		numSess := m.sel.Size()
-		if numSess < int(math.Ceil(float64(m.numOrchs)/2.0)) {
+		if numSess < m.refreshBelow {
 			go m.refreshSessions()
 		}
 		return (numSess > 0 || bsm.lastSess != nil)
@@ -94,7 +113,7 @@ func (bsm *BroadcastSessionsManager) selectSession() *BroadcastSession {
 		var sess *BroadcastSession
 
 		if bsm.lastSess != nil && len(bsm.lastSess.SegsInFlight) > 0 &&
-			time.Since(bsm.lastSess.SegsInFlight[0].startTime) < bsm.lastSess.SegsInFlight[0].segDur {
+			clock.Since(bsm.lastSess.SegsInFlight[0].startTime) < bsm.lastSess.SegsInFlight[0].segDur {
 			// Re-use last session if oldest segment is in-flight for < segDur
 			sess = bsm.lastSess
 		} else {
@@ -104,7 +123,7 @@ func (bsm *BroadcastSessionsManager) selectSession() *BroadcastSession {
 
 		// If no new sessions are available, re-use last session when oldest segment is in-flight for < 2 * segDur
 		if sess == nil && bsm.lastSess != nil && len(bsm.lastSess.SegsInFlight) > 0 &&
-			time.Since(bsm.lastSess.SegsInFlight[0].startTime) < 2*bsm.lastSess.SegsInFlight[0].segDur {
+			clock.Since(bsm.lastSess.SegsInFlight[0].startTime) < 2*bsm.lastSess.SegsInFlight[0].segDur {
 			glog.V(common.DEBUG).Infof("No sessions in the selector for manifestID=%v re-using orch=%v with acceptable in-flight time", bsm.mid, bsm.lastSess.OrchestratorInfo.Transcoder)
 			sess = bsm.lastSess
 		}
@@ -126,6 +145,13 @@ func (bsm *BroadcastSessionsManager) selectSession() *BroadcastSession {
 		   To avoid a runtime search of the session list under lock, simply
 		   fixup the session list at selection time by retrying the selection.
 		*/
+		if _, ok := bsm.sessMap[sess.OrchestratorInfo.Transcoder]; ok && maintenanceWithin(sess.OrchestratorInfo, clock.Now(), maintenanceMigrationLead) {
+			// Move away ahead of the maintenance of the orchestrator, through
+			// the removed session path below
+			glog.Infof("Removing orch=%v from manifestID=%s session list ahead of its maintenance window", sess.OrchestratorInfo.Transcoder, bsm.mid)
+			delete(bsm.sessMap, sess.OrchestratorInfo.Transcoder)
+			go bsm.refreshSessions()
+		}
 		if _, ok := bsm.sessMap[sess.OrchestratorInfo.Transcoder]; ok {
 			if bsm.lastSess != nil && bsm.lastSess.OrchestratorInfo.Transcoder != sess.OrchestratorInfo.Transcoder {
 				glog.V(common.DEBUG).Infof("Swapping from orch=%v to orch=%v for manifestID=%s", bsm.lastSess.OrchestratorInfo.Transcoder, sess.OrchestratorInfo.Transcoder, bsm.mid)
@@ -160,6 +186,7 @@ func (bsm *BroadcastSessionsManager) removeSession(session *BroadcastSession) {
 	defer bsm.sessLock.Unlock()
 
 	delete(bsm.sessMap, session.OrchestratorInfo.Transcoder)
+	bsm.snapshots.drop(session)
 }
 
 func (bsm *BroadcastSessionsManager) completeSession(sess *BroadcastSession) {
@@ -234,6 +261,9 @@ func (bsm *BroadcastSessionsManager) refreshSessions() {
 		}
 		uniqueSessions = append(uniqueSessions, sess)
 		bsm.sessMap[sess.OrchestratorInfo.Transcoder] = sess
+		if OrchNotifications {
+			orchSubs.watch(bsm, sess)
+		}
 	}
 
 	bsm.sel.Add(uniqueSessions)
@@ -244,7 +274,7 @@ func (bsm *BroadcastSessionsManager) pushSegInFlight(sess *BroadcastSession, seg
 	defer bsm.sessLock.Unlock()
 	sess.SegsInFlight = append(sess.SegsInFlight,
 		SegFlightMetadata{
-			startTime: time.Now(),
+			startTime: clock.Now(),
 			segDur:    time.Duration(seg.Duration * float64(time.Second)),
 		})
 }
@@ -264,31 +294,53 @@ func (bsm *BroadcastSessionsManager) cleanup() {
 	bsm.lastSess = nil
 	bsm.sel.Clear()
 	bsm.sessMap = make(map[string]*BroadcastSession) // prevent segfaults
+	orchSubs.unwatch(bsm)
 }
 
 func (bsm *BroadcastSessionsManager) suspendOrch(sess *BroadcastSession) {
+	orchPerf.failure(sess.OrchestratorInfo.GetTranscoder())
 	bsm.sus.suspend(sess.OrchestratorInfo.GetTranscoder(), bsm.poolSize/bsm.numOrchs)
 }
 
+// scores returns the scores of the orchestrators of the stream at their last
+// selection; nil if its selector doesn't score them
+func (bsm *BroadcastSessionsManager) scores() []SessionScore {
+	bsm.sessLock.Lock()
+	defer bsm.sessLock.Unlock()
+	if sel, ok := bsm.sel.(interface{ Scores() []SessionScore }); ok {
+		return sel.Scores()
+	}
+	return nil
+}
+
 func NewSessionManager(node *core.LivepeerNode, params *core.StreamParameters, sel BroadcastSessionsSelector) *BroadcastSessionsManager {
 	var poolSize float64
 	if node.OrchestratorPool != nil {
 		poolSize = float64(node.OrchestratorPool.Size())
 	}
-	maxInflight := common.HTTPTimeout.Seconds() / SegLen.Seconds()
-	numOrchs := int(math.Min(poolSize, maxInflight*2))
+	maxInflight := common.HTTPTimeout.Seconds() / streamSegLen(params).Seconds()
+	policy := policyFor(params.Priority)
+	numOrchs := policy.numOrchs(poolSize, maxInflight*2)
 	sus := newSuspender()
+	snapshots := newSessionSnapshots(node.Database, params.ManifestID)
 	bsm := &BroadcastSessionsManager{
 		mid:     params.ManifestID,
 		sel:     sel,
 		sessMap: make(map[string]*BroadcastSession),
 		createSessions: func() ([]*BroadcastSession, error) {
+			// Resume the sessions the stream had before the node restarted,
+			// if any, rather than selecting orchestrators anew
+			if sessions := snapshots.restore(node, params); len(sessions) > 0 {
+				return sessions, nil
+			}
 			return selectOrchestrator(node, params, numOrchs, sus)
 		},
-		sessLock: &sync.Mutex{},
-		numOrchs: numOrchs,
-		poolSize: int(poolSize),
-		sus:      sus,
+		snapshots:    snapshots,
+		sessLock:     &sync.Mutex{},
+		numOrchs:     numOrchs,
+		poolSize:     int(poolSize),
+		refreshBelow: policy.refreshBelow(numOrchs),
+		sus:          sus,
 	}
 	bsm.refreshSessions()
 	return bsm
@@ -306,86 +358,200 @@ func selectOrchestrator(n *core.LivepeerNode, params *core.StreamParameters, cou
 		return nil, errNoOrchs
 	}
 	if err != nil {
-		return nil, err
+		return nil, wrapError(ErrorCategoryDiscovery, true, err)
 	}
 
 	var sessions []*BroadcastSession
 
 	for _, tinfo := range tinfos {
-		var (
-			sessionID    string
-			balance      Balance
-			ticketParams *pm.TicketParams
-		)
+		if maintenanceWithin(tinfo, clock.Now(), MaintenanceLookahead) {
+			glog.V(common.DEBUG).Infof("Not selecting orch=%s ahead of its maintenance window manifestID=%s", tinfo.Transcoder, params.ManifestID)
+			continue
+		}
+		if SegmentEncryption && len(tinfo.SegmentKey) == 0 {
+			glog.V(common.DEBUG).Infof("Not selecting orch=%s without segment encryption support manifestID=%s", tinfo.Transcoder, params.ManifestID)
+			continue
+		}
 
+		var sessionID string
 		if n.Sender != nil && tinfo.TicketParams != nil {
-			ticketParams = pmTicketParams(tinfo.TicketParams)
-			sessionID = n.Sender.StartSession(*ticketParams)
+			sessionID = n.Sender.StartSession(*pmTicketParams(tinfo.TicketParams))
 		}
 
-		if n.Balances != nil {
-			balance = core.NewBalance(ticketParams.Recipient, core.ManifestID(tinfo.AuthToken.SessionId), n.Balances)
-		}
+		sessions = append(sessions, newBroadcastSession(n, params, tinfo, sessionID))
+	}
+	return sessions, nil
+}
+
+// newBroadcastSession returns a session of the stream with the orchestrator
+// `tinfo`, paid with the PM session `sessionID`
+func newBroadcastSession(n *core.LivepeerNode, params *core.StreamParameters, tinfo *net.OrchestratorInfo, sessionID string) *BroadcastSession {
+	var balance Balance
+	if n.Balances != nil {
+		balance = core.NewBalance(pmTicketParams(tinfo.TicketParams).Recipient, core.ManifestID(tinfo.AuthToken.SessionId), n.Balances)
+	}
+
+	var orchOS drivers.OSSession
+	if len(tinfo.Storage) > 0 {
+		orchOS = drivers.NewSession(tinfo.Storage[0])
+	}
+
+	bcastOS := params.OS
+	if bcastOS.IsExternal() {
+		// Give each O its own OS session to prevent front running uploads
+		pfx := fmt.Sprintf("%v/%v", params.ManifestID, tinfo.AuthToken.SessionId)
+		bcastOS = bcastOS.OS().NewSession(pfx)
+	}
+
+	return &BroadcastSession{
+		Broadcaster:      core.NewBroadcaster(n),
+		Params:           params,
+		OrchestratorInfo: tinfo,
+		OrchestratorOS:   orchOS,
+		BroadcasterOS:    bcastOS,
+		Sender:           n.Sender,
+		PMSessionID:      sessionID,
+		Balances:         n.Balances,
+		Balance:          balance,
+	}
+}
+
+// processSegment stores and transcodes a source segment. `buf`, if set, is the
+// segment buffer backing seg.Data; it is shared with the memory object store
+// and must stay retained by the caller until processSegment returns.
+func processSegment(cxn *rtmpConnection, seg *stream.HLSSegment, buf *common.SegmentBuffer) ([]string, error) {
+	job := &SegmentJob{ManifestID: cxn.mid, Nonce: cxn.nonce, Segment: seg, cxn: cxn, buf: buf}
+	start := clock.Now()
+	urls, err := job.process()
+	cxn.journal.record(job, start, err)
+	meterSegment(job, start, err)
+	atomic.AddUint64(&cxn.segments, 1)
+	if err != nil {
+		atomic.AddUint64(&cxn.failedSegments, 1)
+	}
+	if err == nil && job.Session != nil {
+		cxn.sessManager.snapshots.save(job.Session)
+		ev := newLifecycleEvent(cxn, segmentTranscodedEvent)
+		seqNo := seg.SeqNo
+		ev.SeqNo = &seqNo
+		sendStreamEvent(ev)
+	}
+	return urls, err
+}
+
+// process runs the stages of the segment, retrying the transcoding stages up
+// to MaxAttempts times, adjusted for the priority class of the stream
+func (job *SegmentJob) process() ([]string, error) {
+	cxn, seg := job.cxn, job.Segment
+	if err := cxn.pipeline.run(StageValidate, job, (*SegmentJob).validate); err != nil {
+		return nil, err
+	}
+	if err := cxn.pipeline.run(StageStoreSource, job, (*SegmentJob).storeSource); err != nil {
+		return nil, err
+	}
+
+	// Segments ingested while the node is starting up are saved but have to
+	// wait for it to be able to transcode them
+	if err := StartupReady.Wait(); err != nil {
+		glog.Errorf("Node not ready to transcode nonce=%d manifestID=%s seqNo=%d err=%v", job.Nonce, job.ManifestID, seg.SeqNo, err)
+		return nil, err
+	}
+
+	// The recordings of record only streams are transcoded later
+	if cxn.params != nil && cxn.params.RecordOnly {
+		return nil, nil
+	}
 
-		var orchOS drivers.OSSession
-		if len(tinfo.Storage) > 0 {
-			orchOS = drivers.NewSession(tinfo.Storage[0])
+	if Policy != nil {
+		job.verifier = verification.NewSegmentVerifier(Policy)
+	}
+
+	var err error
+	for i := 0; i < policyFor(cxn.priority()).attempts(); i++ {
+		// if fails, retry; rudimentary
+		var urls []string
+		if urls, err = job.transcode(); err == nil {
+			return urls, nil
 		}
 
-		bcastOS := params.OS
-		if bcastOS.IsExternal() {
-			// Give each O its own OS session to prevent front running uploads
-			pfx := fmt.Sprintf("%v/%v", params.ManifestID, tinfo.AuthToken.SessionId)
-			bcastOS = bcastOS.OS().NewSession(pfx)
+		if shouldStopStream(err) {
+			glog.Warningf("Stopping current stream due to: %v", err)
+			cxn.rtmpStream().Close()
+			return nil, err
 		}
 
-		session := &BroadcastSession{
-			Broadcaster:      core.NewBroadcaster(n),
-			Params:           params,
-			OrchestratorInfo: tinfo,
-			OrchestratorOS:   orchOS,
-			BroadcasterOS:    bcastOS,
-			Sender:           n.Sender,
-			PMSessionID:      sessionID,
-			Balances:         n.Balances,
-			Balance:          balance,
+		if isNonRetryableError(err) {
+			glog.Warningf("Not retrying current segment nonce=%d seqNo=%d due to non-retryable error err=%v", job.Nonce, seg.SeqNo, err)
+			return nil, err
 		}
 
-		sessions = append(sessions, session)
+		// recoverable error, retry
 	}
-	return sessions, nil
+	if err != nil {
+		err = fmt.Errorf("Hit max transcode attempts: %w", err)
+	}
+	return nil, err
 }
 
-func processSegment(cxn *rtmpConnection, seg *stream.HLSSegment) ([]string, error) {
-
-	rtmpStrm := cxn.stream
-	nonce := cxn.nonce
-	cpl := cxn.pl
-	mid := cxn.mid
-	vProfile := cxn.profile
-
+// validate checks the duration of the source segment and accounts for it
+// before it is stored
+func (job *SegmentJob) validate() error {
+	cxn, seg := job.cxn, job.Segment
 	if seg.Duration > maxDurationSec || seg.Duration < 0 {
-		glog.Errorf("Invalid duration nonce=%d manifestID=%s seqNo=%d dur=%v", nonce, mid, seg.SeqNo, seg.Duration)
-		return nil, fmt.Errorf("Invalid duration %v", seg.Duration)
+		glog.Errorf("Invalid duration nonce=%d manifestID=%s seqNo=%d dur=%v", job.Nonce, job.ManifestID, seg.SeqNo, seg.Duration)
+		return wrapError(ErrorCategoryIngest, false, fmt.Errorf("Invalid duration %v", seg.Duration))
 	}
 
-	glog.V(common.DEBUG).Infof("Processing segment nonce=%d manifestID=%s seqNo=%d dur=%v bytes=%v", nonce, mid, seg.SeqNo, seg.Duration, len(seg.Data))
+	glog.V(common.DEBUG).Infof("Processing segment nonce=%d manifestID=%s seqNo=%d dur=%v bytes=%v", job.Nonce, job.ManifestID, seg.SeqNo, seg.Duration, len(seg.Data))
 	if monitor.Enabled {
-		monitor.SegmentEmerged(nonce, seg.SeqNo, len(BroadcastJobVideoProfiles), seg.Duration)
+		monitor.SegmentEmerged(job.Nonce, seg.SeqNo, len(BroadcastJobVideoProfiles), seg.Duration)
 	}
 	atomic.AddUint64(&cxn.sourceBytes, uint64(len(seg.Data)))
+	cxn.source.check(seg.SeqNo, seg.Data, seg.Duration)
+	Cluster.sawSeqNo(job.ManifestID, seg.SeqNo)
+	seg.Data = cxn.sanitizer.sanitize(seg.SeqNo, seg.Data)
+	if cxn.params != nil {
+		if err := SourceCaps.apply(cxn.params.Resolution, cxn.params.Format, seg); err != nil {
+			glog.Errorf("Rejecting source segment nonce=%d manifestID=%s seqNo=%d err=%v", job.Nonce, job.ManifestID, seg.SeqNo, err)
+			return err
+		}
+	}
+	if cxn.params != nil && cxn.params.AutoLadder {
+		cxn.ladderOnce.Do(func() { refineAutoLadder(cxn.params, seg.Data, seg.Duration) })
+	}
+	cxn.state.save(seg.SeqNo, cxn.params)
+	return nil
+}
+
+// storeSource pushes the source segment to its restream targets, saves it to
+// the object stores of the stream and inserts it into the source playlist,
+// along with its audio into those of the audio-only renditions
+func (job *SegmentJob) storeSource() error {
+	cxn, seg, buf := job.cxn, job.Segment, job.buf
+	nonce, mid := job.Nonce, job.ManifestID
+	cpl := cxn.pl
+	vProfile := cxn.profile
+
+	cxn.restreams.push(restreamSourceProfile, seg.Data)
 
 	seg.Name = "" // hijack seg.Name to convey the uploaded URI
 	ext, err := common.ProfileFormatExtension(vProfile.Format)
 	if err != nil {
 		glog.Errorf("Unknown format extension manifestID=%s seqNo=%d err=%s", mid, seg.SeqNo, err)
-		return nil, err
+		return err
 	}
 	name := fmt.Sprintf("%s/%d%s", vProfile.Name, seg.SeqNo, ext)
+	job.Name = name
 	ros := cpl.GetRecordOSSession()
 	segDurMs := getSegDurMsString(seg)
 	if ros != nil {
+		if buf != nil {
+			buf.Retain()
+		}
 		go func() {
+			if buf != nil {
+				defer buf.Release()
+			}
 			now := time.Now()
 			uri, err := drivers.SaveRetried(ros, name, seg.Data, map[string]string{"duration": segDurMs}, 2)
 			took := time.Since(now)
@@ -393,7 +559,8 @@ func processSegment(cxn *rtmpConnection, seg *stream.HLSSegment) ([]string, erro
 				glog.Errorf("Error saving nonce=%d manifestID=%s name=%s bytes=%d to record store err=%v",
 					nonce, mid, name, len(seg.Data), err)
 			} else {
-				cpl.InsertHLSSegmentJSON(vProfile, seg.SeqNo, uri, seg.Duration)
+				cpl.InsertHLSSegmentJSON(vProfile, seg.SeqNo, uri, seg.Duration, nil)
+				cxn.checksums.add(vProfile.Name, seg.SeqNo, uri, seg.Data)
 				glog.Infof("Successfully saved nonce=%d manifestID=%s name=%s bytes=%d to record store took=%s",
 					nonce, mid, name, len(seg.Data), took)
 				cpl.FlushRecord()
@@ -402,14 +569,15 @@ func processSegment(cxn *rtmpConnection, seg *stream.HLSSegment) ([]string, erro
 				monitor.RecordingSegmentSaved(took, err)
 			}
 		}()
+		job.recordAudioTrack(seg)
 	}
-	uri, err := cpl.GetOSSession().SaveData(name, seg.Data, nil)
+	uri, err := saveSourceSegment(cpl.GetOSSession(), name, seg, buf)
 	if err != nil {
 		glog.Errorf("Error saving segment nonce=%d seqNo=%d: %v", nonce, seg.SeqNo, err)
 		if monitor.Enabled {
 			monitor.SegmentUploadFailed(nonce, seg.SeqNo, monitor.SegmentUploadErrorUnknown, err, true)
 		}
-		return nil, err
+		return wrapError(ErrorCategoryStorage, false, err)
 	}
 	if cpl.GetOSSession().IsExternal() {
 		seg.Name = uri // hijack seg.Name to convey the uploaded URI
@@ -424,46 +592,65 @@ func processSegment(cxn *rtmpConnection, seg *stream.HLSSegment) ([]string, erro
 			monitor.SegmentUploadFailed(nonce, seg.SeqNo, monitor.SegmentUploadErrorDuplicateSegment, err, false)
 		}
 	}
+	job.storeAudioRenditions(seg)
+	return nil
+}
 
-	var sv *verification.SegmentVerifier
-	if Policy != nil {
-		sv = verification.NewSegmentVerifier(Policy)
+// saveSourceSegment hands the segment buffer to the memory object store so the
+// source data is not duplicated; other stores get a plain copy of the data.
+func saveSourceSegment(sess drivers.OSSession, name string, seg *stream.HLSSegment, buf *common.SegmentBuffer) (string, error) {
+	if memOS, ok := sess.(*drivers.MemorySession); ok && buf != nil {
+		return memOS.SaveBuffer(name, buf)
 	}
+	return sess.SaveData(name, seg.Data, nil)
+}
 
-	for i := 0; i < MaxAttempts; i++ {
-		// if fails, retry; rudimentary
-		var urls []string
-		if urls, err = transcodeSegment(cxn, seg, name, sv); err == nil {
-			return urls, nil
-		}
-
-		if shouldStopStream(err) {
-			glog.Warningf("Stopping current stream due to: %v", err)
-			rtmpStrm.Close()
-			return nil, err
-		}
+// transcodeSegment makes a single attempt at transcoding a source segment
+// stored under `name`
+func transcodeSegment(cxn *rtmpConnection, seg *stream.HLSSegment, name string,
+	verifier *verification.SegmentVerifier) ([]string, error) {
 
-		if isNonRetryableError(err) {
-			glog.Warningf("Not retrying current segment nonce=%d seqNo=%d due to non-retryable error err=%v", nonce, seg.SeqNo, err)
-			return nil, err
-		}
+	job := &SegmentJob{ManifestID: cxn.mid, Nonce: cxn.nonce, Segment: seg, Name: name, cxn: cxn, verifier: verifier}
+	return job.transcode()
+}
 
-		// recoverable error, retry
+// transcode runs the transcoding stages of the segment, returning the URLs of
+// its renditions. Returns no URLs and no error if no session is available.
+func (job *SegmentJob) transcode() ([]string, error) {
+	job.Session, job.Result, job.URLs, job.InitURLs, job.Data, job.Sizes, job.errCode = nil, nil, nil, nil, nil, nil, ""
+	p := job.cxn.pipeline
+	if err := p.run(StageSelectSession, job, (*SegmentJob).selectSession); err != nil {
+		return nil, err
+	}
+	// View-only (non-transcoded) streams or no sessions available
+	if job.Session == nil {
+		return nil, nil
 	}
+	submitStart := clock.Now()
+	err := p.run(StageSubmit, job, (*SegmentJob).submit)
+	job.TranscodeTook = clock.Since(submitStart)
 	if err != nil {
-		err = fmt.Errorf("Hit max transcode attempts: %w", err)
+		return nil, err
 	}
-	return nil, err
+	if err := p.run(StageStoreRenditions, job, (*SegmentJob).storeRenditions); err != nil {
+		return nil, err
+	}
+	if err := p.run(StageVerify, job, (*SegmentJob).verifyRenditions); err != nil {
+		return nil, err
+	}
+	if err := p.run(StageUpdatePlaylists, job, (*SegmentJob).updatePlaylists); err != nil {
+		return nil, err
+	}
+	glog.V(common.DEBUG).Infof("Successfully validated segment nonce=%d seqNo=%d", job.Nonce, job.Segment.SeqNo)
+	return job.URLs, nil
 }
 
-func transcodeSegment(cxn *rtmpConnection, seg *stream.HLSSegment, name string,
-	verifier *verification.SegmentVerifier) ([]string, error) {
-
-	nonce := cxn.nonce
-	cpl := cxn.pl
+// selectSession picks the session to transcode the segment with, uploading
+// the segment to the storage the orchestrator prefers and refreshing the
+// session if needed
+func (job *SegmentJob) selectSession() error {
+	cxn, seg, nonce := job.cxn, job.Segment, job.Nonce
 	sess := cxn.sessManager.selectSession()
-	// Return early under a few circumstances:
-	// View-only (non-transcoded) streams or no sessions available
 	if sess == nil {
 		if monitor.Enabled {
 			monitor.SegmentTranscodeFailed(monitor.SegmentTranscodeErrorNoOrchestrators, nonce, seg.SeqNo, errNoOrchs, true)
@@ -472,7 +659,7 @@ func transcodeSegment(cxn *rtmpConnection, seg *stream.HLSSegment, name string,
 		// We may want to introduce a "non-retryable" error type here
 		// would help error propagation for live ingest.
 		// similar to the orchestrator's RemoteTranscoderFatalError
-		return nil, nil
+		return nil
 	}
 
 	glog.Infof("Trying to transcode segment nonce=%d seqNo=%d", nonce, seg.SeqNo)
@@ -483,7 +670,7 @@ func transcodeSegment(cxn *rtmpConnection, seg *stream.HLSSegment, name string,
 	// storage the orchestrator prefers
 	if ios := sess.OrchestratorOS; ios != nil {
 		// XXX handle case when orch expects direct upload
-		uri, err := ios.SaveData(name, seg.Data, nil)
+		uri, err := ios.SaveData(job.Name, seg.Data, nil)
 		if err != nil {
 			glog.Errorf("Error saving segment to OS nonce=%d seqNo=%d: %v", nonce, seg.SeqNo, err)
 			if monitor.Enabled {
@@ -491,7 +678,7 @@ func transcodeSegment(cxn *rtmpConnection, seg *stream.HLSSegment, name string,
 			}
 			cxn.sessManager.suspendOrch(sess)
 			cxn.sessManager.removeSession(sess)
-			return nil, err
+			return wrapError(ErrorCategoryStorage, true, err)
 		}
 		seg.Name = uri // hijack seg.Name to convey the uploaded URI
 	}
@@ -501,7 +688,7 @@ func transcodeSegment(cxn *rtmpConnection, seg *stream.HLSSegment, name string,
 		glog.Errorf("Error checking whether to refresh session manifestID=%s orch=%v err=%v", cxn.mid, sess.OrchestratorInfo.Transcoder, err)
 		cxn.sessManager.suspendOrch(sess)
 		cxn.sessManager.removeSession(sess)
-		return nil, err
+		return wrapError(ErrorCategoryDiscovery, true, err)
 	}
 
 	if refresh {
@@ -510,38 +697,152 @@ func transcodeSegment(cxn *rtmpConnection, seg *stream.HLSSegment, name string,
 			glog.Errorf("Error refreshing session manifestID=%s orch=%v err=%v", cxn.mid, sess.OrchestratorInfo.Transcoder, err)
 			cxn.sessManager.suspendOrch(sess)
 			cxn.sessManager.removeSession(sess)
-			return nil, err
+			return wrapError(ErrorCategoryDiscovery, true, err)
 		}
 		// if sess was lastSess, we need to update lastSess,
 		// or else content of SegsInFlight will be lost
 		cxn.sessManager.updateLastSession(sess, newSess)
 		sess = newSess
 	}
+	job.Session = sess
+	return nil
+}
 
+// submit sends the segment to the orchestrator of the session and archives
+// the result
+func (job *SegmentJob) submit() error {
+	cxn, sess, seg, nonce := job.cxn, job.Session, job.Segment, job.Nonce
 	cxn.sessManager.pushSegInFlight(sess, seg)
 	res, err := SubmitSegment(sess, seg, nonce)
 	if err != nil || res == nil {
 		if isNonRetryableError(err) {
 			cxn.sessManager.completeSession(sess)
-			return nil, err
+			return err
 		}
 		cxn.sessManager.suspendOrch(sess)
 		cxn.sessManager.removeSession(sess)
 		if res == nil && err == nil {
-			err = errors.New("empty response")
+			err = newError(ErrorCategoryTranscode, true, "empty response")
 		}
-		return nil, err
+		var mismatch *CapabilityMismatchError
+		if errors.As(err, &mismatch) {
+			job.degrade(mismatch)
+		}
+		job.fallBack(err)
+		return err
+	}
+	job.Result = res
+	atomic.StoreInt32(&cxn.formatErrors, 0)
+
+	if ResponseArchiveRetention > 0 {
+		if ros := cxn.pl.GetRecordOSSession(); ros != nil {
+			rec := newArchivedResponse(sess, nonce, seg.SeqNo, res, clock.Now())
+			go func() {
+				if _, err := rec.save(ros); err != nil {
+					glog.Errorf("Error archiving transcode result nonce=%d seqNo=%d err=%v", nonce, rec.SeqNo, err)
+				}
+			}()
+		}
+	}
+	return nil
+}
+
+// degrade drops the renditions of the stream that need the capabilities
+// missing for a segment, if DegradeCapabilities is set, so that the segment
+// is retried without them
+func (job *SegmentJob) degrade(mismatch *CapabilityMismatchError) {
+	params := job.cxn.params
+	if !DegradeCapabilities || params == nil {
+		glog.Errorf("Orchestrator lacks capabilities for segment nonce=%d manifestID=%s seqNo=%d missing=%s",
+			job.Nonce, job.ManifestID, job.Segment.SeqNo, core.CapabilityNames(mismatch.Missing))
+		return
+	}
+	profiles, err := core.DegradeProfiles(params.Profiles, mismatch.Missing)
+	if err != nil {
+		glog.Errorf("Unable to degrade stream nonce=%d manifestID=%s missing=%s err=%v",
+			job.Nonce, job.ManifestID, core.CapabilityNames(mismatch.Missing), err)
+		return
 	}
+	degraded := *params
+	degraded.Profiles = profiles
+	caps, err := core.JobCapabilities(&degraded)
+	if err != nil {
+		glog.Errorf("Unable to degrade stream nonce=%d manifestID=%s err=%v", job.Nonce, job.ManifestID, err)
+		return
+	}
+	// As with auto ladders, the profiles are replaced rather than changed in
+	// place, so that profiles already read for segments in flight stay as
+	// they were
+	params.Profiles = profiles
+	params.Capabilities = caps
+	glog.Warningf("Degraded stream for missing capabilities nonce=%d manifestID=%s missing=%s profiles=%v",
+		job.Nonce, job.ManifestID, core.CapabilityNames(mismatch.Missing), common.ProfilesNames(profiles))
+}
+
+// fallBack moves the first rendition of the stream in another container
+// than MPEG-TS to MPEG-TS once FormatFallbackAttempts attempts in a row failed
+// with muxing errors, as orchestrators cannot tell which rendition failed
+func (job *SegmentJob) fallBack(err error) {
+	cxn, params := job.cxn, job.cxn.params
+	if FormatFallbackAttempts <= 0 || params == nil || !isFormatError(err) {
+		return
+	}
+	if atomic.AddInt32(&cxn.formatErrors, 1) < int32(FormatFallbackAttempts) {
+		return
+	}
+	atomic.StoreInt32(&cxn.formatErrors, 0)
+	profiles := append([]ffmpeg.VideoProfile(nil), params.Profiles...)
+	i := 0
+	for ; i < len(profiles); i++ {
+		if f := profiles[i].Format; f != ffmpeg.FormatNone && f != ffmpeg.FormatMPEGTS {
+			break
+		}
+	}
+	if i == len(profiles) {
+		glog.Errorf("Repeated format errors without renditions to fall back nonce=%d manifestID=%s err=%v", job.Nonce, job.ManifestID, err)
+		return
+	}
+	profiles[i].Format = ffmpeg.FormatMPEGTS
+	fallback := *params
+	fallback.Profiles = profiles
+	caps, cerr := core.JobCapabilities(&fallback)
+	if cerr != nil {
+		glog.Errorf("Unable to fall back rendition nonce=%d manifestID=%s err=%v", job.Nonce, job.ManifestID, cerr)
+		return
+	}
+	// replaced rather than changed in place, as in degrade
+	params.Profiles = profiles
+	params.Capabilities = caps
+	glog.Warningf("Fell back rendition to MPEG-TS after repeated format errors nonce=%d manifestID=%s rendition=%s err=%v",
+		job.Nonce, job.ManifestID, profiles[i].Name, err)
+	sendStreamEvent(&streamEvent{Event: "renditionFallback", ManifestID: string(job.ManifestID), Time: clock.Now().Unix(),
+		Labels: params.Labels.Get(), Rendition: profiles[i].Name, Error: newStreamEventError(err)})
+}
+
+func isFormatError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, p := range formatErrorPatterns {
+		if strings.Contains(msg, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// storeRenditions downloads the renditions from the transcoder where needed
+// and saves them to the object stores of the stream
+func (job *SegmentJob) storeRenditions() error {
+	cxn, sess, seg, res, nonce := job.cxn, job.Session, job.Segment, job.Result, job.Nonce
+	cpl := cxn.pl
 
 	// download transcoded segments from the transcoder
 	gotErr := false // only send one error msg per segment list
-	var errCode monitor.SegmentTranscodeError
 	errFunc := func(subType monitor.SegmentTranscodeError, url string, err error) {
-		glog.Errorf("%v error with segment nonce=%d seqNo=%d: %v (URL: %v)", subType, nonce, seg.SeqNo, err, url)
+		glog.Errorf("%v error with segment nonce=%d seqNo=%d: %v (URL: %v)", subType, nonce, seg.SeqNo, err, common.RedactURL(url))
 		if monitor.Enabled && !gotErr {
 			monitor.SegmentTranscodeFailed(subType, nonce, seg.SeqNo, err, false)
 			gotErr = true
-			errCode = subType
+			job.errCode = subType
 		}
 	}
 
@@ -549,11 +850,13 @@ func transcodeSegment(cxn *rtmpConnection, seg *stream.HLSSegment, name string,
 	segData := make([][]byte, len(res.Segments))
 	n := len(res.Segments)
 	segURLs := make([]string, len(res.Segments))
+	initURLs := make([]string, len(res.Segments))
+	segSizes := make([]int64, len(res.Segments))
 	segLock := &sync.Mutex{}
 	cond := sync.NewCond(segLock)
 	var recordWG sync.WaitGroup
 
-	dlFunc := func(url string, pixels int64, i int) {
+	dlFunc := func(url string, pixels int64, encoder *core.EncoderSettings, i int) {
 		defer func() {
 			cond.L.Lock()
 			n--
@@ -568,24 +871,51 @@ func transcodeSegment(cxn *rtmpConnection, seg *stream.HLSSegment, name string,
 
 		bros := cpl.GetRecordOSSession()
 		var data []byte
+		size := int64(-1)
+		dlFail := func(err error) {
+			errFunc(monitor.SegmentTranscodeErrorDownload, url, err)
+			segLock.Lock()
+			dlErr = err
+			segLock.Unlock()
+			cxn.sessManager.suspendOrch(sess)
+			cxn.sessManager.removeSession(sess)
+		}
+		saveFail := func(err error) {
+			switch err.Error() {
+			case "Session ended":
+				errFunc(monitor.SegmentTranscodeErrorSessionEnded, url, err)
+			default:
+				errFunc(monitor.SegmentTranscodeErrorSaveData, url, err)
+			}
+		}
+		// If the segment data is only needed for the broadcaster's own OS,
+		// stream it there rather than holding the whole rendition in memory
+		streamSaver, canStream := bos.(drivers.StreamSaver)
+		restream := cxn.restreams.wants(profile.Name)
+		cmaf := bos != nil && isCMAF(cxn.params, profile)
+		checkGOPs := RenditionGOPAlignment != GOPAlignmentOff
+		streamed := canStream && job.verifier == nil && bros == nil && !restream && !cmaf && !checkGOPs && !bos.IsOwn(url)
+
 		// Download segment data in the following cases:
 		// - A verification policy is set. The segment data is needed for signature verification and/or pixel count verification
 		// - The segment data needs to be uploaded to the broadcaster's own OS
-		if verifier != nil || bros != nil || bos != nil && !bos.IsOwn(url) {
+		// - The rendition is pushed to a restream target
+		// - The rendition is packaged as CMAF
+		// - The keyframes of the renditions are checked for alignment
+		if !streamed && (job.verifier != nil || bros != nil || restream || cmaf || checkGOPs || bos != nil && !bos.IsOwn(url)) {
 			d, err := downloadSeg(url)
 			if err != nil {
-				errFunc(monitor.SegmentTranscodeErrorDownload, url, err)
-				segLock.Lock()
-				dlErr = err
-				segLock.Unlock()
-				cxn.sessManager.suspendOrch(sess)
-				cxn.sessManager.removeSession(sess)
+				dlFail(err)
 				return
 			}
 
 			data = d
+			size = int64(len(data))
 			atomic.AddUint64(&cxn.transcodedBytes, uint64(len(data)))
 		}
+		if restream {
+			cxn.restreams.push(profile.Name, data)
+		}
 
 		if bros != nil {
 			go func() {
@@ -598,7 +928,8 @@ func transcodeSegment(cxn *rtmpConnection, seg *stream.HLSSegment, name string,
 				if err != nil {
 					glog.Errorf("Error saving nonce=%d manifestID=%s name=%s to record store err=%v", nonce, cxn.mid, name, err)
 				} else {
-					cpl.InsertHLSSegmentJSON(&profile, seg.SeqNo, uri, seg.Duration)
+					cpl.InsertHLSSegmentJSON(&profile, seg.SeqNo, uri, seg.Duration, encoder)
+					cxn.checksums.add(profile.Name, seg.SeqNo, uri, data)
 					glog.Infof("Successfully saved nonce=%d manifestID=%s name=%s size=%d bytes to record store took=%s",
 						nonce, cxn.mid, name, len(data), took)
 				}
@@ -609,22 +940,47 @@ func transcodeSegment(cxn *rtmpConnection, seg *stream.HLSSegment, name string,
 			}()
 		}
 
-		if bos != nil && !bos.IsOwn(url) {
+		var initURL string
+		if cmaf {
+			newURL, newInitURL, err := cxn.cmafInits.saveSegment(bos, profile.Name, seg.SeqNo, data)
+			if err != nil {
+				saveFail(err)
+				return
+			}
+			url, initURL = newURL, newInitURL
+		} else if bos != nil && !bos.IsOwn(url) {
 			ext, err := common.ProfileFormatExtension(profile.Format)
 			if err != nil {
 				errFunc(monitor.SegmentTranscodeErrorSaveData, url, err)
 				return
 			}
 			name := fmt.Sprintf("%s/%d%s", profile.Name, seg.SeqNo, ext)
-			newURL, err := bos.SaveData(name, data, nil)
-			if err != nil {
-				switch err.Error() {
-				case "Session ended":
-					errFunc(monitor.SegmentTranscodeErrorSessionEnded, url, err)
-				default:
-					errFunc(monitor.SegmentTranscodeErrorSaveData, url, err)
+			var newURL string
+			if streamed {
+				body, size, err := downloadSegStream(url)
+				if err != nil {
+					dlFail(err)
+					return
+				}
+				cr := &countingReader{r: body}
+				newURL, err = streamSaver.SaveStream(name, cr, size, nil)
+				body.Close()
+				size = cr.n
+				atomic.AddUint64(&cxn.transcodedBytes, uint64(cr.n))
+				if cr.err != nil {
+					dlFail(cr.err)
+					return
+				}
+				if err != nil {
+					saveFail(err)
+					return
+				}
+			} else {
+				newURL, err = bos.SaveData(name, data, nil)
+				if err != nil {
+					saveFail(err)
+					return
 				}
-				return
 			}
 			url = newURL
 		}
@@ -636,7 +992,9 @@ func transcodeSegment(cxn *rtmpConnection, seg *stream.HLSSegment, name string,
 		// data. Not an issue if the delivery protocol is being obeyed.
 		segLock.Lock()
 		segURLs[i] = url
+		initURLs[i] = initURL
 		segData[i] = data
+		segSizes[i] = size
 		segLock.Unlock()
 
 		if monitor.Enabled {
@@ -649,7 +1007,7 @@ func transcodeSegment(cxn *rtmpConnection, seg *stream.HLSSegment, name string,
 		recordWG.Add(len(res.Segments))
 	}
 	for i, v := range res.Segments {
-		go dlFunc(v.Url, v.Pixels, i)
+		go dlFunc(v.Url, v.Pixels, core.EncoderSettingsFromNet(v.EncoderSettings), i)
 	}
 	if cpl.GetRecordOSSession() != nil && len(res.Segments) > 0 {
 		go func() {
@@ -664,33 +1022,71 @@ func transcodeSegment(cxn *rtmpConnection, seg *stream.HLSSegment, name string,
 	}
 	cond.L.Unlock()
 	if dlErr != nil {
-		return nil, dlErr
+		return dlErr
 	}
+	job.URLs, job.InitURLs, job.Data, job.Sizes = segURLs, initURLs, segData, segSizes
 
+	orchPerf.success(sess.OrchestratorInfo.GetTranscoder(), res.LatencyScore)
 	cxn.sessManager.completeSession(updateSession(sess, res))
 
 	downloadDur := time.Since(dlStart)
 	if monitor.Enabled {
 		monitor.SegmentDownloaded(nonce, seg.SeqNo, downloadDur)
 	}
+	return nil
+}
 
-	if verifier != nil {
-		// verify potentially can change content of segURLs
-		err := verify(verifier, cxn, sess, seg, res.TranscodeData, segURLs, segData)
-		if err != nil {
-			glog.Errorf("Error verifying nonce=%d manifestID=%s seqNo=%d err=%s", nonce, cxn.mid, seg.SeqNo, err)
-			return nil, err
-		}
+// verifyRenditions checks the renditions against the verification policy,
+// if any, and that their keyframes are aligned if RenditionGOPAlignment
+// requires it
+func (job *SegmentJob) verifyRenditions() error {
+	if RenditionGOPAlignment != GOPAlignmentOff {
+		job.checkGOPAlignment()
+	}
+	if job.verifier == nil {
+		return nil
 	}
+	// verify potentially can change content of job.URLs
+	err := verify(job.verifier, job.cxn, job.Session, job.Segment, job.Result.TranscodeData, job.URLs, job.Data)
+	if err != nil {
+		glog.Errorf("Error verifying nonce=%d manifestID=%s seqNo=%d err=%s", job.Nonce, job.ManifestID, job.Segment.SeqNo, err)
+		return err
+	}
+	return nil
+}
+
+// checkGOPAlignment reports renditions of the segment whose keyframes aren't
+// aligned with those of the others. They are still used, as the keyframes of
+// the source they follow may be no better.
+func (job *SegmentJob) checkGOPAlignment() {
+	params := job.Session.Params
+	rendition := misalignedRendition(params.Profiles, job.Data)
+	if rendition == "" {
+		return
+	}
+	glog.Warningf("Misaligned keyframes in renditions nonce=%d manifestID=%s seqNo=%d rendition=%s orch=%s",
+		job.Nonce, job.ManifestID, job.Segment.SeqNo, rendition, job.Session.OrchestratorInfo.GetTranscoder())
+	seqNo := job.Segment.SeqNo
+	sendStreamEvent(&streamEvent{Event: "gopMisaligned", ManifestID: string(job.ManifestID), Time: clock.Now().Unix(),
+		Nonce: job.Nonce, SeqNo: &seqNo, Labels: params.Labels.Get(), Rendition: rendition})
+}
 
-	for i, url := range segURLs {
-		err := cpl.InsertHLSSegment(&sess.Params.Profiles[i], seg.SeqNo, url, seg.Duration)
+// updatePlaylists inserts the renditions into the playlists of the stream
+func (job *SegmentJob) updatePlaylists() error {
+	sess, seg, nonce := job.Session, job.Segment, job.Nonce
+	for i, url := range job.URLs {
+		var err error
+		if i < len(job.InitURLs) && job.InitURLs[i] != "" {
+			err = job.cxn.pl.InsertCMAFSegment(&sess.Params.Profiles[i], seg.SeqNo, url, job.InitURLs[i], seg.Duration)
+		} else {
+			err = job.cxn.pl.InsertHLSSegment(&sess.Params.Profiles[i], seg.SeqNo, url, seg.Duration)
+		}
 		if err != nil {
 			// InsertHLSSegment only returns ErrSegmentAlreadyExists error
 			// Right now InsertHLSSegment call is atomic regarding transcoded segments - we either inserting
 			// all the transcoded segments or none, so we shouldn't hit this error
 			// But report in case that InsertHLSSegment changed or something wrong is going on in other parts of workflow
-			glog.Errorf("Playlist insertion error nonce=%d manifestID=%s seqNo=%d err=%s", nonce, cxn.mid, seg.SeqNo, err)
+			glog.Errorf("Playlist insertion error nonce=%d manifestID=%s seqNo=%d err=%s", nonce, job.ManifestID, seg.SeqNo, err)
 			if monitor.Enabled {
 				monitor.SegmentTranscodeFailed(monitor.SegmentTranscodeErrorDuplicateSegment, nonce, seg.SeqNo, err, false)
 			}
@@ -698,11 +1094,27 @@ func transcodeSegment(cxn *rtmpConnection, seg *stream.HLSSegment, name string,
 	}
 
 	if monitor.Enabled {
-		monitor.SegmentFullyTranscoded(nonce, seg.SeqNo, common.ProfilesNames(sess.Params.Profiles), errCode)
+		monitor.SegmentFullyTranscoded(nonce, seg.SeqNo, common.ProfilesNames(sess.Params.Profiles), job.errCode)
 	}
+	return nil
+}
 
-	glog.V(common.DEBUG).Infof("Successfully validated segment nonce=%d seqNo=%d", nonce, seg.SeqNo)
-	return segURLs, nil
+// countingReader counts the bytes read and records any read error, so that
+// failures to download a streamed segment can be told apart from failures to
+// save it
+type countingReader struct {
+	r   io.Reader
+	n   int64
+	err error
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	if err != nil && err != io.EOF {
+		cr.err = err
+	}
+	return n, err
 }
 
 var sessionErrStrings = []string{"dial tcp", "unexpected EOF", core.ErrOrchBusy.Error(), core.ErrOrchCap.Error()}
@@ -822,6 +1234,8 @@ func refreshSession(sess *BroadcastSession) (*BroadcastSession, error) {
 	}
 	ctx, cancel := context.WithTimeout(context.Background(), refreshTimeout)
 	defer cancel()
+	// continue the session rather than starting over with cold transcode contexts
+	ctx = withSessionToken(ctx, sess.OrchestratorInfo.AuthToken)
 
 	oInfo, err := getOrchestratorInfoRPC(ctx, sess.Broadcaster, uri)
 	if err != nil {
@@ -845,7 +1259,7 @@ func shouldRefreshSession(sess *BroadcastSession) (bool, error) {
 	// Refresh auth token if we are within the last 10% of the token's valid period
 	authTokenExpireBuffer := 0.1
 	refreshPoint := sess.OrchestratorInfo.AuthToken.Expiration - int64(authTokenValidPeriod.Seconds()*authTokenExpireBuffer)
-	if time.Now().After(time.Unix(refreshPoint, 0)) {
+	if clock.Now().After(time.Unix(refreshPoint, 0)) {
 		glog.V(common.VERBOSE).Infof("Auth token expired, refreshing for orch=%v", sess.OrchestratorInfo.Transcoder)
 
 		return true, nil
@@ -4,12 +4,15 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math/big"
 	"net/http"
 	"net/url"
 	"strings"
 	"sync"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	ethcommon "github.com/ethereum/go-ethereum/common"
@@ -67,9 +70,10 @@ func bsmWithSessList(sessList []*BroadcastSession) *BroadcastSessionsManager {
 		createSessions: func() ([]*BroadcastSession, error) {
 			return sessList, nil
 		},
-		sus:      newSuspender(),
-		numOrchs: 1,
-		poolSize: len(sessList),
+		sus:          newSuspender(),
+		numOrchs:     1,
+		poolSize:     len(sessList),
+		refreshBelow: 1,
 	}
 }
 
@@ -161,6 +165,10 @@ func (pm *stubPlaylistManager) InsertHLSSegment(profile *ffmpeg.VideoProfile, se
 	return nil
 }
 
+func (pm *stubPlaylistManager) InsertCMAFSegment(profile *ffmpeg.VideoProfile, seqNo uint64, uri, initURI string, duration float64) error {
+	return pm.InsertHLSSegment(profile, seqNo, uri, duration)
+}
+
 func (pm *stubPlaylistManager) GetHLSMasterPlaylist() *m3u8.MasterPlaylist {
 	return nil
 }
@@ -169,16 +177,28 @@ func (pm *stubPlaylistManager) GetHLSMediaPlaylist(rendition string) *m3u8.Media
 	return nil
 }
 
+func (pm *stubPlaylistManager) SegmentInserted() <-chan struct{} {
+	return nil
+}
+
+func (pm *stubPlaylistManager) GetDASHManifest(now time.Time) ([]byte, error) {
+	return nil, nil
+}
+
 func (pm *stubPlaylistManager) GetOSSession() drivers.OSSession {
 	return pm.os
 }
 
-func (pm *stubPlaylistManager) Cleanup()     {}
-func (pm *stubPlaylistManager) FlushRecord() {}
+func (pm *stubPlaylistManager) Cleanup()                       {}
+func (pm *stubPlaylistManager) FlushRecord()                   {}
+func (pm *stubPlaylistManager) MarkDiscontinuity(seqNo uint64) {}
 func (pm *stubPlaylistManager) GetRecordOSSession() drivers.OSSession {
 	return nil
 }
-func (pm *stubPlaylistManager) InsertHLSSegmentJSON(profile *ffmpeg.VideoProfile, seqNo uint64, uri string, duration float64) {
+func (pm *stubPlaylistManager) GetRecording() *core.JsonPlaylist {
+	return nil
+}
+func (pm *stubPlaylistManager) InsertHLSSegmentJSON(profile *ffmpeg.VideoProfile, seqNo uint64, uri string, duration float64, encoder *core.EncoderSettings) {
 }
 
 type stubSelector struct {
@@ -327,6 +347,7 @@ func TestSelectSession(t *testing.T) {
 	assert.Len(bsm.sessMap, 1)
 
 	// XXX check refresh condition more precisely - currently numOrchs / 2
+	// for streams of the normal priority class
 }
 
 func TestSelectSession_MultipleInFlight2(t *testing.T) {
@@ -1086,21 +1107,21 @@ func TestProcessSegment_MaxAttempts(t *testing.T) {
 
 	// Sanity check: zero attempts should not transcode
 	MaxAttempts = 0
-	_, err := processSegment(cxn, seg)
+	_, err := processSegment(cxn, seg, nil)
 	assert.Nil(err)
 	assert.Equal(0, transcodeCalls, "Unexpectedly submitted segment")
 	assert.Len(bsm.sessMap, 2)
 
 	// One failed transcode attempt. Should leave another in the map
 	MaxAttempts = 1
-	_, err = processSegment(cxn, seg)
+	_, err = processSegment(cxn, seg, nil)
 	assert.NotNil(err)
 	assert.Equal("Hit max transcode attempts: UnknownResponse", err.Error())
 	assert.Equal(1, transcodeCalls, "Segment submission calls did not match")
 	assert.Len(bsm.sessMap, 1)
 
 	// Drain the swamp! Empty out the session list
-	_, err = processSegment(cxn, seg)
+	_, err = processSegment(cxn, seg, nil)
 	assert.NotNil(err)
 	assert.Equal("Hit max transcode attempts: UnknownResponse", err.Error())
 	assert.Equal(2, transcodeCalls, "Segment submission calls did not match")
@@ -1108,7 +1129,7 @@ func TestProcessSegment_MaxAttempts(t *testing.T) {
 
 	// The session list is empty. TODO Should return an error indicating such
 	// (This test should fail and be corrected once this is actually implemented)
-	_, err = processSegment(cxn, seg)
+	_, err = processSegment(cxn, seg, nil)
 	assert.Nil(err)
 	assert.Equal(2, transcodeCalls, "Segment submission calls did not match")
 	assert.Len(bsm.sessMap, 0)
@@ -1554,6 +1575,54 @@ func TestDownloadSegError_SuspendAndRemove(t *testing.T) {
 	assert.Greater(cxn.sessManager.sus.Suspended(sess.OrchestratorInfo.GetTranscoder()), 0)
 }
 
+func TestTranscodeSegment_StreamsToMemoryOS(t *testing.T) {
+	assert := assert.New(t)
+	mid := core.ManifestID("foo")
+	mem := drivers.NewMemoryDriver(nil).NewSession(string(mid))
+
+	url := "https://orch.example.com/resp1"
+	sess := genBcastSess(t, url, mem, mid)
+	bsm := bsmWithSessList([]*BroadcastSession{sess})
+	cxn := &rtmpConnection{
+		mid:         mid,
+		pl:          &stubPlaylistManager{manifestID: mid, os: mem},
+		profile:     &ffmpeg.P240p30fps16x9,
+		sessManager: bsm,
+	}
+	seg := &stream.HLSSegment{}
+
+	oldDownloadSeg, oldDownloadSegStream := downloadSeg, downloadSegStream
+	defer func() { downloadSeg, downloadSegStream = oldDownloadSeg, oldDownloadSegStream }()
+	downloadSeg = func(url string) ([]byte, error) {
+		t.Error("segment should be streamed")
+		return nil, errors.New("unexpected download")
+	}
+	var streamed []string
+	downloadSegStream = func(url string) (io.ReadCloser, int64, error) {
+		streamed = append(streamed, url)
+		return ioutil.NopCloser(strings.NewReader("transcoded")), 10, nil
+	}
+
+	urls, err := transcodeSegment(cxn, seg, "dummy", nil)
+	assert.Nil(err)
+	assert.Equal([]string{url}, streamed)
+	assert.Len(urls, 1)
+	assert.Equal("transcoded", string(mem.(*drivers.MemorySession).GetData(urls[0])))
+	assert.Equal(uint64(10), cxn.transcodedBytes)
+
+	// Errors reading the rendition are download errors
+	sess = genBcastSess(t, url, mem, mid)
+	cxn.sessManager = bsmWithSessList([]*BroadcastSession{sess})
+	downloadSegStream = func(url string) (io.ReadCloser, int64, error) {
+		return ioutil.NopCloser(iotest.TimeoutReader(strings.NewReader("transcoded"))), 10, nil
+	}
+	_, err = transcodeSegment(cxn, seg, "dummy", nil)
+	assert.Equal(iotest.ErrTimeout, err)
+	_, ok := cxn.sessManager.sessMap[sess.OrchestratorInfo.GetTranscoder()]
+	assert.False(ok)
+	assert.Greater(cxn.sessManager.sus.Suspended(sess.OrchestratorInfo.GetTranscoder()), 0)
+}
+
 func TestRefreshSession(t *testing.T) {
 	assert := assert.New(t)
 	successOrchInfoUpdate := &net.OrchestratorInfo{
@@ -1585,12 +1654,16 @@ func TestRefreshSession(t *testing.T) {
 	assert.EqualError(err, "some error")
 
 	// trigger update
+	var token *net.AuthToken
 	getOrchestratorInfoRPC = func(ctx context.Context, bcast common.Broadcaster, orchestratorServer *url.URL) (*net.OrchestratorInfo, error) {
+		token = sessionToken(ctx)
 		return successOrchInfoUpdate, nil
 	}
 	newSess, err = refreshSession(sess)
 	assert.Equal(newSess.OrchestratorInfo, successOrchInfoUpdate)
 	assert.Nil(err)
+	// the session is asked to be continued
+	assert.Equal(sess.OrchestratorInfo.AuthToken, token)
 
 	// trigger timeout
 	oldRefreshTimeout := refreshTimeout
@@ -1729,7 +1802,7 @@ func TestProcessSegment_VideoFormat(t *testing.T) {
 	downloadSeg = func(url string) ([]byte, error) { return []byte(url), nil }
 
 	// processSegment will also call transcodeSegment; also check that behavior
-	_, err := processSegment(cxn, seg)
+	_, err := processSegment(cxn, seg, nil)
 
 	assert.Nil(err)
 	assert.Equal(ffmpeg.FormatNone, cxn.profile.Format)
@@ -1752,7 +1825,7 @@ func TestProcessSegment_VideoFormat(t *testing.T) {
 	}
 	cxn.sessManager = bsmWithSessList([]*BroadcastSession{sess})
 
-	_, err = processSegment(cxn, seg)
+	_, err = processSegment(cxn, seg, nil)
 
 	assert.Nil(err)
 	for _, p := range sess.Params.Profiles {
@@ -1775,7 +1848,7 @@ func TestProcessSegment_VideoFormat(t *testing.T) {
 	}
 	cxn.sessManager = bsmWithSessList([]*BroadcastSession{sess})
 
-	_, err = processSegment(cxn, seg)
+	_, err = processSegment(cxn, seg, nil)
 
 	assert.Nil(err)
 	for _, p := range sess.Params.Profiles {
@@ -1787,18 +1860,89 @@ func TestProcessSegment_VideoFormat(t *testing.T) {
 	assert.Equal("saved_P240p30fps16x9/0.ts", seg.Name)
 }
 
+func TestDegradeCapabilities(t *testing.T) {
+	assert := assert.New(t)
+
+	gop := ffmpeg.P144p30fps16x9
+	gop.Name = "gop"
+	gop.GOP = time.Second
+	params := &core.StreamParameters{ManifestID: "mid", Profiles: []ffmpeg.VideoProfile{ffmpeg.P240p30fps16x9, gop}}
+	caps, err := core.JobCapabilities(params)
+	require.Nil(t, err)
+	params.Capabilities = caps
+	orchCaps := core.NewCapabilities([]core.Capability{core.Capability_H264, core.Capability_MPEGTS, core.Capability_AuthToken}, nil).ToNetCapabilities()
+	assert.False(params.Capabilities.CompatibleWith(orchCaps))
+
+	job := &SegmentJob{ManifestID: "mid", Segment: &stream.HLSSegment{}, cxn: &rtmpConnection{params: params}}
+	mismatch := &CapabilityMismatchError{Missing: []core.Capability{core.Capability_GOP}}
+
+	// streams keep their renditions unless degrading is enabled
+	job.degrade(mismatch)
+	assert.Len(params.Profiles, 2)
+
+	defer func() { DegradeCapabilities = false }()
+	DegradeCapabilities = true
+	job.degrade(mismatch)
+	assert.Equal([]ffmpeg.VideoProfile{ffmpeg.P240p30fps16x9}, params.Profiles)
+	assert.True(params.Capabilities.CompatibleWith(orchCaps))
+
+	// capabilities of the stream as a whole cannot be done without
+	job.degrade(&CapabilityMismatchError{Missing: []core.Capability{core.Capability_AuthToken}})
+	assert.Equal([]ffmpeg.VideoProfile{ffmpeg.P240p30fps16x9}, params.Profiles)
+}
+
+func TestFormatFallback(t *testing.T) {
+	assert := assert.New(t)
+
+	mp4 := ffmpeg.P144p30fps16x9
+	mp4.Format = ffmpeg.FormatMP4
+	mp4b := ffmpeg.P240p30fps16x9
+	mp4b.Format = ffmpeg.FormatMP4
+	params := &core.StreamParameters{ManifestID: "mid", Profiles: []ffmpeg.VideoProfile{ffmpeg.P360p30fps16x9, mp4, mp4b}}
+	job := &SegmentJob{ManifestID: "mid", Segment: &stream.HLSSegment{}, cxn: &rtmpConnection{params: params}}
+	muxErr := errors.New("Error muxing output")
+
+	// other errors do not count
+	job.fallBack(errors.New("unavailable"))
+	job.fallBack(errors.New("unavailable"))
+	assert.Equal(ffmpeg.FormatMP4, params.Profiles[1].Format)
+
+	job.fallBack(muxErr)
+	assert.Equal(ffmpeg.FormatMP4, params.Profiles[1].Format)
+	job.fallBack(muxErr)
+	assert.Equal(ffmpeg.FormatMPEGTS, params.Profiles[1].Format)
+	assert.Equal(ffmpeg.FormatMP4, params.Profiles[2].Format)
+	assert.NotNil(params.Capabilities)
+
+	// renditions fall back one at a time
+	job.fallBack(muxErr)
+	job.fallBack(muxErr)
+	assert.Equal(ffmpeg.FormatMPEGTS, params.Profiles[2].Format)
+	job.fallBack(muxErr)
+	job.fallBack(muxErr)
+	// without anything left to fall back
+	assert.Equal(ffmpeg.P360p30fps16x9, params.Profiles[0])
+
+	defer func(n int) { FormatFallbackAttempts = n }(FormatFallbackAttempts)
+	FormatFallbackAttempts = 0
+	params.Profiles[1].Format = ffmpeg.FormatMP4
+	job.fallBack(muxErr)
+	job.fallBack(muxErr)
+	assert.Equal(ffmpeg.FormatMP4, params.Profiles[1].Format)
+}
+
 func TestProcessSegment_CheckDuration(t *testing.T) {
 	assert := assert.New(t)
 	seg := &stream.HLSSegment{Duration: -1.0}
 	cxn := &rtmpConnection{}
 
 	// Check less-than-zero
-	_, err := processSegment(cxn, seg)
+	_, err := processSegment(cxn, seg, nil)
 	assert.Equal("Invalid duration -1", err.Error())
 
 	// CHeck greater than max duration
 	seg.Duration = maxDurationSec + 0.01
-	_, err = processSegment(cxn, seg)
+	_, err = processSegment(cxn, seg, nil)
 	assert.Equal("Invalid duration 300.01", err.Error())
 }
 
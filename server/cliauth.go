@@ -0,0 +1,200 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/golang/glog"
+)
+
+// CLIRole is what a user of the CLI webserver is allowed to do. Each role can
+// do everything the roles below it can.
+type CLIRole int
+
+const (
+	// CLIRoleReadOnly can query the node
+	CLIRoleReadOnly CLIRole = iota + 1
+	// CLIRoleOperator can also change the operational settings of the node
+	CLIRoleOperator
+	// CLIRoleAdmin can also move funds, stake, sign and reach pprof
+	CLIRoleAdmin
+)
+
+var cliRoles = map[string]CLIRole{
+	"read-only": CLIRoleReadOnly,
+	"operator":  CLIRoleOperator,
+	"admin":     CLIRoleAdmin,
+}
+
+func (r CLIRole) String() string {
+	for name, role := range cliRoles {
+		if role == r {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// cliEndpointRoles is the role needed by each CLI endpoint. Endpoints not
+// listed here, including funding, staking, unlocking, signing and pprof,
+// need CLIRoleAdmin.
+var cliEndpointRoles = map[string]CLIRole{
+	"/getBroadcastConfig":               CLIRoleReadOnly,
+	"/getAvailableTranscodingOptions":   CLIRoleReadOnly,
+	"/currentRound":                     CLIRoleReadOnly,
+	"/roundInitialized":                 CLIRoleReadOnly,
+	"/unbondingLocks":                   CLIRoleReadOnly,
+	"/delegatorInfo":                    CLIRoleReadOnly,
+	"/orchestratorEarningPoolsForRound": CLIRoleReadOnly,
+	"/streamID":                         CLIRoleReadOnly,
+	"/manifestID":                       CLIRoleReadOnly,
+	"/localStreams":                     CLIRoleReadOnly,
+	"/debug":                            CLIRoleReadOnly,
+	"/getLogLevel":                      CLIRoleReadOnly,
+	"/status":                           CLIRoleReadOnly,
+	"/status/streams":                   CLIRoleReadOnly,
+	"/contractAddresses":                CLIRoleReadOnly,
+	"/protocolParameters":               CLIRoleReadOnly,
+	"/ethAddr":                          CLIRoleReadOnly,
+	"/tokenBalance":                     CLIRoleReadOnly,
+	"/ethBalance":                       CLIRoleReadOnly,
+	"/registeredOrchestrators":          CLIRoleReadOnly,
+	"/orchestratorInfo":                 CLIRoleReadOnly,
+	"/IsOrchestrator":                   CLIRoleReadOnly,
+	"/EthChainID":                       CLIRoleReadOnly,
+	"/maxGasPrice":                      CLIRoleReadOnly,
+	"/currentBlock":                     CLIRoleReadOnly,
+	"/senderInfo":                       CLIRoleReadOnly,
+	"/ticketBrokerParams":               CLIRoleReadOnly,
+	"/metrics":                          CLIRoleReadOnly,
+	"/restreams":                        CLIRoleReadOnly,
+	"/clusterStreams":                   CLIRoleReadOnly,
+	"/streamLabels":                     CLIRoleReadOnly,
+	"/streamAliases":                    CLIRoleReadOnly,
+	"/serviceURIHealth":                 CLIRoleReadOnly,
+
+	"/setBroadcastConfig":    CLIRoleOperator,
+	"/setOrchestratorDrain":  CLIRoleOperator,
+	"/setBroadcasterDrain":   CLIRoleOperator,
+	"/setMaintenanceWindows": CLIRoleOperator,
+	"/setLogLevel":           CLIRoleOperator,
+	"/setMaxGasPrice":        CLIRoleOperator,
+	"/initializeRound":       CLIRoleOperator,
+	"/reward":                CLIRoleOperator,
+	"/loadTest":              CLIRoleOperator,
+	"/setRestreams":          CLIRoleOperator,
+	"/setStreamMetadata":     CLIRoleOperator,
+	"/setStreamLabels":       CLIRoleOperator,
+}
+
+// CLIAuth, if set, requires requests to the CLI webserver to carry the token
+// of a user whose role allows the endpoint
+var CLIAuth *CLIAuthorizer
+
+// CLIAuthorizer authenticates users of the CLI webserver by the bearer token
+// in the Authorization header. A nil *CLIAuthorizer allows every request.
+type CLIAuthorizer struct {
+	// users by the hex encoded SHA-256 of their token, so that tokens are
+	// not compared byte by byte
+	users map[string]cliUser
+}
+
+type cliUser struct {
+	Name  string `json:"name"`
+	Role  string `json:"role"`
+	Token string `json:"token"`
+
+	role CLIRole
+}
+
+// NewCLIAuthorizer reads the users of the CLI webserver from the JSON file
+// `usersFile`, a list of objects with a name, a role (read-only, operator or
+// admin) and a token
+func NewCLIAuthorizer(usersFile string) (*CLIAuthorizer, error) {
+	data, err := ioutil.ReadFile(usersFile)
+	if err != nil {
+		return nil, err
+	}
+	return newCLIAuthorizer(data)
+}
+
+func newCLIAuthorizer(data []byte) (*CLIAuthorizer, error) {
+	var users []cliUser
+	if err := json.Unmarshal(data, &users); err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, fmt.Errorf("no CLI users defined")
+	}
+	a := &CLIAuthorizer{users: make(map[string]cliUser)}
+	names := make(map[string]bool)
+	for _, u := range users {
+		if u.Name == "" || u.Token == "" {
+			return nil, fmt.Errorf("CLI users need a name and a token")
+		}
+		role, ok := cliRoles[u.Role]
+		if !ok {
+			return nil, fmt.Errorf("unknown role %q for CLI user %s", u.Role, u.Name)
+		}
+		u.role = role
+		h := hashCLIToken(u.Token)
+		if _, ok := a.users[h]; ok || names[u.Name] {
+			return nil, fmt.Errorf("duplicate name or token for CLI user %s", u.Name)
+		}
+		names[u.Name] = true
+		u.Token = ""
+		a.users[h] = u
+	}
+	return a, nil
+}
+
+func hashCLIToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// Handler wraps `h` so that it only serves requests of users allowed to use
+// the requested endpoint
+func (a *CLIAuthorizer) Handler(h http.Handler) http.Handler {
+	if a == nil {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		need, ok := cliEndpointRoles[r.URL.Path]
+		if !ok {
+			need = CLIRoleAdmin
+		}
+		if a.authorize(w, r, need) {
+			h.ServeHTTP(w, r)
+		}
+	})
+}
+
+// authorize returns whether the user of `r` has at least the role `need`,
+// answering the request otherwise
+func (a *CLIAuthorizer) authorize(w http.ResponseWriter, r *http.Request, need CLIRole) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	u, ok := a.users[hashCLIToken(strings.TrimPrefix(auth, "Bearer "))]
+	if !ok {
+		glog.Errorf("Rejected CLI request with unknown token path=%s addr=%s", r.URL.Path, r.RemoteAddr)
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	if u.role < need {
+		glog.Errorf("Rejected CLI request user=%s role=%v path=%s needs=%v", u.Name, u.role, r.URL.Path, need)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return false
+	}
+	return true
+}
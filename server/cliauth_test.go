@@ -0,0 +1,70 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCLIAuthorizer(t *testing.T) {
+	assert := assert.New(t)
+
+	a, err := newCLIAuthorizer([]byte(`[
+		{"name": "viewer", "role": "read-only", "token": "t-viewer"},
+		{"name": "ops", "role": "operator", "token": "t-ops"},
+		{"name": "root", "role": "admin", "token": "t-root"}
+	]`))
+	require.Nil(t, err)
+
+	h := a.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	status := func(path, token string) int {
+		req := httptest.NewRequest("POST", path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(http.StatusUnauthorized, status("/status", ""))
+	assert.Equal(http.StatusUnauthorized, status("/status", "nope"))
+
+	assert.Equal(http.StatusOK, status("/status", "t-viewer"))
+	assert.Equal(http.StatusForbidden, status("/setLogLevel", "t-viewer"))
+	assert.Equal(http.StatusForbidden, status("/unlock", "t-viewer"))
+
+	assert.Equal(http.StatusOK, status("/status", "t-ops"))
+	assert.Equal(http.StatusOK, status("/setLogLevel", "t-ops"))
+	assert.Equal(http.StatusForbidden, status("/fundDeposit", "t-ops"))
+	// unlisted endpoints need admin
+	assert.Equal(http.StatusForbidden, status("/debug/pprof/", "t-ops"))
+
+	assert.Equal(http.StatusOK, status("/setLogLevel", "t-root"))
+	assert.Equal(http.StatusOK, status("/fundDeposit", "t-root"))
+	assert.Equal(http.StatusOK, status("/debug/pprof/", "t-root"))
+
+	// no authorizer allows everything
+	var none *CLIAuthorizer
+	rec := httptest.NewRecorder()
+	none.Handler(http.NotFoundHandler()).ServeHTTP(rec, httptest.NewRequest("GET", "/unlock", nil))
+	assert.Equal(http.StatusNotFound, rec.Code)
+}
+
+func TestNewCLIAuthorizer_Errors(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := newCLIAuthorizer([]byte(`[]`))
+	assert.EqualError(err, "no CLI users defined")
+	_, err = newCLIAuthorizer([]byte(`[{"name": "a", "role": "root", "token": "t"}]`))
+	assert.EqualError(err, `unknown role "root" for CLI user a`)
+	_, err = newCLIAuthorizer([]byte(`[{"name": "a", "role": "admin"}]`))
+	assert.EqualError(err, "CLI users need a name and a token")
+	_, err = newCLIAuthorizer([]byte(`[{"name": "a", "role": "admin", "token": "t"}, {"name": "b", "role": "operator", "token": "t"}]`))
+	assert.EqualError(err, "duplicate name or token for CLI user b")
+	_, err = newCLIAuthorizer([]byte(`{}`))
+	assert.NotNil(err)
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+var errClientCertRequired = newError(ErrorCategoryAuth, false, "ErrClientCertRequired")
+var errClientCertNotAllowed = newError(ErrorCategoryAuth, false, "ErrClientCertNotAllowed")
+
+// SegmentClientAuth, if set, requires broadcasters to present a client
+// certificate when submitting segments and requesting ticket parameters from
+// this orchestrator
+var SegmentClientAuth *ClientAuth
+
+// ClientAuth authenticates broadcasters by their TLS client certificate.
+// Certificates must be issued by one of its CAs and, if an allowlist is
+// given, have an allowed subject common name or SHA-256 fingerprint.
+//
+// Other clients of the orchestrator, such as remote transcoders, share the
+// same listener, so a certificate is only asked for and never required
+// during the handshake; the segment endpoints check for it instead.
+//
+// A nil *ClientAuth accepts every client.
+type ClientAuth struct {
+	cas   *x509.CertPool
+	allow map[string]bool
+}
+
+// NewClientAuth reads the PEM encoded CA certificates in `caFile`. Entries of
+// `allowlist` are either a subject common name or the hex encoded SHA-256
+// fingerprint of a certificate.
+func NewClientAuth(caFile string, allowlist []string) (*ClientAuth, error) {
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	cas := x509.NewCertPool()
+	if !cas.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no CA certificates found in %s", caFile)
+	}
+	a := &ClientAuth{cas: cas}
+	for _, entry := range allowlist {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if a.allow == nil {
+			a.allow = make(map[string]bool)
+		}
+		// fingerprints may be written with colons and in either case
+		if fp, err := hex.DecodeString(strings.Replace(entry, ":", "", -1)); err == nil && len(fp) == sha256.Size {
+			entry = hex.EncodeToString(fp)
+		}
+		a.allow[entry] = true
+	}
+	return a, nil
+}
+
+// configureTLS makes the server ask clients for a certificate
+func (a *ClientAuth) configureTLS(cfg *tls.Config) {
+	if a == nil {
+		return
+	}
+	cfg.ClientCAs = a.cas
+	cfg.ClientAuth = tls.VerifyClientCertIfGiven
+}
+
+// verify checks the client certificate of a connection. The chain has
+// already been verified against the CAs during the handshake.
+func (a *ClientAuth) verify(state *tls.ConnectionState) error {
+	if a == nil {
+		return nil
+	}
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return errClientCertRequired
+	}
+	if a.allow == nil {
+		return nil
+	}
+	cert := state.VerifiedChains[0][0]
+	fp := sha256.Sum256(cert.Raw)
+	if a.allow[hex.EncodeToString(fp[:])] || (cert.Subject.CommonName != "" && a.allow[cert.Subject.CommonName]) {
+		return nil
+	}
+	return errClientCertNotAllowed
+}
+
+// verifyPeer checks the client certificate of a gRPC request
+func (a *ClientAuth) verifyPeer(ctx context.Context) error {
+	if a == nil {
+		return nil
+	}
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return errClientCertRequired
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return errClientCertRequired
+	}
+	return a.verify(&info.State)
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+)
+
+// issueCert returns a certificate for `cn` signed by `parent`, or self signed
+// as a CA if parent is nil
+func issueCert(t *testing.T, cn string, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, tls.Certificate) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.Nil(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	require.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.Nil(t, err)
+	return cert, key, tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestClientAuth(t *testing.T) {
+	assert := assert.New(t)
+
+	ca, caKey, _ := issueCert(t, "ca", nil, nil)
+	otherCA, otherKey, _ := issueCert(t, "other ca", nil, nil)
+	b1, _, b1TLS := issueCert(t, "broadcaster1", ca, caKey)
+	_, _, b2TLS := issueCert(t, "broadcaster2", ca, caKey)
+	_, _, rogueTLS := issueCert(t, "broadcaster1", otherCA, otherKey)
+
+	dir, err := ioutil.TempDir("", "clientauth")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	require.Nil(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0600))
+
+	_, err = NewClientAuth(filepath.Join(dir, "missing.pem"), nil)
+	assert.NotNil(err)
+	require.Nil(t, ioutil.WriteFile(filepath.Join(dir, "empty.pem"), nil, 0600))
+	_, err = NewClientAuth(filepath.Join(dir, "empty.pem"), nil)
+	assert.NotNil(err)
+
+	serve := func(auth *ClientAuth, cert *tls.Certificate) int {
+		ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := auth.verify(r.TLS); err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+			}
+		}))
+		ts.TLS = &tls.Config{}
+		auth.configureTLS(ts.TLS)
+		ts.StartTLS()
+		defer ts.Close()
+		cfg := &tls.Config{InsecureSkipVerify: true}
+		if cert != nil {
+			cfg.Certificates = []tls.Certificate{*cert}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+		resp, err := client.Get(ts.URL)
+		require.Nil(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// any certificate from the CA
+	auth, err := NewClientAuth(caFile, nil)
+	require.Nil(t, err)
+	assert.Equal(http.StatusOK, serve(auth, &b1TLS))
+	assert.Equal(http.StatusOK, serve(auth, &b2TLS))
+	assert.Equal(http.StatusForbidden, serve(auth, nil))
+	// clients only offer certificates from the CAs the server asks for
+	assert.Equal(http.StatusForbidden, serve(auth, &rogueTLS))
+
+	// allowlisted by common name
+	auth, err = NewClientAuth(caFile, []string{"broadcaster1"})
+	require.Nil(t, err)
+	assert.Equal(http.StatusOK, serve(auth, &b1TLS))
+	assert.Equal(http.StatusForbidden, serve(auth, &b2TLS))
+
+	// allowlisted by fingerprint
+	fp := sha256.Sum256(b1.Raw)
+	auth, err = NewClientAuth(caFile, []string{hex.EncodeToString(fp[:])})
+	require.Nil(t, err)
+	assert.Equal(http.StatusOK, serve(auth, &b1TLS))
+	assert.Equal(http.StatusForbidden, serve(auth, &b2TLS))
+
+	// disabled
+	assert.Equal(http.StatusOK, serve(nil, nil))
+}
+
+func TestClientAuth_VerifyPeer(t *testing.T) {
+	assert := assert.New(t)
+
+	ca, caKey, _ := issueCert(t, "ca", nil, nil)
+	b1, _, _ := issueCert(t, "broadcaster1", ca, caKey)
+	auth := &ClientAuth{cas: x509.NewCertPool()}
+
+	var nilAuth *ClientAuth
+	assert.Nil(nilAuth.verifyPeer(context.Background()))
+	assert.Equal(errClientCertRequired, auth.verifyPeer(context.Background()))
+
+	ctx := peer.NewContext(context.Background(), &peer.Peer{AuthInfo: credentials.TLSInfo{}})
+	assert.Equal(errClientCertRequired, auth.verifyPeer(ctx))
+
+	state := tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{b1, ca}}}
+	ctx = peer.NewContext(context.Background(), &peer.Peer{AuthInfo: credentials.TLSInfo{State: state}})
+	assert.Nil(auth.verifyPeer(ctx))
+
+	auth.allow = map[string]bool{"broadcaster2": true}
+	assert.Equal(errClientCertNotAllowed, auth.verifyPeer(ctx))
+}
@@ -0,0 +1,45 @@
+package server
+
+import "time"
+
+// Clock tells the time and schedules the timers of the media server, the
+// session manager and the caches, so that tests can control time rather than
+// sleep through timeouts
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	// NewTimer returns a timer sending the time on its channel after `d`
+	NewTimer(d time.Duration) Timer
+	// AfterFunc returns a timer calling `f` after `d`
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a timer scheduled by a Clock, used as a time.Timer
+type Timer interface {
+	// C returns the channel of the timer, which is nil for AfterFunc timers
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// clock is the Clock of the server, the system clock outside of tests
+var clock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                  { return time.Now() }
+func (systemClock) Since(t time.Time) time.Duration { return time.Since(t) }
+
+func (systemClock) NewTimer(d time.Duration) Timer {
+	return systemTimer{time.NewTimer(d)}
+}
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return systemTimer{time.AfterFunc(d, f)}
+}
+
+type systemTimer struct {
+	*time.Timer
+}
+
+func (t systemTimer) C() <-chan time.Time { return t.Timer.C }
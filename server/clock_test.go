@@ -0,0 +1,241 @@
+package server
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is a Clock whose time only moves when advanced, firing the timers
+// that are due
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers map[*fakeTimer]bool
+	// signals that a timer was scheduled
+	scheduled chan struct{}
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{
+		now:       time.Unix(1600000000, 0),
+		timers:    make(map[*fakeTimer]bool),
+		scheduled: make(chan struct{}, 1),
+	}
+}
+
+// useFakeClock makes the server run on a fake clock until the test ends
+func useFakeClock(t *testing.T) *fakeClock {
+	c := newFakeClock()
+	oldClock, oldWatchdog := clock, pushWatchdog
+	clock = c
+	pushWatchdog = newExpiryQueue()
+	t.Cleanup(func() { clock, pushWatchdog = oldClock, oldWatchdog })
+	return c
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Since(t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+func (c *fakeClock) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{c: c, ch: make(chan time.Time, 1)}
+	t.Reset(d)
+	return t
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	t := &fakeTimer{c: c, fn: f}
+	t.Reset(d)
+	return t
+}
+
+// Advance moves the time forward by `d`, firing the timers due by then in
+// the order of their deadlines
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	now := c.now
+	var due []*fakeTimer
+	for t := range c.timers {
+		if !t.when.After(now) {
+			due = append(due, t)
+			delete(c.timers, t)
+		}
+	}
+	c.mu.Unlock()
+	sort.Slice(due, func(i, j int) bool { return due[i].when.Before(due[j].when) })
+	for _, t := range due {
+		t.fire(now)
+	}
+}
+
+// waitTimers waits until `n` timers are scheduled, returning false if they
+// are not within a second of real time
+func (c *fakeClock) waitTimers(n int) bool {
+	deadline := time.After(time.Second)
+	for {
+		c.mu.Lock()
+		scheduled := len(c.timers)
+		c.mu.Unlock()
+		if scheduled >= n {
+			return true
+		}
+		select {
+		case <-c.scheduled:
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+// waitTimerBy waits until a timer is scheduled to fire by `when`, returning
+// false if none is within a second of real time
+func (c *fakeClock) waitTimerBy(when time.Time) bool {
+	deadline := time.After(time.Second)
+	for {
+		c.mu.Lock()
+		var due bool
+		for t := range c.timers {
+			if !t.when.After(when) {
+				due = true
+				break
+			}
+		}
+		c.mu.Unlock()
+		if due {
+			return true
+		}
+		select {
+		case <-c.scheduled:
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+type fakeTimer struct {
+	c    *fakeClock
+	when time.Time
+	ch   chan time.Time
+	fn   func()
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.ch }
+
+func (t *fakeTimer) Stop() bool {
+	t.c.mu.Lock()
+	defer t.c.mu.Unlock()
+	active := t.c.timers[t]
+	delete(t.c.timers, t)
+	return active
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	t.c.mu.Lock()
+	active := t.c.timers[t]
+	t.when = t.c.now.Add(d)
+	due := d <= 0
+	if !due {
+		t.c.timers[t] = true
+	}
+	now := t.c.now
+	t.c.mu.Unlock()
+	if due {
+		t.fire(now)
+		return active
+	}
+	select {
+	case t.c.scheduled <- struct{}{}:
+	default:
+	}
+	return active
+}
+
+func (t *fakeTimer) fire(now time.Time) {
+	if t.fn != nil {
+		go t.fn()
+		return
+	}
+	select {
+	case t.ch <- now:
+	default:
+	}
+}
+
+func TestFakeClock_Timers(t *testing.T) {
+	assert := assert.New(t)
+	c := newFakeClock()
+	start := c.Now()
+
+	fired := make(chan int, 3)
+	c.AfterFunc(2*time.Second, func() { fired <- 2 })
+	stopped := c.AfterFunc(time.Second, func() { fired <- 1 })
+	tm := c.NewTimer(3 * time.Second)
+	assert.True(c.waitTimers(3))
+	assert.True(stopped.Stop())
+	assert.False(stopped.Stop())
+
+	c.Advance(time.Second)
+	assert.Equal(time.Second, c.Since(start))
+	select {
+	case <-fired:
+		t.Error("stopped timer fired")
+	case <-tm.C():
+		t.Error("timer fired early")
+	default:
+	}
+
+	c.Advance(2 * time.Second)
+	assert.Equal(2, <-fired)
+	assert.Equal(start.Add(3*time.Second), <-tm.C())
+
+	// rescheduling a fired timer
+	assert.False(tm.Reset(time.Second))
+	c.Advance(time.Second)
+	assert.Equal(start.Add(4*time.Second), <-tm.C())
+}
+
+func TestExpiryQueue_FakeClock(t *testing.T) {
+	assert := assert.New(t)
+	c := useFakeClock(t)
+
+	q := newExpiryQueue()
+	fired := make(chan int, 2)
+	q.newTimer(func() { fired <- 1 }).reset(time.Minute)
+	tm := q.newTimer(func() { fired <- 2 })
+	tm.reset(time.Hour)
+
+	// nothing fires without the time moving
+	assert.True(c.waitTimers(1))
+	assert.Equal(2, q.len())
+
+	c.Advance(time.Minute)
+	assert.Equal(1, <-fired)
+	assert.True(c.waitTimers(1))
+	assert.Equal(1, q.len())
+	assert.True(tm.stop())
+
+	// the queue goroutine is done once its last wait is over
+	c.Advance(time.Hour)
+	common.WaitAssert(t, time.Second, func() bool {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return !q.running
+	}, "queue still running")
+	select {
+	case <-fired:
+		t.Error("stopped timer fired")
+	default:
+	}
+}
@@ -0,0 +1,357 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/lpms/ffmpeg"
+)
+
+var errStreamElsewhere = newError(ErrorCategoryIngest, false, "ErrStreamElsewhere")
+
+// Cluster, if set, shares the streams of this broadcaster with the others
+// of its cluster, so that any of them can redirect requests for a stream to
+// the node ingesting it, and take a stream over once that node is gone
+var Cluster *StreamCluster
+
+const (
+	clusterStreamPrefix = "streams/"
+	clusterLivePrefix   = "live/"
+	clusterAliasPrefix  = "manifests/"
+	// how long the record of a stream outlives the node ingesting it, for
+	// another node to continue the stream
+	clusterStreamExpiry = time.Hour
+)
+
+// ClusterStream is the record of a stream shared with the cluster
+type ClusterStream struct {
+	ManifestID string    `json:"manifestID"`
+	Node       string    `json:"node"`
+	Profiles   []string  `json:"profiles"`
+	Started    time.Time `json:"started"`
+	// Sequence number following that of the last segment the node shared
+	NextSeqNo uint64 `json:"nextSeqNo"`
+	// Whether the node is still ingesting the stream
+	Live bool `json:"live"`
+}
+
+// StreamCluster keeps the streams of this node in a ClusterStore. A stream
+// is live for as long as its node keeps refreshing it, at a third of `ttl`.
+// Its sequence number is shared as soon as it changes, so that a node taking
+// the stream over continues right after the last segment shared.
+type StreamCluster struct {
+	store ClusterStore
+	node  string
+	ttl   time.Duration
+	// signals that a stream changed
+	changed chan struct{}
+
+	mu      sync.Mutex
+	streams map[core.ManifestID]*ClusterStream
+	dirty   map[core.ManifestID]bool
+	// external manifest IDs of the streams, by their internal ones
+	aliases map[core.ManifestID][]core.ManifestID
+	// called with streams of this node that another node took over
+	lost func(core.ManifestID)
+}
+
+// NewStreamCluster returns the cluster sharing `store`, where this node is
+// reachable at the base URL `node`
+func NewStreamCluster(store ClusterStore, node string, ttl time.Duration) (*StreamCluster, error) {
+	node, err := normalizeIngestNode(node)
+	if err != nil {
+		return nil, err
+	}
+	return &StreamCluster{
+		store:   store,
+		node:    node,
+		ttl:     ttl,
+		changed: make(chan struct{}, 1),
+		streams: make(map[core.ManifestID]*ClusterStream),
+		dirty:   make(map[core.ManifestID]bool),
+		aliases: make(map[core.ManifestID][]core.ManifestID),
+	}, nil
+}
+
+// SetLostHandler sets `lost` to be called with the streams of this node
+// that another node took over, for them to be ended here
+func (c *StreamCluster) SetLostHandler(lost func(core.ManifestID)) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lost = lost
+}
+
+// Run refreshes the streams of this node until `ctx` is done
+func (c *StreamCluster) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.refresh()
+		case <-c.changed:
+			c.flush()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// owns returns whether this node still ingests the stream `mid`
+func (c *StreamCluster) owns(mid core.ManifestID) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.streams[mid]
+	return ok
+}
+
+// flush shares the streams that changed since they were last shared
+func (c *StreamCluster) flush() {
+	c.mu.Lock()
+	var changed []ClusterStream
+	for mid := range c.dirty {
+		if rec, ok := c.streams[mid]; ok {
+			changed = append(changed, *rec)
+		}
+	}
+	c.dirty = make(map[core.ManifestID]bool)
+	c.mu.Unlock()
+	for i := range changed {
+		if c.owns(core.ManifestID(changed[i].ManifestID)) {
+			c.putStream(&changed[i])
+		}
+	}
+}
+
+// refresh keeps the streams of this node live, giving up those another node
+// took over in the meantime, such as while this node was cut off
+func (c *StreamCluster) refresh() {
+	c.flush()
+	c.mu.Lock()
+	var mids []core.ManifestID
+	for mid := range c.streams {
+		mids = append(mids, mid)
+	}
+	lost := c.lost
+	c.mu.Unlock()
+	for _, mid := range mids {
+		node, err := c.store.Get(clusterLivePrefix + string(mid))
+		if err == nil && node != nil && string(node) != c.node {
+			glog.Errorf("Cluster stream taken over manifestID=%s node=%s", mid, node)
+			c.mu.Lock()
+			delete(c.streams, mid)
+			delete(c.dirty, mid)
+			delete(c.aliases, mid)
+			c.mu.Unlock()
+			if lost != nil {
+				lost(mid)
+			}
+			continue
+		}
+		if !c.owns(mid) {
+			// released since
+			continue
+		}
+		if err := c.store.Put(clusterLivePrefix+string(mid), []byte(c.node), c.ttl); err != nil {
+			glog.Errorf("Error refreshing cluster stream manifestID=%s err=%v", mid, err)
+		}
+	}
+}
+
+func (c *StreamCluster) putStream(rec *ClusterStream) {
+	data, err := json.Marshal(rec)
+	if err == nil {
+		err = c.store.Put(clusterStreamPrefix+rec.ManifestID, data, clusterStreamExpiry)
+	}
+	if err != nil {
+		glog.Errorf("Error saving cluster stream manifestID=%s err=%v", rec.ManifestID, err)
+	}
+}
+
+// claim shares the stream `mid` as ingested by this node. If the stream
+// was interrupted on another node, it is taken over and continues at the
+// returned sequence number. Returns errStreamElsewhere if another node still
+// ingests the stream.
+func (c *StreamCluster) claim(mid core.ManifestID, profiles []ffmpeg.VideoProfile) (next uint64, takeover bool, err error) {
+	if c == nil {
+		return 0, false, nil
+	}
+	c.mu.Lock()
+	if rec, ok := c.streams[mid]; ok {
+		// already ingested here
+		c.mu.Unlock()
+		return rec.NextSeqNo, false, nil
+	}
+	c.mu.Unlock()
+	prev, err := c.get(mid)
+	if err != nil {
+		// the stream is still ingested here rather than not at all
+		glog.Errorf("Error reading cluster stream manifestID=%s err=%v", mid, err)
+	} else if prev != nil {
+		if prev.Live && prev.Node != c.node {
+			return 0, false, errStreamElsewhere
+		}
+		// at most the segment the node was cutting when it stopped is lost
+		next, takeover = prev.NextSeqNo, true
+		glog.Infof("Taking over cluster stream manifestID=%s node=%s nextSeqNo=%d", mid, prev.Node, next)
+	}
+	rec := &ClusterStream{
+		ManifestID: string(mid),
+		Node:       c.node,
+		Started:    clock.Now(),
+		NextSeqNo:  next,
+		Live:       true,
+	}
+	for _, p := range profiles {
+		rec.Profiles = append(rec.Profiles, p.Name)
+	}
+	c.mu.Lock()
+	c.streams[mid] = rec
+	r := *rec
+	c.mu.Unlock()
+	c.putStream(&r)
+	if err := c.store.Put(clusterLivePrefix+string(mid), []byte(c.node), c.ttl); err != nil {
+		glog.Errorf("Error saving cluster stream manifestID=%s err=%v", mid, err)
+	}
+	return next, takeover, nil
+}
+
+// alias shares that requests for the external manifest ID `extmid` are for
+// the stream `mid`
+func (c *StreamCluster) alias(extmid, mid core.ManifestID) {
+	if c == nil || extmid == mid {
+		return
+	}
+	c.mu.Lock()
+	c.aliases[mid] = append(c.aliases[mid], extmid)
+	c.mu.Unlock()
+	if err := c.store.Put(clusterAliasPrefix+string(extmid), []byte(mid), clusterStreamExpiry); err != nil {
+		glog.Errorf("Error saving cluster stream alias manifestID=%s external manifestID=%s err=%v", mid, extmid, err)
+	}
+}
+
+// sawSeqNo shares the sequence number of a segment of the stream `mid`
+func (c *StreamCluster) sawSeqNo(mid core.ManifestID, seqNo uint64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if rec, ok := c.streams[mid]; ok && seqNo >= rec.NextSeqNo {
+		rec.NextSeqNo = seqNo + 1
+		c.dirty[mid] = true
+		select {
+		case c.changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// release removes the stream `mid`, which this node no longer ingests, from
+// the cluster
+func (c *StreamCluster) release(mid core.ManifestID) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	if _, ok := c.streams[mid]; !ok {
+		c.mu.Unlock()
+		return
+	}
+	aliases := c.aliases[mid]
+	delete(c.streams, mid)
+	delete(c.dirty, mid)
+	delete(c.aliases, mid)
+	c.mu.Unlock()
+	keys := []string{clusterLivePrefix + string(mid), clusterStreamPrefix + string(mid)}
+	for _, extmid := range aliases {
+		keys = append(keys, clusterAliasPrefix+string(extmid))
+	}
+	for _, k := range keys {
+		if err := c.store.Delete(k); err != nil {
+			glog.Errorf("Error removing cluster stream manifestID=%s key=%s err=%v", mid, k, err)
+		}
+	}
+}
+
+// get returns the record of the stream `mid`, or nil if there is none
+func (c *StreamCluster) get(mid core.ManifestID) (*ClusterStream, error) {
+	data, err := c.store.Get(clusterStreamPrefix + string(mid))
+	if err != nil || data == nil {
+		return nil, err
+	}
+	var rec ClusterStream
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, err
+	}
+	live, err := c.store.Get(clusterLivePrefix + string(mid))
+	if err != nil {
+		return nil, err
+	}
+	rec.Live = live != nil
+	if rec.Live {
+		rec.Node = string(live)
+	}
+	return &rec, nil
+}
+
+// remoteNode returns the node other than this one ingesting the stream with
+// the internal or external manifest ID `mid`, or "" if there is none
+func (c *StreamCluster) remoteNode(mid core.ManifestID) string {
+	if c == nil || mid == "" {
+		return ""
+	}
+	if intmid, err := c.store.Get(clusterAliasPrefix + string(mid)); err == nil && intmid != nil {
+		mid = core.ManifestID(intmid)
+	}
+	node, err := c.store.Get(clusterLivePrefix + string(mid))
+	if err != nil {
+		glog.Errorf("Error reading cluster stream manifestID=%s err=%v", mid, err)
+		return ""
+	}
+	if node == nil || string(node) == c.node {
+		return ""
+	}
+	return string(node)
+}
+
+// list returns the records of all streams of the cluster, sorted by
+// manifest ID
+func (c *StreamCluster) list() ([]*ClusterStream, error) {
+	if c == nil {
+		return nil, nil
+	}
+	streams, err := c.store.List(clusterStreamPrefix)
+	if err != nil {
+		return nil, err
+	}
+	live, err := c.store.List(clusterLivePrefix)
+	if err != nil {
+		return nil, err
+	}
+	var res []*ClusterStream
+	for k, data := range streams {
+		var rec ClusterStream
+		if err := json.Unmarshal(data, &rec); err != nil {
+			glog.Errorf("Invalid cluster stream key=%s err=%v", k, err)
+			continue
+		}
+		node, ok := live[clusterLivePrefix+strings.TrimPrefix(k, clusterStreamPrefix)]
+		rec.Live = ok
+		if ok {
+			rec.Node = string(node)
+		}
+		res = append(res, &rec)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ManifestID < res[j].ManifestID })
+	return res, nil
+}
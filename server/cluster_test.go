@@ -0,0 +1,227 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/lpms/ffmpeg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestStreamCluster(t *testing.T, store ClusterStore, node string) *StreamCluster {
+	c, err := NewStreamCluster(store, node, 3*time.Second)
+	require.Nil(t, err)
+	return c
+}
+
+func TestStreamCluster_Claim(t *testing.T) {
+	assert := assert.New(t)
+	store := NewMemoryClusterStore()
+	b1 := newTestStreamCluster(t, store, "https://b1:8935")
+	b2 := newTestStreamCluster(t, store, "https://b2:8935")
+	profiles := []ffmpeg.VideoProfile{ffmpeg.P240p30fps16x9}
+
+	next, takeover, err := b1.claim("mid", profiles)
+	assert.Nil(err)
+	assert.Zero(next)
+	assert.False(takeover)
+	b1.alias("ext", "mid")
+	b1.sawSeqNo("mid", 4)
+	b1.sawSeqNo("other", 9)
+	b1.refresh()
+
+	// other nodes redirect to the node ingesting the stream
+	assert.Equal("https://b1:8935", b2.remoteNode("mid"))
+	assert.Equal("https://b1:8935", b2.remoteNode("ext"))
+	assert.Empty(b1.remoteNode("mid"))
+	assert.Empty(b2.remoteNode("unknown"))
+	_, _, err = b2.claim("mid", profiles)
+	assert.Equal(errStreamElsewhere, err)
+
+	streams, err := b2.list()
+	assert.Nil(err)
+	assert.Equal([]*ClusterStream{{
+		ManifestID: "mid",
+		Node:       "https://b1:8935",
+		Profiles:   []string{"P240p30fps16x9"},
+		Started:    streams[0].Started,
+		NextSeqNo:  5,
+		Live:       true,
+	}}, streams)
+
+	// claiming again on the same node keeps the stream as it is
+	next, takeover, err = b1.claim("mid", nil)
+	assert.Nil(err)
+	assert.Equal(uint64(5), next)
+	assert.False(takeover)
+
+	// the stream is taken over once its node stops refreshing it, right
+	// after the last segment it shared
+	store.Delete(clusterLivePrefix + "mid")
+	assert.Empty(b2.remoteNode("mid"))
+	next, takeover, err = b2.claim("mid", profiles)
+	assert.Nil(err)
+	assert.Equal(uint64(5), next)
+	assert.True(takeover)
+	assert.Equal("https://b2:8935", b1.remoteNode("mid"))
+
+	// and given up by its node if it comes back
+	var lost []core.ManifestID
+	b1.SetLostHandler(func(mid core.ManifestID) { lost = append(lost, mid) })
+	b1.refresh()
+	assert.Equal([]core.ManifestID{"mid"}, lost)
+	assert.False(b1.owns("mid"))
+	assert.Equal("https://b2:8935", b1.remoteNode("mid"), "the lost stream is not released")
+
+	// released streams are gone from the cluster
+	b2.alias("ext", "mid")
+	b2.release("mid")
+	assert.Empty(b1.remoteNode("mid"))
+	assert.Empty(b1.remoteNode("ext"))
+	streams, err = b1.list()
+	assert.Nil(err)
+	assert.Empty(streams)
+
+	// a nil cluster shares nothing
+	var none *StreamCluster
+	next, takeover, err = none.claim("mid", profiles)
+	assert.Nil(err)
+	assert.Zero(next)
+	assert.False(takeover)
+	none.SetLostHandler(nil)
+	none.alias("ext", "mid")
+	none.sawSeqNo("mid", 1)
+	none.release("mid")
+	assert.Empty(none.remoteNode("mid"))
+	streams, err = none.list()
+	assert.Nil(err)
+	assert.Nil(streams)
+}
+
+func TestStreamCluster_Run(t *testing.T) {
+	assert := assert.New(t)
+	store := NewMemoryClusterStore()
+	c, err := NewStreamCluster(store, "https://b1:8935", 300*time.Millisecond)
+	require.Nil(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	c.claim("mid", nil)
+	// sequence numbers are shared right away
+	c.sawSeqNo("mid", 7)
+	time.Sleep(50 * time.Millisecond)
+	rec, err := c.get("mid")
+	assert.Nil(err)
+	assert.Equal(uint64(8), rec.NextSeqNo)
+
+	time.Sleep(450 * time.Millisecond)
+	rec, err = c.get("mid")
+	assert.Nil(err)
+	assert.True(rec.Live, "refreshed stream stays live")
+
+	// released streams are not refreshed again
+	c.release("mid")
+	time.Sleep(200 * time.Millisecond)
+	rec, err = c.get("mid")
+	assert.Nil(err)
+	assert.Nil(rec)
+}
+
+// fakeEtcd implements the parts of the etcd v3 JSON gateway that
+// EtcdClusterStore uses, without expiring leases
+func fakeEtcd(t *testing.T) *httptest.Server {
+	var mu sync.Mutex
+	kvs := make(map[string]string)
+	decode := func(s string) string {
+		b, err := base64.StdEncoding.DecodeString(s)
+		require.Nil(t, err)
+		return string(b)
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		var req map[string]interface{}
+		require.Nil(t, json.NewDecoder(r.Body).Decode(&req))
+		str := func(k string) string {
+			s, _ := req[k].(string)
+			return decode(s)
+		}
+		switch r.URL.Path {
+		case "/v3/lease/grant":
+			assert.Equal(t, float64(3), req["TTL"])
+			w.Write([]byte(`{"ID":"7587852278411542028","TTL":"3"}`))
+		case "/v3/kv/put":
+			assert.Equal(t, "7587852278411542028", req["lease"])
+			kvs[str("key")] = str("value")
+			w.Write([]byte(`{}`))
+		case "/v3/kv/range":
+			key, end := str("key"), str("range_end")
+			var res []map[string]string
+			for k, v := range kvs {
+				if k == key || (end != "" && k >= key && (end == "\x00" || k < end)) {
+					res = append(res, map[string]string{
+						"key":   base64.StdEncoding.EncodeToString([]byte(k)),
+						"value": base64.StdEncoding.EncodeToString([]byte(v)),
+					})
+				}
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"kvs": res})
+		case "/v3/kv/deleterange":
+			delete(kvs, str("key"))
+			w.Write([]byte(`{}`))
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	}))
+}
+
+func TestEtcdClusterStore(t *testing.T) {
+	assert := assert.New(t)
+	ts := fakeEtcd(t)
+	defer ts.Close()
+
+	store, err := NewClusterStore(strings.Replace(ts.URL, "http://", "etcd://", 1))
+	require.Nil(t, err)
+	assert.Nil(store.Put("live/a", []byte("https://b1:8935"), 2500*time.Millisecond))
+	assert.Nil(store.Put("live/b", []byte("https://b2:8935"), 3*time.Second))
+	assert.Nil(store.Put("streams/a", []byte("{}"), 3*time.Second))
+
+	v, err := store.Get("live/a")
+	assert.Nil(err)
+	assert.Equal("https://b1:8935", string(v))
+	v, err = store.Get("live/c")
+	assert.Nil(err)
+	assert.Nil(v)
+
+	all, err := store.List("live/")
+	assert.Nil(err)
+	assert.Equal(map[string][]byte{"live/a": []byte("https://b1:8935"), "live/b": []byte("https://b2:8935")}, all)
+	all, err = store.List("")
+	assert.Nil(err)
+	assert.Len(all, 3)
+
+	assert.Nil(store.Delete("live/a"))
+	v, err = store.Get("live/a")
+	assert.Nil(err)
+	assert.Nil(v)
+
+	_, err = NewEtcdClusterStore(ts.URL).Get("")
+	assert.Nil(err)
+	_, err = NewEtcdClusterStore(ts.URL + "/missing").Get("live/a")
+	assert.Contains(err.Error(), "etcd kv/range status=404")
+
+	_, err = NewClusterStore("redis://127.0.0.1:6379")
+	assert.EqualError(err, "unsupported cluster store url=redis://127.0.0.1:6379")
+	_, err = NewClusterStore("etcd://")
+	assert.EqualError(err, "invalid cluster store url=etcd://")
+}
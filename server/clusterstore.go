@@ -0,0 +1,207 @@
+package server
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ClusterStore is a key-value store shared by the broadcasters of a cluster
+type ClusterStore interface {
+	// Put sets `key` to `value`, which expires after `ttl` unless it is put
+	// again
+	Put(key string, value []byte, ttl time.Duration) error
+	// Get returns the value of `key`, or nil if it is not set
+	Get(key string) ([]byte, error)
+	Delete(key string) error
+	// List returns the values of the keys starting with `prefix`
+	List(prefix string) (map[string][]byte, error)
+}
+
+// NewClusterStore returns the store at `storeURL`, an etcd v3 cluster at
+// etcd://host:port or etcd+https://host:port
+func NewClusterStore(storeURL string) (ClusterStore, error) {
+	u, err := url.Parse(storeURL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid cluster store url=%s", storeURL)
+	}
+	switch u.Scheme {
+	case "etcd":
+		return NewEtcdClusterStore("http://" + u.Host), nil
+	case "etcd+https":
+		return NewEtcdClusterStore("https://" + u.Host), nil
+	}
+	return nil, fmt.Errorf("unsupported cluster store url=%s", storeURL)
+}
+
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// MemoryClusterStore is a ClusterStore held in memory, shared by the nodes
+// of a single process
+type MemoryClusterStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+func NewMemoryClusterStore() *MemoryClusterStore {
+	return &MemoryClusterStore{entries: make(map[string]memoryEntry)}
+}
+
+func (m *MemoryClusterStore) Put(key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[key] = memoryEntry{value: append([]byte(nil), value...), expires: clock.Now().Add(ttl)}
+	return nil
+}
+
+func (m *MemoryClusterStore) Get(key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[key]
+	if !ok || clock.Now().After(e.expires) {
+		delete(m.entries, key)
+		return nil, nil
+	}
+	return e.value, nil
+}
+
+func (m *MemoryClusterStore) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+	return nil
+}
+
+func (m *MemoryClusterStore) List(prefix string) (map[string][]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res := make(map[string][]byte)
+	now := clock.Now()
+	for k, e := range m.entries {
+		if now.After(e.expires) {
+			delete(m.entries, k)
+		} else if strings.HasPrefix(k, prefix) {
+			res[k] = e.value
+		}
+	}
+	return res, nil
+}
+
+// EtcdClusterStore is a ClusterStore in etcd v3, used through its JSON
+// gateway. Keys expire with leases granted for each put.
+type EtcdClusterStore struct {
+	endpoint string
+	client   *http.Client
+}
+
+func NewEtcdClusterStore(endpoint string) *EtcdClusterStore {
+	return &EtcdClusterStore{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		client:   &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+type etcdKV struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+func (e *EtcdClusterStore) call(method string, req, resp interface{}) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	res, err := e.client.Post(e.endpoint+"/v3/"+method, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("etcd %s status=%d error=%s", method, res.StatusCode, strings.TrimSpace(string(data)))
+	}
+	if resp == nil {
+		return nil
+	}
+	return json.Unmarshal(data, resp)
+}
+
+func etcdKey(key string) string {
+	return base64.StdEncoding.EncodeToString([]byte(key))
+}
+
+func (e *EtcdClusterStore) Put(key string, value []byte, ttl time.Duration) error {
+	var lease struct {
+		ID string `json:"ID"`
+	}
+	secs := int64((ttl + time.Second - 1) / time.Second)
+	if err := e.call("lease/grant", map[string]int64{"TTL": secs}, &lease); err != nil {
+		return err
+	}
+	return e.call("kv/put", map[string]string{
+		"key":   etcdKey(key),
+		"value": base64.StdEncoding.EncodeToString(value),
+		"lease": lease.ID,
+	}, nil)
+}
+
+func (e *EtcdClusterStore) rangeKeys(req map[string]string) (map[string][]byte, error) {
+	var resp struct {
+		KVs []etcdKV `json:"kvs"`
+	}
+	if err := e.call("kv/range", req, &resp); err != nil {
+		return nil, err
+	}
+	res := make(map[string][]byte)
+	for _, kv := range resp.KVs {
+		k, err := base64.StdEncoding.DecodeString(kv.Key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := base64.StdEncoding.DecodeString(kv.Value)
+		if err != nil {
+			return nil, err
+		}
+		res[string(k)] = v
+	}
+	return res, nil
+}
+
+func (e *EtcdClusterStore) Get(key string) ([]byte, error) {
+	res, err := e.rangeKeys(map[string]string{"key": etcdKey(key)})
+	if err != nil {
+		return nil, err
+	}
+	return res[key], nil
+}
+
+func (e *EtcdClusterStore) Delete(key string) error {
+	return e.call("kv/deleterange", map[string]string{"key": etcdKey(key)}, nil)
+}
+
+func (e *EtcdClusterStore) List(prefix string) (map[string][]byte, error) {
+	// the range of keys with the prefix ends at the prefix with its last
+	// byte incremented, or is every key for no prefix
+	start, end := []byte{0}, []byte{0}
+	if prefix != "" {
+		start, end = []byte(prefix), []byte(prefix)
+		end[len(end)-1]++
+	}
+	return e.rangeKeys(map[string]string{
+		"key":       base64.StdEncoding.EncodeToString(start),
+		"range_end": base64.StdEncoding.EncodeToString(end),
+	})
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"sync"
+
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/drivers"
+	"github.com/livepeer/lpms/ffmpeg"
+)
+
+// Output formats of the renditions given in JSON. CMAF renditions are
+// transcoded into fragmented MP4, which the broadcaster splits into the
+// initialization and media segments of a CMAF track.
+const (
+	jsonFormatMPEGTS = "mpegts"
+	jsonFormatMP4    = "mp4"
+	jsonFormatCMAF   = "cmaf"
+)
+
+var errNoFragments = errors.New("no MP4 movie fragments")
+
+// jsonProfileFormat returns the output format of renditions given in JSON
+// with the format `format`; FormatNone to use the format of the source
+func jsonProfileFormat(format string) (ffmpeg.Format, error) {
+	switch strings.ToLower(format) {
+	case "":
+		return ffmpeg.FormatNone, nil
+	case jsonFormatMPEGTS:
+		return ffmpeg.FormatMPEGTS, nil
+	case jsonFormatMP4, jsonFormatCMAF:
+		return ffmpeg.FormatMP4, nil
+	}
+	return ffmpeg.FormatNone, errors.New("invalid format value")
+}
+
+// cmafRenditions returns the names of the renditions given as CMAF in
+// `jsonProfiles`, whose video profiles are `profiles`; nil if there are none
+func cmafRenditions(jsonProfiles []jsonProfile, profiles []ffmpeg.VideoProfile) map[string]bool {
+	var names map[string]bool
+	for i, p := range jsonProfiles {
+		if i >= len(profiles) || strings.ToLower(p.Format) != jsonFormatCMAF {
+			continue
+		}
+		if names == nil {
+			names = make(map[string]bool)
+		}
+		names[profiles[i].Name] = true
+	}
+	return names
+}
+
+// isCMAF tells whether the rendition `profile` of a stream is packaged as
+// CMAF. Renditions moved to MPEG-TS after format errors no longer are.
+func isCMAF(params *core.StreamParameters, profile ffmpeg.VideoProfile) bool {
+	return params != nil && params.CMAF[profile.Name] && profile.Format == ffmpeg.FormatMP4
+}
+
+// splitCMAF splits the fragmented MP4 segment `data` into its initialization
+// segment, the boxes up to its movie box, and its media segment, the movie
+// fragments following it
+func splitCMAF(data []byte) (init, media []byte, err error) {
+	if merr := checkMP4Body(data); merr != nil {
+		return nil, nil, merr
+	}
+	moov := false
+	for off := 0; off < len(data); {
+		rest := data[off:]
+		size := uint64(binary.BigEndian.Uint32(rest))
+		switch size {
+		case 0:
+			size = uint64(len(rest))
+		case 1:
+			size = binary.BigEndian.Uint64(rest[8:])
+		}
+		switch string(rest[4:8]) {
+		case "moov":
+			moov = true
+		case "styp", "sidx", "moof":
+			if !moov {
+				return nil, nil, fmt.Errorf("MP4 movie fragment at offset %d precedes the movie box", off)
+			}
+			return data[:off], data[off:], nil
+		}
+		off += int(size)
+	}
+	return nil, nil, errNoFragments
+}
+
+// cmafInits saves the initialization segments of the CMAF renditions of a
+// stream, once each time they change. The zero value is ready to use.
+type cmafInits struct {
+	mu sync.Mutex
+	// by rendition
+	last map[string]cmafInit
+}
+
+type cmafInit struct {
+	sum uint32
+	uri string
+}
+
+// saveSegment saves the fragmented MP4 rendition `data` of the segment
+// `seqNo` as a CMAF media segment, and returns its URI along with that of
+// its initialization segment
+func (c *cmafInits) saveSegment(os drivers.OSSession, rendition string, seqNo uint64, data []byte) (uri, initURI string, err error) {
+	init, media, err := splitCMAF(data)
+	if err != nil {
+		return "", "", err
+	}
+	if initURI, err = c.save(os, rendition, init); err != nil {
+		return "", "", err
+	}
+	uri, err = os.SaveData(fmt.Sprintf("%s/%d%s", rendition, seqNo, core.CMAFSegmentExt), media, nil)
+	if err != nil {
+		return "", "", err
+	}
+	return uri, initURI, nil
+}
+
+// save returns the URI of the initialization segment `data` of `rendition`,
+// saving it to `os` unless it is the same as the last one. Initialization
+// segments are kept apart from the media segments so that object stores
+// caching a number of recent segments per directory don't evict them.
+func (c *cmafInits) save(os drivers.OSSession, rendition string, data []byte) (string, error) {
+	sum := crc32.ChecksumIEEE(data)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if last, ok := c.last[rendition]; ok && last.sum == sum {
+		return last.uri, nil
+	}
+	uri, err := os.SaveData(fmt.Sprintf("%s/init/init_%08x.mp4", rendition, sum), data, nil)
+	if err != nil {
+		return "", err
+	}
+	if c.last == nil {
+		c.last = make(map[string]cmafInit)
+	}
+	c.last[rendition] = cmafInit{sum: sum, uri: uri}
+	return uri, nil
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"fmt"
+	"hash/crc32"
+	"testing"
+
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/drivers"
+	"github.com/livepeer/lpms/ffmpeg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func cmafTestSegment(timescale uint32) (init, media []byte) {
+	init = append(mp4TestBox("ftyp", []byte("iso6")), mp4TestBox("moov", mp4TestMvhd(timescale, 0))...)
+	media = append(mp4TestBox("moof", []byte("fragment")), mp4TestBox("mdat", []byte("samples"))...)
+	return init, media
+}
+
+func TestSplitCMAF(t *testing.T) {
+	assert := assert.New(t)
+
+	init, media := cmafTestSegment(1000)
+	i, m, err := splitCMAF(append(append([]byte(nil), init...), media...))
+	assert.Nil(err)
+	assert.Equal(init, i)
+	assert.Equal(media, m)
+
+	// segment type boxes start the media segment
+	styp := append(mp4TestBox("styp", []byte("msdh")), media...)
+	i, m, err = splitCMAF(append(append([]byte(nil), init...), styp...))
+	assert.Nil(err)
+	assert.Equal(init, i)
+	assert.Equal(styp, m)
+
+	_, _, err = splitCMAF(init)
+	assert.Equal(errNoFragments, err)
+	_, _, err = splitCMAF(append(append([]byte(nil), media...), init...))
+	assert.EqualError(err, "MP4 movie fragment at offset 0 precedes the movie box")
+	_, _, err = splitCMAF(init[:len(init)-1])
+	assert.Contains(err.Error(), "truncated segment")
+}
+
+func TestCMAFInits_SaveSegment(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	os := drivers.NewMemoryDriver(nil).NewSession("mani")
+	var inits cmafInits
+	init, media := cmafTestSegment(1000)
+	seg := append(append([]byte(nil), init...), media...)
+	initName := fmt.Sprintf("mani/source/init/init_%08x.mp4", crc32.ChecksumIEEE(init))
+
+	uri, initURI, err := inits.saveSegment(os, "source", 1, seg)
+	require.Nil(err)
+	assert.Equal("/stream/mani/source/1.m4s", uri)
+	assert.Equal("/stream/"+initName, initURI)
+	assert.Equal(media, os.(*drivers.MemorySession).GetData("mani/source/1.m4s"))
+	assert.Equal(init, os.(*drivers.MemorySession).GetData(initName))
+
+	// initialization segments are saved when they change
+	uri, initURI2, err := inits.saveSegment(os, "source", 2, seg)
+	require.Nil(err)
+	assert.Equal("/stream/mani/source/2.m4s", uri)
+	assert.Equal(initURI, initURI2)
+	init2, media2 := cmafTestSegment(90000)
+	_, initURI3, err := inits.saveSegment(os, "source", 3, append(init2, media2...))
+	require.Nil(err)
+	assert.NotEqual(initURI, initURI3)
+
+	// and apart for each rendition
+	_, initURI4, err := inits.saveSegment(os, "low", 3, seg)
+	require.Nil(err)
+	assert.Equal(fmt.Sprintf("/stream/mani/low/init/init_%08x.mp4", crc32.ChecksumIEEE(init)), initURI4)
+
+	_, _, err = inits.saveSegment(os, "source", 4, init)
+	assert.Equal(errNoFragments, err)
+}
+
+func TestCMAFRenditions(t *testing.T) {
+	assert := assert.New(t)
+
+	jsonProfiles := []jsonProfile{{Name: "a", Format: "cmaf"}, {Name: "b", Format: "mp4"}, {Width: 1, Height: 2, Format: "CMAF"}}
+	profiles, err := parseJSONProfiles(jsonProfiles)
+	assert.Nil(err)
+	for _, p := range profiles {
+		assert.Equal(ffmpeg.FormatMP4, p.Format)
+	}
+	assert.Equal(map[string]bool{"a": true, "webhook_1x2_0": true}, cmafRenditions(jsonProfiles, profiles))
+	assert.Nil(cmafRenditions(jsonProfiles[1:2], profiles[1:2]))
+	_, err = parseJSONProfiles([]jsonProfile{{Name: "c", Format: "flv"}})
+	assert.EqualError(err, "invalid format value")
+
+	params := &core.StreamParameters{CMAF: map[string]bool{"a": true}}
+	assert.True(isCMAF(params, profiles[0]))
+	assert.False(isCMAF(params, profiles[1]))
+	// not once moved to MPEG-TS
+	profiles[0].Format = ffmpeg.FormatMPEGTS
+	assert.False(isCMAF(params, profiles[0]))
+	assert.False(isCMAF(nil, profiles[1]))
+}
+
+func TestEncryptPlaylist_CMAF(t *testing.T) {
+	assert := assert.New(t)
+
+	pl := core.NewBasicPlaylistManager("mani", nil, nil)
+	profile := &ffmpeg.P144p30fps16x9
+	assert.Nil(pl.InsertCMAFSegment(profile, 1, "mani/P144p30fps16x9/1.m4s", "mani/P144p30fps16x9/init/init_1.mp4", 2))
+	assert.Nil(pl.InsertCMAFSegment(profile, 2, "mani/P144p30fps16x9/2.m4s", "mani/P144p30fps16x9/init/init_2.mp4", 2))
+
+	enc, err := newHLSKeyring("mani", 3, nil).encryptPlaylist(pl.GetHLSMediaPlaylist(profile.Name))
+	assert.Nil(err)
+	out := enc.String()
+	assert.NotContains(out, "AES-128")
+	assert.Contains(out, `#EXT-X-MAP:URI="mani/P144p30fps16x9/init/init_1.mp4"`)
+	assert.Contains(out, `#EXT-X-MAP:URI="mani/P144p30fps16x9/init/init_2.mp4"`)
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/livepeer/go-livepeer/core"
+)
+
+const connectionShards = 32
+
+// connectionMap holds the active rtmpConnections keyed by manifest ID.
+// The map is split into shards with their own locks so that lookups on the
+// hot paths (segment push, watchdogs, playlist fetches) for different streams
+// do not contend with each other or with stream creation and removal.
+type connectionMap struct {
+	shards [connectionShards]connectionShard
+	count  int64
+}
+
+type connectionShard struct {
+	mu   sync.RWMutex
+	cxns map[core.ManifestID]*rtmpConnection
+}
+
+func newConnectionMap() *connectionMap {
+	m := &connectionMap{}
+	for i := range m.shards {
+		m.shards[i].cxns = make(map[core.ManifestID]*rtmpConnection)
+	}
+	return m
+}
+
+// shard picks the shard for the manifest ID using 32 bit FNV-1a, inlined to
+// avoid allocating on every lookup
+func (m *connectionMap) shard(mid core.ManifestID) *connectionShard {
+	h := uint32(2166136261)
+	for i := 0; i < len(mid); i++ {
+		h ^= uint32(mid[i])
+		h *= 16777619
+	}
+	return &m.shards[h%connectionShards]
+}
+
+func (m *connectionMap) get(mid core.ManifestID) (*rtmpConnection, bool) {
+	sh := m.shard(mid)
+	sh.mu.RLock()
+	defer sh.mu.RUnlock()
+	cxn, ok := sh.cxns[mid]
+	return cxn, ok
+}
+
+// loadOrStore returns the existing connection for the manifest ID if there is
+// one. Otherwise it stores the given connection and returns it, unless the
+// map already holds `max` connections, if `max` is positive.
+func (m *connectionMap) loadOrStore(mid core.ManifestID, cxn *rtmpConnection, max int) (*rtmpConnection, bool, error) {
+	sh := m.shard(mid)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if existing, ok := sh.cxns[mid]; ok {
+		return existing, true, nil
+	}
+	// Take the slot before storing so that concurrent stores into other
+	// shards cannot go over the limit either
+	for {
+		count := atomic.LoadInt64(&m.count)
+		if max > 0 && count >= int64(max) {
+			return nil, false, errTooManySessions
+		}
+		if atomic.CompareAndSwapInt64(&m.count, count, count+1) {
+			break
+		}
+	}
+	sh.cxns[mid] = cxn
+	return cxn, false, nil
+}
+
+// store sets the connection for the manifest ID, replacing any existing one
+func (m *connectionMap) store(mid core.ManifestID, cxn *rtmpConnection) {
+	sh := m.shard(mid)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if _, ok := sh.cxns[mid]; !ok {
+		atomic.AddInt64(&m.count, 1)
+	}
+	sh.cxns[mid] = cxn
+}
+
+func (m *connectionMap) delete(mid core.ManifestID) {
+	sh := m.shard(mid)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if _, ok := sh.cxns[mid]; ok {
+		delete(sh.cxns, mid)
+		atomic.AddInt64(&m.count, -1)
+	}
+}
+
+func (m *connectionMap) len() int {
+	return int(atomic.LoadInt64(&m.count))
+}
+
+// forEach calls f for every connection until f returns false. Each shard is
+// locked only while it is being visited, so the iteration is not a
+// consistent snapshot of the whole map.
+func (m *connectionMap) forEach(f func(mid core.ManifestID, cxn *rtmpConnection) bool) {
+	for i := range m.shards {
+		sh := &m.shards[i]
+		sh.mu.RLock()
+		for mid, cxn := range sh.cxns {
+			if !f(mid, cxn) {
+				sh.mu.RUnlock()
+				return
+			}
+		}
+		sh.mu.RUnlock()
+	}
+}
@@ -0,0 +1,183 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectionMap(t *testing.T) {
+	assert := assert.New(t)
+
+	m := newConnectionMap()
+	assert.Equal(0, m.len())
+	_, ok := m.get("mani")
+	assert.False(ok)
+
+	cxn := &rtmpConnection{mid: "mani"}
+	actual, loaded, err := m.loadOrStore("mani", cxn, 0)
+	assert.Nil(err)
+	assert.False(loaded)
+	assert.Equal(cxn, actual)
+	assert.Equal(1, m.len())
+
+	// existing connections are not replaced
+	actual, loaded, err = m.loadOrStore("mani", &rtmpConnection{mid: "other"}, 1)
+	assert.Nil(err)
+	assert.True(loaded)
+	assert.Equal(cxn, actual)
+	assert.Equal(1, m.len())
+
+	// store replaces without changing the count
+	cxn2 := &rtmpConnection{mid: "mani"}
+	m.store("mani", cxn2)
+	actual, ok = m.get("mani")
+	assert.True(ok)
+	assert.Equal(cxn2, actual)
+	assert.Equal(1, m.len())
+
+	for i := 0; i < 100; i++ {
+		m.store(core.ManifestID(fmt.Sprintf("mani%d", i)), &rtmpConnection{})
+	}
+	assert.Equal(101, m.len())
+	n := 0
+	m.forEach(func(core.ManifestID, *rtmpConnection) bool {
+		n++
+		return true
+	})
+	assert.Equal(101, n)
+	n = 0
+	m.forEach(func(core.ManifestID, *rtmpConnection) bool {
+		n++
+		return n < 10
+	})
+	assert.Equal(10, n)
+
+	m.delete("mani")
+	m.delete("mani")
+	assert.Equal(100, m.len())
+	_, ok = m.get("mani")
+	assert.False(ok)
+}
+
+func TestConnectionMap_Max(t *testing.T) {
+	assert := assert.New(t)
+
+	m := newConnectionMap()
+	_, _, err := m.loadOrStore("mani1", &rtmpConnection{}, 2)
+	assert.Nil(err)
+	_, _, err = m.loadOrStore("mani2", &rtmpConnection{}, 2)
+	assert.Nil(err)
+	actual, loaded, err := m.loadOrStore("mani3", &rtmpConnection{}, 2)
+	assert.Equal(errTooManySessions, err)
+	assert.False(loaded)
+	assert.Nil(actual)
+	_, ok := m.get("mani3")
+	assert.False(ok)
+	assert.Equal(2, m.len())
+
+	// concurrent stores never go over the limit
+	m = newConnectionMap()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	stored := 0
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if _, _, err := m.loadOrStore(core.ManifestID(fmt.Sprintf("mani%d", i)), &rtmpConnection{}, 10); err == nil {
+				mu.Lock()
+				stored++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(10, stored)
+	assert.Equal(10, m.len())
+}
+
+func TestRTMPConnection_LastUsed(t *testing.T) {
+	cxn := &rtmpConnection{}
+	now := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cxn.touch(now)
+			cxn.lastUsedTime()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, now, cxn.lastUsedTime())
+}
+
+// lockedConnectionMap is the single-lock map the sharded map replaced; kept
+// for comparison in benchmarks
+type lockedConnectionMap struct {
+	mu   sync.RWMutex
+	cxns map[core.ManifestID]*rtmpConnection
+}
+
+func (m *lockedConnectionMap) get(mid core.ManifestID) (*rtmpConnection, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	cxn, ok := m.cxns[mid]
+	return cxn, ok
+}
+
+func (m *lockedConnectionMap) store(mid core.ManifestID, cxn *rtmpConnection) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.cxns[mid] = cxn
+}
+
+func (m *lockedConnectionMap) delete(mid core.ManifestID) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.cxns, mid)
+}
+
+type connectionStore interface {
+	get(core.ManifestID) (*rtmpConnection, bool)
+	store(core.ManifestID, *rtmpConnection)
+	delete(core.ManifestID)
+}
+
+// benchmarkConnections simulates thousands of concurrent streams: mostly
+// lookups from pushes and watchdogs, with occasional stream churn
+func benchmarkConnections(b *testing.B, m connectionStore) {
+	const streams = 5000
+	mids := make([]core.ManifestID, streams)
+	for i := range mids {
+		mids[i] = core.ManifestID(fmt.Sprintf("stream%d", i))
+		m.store(mids[i], &rtmpConnection{mid: mids[i]})
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			mid := mids[i%streams]
+			if i%100 == 0 {
+				m.delete(mid)
+				m.store(mid, &rtmpConnection{mid: mid})
+			} else if cxn, ok := m.get(mid); ok {
+				cxn.touch(time.Time{})
+			}
+			i++
+		}
+	})
+}
+
+func BenchmarkConnectionMap_Sharded(b *testing.B) {
+	benchmarkConnections(b, newConnectionMap())
+}
+
+func BenchmarkConnectionMap_SingleLock(b *testing.B) {
+	benchmarkConnections(b, &lockedConnectionMap{cxns: make(map[core.ManifestID]*rtmpConnection)})
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/core"
+)
+
+const dashManifestName = "index.mpd"
+
+// serveDASH serves the MPEG-DASH manifests of the live streams of the node at
+// /stream/{manifestID}/index.mpd, passing other requests on to `next`. The
+// manifests list the same MPEG-TS and CMAF segments as the HLS playlists.
+func (s *LivepeerServer) serveDASH(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mid, ok := parseDASHPath(r.URL.Path)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		cxn, ok := s.rtmpConnections.get(mid)
+		if !ok || cxn.pl == nil {
+			http.Error(w, "Stream not found", http.StatusNotFound)
+			return
+		}
+		// DASH players have no use for the keys of encrypted HLS
+		if cxn.hlsKeys != nil {
+			http.Error(w, "DASH not available for encrypted streams", http.StatusNotFound)
+			return
+		}
+		body, err := cxn.pl.GetDASHManifest(clock.Now())
+		if err != nil {
+			glog.Errorf("Unable to serve DASH manifest url=%s err=%v", common.RedactURL(r.URL.String()), err)
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Cache-Control", "max-age=1")
+		w.Header().Set("Content-Type", "application/dash+xml")
+		w.Write(body)
+	})
+}
+
+// parseDASHPath returns the manifest ID of a DASH manifest request
+func parseDASHPath(reqPath string) (core.ManifestID, bool) {
+	if !strings.HasPrefix(reqPath, "/stream/") || path.Base(reqPath) != dashManifestName {
+		return "", false
+	}
+	parts := strings.Split(cleanStreamPrefix(reqPath), "/")
+	if len(parts) != 2 || parts[0] == "" {
+		return "", false
+	}
+	return core.ManifestID(parts[0]), true
+}
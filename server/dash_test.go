@@ -0,0 +1,69 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDASH_ParsePath(t *testing.T) {
+	assert := assert.New(t)
+
+	mid, ok := parseDASHPath("/stream/mani/index.mpd")
+	assert.True(ok)
+	assert.Equal(core.ManifestID("mani"), mid)
+
+	for _, p := range []string{
+		"/stream/mani.m3u8",
+		"/stream/index.mpd",
+		"/stream//index.mpd",
+		"/stream/mani/source/index.mpd",
+		"/recordings/mani/index.mpd",
+	} {
+		_, ok = parseDASHPath(p)
+		assert.False(ok, p)
+	}
+}
+
+func TestDASH_Handler(t *testing.T) {
+	assert := assert.New(t)
+	s := setupServer()
+	defer serverCleanup(s)
+
+	s.rtmpConnections.store("mani", startOverConnection(t))
+	defer s.rtmpConnections.delete("mani")
+	s.rtmpConnections.store("empty", &rtmpConnection{mid: "empty", pl: core.NewBasicPlaylistManager("empty", nil, nil)})
+	defer s.rtmpConnections.delete("empty")
+	encrypted := startOverConnection(t)
+	encrypted.hlsKeys = newHLSKeyring("mani", 3, nil)
+	s.rtmpConnections.store("encrypted", encrypted)
+	defer s.rtmpConnections.delete("encrypted")
+
+	var passed bool
+	h := s.serveDASH(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		passed = true
+	}))
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		passed = false
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	serve("GET", "/stream/mani/source.m3u8")
+	assert.True(passed)
+
+	w := serve("GET", "/stream/mani/index.mpd")
+	assert.False(passed)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("application/dash+xml", w.Header().Get("Content-Type"))
+	assert.Contains(w.Body.String(), `<SegmentURL media="mani/source/6.ts"></SegmentURL>`)
+
+	assert.Equal(http.StatusMethodNotAllowed, serve("POST", "/stream/mani/index.mpd").Code)
+	assert.Equal(http.StatusNotFound, serve("GET", "/stream/unknown/index.mpd").Code)
+	assert.Equal(http.StatusNotFound, serve("GET", "/stream/empty/index.mpd").Code)
+	assert.Equal(http.StatusNotFound, serve("GET", "/stream/encrypted/index.mpd").Code)
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// DrainTimeout, if non-zero, is how long a draining broadcaster waits for its
+// live streams to end before it's considered drained regardless
+var DrainTimeout time.Duration
+
+// drainPollInterval is how often a draining broadcaster checks whether its
+// live streams ended
+var drainPollInterval = time.Second
+
+var errDraining = newError(ErrorCategoryIngest, true, "broadcaster is draining")
+
+// broadcastDrain tracks the drain of a broadcaster: while draining, no new
+// streams are accepted but live ones carry on until they end, or time out
+// after DrainTimeout. A nil *broadcastDrain never drains.
+type broadcastDrain struct {
+	mu       sync.Mutex
+	draining bool
+	// stop stops the watch of the current drain when it's cancelled
+	stop chan struct{}
+	// done is closed once a drain completed
+	done chan struct{}
+}
+
+func newBroadcastDrain() *broadcastDrain {
+	return &broadcastDrain{done: make(chan struct{})}
+}
+
+func (d *broadcastDrain) isDraining() bool {
+	if d == nil {
+		return false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.draining
+}
+
+// StartDrain stops the broadcaster from accepting new streams. Drained() is
+// closed once its live streams ended, or DrainTimeout passed if set. Starting
+// a drain that is already under way does nothing.
+func (s *LivepeerServer) StartDrain() {
+	d := s.drain
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.draining {
+		return
+	}
+	d.draining = true
+	d.stop = make(chan struct{})
+	glog.Infof("Broadcaster drain started streams=%d timeout=%s", s.rtmpConnections.len(), DrainTimeout)
+	go s.watchDrain(d.stop)
+}
+
+// CancelDrain makes the broadcaster accept new streams again, unless it
+// already drained
+func (s *LivepeerServer) CancelDrain() {
+	d := s.drain
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.draining || isClosed(d.done) {
+		return
+	}
+	d.draining = false
+	close(d.stop)
+	glog.Info("Broadcaster drain cancelled")
+}
+
+// Drained returns a channel closed once a drain of the broadcaster completed
+func (s *LivepeerServer) Drained() <-chan struct{} {
+	return s.drain.done
+}
+
+// watchDrain closes the done channel of the drain once no streams are left
+// or DrainTimeout passed, unless `stop` is closed first
+func (s *LivepeerServer) watchDrain(stop chan struct{}) {
+	d := s.drain
+	deadline := clock.Now().Add(DrainTimeout)
+	timer := clock.NewTimer(drainPollInterval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-timer.C():
+		}
+		left := s.rtmpConnections.len()
+		if left == 0 || (DrainTimeout > 0 && !clock.Now().Before(deadline)) {
+			d.mu.Lock()
+			// a cancel, or another drain, may have raced with the last check
+			if d.draining && d.stop == stop && !isClosed(d.done) {
+				glog.Infof("Broadcaster drained streams=%d", left)
+				close(d.done)
+			}
+			d.mu.Unlock()
+			return
+		}
+		timer.Reset(drainPollInterval)
+	}
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/lpms/stream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBroadcasterDrain(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	c := useFakeClock(t)
+	s := setupServer()
+	defer serverCleanup(s)
+	defer func(d *broadcastDrain, timeout time.Duration) { s.drain, DrainTimeout = d, timeout }(s.drain, DrainTimeout)
+	s.drain = newBroadcastDrain()
+
+	register := func(mid core.ManifestID) (*rtmpConnection, error) {
+		return s.registerConnection(stream.NewBasicRTMPVideoStream(&core.StreamParameters{ManifestID: mid}))
+	}
+	drained := func() bool {
+		select {
+		case <-s.Drained():
+			return true
+		default:
+			return false
+		}
+	}
+	waitDrained := func() bool {
+		select {
+		case <-s.Drained():
+			return true
+		case <-time.After(time.Second):
+			return false
+		}
+	}
+	poll := func() {
+		assert.True(c.waitTimerBy(c.Now().Add(drainPollInterval)))
+		c.Advance(drainPollInterval)
+	}
+
+	live, err := register("live")
+	require.Nil(err)
+	defer removeRTMPStream(s, "live")
+
+	// drains are started and cancelled from the CLI webserver
+	srv := httptest.NewServer(s.cliWebServerHandlers("addr"))
+	defer srv.Close()
+	setDrain := func(drain string) int {
+		resp, err := http.PostForm(srv.URL+"/setBroadcasterDrain", url.Values{"drain": {drain}})
+		require.Nil(err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(http.StatusBadRequest, setDrain("true"))
+	defer func(typ core.NodeType) { s.LivepeerNode.NodeType = typ }(s.LivepeerNode.NodeType)
+	s.LivepeerNode.NodeType = core.BroadcasterNode
+	assert.Equal(http.StatusBadRequest, setDrain("maybe"))
+	assert.False(s.drain.isDraining())
+	assert.Equal(http.StatusOK, setDrain("true"))
+
+	// new streams are rejected while live ones carry on
+	_, err = register("new")
+	assert.Equal(errDraining, err)
+	cxn, err := register("live")
+	assert.Equal(errAlreadyExists, err)
+	assert.Equal(live, cxn)
+	poll()
+	assert.False(drained())
+
+	// until the drain is cancelled
+	assert.Equal(http.StatusOK, setDrain("false"))
+	_, err = register("new")
+	require.Nil(err)
+	removeRTMPStream(s, "new")
+
+	// the broadcaster is drained once its streams ended
+	s.StartDrain()
+	poll()
+	assert.False(drained())
+	removeRTMPStream(s, "live")
+	poll()
+	assert.True(waitDrained())
+
+	// or once the drain timed out
+	s.drain = newBroadcastDrain()
+	DrainTimeout = time.Minute
+	_, err = register("live")
+	require.Nil(err)
+	s.StartDrain()
+	for i := time.Duration(0); i < DrainTimeout; i += drainPollInterval {
+		assert.False(drained())
+		poll()
+	}
+	assert.True(waitDrained())
+	_, err = register("new")
+	assert.Equal(errDraining, err)
+}
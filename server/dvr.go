@@ -0,0 +1,41 @@
+package server
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/m3u8"
+)
+
+// DVRWindow, if non-zero, makes the live media playlists of recorded streams
+// span the last DVRWindow of the stream, with the segments that fell out of
+// the live playlist served from the record store, so that players can seek
+// back during the broadcast
+var DVRWindow time.Duration
+
+// dvrMediaPlaylist returns the live playlist of `track` covering the DVR
+// window before `now`; nil if the stream has no DVR window to serve, in which
+// case the live playlist is served as is
+func dvrMediaPlaylist(cxn *rtmpConnection, track string, now time.Time) *m3u8.MediaPlaylist {
+	if DVRWindow <= 0 {
+		return nil
+	}
+	mpl, err := recordedMediaPlaylist(cxn, track, now.Add(-DVRWindow), now)
+	if err != nil {
+		if err != errStartOverUnavailable {
+			glog.V(common.DEBUG).Infof("Serving live playlist without DVR window manifestID=%s track=%s err=%v", cxn.mid, track, err)
+		}
+		return nil
+	}
+	return mpl
+}
+
+// recordHistory returns how far back the recordings of live streams are kept
+// at hand for the playlists served from them
+func recordHistory() time.Duration {
+	if DVRWindow > StartOverWindow {
+		return DVRWindow
+	}
+	return StartOverWindow
+}
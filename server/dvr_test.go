@@ -0,0 +1,82 @@
+package server
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/livepeer/m3u8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDVRMediaPlaylist(t *testing.T) {
+	assert := assert.New(t)
+	oldWindow := DVRWindow
+	defer func() { DVRWindow = oldWindow }()
+
+	cxn := startOverConnection(t)
+	now := time.Unix(1600000000, 0)
+
+	DVRWindow = 0
+	assert.Nil(dvrMediaPlaylist(cxn, "source", now))
+
+	// recorded segments followed by the live ones, trimmed to the window
+	DVRWindow = 7 * time.Second
+	mpl := dvrMediaPlaylist(cxn, "source", now)
+	require.NotNil(t, mpl)
+	assert.Equal(uint64(3), mpl.SeqNo)
+	out := mpl.String()
+	assert.NotContains(out, "#EXT-X-PLAYLIST-TYPE")
+	assert.NotContains(out, "/recordings/recmid/source/2.ts")
+	assert.Contains(out, "/recordings/recmid/source/3.ts")
+	assert.Contains(out, "mani/source/6.ts")
+	assert.NotContains(out, "#EXT-X-ENDLIST")
+
+	assert.Nil(dvrMediaPlaylist(cxn, "P144p30fps16x9", now))
+
+	// not with encrypted playlists
+	cxn.hlsKeys = newHLSKeyring("mani", 3, nil)
+	assert.Nil(dvrMediaPlaylist(cxn, "source", now))
+}
+
+func TestDVRMediaPlaylist_Handler(t *testing.T) {
+	assert := assert.New(t)
+	s := setupServer()
+	defer serverCleanup(s)
+	oldWindow := DVRWindow
+	defer func() { DVRWindow = oldWindow }()
+
+	s.rtmpConnections.store("mani", startOverConnection(t))
+	defer s.rtmpConnections.delete("mani")
+	handler := getHLSMediaPlaylistHandler(s)
+	get := func() *m3u8.MediaPlaylist {
+		u, err := url.Parse("/stream/mani/source.m3u8")
+		require.Nil(t, err)
+		mpl, err := handler(u)
+		require.Nil(t, err)
+		return mpl
+	}
+
+	// the live playlist only
+	DVRWindow = 0
+	assert.NotContains(get().String(), "/recordings/")
+
+	DVRWindow = time.Hour
+	mpl := get()
+	assert.Equal(uint64(1), mpl.SeqNo)
+	assert.Contains(mpl.String(), "/recordings/recmid/source/1.ts")
+}
+
+func TestRecordHistory(t *testing.T) {
+	assert := assert.New(t)
+	oldDVR, oldStartOver := DVRWindow, StartOverWindow
+	defer func() { DVRWindow, StartOverWindow = oldDVR, oldStartOver }()
+
+	DVRWindow, StartOverWindow = 0, 0
+	assert.Zero(recordHistory())
+	DVRWindow = time.Minute
+	assert.Equal(time.Minute, recordHistory())
+	StartOverWindow = time.Hour
+	assert.Equal(time.Hour, recordHistory())
+}
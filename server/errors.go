@@ -0,0 +1,89 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+)
+
+// ErrorCategory is the part of the system an error comes from
+type ErrorCategory string
+
+const (
+	ErrorCategoryUnknown   ErrorCategory = "unknown"
+	ErrorCategoryAuth      ErrorCategory = "auth"
+	ErrorCategoryIngest    ErrorCategory = "ingest"
+	ErrorCategoryStorage   ErrorCategory = "storage"
+	ErrorCategoryDiscovery ErrorCategory = "discovery"
+	ErrorCategoryPayment   ErrorCategory = "payment"
+	ErrorCategoryTranscode ErrorCategory = "transcode"
+)
+
+// ServerError is an error of the server carrying its category and whether
+// the request failing with it may succeed when retried
+type ServerError struct {
+	Category  ErrorCategory
+	Retryable bool
+	msg       string
+	err       error
+}
+
+// newError returns a new error with the message `msg`
+func newError(category ErrorCategory, retryable bool, msg string) error {
+	return &ServerError{Category: category, Retryable: retryable, msg: msg}
+}
+
+// wrapError returns `err` as an error of `category`, keeping its message. An
+// error that already has a category keeps it.
+func wrapError(category ErrorCategory, retryable bool, err error) error {
+	if err == nil {
+		return nil
+	}
+	var serr *ServerError
+	if errors.As(err, &serr) {
+		return err
+	}
+	return &ServerError{Category: category, Retryable: retryable, err: err}
+}
+
+func (e *ServerError) Error() string {
+	if e.err != nil {
+		return e.err.Error()
+	}
+	return e.msg
+}
+
+func (e *ServerError) Unwrap() error {
+	return e.err
+}
+
+// errorCategory returns the category of `err`, which is unknown for errors
+// without one
+func errorCategory(err error) ErrorCategory {
+	var serr *ServerError
+	if errors.As(err, &serr) {
+		return serr.Category
+	}
+	return ErrorCategoryUnknown
+}
+
+// isRetryable returns whether the request failing with `err` may succeed
+// when retried. Errors without a category are not.
+func isRetryable(err error) bool {
+	var serr *ServerError
+	return errors.As(err, &serr) && serr.Retryable
+}
+
+// errorStatus returns the HTTP status a request failing with `err` is
+// answered with: requests that may be retried are unavailable for now, and
+// ingest that will not be accepted as it is is a bad request
+func errorStatus(err error) int {
+	switch {
+	case errorCategory(err) == ErrorCategoryAuth:
+		return http.StatusForbidden
+	case isRetryable(err):
+		return http.StatusServiceUnavailable
+	case errorCategory(err) == ErrorCategoryIngest:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,50 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerError_Wrap(t *testing.T) {
+	assert := assert.New(t)
+
+	cause := errors.New("some error")
+	err := wrapError(ErrorCategoryPayment, true, cause)
+	assert.EqualError(err, "some error")
+	assert.True(errors.Is(err, cause))
+	assert.Equal(ErrorCategoryPayment, errorCategory(err))
+	assert.True(isRetryable(err))
+
+	// errors keep the category they already have, even when wrapped further
+	wrapped := fmt.Errorf("Hit max transcode attempts: %w", err)
+	assert.Equal(err, wrapError(ErrorCategoryTranscode, false, err))
+	assert.Equal(ErrorCategoryPayment, errorCategory(wrapError(ErrorCategoryTranscode, false, wrapped)))
+	assert.True(isRetryable(wrapped))
+
+	assert.Nil(wrapError(ErrorCategoryStorage, false, nil))
+
+	// errors without a category
+	assert.Equal(ErrorCategoryUnknown, errorCategory(cause))
+	assert.False(isRetryable(cause))
+	assert.False(isRetryable(nil))
+}
+
+func TestServerError_Status(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(http.StatusForbidden, errorStatus(errPushSigInvalid))
+	assert.Equal(http.StatusServiceUnavailable, errorStatus(errNotReady))
+	assert.Equal(http.StatusServiceUnavailable, errorStatus(fmt.Errorf("Hit max transcode attempts: %w", errNoOrchs)))
+	assert.Equal(http.StatusBadRequest, errorStatus(errMismatchedParams))
+	assert.Equal(http.StatusInternalServerError, errorStatus(errStorage))
+	assert.Equal(http.StatusInternalServerError, errorStatus(wrapError(ErrorCategoryTranscode, false, errors.New("Unsupported input pixel format"))))
+	assert.Equal(http.StatusInternalServerError, errorStatus(errors.New("some error")))
+
+	// sentinels keep their messages
+	assert.EqualError(errNotReady, "ErrNotReady")
+	assert.Equal(&streamEventError{Category: ErrorCategoryAuth, Message: "ErrPushSigInvalid"}, newStreamEventError(errPushSigInvalid))
+}
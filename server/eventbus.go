@@ -0,0 +1,284 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	gonet "net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common"
+)
+
+// EventBus, if set, mirrors stream events and usage records onto the
+// subjects of a message broker. Disabled by default.
+var EventBus *EventPublisher
+
+// Kinds of events published, each on its own subject
+const (
+	eventStream = "stream"
+	eventUsage  = "usage"
+)
+
+// eventSchemaVersion is the version of the schema of the payloads of events,
+// to be bumped on incompatible changes to any of them
+const eventSchemaVersion = 1
+
+// maxQueuedEvents bounds the events waiting to be published; newer events
+// are dropped beyond that
+const maxQueuedEvents = 10000
+
+var (
+	eventRetryMin  = time.Second
+	eventRetryMax  = time.Minute
+	natsAckTimeout = 5 * time.Second
+)
+
+// busEvent is the envelope of published events. Events may be published more
+// than once, so consumers should dedupe them by ID.
+type busEvent struct {
+	ID     string      `json:"id"`
+	Kind   string      `json:"kind"`
+	Schema string      `json:"schema"`
+	Time   int64       `json:"time"`
+	Data   interface{} `json:"data"`
+}
+
+// eventTransport publishes to a broker, returning once the broker
+// acknowledged the payload
+type eventTransport interface {
+	publish(subject string, payload []byte) error
+}
+
+// EventPublisher publishes events one at a time, in order, retrying each
+// until the broker acknowledges it
+type EventPublisher struct {
+	transport eventTransport
+	prefix    string
+	queue     chan *busEvent
+	dropped   uint64
+}
+
+// NewEventPublisher creates a publisher to the broker at `dest`, a
+// nats://[user:pass@]host:port URL, publishing events of each kind on the
+// subject `prefix`.<kind>. For at-least-once delivery, the subjects have to
+// be captured by a JetStream stream, which acknowledges the events it stored.
+func NewEventPublisher(dest, prefix string) (*EventPublisher, error) {
+	u, err := url.Parse(dest)
+	if err != nil {
+		return nil, err
+	}
+	if prefix == "" {
+		return nil, errors.New("missing event subject prefix")
+	}
+	var t eventTransport
+	switch u.Scheme {
+	case "nats":
+		t = &natsTransport{addr: u.Host, user: u.User, timeout: natsAckTimeout}
+	default:
+		return nil, fmt.Errorf("unsupported event bus: %s", common.RedactURL(dest))
+	}
+	p := newEventPublisher(t, prefix, maxQueuedEvents)
+	go p.run()
+	return p, nil
+}
+
+func newEventPublisher(t eventTransport, prefix string, maxQueued int) *EventPublisher {
+	return &EventPublisher{transport: t, prefix: prefix, queue: make(chan *busEvent, maxQueued)}
+}
+
+// publish queues `data` to be published as an event of `kind`. It must not
+// be modified afterwards.
+func (p *EventPublisher) publish(kind string, data interface{}) {
+	if p == nil {
+		return
+	}
+	ev := &busEvent{
+		ID:     common.RandName(),
+		Kind:   kind,
+		Schema: fmt.Sprintf("livepeer.%s.v%d", kind, eventSchemaVersion),
+		Time:   clock.Now().Unix(),
+		Data:   data,
+	}
+	select {
+	case p.queue <- ev:
+	default:
+		dropped := atomic.AddUint64(&p.dropped, 1)
+		glog.Errorf("Dropped event, queue full kind=%s total=%d", kind, dropped)
+	}
+}
+
+func (p *EventPublisher) run() {
+	for ev := range p.queue {
+		p.send(ev)
+	}
+}
+
+// send publishes `ev`, retrying with backoff until it is acknowledged
+func (p *EventPublisher) send(ev *busEvent) {
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		glog.Errorf("Error encoding event kind=%s err=%v", ev.Kind, err)
+		return
+	}
+	subject := p.prefix + "." + ev.Kind
+	backoff := eventRetryMin
+	for {
+		err := p.transport.publish(subject, payload)
+		if err == nil {
+			return
+		}
+		glog.Errorf("Error publishing event subject=%s id=%s retryIn=%v err=%v", subject, ev.ID, backoff, err)
+		t := clock.NewTimer(backoff)
+		<-t.C()
+		if backoff *= 2; backoff > eventRetryMax {
+			backoff = eventRetryMax
+		}
+	}
+}
+
+// natsTransport publishes to a NATS server over its text protocol, waiting
+// for a reply to every message as JetStream acknowledgement
+type natsTransport struct {
+	addr    string
+	user    *url.Userinfo
+	timeout time.Duration
+
+	mu    sync.Mutex
+	conn  gonet.Conn
+	r     *bufio.Reader
+	inbox string
+	n     uint64
+}
+
+type natsAck struct {
+	Error *struct {
+		Description string `json:"description"`
+	} `json:"error"`
+}
+
+func (t *natsTransport) publish(subject string, payload []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.conn == nil {
+		if err := t.connect(); err != nil {
+			return err
+		}
+	}
+	err := t.publishAcked(subject, payload)
+	if err != nil {
+		t.conn.Close()
+		t.conn = nil
+	}
+	return err
+}
+
+func (t *natsTransport) connect() error {
+	conn, err := gonet.DialTimeout("tcp", t.addr, t.timeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(t.timeout))
+	t.conn, t.r = conn, bufio.NewReader(conn)
+	line, err := t.readLine()
+	if err == nil && !strings.HasPrefix(line, "INFO ") {
+		err = fmt.Errorf("unexpected greeting: %s", line)
+	}
+	if err != nil {
+		conn.Close()
+		t.conn = nil
+		return err
+	}
+
+	opts := map[string]interface{}{"verbose": false, "pedantic": false, "name": "livepeer", "lang": "go", "protocol": 1}
+	if t.user != nil {
+		if pass, ok := t.user.Password(); ok {
+			opts["user"], opts["pass"] = t.user.Username(), pass
+		} else {
+			opts["auth_token"] = t.user.Username()
+		}
+	}
+	connect, _ := json.Marshal(opts)
+	t.inbox = "_INBOX." + common.RandName()
+	_, err = fmt.Fprintf(conn, "CONNECT %s\r\nSUB %s.* 1\r\nPING\r\n", connect, t.inbox)
+	for err == nil {
+		var line string
+		if line, err = t.readLine(); err != nil {
+			break
+		}
+		if line == "PONG" {
+			return nil
+		}
+		if strings.HasPrefix(line, "-ERR") {
+			err = errors.New(line)
+		}
+	}
+	conn.Close()
+	t.conn = nil
+	return err
+}
+
+func (t *natsTransport) publishAcked(subject string, payload []byte) error {
+	t.n++
+	reply := t.inbox + "." + strconv.FormatUint(t.n, 10)
+	t.conn.SetDeadline(time.Now().Add(t.timeout))
+	if _, err := fmt.Fprintf(t.conn, "PUB %s %s %d\r\n%s\r\n", subject, reply, len(payload), payload); err != nil {
+		return err
+	}
+	for {
+		line, err := t.readLine()
+		if err != nil {
+			return err
+		}
+		switch {
+		case line == "PING":
+			if _, err := io.WriteString(t.conn, "PONG\r\n"); err != nil {
+				return err
+			}
+		case strings.HasPrefix(line, "-ERR"):
+			return errors.New(line)
+		case strings.HasPrefix(line, "MSG "):
+			// MSG <subject> <sid> [reply-to] <#bytes>
+			fields := strings.Fields(line)
+			if len(fields) < 4 {
+				return fmt.Errorf("invalid message: %s", line)
+			}
+			size, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil {
+				return fmt.Errorf("invalid message: %s", line)
+			}
+			body := make([]byte, size+2)
+			if _, err := io.ReadFull(t.r, body); err != nil {
+				return err
+			}
+			if fields[1] != reply {
+				// late acknowledgement of an earlier attempt
+				continue
+			}
+			var ack natsAck
+			if err := json.Unmarshal(body[:size], &ack); err != nil {
+				return fmt.Errorf("invalid acknowledgement: %s", body[:size])
+			}
+			if ack.Error != nil {
+				return errors.New(ack.Error.Description)
+			}
+			return nil
+		}
+	}
+}
+
+func (t *natsTransport) readLine() (string, error) {
+	line, err := t.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
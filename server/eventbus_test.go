@@ -0,0 +1,204 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	gonet "net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stubEventTransport struct {
+	mu       sync.Mutex
+	failures int
+	attempts int
+	subject  string
+	payload  []byte
+}
+
+func (t *stubEventTransport) publish(subject string, payload []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.attempts++
+	if t.attempts <= t.failures {
+		return errors.New("unavailable")
+	}
+	t.subject, t.payload = subject, payload
+	return nil
+}
+
+func TestEventPublisher(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	c := useFakeClock(t)
+
+	var nilPublisher *EventPublisher
+	nilPublisher.publish(eventStream, &streamEvent{})
+
+	tr := &stubEventTransport{failures: 2}
+	p := newEventPublisher(tr, "lp", 1)
+	p.publish(eventStream, &streamEvent{Event: "sourceWarning", ManifestID: "mani"})
+	// beyond the queue
+	p.publish(eventUsage, &usageRecord{})
+	assert.Equal(uint64(1), p.dropped)
+
+	// retried with backoff until acknowledged
+	ev := <-p.queue
+	done := make(chan struct{})
+	go func() { p.send(ev); close(done) }()
+	assert.True(c.waitTimers(1))
+	c.Advance(eventRetryMin)
+	assert.True(c.waitTimers(1))
+	c.Advance(eventRetryMin)
+	select {
+	case <-done:
+		t.Fatal("retried before the backoff")
+	case <-time.After(10 * time.Millisecond):
+	}
+	c.Advance(eventRetryMin)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("event not sent")
+	}
+	assert.Equal(3, tr.attempts)
+
+	assert.Equal("lp.stream", tr.subject)
+	var got struct {
+		busEvent
+		Data streamEvent `json:"data"`
+	}
+	require.Nil(json.Unmarshal(tr.payload, &got))
+	assert.NotEmpty(got.ID)
+	assert.Equal("stream", got.Kind)
+	assert.Equal("livepeer.stream.v1", got.Schema)
+	assert.Equal(int64(1600000000), got.Time)
+	assert.Equal("mani", got.Data.ManifestID)
+	assert.Equal("sourceWarning", got.Data.Event)
+
+	_, err := NewEventPublisher("kafka://broker:9092", "lp")
+	assert.EqualError(err, "unsupported event bus: kafka://broker:9092")
+	_, err = NewEventPublisher("nats://localhost:4222", "")
+	assert.EqualError(err, "missing event subject prefix")
+}
+
+// stubNATSServer serves the NATS text protocol, replying to each published
+// message with the acknowledgement returned by `ack`, or not at all if empty.
+// The CONNECT lines received are sent to `connects`.
+func stubNATSServer(t *testing.T, ack func(subject string, payload []byte) string, connects chan string) string {
+	l, err := gonet.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serveStubNATS(conn, ack, connects)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func serveStubNATS(conn gonet.Conn, ack func(subject string, payload []byte) string, connects chan string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	io.WriteString(conn, "INFO {\"server_id\":\"stub\"}\r\n")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch fields[0] {
+		case "CONNECT":
+			connects <- strings.TrimSpace(strings.TrimPrefix(line, "CONNECT"))
+		case "PING":
+			io.WriteString(conn, "PONG\r\n")
+		case "PUB":
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			body := make([]byte, size+2)
+			if _, err := io.ReadFull(r, body); err != nil {
+				return
+			}
+			// the server checks on the client in between
+			io.WriteString(conn, "PING\r\n")
+			if res := ack(fields[1], body[:size]); res != "" && len(fields) == 4 {
+				fmt.Fprintf(conn, "MSG %s 1 %d\r\n%s\r\n", fields[2], len(res), res)
+			}
+		}
+	}
+}
+
+func TestNATSTransport(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var mu sync.Mutex
+	var published []string
+	res := `{"stream":"events","seq":1}`
+	connects := make(chan string, 10)
+	addr := stubNATSServer(t, func(subject string, payload []byte) string {
+		mu.Lock()
+		defer mu.Unlock()
+		published = append(published, subject+" "+string(payload))
+		return res
+	}, connects)
+
+	tr := &natsTransport{addr: addr, user: url.UserPassword("user", "pass"), timeout: time.Second}
+	defer func() {
+		if tr.conn != nil {
+			tr.conn.Close()
+		}
+	}()
+	require.Nil(tr.publish("lp.stream", []byte("one")))
+	require.Nil(tr.publish("lp.usage", []byte("two")))
+	assert.Equal([]string{"lp.stream one", "lp.usage two"}, published)
+	var opts map[string]interface{}
+	require.Nil(json.Unmarshal([]byte(<-connects), &opts))
+	assert.Equal("user", opts["user"])
+	assert.Equal("pass", opts["pass"])
+	assert.Equal(false, opts["verbose"])
+
+	// errors from JetStream fail the publish and reset the connection
+	mu.Lock()
+	res = `{"error":{"code":503,"description":"no stream matches subject"}}`
+	mu.Unlock()
+	assert.EqualError(tr.publish("lp.stream", []byte("three")), "no stream matches subject")
+	assert.Nil(tr.conn)
+
+	// as do missing acknowledgements
+	mu.Lock()
+	res = ""
+	mu.Unlock()
+	tr.timeout = 50 * time.Millisecond
+	assert.NotNil(tr.publish("lp.stream", []byte("four")))
+	assert.Nil(tr.conn)
+
+	tr.timeout = time.Second
+	mu.Lock()
+	res = `{"stream":"events","seq":2}`
+	mu.Unlock()
+	require.Nil(tr.publish("lp.stream", []byte("five")))
+	assert.Len(connects, 2)
+
+	l, err := gonet.Listen("tcp", "127.0.0.1:0")
+	require.Nil(err)
+	closed := l.Addr().String()
+	l.Close()
+	assert.NotNil((&natsTransport{addr: closed, timeout: time.Second}).publish("lp.stream", []byte("six")))
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/livepeer/lpms/ffmpeg"
+)
+
+// GOPAlignment is how the keyframes of the renditions of a stream are lined
+// up with each other, so that players can switch between renditions without
+// waiting for a keyframe
+type GOPAlignment string
+
+const (
+	// GOPAlignmentOff leaves the GOPs of the renditions to their profiles
+	GOPAlignmentOff GOPAlignment = "off"
+	// GOPAlignmentSource keyframes renditions only where the segments of
+	// the source start, on its keyframes; profiles may not set GOPs
+	GOPAlignmentSource GOPAlignment = "source"
+	// GOPAlignmentForced has all renditions share the GOP set by their
+	// profiles; profiles that set none are given it
+	GOPAlignmentForced GOPAlignment = "forced"
+)
+
+// RenditionGOPAlignment is how the keyframes of renditions are aligned.
+// Unless off, the keyframes of the renditions of each transcoded segment are
+// also checked to be aligned.
+var RenditionGOPAlignment = GOPAlignmentOff
+
+// GOPAlignmentTolerance is how far apart the keyframes of two renditions may
+// be and still count as aligned, to allow for renditions of different frame
+// rates
+var GOPAlignmentTolerance = 50 * time.Millisecond
+
+// ParseGOPAlignment parses the GOP alignment `s`; empty is off
+func ParseGOPAlignment(s string) (GOPAlignment, error) {
+	switch a := GOPAlignment(s); a {
+	case "":
+		return GOPAlignmentOff, nil
+	case GOPAlignmentOff, GOPAlignmentSource, GOPAlignmentForced:
+		return a, nil
+	}
+	return "", fmt.Errorf("unknown gop alignment %q", s)
+}
+
+// alignProfileGOPs returns `profiles` with their GOPs aligned as required by
+// RenditionGOPAlignment, or an error naming the first profile that can't be
+func alignProfileGOPs(profiles []ffmpeg.VideoProfile) ([]ffmpeg.VideoProfile, error) {
+	switch RenditionGOPAlignment {
+	case GOPAlignmentSource:
+		for _, p := range profiles {
+			if p.GOP != 0 {
+				return nil, fmt.Errorf("gop set while aligned on the source profile=%s gop=%v", p.Name, p.GOP)
+			}
+		}
+	case GOPAlignmentForced:
+		var gop time.Duration
+		for _, p := range profiles {
+			if p.GOP == 0 {
+				continue
+			}
+			if gop != 0 && p.GOP != gop {
+				return nil, fmt.Errorf("gop differs from other profiles profile=%s gop=%v other=%v", p.Name, p.GOP, gop)
+			}
+			gop = p.GOP
+		}
+		if gop == 0 {
+			return profiles, nil
+		}
+		// copied, as profiles may be shared with other streams
+		aligned := append([]ffmpeg.VideoProfile(nil), profiles...)
+		for i := range aligned {
+			aligned[i].GOP = gop
+		}
+		return aligned, nil
+	}
+	return profiles, nil
+}
+
+// misalignedRendition compares the keyframes of the MPEG-TS renditions
+// `data` of a segment, in the order of `profiles`, and returns the name of
+// the first rendition whose keyframes aren't within GOPAlignmentTolerance of
+// those of the first rendition with keyframes; empty if all are aligned.
+// Renditions of other formats or without data are skipped.
+func misalignedRendition(profiles []ffmpeg.VideoProfile, data [][]byte) string {
+	tolerance := int64(GOPAlignmentTolerance * 90000 / time.Second)
+	var ref []int64
+	for i, d := range data {
+		if i >= len(profiles) || len(d) == 0 {
+			continue
+		}
+		if f := profiles[i].Format; f != ffmpeg.FormatNone && f != ffmpeg.FormatMPEGTS {
+			continue
+		}
+		keyframes := parseTSTimestamps(d).keyframes
+		if len(keyframes) == 0 {
+			continue
+		}
+		if ref == nil {
+			ref = keyframes
+			continue
+		}
+		if len(keyframes) != len(ref) {
+			return profiles[i].Name
+		}
+		for j := range keyframes {
+			if delta := tsDelta(keyframes[j], ref[j]); delta > tolerance || delta < -tolerance {
+				return profiles[i].Name
+			}
+		}
+	}
+	return ""
+}
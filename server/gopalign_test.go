@@ -0,0 +1,95 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/livepeer/lpms/ffmpeg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// gopTestRendition builds an MPEG-TS rendition of `n` video frames `step`
+// apart, with a keyframe every `gop` frames
+func gopTestRendition(step int64, n, gop int) []byte {
+	data := tsTestPSI()
+	for i := 0; i < n; i++ {
+		pkt := tsTestVideo(int64(i)*step, int64(i)*step)
+		if i%gop == 0 {
+			// adaptation field with the random access indicator set
+			pkt = append([]byte{pkt[0], pkt[1], pkt[2], 0x30, 1, 0x40}, pkt[4:tsPacketSize-2]...)
+		}
+		data = append(data, pkt...)
+	}
+	return data
+}
+
+func TestParseGOPAlignment(t *testing.T) {
+	assert := assert.New(t)
+	for s, want := range map[string]GOPAlignment{"": GOPAlignmentOff, "off": GOPAlignmentOff, "source": GOPAlignmentSource, "forced": GOPAlignmentForced} {
+		a, err := ParseGOPAlignment(s)
+		assert.Nil(err)
+		assert.Equal(want, a)
+	}
+	_, err := ParseGOPAlignment("always")
+	assert.EqualError(err, `unknown gop alignment "always"`)
+}
+
+func TestAlignProfileGOPs(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	defer func(a GOPAlignment) { RenditionGOPAlignment = a }(RenditionGOPAlignment)
+
+	p1, p2, p3 := ffmpeg.P144p30fps16x9, ffmpeg.P240p30fps16x9, ffmpeg.P360p30fps16x9
+	p1.GOP, p2.GOP = time.Second, time.Second
+	profiles := []ffmpeg.VideoProfile{p1, p2, p3}
+
+	// left alone when off
+	RenditionGOPAlignment = GOPAlignmentOff
+	aligned, err := alignProfileGOPs(profiles)
+	require.Nil(err)
+	assert.Equal(profiles, aligned)
+
+	// profiles take the shared gop, without changing those given
+	RenditionGOPAlignment = GOPAlignmentForced
+	aligned, err = alignProfileGOPs(profiles)
+	require.Nil(err)
+	for _, p := range aligned {
+		assert.Equal(time.Second, p.GOP)
+	}
+	assert.Zero(profiles[2].GOP)
+	p2.GOP = 2 * time.Second
+	_, err = alignProfileGOPs([]ffmpeg.VideoProfile{p1, p2})
+	assert.EqualError(err, "gop differs from other profiles profile=P240p30fps16x9 gop=2s other=1s")
+	aligned, err = alignProfileGOPs([]ffmpeg.VideoProfile{p3})
+	require.Nil(err)
+	assert.Zero(aligned[0].GOP)
+
+	// no gops when aligned on the source
+	RenditionGOPAlignment = GOPAlignmentSource
+	_, err = alignProfileGOPs(profiles)
+	assert.EqualError(err, "gop set while aligned on the source profile=P144p30fps16x9 gop=1s")
+	aligned, err = alignProfileGOPs([]ffmpeg.VideoProfile{p3})
+	require.Nil(err)
+	assert.Equal([]ffmpeg.VideoProfile{p3}, aligned)
+}
+
+func TestMisalignedRendition(t *testing.T) {
+	assert := assert.New(t)
+	p1, p2, p3 := ffmpeg.P144p30fps16x9, ffmpeg.P240p30fps16x9, ffmpeg.P360p30fps16x9
+	profiles := []ffmpeg.VideoProfile{p1, p2, p3}
+
+	// keyframes every second, at 30 and 60fps
+	assert.Equal([]int64{0, 90000}, parseTSTimestamps(gopTestRendition(3000, 60, 30)).keyframes)
+	assert.Equal("", misalignedRendition(profiles, [][]byte{gopTestRendition(3000, 60, 30), gopTestRendition(1500, 120, 60), gopTestRendition(3000, 60, 30)}))
+
+	// a rendition with keyframes every half second
+	assert.Equal("P360p30fps16x9", misalignedRendition(profiles, [][]byte{gopTestRendition(3000, 60, 30), gopTestRendition(3000, 60, 30), gopTestRendition(3000, 60, 15)}))
+	// or with the same number of keyframes elsewhere
+	assert.Equal("P240p30fps16x9", misalignedRendition(profiles, [][]byte{gopTestRendition(3000, 60, 30), gopTestRendition(3000, 60, 40), nil}))
+
+	// renditions without data or keyframes, or of other formats, are skipped
+	p1.Format = ffmpeg.FormatMP4
+	assert.Equal("", misalignedRendition([]ffmpeg.VideoProfile{p1, p2, p3}, [][]byte{gopTestRendition(3000, 60, 15), nil, gopTestRendition(3000, 60, 30)}))
+	assert.Equal("", misalignedRendition(profiles, [][]byte{tsTestSegment(tsTestFrames(0, 3000), nil), gopTestRendition(3000, 60, 30)}))
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/core"
+)
+
+// StreamHeartbeatURL, if set, receives a JSON POST with the stats of every
+// live stream each StreamHeartbeatInterval, so that control planes don't have
+// to poll /status of every broadcaster. The auth webhook may give streams
+// their own URL.
+var StreamHeartbeatURL string
+
+// StreamHeartbeatInterval is how often heartbeats of live streams are sent;
+// zero for never
+var StreamHeartbeatInterval = 30 * time.Second
+
+// streamHeartbeat is the stats of a live stream posted to its heartbeat URL
+type streamHeartbeat struct {
+	StreamStatus
+	Time   int64             `json:"time"`
+	Nonce  uint64            `json:"nonce"`
+	Labels map[string]string `json:"labels,omitempty"`
+	// Bits per second of the source since the previous heartbeat of the
+	// stream; zero in its first heartbeat
+	Bitrate uint64 `json:"bitrate"`
+}
+
+// heartbeatSample is what the bitrate of a stream is measured from
+type heartbeatSample struct {
+	time        time.Time
+	sourceBytes uint64
+}
+
+// heartbeatURL returns the URL the heartbeats of the stream of `params` are
+// posted to; empty for none
+func heartbeatURL(params *core.StreamParameters) string {
+	if params != nil && params.HeartbeatURL != "" {
+		return params.HeartbeatURL
+	}
+	return StreamHeartbeatURL
+}
+
+// validateHeartbeatURL checks that heartbeats can be posted to `u`
+func validateHeartbeatURL(u string) error {
+	p, err := url.ParseRequestURI(u)
+	if err != nil {
+		return err
+	}
+	if p.Scheme != "http" && p.Scheme != "https" {
+		return fmt.Errorf("heartbeat URL not HTTP or HTTPS scheme=%s", p.Scheme)
+	}
+	return nil
+}
+
+// sendStreamHeartbeats posts the heartbeats of the live streams every
+// StreamHeartbeatInterval until `ctx` is done
+func (s *LivepeerServer) sendStreamHeartbeats(ctx context.Context) {
+	prev := make(map[*rtmpConnection]heartbeatSample)
+	timer := clock.NewTimer(StreamHeartbeatInterval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C():
+		}
+		s.streamHeartbeats(prev)
+		timer.Reset(StreamHeartbeatInterval)
+	}
+}
+
+// streamHeartbeats posts a heartbeat of each live stream in the background,
+// measuring their bitrate from the samples `prev` of the previous heartbeats,
+// which are replaced
+func (s *LivepeerServer) streamHeartbeats(prev map[*rtmpConnection]heartbeatSample) {
+	now := clock.Now()
+	external := s.externalManifestIDs()
+	seen := make(map[*rtmpConnection]bool)
+	s.rtmpConnections.forEach(func(mid core.ManifestID, cxn *rtmpConnection) bool {
+		url := heartbeatURL(cxn.params)
+		if url == "" {
+			return true
+		}
+		seen[cxn] = true
+		hb := &streamHeartbeat{
+			StreamStatus: s.streamStatus(mid, cxn, external),
+			Time:         now.Unix(),
+			Nonce:        cxn.nonce,
+		}
+		if cxn.params != nil {
+			hb.Labels = cxn.params.Labels.Get()
+		}
+		sample := heartbeatSample{time: now, sourceBytes: atomic.LoadUint64(&cxn.sourceBytes)}
+		if p, ok := prev[cxn]; ok && now.After(p.time) && sample.sourceBytes >= p.sourceBytes {
+			hb.Bitrate = uint64(float64((sample.sourceBytes-p.sourceBytes)*8) / now.Sub(p.time).Seconds())
+		}
+		prev[cxn] = sample
+		go func() {
+			if err := postWebhookJSON(url, hb); err != nil {
+				glog.Errorf("Error sending stream heartbeat manifestID=%s err=%v", mid, err)
+			}
+		}()
+		return true
+	})
+	for cxn := range prev {
+		if !seen[cxn] {
+			delete(prev, cxn)
+		}
+	}
+}
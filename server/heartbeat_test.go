@@ -0,0 +1,125 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/lpms/stream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamHeartbeats(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	c := useFakeClock(t)
+	s := setupServer()
+	defer serverCleanup(s)
+
+	heartbeats := make(chan streamHeartbeat, 10)
+	receiver := func(path string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			var hb streamHeartbeat
+			assert.Nil(json.Unmarshal(body, &hb))
+			assert.Equal(path, r.URL.Path)
+			heartbeats <- hb
+		}))
+	}
+	node, own := receiver("/node"), receiver("/own")
+	defer node.Close()
+	defer own.Close()
+	next := func() streamHeartbeat {
+		select {
+		case hb := <-heartbeats:
+			return hb
+		case <-time.After(time.Second):
+			require.Fail("no heartbeat")
+		}
+		return streamHeartbeat{}
+	}
+
+	a, err := s.registerConnection(stream.NewBasicRTMPVideoStream(&core.StreamParameters{ManifestID: "hba", Labels: core.NewStreamLabels(map[string]string{"k": "v"})}))
+	require.Nil(err)
+	defer removeRTMPStream(s, "hba")
+	b, err := s.registerConnection(stream.NewBasicRTMPVideoStream(&core.StreamParameters{ManifestID: "hbb", HeartbeatURL: own.URL + "/own"}))
+	require.Nil(err)
+	defer removeRTMPStream(s, "hbb")
+
+	// streams without a heartbeat URL have no heartbeats
+	prev := make(map[*rtmpConnection]heartbeatSample)
+	s.streamHeartbeats(prev)
+	hb := next()
+	assert.Equal("hbb", hb.ManifestID)
+	assert.Equal(b.nonce, hb.Nonce)
+	assert.Zero(hb.Bitrate)
+	assert.Len(prev, 1)
+
+	defer func(url string) { StreamHeartbeatURL = url }(StreamHeartbeatURL)
+	StreamHeartbeatURL = node.URL + "/node"
+	c.Advance(10 * time.Second)
+	atomic.AddUint64(&a.sourceBytes, 1000)
+	atomic.AddUint64(&b.sourceBytes, 5000)
+	atomic.AddUint64(&b.segments, 2)
+	atomic.AddUint64(&b.failedSegments, 1)
+	s.streamHeartbeats(prev)
+	got := map[string]streamHeartbeat{}
+	for i := 0; i < 2; i++ {
+		hb := next()
+		got[hb.ManifestID] = hb
+	}
+	assert.Equal(c.Now().Unix(), got["hba"].Time)
+	assert.Equal(map[string]string{"k": "v"}, got["hba"].Labels)
+	assert.Zero(got["hba"].Bitrate)
+	assert.Equal(uint64(1000), got["hba"].SourceBytes)
+	// bits per second over the 10s since the previous heartbeat
+	assert.Equal(uint64(4000), got["hbb"].Bitrate)
+	assert.Equal(uint64(2), got["hbb"].Segments)
+	assert.Equal(uint64(1), got["hbb"].FailedSegments)
+
+	// ended streams are forgotten
+	require.Nil(removeRTMPStream(s, "hba"))
+	s.streamHeartbeats(prev)
+	assert.Equal("hbb", next().ManifestID)
+	assert.Len(prev, 1)
+	assert.Contains(prev, b)
+}
+
+func TestStreamHeartbeatURL(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	s := setupServer()
+	defer serverCleanup(s)
+
+	assert.Nil(validateHeartbeatURL("https://control.test/heartbeats"))
+	assert.NotNil(validateHeartbeatURL("control.test/heartbeats"))
+	assert.NotNil(validateHeartbeatURL("ftp://control.test/heartbeats"))
+
+	var resp string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(resp))
+	}))
+	defer ts.Close()
+	defer func(url string) { AuthWebhookURL = url }(AuthWebhookURL)
+	AuthWebhookURL = ts.URL
+	u, _ := url.Parse("rtmp://localhost/live/hb")
+	resp = `{"manifestID":"hb", "heartbeatUrl":"https://control.test/heartbeats"}`
+	params, ok := createRTMPStreamIDHandler(s)(u).(*core.StreamParameters)
+	require.True(ok)
+	assert.Equal("https://control.test/heartbeats", params.HeartbeatURL)
+	assert.Equal("https://control.test/heartbeats", heartbeatURL(params))
+	resp = `{"manifestID":"hb", "heartbeatUrl":"control.test"}`
+	assert.Nil(createRTMPStreamIDHandler(s)(u))
+
+	defer func(url string) { StreamHeartbeatURL = url }(StreamHeartbeatURL)
+	StreamHeartbeatURL = "https://node.test/heartbeats"
+	assert.Equal("https://node.test/heartbeats", heartbeatURL(&core.StreamParameters{}))
+	assert.Equal("https://node.test/heartbeats", heartbeatURL(nil))
+}
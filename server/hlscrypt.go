@@ -0,0 +1,242 @@
+package server
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/m3u8"
+)
+
+// HLSKeyRotation, if non-zero, encrypts the live HLS output served from
+// /stream/ with AES-128, using a new key every HLSKeyRotation segments. Keys
+// are served from /hlskeys/, which is subject to the same middleware and
+// address filters as the playlists.
+var HLSKeyRotation uint64
+
+// HLSKeyServer, if set, supplies the keys of encrypted streams instead of
+// keys derived on this node, eg from a DRM key server
+var HLSKeyServer HLSKeySource
+
+const hlsKeyPrefix = "/hlskeys/"
+
+// number of keys kept per stream, enough for a live playlist window
+const hlsKeysCached = 4
+
+// HLSKey is an AES-128 content key and how it is announced in playlists
+type HLSKey struct {
+	Key []byte
+	// URI, KeyFormat and KeyFormatVersions of the EXT-X-KEY tag. An empty
+	// URI points players to the key on /hlskeys/.
+	URI               string
+	KeyFormat         string
+	KeyFormatVersions string
+}
+
+// HLSKeySource supplies the key for the `idx`th key period of a stream. It
+// must return the same key each time it is asked for the same period.
+type HLSKeySource interface {
+	HLSKey(mid core.ManifestID, idx uint64) (*HLSKey, error)
+}
+
+// localKeySource derives keys from a secret that never leaves this node
+type localKeySource struct {
+	secret []byte
+}
+
+func (l *localKeySource) HLSKey(mid core.ManifestID, idx uint64) (*HLSKey, error) {
+	h := hmac.New(sha256.New, l.secret)
+	binary.Write(h, binary.BigEndian, idx)
+	return &HLSKey{Key: h.Sum(nil)[:aes.BlockSize]}, nil
+}
+
+// hlsKeyring holds the keys of a stream. A nil *hlsKeyring leaves the stream
+// unencrypted.
+type hlsKeyring struct {
+	// highest key index published in a playlist, plus one. Kept first for
+	// 64-bit alignment of atomic accesses.
+	published uint64
+	mid       core.ManifestID
+	source    HLSKeySource
+	rotation  uint64
+
+	mu   sync.Mutex
+	keys map[uint64]*HLSKey
+}
+
+func newHLSKeyring(mid core.ManifestID, rotation uint64, source HLSKeySource) *hlsKeyring {
+	if rotation == 0 {
+		return nil
+	}
+	if source == nil {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			glog.Errorf("Could not generate HLS key secret manifestID=%s err=%v", mid, err)
+			return nil
+		}
+		source = &localKeySource{secret: secret}
+	}
+	return &hlsKeyring{mid: mid, source: source, rotation: rotation, keys: make(map[uint64]*HLSKey)}
+}
+
+// key returns the key of the `idx`th key period, asking the source only once
+func (k *hlsKeyring) key(idx uint64) (*HLSKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if key, ok := k.keys[idx]; ok {
+		return key, nil
+	}
+	key, err := k.source.HLSKey(k.mid, idx)
+	if err != nil {
+		return nil, err
+	}
+	if len(key.Key) != aes.BlockSize {
+		return nil, fmt.Errorf("invalid key length=%d manifestID=%s", len(key.Key), k.mid)
+	}
+	for i := range k.keys {
+		if i+hlsKeysCached <= idx {
+			delete(k.keys, i)
+		}
+	}
+	k.keys[idx] = key
+	return key, nil
+}
+
+func (k *hlsKeyring) keyURI(idx uint64) string {
+	return fmt.Sprintf("%s%s/%d.key", hlsKeyPrefix, k.mid, idx)
+}
+
+// iv is the sequence number of the segment, as in the HLS default
+func hlsIV(seq uint64) []byte {
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], seq)
+	return iv
+}
+
+// segmentSeq reads the sequence number from a segment name such as
+// <rendition>/<seq>.ts
+func segmentSeq(name string) (uint64, bool) {
+	base := path.Base(name)
+	seq, err := strconv.ParseUint(strings.TrimSuffix(base, path.Ext(base)), 10, 64)
+	return seq, err == nil
+}
+
+// encrypt returns the segment `seq` encrypted with AES-128-CBC
+func (k *hlsKeyring) encrypt(seq uint64, data []byte) ([]byte, error) {
+	if k == nil {
+		return data, nil
+	}
+	key, err := k.key(seq / k.rotation)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key.Key)
+	if err != nil {
+		return nil, err
+	}
+	// PKCS#7 padding, as required by HLS
+	pad := aes.BlockSize - len(data)%aes.BlockSize
+	out := make([]byte, len(data)+pad)
+	copy(out, data)
+	copy(out[len(data):], bytes.Repeat([]byte{byte(pad)}, pad))
+	cipher.NewCBCEncrypter(block, hlsIV(seq)).CryptBlocks(out, out)
+	return out, nil
+}
+
+// encryptPlaylist returns a copy of `pl` with each segment's key. Segments
+// whose sequence number can not be read from their URI, and CMAF segments,
+// are left in the clear, as they are by the segment handler.
+func (k *hlsKeyring) encryptPlaylist(pl *m3u8.MediaPlaylist) (*m3u8.MediaPlaylist, error) {
+	if k == nil {
+		return pl, nil
+	}
+	decoded, _, err := m3u8.DecodeFrom(bytes.NewReader(pl.Encode().Bytes()), true)
+	if err != nil {
+		return nil, err
+	}
+	cpy, ok := decoded.(*m3u8.MediaPlaylist)
+	if !ok {
+		return nil, fmt.Errorf("unexpected playlist type")
+	}
+	// custom tags are not decoded, and the map of the first segment is
+	// decoded as the map of every segment
+	cpy.Custom = pl.Custom
+	cpy.Map = pl.Map
+	for _, seg := range cpy.Segments {
+		if seg == nil {
+			continue
+		}
+		seq, ok := segmentSeq(seg.URI)
+		// CMAF segments would need their initialization segments encrypted
+		// with the same key, so are left in the clear too
+		if !ok || seg.Map != nil {
+			seg.Key = &m3u8.Key{Method: "NONE"}
+			continue
+		}
+		idx := seq / k.rotation
+		key, err := k.key(idx)
+		if err != nil {
+			return nil, err
+		}
+		seg.Key = &m3u8.Key{
+			Method:            "AES-128",
+			URI:               key.URI,
+			IV:                fmt.Sprintf("0x%x", hlsIV(seq)),
+			Keyformat:         key.KeyFormat,
+			Keyformatversions: key.KeyFormatVersions,
+		}
+		if seg.Key.URI == "" {
+			seg.Key.URI = k.keyURI(idx)
+		}
+		for {
+			published := atomic.LoadUint64(&k.published)
+			if idx < published || atomic.CompareAndSwapUint64(&k.published, published, idx+1) {
+				break
+			}
+		}
+	}
+	return cpy, nil
+}
+
+// HandleHLSKey serves the keys of encrypted live streams. Only keys that have
+// been published in a playlist are served.
+func (s *LivepeerServer) HandleHLSKey(w http.ResponseWriter, r *http.Request) {
+	p := strings.TrimPrefix(r.URL.Path, hlsKeyPrefix)
+	parts := strings.Split(p, "/")
+	if len(parts) != 2 || path.Ext(parts[1]) != ".key" {
+		http.Error(w, "Bad key URL", http.StatusBadRequest)
+		return
+	}
+	idx, err := strconv.ParseUint(strings.TrimSuffix(parts[1], ".key"), 10, 64)
+	if err != nil {
+		http.Error(w, "Bad key URL", http.StatusBadRequest)
+		return
+	}
+	cxn, ok := s.rtmpConnections.get(core.ManifestID(parts[0]))
+	if !ok || cxn.hlsKeys == nil || idx >= atomic.LoadUint64(&cxn.hlsKeys.published) {
+		http.Error(w, "Key not found", http.StatusNotFound)
+		return
+	}
+	key, err := cxn.hlsKeys.key(idx)
+	if err != nil {
+		glog.Errorf("Error getting HLS key manifestID=%s idx=%d err=%v", cxn.mid, idx, err)
+		http.Error(w, "Error getting key", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	w.Write(key.Key)
+}
@@ -0,0 +1,172 @@
+package server
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/m3u8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decryptSegment(t *testing.T, key, iv, data []byte) []byte {
+	block, err := aes.NewCipher(key)
+	require.Nil(t, err)
+	require.Equal(t, 0, len(data)%aes.BlockSize)
+	out := make([]byte, len(data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(out, data)
+	pad := int(out[len(out)-1])
+	require.True(t, pad > 0 && pad <= aes.BlockSize)
+	return out[:len(out)-pad]
+}
+
+func TestHLSKeyring_Encrypt(t *testing.T) {
+	assert := assert.New(t)
+
+	var nilKeys *hlsKeyring
+	assert.Nil(newHLSKeyring("mani", 0, nil))
+	data, err := nilKeys.encrypt(1, []byte("clear"))
+	assert.Nil(err)
+	assert.Equal("clear", string(data))
+
+	k := newHLSKeyring("mani", 3, nil)
+	require.NotNil(t, k)
+	key := func(k *hlsKeyring, idx uint64) []byte {
+		key, err := k.key(idx)
+		require.Nil(t, err)
+		return key.Key
+	}
+	assert.Equal(key(k, 0), key(k, 0))
+	assert.NotEqual(key(k, 0), key(k, 1))
+	// keys differ between streams
+	assert.NotEqual(key(k, 0), key(newHLSKeyring("mani", 3, nil), 0))
+
+	for _, size := range []int{0, 1, 15, 16, 17, 188 * 7} {
+		seg := []byte(strings.Repeat("x", size))
+		for _, seq := range []uint64{0, 2, 3, 7} {
+			enc, err := k.encrypt(seq, seg)
+			assert.Nil(err)
+			assert.Equal(seg, decryptSegment(t, key(k, seq/3), hlsIV(seq), enc))
+		}
+	}
+}
+
+type stubKeySource struct {
+	calls int
+	key   *HLSKey
+	err   error
+}
+
+func (s *stubKeySource) HLSKey(mid core.ManifestID, idx uint64) (*HLSKey, error) {
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return &HLSKey{Key: append([]byte(nil), s.key.Key...), URI: s.key.URI, KeyFormat: s.key.KeyFormat}, nil
+}
+
+func TestHLSKeyring_Source(t *testing.T) {
+	assert := assert.New(t)
+
+	src := &stubKeySource{key: &HLSKey{Key: []byte("0123456789abcdef")}}
+	k := newHLSKeyring("mani", 1, src)
+
+	// keys are fetched once while cached
+	for i := 0; i < 3; i++ {
+		_, err := k.key(0)
+		assert.Nil(err)
+	}
+	assert.Equal(1, src.calls)
+	for idx := uint64(1); idx <= hlsKeysCached; idx++ {
+		_, err := k.key(idx)
+		assert.Nil(err)
+	}
+	assert.Len(k.keys, hlsKeysCached)
+	// the oldest key was evicted
+	_, err := k.key(0)
+	assert.Nil(err)
+	assert.Equal(hlsKeysCached+2, src.calls)
+
+	src.key.Key = []byte("short")
+	_, err = k.key(100)
+	assert.NotNil(err)
+
+	src.err = errors.New("key server down")
+	_, err = k.encrypt(200, []byte("data"))
+	assert.Equal(src.err, err)
+}
+
+func TestHLSKeyring_Playlist(t *testing.T) {
+	assert := assert.New(t)
+
+	pl, err := m3u8.NewMediaPlaylist(5, 5)
+	require.Nil(t, err)
+	for seq := uint64(2); seq < 6; seq++ {
+		require.Nil(t, pl.Append(fmt.Sprintf("P240p30fps16x9/%d.ts", seq), 2, ""))
+	}
+	var nilKeys *hlsKeyring
+	same, err := nilKeys.encryptPlaylist(pl)
+	assert.Nil(err)
+	assert.Equal(pl, same)
+
+	k := newHLSKeyring("mani", 3, nil)
+	enc, err := k.encryptPlaylist(pl)
+	require.Nil(t, err)
+	out := enc.Encode().String()
+	assert.Contains(out, `#EXT-X-KEY:METHOD=AES-128,URI="/hlskeys/mani/0.key",IV=0x00000000000000000000000000000002`)
+	assert.Contains(out, `#EXT-X-KEY:METHOD=AES-128,URI="/hlskeys/mani/1.key",IV=0x00000000000000000000000000000005`)
+	// the stream's own playlist is left alone
+	assert.NotContains(pl.Encode().String(), "EXT-X-KEY")
+	assert.Equal(uint64(2), k.published)
+
+	// keys from a key server are announced as given
+	src := &stubKeySource{key: &HLSKey{Key: []byte("0123456789abcdef"), URI: "skd://key", KeyFormat: "com.apple.streamingkeydelivery"}}
+	enc, err = newHLSKeyring("mani", 3, src).encryptPlaylist(pl)
+	require.Nil(t, err)
+	out = enc.Encode().String()
+	assert.Contains(out, `URI="skd://key"`)
+	assert.Contains(out, `KEYFORMAT="com.apple.streamingkeydelivery"`)
+	assert.NotContains(out, "/hlskeys/")
+
+	src.err = errors.New("key server down")
+	_, err = newHLSKeyring("mani", 3, src).encryptPlaylist(pl)
+	assert.Equal(src.err, err)
+}
+
+func TestHLSKeyring_HandleKey(t *testing.T) {
+	assert := assert.New(t)
+	s := setupServer()
+	defer serverCleanup(s)
+
+	k := newHLSKeyring("mani", 3, nil)
+	s.rtmpConnections.store("mani", &rtmpConnection{mid: "mani", hlsKeys: k})
+	s.rtmpConnections.store("clear", &rtmpConnection{mid: "clear"})
+
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.HandleHLSKey(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	// keys are only served once published in a playlist
+	assert.Equal(http.StatusNotFound, serve("/hlskeys/mani/0.key").Code)
+	k.published = 2
+	w := serve("/hlskeys/mani/1.key")
+	assert.Equal(http.StatusOK, w.Code)
+	key, err := k.key(1)
+	require.Nil(t, err)
+	assert.Equal(key.Key, w.Body.Bytes())
+	assert.Equal(http.StatusNotFound, serve("/hlskeys/mani/2.key").Code)
+
+	assert.Equal(http.StatusNotFound, serve("/hlskeys/clear/0.key").Code)
+	assert.Equal(http.StatusNotFound, serve("/hlskeys/unknown/0.key").Code)
+	assert.Equal(http.StatusBadRequest, serve("/hlskeys/mani/x.key").Code)
+	assert.Equal(http.StatusBadRequest, serve("/hlskeys/mani/0").Code)
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"crypto/tls"
+	"net/http"
+	"time"
+)
+
+// HTTPServerConfig bounds the resources a client can hold on the broadcaster's
+// HTTP server, so that slow or misbehaving clients can not exhaust the node
+type HTTPServerConfig struct {
+	// ReadHeaderTimeout bounds the time to read the request headers
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout bounds the time to read the whole request, including the
+	// body; 0 for no limit
+	ReadTimeout time.Duration
+	// WriteTimeout bounds the time from the end of the request headers to
+	// the end of the response; 0 for no limit
+	WriteTimeout time.Duration
+	// IdleTimeout is how long a keep-alive connection waits for the next request
+	IdleTimeout time.Duration
+	// MaxHeaderBytes bounds the size of the request headers
+	MaxHeaderBytes int
+	// MaxBodyBytes bounds the size of request bodies; 0 for no limit
+	MaxBodyBytes int64
+	// MaxConcurrentRequests is the number of requests served at once. Requests
+	// beyond that are rejected with a 503; 0 for no limit.
+	MaxConcurrentRequests int
+	// CertFile and KeyFile, if set, make the server serve HTTPS
+	CertFile string
+	KeyFile  string
+}
+
+// DefaultHTTPServerConfig leaves room for long transcodes and large segments
+// while cutting off clients that never finish sending their headers
+var DefaultHTTPServerConfig = HTTPServerConfig{
+	ReadHeaderTimeout: 10 * time.Second,
+	IdleTimeout:       2 * time.Minute,
+	MaxHeaderBytes:    http.DefaultMaxHeaderBytes,
+	MaxBodyBytes:      64 << 20,
+}
+
+// MediaServerConfig is used for the HTTP server started by StartMediaServer
+var MediaServerConfig = DefaultHTTPServerConfig
+
+func newHTTPServer(addr string, h http.Handler, cfg HTTPServerConfig) *http.Server {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           limitRequests(h, cfg),
+		ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+		ReadTimeout:       cfg.ReadTimeout,
+		WriteTimeout:      cfg.WriteTimeout,
+		IdleTimeout:       cfg.IdleTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+	}
+	if cfg.CertFile != "" {
+		srv.TLSConfig = &tls.Config{}
+		TLSPolicy.configureTLS(srv.TLSConfig)
+		srv.Handler = TLSPolicy.hsts(srv.Handler)
+	}
+	return srv
+}
+
+// listenAndServe serves HTTPS if `cfg` has a certificate and HTTP otherwise
+func listenAndServe(srv *http.Server, cfg HTTPServerConfig) error {
+	if cfg.CertFile != "" {
+		return srv.ListenAndServeTLS(cfg.CertFile, cfg.KeyFile)
+	}
+	return srv.ListenAndServe()
+}
+
+// limitRequests applies the body size and concurrency limits of `cfg` to `h`
+func limitRequests(h http.Handler, cfg HTTPServerConfig) http.Handler {
+	var sem chan struct{}
+	if cfg.MaxConcurrentRequests > 0 {
+		sem = make(chan struct{}, cfg.MaxConcurrentRequests)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if sem != nil {
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			default:
+				http.Error(w, "Too many concurrent requests", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		if cfg.MaxBodyBytes > 0 && r.Body != nil {
+			if r.ContentLength > cfg.MaxBodyBytes {
+				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, cfg.MaxBodyBytes)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
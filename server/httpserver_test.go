@@ -0,0 +1,91 @@
+package server
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimitRequests_BodySize(t *testing.T) {
+	assert := assert.New(t)
+
+	var readErr error
+	h := limitRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, readErr = ioutil.ReadAll(r.Body)
+	}), HTTPServerConfig{MaxBodyBytes: 4})
+
+	serve := func(body string, length int64) int {
+		readErr = nil
+		req := httptest.NewRequest("POST", "/live/a/1.ts", strings.NewReader(body))
+		req.ContentLength = length
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(http.StatusOK, serve("abcd", 4))
+	assert.Nil(readErr)
+
+	// rejected up front when the length is known
+	assert.Equal(http.StatusRequestEntityTooLarge, serve("abcde", 5))
+
+	// otherwise reading stops at the limit
+	serve("abcde", -1)
+	assert.NotNil(readErr)
+}
+
+func TestLimitRequests_Concurrency(t *testing.T) {
+	assert := assert.New(t)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h := limitRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}), HTTPServerConfig{MaxConcurrentRequests: 1})
+
+	done := make(chan int)
+	go func() {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/stream/a.m3u8", nil))
+		done <- w.Code
+	}()
+	<-started
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/stream/a.m3u8", nil))
+	assert.Equal(http.StatusServiceUnavailable, w.Code)
+
+	close(release)
+	assert.Equal(http.StatusOK, <-done)
+
+	// the slot is given back once the request completes
+	go func() { <-started }()
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/stream/a.m3u8", nil))
+	assert.Equal(http.StatusOK, w.Code)
+}
+
+func TestNewHTTPServer(t *testing.T) {
+	assert := assert.New(t)
+	cfg := HTTPServerConfig{
+		ReadHeaderTimeout: time.Second,
+		ReadTimeout:       2 * time.Second,
+		WriteTimeout:      3 * time.Second,
+		IdleTimeout:       4 * time.Second,
+		MaxHeaderBytes:    5,
+	}
+	srv := newHTTPServer("127.0.0.1:0", http.NotFoundHandler(), cfg)
+	assert.Equal("127.0.0.1:0", srv.Addr)
+	assert.Equal(time.Second, srv.ReadHeaderTimeout)
+	assert.Equal(2*time.Second, srv.ReadTimeout)
+	assert.Equal(3*time.Second, srv.WriteTimeout)
+	assert.Equal(4*time.Second, srv.IdleTimeout)
+	assert.Equal(5, srv.MaxHeaderBytes)
+	assert.NotNil(srv.Handler)
+}
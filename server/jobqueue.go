@@ -0,0 +1,444 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/core"
+)
+
+// States of queued jobs
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobCompleted = "completed"
+	jobFailed    = "failed"
+	jobCancelled = "cancelled"
+)
+
+// JobWorkers is how many queued jobs, e.g. VOD transcodes, the node runs at
+// once
+var JobWorkers = 2
+
+// JobMaxAttempts is how many times a queued job is run before it fails, as
+// long as it fails with retryable errors
+var JobMaxAttempts = 3
+
+// jobRetryBackoff is how long a failed job waits, per attempt made, before it
+// is run again
+var jobRetryBackoff = 30 * time.Second
+
+// jobRetention is how long finished jobs are kept, and jobPruneInterval how
+// often those past it are removed
+var (
+	jobRetention     = 7 * 24 * time.Hour
+	jobPruneInterval = time.Hour
+)
+
+var (
+	errJobNotFound = errors.New("job not found")
+	errJobFinished = errors.New("job already finished")
+	errJobExists   = errors.New("job already queued")
+)
+
+// jobInfo is a queued job, as listed by the admin API
+type jobInfo struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+	// Key of the job among those of its kind; only one unfinished job of a
+	// kind has a given key
+	Key       string            `json:"key,omitempty"`
+	State     string            `json:"state"`
+	Attempts  int               `json:"attempts"`
+	Progress  float64           `json:"progress"`
+	Payload   json.RawMessage   `json:"payload"`
+	Result    json.RawMessage   `json:"result,omitempty"`
+	Error     *streamEventError `json:"error,omitempty"`
+	CreatedAt time.Time         `json:"createdAt"`
+	UpdatedAt time.Time         `json:"updatedAt"`
+}
+
+func (j *jobInfo) finished() bool {
+	return j.State == jobCompleted || j.State == jobFailed || j.State == jobCancelled
+}
+
+// jobKind runs the jobs of a kind
+type jobKind struct {
+	// run makes an attempt at a job, which is cancelled with `ctx`. The job is
+	// run again later if the attempt fails with a retryable error.
+	run func(ctx context.Context, job *runningJob) error
+	// finish, if set, is called once a job is finished, whichever way
+	finish func(job jobInfo)
+}
+
+// runningJob is the job an attempt is made at
+type runningJob struct {
+	q    *jobQueue
+	info jobInfo
+}
+
+// decode decodes the payload of the job into `v`
+func (r *runningJob) decode(v interface{}) error {
+	return json.Unmarshal(r.info.Payload, v)
+}
+
+// progress reports the progress of the attempt, from 0 to 1
+func (r *runningJob) progress(p float64) {
+	r.q.update(r.info.ID, func(j *jobInfo) { j.Progress = p })
+}
+
+// setResult sets the result of the job, which is kept once it completes
+func (r *runningJob) setResult(v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	r.q.update(r.info.ID, func(j *jobInfo) { j.Result = b })
+	return nil
+}
+
+type queuedJob struct {
+	info jobInfo
+	// time before which the job is not run again after a failed attempt
+	notBefore time.Time
+	// cancels the running attempt
+	cancel    context.CancelFunc
+	cancelled bool
+}
+
+// jobQueue runs long running tasks of the node, e.g. VOD transcodes, in the
+// background, retrying them if they fail. Jobs are stored in the node DB, if
+// there is one, and resumed after a restart.
+type jobQueue struct {
+	db      *common.DB
+	kinds   map[string]*jobKind
+	wake    chan struct{}
+	mu      sync.Mutex
+	jobs    map[string]*queuedJob
+	running int
+}
+
+func newJobQueue(db *common.DB) *jobQueue {
+	return &jobQueue{
+		db:    db,
+		kinds: make(map[string]*jobKind),
+		wake:  make(chan struct{}, 1),
+		jobs:  make(map[string]*queuedJob),
+	}
+}
+
+// register registers the runner of the jobs of `kind`. Kinds are registered
+// before the queue is started.
+func (q *jobQueue) register(kind string, k *jobKind) {
+	q.kinds[kind] = k
+}
+
+// start loads the stored jobs and runs the queued ones until `ctx` is done.
+// Jobs interrupted then are queued again.
+func (q *jobQueue) start(ctx context.Context) {
+	if err := q.load(); err != nil {
+		glog.Errorf("Unable to load queued jobs err=%v", err)
+	}
+	timer := clock.NewTimer(jobPruneInterval)
+	defer timer.Stop()
+	for {
+		q.dispatch(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-timer.C():
+			q.prune()
+			timer.Reset(jobPruneInterval)
+		}
+	}
+}
+
+func (q *jobQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *jobQueue) load() error {
+	if q.db == nil {
+		return nil
+	}
+	stored, err := q.db.SelectJobs()
+	if err != nil {
+		return err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, dbj := range stored {
+		if _, ok := q.jobs[dbj.ID]; ok {
+			continue
+		}
+		j := &queuedJob{info: jobInfo{
+			ID:        dbj.ID,
+			Kind:      dbj.Kind,
+			Key:       dbj.Key,
+			State:     dbj.State,
+			Attempts:  dbj.Attempts,
+			Progress:  dbj.Progress,
+			Payload:   dbj.Payload,
+			Result:    dbj.Result,
+			CreatedAt: dbj.CreatedAt,
+			UpdatedAt: dbj.UpdatedAt,
+		}}
+		if len(dbj.Error) > 0 {
+			j.info.Error = &streamEventError{}
+			if err := json.Unmarshal(dbj.Error, j.info.Error); err != nil {
+				glog.Errorf("Invalid error of stored job id=%s err=%v", dbj.ID, err)
+			}
+		}
+		if j.info.State == jobRunning {
+			// interrupted by a restart
+			j.info.State = jobQueued
+			glog.Infof("Resuming job id=%s kind=%s", dbj.ID, dbj.Kind)
+		}
+		q.jobs[dbj.ID] = j
+	}
+	return nil
+}
+
+// save stores `j` in the DB. Must be called with the lock held.
+func (q *jobQueue) save(j *queuedJob) error {
+	if q.db == nil {
+		return nil
+	}
+	dbj := &common.DBJob{
+		ID:        j.info.ID,
+		Kind:      j.info.Kind,
+		Key:       j.info.Key,
+		Payload:   j.info.Payload,
+		Result:    j.info.Result,
+		State:     j.info.State,
+		Attempts:  j.info.Attempts,
+		Progress:  j.info.Progress,
+		CreatedAt: j.info.CreatedAt,
+		UpdatedAt: j.info.UpdatedAt,
+	}
+	if j.info.Error != nil {
+		b, err := json.Marshal(j.info.Error)
+		if err != nil {
+			return err
+		}
+		dbj.Error = b
+	}
+	if err := q.db.UpdateJob(dbj); err != nil {
+		glog.Errorf("Unable to store job id=%s err=%v", j.info.ID, err)
+		return err
+	}
+	return nil
+}
+
+// submit queues a job of `kind` with `payload` as its arguments. Fails with
+// errJobExists if `key` is set and an unfinished job of the kind has it.
+func (q *jobQueue) submit(kind, key string, payload interface{}) (jobInfo, error) {
+	if q.kinds[kind] == nil {
+		return jobInfo{}, errors.New("unknown job kind=" + kind)
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return jobInfo{}, err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if key != "" {
+		for _, j := range q.jobs {
+			if j.info.Kind == kind && j.info.Key == key && !j.info.finished() {
+				return jobInfo{}, errJobExists
+			}
+		}
+	}
+	now := clock.Now()
+	j := &queuedJob{info: jobInfo{
+		ID:        string(core.RandomManifestID()),
+		Kind:      kind,
+		Key:       key,
+		State:     jobQueued,
+		Payload:   b,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}}
+	if err := q.save(j); err != nil {
+		return jobInfo{}, err
+	}
+	q.jobs[j.info.ID] = j
+	glog.Infof("Queued job id=%s kind=%s key=%s", j.info.ID, kind, key)
+	q.notify()
+	return j.info, nil
+}
+
+// dispatch runs the oldest queued jobs while there are workers for them
+func (q *jobQueue) dispatch(ctx context.Context) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := clock.Now()
+	for q.running < JobWorkers {
+		var next *queuedJob
+		for _, j := range q.jobs {
+			if j.info.State != jobQueued || j.notBefore.After(now) || q.kinds[j.info.Kind] == nil {
+				continue
+			}
+			if next == nil || j.info.CreatedAt.Before(next.info.CreatedAt) {
+				next = j
+			}
+		}
+		if next == nil {
+			return
+		}
+		var jctx context.Context
+		jctx, next.cancel = context.WithCancel(ctx)
+		next.info.State = jobRunning
+		next.info.Attempts++
+		next.info.UpdatedAt = now
+		q.save(next)
+		q.running++
+		go q.run(jctx, ctx, q.kinds[next.info.Kind], &runningJob{q: q, info: next.info})
+	}
+}
+
+// run makes an attempt at `rj`, then queues it again or finishes it
+func (q *jobQueue) run(ctx, queueCtx context.Context, kind *jobKind, rj *runningJob) {
+	glog.Infof("Running job id=%s kind=%s attempt=%d", rj.info.ID, rj.info.Kind, rj.info.Attempts)
+	err := kind.run(ctx, rj)
+
+	q.mu.Lock()
+	j := q.jobs[rj.info.ID]
+	j.cancel()
+	j.cancel = nil
+	q.running--
+	now := clock.Now()
+	switch {
+	case j.cancelled:
+		j.info.State = jobCancelled
+	case queueCtx.Err() != nil:
+		// stopped with the node, the attempt does not count
+		j.info.State = jobQueued
+		j.info.Attempts--
+	case err == nil:
+		j.info.State = jobCompleted
+		j.info.Progress = 1
+		j.info.Error = nil
+	case isRetryable(err) && j.info.Attempts < JobMaxAttempts:
+		backoff := time.Duration(j.info.Attempts) * jobRetryBackoff
+		j.info.State = jobQueued
+		j.info.Error = newStreamEventError(err)
+		j.notBefore = now.Add(backoff)
+		clock.AfterFunc(backoff, q.notify)
+	default:
+		j.info.State = jobFailed
+		j.info.Error = newStreamEventError(err)
+	}
+	j.info.UpdatedAt = now
+	q.save(j)
+	info := j.info
+	q.mu.Unlock()
+
+	if err != nil {
+		glog.Errorf("Job attempt failed id=%s kind=%s attempt=%d state=%s err=%v", info.ID, info.Kind, info.Attempts, info.State, err)
+	} else {
+		glog.Infof("Job completed id=%s kind=%s", info.ID, info.Kind)
+	}
+	if info.finished() && kind.finish != nil {
+		kind.finish(info)
+	}
+	q.notify()
+}
+
+// update updates the job `id`, unless it is gone
+func (q *jobQueue) update(id string, f func(j *jobInfo)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return
+	}
+	f(&j.info)
+	j.info.UpdatedAt = clock.Now()
+	q.save(j)
+}
+
+// cancel cancels the job `id`, stopping it if it is running
+func (q *jobQueue) cancel(id string) error {
+	q.mu.Lock()
+	j, ok := q.jobs[id]
+	switch {
+	case !ok:
+		q.mu.Unlock()
+		return errJobNotFound
+	case j.info.finished():
+		q.mu.Unlock()
+		return errJobFinished
+	case j.info.State == jobRunning:
+		// finished once the attempt returns
+		j.cancelled = true
+		j.cancel()
+		q.mu.Unlock()
+		return nil
+	}
+	j.info.State = jobCancelled
+	j.info.UpdatedAt = clock.Now()
+	q.save(j)
+	info := j.info
+	q.mu.Unlock()
+	glog.Infof("Cancelled job id=%s kind=%s", info.ID, info.Kind)
+	if kind := q.kinds[info.Kind]; kind != nil && kind.finish != nil {
+		kind.finish(info)
+	}
+	return nil
+}
+
+// get returns the job `id`
+func (q *jobQueue) get(id string) (jobInfo, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return jobInfo{}, false
+	}
+	return j.info, true
+}
+
+// list returns the jobs of `kind` in `state`, oldest first; all kinds or
+// states if empty
+func (q *jobQueue) list(kind, state string) []jobInfo {
+	q.mu.Lock()
+	jobs := []jobInfo{}
+	for _, j := range q.jobs {
+		if (kind == "" || j.info.Kind == kind) && (state == "" || j.info.State == state) {
+			jobs = append(jobs, j.info)
+		}
+	}
+	q.mu.Unlock()
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].CreatedAt.Before(jobs[k].CreatedAt) })
+	return jobs
+}
+
+// prune removes the jobs finished past jobRetention
+func (q *jobQueue) prune() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	cutoff := clock.Now().Add(-jobRetention)
+	for id, j := range q.jobs {
+		if !j.info.finished() || !j.info.UpdatedAt.Before(cutoff) {
+			continue
+		}
+		if q.db != nil {
+			if err := q.db.DeleteJob(id); err != nil {
+				glog.Errorf("Unable to delete job id=%s err=%v", id, err)
+				continue
+			}
+		}
+		delete(q.jobs, id)
+	}
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubJobKind runs jobs with the errors sent to `results`, making them wait
+// for those
+type stubJobKind struct {
+	results  chan error
+	finished chan jobInfo
+}
+
+func newStubJobKind() *stubJobKind {
+	return &stubJobKind{results: make(chan error), finished: make(chan jobInfo, 10)}
+}
+
+func (k *stubJobKind) kind() *jobKind {
+	return &jobKind{
+		run: func(ctx context.Context, job *runningJob) error {
+			var payload map[string]string
+			if err := job.decode(&payload); err != nil {
+				return err
+			}
+			job.progress(0.5)
+			select {
+			case err := <-k.results:
+				if err == nil {
+					return job.setResult(payload)
+				}
+				return err
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+		finish: func(job jobInfo) { k.finished <- job },
+	}
+}
+
+// startJobQueue starts `q`, returning a func to stop it with
+func startJobQueue(q *jobQueue) func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		q.start(ctx)
+		close(done)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func waitJobState(t *testing.T, q *jobQueue, id, state string) jobInfo {
+	var job jobInfo
+	common.WaitAssert(t, time.Second, func() bool {
+		job, _ = q.get(id)
+		return job.State == state
+	}, "job not "+state)
+	return job
+}
+
+func TestJobQueue_Run(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	defer func(n int) { JobWorkers = n }(JobWorkers)
+	JobWorkers = 1
+
+	q := newJobQueue(nil)
+	k := newStubJobKind()
+	q.register("test", k.kind())
+	_, err := q.submit("unknown", "", nil)
+	assert.EqualError(err, "unknown job kind=unknown")
+
+	stop := startJobQueue(q)
+	defer stop()
+
+	j1, err := q.submit("test", "k1", map[string]string{"n": "1"})
+	require.Nil(err)
+	assert.Equal(jobQueued, j1.State)
+	_, err = q.submit("test", "k1", nil)
+	assert.Equal(errJobExists, err)
+	j2, err := q.submit("test", "", map[string]string{"n": "2"})
+	require.Nil(err)
+
+	// one job runs at a time, oldest first
+	j1 = waitJobState(t, q, j1.ID, jobRunning)
+	assert.Equal(1, j1.Attempts)
+	common.WaitAssert(t, time.Second, func() bool {
+		j1, _ = q.get(j1.ID)
+		return j1.Progress == 0.5
+	}, "no progress")
+	j2, _ = q.get(j2.ID)
+	assert.Equal(jobQueued, j2.State)
+	assert.Len(q.list("test", jobQueued), 1)
+	assert.Len(q.list("", ""), 2)
+
+	k.results <- nil
+	j1 = <-k.finished
+	assert.Equal(jobCompleted, j1.State)
+	assert.Equal(float64(1), j1.Progress)
+	assert.JSONEq(`{"n":"1"}`, string(j1.Result))
+	// the key is free once the job is finished
+	j3, err := q.submit("test", "k1", nil)
+	require.Nil(err)
+
+	waitJobState(t, q, j2.ID, jobRunning)
+	k.results <- errors.New("boom")
+	j2 = <-k.finished
+	assert.Equal(jobFailed, j2.State)
+	assert.Equal(1, j2.Attempts)
+	assert.Equal("boom", j2.Error.Message)
+
+	// cancelled while running
+	waitJobState(t, q, j3.ID, jobRunning)
+	require.Nil(q.cancel(j3.ID))
+	j3 = <-k.finished
+	assert.Equal(jobCancelled, j3.State)
+	assert.Equal(errJobFinished, q.cancel(j3.ID))
+	assert.Equal(errJobNotFound, q.cancel("unknown"))
+	assert.Equal([]jobInfo{j1, j2, j3}, q.list("", ""))
+}
+
+func TestJobQueue_Retry(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	defer func(n int, d time.Duration) { JobMaxAttempts, jobRetryBackoff = n, d }(JobMaxAttempts, jobRetryBackoff)
+	JobMaxAttempts, jobRetryBackoff = 2, 0
+
+	q := newJobQueue(nil)
+	k := newStubJobKind()
+	q.register("test", k.kind())
+	stop := startJobQueue(q)
+	defer stop()
+
+	job, err := q.submit("test", "", nil)
+	require.Nil(err)
+	waitJobState(t, q, job.ID, jobRunning)
+	k.results <- errNoOrchs
+	common.WaitAssert(t, time.Second, func() bool {
+		job, _ = q.get(job.ID)
+		return job.State == jobRunning && job.Attempts == 2
+	}, "job not retried")
+	require.NotNil(job.Error)
+	assert.Equal(ErrorCategoryDiscovery, job.Error.Category)
+	// out of attempts
+	k.results <- errNoOrchs
+	job = <-k.finished
+	assert.Equal(jobFailed, job.State)
+
+	job, err = q.submit("test", "", nil)
+	require.Nil(err)
+	waitJobState(t, q, job.ID, jobRunning)
+	k.results <- errNoOrchs
+	k.results <- nil
+	job = <-k.finished
+	assert.Equal(jobCompleted, job.State)
+	assert.Nil(job.Error)
+
+	// queued jobs are cancelled right away
+	q = newJobQueue(nil)
+	q.register("test", k.kind())
+	job, err = q.submit("test", "", nil)
+	require.Nil(err)
+	require.Nil(q.cancel(job.ID))
+	job = <-k.finished
+	assert.Equal(jobCancelled, job.State)
+	assert.Equal(0, job.Attempts)
+}
+
+func TestJobQueue_Persistence(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dbh, dbraw, err := common.TempDB(t)
+	require.Nil(err)
+	defer dbh.Close()
+	defer dbraw.Close()
+
+	q := newJobQueue(dbh)
+	k := newStubJobKind()
+	q.register("test", k.kind())
+	stop := startJobQueue(q)
+	job, err := q.submit("test", "key", map[string]string{"n": "1"})
+	require.Nil(err)
+	waitJobState(t, q, job.ID, jobRunning)
+
+	// interrupted by the node stopping
+	stop()
+	job = waitJobState(t, q, job.ID, jobQueued)
+	assert.Equal(0, job.Attempts)
+	stored, err := dbh.SelectJobs()
+	require.Nil(err)
+	require.Len(stored, 1)
+	assert.Equal(jobQueued, stored[0].State)
+
+	// resumed after a restart
+	q = newJobQueue(dbh)
+	k = newStubJobKind()
+	q.register("test", k.kind())
+	stop = startJobQueue(q)
+	defer stop()
+	waitJobState(t, q, job.ID, jobRunning)
+	k.results <- errors.New("boom")
+	job = <-k.finished
+	assert.Equal(jobFailed, job.State)
+
+	stored, err = dbh.SelectJobs()
+	require.Nil(err)
+	require.Len(stored, 1)
+	assert.Equal("test", stored[0].Kind)
+	assert.Equal("key", stored[0].Key)
+	assert.Equal(jobFailed, stored[0].State)
+	assert.Equal(1, stored[0].Attempts)
+	var serr streamEventError
+	require.Nil(json.Unmarshal(stored[0].Error, &serr))
+	assert.Equal("boom", serr.Message)
+
+	// finished jobs are pruned past the retention
+	q.prune()
+	assert.Len(q.list("", ""), 1)
+	defer func(d time.Duration) { jobRetention = d }(jobRetention)
+	jobRetention = -time.Second
+	q.prune()
+	assert.Empty(q.list("", ""))
+	stored, err = dbh.SelectJobs()
+	require.Nil(err)
+	assert.Empty(stored)
+}
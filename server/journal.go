@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"math/big"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/core"
+)
+
+// SegmentJournalRetention, if non-zero, makes the broadcaster journal the
+// outcome of every source segment of its streams in the node DB: when it was
+// processed, by which orchestrator, whether it was transcoded, and the pixels
+// and cost of the renditions. Journaled segments are pruned after this long.
+var SegmentJournalRetention time.Duration
+
+// Statuses of journaled segments. Failed segments are journaled with the
+// category of their error, e.g. "failed:transcode".
+const (
+	segmentTranscoded   = "transcoded"
+	segmentUntranscoded = "untranscoded"
+	segmentFailedPrefix = "failed:"
+)
+
+// segmentJournal journals the segments of a stream. A nil *segmentJournal
+// journals nothing.
+type segmentJournal struct {
+	db  *common.DB
+	mid core.ManifestID
+}
+
+func newSegmentJournal(db *common.DB, mid core.ManifestID) *segmentJournal {
+	if db == nil || SegmentJournalRetention <= 0 {
+		return nil
+	}
+	return &segmentJournal{db: db, mid: mid}
+}
+
+// record journals `job`, processed from `start` on and failed with `err` if
+// non-nil. The segment is written in the background so as not to hold up the
+// stream.
+func (j *segmentJournal) record(job *SegmentJob, start time.Time, err error) {
+	if j == nil {
+		return
+	}
+	seg := &common.DBSegment{
+		ManifestID: string(j.mid),
+		SeqNo:      job.Segment.SeqNo,
+		StartedAt:  start,
+		FinishedAt: clock.Now(),
+	}
+	switch {
+	case err != nil:
+		seg.Status = segmentFailedPrefix + string(errorCategory(err))
+	case job.Session == nil:
+		seg.Status = segmentUntranscoded
+	default:
+		seg.Status = segmentTranscoded
+	}
+	if job.Session != nil && job.Session.OrchestratorInfo != nil {
+		seg.Orchestrator = job.Session.OrchestratorInfo.Transcoder
+	}
+	if job.Result != nil && job.Result.TranscodeData != nil {
+		for _, s := range job.Result.Segments {
+			seg.Pixels += s.Pixels
+		}
+		if job.Session != nil && job.Session.OrchestratorInfo != nil {
+			price, perr := common.RatPriceInfo(job.Session.OrchestratorInfo.PriceInfo)
+			if perr != nil {
+				glog.Errorf("Invalid price for segment journal manifestID=%s seqNo=%d err=%v", j.mid, seg.SeqNo, perr)
+			} else if price != nil {
+				seg.Cost = new(big.Rat).Mul(price, big.NewRat(seg.Pixels, 1))
+			}
+		}
+	}
+	go func() {
+		if err := j.db.InsertSegment(seg); err != nil {
+			glog.Errorf("Unable to journal segment manifestID=%s seqNo=%d err=%v", j.mid, seg.SeqNo, err)
+		}
+	}()
+}
+
+// segmentJournalPruneInterval is how often journaled segments past
+// SegmentJournalRetention are pruned
+var segmentJournalPruneInterval = time.Hour
+
+// pruneSegmentJournal prunes segments journaled in `db` past
+// SegmentJournalRetention until `ctx` is done
+func pruneSegmentJournal(ctx context.Context, db *common.DB) {
+	timer := clock.NewTimer(segmentJournalPruneInterval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C():
+		}
+		n, err := db.PruneSegments(clock.Now().Add(-SegmentJournalRetention))
+		if err != nil {
+			glog.Errorf("Unable to prune segment journal err=%v", err)
+		} else if n > 0 {
+			glog.V(common.DEBUG).Infof("Pruned segment journal segments=%d", n)
+		}
+		timer.Reset(segmentJournalPruneInterval)
+	}
+}
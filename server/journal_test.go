@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/net"
+	"github.com/livepeer/lpms/stream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSegmentJournal_Record(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	c := useFakeClock(t)
+
+	dbh, dbraw, err := common.TempDB(t)
+	require.Nil(err)
+	defer dbh.Close()
+	defer dbraw.Close()
+
+	// journaling is off without a retention or a DB
+	assert.Nil(newSegmentJournal(dbh, "mid"))
+	defer func(r time.Duration) { SegmentJournalRetention = r }(SegmentJournalRetention)
+	SegmentJournalRetention = time.Hour
+	assert.Nil(newSegmentJournal(nil, "mid"))
+	var nilJournal *segmentJournal
+	nilJournal.record(&SegmentJob{Segment: &stream.HLSSegment{}}, c.Now(), nil)
+
+	j := newSegmentJournal(dbh, "mid")
+	require.NotNil(j)
+	start := c.Now()
+	c.Advance(time.Second)
+	sess := &BroadcastSession{OrchestratorInfo: &net.OrchestratorInfo{
+		Transcoder: "https://o1",
+		PriceInfo:  &net.PriceInfo{PricePerUnit: 3, PixelsPerUnit: 2},
+	}}
+	result := &ReceivedTranscodeResult{TranscodeData: &net.TranscodeData{
+		Segments: []*net.TranscodedSegmentData{{Pixels: 100}, {Pixels: 50}},
+	}}
+	j.record(&SegmentJob{Segment: &stream.HLSSegment{SeqNo: 0}, Session: sess, Result: result}, start, nil)
+	j.record(&SegmentJob{Segment: &stream.HLSSegment{SeqNo: 1}}, start, nil)
+	j.record(&SegmentJob{Segment: &stream.HLSSegment{SeqNo: 2}, Session: sess}, start, wrapError(ErrorCategoryTranscode, true, errors.New("boom")))
+	j.record(&SegmentJob{Segment: &stream.HLSSegment{SeqNo: 3}}, start, errors.New("boom"))
+
+	var segs []*common.DBSegment
+	common.WaitAssert(t, time.Second, func() bool {
+		segs, err = dbh.SelectSegments("mid", 0, 10)
+		return err == nil && len(segs) == 4
+	}, "segments not journaled")
+	require.Len(segs, 4)
+	assert.Equal(&common.DBSegment{
+		ManifestID:   "mid",
+		SeqNo:        0,
+		StartedAt:    start,
+		FinishedAt:   start.Add(time.Second),
+		Orchestrator: "https://o1",
+		Status:       "transcoded",
+		Pixels:       150,
+		Cost:         big.NewRat(225, 1),
+	}, segs[0])
+	assert.Equal("untranscoded", segs[1].Status)
+	assert.Empty(segs[1].Orchestrator)
+	assert.Nil(segs[1].Cost)
+	assert.Equal("failed:transcode", segs[2].Status)
+	assert.Equal("https://o1", segs[2].Orchestrator)
+	assert.Zero(segs[2].Pixels)
+	assert.Equal("failed:unknown", segs[3].Status)
+}
+
+func TestSegmentJournal_Prune(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	c := useFakeClock(t)
+
+	dbh, dbraw, err := common.TempDB(t)
+	require.Nil(err)
+	defer dbh.Close()
+	defer dbraw.Close()
+
+	defer func(r time.Duration) { SegmentJournalRetention = r }(SegmentJournalRetention)
+	SegmentJournalRetention = 90 * time.Minute
+	now := c.Now()
+	require.Nil(dbh.InsertSegment(&common.DBSegment{ManifestID: "mid", SeqNo: 0, StartedAt: now, FinishedAt: now}))
+	require.Nil(dbh.InsertSegment(&common.DBSegment{ManifestID: "mid", SeqNo: 1, StartedAt: now, FinishedAt: now.Add(time.Hour)}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		pruneSegmentJournal(ctx, dbh)
+		close(done)
+	}()
+
+	count := func() int {
+		segs, err := dbh.SelectSegments("mid", 0, 10)
+		require.Nil(err)
+		return len(segs)
+	}
+	// nothing is past the retention after an hour
+	require.True(c.waitTimers(1))
+	c.Advance(segmentJournalPruneInterval)
+	require.True(c.waitTimers(1))
+	assert.Equal(2, count())
+
+	// the first segment is after two
+	c.Advance(segmentJournalPruneInterval)
+	require.True(c.waitTimers(1))
+	assert.Equal(1, count())
+
+	cancel()
+	<-done
+}
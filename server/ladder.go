@@ -0,0 +1,126 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/lpms/ffmpeg"
+)
+
+// AutoLadder is the value of the `ladder` field of auth webhook responses,
+// and of -transcodingOptions, that makes the renditions of a stream be
+// derived from its source rather than listed
+const AutoLadder = "auto"
+
+// AutoLadderDefault makes streams use an auto ladder unless the auth webhook
+// lists their profiles or presets
+var AutoLadderDefault bool
+
+// AutoLadderMaxFPS is the highest frame rate of the renditions of an auto
+// ladder. Sources with higher frame rates are transcoded down to it.
+var AutoLadderMaxFPS uint = 30
+
+// autoLadderRungs are the heights and bitrates, in bits per second, of the
+// renditions auto ladders pick from, highest first
+var autoLadderRungs = []struct {
+	height  int
+	bitrate int
+}{
+	{1080, 6000000},
+	{720, 3000000},
+	{480, 1600000},
+	{360, 800000},
+	{240, 400000},
+}
+
+// autoLadder returns the renditions for a source of `width` by `height`: a
+// rendition at each rung below the source height, with the aspect ratio of
+// the source. Sources no taller than the lowest rung get a single rendition
+// at their own resolution. Returns nil if the resolution is unknown.
+func autoLadder(width, height int, format ffmpeg.Format) []ffmpeg.VideoProfile {
+	if width <= 0 || height <= 0 {
+		return nil
+	}
+	var profiles []ffmpeg.VideoProfile
+	add := func(w, h, bitrate int) {
+		profiles = append(profiles, ffmpeg.VideoProfile{
+			Name:       fmt.Sprintf("auto_%dp", h),
+			Bitrate:    fmt.Sprint(bitrate),
+			Resolution: fmt.Sprintf("%dx%d", w, h),
+			Format:     format,
+		})
+	}
+	for _, rung := range autoLadderRungs {
+		if rung.height >= height {
+			continue
+		}
+		// encoders need even dimensions
+		w := (width*rung.height/height + 1) &^ 1
+		add(w, rung.height, rung.bitrate)
+	}
+	if len(profiles) == 0 {
+		lowest := autoLadderRungs[len(autoLadderRungs)-1]
+		add(width&^1, height&^1, lowest.bitrate)
+	}
+	return profiles
+}
+
+// applyAutoLadder replaces the profiles of a stream with an auto ladder for
+// the resolution of its source. Streams of unknown resolution keep their
+// profiles and no longer use an auto ladder.
+func applyAutoLadder(params *core.StreamParameters) {
+	var w, h int
+	fmt.Sscanf(params.Resolution, "%dx%d", &w, &h)
+	ladder := autoLadder(w, h, params.Format)
+	if ladder == nil {
+		glog.Errorf("Unknown source resolution for auto ladder, using default profiles manifestID=%s resolution=%q", params.ManifestID, params.Resolution)
+		params.AutoLadder = false
+		return
+	}
+	params.Profiles = ladder
+	glog.Infof("Using auto ladder manifestID=%s resolution=%s profiles=%v", params.ManifestID, params.Resolution, ladder)
+}
+
+// refineAutoLadder caps the frame rate and bitrates of an auto ladder at
+// those of the source measured from its first segment `data` of `dur`
+// seconds. Renditions never exceed the source bitrate, and are transcoded
+// down to AutoLadderMaxFPS if the source has a higher frame rate.
+func refineAutoLadder(params *core.StreamParameters, data []byte, dur float64) {
+	fps := sourceFrameRate(parseTSTimestamps(data))
+	bitrate := 0
+	if dur > 0 {
+		bitrate = int(math.Round(float64(len(data)*8) / dur))
+	}
+	profiles := append([]ffmpeg.VideoProfile(nil), params.Profiles...)
+	for i := range profiles {
+		if fps > float64(AutoLadderMaxFPS)+0.5 {
+			profiles[i].Framerate = AutoLadderMaxFPS
+		}
+		var b int
+		if _, err := fmt.Sscan(profiles[i].Bitrate, &b); err == nil && bitrate > 0 && b > bitrate {
+			profiles[i].Bitrate = fmt.Sprint(bitrate)
+		}
+	}
+	// no segment has been transcoded yet, so the profiles can still change
+	params.Profiles = profiles
+	glog.Infof("Refined auto ladder manifestID=%s fps=%.2f bitrate=%d profiles=%v", params.ManifestID, fps, bitrate, profiles)
+}
+
+// sourceFrameRate estimates the frame rate of video from the median interval
+// between its frames, or returns 0 if there are too few frames
+func sourceFrameRate(ts tsTimestamps) float64 {
+	var deltas []int64
+	for i := 1; i < len(ts.video); i++ {
+		if d := tsDelta(ts.video[i], ts.video[i-1]); d > 0 {
+			deltas = append(deltas, d)
+		}
+	}
+	if len(deltas) == 0 {
+		return 0
+	}
+	sort.Slice(deltas, func(i, j int) bool { return deltas[i] < deltas[j] })
+	return 90000 / float64(deltas[len(deltas)/2])
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/lpms/ffmpeg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAutoLadder(t *testing.T) {
+	assert := assert.New(t)
+	resolutions := func(profiles []ffmpeg.VideoProfile) []string {
+		var res []string
+		for _, p := range profiles {
+			res = append(res, p.Name+" "+p.Resolution+" "+p.Bitrate)
+		}
+		return res
+	}
+
+	assert.Equal([]string{
+		"auto_720p 1280x720 3000000",
+		"auto_480p 854x480 1600000",
+		"auto_360p 640x360 800000",
+		"auto_240p 426x240 400000",
+	}, resolutions(autoLadder(1920, 1080, ffmpeg.FormatNone)))
+
+	// never upscaled
+	assert.Equal([]string{"auto_240p 426x240 400000"}, resolutions(autoLadder(640, 360, ffmpeg.FormatNone)))
+	assert.Equal([]string{"auto_180p 320x180 400000"}, resolutions(autoLadder(321, 181, ffmpeg.FormatNone)))
+
+	// portrait
+	assert.Equal([]string{"auto_240p 136x240 400000"}, resolutions(autoLadder(180, 320, ffmpeg.FormatNone)))
+
+	profiles := autoLadder(1280, 720, ffmpeg.FormatMP4)
+	assert.Len(profiles, 3)
+	for _, p := range profiles {
+		assert.Equal(ffmpeg.FormatMP4, p.Format)
+	}
+
+	assert.Nil(autoLadder(0, 0, ffmpeg.FormatNone))
+}
+
+func TestApplyAutoLadder(t *testing.T) {
+	assert := assert.New(t)
+
+	params := &core.StreamParameters{ManifestID: "mid", Resolution: "1280x720", Profiles: BroadcastJobVideoProfiles, AutoLadder: true}
+	applyAutoLadder(params)
+	assert.True(params.AutoLadder)
+	assert.Len(params.Profiles, 3)
+	assert.Equal("auto_480p", params.Profiles[0].Name)
+
+	// the default profiles are kept for unknown resolutions
+	for _, res := range []string{"", "0x0", "widexhigh"} {
+		params = &core.StreamParameters{ManifestID: "mid", Resolution: res, Profiles: BroadcastJobVideoProfiles, AutoLadder: true}
+		applyAutoLadder(params)
+		assert.Equal(BroadcastJobVideoProfiles, params.Profiles)
+		assert.False(params.AutoLadder)
+	}
+}
+
+func TestRefineAutoLadder(t *testing.T) {
+	assert := assert.New(t)
+
+	// 60fps source at a high bitrate
+	params := &core.StreamParameters{ManifestID: "mid", Profiles: autoLadder(1280, 720, ffmpeg.FormatNone)}
+	ladder := params.Profiles
+	data := tsTestSegment(tsTestFrames(0, 1500, 1500, 1500, 1500), nil)
+	refineAutoLadder(params, data, float64(len(data)*8)/10000000)
+	for _, p := range params.Profiles {
+		assert.Equal(AutoLadderMaxFPS, p.Framerate)
+	}
+	assert.Equal([]string{"1600000", "800000", "400000"},
+		[]string{params.Profiles[0].Bitrate, params.Profiles[1].Bitrate, params.Profiles[2].Bitrate})
+	assert.Zero(ladder[0].Framerate, "ladder is replaced rather than modified")
+
+	// 25fps source at a bitrate below the top rungs
+	params = &core.StreamParameters{ManifestID: "mid", Profiles: autoLadder(1280, 720, ffmpeg.FormatNone)}
+	data = tsTestSegment(tsTestFrames(0, 3600, 3600, 3600), nil)
+	refineAutoLadder(params, data, float64(len(data)*8)/1000000)
+	for _, p := range params.Profiles {
+		assert.Zero(p.Framerate)
+	}
+	assert.Equal([]string{"1000000", "800000", "400000"},
+		[]string{params.Profiles[0].Bitrate, params.Profiles[1].Bitrate, params.Profiles[2].Bitrate})
+
+	assert.Zero(sourceFrameRate(tsTimestamps{video: []int64{0}}))
+	assert.InDelta(29.97, sourceFrameRate(tsTimestamps{video: []int64{0, 3003, 6006, 9009}}), 0.01)
+}
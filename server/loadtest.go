@@ -0,0 +1,168 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LoadTestConfig describes a synthetic HTTP push load
+type LoadTestConfig struct {
+	// Streams is the number of streams pushing at once
+	Streams int
+	// Segments is the number of segments pushed by each stream
+	Segments int
+	// SegmentSize is the size of each segment in bytes
+	SegmentSize int
+	// SegmentDuration is sent as the Content-Duration of each segment
+	SegmentDuration time.Duration
+	// Multipart requests the renditions in a multipart response
+	Multipart bool
+	// ManifestPrefix names the streams <prefix>-<n>
+	ManifestPrefix string
+}
+
+// LoadTestReport summarises a load test run
+type LoadTestReport struct {
+	Segments       int
+	Errors         int
+	Elapsed        time.Duration
+	SegmentsPerSec float64
+	BytesPerSec    float64 // of source segments pushed
+	LatencyP50     time.Duration
+	LatencyP90     time.Duration
+	LatencyP99     time.Duration
+	LatencyMax     time.Duration
+	// Allocations made by the whole process during the run
+	AllocBytes   uint64
+	Allocs       uint64
+	AllocsPerSeg uint64
+}
+
+// DefaultLoadTestConfig pushes 2s segments of 1MB
+var DefaultLoadTestConfig = LoadTestConfig{
+	Streams:         10,
+	Segments:        10,
+	SegmentSize:     1 << 20,
+	SegmentDuration: 2 * time.Second,
+	ManifestPrefix:  "loadtest",
+}
+
+func (cfg LoadTestConfig) manifestID(stream int) string {
+	return fmt.Sprintf("%s-%d", cfg.ManifestPrefix, stream)
+}
+
+// RunLoadTest pushes segments for cfg.Streams streams at once to `h`, each
+// stream pushing its segments one after the other as an encoder would, and
+// reports the throughput, latency and allocations of the run. The run stops
+// early if the context is done.
+func RunLoadTest(ctx context.Context, h http.Handler, cfg LoadTestConfig) *LoadTestReport {
+	data := make([]byte, cfg.SegmentSize)
+	rand.Read(data)
+	dur := strconv.FormatInt(int64(cfg.SegmentDuration/time.Millisecond), 10)
+
+	var (
+		mu        sync.Mutex
+		latencies []time.Duration
+		errors    int
+		wg        sync.WaitGroup
+	)
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for i := 0; i < cfg.Streams; i++ {
+		wg.Add(1)
+		go func(mid string) {
+			defer wg.Done()
+			took := make([]time.Duration, 0, cfg.Segments)
+			failed := 0
+			for seq := 0; seq < cfg.Segments && ctx.Err() == nil; seq++ {
+				req, err := http.NewRequest("POST", fmt.Sprintf("/live/%s/%d.ts", mid, seq), bytes.NewReader(data))
+				if err != nil {
+					failed++
+					continue
+				}
+				req = req.WithContext(ctx)
+				req.Header.Set("Content-Duration", dur)
+				if cfg.Multipart {
+					req.Header.Set("Accept", "multipart/mixed")
+				}
+				w := &loadTestWriter{header: make(http.Header), status: http.StatusOK}
+				segStart := time.Now()
+				h.ServeHTTP(w, req)
+				took = append(took, time.Since(segStart))
+				if w.status != http.StatusOK {
+					failed++
+				}
+			}
+			mu.Lock()
+			latencies = append(latencies, took...)
+			errors += failed
+			mu.Unlock()
+		}(cfg.manifestID(i))
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	rep := &LoadTestReport{
+		Segments:   len(latencies),
+		Errors:     errors,
+		Elapsed:    elapsed,
+		AllocBytes: after.TotalAlloc - before.TotalAlloc,
+		Allocs:     after.Mallocs - before.Mallocs,
+	}
+	if len(latencies) == 0 {
+		return rep
+	}
+	rep.SegmentsPerSec = float64(len(latencies)) / elapsed.Seconds()
+	rep.BytesPerSec = rep.SegmentsPerSec * float64(cfg.SegmentSize)
+	rep.AllocsPerSeg = rep.Allocs / uint64(len(latencies))
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	percentile := func(p int) time.Duration {
+		return latencies[(len(latencies)-1)*p/100]
+	}
+	rep.LatencyP50 = percentile(50)
+	rep.LatencyP90 = percentile(90)
+	rep.LatencyP99 = percentile(99)
+	rep.LatencyMax = latencies[len(latencies)-1]
+	return rep
+}
+
+func (rep *LoadTestReport) String() string {
+	return fmt.Sprintf("segments=%d errors=%d elapsed=%s segs/s=%.1f MB/s=%.1f p50=%s p90=%s p99=%s max=%s allocs/seg=%d allocMB=%.1f",
+		rep.Segments, rep.Errors, rep.Elapsed, rep.SegmentsPerSec, rep.BytesPerSec/1e6,
+		rep.LatencyP50, rep.LatencyP90, rep.LatencyP99, rep.LatencyMax, rep.AllocsPerSeg, float64(rep.AllocBytes)/1e6)
+}
+
+// loadTestWriter discards the response, keeping only its status
+type loadTestWriter struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+}
+
+func (w *loadTestWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *loadTestWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+		w.wroteHeader = true
+	}
+}
+
+func (w *loadTestWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return len(b), nil
+}
+
+func (w *loadTestWriter) Flush() {}
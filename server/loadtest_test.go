@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/drivers"
+	"github.com/livepeer/go-livepeer/net"
+	"github.com/livepeer/lpms/ffmpeg"
+)
+
+func TestRunLoadTest(t *testing.T) {
+	assert := assert.New(t)
+
+	var mu sync.Mutex
+	seen := map[string]int{}
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Len(body, 10)
+		assert.Equal("2000", r.Header.Get("Content-Duration"))
+		assert.Equal("multipart/mixed", r.Header.Get("Accept"))
+		mu.Lock()
+		seen[r.URL.Path]++
+		mu.Unlock()
+		if r.URL.Path == "/live/lt-1/2.ts" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("ok"))
+	})
+
+	cfg := LoadTestConfig{Streams: 3, Segments: 4, SegmentSize: 10, SegmentDuration: 2 * time.Second, Multipart: true, ManifestPrefix: "lt"}
+	rep := RunLoadTest(context.Background(), h, cfg)
+	assert.Equal(12, rep.Segments)
+	assert.Equal(1, rep.Errors)
+	assert.Len(seen, 12)
+	assert.Equal(1, seen["/live/lt-2/3.ts"])
+	assert.True(rep.SegmentsPerSec > 0)
+	assert.True(rep.LatencyP50 <= rep.LatencyP99)
+	assert.True(rep.LatencyP99 <= rep.LatencyMax)
+
+	// nothing is pushed once the context is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rep = RunLoadTest(ctx, h, cfg)
+	assert.Equal(0, rep.Segments)
+	assert.Equal(0, rep.Errors)
+}
+
+// BenchmarkPush pushes concurrent streams through HandlePush to a stub
+// orchestrator. Run with eg `go test -run x -bench Push -benchtime 20x`
+func BenchmarkPush(b *testing.B) {
+	s := setupServer()
+	defer serverCleanup(s)
+
+	ts, mux := stubTLSServer()
+	defer ts.Close()
+	segPath := "/transcoded/segment.ts"
+	buf, err := proto.Marshal(&net.TranscodeResult{
+		Result: &net.TranscodeResult_Data{
+			Data: &net.TranscodeData{
+				Segments: []*net.TranscodedSegmentData{{Url: ts.URL + segPath, Pixels: 100}},
+				Sig:      []byte("bar"),
+			},
+		},
+	})
+	require.Nil(b, err)
+	mux.HandleFunc("/segment", func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		w.Write(buf)
+	})
+	mux.HandleFunc(segPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("transcoded binary data"))
+	})
+
+	cfg := LoadTestConfig{Streams: 8, Segments: b.N, SegmentSize: 256 << 10, SegmentDuration: 2 * time.Second, Multipart: true, ManifestPrefix: "bench"}
+	u, _ := url.ParseRequestURI("test://some.host")
+	osd := drivers.NewMemoryDriver(u)
+	for i := 0; i < cfg.Streams; i++ {
+		mid := core.ManifestID(cfg.manifestID(i))
+		sess := StubBroadcastSession(ts.URL)
+		sess.Params.Profiles = []ffmpeg.VideoProfile{ffmpeg.P144p30fps16x9}
+		sess.Params.ManifestID = mid
+		s.rtmpConnections.store(mid, &rtmpConnection{
+			mid:         mid,
+			nonce:       uint64(i),
+			pl:          core.NewBasicPlaylistManager(mid, osd.NewSession(string(mid)), nil),
+			profile:     &ffmpeg.P144p30fps16x9,
+			sessManager: bsmWithSessList([]*BroadcastSession{sess}),
+			params:      &core.StreamParameters{Profiles: []ffmpeg.VideoProfile{ffmpeg.P144p30fps16x9}},
+		})
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	rep := RunLoadTest(context.Background(), http.HandlerFunc(s.HandlePush), cfg)
+	b.StopTimer()
+	if rep.Errors > 0 {
+		b.Fatalf("%d of %d segments failed", rep.Errors, rep.Segments)
+	}
+	b.ReportMetric(rep.SegmentsPerSec, "segs/s")
+	b.ReportMetric(float64(rep.LatencyP50.Nanoseconds()), "p50-ns")
+	b.ReportMetric(float64(rep.LatencyP99.Nanoseconds()), "p99-ns")
+	b.ReportMetric(float64(rep.AllocsPerSeg), "allocs/seg")
+	b.Logf("%d streams: %s", cfg.Streams, rep)
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/livepeer/go-livepeer/net"
+)
+
+// MaintenanceLookahead is how far ahead of a maintenance window broadcasters
+// stop selecting the orchestrator for new sessions, so that streams expected
+// to last that long don't have to move away mid-stream
+var MaintenanceLookahead = time.Hour
+
+// maintenanceMigrationLead is how long before a maintenance window starts
+// broadcasters move active sessions away from the orchestrator
+var maintenanceMigrationLead = time.Minute
+
+// MaintenanceWindow is a period during which the orchestrator is not expected
+// to transcode
+type MaintenanceWindow struct {
+	Start time.Time
+	End   time.Time
+}
+
+// maintenanceSchedule holds the maintenance windows the orchestrator
+// advertises
+type maintenanceSchedule struct {
+	mu      sync.Mutex
+	windows []MaintenanceWindow
+}
+
+var maintenanceWindows = &maintenanceSchedule{}
+
+// SetMaintenanceWindows replaces the maintenance windows advertised by the
+// orchestrator in its OrchestratorInfo
+func SetMaintenanceWindows(windows []MaintenanceWindow) {
+	maintenanceWindows.set(windows)
+}
+
+func (s *maintenanceSchedule) set(windows []MaintenanceWindow) {
+	windows = append([]MaintenanceWindow(nil), windows...)
+	sort.Slice(windows, func(i, j int) bool { return windows[i].Start.Before(windows[j].Start) })
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.windows = windows
+}
+
+// upcoming returns the windows that have not ended by `now`
+func (s *maintenanceSchedule) upcoming(now time.Time) []*net.OrchestratorInfo_MaintenanceWindow {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res []*net.OrchestratorInfo_MaintenanceWindow
+	for _, w := range s.windows {
+		if w.End.After(now) {
+			res = append(res, &net.OrchestratorInfo_MaintenanceWindow{Start: w.Start.Unix(), End: w.End.Unix()})
+		}
+	}
+	return res
+}
+
+// ParseMaintenanceWindows parses a comma separated list of maintenance
+// windows, each given as its RFC 3339 start followed by a slash and either
+// its RFC 3339 end or its duration, e.g. 2021-03-01T02:00:00Z/2h
+func ParseMaintenanceWindows(v string) ([]MaintenanceWindow, error) {
+	var windows []MaintenanceWindow
+	for _, s := range strings.Split(v, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		parts := strings.SplitN(s, "/", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("maintenance window %q is not start/end or start/duration", s)
+		}
+		start, err := time.Parse(time.RFC3339, parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid start of maintenance window %q: %w", s, err)
+		}
+		end, err := time.Parse(time.RFC3339, parts[1])
+		if err != nil {
+			d, derr := time.ParseDuration(parts[1])
+			if derr != nil {
+				return nil, fmt.Errorf("invalid end of maintenance window %q: %w", s, err)
+			}
+			end = start.Add(d)
+		}
+		if !end.After(start) {
+			return nil, fmt.Errorf("maintenance window %q ends before it starts", s)
+		}
+		windows = append(windows, MaintenanceWindow{Start: start, End: end})
+	}
+	return windows, nil
+}
+
+// maintenanceWithin returns whether a maintenance window advertised in `info`
+// overlaps the period of `d` from `now`
+func maintenanceWithin(info *net.OrchestratorInfo, now time.Time, d time.Duration) bool {
+	from, to := now.Unix(), now.Add(d).Unix()
+	for _, w := range info.GetMaintenanceWindows() {
+		if w.Start <= to && w.End > from {
+			return true
+		}
+	}
+	return false
+}
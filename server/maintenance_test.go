@@ -0,0 +1,137 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/net"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMaintenanceWindows(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	start := time.Date(2021, 3, 1, 2, 0, 0, 0, time.UTC)
+	windows, err := ParseMaintenanceWindows("2021-03-01T02:00:00Z/2021-03-01T04:00:00Z, 2021-03-08T02:00:00Z/30m")
+	require.Nil(err)
+	require.Len(windows, 2)
+	assert.True(windows[0].Start.Equal(start))
+	assert.True(windows[0].End.Equal(start.Add(2 * time.Hour)))
+	assert.True(windows[1].Start.Equal(start.Add(7 * 24 * time.Hour)))
+	assert.True(windows[1].End.Equal(start.Add(7*24*time.Hour + 30*time.Minute)))
+
+	windows, err = ParseMaintenanceWindows("")
+	assert.Nil(err)
+	assert.Empty(windows)
+
+	for _, v := range []string{
+		"2021-03-01T02:00:00Z",
+		"tomorrow/2h",
+		"2021-03-01T02:00:00Z/soon",
+		"2021-03-01T02:00:00Z/2021-03-01T01:00:00Z",
+		"2021-03-01T02:00:00Z/0s",
+	} {
+		_, err := ParseMaintenanceWindows(v)
+		assert.Error(err, v)
+	}
+}
+
+func TestMaintenanceSchedule_Upcoming(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Unix(1600000000, 0)
+	s := &maintenanceSchedule{}
+	assert.Empty(s.upcoming(now))
+
+	s.set([]MaintenanceWindow{
+		{Start: now.Add(24 * time.Hour), End: now.Add(25 * time.Hour)},
+		{Start: now.Add(-2 * time.Hour), End: now.Add(-time.Hour)},
+		{Start: now.Add(-time.Hour), End: now.Add(time.Hour)},
+	})
+	windows := s.upcoming(now)
+	// ended windows are left out, the rest sorted by start
+	assert.Equal([]*net.OrchestratorInfo_MaintenanceWindow{
+		{Start: now.Add(-time.Hour).Unix(), End: now.Add(time.Hour).Unix()},
+		{Start: now.Add(24 * time.Hour).Unix(), End: now.Add(25 * time.Hour).Unix()},
+	}, windows)
+
+	assert.Empty(s.upcoming(now.Add(25 * time.Hour)))
+}
+
+func TestMaintenanceWithin(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Unix(1600000000, 0)
+	info := &net.OrchestratorInfo{MaintenanceWindows: []*net.OrchestratorInfo_MaintenanceWindow{
+		{Start: now.Add(2 * time.Hour).Unix(), End: now.Add(3 * time.Hour).Unix()},
+	}}
+	assert.False(maintenanceWithin(nil, now, time.Hour))
+	assert.False(maintenanceWithin(&net.OrchestratorInfo{}, now, time.Hour))
+	assert.False(maintenanceWithin(info, now, time.Hour))
+	assert.True(maintenanceWithin(info, now, 2*time.Hour))
+	assert.True(maintenanceWithin(info, now.Add(150*time.Minute), 0))
+	assert.False(maintenanceWithin(info, now.Add(3*time.Hour), time.Hour))
+}
+
+func TestSelectOrchestrator_Maintenance(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	c := useFakeClock(t)
+	s := setupServer()
+	defer serverCleanup(s)
+	defer func() { s.LivepeerNode.OrchestratorPool = nil }()
+
+	now := c.Now()
+	sd := &stubDiscovery{infos: []*net.OrchestratorInfo{
+		{Transcoder: "transcoder1", PriceInfo: &net.PriceInfo{PricePerUnit: 1, PixelsPerUnit: 1}, AuthToken: stubAuthToken},
+		{
+			Transcoder: "transcoder2", PriceInfo: &net.PriceInfo{PricePerUnit: 1, PixelsPerUnit: 1}, AuthToken: stubAuthToken,
+			MaintenanceWindows: []*net.OrchestratorInfo_MaintenanceWindow{
+				{Start: now.Add(30 * time.Minute).Unix(), End: now.Add(90 * time.Minute).Unix()},
+			},
+		},
+	}}
+	s.LivepeerNode.OrchestratorPool = sd
+	sp := &core.StreamParameters{ManifestID: core.RandomManifestID()}
+
+	// the orchestrator going into maintenance within the lookahead is skipped
+	sess, err := selectOrchestrator(s.LivepeerNode, sp, 2, newSuspender())
+	require.Nil(err)
+	require.Len(sess, 1)
+	assert.Equal("transcoder1", sess[0].OrchestratorInfo.Transcoder)
+
+	// and selected again once its window is further away than the lookahead
+	defer func(d time.Duration) { MaintenanceLookahead = d }(MaintenanceLookahead)
+	MaintenanceLookahead = 10 * time.Minute
+	sess, err = selectOrchestrator(s.LivepeerNode, sp, 2, newSuspender())
+	require.Nil(err)
+	assert.Len(sess, 2)
+}
+
+func TestSelectSession_Maintenance(t *testing.T) {
+	assert := assert.New(t)
+	c := useFakeClock(t)
+
+	sess1 := StubBroadcastSession("transcoder1")
+	sess2 := StubBroadcastSession("transcoder2")
+	sess2.OrchestratorInfo.MaintenanceWindows = []*net.OrchestratorInfo_MaintenanceWindow{
+		{Start: c.Now().Add(10 * time.Minute).Unix(), End: c.Now().Add(time.Hour).Unix()},
+	}
+	bsm := newSessionsManagerLIFO(bsmWithSessList([]*BroadcastSession{sess1, sess2}))
+	bsm.createSessions = func() ([]*BroadcastSession, error) { return nil, nil }
+
+	// the window is beyond the migration lead so the session stays in use
+	assert.Equal(sess2, bsm.selectSession())
+	bsm.completeSession(sess2)
+	assert.Equal(sess2, bsm.selectSession())
+	bsm.completeSession(sess2)
+
+	// shortly before the window starts the session is moved away from
+	c.Advance(10*time.Minute - maintenanceMigrationLead)
+	assert.Equal(sess1, bsm.selectSession())
+	assert.NotContains(bsm.sessMap, "transcoder2")
+	assert.Contains(bsm.sessMap, "transcoder1")
+}
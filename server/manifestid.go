@@ -0,0 +1,73 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/livepeer/go-livepeer/core"
+)
+
+// ManifestIDRules restrict the manifest IDs that streams may be pushed to or
+// given by the auth webhook. Zero values leave their aspect unchecked.
+type ManifestIDRules struct {
+	// Pattern that manifest IDs must match in full
+	Pattern   *regexp.Regexp
+	MaxLength int
+	// Prefixes of manifest IDs kept for internal use
+	ReservedPrefixes []string
+}
+
+// ManifestIDPolicy is checked on top of the rules every manifest ID must
+// follow: not being "." or "..", and having no characters that are special
+// in paths or URLs, nor whitespace or control characters
+var ManifestIDPolicy ManifestIDRules
+
+// validate returns an error if `mid` breaks the rules
+func (r ManifestIDRules) validate(mid core.ManifestID) error {
+	s := string(mid)
+	if s == "." || s == ".." {
+		return fmt.Errorf("invalid manifest ID %q", s)
+	}
+	for _, c := range s {
+		if unicode.IsSpace(c) || unicode.IsControl(c) || strings.ContainsRune(`/\?#%`, c) {
+			return fmt.Errorf("invalid character %q in manifest ID %q", c, s)
+		}
+	}
+	if r.MaxLength > 0 && len(s) > r.MaxLength {
+		return fmt.Errorf("manifest ID too long length=%d max=%d", len(s), r.MaxLength)
+	}
+	if r.Pattern != nil && !r.Pattern.MatchString(s) {
+		return fmt.Errorf("manifest ID %q does not match %s", s, r.Pattern)
+	}
+	for _, p := range r.ReservedPrefixes {
+		if strings.HasPrefix(s, p) {
+			return fmt.Errorf("manifest ID %q has reserved prefix %q", s, p)
+		}
+	}
+	return nil
+}
+
+// ParseManifestIDRules parses the rules from their flags: a regular
+// expression that is anchored at both ends, and comma separated prefixes
+func ParseManifestIDRules(pattern string, maxLength int, reservedPrefixes string) (ManifestIDRules, error) {
+	var r ManifestIDRules
+	if pattern != "" {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return r, err
+		}
+		r.Pattern = re
+	}
+	if maxLength < 0 {
+		return r, fmt.Errorf("invalid manifest ID max length=%d", maxLength)
+	}
+	r.MaxLength = maxLength
+	for _, p := range strings.Split(reservedPrefixes, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			r.ReservedPrefixes = append(r.ReservedPrefixes, p)
+		}
+	}
+	return r, nil
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifestIDRules(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var r ManifestIDRules
+	assert.Nil(r.validate("abc-123_x.y"))
+	assert.EqualError(r.validate(".."), `invalid manifest ID ".."`)
+	assert.EqualError(r.validate("a b"), `invalid character ' ' in manifest ID "a b"`)
+	assert.EqualError(r.validate(`a\b`), `invalid character '\\' in manifest ID "a\\b"`)
+	assert.EqualError(r.validate("a%2F"), `invalid character '%' in manifest ID "a%2F"`)
+	assert.NotNil(r.validate("a\x00"))
+
+	r, err := ParseManifestIDRules("[a-z0-9]+", 8, " internal-, tmp ,")
+	require.Nil(err)
+	assert.Equal([]string{"internal-", "tmp"}, r.ReservedPrefixes)
+	assert.Nil(r.validate("abc123"))
+	// matched in full
+	assert.EqualError(r.validate("abc-123"), `manifest ID "abc-123" does not match ^(?:[a-z0-9]+)$`)
+	assert.EqualError(r.validate("abcdefghi"), "manifest ID too long length=9 max=8")
+	assert.EqualError(r.validate("tmp1"), `manifest ID "tmp1" has reserved prefix "tmp"`)
+
+	_, err = ParseManifestIDRules("[", 0, "")
+	assert.NotNil(err)
+	_, err = ParseManifestIDRules("", -1, "")
+	assert.EqualError(err, "invalid manifest ID max length=-1")
+}
+
+func TestCreateRTMPStreamHandler_ManifestIDRules(t *testing.T) {
+	assert := assert.New(t)
+	s := setupServer()
+	defer serverCleanup(s)
+	defer func(r ManifestIDRules) { ManifestIDPolicy = r }(ManifestIDPolicy)
+	createSid := createRTMPStreamIDHandler(s)
+
+	u, _ := url.Parse("rtmp://localhost/stream/..")
+	assert.Nil(createSid(u))
+
+	var err error
+	ManifestIDPolicy, err = ParseManifestIDRules("", 0, "internal")
+	assert.Nil(err)
+	u, _ = url.Parse("rtmp://localhost/internal1/key")
+	assert.Nil(createSid(u))
+	u, _ = url.Parse("rtmp://localhost/public1/key")
+	params := streamParams(createSid(u))
+	if assert.NotNil(params) {
+		assert.Equal(core.ManifestID("public1"), params.ManifestID)
+	}
+
+	// generated manifest IDs are not checked
+	ManifestIDPolicy.Pattern = nil
+	ManifestIDPolicy.MaxLength = 1
+	u, _ = url.Parse("rtmp://localhost")
+	assert.NotNil(createSid(u))
+}
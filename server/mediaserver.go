@@ -12,7 +12,6 @@ import (
 	"io"
 	"io/ioutil"
 	"math/rand"
-	"mime/multipart"
 	"net/http"
 	"net/textproto"
 	"net/url"
@@ -39,15 +38,15 @@ import (
 	"github.com/livepeer/lpms/stream"
 	"github.com/livepeer/lpms/vidplayer"
 	"github.com/livepeer/m3u8"
-	"github.com/patrickmn/go-cache"
 )
 
-var errAlreadyExists = errors.New("StreamAlreadyExists")
-var errStorage = errors.New("ErrStorage")
-var errDiscovery = errors.New("ErrDiscovery")
-var errNoOrchs = errors.New("ErrNoOrchs")
-var errUnknownStream = errors.New("ErrUnknownStream")
-var errMismatchedParams = errors.New("Mismatched type for stream params")
+var errAlreadyExists = newError(ErrorCategoryIngest, false, "StreamAlreadyExists")
+var errStorage = newError(ErrorCategoryStorage, false, "ErrStorage")
+var errDiscovery = newError(ErrorCategoryDiscovery, false, "ErrDiscovery")
+var errNoOrchs = newError(ErrorCategoryDiscovery, true, "ErrNoOrchs")
+var errUnknownStream = newError(ErrorCategoryIngest, false, "ErrUnknownStream")
+var errMismatchedParams = newError(ErrorCategoryIngest, false, "Mismatched type for stream params")
+var errTooManySessions = newError(ErrorCategoryIngest, true, "ErrTooManySessions")
 
 const HLSWaitInterval = time.Second
 const HLSBufferCap = uint(43200) //12 hrs assuming 1s segment
@@ -61,11 +60,42 @@ var BroadcastJobVideoProfiles = []ffmpeg.VideoProfile{ffmpeg.P240p30fps4x3, ffmp
 
 var AuthWebhookURL string
 
+// AuthWebhookSecret, if set, signs the requests of the auth webhook with
+// WebhookSigHeader, for the webhook to check that they come from this node
+var AuthWebhookSecret string
+
+// AuthWebhookVerifyResponse makes the node reject responses of the auth
+// webhook that aren't signed with AuthWebhookSecret
+var AuthWebhookVerifyResponse bool
+
+// RecordFlushInterval is the default interval over which saves of the
+// recording playlist are batched. Zero saves it after every segment.
+var RecordFlushInterval time.Duration
+
+// RecordingsRedirectExpiry, if non-zero, makes /recordings answer requests for
+// segments with a redirect to a signed URL valid for this long, for object
+// stores that support it, instead of proxying the data through this node
+var RecordingsRedirectExpiry time.Duration
+
+// RTMPReconnectGrace, if non-zero, is how long the connection, sessions and
+// playlists of an RTMP stream are kept after its publisher disconnects. A
+// publisher reconnecting to the same manifest ID within it continues the
+// stream after a discontinuity, rather than starting a new one.
+var RTMPReconnectGrace time.Duration
+
+// MemBudget, if set, bounds the memory held for segments in flight and in the
+// memory object store. New segments are rejected once it is exhausted.
+var MemBudget *common.MemoryBudget
+
+// MaxPushBodySize, if non-zero, bounds the size of the segments pushed over
+// HTTP. Larger pushes are rejected as soon as they are found to be too large.
+var MaxPushBodySize int64 = 32 << 20
+
 // For HTTP push watchdog
 var httpPushTimeout = 1 * time.Minute
-var httpPushResetTimer = func() (context.Context, context.CancelFunc) {
-	sleepDur := time.Duration(int64(float64(httpPushTimeout) * 0.9))
-	return context.WithTimeout(context.Background(), sleepDur)
+
+func httpPushResetInterval() time.Duration {
+	return time.Duration(int64(float64(httpPushTimeout) * 0.9))
 }
 
 type rtmpConnection struct {
@@ -76,9 +106,143 @@ type rtmpConnection struct {
 	profile         *ffmpeg.VideoProfile
 	params          *core.StreamParameters
 	sessManager     *BroadcastSessionsManager
-	lastUsed        time.Time
 	sourceBytes     uint64
 	transcodedBytes uint64
+	hlsKeys         *hlsKeyring
+	pushSig         *pushVerifier
+	source          *sourceChecker
+	sanitizer       *tsSanitizer
+	restreams       *restreams
+	cmafInits       cmafInits
+	ladderOnce      sync.Once
+	pipeline        *segmentPipeline
+	journal         *segmentJournal
+	state           *streamState
+	checksums       *recordChecksums
+	// attempts in a row that failed with format errors
+	formatErrors int32
+	// sequence number the stream starts at, following the segments of a
+	// stream taken over from another node of the Cluster or resumed after a
+	// restart
+	startSeqNo uint64
+	// segments processed, and those of them that failed
+	segments       uint64
+	failedSegments uint64
+
+	mu       sync.Mutex // protects stream, lastUsed, nextSeqNo, graceTimer and idleTimer
+	lastUsed time.Time
+	// sequence number following that of the last segment from the publisher
+	nextSeqNo uint64
+	// set while waiting for a disconnected RTMP publisher to reconnect
+	graceTimer Timer
+	// removes the stream once nothing is pushed to it
+	idleTimer *expiryTimer
+}
+
+func (cxn *rtmpConnection) touch(t time.Time) {
+	cxn.mu.Lock()
+	defer cxn.mu.Unlock()
+	cxn.lastUsed = t
+}
+
+func (cxn *rtmpConnection) lastUsedTime() time.Time {
+	cxn.mu.Lock()
+	defer cxn.mu.Unlock()
+	return cxn.lastUsed
+}
+
+// rtmpStream is the current stream of the publisher, which changes when it
+// reconnects
+func (cxn *rtmpConnection) rtmpStream() stream.RTMPVideoStream {
+	cxn.mu.Lock()
+	defer cxn.mu.Unlock()
+	return cxn.stream
+}
+
+func (cxn *rtmpConnection) sawSeqNo(seqNo uint64) {
+	cxn.mu.Lock()
+	defer cxn.mu.Unlock()
+	if seqNo >= cxn.nextSeqNo {
+		cxn.nextSeqNo = seqNo + 1
+	}
+}
+
+// awaitReconnect keeps the connection for its publisher to reconnect to
+// until `grace` expires, then calls `expire`
+func (cxn *rtmpConnection) awaitReconnect(grace time.Duration, expire func()) {
+	cxn.mu.Lock()
+	defer cxn.mu.Unlock()
+	var t Timer
+	t = clock.AfterFunc(grace, func() {
+		cxn.mu.Lock()
+		current := cxn.graceTimer == t
+		cxn.mu.Unlock()
+		if current {
+			expire()
+		}
+	})
+	cxn.graceTimer = t
+}
+
+// resume hands the connection to the reconnected RTMP stream `rtmpStrm` if it
+// is awaiting one, returning the sequence number the stream continues at
+func (cxn *rtmpConnection) resume(rtmpStrm stream.RTMPVideoStream) (uint64, bool) {
+	cxn.mu.Lock()
+	defer cxn.mu.Unlock()
+	// if the timer already fired the connection is being removed
+	if cxn.graceTimer == nil || !cxn.graceTimer.Stop() {
+		return 0, false
+	}
+	cxn.graceTimer = nil
+	cxn.stream = rtmpStrm
+	return cxn.nextSeqNo, true
+}
+
+func (cxn *rtmpConnection) awaitingReconnect() bool {
+	cxn.mu.Lock()
+	defer cxn.mu.Unlock()
+	return cxn.graceTimer != nil
+}
+
+func (cxn *rtmpConnection) stopGrace() {
+	cxn.mu.Lock()
+	defer cxn.mu.Unlock()
+	if cxn.graceTimer != nil {
+		cxn.graceTimer.Stop()
+		cxn.graceTimer = nil
+	}
+}
+
+func (cxn *rtmpConnection) stopIdle() {
+	cxn.mu.Lock()
+	defer cxn.mu.Unlock()
+	if cxn.idleTimer != nil {
+		cxn.idleTimer.stop()
+		cxn.idleTimer = nil
+	}
+}
+
+func (cxn *rtmpConnection) ingestFilter() *common.IPFilter {
+	if cxn.params == nil {
+		return nil
+	}
+	return cxn.params.IngestFilter
+}
+
+func (cxn *rtmpConnection) playbackFilter() *common.IPFilter {
+	if cxn.params == nil {
+		return nil
+	}
+	return cxn.params.PlaybackFilter
+}
+
+// priority returns the priority class of the stream; that of the normal
+// class for streams not created yet
+func (cxn *rtmpConnection) priority() core.StreamPriority {
+	if cxn == nil || cxn.params == nil {
+		return core.PriorityNormal
+	}
+	return cxn.params.Priority
 }
 
 type LivepeerServer struct {
@@ -87,35 +251,116 @@ type LivepeerServer struct {
 	LivepeerNode            *core.LivepeerNode
 	HTTPMux                 *http.ServeMux
 	ExposeCurrentManifest   bool
-	recordingsAuthResponses *cache.Cache
+	recordingsAuthResponses *ttlCache
+	jobs                    *jobQueue
+	middleware              []Middleware
+	segmentMiddleware       []SegmentMiddleware
+
+	// rtmpConnections and streamAliases do their own locking
+	rtmpConnections *connectionMap
+	streamAliases   *streamAliases
+	drain           *broadcastDrain
 
 	// Thread sensitive fields. All accesses to the
 	// following fields should be protected by `connectionLock`
-	rtmpConnections   map[core.ManifestID]*rtmpConnection
-	internalManifests map[core.ManifestID]core.ManifestID
-	lastHLSStreamID   core.StreamID
-	lastManifestID    core.ManifestID
-	connectionLock    *sync.RWMutex
+	lastHLSStreamID core.StreamID
+	lastManifestID  core.ManifestID
+	connectionLock  *sync.RWMutex
 }
 
 type authWebhookResponse struct {
-	ManifestID           string   `json:"manifestID"`
-	StreamKey            string   `json:"streamKey"`
-	Presets              []string `json:"presets"`
-	ObjectStore          string   `json:"objectStore"`
-	RecordObjectStore    string   `json:"recordObjectStore"`
-	RecordObjectStoreURL string   `json:"recordObjectStoreUrl"`
-	Profiles             []struct {
-		Name    string `json:"name"`
-		Width   int    `json:"width"`
-		Height  int    `json:"height"`
-		Bitrate int    `json:"bitrate"`
-		FPS     uint   `json:"fps"`
-		FPSDen  uint   `json:"fpsDen"`
-		Profile string `json:"profile"`
-		GOP     string `json:"gop"`
-	} `json:"profiles"`
-	PreviousSessions []string `json:"previousSessions"`
+	ManifestID           string        `json:"manifestID"`
+	StreamKey            string        `json:"streamKey"`
+	Presets              []string      `json:"presets"`
+	ObjectStore          string        `json:"objectStore"`
+	RecordObjectStore    string        `json:"recordObjectStore"`
+	RecordObjectStoreURL string        `json:"recordObjectStoreUrl"`
+	Profiles             []jsonProfile `json:"profiles"`
+	PreviousSessions     []string      `json:"previousSessions"`
+	// Overrides RecordFlushInterval for the stream if set
+	RecordFlushIntervalMs int64 `json:"recordFlushIntervalMs"`
+	// Client addresses, as CIDRs, that may push or play the stream. Denied
+	// ranges take precedence; if no ranges are allowed, any address may.
+	IngestAllowCIDRs   []string `json:"ingestAllowCidrs"`
+	IngestDenyCIDRs    []string `json:"ingestDenyCidrs"`
+	PlaybackAllowCIDRs []string `json:"playbackAllowCidrs"`
+	PlaybackDenyCIDRs  []string `json:"playbackDenyCidrs"`
+	// If set, pushes to the stream must carry a PushSigHeader signed with it
+	PushSecret string `json:"pushSecret"`
+	// External RTMP(S) endpoints to push the stream to
+	Restream []core.RestreamTarget `json:"restream"`
+	// AutoLadder to derive the renditions from the source instead of
+	// listing them in Profiles or Presets
+	Ladder string `json:"ladder"`
+	// If set, the source is recorded without being transcoded live. The
+	// recording is transcoded into the profiles by a POST to
+	// /recordings/{manifestID}/transcode.
+	RecordOnly bool `json:"recordOnly"`
+	// Base URL of the broadcaster to pin the stream to, among IngestNodes
+	IngestNode string `json:"ingestNode"`
+	// Metadata, such as viewer or epoch IDs, to send orchestrators along
+	// each segment, which log it; updated over /setStreamMetadata. Streams
+	// without it cannot be given metadata later on.
+	Metadata map[string]string `json:"metadata"`
+	// Labels describing the stream, such as its title, customer ID or tags,
+	// surfaced in /status, stream events, usage records and the info.json
+	// of its recording; updated over /setStreamLabels
+	Labels map[string]string `json:"labels"`
+	// Priority class of the stream: low, normal or high. Higher classes get
+	// more orchestrators to fail over to, are served first by the segment
+	// workers and are shed last when the node runs out of memory.
+	Priority string `json:"priority"`
+	// Weights orchestrators are scored by when selected for the stream;
+	// those of the node if unset
+	SelectionWeights *core.SelectionWeights `json:"selectionWeights"`
+	// Duration the source is cut into segments at, within
+	// SegmentDurationMin and SegmentDurationMax; overrides the
+	// segmentDuration query parameter of the ingest URL
+	SegmentDurationMs int64 `json:"segmentDurationMs"`
+	// URL the heartbeats of the stream are posted to, instead of
+	// StreamHeartbeatURL
+	HeartbeatURL string `json:"heartbeatUrl"`
+}
+
+// jsonProfile is a rendition as given in JSON to the auth webhook or the VOD
+// API
+type jsonProfile struct {
+	Name    string `json:"name"`
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	Bitrate int    `json:"bitrate"`
+	FPS     uint   `json:"fps"`
+	FPSDen  uint   `json:"fpsDen"`
+	Profile string `json:"profile"`
+	GOP     string `json:"gop"`
+	// Output format: mpegts, mp4 or cmaf; that of the source if empty
+	Format string `json:"format"`
+	// Codec of audio-only renditions, which set it and no width and height:
+	// only aac, as the audio of the source is carried over as is
+	AudioCodec string `json:"audioCodec"`
+}
+
+// selectionWeights returns the weights orchestrators are scored by when
+// selected for the stream of `params`
+func selectionWeights(params *core.StreamParameters) core.SelectionWeights {
+	if params != nil && params.SelectionWeights != nil {
+		return *params.SelectionWeights
+	}
+	return SelectionWeights
+}
+
+// ipFilters returns the filters for the client addresses of the stream
+func (resp *authWebhookResponse) ipFilters() (ingest, playback *common.IPFilter, err error) {
+	if resp == nil {
+		return nil, nil, nil
+	}
+	if ingest, err = common.NewIPFilter(resp.IngestAllowCIDRs, resp.IngestDenyCIDRs); err != nil {
+		return nil, nil, err
+	}
+	if playback, err = common.NewIPFilter(resp.PlaybackAllowCIDRs, resp.PlaybackDenyCIDRs); err != nil {
+		return nil, nil, err
+	}
+	return ingest, playback, nil
 }
 
 func NewLivepeerServer(rtmpAddr string, lpNode *core.LivepeerNode, httpIngest bool, transcodingOptions string) (*LivepeerServer, error) {
@@ -129,7 +374,10 @@ func NewLivepeerServer(rtmpAddr string, lpNode *core.LivepeerNode, httpIngest bo
 	case core.BroadcasterNode:
 		opts.RtmpDisabled = false
 
-		if transcodingOptions != "" {
+		if transcodingOptions == AutoLadder {
+			// default profiles remain for sources of unknown resolution
+			AutoLadderDefault = true
+		} else if transcodingOptions != "" {
 			var profiles []ffmpeg.VideoProfile
 			content, err := ioutil.ReadFile(transcodingOptions)
 			if err == nil && len(content) > 0 {
@@ -149,19 +397,38 @@ func NewLivepeerServer(rtmpAddr string, lpNode *core.LivepeerNode, httpIngest bo
 			if len(profiles) <= 0 {
 				return nil, fmt.Errorf("No transcoding profiles found")
 			}
+			if err := validateProfiles(profiles); err != nil {
+				return nil, fmt.Errorf("Invalid transcoding profiles: %v", err)
+			}
+			if profiles, err = alignProfileGOPs(profiles); err != nil {
+				return nil, fmt.Errorf("Unable to align transcoding profiles: %v", err)
+			}
 			BroadcastJobVideoProfiles = profiles
 		}
 	}
 	server := lpmscore.New(&opts)
 	ls := &LivepeerServer{RTMPSegmenter: server, LPMS: server, LivepeerNode: lpNode, HTTPMux: opts.HttpMux, connectionLock: &sync.RWMutex{},
-		rtmpConnections:         make(map[core.ManifestID]*rtmpConnection),
-		internalManifests:       make(map[core.ManifestID]core.ManifestID),
-		recordingsAuthResponses: cache.New(time.Hour, 2*time.Hour),
-	}
+		rtmpConnections:         newConnectionMap(),
+		streamAliases:           newStreamAliases(),
+		recordingsAuthResponses: newTTLCache(time.Hour),
+		jobs:                    newJobQueue(lpNode.Database),
+		drain:                   newBroadcastDrain(),
+	}
+	ls.jobs.register(vodJobKind, &jobKind{run: ls.runVODJob, finish: finishVODJob})
+	ls.jobs.register(recordingTranscodeJobKind, &jobKind{run: ls.runRecordingTranscode, finish: finishRecordingTranscode})
+	ls.jobs.register(recordingFinalizeJobKind, &jobKind{run: runRecordingFinalize})
 	if lpNode.NodeType == core.BroadcasterNode && httpIngest {
 		opts.HttpMux.HandleFunc("/live/", ls.HandlePush)
 	}
+	if lpNode.NodeType == core.BroadcasterNode {
+		opts.HttpMux.HandleFunc("/vod", ls.HandleVOD)
+		opts.HttpMux.HandleFunc("/vod/", ls.HandleVOD)
+		opts.HttpMux.HandleFunc(remuxPrefix, ls.HandleRemux)
+	}
 	opts.HttpMux.HandleFunc("/recordings/", ls.HandleRecordings)
+	opts.HttpMux.HandleFunc(hlsKeyPrefix, ls.HandleHLSKey)
+	// Streams taken over by another node are ingested there from now on
+	Cluster.SetLostHandler(func(mid core.ManifestID) { removeRTMPStream(ls, mid) })
 	return ls, nil
 }
 
@@ -170,7 +437,7 @@ func (s *LivepeerServer) StartMediaServer(ctx context.Context, httpAddr string)
 	glog.V(common.SHORT).Infof("Transcode Job Type: %v", BroadcastJobVideoProfiles)
 
 	//LPMS handlers for handling RTMP video
-	s.LPMS.HandleRTMPPublish(createRTMPStreamIDHandler(s), gotRTMPStreamHandler(s), endRTMPStreamHandler(s))
+	s.LPMS.HandleRTMPPublish(AuditLog.RTMPPublishHandlers(createRTMPStreamIDHandler(s), gotRTMPStreamHandler(s), endRTMPStreamHandler(s)))
 	s.LPMS.HandleRTMPPlay(getRTMPStreamHandler(s))
 
 	//LPMS hanlder for handling HLS video play
@@ -187,10 +454,29 @@ func (s *LivepeerServer) StartMediaServer(ctx context.Context, httpAddr string)
 			ec <- s.LPMS.Start(lpmsCtx)
 		}
 	}()
+	go s.jobs.start(lpmsCtx)
 	if s.LivepeerNode.NodeType == core.BroadcasterNode {
+		if SegmentJournalRetention > 0 && s.LivepeerNode.Database != nil {
+			go pruneSegmentJournal(lpmsCtx, s.LivepeerNode.Database)
+		}
+		if SessionSnapshotMaxAge > 0 && s.LivepeerNode.Database != nil {
+			go pruneSessionSnapshots(lpmsCtx, s.LivepeerNode.Database)
+		}
+		if StreamStateMaxAge > 0 && s.LivepeerNode.Database != nil {
+			go pruneStreamStates(lpmsCtx, s.LivepeerNode.Database)
+		}
+		if StreamHeartbeatInterval > 0 {
+			go s.sendStreamHeartbeats(lpmsCtx)
+		}
 		go func() {
-			glog.V(4).Infof("HTTP Server listening on http://%v", httpAddr)
-			ec <- http.ListenAndServe(httpAddr, s.HTTPMux)
+			scheme := "http"
+			if MediaServerConfig.CertFile != "" {
+				scheme = "https"
+			}
+			glog.V(4).Infof("HTTP Server listening on %s://%v", scheme, httpAddr)
+			srv := newHTTPServer(httpAddr, s.filterPlayback(s.redirectClusterPlayback(s.serveStartOver(s.serveDASH(s.mediaHandler())))), MediaServerConfig)
+			srv.Handler = AuditLog.Handler("", srv.Handler)
+			ec <- listenAndServe(srv, MediaServerConfig)
 		}()
 	}
 
@@ -220,8 +506,43 @@ func createRTMPStreamIDHandler(s *LivepeerServer) func(url *url.URL) (strmID str
 		var os, ros drivers.OSDriver
 		var oss, ross drivers.OSSession
 		profiles := []ffmpeg.VideoProfile{}
-		if resp, err = authenticateStream(url.String()); err != nil {
-			glog.Errorf("Authentication denied for streamID url=%s err=%v", url.String(), err)
+		var audioProfiles []ffmpeg.VideoProfile
+		recordFlushInterval := RecordFlushInterval
+		var pushSecret string
+		var restream []core.RestreamTarget
+		var ingestNode string
+		var recordOnly bool
+		var metadata *core.SegmentMetadata
+		var labels map[string]string
+		var priority core.StreamPriority
+		var cmaf map[string]bool
+		var weights *core.SelectionWeights
+		var recordStoreURL, recordExtURL string
+		var previousSessions []string
+		var heartbeatURL string
+		autoLadder := AutoLadderDefault
+		segDuration, err := parseSegmentDuration(url)
+		if err != nil {
+			glog.Errorf("Invalid segment duration for streamID url=%s err=%v", common.RedactURL(url.String()), err)
+			return nil
+		}
+		// Smooth out bursts of new streams before hitting the webhook
+		if err = StreamAdmission.Admit(); err != nil {
+			glog.Errorf("Stream creation not admitted for streamID url=%s err=%v", common.RedactURL(url.String()), err)
+			return nil
+		}
+		if ProvisionedStreams != nil {
+			resp, err = ProvisionedStreams.authenticate(url)
+		} else {
+			resp, err = authenticateIngest(url.String())
+		}
+		if err != nil {
+			glog.Errorf("Authentication denied for streamID url=%s err=%v", common.RedactURL(url.String()), err)
+			return nil
+		}
+		ingestFilter, playbackFilter, err := resp.ipFilters()
+		if err != nil {
+			glog.Errorf("Failed to parse allowed addresses for streamID url=%s err=%v", common.RedactURL(url.String()), err)
 			return nil
 		}
 		if resp != nil {
@@ -233,44 +554,153 @@ func createRTMPStreamIDHandler(s *LivepeerServer) func(url *url.URL) (strmID str
 
 			parsedProfiles, err := jsonProfileToVideoProfile(resp)
 			if err != nil {
-				glog.Errorf("Failed to parse JSON video profile for streamID url=%s err=%v", url.String(), err)
+				glog.Errorf("Failed to parse JSON video profile for streamID url=%s err=%v", common.RedactURL(url.String()), err)
 				return nil
 			}
 			profiles = append(profiles, parsedProfiles...)
+			cmaf = cmafRenditions(resp.Profiles, parsedProfiles)
+			profiles, audioProfiles = splitAudioProfiles(profiles)
 
 			// Only set defaults if user did not specify a preset/profile
-			if len(resp.Profiles) <= 0 && len(resp.Presets) <= 0 {
+			// other than audio-only ones
+			if len(resp.Profiles) <= len(audioProfiles) && len(resp.Presets) <= 0 {
 				profiles = BroadcastJobVideoProfiles
+			} else {
+				autoLadder = false
+				if err := validateProfiles(profiles); err != nil {
+					glog.Errorf("Invalid profiles for streamID url=%s err=%v", common.RedactURL(url.String()), err)
+					return nil
+				}
+				if profiles, err = alignProfileGOPs(profiles); err != nil {
+					glog.Errorf("Unable to align profiles for streamID url=%s err=%v", common.RedactURL(url.String()), err)
+					return nil
+				}
+			}
+			if err := validateAudioProfiles(profiles, audioProfiles); err != nil {
+				glog.Errorf("Invalid audio-only profiles for streamID url=%s err=%v", common.RedactURL(url.String()), err)
+				return nil
+			}
+			switch resp.Ladder {
+			case "":
+			case AutoLadder:
+				autoLadder = true
+			default:
+				glog.Errorf("Unknown ladder for streamID url=%s ladder=%q", common.RedactURL(url.String()), resp.Ladder)
+				return nil
 			}
 
 			// set OS if it was provided
 			if resp.ObjectStore != "" {
 				os, err = drivers.ParseOSURL(resp.ObjectStore, false)
 				if err != nil {
-					glog.Errorf("Failed to parse object store url for streamID url=%s err=%v", url.String(), err)
+					glog.Errorf("Failed to parse object store url for streamID url=%s err=%v", common.RedactURL(url.String()), err)
 					return nil
 				}
 			}
+			recordStoreURL, recordExtURL = resp.RecordObjectStore, resp.RecordObjectStoreURL
+			previousSessions = resp.PreviousSessions
 			// set Recording OS if it was provided
 			if resp.RecordObjectStore != "" {
 				ros, err = drivers.ParseOSURL(resp.RecordObjectStore, true)
 				if err != nil {
-					glog.Errorf("Failed to parse recording object store url for streamID url=%s err=%v", url.String(), err)
+					glog.Errorf("Failed to parse recording object store url for streamID url=%s err=%v", common.RedactURL(url.String()), err)
+					return nil
+				}
+			}
+			if resp.RecordFlushIntervalMs > 0 {
+				recordFlushInterval = time.Duration(resp.RecordFlushIntervalMs) * time.Millisecond
+			}
+			pushSecret = resp.PushSecret
+			if err := validateRestreamTargets(resp.Restream, profiles); err != nil {
+				glog.Errorf("Invalid restream targets for streamID url=%s err=%v", common.RedactURL(url.String()), err)
+				return nil
+			}
+			restream = resp.Restream
+			ingestNode = resp.IngestNode
+			recordOnly = resp.RecordOnly
+			if resp.Metadata != nil {
+				if err := validateSegmentMetadata(resp.Metadata); err != nil {
+					glog.Errorf("Invalid metadata for streamID url=%s err=%v", common.RedactURL(url.String()), err)
+					return nil
+				}
+				metadata = core.NewSegmentMetadata(resp.Metadata)
+			}
+			if err := validateStreamLabels(resp.Labels); err != nil {
+				glog.Errorf("Invalid labels for streamID url=%s err=%v", common.RedactURL(url.String()), err)
+				return nil
+			}
+			labels = resp.Labels
+			if priority, err = core.ParseStreamPriority(resp.Priority); err != nil {
+				glog.Errorf("Invalid priority for streamID url=%s err=%v", common.RedactURL(url.String()), err)
+				return nil
+			}
+			if resp.SelectionWeights != nil {
+				if err := resp.SelectionWeights.Validate(); err != nil {
+					glog.Errorf("Invalid selection weights for streamID url=%s err=%v", common.RedactURL(url.String()), err)
+					return nil
+				}
+				weights = resp.SelectionWeights
+			}
+			if resp.SegmentDurationMs > 0 {
+				segDuration = time.Duration(resp.SegmentDurationMs) * time.Millisecond
+			}
+			if resp.HeartbeatURL != "" {
+				if err := validateHeartbeatURL(resp.HeartbeatURL); err != nil {
+					glog.Errorf("Invalid heartbeat URL for streamID url=%s err=%v", common.RedactURL(url.String()), err)
 					return nil
 				}
+				heartbeatURL = resp.HeartbeatURL
 			}
 		} else {
 			profiles = BroadcastJobVideoProfiles
 		}
+		if segDuration > 0 {
+			if err := validateSegmentDuration(segDuration, profiles); err != nil {
+				glog.Errorf("Invalid segment duration for streamID url=%s err=%v", common.RedactURL(url.String()), err)
+				return nil
+			}
+		}
 
 		sid := parseStreamID(url.Path)
 		extmid := sid.ManifestID
+		hookMid := mid
 		if mid == "" {
 			mid, key = sid.ManifestID, sid.Rendition
 		}
+		for _, m := range []core.ManifestID{mid, extmid} {
+			if m == "" {
+				continue
+			}
+			if err := ManifestIDPolicy.validate(m); err != nil {
+				glog.Errorf("Invalid manifestID for streamID url=%s err=%v", common.RedactURL(url.String()), err)
+				return nil
+			}
+		}
+		// Streams pushed again after a restart resume where they were, with
+		// the renditions they had unless the webhook gave them others. A new
+		// manifest ID from the webhook starts the stream over.
+		if prev := loadStreamState(s.LivepeerNode.Database, extmid); prev != nil && (hookMid == "" || hookMid == prev.ManifestID) {
+			mid = prev.ManifestID
+			hookLadder := resp != nil && (len(resp.Profiles) > 0 || len(resp.Presets) > 0 || resp.Ladder != "")
+			if !hookLadder {
+				profiles, audioProfiles, autoLadder = prev.Profiles, prev.AudioProfiles, false
+				if prev.SegmentDuration > 0 && (resp == nil || resp.SegmentDurationMs <= 0) {
+					segDuration = prev.SegmentDuration
+				}
+			}
+			glog.Infof("Resuming saved stream manifestID=%s externalManifestID=%s nextSeqNo=%d webhookLadder=%v", mid, extmid, prev.NextSeqNo, hookLadder)
+		}
 		if mid == "" {
 			mid = core.RandomManifestID()
 		}
+		if ingestNode, err = IngestNodes.pinnedNode(mid, ingestNode); err != nil {
+			glog.Errorf("Invalid ingest node for streamID url=%s err=%v", common.RedactURL(url.String()), err)
+			return nil
+		}
+		if ingestNode != "" {
+			// Rejected or redirected once the stream is pushed
+			return &core.StreamParameters{ManifestID: mid, IngestNode: ingestNode}
+		}
 		// Generate RTMP part of StreamID
 		if key == "" {
 			key = common.RandomIDGenerator(StreamKeyBytes)
@@ -286,20 +716,42 @@ func createRTMPStreamIDHandler(s *LivepeerServer) func(url *url.URL) (strmID str
 		} else if drivers.RecordStorage != nil {
 			ross = drivers.RecordStorage.NewSession(recordPath)
 		}
-		// Ensure there's no concurrent StreamID with the same name
-		s.connectionLock.RLock()
-		defer s.connectionLock.RUnlock()
-		if core.MaxSessions > 0 && len(s.rtmpConnections) >= core.MaxSessions {
-			glog.Errorf("Too many connections for streamID url=%s err=%v", url.String(), err)
+		if recordOnly && ross == nil {
+			glog.Errorf("Record only stream without a record store for streamID url=%s", common.RedactURL(url.String()))
+			return nil
+		}
+		// Fast path - the limit is enforced when the connection is stored
+		if core.MaxSessions > 0 && s.rtmpConnections.len() >= core.MaxSessions && !s.awaitingReconnect(mid) {
+			glog.Errorf("Too many connections for streamID url=%s err=%v", common.RedactURL(url.String()), err)
 			return nil
 		}
 		return &core.StreamParameters{
 			ManifestID: mid,
 			RtmpKey:    key,
 			// HTTP push mutates `profiles` so make a copy of it
-			Profiles: append([]ffmpeg.VideoProfile(nil), profiles...),
-			OS:       oss,
-			RecordOS: ross,
+			Profiles:      append([]ffmpeg.VideoProfile(nil), profiles...),
+			AudioProfiles: audioProfiles,
+			OS:            oss,
+			RecordOS:      ross,
+
+			RecordFlushInterval: recordFlushInterval,
+			IngestFilter:        ingestFilter,
+			PlaybackFilter:      playbackFilter,
+			PushSecret:          pushSecret,
+			Restream:            restream,
+			AutoLadder:          autoLadder,
+			RecordOnly:          recordOnly,
+			Metadata:            metadata,
+			Labels:              core.NewStreamLabels(labels),
+			Priority:            priority,
+			RecordingID:         extmid,
+			PreviousSessions:    previousSessions,
+			RecordStore:         recordStoreURL,
+			RecordExtURL:        recordExtURL,
+			CMAF:                cmaf,
+			SelectionWeights:    weights,
+			SegmentDuration:     segDuration,
+			HeartbeatURL:        heartbeatURL,
 		}
 	}
 }
@@ -314,15 +766,34 @@ func authenticateStream(url string) (*authWebhookResponse, error) {
 	if err != nil {
 		return nil, err
 	}
-	resp, err := http.Post(AuthWebhookURL, "application/json", bytes.NewBuffer(jsonValue))
+	req, err := http.NewRequest("POST", AuthWebhookURL, bytes.NewBuffer(jsonValue))
+	if err != nil {
+		return nil, common.RedactError(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if AuthWebhookSecret != "" {
+		req.Header.Set(WebhookSigHeader, signWebhook(AuthWebhookSecret, clock.Now(), jsonValue))
+	}
+	resp, err := http.DefaultClient.Do(req)
 
 	if err != nil {
-		return nil, err
+		return nil, wrapError(ErrorCategoryAuth, true, common.RedactError(err))
 	}
 	rbody, err := ioutil.ReadAll(resp.Body)
 	resp.Body.Close()
+	if err != nil {
+		return nil, wrapError(ErrorCategoryAuth, true, err)
+	}
 	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("status=%d error=%s", resp.StatusCode, string(rbody))
+		// Denials won't change with retries, unlike failures of the webhook
+		retryable := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return nil, wrapError(ErrorCategoryAuth, retryable, fmt.Errorf("status=%d error=%s", resp.StatusCode, string(rbody)))
+	}
+	if AuthWebhookVerifyResponse {
+		if err := verifyWebhook(AuthWebhookSecret, resp.Header.Get(WebhookSigHeader), rbody, clock.Now()); err != nil {
+			glog.Errorf("Rejecting auth webhook response for url=%s err=%v", common.RedactURL(url), err)
+			return nil, err
+		}
 	}
 	if len(rbody) == 0 {
 		return nil, nil
@@ -336,7 +807,7 @@ func authenticateStream(url string) (*authWebhookResponse, error) {
 		return nil, errors.New("Empty manifest id not allowed")
 	}
 	took := time.Since(started)
-	glog.Infof("Stream authentication for url=%s dur=%s", url, took)
+	glog.Infof("Stream authentication for url=%s dur=%s", common.RedactURL(url), took)
 	if monitor.Enabled {
 		monitor.AuthWebhookFinished(took)
 	}
@@ -344,8 +815,14 @@ func authenticateStream(url string) (*authWebhookResponse, error) {
 }
 
 func jsonProfileToVideoProfile(resp *authWebhookResponse) ([]ffmpeg.VideoProfile, error) {
+	return parseJSONProfiles(resp.Profiles)
+}
+
+// parseJSONProfiles returns the video profiles of renditions given in JSON,
+// including those of audio-only renditions
+func parseJSONProfiles(jsonProfiles []jsonProfile) ([]ffmpeg.VideoProfile, error) {
 	profiles := []ffmpeg.VideoProfile{}
-	for _, profile := range resp.Profiles {
+	for _, profile := range jsonProfiles {
 		name := profile.Name
 		if name == "" {
 			name = "webhook_" + common.DefaultProfileName(
@@ -353,6 +830,14 @@ func jsonProfileToVideoProfile(resp *authWebhookResponse) ([]ffmpeg.VideoProfile
 				profile.Height,
 				profile.Bitrate)
 		}
+		if profile.AudioCodec != "" && profile.Width == 0 && profile.Height == 0 {
+			prof, err := audioProfile(name, profile)
+			if err != nil {
+				return nil, err
+			}
+			profiles = append(profiles, prof)
+			continue
+		}
 		var gop time.Duration
 		if profile.GOP != "" {
 			if profile.GOP == "intra" {
@@ -372,6 +857,10 @@ func jsonProfileToVideoProfile(resp *authWebhookResponse) ([]ffmpeg.VideoProfile
 		if err != nil {
 			return nil, err
 		}
+		format, err := jsonProfileFormat(profile.Format)
+		if err != nil {
+			return nil, err
+		}
 		prof := ffmpeg.VideoProfile{
 			Name:         name,
 			Bitrate:      fmt.Sprint(profile.Bitrate),
@@ -380,6 +869,7 @@ func jsonProfileToVideoProfile(resp *authWebhookResponse) ([]ffmpeg.VideoProfile
 			Resolution:   fmt.Sprintf("%dx%d", profile.Width, profile.Height),
 			Profile:      encodingProfile,
 			GOP:          gop,
+			Format:       format,
 		}
 		profiles = append(profiles, prof)
 	}
@@ -397,39 +887,72 @@ func streamParams(d stream.AppData) *core.StreamParameters {
 
 func gotRTMPStreamHandler(s *LivepeerServer) func(url *url.URL, rtmpStrm stream.RTMPVideoStream) (err error) {
 	return func(url *url.URL, rtmpStrm stream.RTMPVideoStream) (err error) {
+		// RTMP has no redirects, so publishers must reach the pinned node
+		if params := streamParams(rtmpStrm.AppData()); params != nil && params.IngestNode != "" {
+			glog.Errorf("Rejecting RTMP stream pinned to another node url=%s manifestID=%s node=%s", common.RedactURL(url.String()), params.ManifestID, params.IngestNode)
+			return errPinnedElsewhere
+		}
 
-		cxn, err := s.registerConnection(rtmpStrm)
-		if err != nil {
-			return err
+		cxn, startSeq, resumed := s.resumeConnection(rtmpStrm)
+		if !resumed {
+			if cxn, err = s.registerConnection(rtmpStrm); err != nil {
+				return err
+			}
+			startSeq = cxn.startSeqNo
 		}
 
 		mid := cxn.mid
 		nonce := cxn.nonce
-		startSeq := 0
 
-		streamStarted := false
+		streamStarted := resumed
 		//Segment the stream, insert the segments into the broadcaster
 		go func(rtmpStrm stream.RTMPVideoStream) {
 			hid := string(core.RandomManifestID()) // ffmpeg m3u8 output name
 			hlsStrm := stream.NewBasicHLSVideoStream(hid, stream.DefaultHLSStreamWin)
-			hlsStrm.SetSubscriber(func(seg *stream.HLSSegment, eof bool) {
-				if eof {
-					// XXX update HLS manifest
-					return
-				}
+			submit := func(seg *stream.HLSSegment) {
+				cxn.sawSeqNo(seg.SeqNo)
 				if !streamStarted {
 					streamStarted = true
 					if monitor.Enabled {
 						monitor.StreamStarted(nonce)
 					}
 				}
-				go processSegment(cxn, seg)
-			})
+				if err := SegmentWorkers.Submit(string(mid), cxn.priority(), func() { processSegment(cxn, seg, nil) }); err != nil {
+					glog.Errorf("Dropping segment manifestID=%s nonce=%d seqNo=%d err=%v", mid, nonce, seg.SeqNo, err)
+				}
+			}
 
 			segOptions := segmenter.SegmenterOptions{
-				StartSeq:  startSeq,
-				SegLength: SegLen,
+				StartSeq:  int(startSeq),
+				SegLength: streamSegLen(cxn.params),
 			}
+			var joiner *segmentJoiner
+			if KeyframeSegmentation != nil {
+				joiner = newSegmentJoiner(string(mid), *KeyframeSegmentation)
+				segOptions.EnforceKeyframe = true
+				segOptions.SegLength = KeyframeSegmentation.Min
+			}
+
+			hlsStrm.SetSubscriber(func(seg *stream.HLSSegment, eof bool) {
+				if joiner == nil {
+					if eof {
+						// XXX update HLS manifest
+						return
+					}
+					submit(seg)
+					return
+				}
+				var segs []*stream.HLSSegment
+				if eof {
+					segs = joiner.flush()
+				} else {
+					segs = joiner.add(seg)
+				}
+				for _, seg := range segs {
+					submit(seg)
+				}
+			})
+
 			err := s.RTMPSegmenter.SegmentRTMPToHLS(context.Background(), rtmpStrm, hlsStrm, segOptions)
 			if err != nil {
 				// Stop the incoming RTMP connection.
@@ -439,6 +962,11 @@ func gotRTMPStreamHandler(s *LivepeerServer) func(url *url.URL, rtmpStrm stream.
 
 		}(rtmpStrm)
 
+		if resumed {
+			glog.Infof("Publisher reconnected to stream manifestID=%s nonce=%d seqNo=%d", mid, nonce, startSeq)
+			return nil
+		}
+
 		if monitor.Enabled {
 			monitor.StreamCreated(string(mid), nonce)
 		}
@@ -456,6 +984,10 @@ func endRTMPStreamHandler(s *LivepeerServer) func(url *url.URL, rtmpStrm stream.
 			return errMismatchedParams
 		}
 
+		if RTMPReconnectGrace > 0 && s.holdConnection(params.ManifestID, rtmpStrm) {
+			return nil
+		}
+
 		//Remove RTMP stream
 		err := removeRTMPStream(s, params.ManifestID)
 		if err != nil {
@@ -465,6 +997,52 @@ func endRTMPStreamHandler(s *LivepeerServer) func(url *url.URL, rtmpStrm stream.
 	}
 }
 
+// holdConnection keeps the connection of the RTMP stream `rtmpStrm` that just
+// ended for RTMPReconnectGrace, for its publisher to reconnect to
+func (s *LivepeerServer) holdConnection(mid core.ManifestID, rtmpStrm stream.RTMPVideoStream) bool {
+	cxn, ok := s.rtmpConnections.get(mid)
+	if !ok || cxn.rtmpStream() != rtmpStrm {
+		return false
+	}
+	glog.Infof("Publisher disconnected, keeping stream for reconnect manifestID=%s nonce=%d grace=%s", mid, cxn.nonce, RTMPReconnectGrace)
+	sendStreamEvent(newLifecycleEvent(cxn, streamIdleEvent))
+	cxn.awaitReconnect(RTMPReconnectGrace, func() {
+		glog.Infof("Publisher did not reconnect manifestID=%s nonce=%d", mid, cxn.nonce)
+		if cur, ok := s.rtmpConnections.get(mid); ok && cur == cxn {
+			removeRTMPStream(s, mid)
+		}
+	})
+	return true
+}
+
+// resumeConnection hands a connection awaiting its publisher to reconnect to
+// the new RTMP stream `rtmpStrm` of the same manifest ID, marking a
+// discontinuity in the playlists. Returns the sequence number the stream
+// continues at.
+func (s *LivepeerServer) resumeConnection(rtmpStrm stream.RTMPVideoStream) (*rtmpConnection, uint64, bool) {
+	params := streamParams(rtmpStrm.AppData())
+	if params == nil {
+		return nil, 0, false
+	}
+	cxn, ok := s.rtmpConnections.get(params.ManifestID)
+	if !ok {
+		return nil, 0, false
+	}
+	seqNo, ok := cxn.resume(rtmpStrm)
+	if !ok {
+		return nil, 0, false
+	}
+	cxn.pl.MarkDiscontinuity(seqNo)
+	cxn.source.reset()
+	cxn.sanitizer.reset()
+	return cxn, seqNo, true
+}
+
+func (s *LivepeerServer) awaitingReconnect(mid core.ManifestID) bool {
+	cxn, ok := s.rtmpConnections.get(mid)
+	return ok && cxn.awaitingReconnect()
+}
+
 func (s *LivepeerServer) registerConnection(rtmpStrm stream.RTMPVideoStream) (*rtmpConnection, error) {
 	nonce := rand.Uint64()
 
@@ -474,6 +1052,14 @@ func (s *LivepeerServer) registerConnection(rtmpStrm stream.RTMPVideoStream) (*r
 		return nil, errMismatchedParams
 	}
 	mid := params.ManifestID
+	// Draining broadcasters let live streams carry on but take no new ones
+	if s.drain.isDraining() {
+		if cxn, exists := s.rtmpConnections.get(mid); exists {
+			return cxn, errAlreadyExists
+		}
+		glog.Errorf("Rejecting stream while draining manifestID=%s", mid)
+		return nil, errDraining
+	}
 	if drivers.NodeStorage == nil {
 		glog.Error("Missing node storage")
 		return nil, errStorage
@@ -482,10 +1068,20 @@ func (s *LivepeerServer) registerConnection(rtmpStrm stream.RTMPVideoStream) (*r
 	if params.Resolution == "" {
 		params.Resolution = fmt.Sprintf("%vx%v", rtmpStrm.Width(), rtmpStrm.Height())
 	}
+	if err := SourceCaps.checkResolution(params.Resolution); err != nil {
+		glog.Errorf("Rejecting stream manifestID=%s err=%v", mid, err)
+		return nil, err
+	}
 	if params.OS == nil {
 		params.OS = drivers.NodeStorage.NewSession(string(mid))
 	}
 	storage := params.OS
+	if params.Labels == nil {
+		params.Labels = core.NewStreamLabels(nil)
+	}
+	if params.AutoLadder {
+		applyAutoLadder(params)
+	}
 
 	// Generate and set capabilities
 	caps, err := core.JobCapabilities(params)
@@ -502,20 +1098,47 @@ func (s *LivepeerServer) registerConnection(rtmpStrm stream.RTMPVideoStream) (*r
 		Format:     params.Format,
 	}
 	hlsStrmID := core.MakeStreamID(mid, &vProfile)
-	s.connectionLock.RLock()
 	// Fast path - check early if session exists - creating new session can take time
-	oldCxn, exists := s.rtmpConnections[mid]
-	s.connectionLock.RUnlock()
+	oldCxn, exists := s.rtmpConnections.get(mid)
 	if exists {
 		// We can only have one concurrent stream per ManifestID
 		return oldCxn, errAlreadyExists
 	}
+	startSeqNo, takeover, err := Cluster.claim(mid, params.Profiles)
+	if err != nil {
+		return nil, err
+	}
+	state, resumeSeqNo, resumed := newStreamState(s.LivepeerNode.Database, params)
+	if resumed {
+		glog.Infof("Resuming stream after restart manifestID=%s nextSeqNo=%d", mid, resumeSeqNo)
+		if resumeSeqNo > startSeqNo {
+			startSeqNo = resumeSeqNo
+		}
+	}
 
 	playlist := core.NewBasicPlaylistManager(mid, storage, recordStorage)
+	playlist.SetRecordFlushInterval(params.RecordFlushInterval)
+	if history := recordHistory(); history > 0 {
+		// only streams that may be played back from the past keep their history
+		playlist.SetRecordHistory(history)
+	}
+	if takeover || resumed {
+		// the stream continues from where it was interrupted on another node
+		// or before a restart
+		playlist.MarkDiscontinuity(startSeqNo)
+	}
 	var stakeRdr stakeReader
 	if s.LivepeerNode.Eth != nil {
 		stakeRdr = &storeStakeReader{store: s.LivepeerNode.Database}
 	}
+	var driftLimit time.Duration
+	if AVSyncCorrection {
+		driftLimit = AVSyncDriftLimit
+	}
+	source := newSourceChecker(string(mid), func(w sourceWarning) {
+		sendStreamEvent(&streamEvent{Event: "sourceWarning", ManifestID: string(mid), Time: clock.Now().Unix(), Labels: params.Labels.Get(), Warning: &w})
+	})
+	source.segLen = streamSegLen(params)
 	cxn := &rtmpConnection{
 		mid:         mid,
 		nonce:       nonce,
@@ -523,29 +1146,49 @@ func (s *LivepeerServer) registerConnection(rtmpStrm stream.RTMPVideoStream) (*r
 		pl:          playlist,
 		profile:     &vProfile,
 		params:      params,
-		sessManager: NewSessionManager(s.LivepeerNode, params, NewMinLSSelector(stakeRdr, 1.0)),
-		lastUsed:    time.Now(),
+		sessManager: NewSessionManager(s.LivepeerNode, params, NewMinLSSelectorWithWeights(stakeRdr, 1.0, selectionWeights(params))),
+		hlsKeys:     newHLSKeyring(mid, HLSKeyRotation, HLSKeyServer),
+		pushSig:     newPushVerifier(params.PushSecret),
+		source:      source,
+		sanitizer:   newTSSanitizer(string(mid), SanitizeTimestamps, driftLimit),
+		restreams:   newRestreams(string(mid), params.Restream),
+		pipeline:    newSegmentPipeline(s.segmentMiddleware),
+		journal:     newSegmentJournal(s.LivepeerNode.Database, mid),
+		state:       state,
+		checksums:   newRecordChecksums(mid, recordStorage),
+		startSeqNo:  startSeqNo,
+		lastUsed:    clock.Now(),
+		nextSeqNo:   startSeqNo,
 	}
 
 	s.connectionLock.Lock()
-	oldCxn, exists = s.rtmpConnections[mid]
 	// Check if session exist again - potentially two sessions can be created simultaneously,
 	// so we don't want to overwrite one that was already created
-	if exists {
-		// We can only have one concurrent stream per ManifestID
+	oldCxn, exists, err = s.rtmpConnections.loadOrStore(mid, cxn, core.MaxSessions)
+	if exists || err != nil {
 		s.connectionLock.Unlock()
 		cxn.sessManager.cleanup()
+		cxn.restreams.stop()
+		if err != nil {
+			glog.Errorf("Too many connections for manifestID=%s max=%d", mid, core.MaxSessions)
+			Cluster.release(mid)
+			return nil, err
+		}
+		// We can only have one concurrent stream per ManifestID
 		return oldCxn, errAlreadyExists
 	}
-	s.rtmpConnections[mid] = cxn
 	s.lastManifestID = mid
 	s.lastHLSStreamID = hlsStrmID
-	sessionsNumber := len(s.rtmpConnections)
+	sessionsNumber := s.rtmpConnections.len()
 	s.connectionLock.Unlock()
 
 	if monitor.Enabled {
 		monitor.CurrentSessions(sessionsNumber)
 	}
+	if len(params.Labels.Get()) > 0 {
+		go saveRecordingInfo(cxn)
+	}
+	sendStreamEvent(newLifecycleEvent(cxn, streamStartedEvent))
 
 	return cxn, nil
 }
@@ -553,34 +1196,129 @@ func (s *LivepeerServer) registerConnection(rtmpStrm stream.RTMPVideoStream) (*r
 func removeRTMPStream(s *LivepeerServer, extmid core.ManifestID) error {
 	s.connectionLock.Lock()
 	defer s.connectionLock.Unlock()
-	intmid := extmid
-	if _intmid, exists := s.internalManifests[extmid]; exists {
-		// Use the internal manifestID that was stored for the provided manifestID
-		// to index into rtmpConnections
-		intmid = _intmid
-	}
-	cxn, ok := s.rtmpConnections[intmid]
+	// Use the internal manifestID bound to the provided manifestID, if any,
+	// to index into rtmpConnections
+	intmid := s.streamAliases.resolve(extmid)
+	cxn, ok := s.rtmpConnections.get(intmid)
 	if !ok || cxn.pl == nil {
 		glog.Warningf("Attempted to end unknown stream with manifestID=%s", extmid)
 		return errUnknownStream
 	}
-	cxn.stream.Close()
+	cxn.stopGrace()
+	cxn.stopIdle()
+	cxn.rtmpStream().Close()
+	cxn.restreams.stop()
 	cxn.sessManager.cleanup()
+	cxn.sessManager.snapshots.clear()
+	cxn.state.clear()
 	cxn.pl.Cleanup()
 	glog.Infof("Ended stream with manifestID=%s external manifestID=%s", intmid, extmid)
-	delete(s.rtmpConnections, intmid)
-	delete(s.internalManifests, extmid)
+	if RecordingMP4 && cxn.params.RecordOS != nil {
+		s.queueRecordingFinalize(cxn.params)
+	}
+	s.rtmpConnections.delete(intmid)
+	s.streamAliases.unbind(intmid)
+	Cluster.release(intmid)
 
 	if monitor.Enabled {
 		monitor.StreamEnded(cxn.nonce)
-		monitor.CurrentSessions(len(s.rtmpConnections))
+		monitor.CurrentSessions(s.rtmpConnections.len())
 	}
+	sendStreamEvent(newLifecycleEvent(cxn, streamEndedEvent))
 
 	return nil
 }
 
+// handleTerminateStream ends the stream of a DELETE /live/{manifestID}
+// request, given by its internal or external manifest ID, and answers with
+// its final StreamStatus. Only -cliUsers with the operator role may end
+// streams.
+func (s *LivepeerServer) handleTerminateStream(w http.ResponseWriter, r *http.Request) {
+	if CLIAuth == nil {
+		respondWithError(w, "ending streams needs -cliUsers", http.StatusForbidden)
+		return
+	}
+	if !CLIAuth.authorize(w, r, CLIRoleOperator) {
+		return
+	}
+	extmid := parseManifestID(r.URL.Path)
+	st, ok := s.streamStatusOf(extmid)
+	cxn, exists := s.rtmpConnections.get(core.ManifestID(st.ManifestID))
+	if !ok || !exists || removeRTMPStream(s, extmid) != nil {
+		respondWithError(w, "stream not found", http.StatusNotFound)
+		return
+	}
+	// Segments in flight may have added to the counters until the end
+	st.SourceBytes = atomic.LoadUint64(&cxn.sourceBytes)
+	st.TranscodedBytes = atomic.LoadUint64(&cxn.transcodedBytes)
+	glog.Infof("Terminated stream manifestID=%s addr=%s sourceBytes=%d transcodedBytes=%d", st.ManifestID, r.RemoteAddr, st.SourceBytes, st.TranscodedBytes)
+	data, err := json.Marshal(st)
+	if err != nil {
+		respondWith500(w, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
 //End RTMP Publish Handlers
 
+// playbackManifestID returns the manifest ID of /stream/ and /hlskeys/
+// requests
+func (s *LivepeerServer) playbackManifestID(r *http.Request) (core.ManifestID, bool) {
+	var mid core.ManifestID
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/stream/"):
+		mid = parseManifestID(r.URL.Path)
+	case strings.HasPrefix(r.URL.Path, hlsKeyPrefix):
+		mid = core.ManifestID(strings.SplitN(strings.TrimPrefix(r.URL.Path, hlsKeyPrefix), "/", 2)[0])
+	default:
+		return "", false
+	}
+	if s.ExposeCurrentManifest && strings.ToLower(r.URL.Path) == "/stream/current.m3u8" {
+		mid = s.LastManifestID()
+	}
+	return mid, true
+}
+
+// filterPlayback refuses /stream/ and /hlskeys/ requests from addresses the
+// stream's PlaybackFilter does not allow. /recordings/ are checked by HandleRecordings.
+func (s *LivepeerServer) filterPlayback(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mid, ok := s.playbackManifestID(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if cxn, ok := s.rtmpConnections.get(mid); ok && !cxn.playbackFilter().Allowed(common.RemoteIP(r.RemoteAddr)) {
+			glog.Errorf("Rejecting playback request from disallowed address url=%s addr=%s", common.RedactURL(r.URL.String()), r.RemoteAddr)
+			http.Error(w, "Address not allowed", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// redirectClusterPlayback redirects /stream/ and /hlskeys/ requests for
+// streams ingested by another node of the Cluster to that node
+func (s *LivepeerServer) redirectClusterPlayback(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mid, ok := s.playbackManifestID(r)
+		if !ok || Cluster == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		mid = s.streamAliases.resolve(mid)
+		if _, local := s.rtmpConnections.get(mid); !local {
+			if node := Cluster.remoteNode(mid); node != "" {
+				http.Redirect(w, r, node+r.URL.RequestURI(), http.StatusTemporaryRedirect)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 //HLS Play Handlers
 func getHLSMasterPlaylistHandler(s *LivepeerServer) func(url *url.URL) (*m3u8.MasterPlaylist, error) {
 	return func(url *url.URL) (*m3u8.MasterPlaylist, error) {
@@ -596,9 +1334,7 @@ func getHLSMasterPlaylistHandler(s *LivepeerServer) func(url *url.URL) (*m3u8.Ma
 			manifestID = sid.ManifestID
 		}
 
-		s.connectionLock.RLock()
-		defer s.connectionLock.RUnlock()
-		cxn, ok := s.rtmpConnections[manifestID]
+		cxn, ok := s.rtmpConnections.get(manifestID)
 		if !ok || cxn.pl == nil {
 			return nil, vidplayer.ErrNotFound
 		}
@@ -615,19 +1351,25 @@ func getHLSMediaPlaylistHandler(s *LivepeerServer) func(url *url.URL) (*m3u8.Med
 	return func(url *url.URL) (*m3u8.MediaPlaylist, error) {
 		strmID := parseStreamID(url.Path)
 		mid := strmID.ManifestID
-		s.connectionLock.RLock()
-		defer s.connectionLock.RUnlock()
-		cxn, ok := s.rtmpConnections[mid]
+		cxn, ok := s.rtmpConnections.get(mid)
 		if !ok || cxn.pl == nil {
 			return nil, vidplayer.ErrNotFound
 		}
 
+		if msn, ok := blockingReloadMSN(url); ok && !awaitPlaylistSegment(cxn.pl, strmID.Rendition, msn) {
+			return nil, vidplayer.ErrBadRequest
+		}
+
+		if pl := dvrMediaPlaylist(cxn, strmID.Rendition, clock.Now()); pl != nil {
+			return pl, nil
+		}
+
 		//Get the hls playlist
 		pl := cxn.pl.GetHLSMediaPlaylist(strmID.Rendition)
 		if pl == nil {
 			return nil, vidplayer.ErrNotFound
 		}
-		return pl, nil
+		return cxn.hlsKeys.encryptPlaylist(pl)
 	}
 }
 
@@ -655,10 +1397,16 @@ func getHLSSegmentHandler(s *LivepeerServer) func(url *url.URL) ([]byte, error)
 			return nil, vidplayer.ErrNotFound
 		}
 		data := os.GetData(segName)
-		if len(data) > 0 {
-			return data, nil
+		if len(data) == 0 {
+			return nil, vidplayer.ErrNotFound
+		}
+		// CMAF segments are not encrypted as a whole
+		if cxn, ok := s.rtmpConnections.get(core.ManifestID(parts[0])); ok && cxn.hlsKeys != nil && path.Ext(segName) != core.CMAFSegmentExt {
+			if seq, ok := segmentSeq(segName); ok {
+				return cxn.hlsKeys.encrypt(seq, data)
+			}
 		}
-		return nil, vidplayer.ErrNotFound
+		return data, nil
 	}
 }
 
@@ -668,40 +1416,113 @@ func getHLSSegmentHandler(s *LivepeerServer) func(url *url.URL) ([]byte, error)
 func getRTMPStreamHandler(s *LivepeerServer) func(url *url.URL) (stream.RTMPVideoStream, error) {
 	return func(url *url.URL) (stream.RTMPVideoStream, error) {
 		mid := parseManifestID(url.Path)
-		s.connectionLock.RLock()
-		cxn, ok := s.rtmpConnections[mid]
-		defer s.connectionLock.RUnlock()
+		cxn, ok := s.rtmpConnections.get(mid)
 		if !ok {
 			glog.Error("Cannot find RTMP stream for ManifestID ", mid)
 			return nil, vidplayer.ErrNotFound
 		}
 
 		//Could use a subscriber, but not going to here because the RTMP stream doesn't need to be available for consumption by multiple views.  It's only for the segmenter.
-		return cxn.stream, nil
+		return cxn.rtmpStream(), nil
 	}
 }
 
 //End RTMP Handlers
 
+// watchIdleStream removes the stream of `cxn` once nothing has been pushed to
+// it for httpPushTimeout. The watchdog is stopped along with the stream, so
+// it never outlives it.
+func (s *LivepeerServer) watchIdleStream(cxn *rtmpConnection) {
+	var t *expiryTimer
+	t = pushWatchdog.newTimer(func() {
+		if cur, exists := s.rtmpConnections.get(cxn.mid); !exists || cur != cxn {
+			glog.Warningf("Watchdog tried closing session for manifestID=%s, which was already closed", cxn.mid)
+			return
+		}
+		if clock.Since(cxn.lastUsedTime()) > httpPushTimeout {
+			sendStreamEvent(newLifecycleEvent(cxn, streamIdleEvent))
+			go removeRTMPStream(s, cxn.mid)
+			return
+		}
+		t.reset(httpPushTimeout)
+	})
+	cxn.mu.Lock()
+	cxn.idleTimer = t
+	cxn.mu.Unlock()
+	t.reset(httpPushTimeout)
+}
+
+// pushFailed records that a push to the stream `mid` failed with `err`, and
+// tells the owner of the stream about failures that retrying will not fix
+func pushFailed(mid core.ManifestID, labels *core.StreamLabels, err error) {
+	if monitor.Enabled {
+		monitor.HTTPPushFailed(string(errorCategory(err)), isRetryable(err))
+	}
+	if !isRetryable(err) {
+		sendStreamEvent(&streamEvent{Event: "segmentError", ManifestID: string(mid), Time: clock.Now().Unix(), Labels: labels.Get(), Error: newStreamEventError(err)})
+	}
+}
+
+// segmentRejected records that a segment pushed to the stream `mid` was
+// rejected for `err`, and tells the owner of the stream if it is started
+func segmentRejected(mid core.ManifestID, cxn *rtmpConnection, err *malformedSegmentError) {
+	if monitor.Enabled {
+		monitor.HTTPPushRejected(err.Reason)
+	}
+	if cxn == nil || cxn.params == nil {
+		return
+	}
+	sendStreamEvent(&streamEvent{Event: "segmentRejected", ManifestID: string(mid), Time: clock.Now().Unix(), Labels: cxn.params.Labels.Get(),
+		Error: newStreamEventError(wrapError(ErrorCategoryIngest, false, err))})
+}
+
+// readSegmentBody reads the segment in the body of `r`, up to
+// MaxPushBodySize. Returns nil after answering the request if it can't.
+func readSegmentBody(w http.ResponseWriter, r *http.Request) *common.SegmentBuffer {
+	if MaxPushBodySize > 0 && r.ContentLength > MaxPushBodySize {
+		httpErr := fmt.Sprintf(`Segment too large url=%s bytes=%d max=%d`, common.RedactURL(r.URL.String()), r.ContentLength, MaxPushBodySize)
+		glog.Error(httpErr)
+		http.Error(w, httpErr, http.StatusRequestEntityTooLarge)
+		return nil
+	}
+	buf, err := common.ReadSegmentBufferLimit(r.Body, r.ContentLength, MaxPushBodySize)
+	if err == common.ErrSegmentTooLarge {
+		httpErr := fmt.Sprintf(`Segment too large url=%s max=%d`, common.RedactURL(r.URL.String()), MaxPushBodySize)
+		glog.Error(httpErr)
+		http.Error(w, httpErr, http.StatusRequestEntityTooLarge)
+		return nil
+	}
+	if err != nil {
+		httpErr := fmt.Sprintf(`Error reading http request body: %s`, err.Error())
+		glog.Error(httpErr)
+		http.Error(w, httpErr, http.StatusInternalServerError)
+		return nil
+	}
+	return buf
+}
+
 // HandlePush processes request for HTTP ingest
 func (s *LivepeerServer) HandlePush(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
+	if r.Method == "DELETE" {
+		s.handleTerminateStream(w, r)
+		return
+	}
 	if r.Method != "POST" && r.Method != "PUT" {
-		httpErr := fmt.Sprintf(`http push request wrong method=%s url=%s host=%s`, r.Method, r.URL, r.Host)
+		httpErr := fmt.Sprintf(`http push request wrong method=%s url=%s host=%s`, r.Method, common.RedactURL(r.URL.String()), r.Host)
 		glog.Error(httpErr)
 		http.Error(w, httpErr, http.StatusMethodNotAllowed)
 		return
 	}
 	// we read this unconditionally, mostly for ffmpeg
-	body, err := ioutil.ReadAll(r.Body)
-
-	if err != nil {
-		httpErr := fmt.Sprintf(`Error reading http request body: %s`, err.Error())
-		glog.Error(httpErr)
-		http.Error(w, httpErr, http.StatusInternalServerError)
+	buf := readSegmentBody(w, r)
+	if buf == nil {
 		return
 	}
+	defer buf.Release()
+	body := buf.Bytes()
 	r.Body.Close()
+	requestURI := r.URL.RequestURI()
 	r.URL = &url.URL{Scheme: "http", Host: r.Host, Path: r.URL.Path}
 
 	// Determine the input format the request is claiming to have
@@ -716,59 +1537,105 @@ func (s *LivepeerServer) HandlePush(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, httpErr, http.StatusBadRequest)
 		return
 	}
-	glog.Infof("Got push request at url=%s ua=%s addr=%s bytes=%d dur=%s resolution=%s", r.URL.String(), r.UserAgent(), r.RemoteAddr, len(body),
+	glog.Infof("Got push request at url=%s ua=%s addr=%s bytes=%d dur=%s resolution=%s", common.RedactURL(r.URL.String()), r.UserAgent(), r.RemoteAddr, len(body),
 		r.Header.Get("Content-Duration"), r.Header.Get("Content-Resolution"))
 
-	now := time.Now()
+	now := clock.Now()
 	mid := parseManifestID(r.URL.Path)
 	if mid == "" {
-		httpErr := fmt.Sprintf("Bad URL url=%s", r.URL)
+		httpErr := fmt.Sprintf("Bad URL url=%s", common.RedactURL(r.URL.String()))
 		glog.Error(httpErr)
 		http.Error(w, httpErr, http.StatusBadRequest)
 		return
 	}
-	s.connectionLock.RLock()
-	if intmid, exists := s.internalManifests[mid]; exists {
-		mid = intmid
+	extmid := mid
+	mid = s.streamAliases.resolve(extmid)
+	cxn, exists := s.rtmpConnections.get(mid)
+	if CheckPushBodies {
+		if merr := checkSegmentBody(body, format); merr != nil {
+			httpErr := fmt.Sprintf("Rejecting push request url=%s manifestID=%s err=%v", common.RedactURL(r.URL.String()), mid, merr)
+			glog.Error(httpErr)
+			segmentRejected(mid, cxn, merr)
+			http.Error(w, httpErr, http.StatusUnprocessableEntity)
+			return
+		}
 	}
-	cxn, exists := s.rtmpConnections[mid]
+	var claim *aliasClaim
+	if !exists {
+		// Pushes to a stream that is not started yet wait for any other push
+		// starting it, so that its auth webhook is called once
+		claim = s.streamAliases.claim(extmid)
+		defer claim.release()
+		mid = s.streamAliases.resolve(extmid)
+		cxn, exists = s.rtmpConnections.get(mid)
+	}
+	if !exists && s.drain.isDraining() {
+		glog.Errorf("Rejecting push request for new stream while draining url=%s", common.RedactURL(r.URL.String()))
+		http.Error(w, errDraining.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	// Shed load before the node runs out of memory, starting with the streams
+	// of the lowest priority classes
+	memLimit := policyFor(cxn.priority()).memLimit(MemBudget.Limit())
+	if err := MemBudget.ReserveBelow(string(mid), int64(len(body)), memLimit); err != nil {
+		glog.Errorf("Rejecting push request due to memory pressure url=%s manifestID=%s bytes=%d used=%d limit=%d",
+			common.RedactURL(r.URL.String()), mid, len(body), MemBudget.Used(), memLimit)
+		http.Error(w, "Memory budget exceeded", http.StatusServiceUnavailable)
+		return
+	}
+	defer MemBudget.Release(string(mid), int64(len(body)))
+
+	remoteIP := common.RemoteIP(r.RemoteAddr)
 	if exists && cxn != nil {
-		cxn.lastUsed = now
+		if !cxn.ingestFilter().Allowed(remoteIP) {
+			glog.Errorf("Rejecting push request from disallowed address url=%s addr=%s", common.RedactURL(r.URL.String()), r.RemoteAddr)
+			http.Error(w, "Address not allowed", http.StatusForbidden)
+			return
+		}
+		cxn.touch(now)
 	}
-	s.connectionLock.RUnlock()
 
 	// Check for presence and register if a fresh cxn
 	if !exists {
+		if node := Cluster.remoteNode(mid); node != "" {
+			glog.Infof("Redirecting push request to cluster node url=%s manifestID=%s node=%s", common.RedactURL(r.URL.String()), mid, node)
+			http.Redirect(w, r, node+requestURI, http.StatusTemporaryRedirect)
+			return
+		}
 		appData := (createRTMPStreamIDHandler(s))(r.URL)
 		if appData == nil {
-			httpErr := fmt.Sprintf("Could not create stream ID: url=%s", r.URL)
+			httpErr := fmt.Sprintf("Could not create stream ID: url=%s", common.RedactURL(r.URL.String()))
 			glog.Error(httpErr)
 			http.Error(w, httpErr, http.StatusInternalServerError)
 			return
 		}
 		params := streamParams(appData)
+		if params.IngestNode != "" {
+			glog.Infof("Redirecting push request to pinned node url=%s manifestID=%s node=%s", common.RedactURL(r.URL.String()), params.ManifestID, params.IngestNode)
+			http.Redirect(w, r, params.IngestNode+requestURI, http.StatusTemporaryRedirect)
+			return
+		}
+		if !params.IngestFilter.Allowed(remoteIP) {
+			glog.Errorf("Rejecting push request from disallowed address url=%s addr=%s", common.RedactURL(r.URL.String()), r.RemoteAddr)
+			http.Error(w, "Address not allowed", http.StatusForbidden)
+			return
+		}
+		// Don't start streams for pushes that will be rejected below
+		if _, err := newPushVerifier(params.PushSecret).check(r.URL.Path, r.Header.Get(PushSigHeader), body, now); err != nil {
+			glog.Errorf("Rejecting push request with bad signature url=%s addr=%s err=%v", common.RedactURL(r.URL.String()), r.RemoteAddr, err)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
 		params.Resolution = r.Header.Get("Content-Resolution")
 		params.Format = format
-		s.connectionLock.RLock()
-		if mid != params.ManifestID && s.rtmpConnections[params.ManifestID] != nil && s.internalManifests[mid] == "" {
-			// Pre-existing connection found for this new stream with the same underlying manifestID
-			var oldStreamID core.ManifestID
-			for k, v := range s.internalManifests {
-				if v == params.ManifestID {
-					oldStreamID = k
-					break
-				}
-			}
-			s.connectionLock.RUnlock()
-			if oldStreamID != "" && mid != oldStreamID {
-				// Close the old connection, and open a new one
-				// TODO try to re-use old HLS playlist?
-				glog.Warningf("Ending streamID=%v as new streamID=%s with same manifestID=%s has arrived",
-					oldStreamID, mid, params.ManifestID)
-				removeRTMPStream(s, oldStreamID)
-			}
-		} else {
-			s.connectionLock.RUnlock()
+		if oldStreamID, ok := s.streamAliases.externalOf(params.ManifestID); ok && oldStreamID != mid {
+			// Pre-existing connection found for this new stream with the same
+			// underlying manifestID. Close the old connection, and open a new one
+			// TODO try to re-use old HLS playlist?
+			glog.Warningf("Ending streamID=%v as new streamID=%s with same manifestID=%s has arrived",
+				oldStreamID, mid, params.ManifestID)
+			removeRTMPStream(s, params.ManifestID)
 		}
 		st := stream.NewBasicRTMPVideoStream(appData)
 		// Set output formats if not explicitly specified
@@ -778,52 +1645,41 @@ func (s *LivepeerServer) HandlePush(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 
+		var err error
 		cxn, err = s.registerConnection(st)
 		if err != nil {
 			st.Close()
 			if err != errAlreadyExists {
-				httpErr := fmt.Sprintf("http push error url=%s err=%v", r.URL, err)
+				httpErr := fmt.Sprintf("http push error url=%s err=%v", common.RedactURL(r.URL.String()), err)
 				glog.Error(httpErr)
-				http.Error(w, httpErr, http.StatusInternalServerError)
+				pushFailed(mid, params.Labels, err)
+				http.Error(w, httpErr, errorStatus(err))
 				return
 			} // else we continue with the old cxn
 		} else {
 			// Start a watchdog to remove session after a period of inactivity
-			ticker := time.NewTicker(httpPushTimeout)
-			go func(s *LivepeerServer, intmid, extmid core.ManifestID) {
-				defer ticker.Stop()
-				for range ticker.C {
-					var lastUsed time.Time
-					s.connectionLock.RLock()
-					if cxn, exists := s.rtmpConnections[intmid]; exists {
-						lastUsed = cxn.lastUsed
-					}
-					if _, exists := s.internalManifests[extmid]; !exists && intmid != extmid {
-						s.connectionLock.RUnlock()
-						glog.Warningf("Watchdog tried closing session for streamID=%s, which was already closed", extmid)
-						return
-					}
-					s.connectionLock.RUnlock()
-					if time.Since(lastUsed) > httpPushTimeout {
-						_ = removeRTMPStream(s, extmid)
-						return
-					}
-				}
-			}(s, cxn.mid, mid)
+			s.watchIdleStream(cxn)
 		}
 		// Regardless of old/new cxn returned by registerConnection, we make sure
-		// our internalManifests mapping is OK before moving on
+		// the alias of the stream is bound before moving on
 		if cxn.mid != mid {
 			// AuthWebhook provided different ManifestID
-			s.connectionLock.Lock()
-			s.internalManifests[mid] = cxn.mid
-			s.connectionLock.Unlock()
+			if prev := s.streamAliases.bind(mid, cxn.mid); prev != "" {
+				glog.Infof("Rebound manifestID=%s from streamID=%s to streamID=%s", cxn.mid, prev, mid)
+			}
+			Cluster.alias(mid, cxn.mid)
 			mid = cxn.mid
 		}
+		claim.release()
+	}
+	if err := cxn.pushSig.verify(r.URL.Path, r.Header.Get(PushSigHeader), body, now); err != nil {
+		glog.Errorf("Rejecting push request with bad signature url=%s addr=%s err=%v", common.RedactURL(r.URL.String()), r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
 	}
 	defer func(now time.Time) {
-		glog.Infof("Finished push request at url=%s ua=%s addr=%s len=%d dur=%s resolution=%s took=%s", r.URL.String(), r.UserAgent(), r.RemoteAddr, len(body),
-			r.Header.Get("Content-Duration"), r.Header.Get("Content-Resolution"), time.Since(now))
+		glog.Infof("Finished push request at url=%s ua=%s addr=%s len=%d dur=%s resolution=%s took=%s", common.RedactURL(r.URL.String()), r.UserAgent(), r.RemoteAddr, len(body),
+			r.Header.Get("Content-Duration"), r.Header.Get("Content-Resolution"), clock.Since(now))
 	}(now)
 
 	fname := path.Base(r.URL.Path)
@@ -834,8 +1690,13 @@ func (s *LivepeerServer) HandlePush(w http.ResponseWriter, r *http.Request) {
 
 	duration, err := strconv.Atoi(r.Header.Get("Content-Duration"))
 	if err != nil {
-		duration = 2000
-		glog.Info("Missing duration; filling in a default of 2000ms")
+		if d, ok := probeSegmentDuration(body, format); ok {
+			duration = int(d / time.Millisecond)
+			glog.V(common.DEBUG).Infof("Missing duration; probed a duration of %dms manifestID=%s seqNo=%d", duration, mid, seq)
+		} else {
+			duration = int(DefaultSegmentDuration / time.Millisecond)
+			glog.Infof("Missing duration; filling in a default of %dms", duration)
+		}
 	}
 
 	seg := &stream.HLSSegment{
@@ -846,33 +1707,31 @@ func (s *LivepeerServer) HandlePush(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Kick watchdog periodically so session doesn't time out during long transcodes
-	requestEnded := make(chan struct{}, 1)
-	defer func() { requestEnded <- struct{}{} }()
-	go func() {
-		for {
-			tick, cancel := httpPushResetTimer()
-			select {
-			case <-requestEnded:
-				cancel()
-				return
-			case <-tick.Done():
-				glog.V(common.VERBOSE).Infof("watchdog reset manifestID=%s seq=%d dur=%v started=%v", mid, seq, duration, now)
-				s.connectionLock.RLock()
-				if cxn, exists := s.rtmpConnections[mid]; exists {
-					cxn.lastUsed = time.Now()
-				}
-				s.connectionLock.RUnlock()
-			}
+	var reset *expiryTimer
+	reset = pushWatchdog.newTimer(func() {
+		glog.V(common.VERBOSE).Infof("watchdog reset manifestID=%s seq=%d dur=%v started=%v", mid, seq, duration, now)
+		if cxn, exists := s.rtmpConnections.get(mid); exists {
+			cxn.touch(clock.Now())
 		}
-	}()
+		reset.reset(httpPushResetInterval())
+	})
+	reset.reset(httpPushResetInterval())
+	defer reset.stop()
 
 	// Do the transcoding!
-	urls, err := processSegment(cxn, seg)
+	var urls []string
+	if qerr := SegmentWorkers.Do(string(mid), cxn.priority(), func() { urls, err = processSegment(cxn, seg, buf) }); qerr != nil {
+		httpErr := fmt.Sprintf("http push error queueing segment url=%s manifestID=%s err=%v", common.RedactURL(r.URL.String()), mid, qerr)
+		glog.Error(httpErr)
+		pushFailed(mid, cxn.params.Labels, qerr)
+		http.Error(w, httpErr, errorStatus(qerr))
+		return
+	}
 	if err != nil {
-		// TODO distinguish between user errors (400) and server errors (500)
-		httpErr := fmt.Sprintf("http push error processing segment url=%s manifestID=%s err=%v", r.URL, mid, err)
+		httpErr := fmt.Sprintf("http push error processing segment url=%s manifestID=%s err=%v", common.RedactURL(r.URL.String()), mid, err)
 		glog.Error(httpErr)
-		http.Error(w, httpErr, http.StatusInternalServerError)
+		pushFailed(mid, cxn.params.Labels, err)
+		http.Error(w, httpErr, errorStatus(err))
 		return
 	}
 	select {
@@ -885,22 +1744,27 @@ func (s *LivepeerServer) HandlePush(w http.ResponseWriter, r *http.Request) {
 	default:
 	}
 	if len(urls) == 0 {
-		glog.Infof("No sessions available for manifestID=%s seqNo=%d name=%s url=%s", mid, seq, fname, r.URL)
+		glog.Infof("No sessions available for manifestID=%s seqNo=%d name=%s url=%s", mid, seq, fname, common.RedactURL(r.URL.String()))
 		http.Error(w, "No sessions available", http.StatusServiceUnavailable)
 		return
 	}
-	renditionData := make([][]byte, len(urls))
+	renditionData := make([]*common.SegmentBuffer, len(urls))
 	// find data in local storage
 	memOS, ok := cxn.pl.GetOSSession().(*drivers.MemorySession)
 	if ok {
 		for i, fname := range urls {
-			data := memOS.GetData(fname)
-			if data != nil {
-				renditionData[i] = data
-			}
+			renditionData[i] = memOS.GetBuffer(fname)
 		}
+		// Hold on to the renditions until the response has been written
+		defer func() {
+			for _, buf := range renditionData {
+				if buf != nil {
+					buf.Release()
+				}
+			}
+		}()
 	}
-	glog.Infof("Finished transcoding push request at url=%s manifestID=%s seqNo=%d took=%s", r.URL.String(), mid, seq, time.Since(now))
+	glog.Infof("Finished transcoding push request at url=%s manifestID=%s seqNo=%d took=%s", common.RedactURL(r.URL.String()), mid, seq, clock.Since(now))
 
 	boundary := common.RandName()
 	accept := r.Header.Get("Accept")
@@ -915,12 +1779,14 @@ func (s *LivepeerServer) HandlePush(w http.ResponseWriter, r *http.Request) {
 	if accept != "multipart/mixed" {
 		return
 	}
-	mw := multipart.NewWriter(w)
+	mw := newPartWriter(w, boundary)
 	var fw io.Writer
 	for i, url := range urls {
-		mw.SetBoundary(boundary)
 		var typ, ext string
-		length := len(renditionData[i])
+		var length int
+		if renditionData[i] != nil {
+			length = renditionData[i].Len()
+		}
 		if length == 0 {
 			typ, ext, length = "application/vnd+livepeer.uri", ".txt", len(url)
 		} else {
@@ -932,7 +1798,7 @@ func (s *LivepeerServer) HandlePush(w http.ResponseWriter, r *http.Request) {
 			}
 			typ, err = common.ProfileFormatMimeType(format)
 			if err != nil {
-				glog.Errorf("Unknown mime type for format url=%s manifestID=%s err=%v ", r.URL, mid, err)
+				glog.Errorf("Unknown mime type for format url=%s manifestID=%s err=%v ", common.RedactURL(r.URL.String()), mid, err)
 			}
 		}
 		profile := cxn.params.Profiles[i].Name
@@ -948,8 +1814,8 @@ func (s *LivepeerServer) HandlePush(w http.ResponseWriter, r *http.Request) {
 			glog.Error("Could not create multipart part ", err)
 			break
 		}
-		if len(renditionData[i]) > 0 {
-			_, err = io.Copy(fw, bytes.NewBuffer(renditionData[i]))
+		if renditionData[i] != nil && renditionData[i].Len() > 0 {
+			_, err = fw.Write(renditionData[i].Bytes())
 			if err != nil {
 				break
 			}
@@ -964,7 +1830,7 @@ func (s *LivepeerServer) HandlePush(w http.ResponseWriter, r *http.Request) {
 		err = mw.Close()
 	}
 	if err != nil {
-		glog.Errorf("Error sending transcoded response url=%s err=%v", r.URL.String(), err)
+		glog.Errorf("Error sending transcoded response url=%s err=%v", common.RedactURL(r.URL.String()), err)
 		if monitor.Enabled {
 			monitor.HTTPClientTimedOut2()
 		}
@@ -997,57 +1863,173 @@ func getPlaylistsFromStore(ctx context.Context, sess drivers.OSSession, manifest
 	var latestPlaylistTime time.Time
 	var jsonFiles []string
 	filesMap := make(map[string][]int)
-	for _, manifestID := range manifests {
+	prefixes := make([]string, len(manifests))
+	for i, manifestID := range manifests {
 		filesMap[manifestID] = nil
-		start := time.Now()
-		filesPage, err := sess.ListFiles(ctx, manifestID+"/", "/")
-		if err != nil {
-			return nil, nil, latestPlaylistTime, err
+		prefixes[i] = manifestID + "/"
+	}
+	start := time.Now()
+	manifestLists, err := drivers.ParallelListFiles(ctx, sess, prefixes, "/", 16)
+	if err != nil {
+		return nil, nil, latestPlaylistTime, err
+	}
+	glog.V(common.VERBOSE).Infof("Listing directories for manifests=%d took=%s", len(manifests), time.Since(start))
+
+	var dirPrefixes, dirManifests []string
+	for i, list := range manifestLists {
+		for _, dirName := range list.Directories {
+			dirPrefixes = append(dirPrefixes, dirName+"playlist_")
+			dirManifests = append(dirManifests, manifests[i])
 		}
-		glog.V(common.VERBOSE).Infof("Listing directories for manifestID=%s took=%s", manifestID, time.Since(start))
-		dirs := filesPage.Directories()
-		if len(dirs) == 0 {
+	}
+	start = time.Now()
+	dirLists, err := drivers.ParallelListFiles(ctx, sess, dirPrefixes, "", 16)
+	if err != nil {
+		return nil, nil, latestPlaylistTime, err
+	}
+	glog.V(common.VERBOSE).Infof("Listing playlist files for directories=%d took=%s", len(dirPrefixes), time.Since(start))
+
+	for i, list := range dirLists {
+		manifestID := dirManifests[i]
+		for _, plf := range list.Files {
+			if plf.LastModified.After(latestPlaylistTime) {
+				latestPlaylistTime = plf.LastModified
+			}
+			filesMap[manifestID] = append(filesMap[manifestID], len(jsonFiles))
+			jsonFiles = append(jsonFiles, plf.Name)
+		}
+	}
+	return filesMap, jsonFiles, latestPlaylistTime, nil
+}
+
+// recordStore returns the store the stream of `resp` is recorded to: the one
+// from the auth webhook if set, else -recordStore. Returns nil if there is
+// neither.
+func recordStore(resp *authWebhookResponse) (drivers.OSDriver, error) {
+	if resp != nil && resp.RecordObjectStore != "" {
+		return drivers.ParseOSURL(resp.RecordObjectStore, true)
+	}
+	if drivers.RecordStorage == nil {
+		return nil, nil
+	}
+	return drivers.RecordStorage, nil
+}
+
+// joinRecordings reads the JSON playlists listed by getPlaylistsFromStore and
+// joins them into one playlist: the sessions of each manifest are merged, and
+// the manifests follow one another with discontinuities. All tracks are
+// joined if `finalize` is set, else only `track`, if not empty.
+func joinRecordings(ctx context.Context, sess drivers.OSSession, manifests []string, jsonFilesMap map[string][]int,
+	jsonFiles []string, finalize bool, track string) (*core.JsonPlaylist, error) {
+
+	now := time.Now()
+	_, datas, err := drivers.ParallelReadFiles(ctx, sess, jsonFiles, 16)
+	if err != nil {
+		return nil, err
+	}
+	glog.V(common.VERBOSE).Infof("Finished reading num=%d playlist files for manifests=%v took=%s", len(jsonFiles), manifests, time.Since(now))
+
+	var jsonPlaylists []*core.JsonPlaylist
+	for _, manifestID := range manifests {
+		if len(jsonFilesMap[manifestID]) == 0 {
 			continue
 		}
-		for _, dirName := range dirs {
-			start = time.Now()
-			dirOnePage, err := sess.ListFiles(ctx, dirName+"playlist_", "")
-			glog.V(common.VERBOSE).Infof("Listing playlist files for manifestID=%s took=%s", manifestID, time.Since(start))
-			if err != nil {
-				return nil, nil, latestPlaylistTime, err
+		// reconstruct sessions
+		manifestMainJspl := core.NewJSONPlaylist()
+		jsonPlaylists = append(jsonPlaylists, manifestMainJspl)
+		for _, i := range jsonFilesMap[manifestID] {
+			jspl := &core.JsonPlaylist{}
+			if err := json.Unmarshal(datas[i], jspl); err != nil {
+				return nil, err
 			}
-			for {
-				playlistsNames := dirOnePage.Files()
-				for _, plf := range playlistsNames {
-					if plf.LastModified.After(latestPlaylistTime) {
-						latestPlaylistTime = plf.LastModified
-					}
-					filesMap[manifestID] = append(filesMap[manifestID], len(jsonFiles))
-					jsonFiles = append(jsonFiles, plf.Name)
-				}
-				if !dirOnePage.HasNextPage() {
-					break
-				}
-				dirOnePage, err = dirOnePage.NextPage()
-				if err != nil {
-					return nil, nil, latestPlaylistTime, err
+			manifestMainJspl.AddMaster(jspl)
+			if finalize {
+				for trackName := range jspl.Segments {
+					manifestMainJspl.AddTrack(jspl, trackName)
 				}
+			} else if track != "" {
+				manifestMainJspl.AddTrack(jspl, track)
 			}
 		}
 	}
-	return filesMap, jsonFiles, latestPlaylistTime, nil
+	if len(jsonPlaylists) == 1 {
+		return jsonPlaylists[0], nil
+	}
+	mainJspl := core.NewJSONPlaylist()
+	// join sessions
+	for _, jspl := range jsonPlaylists {
+		mainJspl.AddMaster(jspl)
+		if finalize {
+			for trackName := range jspl.Segments {
+				mainJspl.AddDiscontinuedTrack(jspl, trackName)
+			}
+		} else if track != "" {
+			mainJspl.AddDiscontinuedTrack(jspl, track)
+		}
+	}
+	return mainJspl, nil
+}
+
+// recordingPlaylists returns the master playlist of a joined recording and
+// its empty VOD media playlists, by track name
+func recordingPlaylists(jspl *core.JsonPlaylist) (*m3u8.MasterPlaylist, map[string]*m3u8.MediaPlaylist, error) {
+	masterPList := m3u8.NewMasterPlaylist()
+	mediaLists := make(map[string]*m3u8.MediaPlaylist)
+	for _, track := range jspl.Tracks {
+		segments := jspl.Segments[track.Name]
+		mpl, err := m3u8.NewMediaPlaylist(uint(len(segments)), uint(len(segments)))
+		if err != nil {
+			return nil, nil, err
+		}
+		url := fmt.Sprintf("%s.m3u8", track.Name)
+		vParams := m3u8.VariantParams{Bandwidth: track.Bandwidth, Resolution: track.Resolution}
+		masterPList.Append(url, mpl, vParams)
+		mpl.Live = false
+		mediaLists[track.Name] = mpl
+	}
+	return masterPList, mediaLists, nil
+}
+
+// saveRecordingPlaylists fills the media playlists of a joined recording and
+// saves them, with the master playlist, to the root of the recording
+func saveRecordingPlaylists(sess drivers.OSSession, manifests []string, jspl *core.JsonPlaylist,
+	masterPList *m3u8.MasterPlaylist, mediaLists map[string]*m3u8.MediaPlaylist, extURL string) error {
+
+	for trackName := range jspl.Segments {
+		mpl := mediaLists[trackName]
+		jspl.AddSegmentsToMPL(manifests, trackName, mpl, extURL)
+		fileName := trackName + ".m3u8"
+		now := time.Now()
+		_, err := sess.SaveData(fileName, mpl.Encode().Bytes(), nil)
+		glog.V(common.VERBOSE).Infof("Saving playlist fileName=%s for manifests=%v took=%s", fileName, manifests, time.Since(now))
+		if err != nil {
+			return err
+		}
+	}
+	now := time.Now()
+	_, err := sess.SaveData("index.m3u8", masterPList.Encode().Bytes(), nil)
+	glog.V(common.VERBOSE).Infof("Saving playlist fileName=%s for manifests=%v took=%s", "index.m3u8", manifests, time.Since(now))
+	return err
 }
 
 // HandleRecordings handle requests to /recordings/ endpoint
 func (s *LivepeerServer) HandleRecordings(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" && path.Base(r.URL.Path) == "transcode" {
+		s.HandleTranscodeRecording(w, r)
+		return
+	}
+	if r.Method == "POST" && path.Base(r.URL.Path) == "finalize" {
+		s.HandleFinalizeRecording(w, r)
+		return
+	}
 	if r.Method != "GET" {
-		glog.Errorf(`/recordings request wrong method=%s url=%s host=%s`, r.Method, r.URL, r.Host)
+		glog.Errorf(`/recordings request wrong method=%s url=%s host=%s`, r.Method, common.RedactURL(r.URL.String()), r.Host)
 		w.WriteHeader(http.StatusMethodNotAllowed)
 		return
 	}
 	ext := path.Ext(r.URL.Path)
-	if ext != ".m3u8" && ext != ".ts" {
-		glog.Errorf(`/recordings request wrong extension=%s url=%s host=%s`, ext, r.URL, r.Host)
+	if ext != ".m3u8" && ext != ".ts" && ext != ".mp4" {
+		glog.Errorf(`/recordings request wrong extension=%s url=%s host=%s`, ext, common.RedactURL(r.URL.String()), r.Host)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
@@ -1059,14 +2041,14 @@ func (s *LivepeerServer) HandleRecordings(w http.ResponseWriter, r *http.Request
 	finalize := r.URL.Query().Get("finalize") == "true"
 	_, finalizeSet := r.URL.Query()["finalize"]
 	if len(pp) < 4 {
-		glog.Errorf(`/recordings request wrong url structure url=%s host=%s`, r.URL, r.Host)
+		glog.Errorf(`/recordings request wrong url structure url=%s host=%s`, common.RedactURL(r.URL.String()), r.Host)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	glog.V(common.VERBOSE).Infof("/recordings request=%s", r.URL.String())
+	glog.V(common.VERBOSE).Infof("/recordings request=%s", common.RedactURL(r.URL.String()))
 	now := time.Now()
 	defer func() {
-		glog.V(common.VERBOSE).Infof("request=%s took=%s headers=%+v", r.URL.String(), time.Since(now), w.Header())
+		glog.V(common.VERBOSE).Infof("request=%s took=%s headers=%+v", common.RedactURL(r.URL.String()), time.Since(now), w.Header())
 	}()
 	returnMasterPlaylist := pp[3] == "index.m3u8"
 	var track string
@@ -1079,11 +2061,11 @@ func (s *LivepeerServer) HandleRecordings(w http.ResponseWriter, r *http.Request
 	var fromCache bool
 	var err error
 	var resp *authWebhookResponse
-	if cresp, has := s.recordingsAuthResponses.Get(manifestID); has {
+	if cresp, has := s.recordingsAuthResponses.get(manifestID); has {
 		resp = cresp.(*authWebhookResponse)
 		fromCache = true
 	} else if resp, err = authenticateStream(r.URL.String()); err != nil {
-		glog.Errorf("Authentication denied for url=%s err=%v", r.URL.String(), err)
+		glog.Errorf("Authentication denied for url=%s err=%v", common.RedactURL(r.URL.String()), err)
 		if strings.Contains(err.Error(), "not found") {
 			w.WriteHeader(http.StatusNotFound)
 		} else {
@@ -1094,24 +2076,44 @@ func (s *LivepeerServer) HandleRecordings(w http.ResponseWriter, r *http.Request
 	var sess drivers.OSSession
 	ctx := r.Context()
 	if resp != nil && !fromCache {
-		s.recordingsAuthResponses.SetDefault(manifestID, resp)
+		s.recordingsAuthResponses.set(manifestID, resp)
+	}
+	_, playbackFilter, err := resp.ipFilters()
+	if err != nil {
+		glog.Errorf("Failed to parse allowed addresses for url=%s err=%v", common.RedactURL(r.URL.String()), err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if !playbackFilter.Allowed(common.RemoteIP(r.RemoteAddr)) {
+		glog.Errorf("Rejecting recordings request from disallowed address url=%s addr=%s", common.RedactURL(r.URL.String()), r.RemoteAddr)
+		w.WriteHeader(http.StatusForbidden)
+		return
 	}
 
-	if resp != nil && resp.RecordObjectStore != "" {
-		os, err := drivers.ParseOSURL(resp.RecordObjectStore, true)
-		if err != nil {
-			glog.Errorf("Error parsing OS URL err=%v request url=%s", err, r.URL)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-		sess = os.NewSession(manifestID)
-	} else if drivers.RecordStorage != nil {
-		sess = drivers.RecordStorage.NewSession(manifestID)
-	} else {
-		glog.Errorf("No record object store defined for request url=%s", r.URL)
+	ros, err := recordStore(resp)
+	if err != nil {
+		glog.Errorf("Error parsing OS URL err=%v request url=%s", err, common.RedactURL(r.URL.String()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if ros == nil {
+		glog.Errorf("No record object store defined for request url=%s", common.RedactURL(r.URL.String()))
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	sess = ros.NewSession(manifestID)
+
+	if ext != ".m3u8" && RecordingsRedirectExpiry > 0 {
+		if signer, ok := sess.(drivers.URLSigner); ok {
+			uri, err := signer.SignedURL(requestFileName, RecordingsRedirectExpiry)
+			if err == nil {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+				http.Redirect(w, r, uri, http.StatusFound)
+				return
+			}
+			glog.Errorf("Error signing url for request url=%s err=%v", common.RedactURL(r.URL.String()), err)
+		}
+	}
 
 	startRead := time.Now()
 	fi, err := sess.ReadData(ctx, requestFileName)
@@ -1123,18 +2125,28 @@ func (s *LivepeerServer) HandleRecordings(w http.ResponseWriter, r *http.Request
 	if err == nil && fi != nil && fi.Body != nil {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
 		w.Header().Set("Access-Control-Expose-Headers", "Content-Length")
-		if ext == ".ts" {
-			contentType, _ := common.TypeByExtension(".ts")
+		if ext != ".m3u8" {
+			contentType, _ := common.TypeByExtension(ext)
 			w.Header().Set("Content-Type", contentType)
 		} else {
 			w.Header().Set("Cache-Control", "max-age=5")
 			w.Header().Set("Content-Type", "application/x-mpegURL")
 		}
 		w.Header().Set("Connection", "keep-alive")
+		if fi.Size > 0 {
+			// Without a length the response is chunked, which rules out sendfile
+			// for file backed readers
+			w.Header().Set("Content-Length", strconv.FormatInt(fi.Size, 10))
+		}
 		startWrite := time.Now()
 		io.Copy(w, fi.Body)
 		fi.Body.Close()
-		glog.V(common.VERBOSE).Infof("request url=%s streaming filename=%s took=%s from_read_took=%s", r.URL.String(), requestFileName, time.Since(startWrite), time.Since(startRead))
+		glog.V(common.VERBOSE).Infof("request url=%s streaming filename=%s took=%s from_read_took=%s", common.RedactURL(r.URL.String()), requestFileName, time.Since(startWrite), time.Since(startRead))
+		return
+	}
+	if ext == ".mp4" {
+		// only packaged when the recording is finalized
+		w.WriteHeader(http.StatusNotFound)
 		return
 	}
 	var manifests []string
@@ -1149,85 +2161,28 @@ func (s *LivepeerServer) HandleRecordings(w http.ResponseWriter, r *http.Request
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	glog.V(common.VERBOSE).Infof("request url=%s found json files: %+v", r.URL, jsonFiles)
+	glog.V(common.VERBOSE).Infof("request url=%s found json files: %+v", common.RedactURL(r.URL.String()), jsonFiles)
 
 	if len(jsonFiles) == 0 {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
-	if time.Since(latestPlaylistTime) > 24*time.Hour && !finalizeSet {
+	if clock.Since(latestPlaylistTime) > 24*time.Hour && !finalizeSet {
 		finalize = true
 	}
 
 	now1 := time.Now()
-	_, datas, err := drivers.ParallelReadFiles(ctx, sess, jsonFiles, 16)
+	mainJspl, err := joinRecordings(ctx, sess, manifests, jsonFilesMap, jsonFiles, finalize, track)
 	if err != nil {
 		glog.Error(err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	glog.V(common.VERBOSE).Infof("Finished reading num=%d playlist files for manifestID=%s took=%s", len(jsonFiles), manifestID, time.Since(now1))
-
-	var jsonPlaylists []*core.JsonPlaylist
-	for _, manifestID := range manifests {
-		if len(jsonFilesMap[manifestID]) == 0 {
-			continue
-		}
-		// reconstruct sessions
-		manifestMainJspl := core.NewJSONPlaylist()
-		jsonPlaylists = append(jsonPlaylists, manifestMainJspl)
-		for _, i := range jsonFilesMap[manifestID] {
-			jspl := &core.JsonPlaylist{}
-			err = json.Unmarshal(datas[i], jspl)
-			if err != nil {
-				glog.Error(err)
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-			manifestMainJspl.AddMaster(jspl)
-			if finalize {
-				for trackName := range jspl.Segments {
-					manifestMainJspl.AddTrack(jspl, trackName)
-				}
-			} else if track != "" {
-				manifestMainJspl.AddTrack(jspl, track)
-			}
-		}
-	}
-	var mainJspl *core.JsonPlaylist
-	if len(jsonPlaylists) == 1 {
-		mainJspl = jsonPlaylists[0]
-	} else {
-		mainJspl = core.NewJSONPlaylist()
-		// join sessions
-		for _, jspl := range jsonPlaylists {
-			mainJspl.AddMaster(jspl)
-			if finalize {
-				for trackName := range jspl.Segments {
-					mainJspl.AddDiscontinuedTrack(jspl, trackName)
-				}
-			} else if track != "" {
-				mainJspl.AddDiscontinuedTrack(jspl, track)
-			}
-		}
-	}
-
-	masterPList := m3u8.NewMasterPlaylist()
-	mediaLists := make(map[string]*m3u8.MediaPlaylist)
-
-	for _, track := range mainJspl.Tracks {
-		segments := mainJspl.Segments[track.Name]
-		mpl, err := m3u8.NewMediaPlaylist(uint(len(segments)), uint(len(segments)))
-		if err != nil {
-			glog.Error(err)
-			w.WriteHeader(http.StatusInternalServerError)
-			return
-		}
-		url := fmt.Sprintf("%s.m3u8", track.Name)
-		vParams := m3u8.VariantParams{Bandwidth: track.Bandwidth, Resolution: track.Resolution}
-		masterPList.Append(url, mpl, vParams)
-		mpl.Live = false
-		mediaLists[track.Name] = mpl
+	masterPList, mediaLists, err := recordingPlaylists(mainJspl)
+	if err != nil {
+		glog.Error(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
 	select {
 	case <-ctx.Done():
@@ -1237,23 +2192,7 @@ func (s *LivepeerServer) HandleRecordings(w http.ResponseWriter, r *http.Request
 	}
 	glog.V(common.VERBOSE).Infof("Playlist generation for manifestID=%s took=%s", manifestID, time.Since(now1))
 	if finalize {
-		for trackName := range mainJspl.Segments {
-			mpl := mediaLists[trackName]
-			mainJspl.AddSegmentsToMPL(manifests, trackName, mpl, resp.RecordObjectStoreURL)
-			fileName := trackName + ".m3u8"
-			nows := time.Now()
-			_, err = sess.SaveData(fileName, mpl.Encode().Bytes(), nil)
-			glog.V(common.VERBOSE).Infof("Saving playlist fileName=%s for manifestID=%s took=%s", fileName, manifestID, time.Since(nows))
-			if err != nil {
-				glog.Error(err)
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-		}
-		nows := time.Now()
-		_, err = sess.SaveData("index.m3u8", masterPList.Encode().Bytes(), nil)
-		glog.V(common.VERBOSE).Infof("Saving playlist fileName=%s for manifestID=%s took=%s", "index.m3u8", manifestID, time.Since(nows))
-		if err != nil {
+		if err := saveRecordingPlaylists(sess, manifests, mainJspl, masterPList, mediaLists, resp.RecordObjectStoreURL); err != nil {
 			glog.Error(err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
@@ -1295,10 +2234,47 @@ func (s *LivepeerServer) HandleRecordings(w http.ResponseWriter, r *http.Request
 //Helper Methods Begin
 
 // StreamPrefix match all leading spaces, slashes and optionally `stream/`
+// as well as any `live/`. cleanStreamPrefix implements the same replacement
+// without the regex as it runs on every request.
 var StreamPrefix = regexp.MustCompile(`^[ /]*(stream/)?|(live/)?`) // test carefully!
 
+// cleanStreamPrefix is equivalent to StreamPrefix.ReplaceAllString(reqPath, "")
+// but only allocates if `live/` has to be cut out of the middle of the path
 func cleanStreamPrefix(reqPath string) string {
-	return StreamPrefix.ReplaceAllString(reqPath, "")
+	const stream, live = "stream/", "live/"
+	i := 0
+	for i < len(reqPath) && (reqPath[i] == ' ' || reqPath[i] == '/') {
+		i++
+	}
+	if strings.HasPrefix(reqPath[i:], stream) {
+		i += len(stream)
+	}
+	// `live/` is removed from wherever the prefix ends. If there is no
+	// prefix the regex matches the empty string first, so the search for
+	// `live/` only starts after the first character.
+	from := i
+	if i == 0 && len(reqPath) > 0 {
+		from = 1
+	} else {
+		for strings.HasPrefix(reqPath[i:], live) {
+			i += len(live)
+		}
+		from = i
+	}
+	j := strings.Index(reqPath[from:], live)
+	if j < 0 {
+		return reqPath[i:]
+	}
+	var b strings.Builder
+	b.Grow(len(reqPath) - i - len(live))
+	for j >= 0 {
+		b.WriteString(reqPath[i : from+j])
+		i = from + j + len(live)
+		from = i
+		j = strings.Index(reqPath[from:], live)
+	}
+	b.WriteString(reqPath[i:])
+	return b.String()
 }
 
 func parseStreamID(reqPath string) core.StreamID {
@@ -1337,12 +2313,10 @@ func (s *LivepeerServer) GetNodeStatus() *net.NodeStatus {
 	// not threadsafe; need to deep copy the playlist
 	m := make(map[string]*m3u8.MasterPlaylist)
 
-	s.connectionLock.RLock()
-	defer s.connectionLock.RUnlock()
 	streamInfo := make(map[string]net.StreamInfo)
-	for _, cxn := range s.rtmpConnections {
+	s.rtmpConnections.forEach(func(_ core.ManifestID, cxn *rtmpConnection) bool {
 		if cxn.pl == nil {
-			continue
+			return true
 		}
 		cpl := cxn.pl
 		m[string(cpl.ManifestID())] = cpl.GetHLSMasterPlaylist()
@@ -1351,8 +2325,15 @@ func (s *LivepeerServer) GetNodeStatus() *net.NodeStatus {
 		streamInfo[string(cpl.ManifestID())] = net.StreamInfo{
 			SourceBytes:     sb,
 			TranscodedBytes: tb,
+			MemoryBytes:     MemBudget.StreamUsage(string(cpl.ManifestID())),
+			SegmentsQueued:  SegmentWorkers.StreamQueued(string(cpl.ManifestID())),
+			Priority:        cxn.priority().String(),
+			SourceWarnings:  cxn.source.Warnings(),
+			AVDrift:         cxn.source.AVDrift(),
+			Labels:          cxn.params.Labels.Get(),
 		}
-	}
+		return true
+	})
 	res := &net.NodeStatus{
 		Manifests:             m,
 		InternalManifests:     make(map[string]string),
@@ -1364,9 +2345,13 @@ func (s *LivepeerServer) GetNodeStatus() *net.NodeStatus {
 		OrchestratorPool:      []string{},
 		RegisteredTranscoders: []net.RemoteTranscoderInfo{},
 		LocalTranscoding:      s.LivepeerNode.TranscoderManager == nil,
+		MemoryUsed:            MemBudget.Used(),
+		MemoryLimit:           MemBudget.Limit(),
+		SegmentsQueued:        SegmentWorkers.Queued(),
+		SegmentWorkersBusy:    SegmentWorkers.Busy(),
 	}
-	for k, v := range s.internalManifests {
-		res.InternalManifests[string(k)] = string(v)
+	for _, alias := range s.streamAliases.list() {
+		res.InternalManifests[string(alias.External)] = string(alias.Internal)
 	}
 	if s.LivepeerNode.TranscoderManager != nil {
 		res.RegisteredTranscodersNumber = s.LivepeerNode.TranscoderManager.RegisteredTranscodersCount()
@@ -1375,7 +2360,7 @@ func (s *LivepeerServer) GetNodeStatus() *net.NodeStatus {
 	if s.LivepeerNode.OrchestratorPool != nil {
 		urls := s.LivepeerNode.OrchestratorPool.GetURLs()
 		for _, url := range urls {
-			res.OrchestratorPool = append(res.OrchestratorPool, url.String())
+			res.OrchestratorPool = append(res.OrchestratorPool, common.RedactURL(url.String()))
 		}
 	}
 	return res
@@ -1383,9 +2368,7 @@ func (s *LivepeerServer) GetNodeStatus() *net.NodeStatus {
 
 // Debug helpers
 func (s *LivepeerServer) LatestPlaylist() core.PlaylistManager {
-	s.connectionLock.RLock()
-	defer s.connectionLock.RUnlock()
-	cxn, ok := s.rtmpConnections[s.lastManifestID]
+	cxn, ok := s.rtmpConnections.get(s.LastManifestID())
 	if !ok || cxn.pl == nil {
 		return nil
 	}
@@ -1393,6 +2376,6 @@ func (s *LivepeerServer) LatestPlaylist() core.PlaylistManager {
 }
 
 func shouldStopStream(err error) bool {
-	_, ok := err.(pm.ErrSenderValidation)
-	return ok
+	var serr pm.ErrSenderValidation
+	return errors.As(err, &serr)
 }
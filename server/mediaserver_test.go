@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -32,8 +33,6 @@ import (
 
 var S *LivepeerServer
 
-var pushResetWg sync.WaitGroup // needed to synchronize exits from HTTP push
-
 func setupServer() *LivepeerServer {
 	s, _ := setupServerWithCancel()
 	return s
@@ -43,15 +42,6 @@ func setupServerWithCancel() (*LivepeerServer, context.CancelFunc) {
 	drivers.NodeStorage = drivers.NewMemoryDriver(nil)
 	ctx, cancel := context.WithCancel(context.Background())
 	if S == nil {
-		httpPushResetTimer = func() (context.Context, context.CancelFunc) {
-			ctx, cancel := context.WithCancel(context.Background())
-			pushResetWg.Add(1)
-			wrapCancel := func() {
-				cancel()
-				pushResetWg.Done()
-			}
-			return ctx, wrapCancel
-		}
 		n, _ := core.NewLivepeerNode(nil, "./tmp", nil)
 		S, _ = NewLivepeerServer("127.0.0.1:1938", n, true, "")
 		go S.StartMediaServer(ctx, "127.0.0.1:8080")
@@ -65,11 +55,12 @@ func setupServerWithCancel() (*LivepeerServer, context.CancelFunc) {
 // close connections in all the tests that are using them
 func serverCleanup(s *LivepeerServer) {
 	s.connectionLock.Lock()
-	for _, cxn := range s.rtmpConnections {
+	s.rtmpConnections.forEach(func(_ core.ManifestID, cxn *rtmpConnection) bool {
 		if cxn != nil && cxn.stream != nil {
 			cxn.stream.Close()
 		}
-	}
+		return true
+	})
 	s.connectionLock.Unlock()
 }
 
@@ -316,7 +307,7 @@ func newStreamParams(mid core.ManifestID, rtmpKey string) *core.StreamParameters
 func TestCreateRTMPStreamHandlerCap(t *testing.T) {
 	s := &LivepeerServer{
 		connectionLock:  &sync.RWMutex{},
-		rtmpConnections: make(map[core.ManifestID]*rtmpConnection),
+		rtmpConnections: newConnectionMap(),
 	}
 	createSid := createRTMPStreamIDHandler(s)
 	u, _ := url.Parse("http://hot/id1/secret")
@@ -331,7 +322,7 @@ func TestCreateRTMPStreamHandlerCap(t *testing.T) {
 	if sid.StreamID() != "id1/secret" {
 		t.Error("Stream id/key did not match ", sid)
 	}
-	s.rtmpConnections[core.ManifestID("id1")] = nil
+	s.rtmpConnections.store(core.ManifestID("id1"), nil)
 	// capped case
 	params := createSid(u)
 	if params != nil {
@@ -424,7 +415,9 @@ func TestCreateRTMPStreamHandlerWebhook(t *testing.T) {
 	assert.Equal(params.Profiles, []ffmpeg.VideoProfile{ffmpeg.P240p30fps16x9,
 		ffmpeg.P720p30fps16x9}, "Did not have matching presets")
 
-	// set profiles with valid values, presets empty
+	// set profiles with valid values, presets empty, without profile limits
+	defer func(l ProfileLimits) { TranscodeProfileLimits = l }(TranscodeProfileLimits)
+	TranscodeProfileLimits = ProfileLimits{}
 	ts7 := makeServer(`{"manifestID":"a", "profiles": [
 		{"name": "prof1", "bitrate": 432, "fps": 560, "width": 123, "height": 456, "profile": "H264Baseline"},
 		{"name": "prof2", "bitrate": 765, "fps": 876, "fpsDen": 12, "width": 456, "height": 987, "gop": "intra"},
@@ -466,6 +459,11 @@ func TestCreateRTMPStreamHandlerWebhook(t *testing.T) {
 	assert.Len(params.Profiles, 3)
 	assert.Equal(expectedProfiles, params.Profiles, "Did not have matching profiles")
 
+	// the same profiles are now rejected within the default limits
+	TranscodeProfileLimits = DefaultProfileLimits
+	assert.Nil(createSid(u))
+	TranscodeProfileLimits = ProfileLimits{}
+
 	// set profiles with invalid values, presets empty
 	ts8 := makeServer(`{"manifestID":"a", "profiles": [
 		{"name": "prof1", "bitrate": 432, "fps": 560, "width": 123, "height": 456},
@@ -488,6 +486,9 @@ func TestCreateRTMPStreamHandlerWebhook(t *testing.T) {
 	assert.Len(params.Profiles, 5)
 	assert.Equal(jointProfiles, params.Profiles, "Did not have matching profiles")
 
+	TranscodeProfileLimits = DefaultProfileLimits
+	assert.Nil(createSid(u))
+
 	// all invalid presets in webhook should lead to empty set
 	ts10 := makeServer(`{"manifestID":"a", "presets":["very", "unknown"]}`)
 	defer ts10.Close()
@@ -506,7 +507,7 @@ func TestCreateRTMPStreamHandlerWebhook(t *testing.T) {
 	assert.Nil(createSid(u))
 
 	// intra only gop
-	ts14 := makeServer(`{"manifestID":"a", "profiles": [ {"gop": "intra" }]}`)
+	ts14 := makeServer(`{"manifestID":"a", "profiles": [ {"bitrate": 400000, "width": 426, "height": 240, "gop": "intra" }]}`)
 	defer ts14.Close()
 	params = createSid(u).(*core.StreamParameters)
 	assert.Len(params.Profiles, 1)
@@ -538,6 +539,17 @@ func TestCreateRTMPStreamHandlerWebhook(t *testing.T) {
 	osinfo = params.RecordOS.GetInfo()
 	assert.Equal(net.OSInfo_S3, osinfo.GetStorageType())
 	assert.Equal("http://record.store", osinfo.GetS3Info().Host)
+	assert.Equal(core.PriorityNormal, params.Priority)
+
+	ts18 := makeServer(`{"manifestID":"a4", "priority": "high"}`)
+	defer ts18.Close()
+	params = createSid(u).(*core.StreamParameters)
+	assert.Equal(core.PriorityHigh, params.Priority)
+
+	ts19 := makeServer(`{"manifestID":"a5", "priority": "premium"}`)
+	defer ts19.Close()
+	sid = createSid(u)
+	assert.Nil(sid)
 }
 
 func TestCreateRTMPStreamHandler(t *testing.T) {
@@ -643,6 +655,58 @@ func TestEndRTMPStreamHandler(t *testing.T) {
 	}
 }
 
+func TestEndRTMPStreamHandler_ReconnectGrace(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	s := setupServer()
+	defer serverCleanup(s)
+	s.RTMPSegmenter = &StubSegmenter{skip: true}
+	handler := gotRTMPStreamHandler(s)
+	endHandler := endRTMPStreamHandler(s)
+	defer func(g time.Duration) { RTMPReconnectGrace = g }(RTMPReconnectGrace)
+	RTMPReconnectGrace = 100 * time.Millisecond
+
+	u, _ := url.Parse("rtmp://localhost/live/grace")
+	mid := core.ManifestID("grace")
+	st1 := stream.NewBasicRTMPVideoStream(&core.StreamParameters{ManifestID: mid})
+	require.Nil(handler(u, st1))
+	cxn, ok := s.rtmpConnections.get(mid)
+	require.True(ok)
+	cxn.sawSeqNo(3)
+	cxn.sawSeqNo(4)
+
+	// publisher drops; the connection is kept
+	assert.Nil(endHandler(u, st1))
+	cur, ok := s.rtmpConnections.get(mid)
+	require.True(ok)
+	assert.Equal(cxn, cur)
+	assert.True(s.awaitingReconnect(mid))
+
+	// and picked up again by the reconnecting publisher
+	st2 := stream.NewBasicRTMPVideoStream(&core.StreamParameters{ManifestID: mid})
+	require.Nil(handler(u, st2))
+	cur, ok = s.rtmpConnections.get(mid)
+	require.True(ok)
+	assert.Equal(cxn, cur)
+	assert.Equal(st2, cxn.rtmpStream())
+	assert.False(s.awaitingReconnect(mid))
+
+	// segments continue after a discontinuity
+	vProfile := ffmpeg.P144p30fps16x9
+	require.Nil(cxn.pl.InsertHLSSegment(&vProfile, 4, "4.ts", 2))
+	require.Nil(cxn.pl.InsertHLSSegment(&vProfile, 5, "5.ts", 2))
+	mpl := cxn.pl.GetHLSMediaPlaylist(vProfile.Name)
+	assert.False(mpl.Segments[0].Discontinuity)
+	assert.True(mpl.Segments[1].Discontinuity)
+
+	// the stream ends once the publisher stays away
+	assert.Nil(endHandler(u, st2))
+	time.Sleep(2 * RTMPReconnectGrace)
+	_, ok = s.rtmpConnections.get(mid)
+	assert.False(ok)
+	assert.Equal(errUnknownStream, endHandler(u, st2))
+}
+
 // Should publish RTMP stream, turn the RTMP stream into HLS, and broadcast the HLS stream.
 func TestGotRTMPStreamHandler(t *testing.T) {
 	s := setupServer()
@@ -764,8 +828,8 @@ func TestMultiStream(t *testing.T) {
 	select {
 	case <-ch:
 		close(ch)
-		if len(s.rtmpConnections) < asyncStreams {
-			t.Error("Did not have expected number of streams", len(s.rtmpConnections))
+		if s.rtmpConnections.len() < asyncStreams {
+			t.Error("Did not have expected number of streams", s.rtmpConnections.len())
 		}
 	case <-ctx.Done():
 		t.Error("Timed out")
@@ -823,6 +887,9 @@ func TestRegisterConnection(t *testing.T) {
 	assert := assert.New(t)
 	s := setupServer()
 	defer serverCleanup(s)
+	oldMaxSessions := core.MaxSessions
+	defer func() { core.MaxSessions = oldMaxSessions }()
+	core.MaxSessions = 0
 	mid := core.SplitStreamIDString(t.Name()).ManifestID
 	strm := stream.NewBasicRTMPVideoStream(&core.StreamParameters{ManifestID: mid})
 
@@ -879,6 +946,16 @@ func TestRegisterConnection(t *testing.T) {
 	// TODO test with non-legacy capabilities once we have some
 	// Should result in a non-nil `cxn.params.Capabilities`.
 
+	// Should return an error once there are too many connections
+	core.MaxSessions = s.rtmpConnections.len()
+	mid = core.RandomManifestID()
+	cxn, err = s.registerConnection(stream.NewBasicRTMPVideoStream(&core.StreamParameters{ManifestID: mid}))
+	assert.Nil(cxn)
+	assert.Equal(errTooManySessions, err)
+	_, exists := s.rtmpConnections.get(mid)
+	assert.False(exists)
+	core.MaxSessions = 0
+
 	// Ensure thread-safety under -race
 	var wg sync.WaitGroup
 	for i := 0; i < 500; i++ {
@@ -897,6 +974,27 @@ func TestRegisterConnection(t *testing.T) {
 	}
 	wg.Wait()
 
+	// Concurrent registrations do not go over the limit
+	core.MaxSessions = s.rtmpConnections.len() + 5
+	var mu sync.Mutex
+	registered := 0
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			mid := core.ManifestID(fmt.Sprintf("%v_capped_%v", t.Name(), i))
+			if _, err := s.registerConnection(stream.NewBasicRTMPVideoStream(&core.StreamParameters{ManifestID: mid})); err == nil {
+				mu.Lock()
+				registered++
+				mu.Unlock()
+			} else {
+				assert.Equal(errTooManySessions, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Equal(5, registered)
+
 }
 
 func TestBroadcastSessionManagerWithStreamStartStop(t *testing.T) {
@@ -929,7 +1027,7 @@ func TestBroadcastSessionManagerWithStreamStartStop(t *testing.T) {
 	mid := streamParams(st.AppData()).ManifestID
 
 	// assert that ManifestID has not been added to rtmpConnections yet
-	_, exists := s.rtmpConnections[mid]
+	_, exists := s.rtmpConnections.get(mid)
 	assert.Equal(exists, false)
 
 	// assert stream starts successfully
@@ -937,7 +1035,7 @@ func TestBroadcastSessionManagerWithStreamStartStop(t *testing.T) {
 	assert.Nil(err)
 
 	// assert sessManager is running and has right number of sessions
-	cxn, exists := s.rtmpConnections[mid]
+	cxn, exists := s.rtmpConnections.get(mid)
 	assert.Equal(exists, true)
 	assert.Equal(cxn.sessManager.finished, false)
 	assert.Equal(cxn.sessManager.sel.Size(), 2)
@@ -948,7 +1046,7 @@ func TestBroadcastSessionManagerWithStreamStartStop(t *testing.T) {
 	assert.Nil(err)
 
 	// assert sessManager is not running and has no sessions
-	_, exists = s.rtmpConnections[mid]
+	_, exists = s.rtmpConnections.get(mid)
 	assert.Equal(exists, false)
 	assert.Equal(cxn.sessManager.finished, true)
 	assert.Equal(cxn.sessManager.sel.Size(), 0)
@@ -959,7 +1057,7 @@ func TestBroadcastSessionManagerWithStreamStartStop(t *testing.T) {
 	assert.Nil(err)
 
 	// assert sessManager is running and has right number of sessions
-	cxn, exists = s.rtmpConnections[mid]
+	cxn, exists = s.rtmpConnections.get(mid)
 	assert.Equal(exists, true)
 	assert.Equal(cxn.sessManager.finished, false)
 	assert.Equal(cxn.sessManager.sel.Size(), 2)
@@ -988,6 +1086,57 @@ func TestCleanStreamPrefix(t *testing.T) {
 	}
 }
 
+func TestCleanStreamPrefix_MatchesRegex(t *testing.T) {
+	cases := []string{
+		"", "/", "live/x", "/live/x", "stream/live/x", "  /stream/stream/x", "/streams/x",
+		"olive/x", "/live/foo/live/bar", "x/live/", "/stream/", "live/live/x", "/livelive/",
+		"//live//x", "/stream/live/live/mani/1.ts", "élive/x",
+	}
+	// random paths built from the pieces the regex cares about
+	parts := []string{" ", "/", "stream/", "live/", "live", "l", "x", "é", "\xff"}
+	r := rand.New(rand.NewSource(1))
+	for n := 0; n < 10000; n++ {
+		var b strings.Builder
+		for i := r.Intn(8); i > 0; i-- {
+			b.WriteString(parts[r.Intn(len(parts))])
+		}
+		cases = append(cases, b.String())
+	}
+	for _, c := range cases {
+		if got, want := cleanStreamPrefix(c), StreamPrefix.ReplaceAllString(c, ""); got != want {
+			t.Errorf("cleanStreamPrefix(%q) = %q, want %q", c, got, want)
+		}
+	}
+}
+
+func TestParseStreamID_NoAllocs(t *testing.T) {
+	assert := assert.New(t)
+	for _, p := range []string{"/live/mani/1.ts", "/stream/mani/P144p30fps16x9/12.ts", "/stream/mani.m3u8"} {
+		allocs := testing.AllocsPerRun(100, func() { parseStreamID(p) })
+		assert.Equal(float64(0), allocs, p)
+	}
+	assert.Equal(core.MakeStreamIDFromString("mani", "P144p30fps16x9/12"), parseStreamID("/stream/mani/P144p30fps16x9/12.ts"))
+	assert.Equal(core.ManifestID("mani"), parseManifestID("/live/mani/1.ts"))
+}
+
+func BenchmarkCleanStreamPrefix(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		cleanStreamPrefix("/live/mani/1.ts")
+	}
+}
+
+func BenchmarkCleanStreamPrefix_Regex(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		StreamPrefix.ReplaceAllString("/live/mani/1.ts", "")
+	}
+}
+
+func BenchmarkParseStreamID(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		parseStreamID("/stream/mani/P144p30fps16x9/12.ts")
+	}
+}
+
 func TestShouldStopStream(t *testing.T) {
 	assert := assert.New(t)
 	ok := shouldStopStream(fmt.Errorf("some random error string"))
@@ -0,0 +1,263 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common"
+)
+
+// Metering, if set, receives a usage record for every source segment the
+// broadcaster processes, for billing. Disabled by default.
+var Metering *UsageMeter
+
+// maxPendingBatches bounds the usage records kept while the sink fails, in
+// batches; the oldest records are dropped beyond that
+const maxPendingBatches = 100
+
+var meteringClient = &http.Client{Timeout: 10 * time.Second}
+
+// UsageMeter batches usage records and delivers them to a sink: a file of
+// JSON lines, or an HTTP endpoint that batches are POSTed to as JSON arrays.
+// Batches that fail to be delivered are retried with the next flush.
+type UsageMeter struct {
+	sink      meteringSink
+	batchSize int
+	mu        sync.Mutex
+	pending   []*usageRecord
+	dropped   int
+	full      chan struct{}
+}
+
+type usageRecord struct {
+	ManifestID string `json:"manifestID"`
+	SeqNo      uint64 `json:"seqNo"`
+	Time       string `json:"time"`
+	Status     string `json:"status"`
+	// Of the source segment, in seconds
+	Duration    float64 `json:"duration"`
+	SourceBytes int64   `json:"sourceBytes"`
+	// Output pixels of all renditions
+	Pixels        int64             `json:"pixels"`
+	Renditions    []*usageRendition `json:"renditions,omitempty"`
+	TranscodeMs   int64             `json:"transcodeMs"`
+	Orchestrator  string            `json:"orchestrator,omitempty"`
+	PricePerUnit  int64             `json:"pricePerUnit,omitempty"`
+	PixelsPerUnit int64             `json:"pixelsPerUnit,omitempty"`
+	Labels        map[string]string `json:"labels,omitempty"`
+}
+
+type usageRendition struct {
+	Name   string `json:"name"`
+	Pixels int64  `json:"pixels"`
+	// Not known for renditions that orchestrators saved straight to the
+	// object store of the broadcaster
+	Bytes *int64 `json:"bytes,omitempty"`
+}
+
+// meteringSink delivers batches of usage records
+type meteringSink interface {
+	send(recs []*usageRecord) error
+}
+
+// NewUsageMeter creates a meter delivering to `dest`, either an http(s) URL
+// or the path of a file, in batches of up to `batchSize` records at least
+// every `flushInterval`
+func NewUsageMeter(dest string, batchSize int, flushInterval time.Duration) (*UsageMeter, error) {
+	if batchSize <= 0 || flushInterval <= 0 {
+		return nil, fmt.Errorf("invalid metering batch size=%d flushInterval=%v", batchSize, flushInterval)
+	}
+	var sink meteringSink
+	switch {
+	case strings.HasPrefix(dest, "http://"), strings.HasPrefix(dest, "https://"):
+		sink = &httpMeteringSink{url: dest}
+	case strings.Contains(dest, "://"):
+		return nil, fmt.Errorf("unsupported metering sink: %s", common.RedactURL(dest))
+	default:
+		w, err := newRotatingFile(dest, 0, 0)
+		if err != nil {
+			return nil, err
+		}
+		sink = &fileMeteringSink{w: w}
+	}
+	m := newUsageMeter(sink, batchSize)
+	go m.run(flushInterval)
+	return m, nil
+}
+
+func newUsageMeter(sink meteringSink, batchSize int) *UsageMeter {
+	return &UsageMeter{sink: sink, batchSize: batchSize, full: make(chan struct{}, 1)}
+}
+
+// meterSegment records the usage of `job`, processed from `start` on and
+// failed with `err` if non-nil, with Metering and EventBus
+func meterSegment(job *SegmentJob, start time.Time, err error) {
+	if Metering == nil && EventBus == nil {
+		return
+	}
+	rec := newUsageRecord(job, start, err)
+	Metering.record(rec)
+	EventBus.publish(eventUsage, rec)
+}
+
+func newUsageRecord(job *SegmentJob, start time.Time, err error) *usageRecord {
+	seg := job.Segment
+	rec := &usageRecord{
+		ManifestID:  string(job.ManifestID),
+		SeqNo:       seg.SeqNo,
+		Time:        start.UTC().Format(time.RFC3339Nano),
+		Duration:    seg.Duration,
+		SourceBytes: int64(len(seg.Data)),
+		TranscodeMs: int64(job.TranscodeTook / time.Millisecond),
+	}
+	if job.cxn != nil {
+		rec.Labels = job.cxn.params.Labels.Get()
+	}
+	switch {
+	case err != nil:
+		rec.Status = segmentFailedPrefix + string(errorCategory(err))
+	case job.Session == nil:
+		rec.Status = segmentUntranscoded
+	default:
+		rec.Status = segmentTranscoded
+	}
+	if job.Session != nil && job.Session.OrchestratorInfo != nil {
+		info := job.Session.OrchestratorInfo
+		rec.Orchestrator = info.Transcoder
+		rec.PricePerUnit = info.GetPriceInfo().GetPricePerUnit()
+		rec.PixelsPerUnit = info.GetPriceInfo().GetPixelsPerUnit()
+	}
+	if job.Result != nil && job.Result.TranscodeData != nil && job.Session != nil {
+		profiles := job.Session.Params.Profiles
+		for i, s := range job.Result.Segments {
+			rec.Pixels += s.Pixels
+			r := &usageRendition{Pixels: s.Pixels}
+			if i < len(profiles) {
+				r.Name = profiles[i].Name
+			}
+			if i < len(job.Sizes) && job.Sizes[i] >= 0 {
+				size := job.Sizes[i]
+				r.Bytes = &size
+			}
+			rec.Renditions = append(rec.Renditions, r)
+		}
+	}
+	return rec
+}
+
+// record queues `rec` for delivery
+func (m *UsageMeter) record(rec *usageRecord) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.pending = append(m.pending, rec)
+	full := len(m.pending) >= m.batchSize
+	m.mu.Unlock()
+	if full {
+		select {
+		case m.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (m *UsageMeter) run(interval time.Duration) {
+	t := clock.NewTimer(interval)
+	for {
+		select {
+		case <-t.C():
+		case <-m.full:
+			if !t.Stop() {
+				<-t.C()
+			}
+		}
+		if err := m.flush(); err != nil {
+			glog.Errorf("Error delivering usage records err=%v", err)
+		}
+		t.Reset(interval)
+	}
+}
+
+// flush delivers the pending records in batches, keeping those of the first
+// batch that fails for the next flush
+func (m *UsageMeter) flush() error {
+	m.mu.Lock()
+	recs := m.pending
+	m.pending = nil
+	m.mu.Unlock()
+
+	for len(recs) > 0 {
+		n := m.batchSize
+		if n > len(recs) {
+			n = len(recs)
+		}
+		if err := m.sink.send(recs[:n]); err != nil {
+			m.requeue(recs)
+			return err
+		}
+		recs = recs[n:]
+	}
+	return nil
+}
+
+// requeue puts undelivered records back ahead of those recorded since,
+// dropping the oldest beyond maxPendingBatches
+func (m *UsageMeter) requeue(recs []*usageRecord) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending = append(recs, m.pending...)
+	if max := maxPendingBatches * m.batchSize; len(m.pending) > max {
+		drop := len(m.pending) - max
+		m.dropped += drop
+		m.pending = m.pending[drop:]
+		glog.Errorf("Dropped undelivered usage records count=%d total=%d", drop, m.dropped)
+	}
+}
+
+// fileMeteringSink writes records as JSON lines
+type fileMeteringSink struct {
+	w io.Writer
+}
+
+func (s *fileMeteringSink) send(recs []*usageRecord) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, rec := range recs {
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	_, err := s.w.Write(buf.Bytes())
+	return err
+}
+
+// httpMeteringSink POSTs batches of records as JSON arrays
+type httpMeteringSink struct {
+	url string
+}
+
+func (s *httpMeteringSink) send(recs []*usageRecord) error {
+	body, err := json.Marshal(recs)
+	if err != nil {
+		return err
+	}
+	resp, err := meteringClient.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return common.RedactError(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		rbody, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("status=%d error=%s", resp.StatusCode, string(rbody))
+	}
+	return nil
+}
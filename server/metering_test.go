@@ -0,0 +1,171 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/net"
+	"github.com/livepeer/lpms/ffmpeg"
+	"github.com/livepeer/lpms/stream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type stubMeteringSink struct {
+	batches [][]*usageRecord
+	err     error
+}
+
+func (s *stubMeteringSink) send(recs []*usageRecord) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.batches = append(s.batches, append([]*usageRecord(nil), recs...))
+	return nil
+}
+
+func meteredJob(seqNo uint64) *SegmentJob {
+	return &SegmentJob{
+		ManifestID: "mani",
+		Segment:    &stream.HLSSegment{SeqNo: seqNo, Duration: 2, Data: []byte("source")},
+		Session: &BroadcastSession{
+			Params:           &core.StreamParameters{Profiles: []ffmpeg.VideoProfile{ffmpeg.P240p30fps16x9, ffmpeg.P360p30fps16x9}},
+			OrchestratorInfo: &net.OrchestratorInfo{Transcoder: "https://orch", PriceInfo: &net.PriceInfo{PricePerUnit: 3, PixelsPerUnit: 2}},
+		},
+		Result: &ReceivedTranscodeResult{TranscodeData: &net.TranscodeData{Segments: []*net.TranscodedSegmentData{
+			{Url: "a", Pixels: 100},
+			{Url: "b", Pixels: 200},
+		}}},
+		Sizes:         []int64{10, -1},
+		TranscodeTook: 1500 * time.Millisecond,
+	}
+}
+
+func TestUsageMeter_Record(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var m *UsageMeter
+	m.record(newUsageRecord(meteredJob(1), time.Now(), nil))
+
+	sink := &stubMeteringSink{}
+	m = newUsageMeter(sink, 10)
+	start := time.Unix(1600000000, 0)
+	m.record(newUsageRecord(meteredJob(1), start, nil))
+	untranscoded := meteredJob(2)
+	untranscoded.Session, untranscoded.Result = nil, nil
+	m.record(newUsageRecord(untranscoded, start, nil))
+	m.record(newUsageRecord(meteredJob(3), start, errSegmentQueueFull))
+	require.Nil(m.flush())
+	require.Len(sink.batches, 1)
+	recs := sink.batches[0]
+	require.Len(recs, 3)
+
+	size := int64(10)
+	assert.Equal(&usageRecord{
+		ManifestID:  "mani",
+		SeqNo:       1,
+		Time:        "2020-09-13T12:26:40Z",
+		Status:      segmentTranscoded,
+		Duration:    2,
+		SourceBytes: 6,
+		Pixels:      300,
+		Renditions: []*usageRendition{
+			{Name: "P240p30fps16x9", Pixels: 100, Bytes: &size},
+			{Name: "P360p30fps16x9", Pixels: 200},
+		},
+		TranscodeMs:   1500,
+		Orchestrator:  "https://orch",
+		PricePerUnit:  3,
+		PixelsPerUnit: 2,
+	}, recs[0])
+	assert.Equal(segmentUntranscoded, recs[1].Status)
+	assert.Empty(recs[1].Renditions)
+	assert.Empty(recs[1].Orchestrator)
+	assert.Equal("failed:ingest", recs[2].Status)
+
+	// nothing left to deliver
+	require.Nil(m.flush())
+	assert.Len(sink.batches, 1)
+}
+
+func TestUsageMeter_Flush(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	sink := &stubMeteringSink{}
+	m := newUsageMeter(sink, 2)
+	for i := 1; i <= 5; i++ {
+		m.record(newUsageRecord(meteredJob(uint64(i)), time.Now(), nil))
+	}
+	// a full batch triggers a flush
+	select {
+	case <-m.full:
+	default:
+		t.Error("full batch not signalled")
+	}
+	require.Nil(m.flush())
+	require.Len(sink.batches, 3)
+	assert.Len(sink.batches[0], 2)
+	assert.Len(sink.batches[2], 1)
+	assert.Equal(uint64(5), sink.batches[2][0].SeqNo)
+
+	// undelivered records are kept for the next flush, ahead of newer ones
+	sink.batches, sink.err = nil, errors.New("unavailable")
+	m.record(newUsageRecord(meteredJob(6), time.Now(), nil))
+	assert.Equal(sink.err, m.flush())
+	m.record(newUsageRecord(meteredJob(7), time.Now(), nil))
+	sink.err = nil
+	require.Nil(m.flush())
+	require.Len(sink.batches, 1)
+	assert.Equal(uint64(6), sink.batches[0][0].SeqNo)
+	assert.Equal(uint64(7), sink.batches[0][1].SeqNo)
+
+	// up to a bound
+	sink.batches, sink.err = nil, errors.New("unavailable")
+	for i := 0; i < 2*maxPendingBatches+3; i++ {
+		m.record(newUsageRecord(meteredJob(uint64(i)), time.Now(), nil))
+	}
+	assert.NotNil(m.flush())
+	assert.Len(m.pending, 2*maxPendingBatches)
+	assert.Equal(3, m.dropped)
+	assert.Equal(uint64(3), m.pending[0].SeqNo)
+}
+
+func TestUsageMeter_Sinks(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	recs := []*usageRecord{{ManifestID: "a", SeqNo: 1}, {ManifestID: "a", SeqNo: 2}}
+
+	var buf bytes.Buffer
+	require.Nil((&fileMeteringSink{w: &buf}).send(recs))
+	assert.Equal(`{"manifestID":"a","seqNo":1,"time":"","status":"","duration":0,"sourceBytes":0,"pixels":0,"transcodeMs":0}`+"\n"+
+		`{"manifestID":"a","seqNo":2,"time":"","status":"","duration":0,"sourceBytes":0,"pixels":0,"transcodeMs":0}`+"\n", buf.String())
+
+	status := http.StatusOK
+	var got []*usageRecord
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("application/json", r.Header.Get("Content-Type"))
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Nil(json.Unmarshal(body, &got))
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+	sink := &httpMeteringSink{url: ts.URL}
+	require.Nil(sink.send(recs))
+	assert.Equal(recs, got)
+	status = http.StatusServiceUnavailable
+	assert.EqualError(sink.send(recs), "status=503 error=")
+
+	_, err := NewUsageMeter("kafka://broker/topic", 10, time.Second)
+	assert.EqualError(err, "unsupported metering sink: kafka://broker/topic")
+	_, err = NewUsageMeter(ts.URL, 0, time.Second)
+	assert.NotNil(err)
+}
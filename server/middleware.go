@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+)
+
+// mediaRoutes are the HTTPMux route prefixes that registered middleware applies to
+var mediaRoutes = []string{"/live/", "/recordings/", "/stream/", hlsKeyPrefix}
+
+// Middleware wraps the media server handler, e.g. to add authentication or
+// quotas. It may respond to the request itself instead of calling `next`.
+type Middleware func(next http.Handler) http.Handler
+
+// Use registers middleware for the /live/, /recordings/, /stream/ and /hlskeys/ routes
+// of HTTPMux. Middleware runs in registration order, so the first one
+// registered sees the request first. Must be called before StartMediaServer.
+func (s *LivepeerServer) Use(mw ...Middleware) {
+	s.middleware = append(s.middleware, mw...)
+}
+
+// mediaHandler returns HTTPMux with the registered middleware applied to the
+// media routes; other routes are served by HTTPMux directly.
+func (s *LivepeerServer) mediaHandler() http.Handler {
+	if len(s.middleware) == 0 {
+		return s.HTTPMux
+	}
+	var wrapped http.Handler = s.HTTPMux
+	for i := len(s.middleware) - 1; i >= 0; i-- {
+		wrapped = s.middleware[i](wrapped)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isMediaRoute(r.URL.Path) {
+			wrapped.ServeHTTP(w, r)
+			return
+		}
+		s.HTTPMux.ServeHTTP(w, r)
+	})
+}
+
+func isMediaRoute(path string) bool {
+	for _, prefix := range mediaRoutes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMiddleware_MediaRoutes(t *testing.T) {
+	assert := assert.New(t)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	s := &LivepeerServer{HTTPMux: mux}
+
+	// no middleware registered
+	assert.Equal(http.Handler(mux), s.mediaHandler())
+
+	var order []string
+	s.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			order = append(order, "first")
+			if r.Header.Get("Authorization") == "" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			order = append(order, "second")
+			next.ServeHTTP(w, r)
+		})
+	})
+	h := s.mediaHandler()
+
+	serve := func(path, auth string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	for _, path := range []string{"/live/mani/0.ts", "/recordings/mani/index.m3u8", "/stream/mani.m3u8"} {
+		order = nil
+		assert.Equal(http.StatusUnauthorized, serve(path, ""), path)
+		assert.Equal([]string{"first"}, order)
+		order = nil
+		assert.Equal(http.StatusOK, serve(path, "token"), path)
+		assert.Equal([]string{"first", "second"}, order)
+	}
+
+	// other routes bypass the middleware
+	order = nil
+	assert.Equal(http.StatusOK, serve("/status", ""))
+	assert.Empty(order)
+}
@@ -0,0 +1,71 @@
+package server
+
+import (
+	"io"
+	"net/textproto"
+
+	"github.com/livepeer/go-livepeer/common"
+)
+
+// partWriter writes a multipart body the same way as mime/multipart.Writer,
+// but assembles the boundaries and part headers in a pooled buffer rather
+// than allocating them for every part of every segment.
+type partWriter struct {
+	w        io.Writer
+	boundary string
+	parts    int
+}
+
+func newPartWriter(w io.Writer, boundary string) *partWriter {
+	return &partWriter{w: w, boundary: boundary}
+}
+
+// CreatePart writes the headers of a new part. The part's body is then
+// written to the returned writer.
+func (pw *partWriter) CreatePart(hdrs textproto.MIMEHeader) (io.Writer, error) {
+	b := common.GetBuffer()
+	defer common.PutBuffer(b)
+	if pw.parts > 0 {
+		b.WriteString("\r\n")
+	}
+	pw.parts++
+	b.WriteString("--")
+	b.WriteString(pw.boundary)
+	b.WriteString("\r\n")
+
+	// Headers are written in sorted order, as by mime/multipart
+	var keys [8]string
+	sorted := keys[:0]
+	for k := range hdrs {
+		i := len(sorted)
+		sorted = append(sorted, k)
+		for ; i > 0 && sorted[i-1] > k; i-- {
+			sorted[i] = sorted[i-1]
+		}
+		sorted[i] = k
+	}
+	for _, k := range sorted {
+		for _, v := range hdrs[k] {
+			b.WriteString(k)
+			b.WriteString(": ")
+			b.WriteString(v)
+			b.WriteString("\r\n")
+		}
+	}
+	b.WriteString("\r\n")
+	if _, err := pw.w.Write(b.Bytes()); err != nil {
+		return nil, err
+	}
+	return pw.w, nil
+}
+
+// Close writes the closing boundary
+func (pw *partWriter) Close() error {
+	b := common.GetBuffer()
+	defer common.PutBuffer(b)
+	b.WriteString("\r\n--")
+	b.WriteString(pw.boundary)
+	b.WriteString("--\r\n")
+	_, err := pw.w.Write(b.Bytes())
+	return err
+}
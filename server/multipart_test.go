@@ -0,0 +1,68 @@
+package server
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/textproto"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartWriter_MatchesMultipart(t *testing.T) {
+	assert := assert.New(t)
+
+	parts := []struct {
+		hdrs textproto.MIMEHeader
+		body string
+	}{
+		{textproto.MIMEHeader{
+			"Content-Type":        {`video/mp2t; name="P144p30fps16x9_1.ts"`},
+			"Content-Length":      {"4"},
+			"Content-Disposition": {`attachment; filename="P144p30fps16x9_1.ts"`},
+			"Rendition-Name":      {"P144p30fps16x9"},
+		}, "abcd"},
+		{textproto.MIMEHeader{
+			"Content-Type": {"application/vnd+livepeer.uri"},
+			"X-Multi":      {"b", "a"},
+		}, "https://example.com/1.ts"},
+		{textproto.MIMEHeader{}, ""},
+	}
+
+	for n := 0; n <= len(parts); n++ {
+		var expected, actual bytes.Buffer
+		mw := multipart.NewWriter(&expected)
+		assert.Nil(mw.SetBoundary("boundary"))
+		pw := newPartWriter(&actual, "boundary")
+		for _, p := range parts[:n] {
+			w, err := mw.CreatePart(p.hdrs)
+			assert.Nil(err)
+			w.Write([]byte(p.body))
+			w, err = pw.CreatePart(p.hdrs)
+			assert.Nil(err)
+			w.Write([]byte(p.body))
+		}
+		assert.Nil(mw.Close())
+		assert.Nil(pw.Close())
+		assert.Equal(expected.String(), actual.String())
+	}
+}
+
+func BenchmarkPartWriter(b *testing.B) {
+	hdrs := textproto.MIMEHeader{
+		"Content-Type":        {`video/mp2t; name="P144p30fps16x9_1.ts"`},
+		"Content-Length":      {"4"},
+		"Content-Disposition": {`attachment; filename="P144p30fps16x9_1.ts"`},
+		"Rendition-Name":      {"P144p30fps16x9"},
+	}
+	var out bytes.Buffer
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		out.Reset()
+		pw := newPartWriter(&out, "boundary")
+		for j := 0; j < 4; j++ {
+			pw.CreatePart(hdrs)
+		}
+		pw.Close()
+	}
+}
@@ -0,0 +1,331 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"sync"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/net"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errOrchDraining = newError(ErrorCategoryDiscovery, true, "orchestrator is draining")
+
+// OrchNotifications enables subscribing to orchestrator notifications on the
+// broadcaster so that sessions can be reselected ahead of orchestrator changes
+var OrchNotifications = false
+
+var orchNotificationsRetry = 30 * time.Second
+
+var subscribeOrchNotificationsRPC = subscribeOrchNotifications
+
+// Orchestrator side
+
+type orchEvent struct {
+	typ         net.OrchestratorNotification_Type
+	effectiveAt time.Time
+}
+
+// orchNotifier fans out orchestrator events to the notification streams of
+// all subscribed broadcasters
+type orchNotifier struct {
+	mu       sync.Mutex
+	subs     map[chan orchEvent]struct{}
+	draining bool
+	// drainTimer starts a drain scheduled for later
+	drainTimer Timer
+}
+
+var orchNotifications = newOrchNotifier()
+
+func newOrchNotifier() *orchNotifier {
+	return &orchNotifier{subs: make(map[chan orchEvent]struct{})}
+}
+
+func (n *orchNotifier) subscribe() chan orchEvent {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	ch := make(chan orchEvent, 8)
+	n.subs[ch] = struct{}{}
+	return ch
+}
+
+func (n *orchNotifier) unsubscribe(ch chan orchEvent) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.subs, ch)
+}
+
+// notify sends an event to all subscribers. Subscribers that are too slow to
+// keep up miss the event rather than block the caller.
+func (n *orchNotifier) notify(typ net.OrchestratorNotification_Type, effectiveAt time.Time) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	ev := orchEvent{typ: typ, effectiveAt: effectiveAt}
+	for ch := range n.subs {
+		select {
+		case ch <- ev:
+		default:
+			glog.Warningf("Dropping orchestrator notification type=%s for slow subscriber", typ)
+		}
+	}
+}
+
+// setDraining marks the orchestrator as (not) accepting new sessions from
+// `effectiveAt` on. Subscribers are notified right away when a drain is set,
+// so that they can move away before it starts.
+func (n *orchNotifier) setDraining(draining bool, effectiveAt time.Time) {
+	n.mu.Lock()
+	if n.drainTimer != nil {
+		n.drainTimer.Stop()
+		n.drainTimer = nil
+	}
+	delay := effectiveAt.Sub(clock.Now())
+	if draining && delay > 0 {
+		var t Timer
+		t = clock.AfterFunc(delay, func() {
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			// a later call may have replaced the drain
+			if n.drainTimer == t {
+				n.draining = true
+				n.drainTimer = nil
+				glog.Info("Orchestrator drain started")
+			}
+		})
+		n.drainTimer = t
+	} else {
+		n.draining = draining
+	}
+	n.mu.Unlock()
+	if draining {
+		n.notify(net.OrchestratorNotification_DRAIN, effectiveAt)
+	}
+}
+
+func (n *orchNotifier) isDraining() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.draining
+}
+
+func (h *lphttp) Notifications(req *net.OrchestratorRequest, stream net.Orchestrator_NotificationsServer) error {
+	return notifyBroadcaster(h.orchestrator, req, stream)
+}
+
+func notifyBroadcaster(orch Orchestrator, req *net.OrchestratorRequest, stream net.Orchestrator_NotificationsServer) error {
+	addr := ethcommon.BytesToAddress(req.Address)
+	if !orch.VerifySig(addr, addr.Hex(), req.Sig) {
+		glog.Error("orchestrator notifications req sig check failed")
+		return errors.New("orchestrator req sig check failed")
+	}
+
+	ch := orchNotifications.subscribe()
+	defer orchNotifications.unsubscribe(ch)
+	glog.V(common.DEBUG).Infof("Broadcaster subscribed to notifications addr=%s", addr.Hex())
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			glog.V(common.DEBUG).Infof("Broadcaster unsubscribed from notifications addr=%s", addr.Hex())
+			return nil
+		case ev := <-ch:
+			n := &net.OrchestratorNotification{
+				Type:        ev.typ,
+				EffectiveAt: ev.effectiveAt.Unix(),
+			}
+			if ev.typ != net.OrchestratorNotification_DRAIN {
+				info, err := orchestratorInfo(orch, addr, orch.ServiceURI().String(), "")
+				if err != nil {
+					glog.Errorf("Error getting orchestrator info for notification addr=%s err=%v", addr.Hex(), err)
+				} else {
+					n.Info = info
+				}
+			}
+			if err := stream.Send(n); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Broadcaster side
+
+// orchSubscriptions tracks the notification streams the broadcaster has open.
+// There is at most one stream per orchestrator, shared by all the session
+// managers that hold a session with that orchestrator.
+type orchSubscriptions struct {
+	mu   sync.Mutex
+	subs map[string]*orchSubscription
+}
+
+type orchSubscription struct {
+	listeners map[*BroadcastSessionsManager]struct{}
+	cancel    context.CancelFunc
+}
+
+var orchSubs = &orchSubscriptions{subs: make(map[string]*orchSubscription)}
+
+// watch registers the session manager for notifications from the session's orchestrator
+func (s *orchSubscriptions) watch(bsm *BroadcastSessionsManager, sess *BroadcastSession) {
+	uri := sess.OrchestratorInfo.GetTranscoder()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.subs[uri]
+	if !ok {
+		u, err := url.Parse(uri)
+		if err != nil {
+			return
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		sub = &orchSubscription{
+			listeners: make(map[*BroadcastSessionsManager]struct{}),
+			cancel:    cancel,
+		}
+		s.subs[uri] = sub
+		go s.run(ctx, sub, sess, u)
+	}
+	sub.listeners[bsm] = struct{}{}
+}
+
+// unwatch removes the session manager from all subscriptions, closing
+// streams that no longer have any listeners
+func (s *orchSubscriptions) unwatch(bsm *BroadcastSessionsManager) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for uri, sub := range s.subs {
+		delete(sub.listeners, bsm)
+		if len(sub.listeners) == 0 {
+			sub.cancel()
+			delete(s.subs, uri)
+		}
+	}
+}
+
+func (s *orchSubscriptions) dispatch(uri string, n *net.OrchestratorNotification) {
+	s.mu.Lock()
+	sub, ok := s.subs[uri]
+	if !ok {
+		s.mu.Unlock()
+		return
+	}
+	listeners := make([]*BroadcastSessionsManager, 0, len(sub.listeners))
+	for bsm := range sub.listeners {
+		listeners = append(listeners, bsm)
+	}
+	s.mu.Unlock()
+
+	glog.Infof("Received orchestrator notification orch=%s type=%s effectiveAt=%d", uri, n.Type, n.EffectiveAt)
+	for _, bsm := range listeners {
+		bsm.orchNotification(uri, n)
+	}
+}
+
+func (s *orchSubscriptions) remove(uri string, sub *orchSubscription) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subs[uri] == sub {
+		sub.cancel()
+		delete(s.subs, uri)
+	}
+}
+
+// run keeps the notification stream to an orchestrator open until there are
+// no more listeners or the orchestrator does not support notifications
+func (s *orchSubscriptions) run(ctx context.Context, sub *orchSubscription, sess *BroadcastSession, uri *url.URL) {
+	key := sess.OrchestratorInfo.GetTranscoder()
+	for {
+		err := subscribeOrchNotificationsRPC(ctx, sess.Broadcaster, uri, func(n *net.OrchestratorNotification) {
+			s.dispatch(key, n)
+		})
+		if ctx.Err() != nil {
+			return
+		}
+		if status.Code(err) == codes.Unimplemented {
+			glog.V(common.DEBUG).Infof("Orchestrator does not support notifications orch=%s", key)
+			s.remove(key, sub)
+			return
+		}
+		glog.V(common.DEBUG).Infof("Orchestrator notification stream ended orch=%s err=%v", key, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(orchNotificationsRetry):
+		}
+	}
+}
+
+func subscribeOrchNotifications(ctx context.Context, bcast common.Broadcaster, uri *url.URL, handle func(*net.OrchestratorNotification)) error {
+	c, conn, err := startOrchestratorClient(uri)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	req, err := genOrchestratorReq(bcast)
+	if err != nil {
+		return err
+	}
+	stream, err := c.Notifications(ctx, req)
+	if err != nil {
+		return err
+	}
+	for {
+		n, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		handle(n)
+	}
+}
+
+// orchNotification drops the session with the notifying orchestrator, if
+// the notification makes it unusable, so that the next segment goes to a
+// freshly selected orchestrator instead
+func (bsm *BroadcastSessionsManager) orchNotification(uri string, n *net.OrchestratorNotification) {
+	bsm.sessLock.Lock()
+	sess, ok := bsm.sessMap[uri]
+	if !ok || bsm.finished || !orchNotificationAffects(sess, n) {
+		bsm.sessLock.Unlock()
+		return
+	}
+	delete(bsm.sessMap, uri)
+	bsm.sessLock.Unlock()
+
+	glog.Infof("Removing orch=%s from manifestID=%s session list after notification type=%s", uri, bsm.mid, n.Type)
+	if n.Type == net.OrchestratorNotification_DRAIN {
+		bsm.suspendOrch(sess)
+	}
+	go bsm.refreshSessions()
+}
+
+// orchNotificationAffects returns whether the session can no longer be used
+// after the notification. Price and capability changes only affect sessions
+// whose orchestrator became too expensive or lost capabilities the stream
+// needs; without the new orchestrator info that cannot be told.
+func orchNotificationAffects(sess *BroadcastSession, n *net.OrchestratorNotification) bool {
+	switch n.Type {
+	case net.OrchestratorNotification_PRICE_CHANGE:
+		if n.Info == nil {
+			return true
+		}
+		price, err := common.RatPriceInfo(n.Info.GetPriceInfo())
+		if err != nil || price == nil {
+			return true
+		}
+		maxPrice := BroadcastCfg.MaxPrice()
+		return maxPrice != nil && price.Cmp(maxPrice) > 0
+	case net.OrchestratorNotification_CAPABILITIES_CHANGE:
+		if n.Info == nil || sess.Params == nil {
+			return true
+		}
+		return !sess.Params.Capabilities.CompatibleWith(n.Info.GetCapabilities())
+	}
+	return true
+}
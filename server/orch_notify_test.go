@@ -0,0 +1,273 @@
+package server
+
+import (
+	"context"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/net"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type stubNotificationsStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent chan *net.OrchestratorNotification
+}
+
+func (s *stubNotificationsStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *stubNotificationsStream) Send(n *net.OrchestratorNotification) error {
+	s.sent <- n
+	return nil
+}
+
+func TestOrchNotifier_Drain(t *testing.T) {
+	assert := assert.New(t)
+
+	n := newOrchNotifier()
+	ch := n.subscribe()
+	assert.False(n.isDraining())
+
+	now := time.Now()
+	n.setDraining(true, now)
+	assert.True(n.isDraining())
+	ev := <-ch
+	assert.Equal(net.OrchestratorNotification_DRAIN, ev.typ)
+	assert.Equal(now, ev.effectiveAt)
+
+	// no event when draining stops
+	n.setDraining(false, now)
+	assert.False(n.isDraining())
+	assert.Len(ch, 0)
+
+	// drains set for later are announced right away but only start then
+	c := useFakeClock(t)
+	n.setDraining(true, c.Now().Add(time.Minute))
+	assert.False(n.isDraining())
+	ev = <-ch
+	assert.Equal(net.OrchestratorNotification_DRAIN, ev.typ)
+	assert.Equal(c.Now().Add(time.Minute), ev.effectiveAt)
+	c.Advance(time.Minute)
+	for !n.isDraining() {
+		time.Sleep(time.Millisecond)
+	}
+
+	// stopping the drain cancels one that is scheduled
+	n.setDraining(false, c.Now())
+	n.setDraining(true, c.Now().Add(time.Minute))
+	<-ch
+	n.setDraining(false, c.Now())
+	c.Advance(time.Minute)
+	time.Sleep(10 * time.Millisecond)
+	assert.False(n.isDraining())
+	assert.Len(ch, 0)
+
+	// unsubscribed channels do not receive events
+	n.unsubscribe(ch)
+	n.notify(net.OrchestratorNotification_PRICE_CHANGE, now)
+	assert.Len(ch, 0)
+}
+
+func TestNotifyBroadcaster(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	orch := newStubOrchestrator()
+	orch.priceInfo = &net.PriceInfo{PricePerUnit: 2, PixelsPerUnit: 1}
+	b := stubBroadcaster2()
+
+	// invalid signature
+	req, err := genOrchestratorReq(b)
+	require.Nil(err)
+	req.Sig = []byte("foo")
+	stream := &stubNotificationsStream{ctx: context.Background(), sent: make(chan *net.OrchestratorNotification, 1)}
+	assert.EqualError(notifyBroadcaster(orch, req, stream), "orchestrator req sig check failed")
+
+	req, err = genOrchestratorReq(b)
+	require.Nil(err)
+	ctx, cancel := context.WithCancel(context.Background())
+	stream = &stubNotificationsStream{ctx: ctx, sent: make(chan *net.OrchestratorNotification, 1)}
+	done := make(chan error)
+	go func() { done <- notifyBroadcaster(orch, req, stream) }()
+
+	// wait for the subscription
+	for {
+		orchNotifications.mu.Lock()
+		l := len(orchNotifications.subs)
+		orchNotifications.mu.Unlock()
+		if l > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	now := time.Now()
+	orchNotifications.notify(net.OrchestratorNotification_PRICE_CHANGE, now)
+	n := <-stream.sent
+	assert.Equal(net.OrchestratorNotification_PRICE_CHANGE, n.Type)
+	assert.Equal(now.Unix(), n.EffectiveAt)
+	require.NotNil(n.Info)
+	assert.Equal(int64(2), n.Info.PriceInfo.PricePerUnit)
+
+	orchNotifications.setDraining(true, now)
+	defer orchNotifications.setDraining(false, now)
+	n = <-stream.sent
+	assert.Equal(net.OrchestratorNotification_DRAIN, n.Type)
+	assert.Nil(n.Info)
+
+	// new sessions are refused while draining
+	assert.Equal(errOrchDraining, verifyOrchestratorReq(orch, b.Address(), req.Sig))
+
+	cancel()
+	assert.Nil(<-done)
+}
+
+func TestOrchSubscriptions(t *testing.T) {
+	assert := assert.New(t)
+
+	subscribed := make(chan string, 2)
+	stop := make(chan struct{})
+	var handler func(*net.OrchestratorNotification)
+	oldRPC := subscribeOrchNotificationsRPC
+	defer func() { subscribeOrchNotificationsRPC = oldRPC }()
+	subscribeOrchNotificationsRPC = func(ctx context.Context, bcast common.Broadcaster, uri *url.URL, handle func(*net.OrchestratorNotification)) error {
+		if uri.String() == "transcoder2" {
+			return status.Error(codes.Unimplemented, "unimplemented")
+		}
+		handler = handle
+		subscribed <- uri.String()
+		select {
+		case <-ctx.Done():
+		case <-stop:
+		}
+		return ctx.Err()
+	}
+
+	subs := &orchSubscriptions{subs: make(map[string]*orchSubscription)}
+	bsm := StubBroadcastSessionsManager()
+	// keep the refresh from adding the removed session back
+	bsm.createSessions = func() ([]*BroadcastSession, error) { return nil, nil }
+	sess1 := bsm.sessMap["transcoder1"]
+	sess2 := bsm.sessMap["transcoder2"]
+	subs.watch(bsm, sess1)
+	subs.watch(bsm, sess2)
+	assert.Equal("transcoder1", <-subscribed)
+
+	// orchestrators without notification support are dropped
+	for {
+		subs.mu.Lock()
+		l := len(subs.subs)
+		subs.mu.Unlock()
+		if l == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// a second manager shares the existing stream
+	bsm2 := StubBroadcastSessionsManager()
+	subs.watch(bsm2, sess1)
+	assert.Len(subscribed, 0)
+	assert.Len(subs.subs["transcoder1"].listeners, 2)
+
+	handler(&net.OrchestratorNotification{Type: net.OrchestratorNotification_DRAIN})
+	bsm.sessLock.Lock()
+	_, ok := bsm.sessMap["transcoder1"]
+	bsm.sessLock.Unlock()
+	assert.False(ok)
+	assert.NotZero(bsm.sus.Suspended("transcoder1"))
+
+	// stream is closed once the last listener is gone
+	subs.unwatch(bsm)
+	assert.Len(subs.subs, 1)
+	subs.unwatch(bsm2)
+	assert.Len(subs.subs, 0)
+	close(stop)
+}
+
+func TestOrchNotification_PriceChange(t *testing.T) {
+	assert := assert.New(t)
+
+	oldMaxPrice := BroadcastCfg.MaxPrice()
+	defer BroadcastCfg.SetMaxPrice(oldMaxPrice)
+	BroadcastCfg.SetMaxPrice(big.NewRat(2, 1))
+
+	bsm := StubBroadcastSessionsManager()
+	bsm.createSessions = func() ([]*BroadcastSession, error) { return nil, nil }
+	hasSession := func(uri string) bool {
+		bsm.sessLock.Lock()
+		defer bsm.sessLock.Unlock()
+		_, ok := bsm.sessMap[uri]
+		return ok
+	}
+	priceChange := func(price int64) *net.OrchestratorNotification {
+		return &net.OrchestratorNotification{
+			Type: net.OrchestratorNotification_PRICE_CHANGE,
+			Info: &net.OrchestratorInfo{PriceInfo: &net.PriceInfo{PricePerUnit: price, PixelsPerUnit: 1}},
+		}
+	}
+
+	// sessions are kept while the price is acceptable
+	bsm.orchNotification("transcoder1", priceChange(2))
+	assert.True(hasSession("transcoder1"))
+	BroadcastCfg.SetMaxPrice(nil)
+	bsm.orchNotification("transcoder1", priceChange(100))
+	assert.True(hasSession("transcoder1"))
+
+	BroadcastCfg.SetMaxPrice(big.NewRat(2, 1))
+	bsm.orchNotification("transcoder1", priceChange(3))
+	assert.False(hasSession("transcoder1"))
+	// price changes do not suspend the orchestrator
+	assert.Zero(bsm.sus.Suspended("transcoder1"))
+
+	// without the new price the session cannot be trusted
+	bsm.orchNotification("transcoder2", &net.OrchestratorNotification{Type: net.OrchestratorNotification_PRICE_CHANGE})
+	assert.False(hasSession("transcoder2"))
+
+	// unknown orchestrators are ignored
+	bsm.orchNotification("nope", &net.OrchestratorNotification{})
+}
+
+func TestOrchNotification_CapabilitiesChange(t *testing.T) {
+	assert := assert.New(t)
+
+	bsm := StubBroadcastSessionsManager()
+	bsm.createSessions = func() ([]*BroadcastSession, error) { return nil, nil }
+	hasSession := func(uri string) bool {
+		bsm.sessLock.Lock()
+		defer bsm.sessLock.Unlock()
+		_, ok := bsm.sessMap[uri]
+		return ok
+	}
+	caps := []core.Capability{core.Capability_H264, core.Capability_MPEGTS, core.Capability_GOP}
+	for _, uri := range []string{"transcoder1", "transcoder2"} {
+		bsm.sessMap[uri].Params.Capabilities = core.NewCapabilities(caps[:2], nil)
+	}
+	capsChange := func(caps []core.Capability) *net.OrchestratorNotification {
+		return &net.OrchestratorNotification{
+			Type: net.OrchestratorNotification_CAPABILITIES_CHANGE,
+			Info: &net.OrchestratorInfo{Capabilities: core.NewCapabilities(caps, nil).ToNetCapabilities()},
+		}
+	}
+
+	// sessions are kept while the orchestrator has what the stream needs
+	bsm.orchNotification("transcoder1", capsChange(caps[:2]))
+	assert.True(hasSession("transcoder1"))
+	bsm.orchNotification("transcoder1", capsChange([]core.Capability{core.Capability_H264}))
+	assert.False(hasSession("transcoder1"))
+	assert.Zero(bsm.sus.Suspended("transcoder1"))
+
+	bsm.orchNotification("transcoder2", &net.OrchestratorNotification{Type: net.OrchestratorNotification_CAPABILITIES_CHANGE})
+	assert.False(hasSession("transcoder2"))
+}
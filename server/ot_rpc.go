@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
@@ -36,8 +37,8 @@ import (
 const protoVerLPT = "Livepeer-Transcoder-1.0"
 const transcodingErrorMimeType = "livepeer/transcoding-error"
 
-var errSecret = errors.New("Invalid secret")
-var errZeroCapacity = errors.New("Zero capacity")
+var errSecret = newError(ErrorCategoryAuth, false, "Invalid secret")
+var errZeroCapacity = newError(ErrorCategoryTranscode, false, "Zero capacity")
 
 // Standalone Transcoder
 
@@ -131,7 +132,7 @@ func runTranscoder(n *core.LivepeerNode, orchAddr string, capacity int) error {
 
 func runTranscode(n *core.LivepeerNode, orchAddr string, httpc *http.Client, notify *net.NotifySegment) {
 
-	glog.Infof("Transcoding taskId=%d url=%s", notify.TaskId, notify.Url)
+	glog.Infof("Transcoding taskId=%d url=%s", notify.TaskId, common.RedactURL(notify.Url))
 	var contentType string
 	var body bytes.Buffer
 
@@ -147,7 +148,7 @@ func runTranscode(n *core.LivepeerNode, orchAddr string, httpc *http.Client, not
 
 	start := time.Now()
 	tData, err := n.Transcoder.Transcode(md)
-	glog.V(common.VERBOSE).Infof("Transcoding done for taskId=%d url=%s dur=%v err=%v", notify.TaskId, notify.Url, time.Since(start), err)
+	glog.V(common.VERBOSE).Infof("Transcoding done for taskId=%d url=%s dur=%v err=%v", notify.TaskId, common.RedactURL(notify.Url), time.Since(start), err)
 	if err == nil && len(tData.Segments) != len(profiles) {
 		err = errors.New("segment / profile mismatch")
 	}
@@ -170,6 +171,10 @@ func runTranscode(n *core.LivepeerNode, orchAddr string, httpc *http.Client, not
 				"Content-Length": {strconv.Itoa(len(v.Data))},
 				"Pixels":         {strconv.FormatInt(v.Pixels, 10)},
 			}
+			if v.Encoder != nil {
+				enc, _ := json.Marshal(v.Encoder)
+				hdrs.Set("Encoder-Settings", string(enc))
+			}
 			fw, err := w.CreatePart(hdrs)
 			if err != nil {
 				glog.Error("Could not create multipart part ", err)
@@ -199,7 +204,7 @@ func runTranscode(n *core.LivepeerNode, orchAddr string, httpc *http.Client, not
 		resp.Body.Close()
 	}
 	uploadDur := time.Since(uploadStart)
-	glog.V(common.VERBOSE).Infof("Transcoding done results sent for taskId=%d url=%s dur=%v err=%v", notify.TaskId, notify.Url, uploadDur, err)
+	glog.V(common.VERBOSE).Infof("Transcoding done results sent for taskId=%d url=%s dur=%v err=%v", notify.TaskId, common.RedactURL(notify.Url), uploadDur, err)
 
 	if monitor.Enabled {
 		monitor.SegmentUploaded(0, uint64(notify.TaskId), uploadDur)
@@ -309,7 +314,17 @@ func (h *lphttp) TranscodeResults(w http.ResponseWriter, r *http.Request) {
 				break
 			}
 
-			segments = append(segments, &core.TranscodedSegmentData{Data: body, Pixels: encodedPixels})
+			// Transcoders of earlier versions don't send their encoder settings
+			var encoder *core.EncoderSettings
+			if enc := p.Header.Get("Encoder-Settings"); enc != "" {
+				encoder = &core.EncoderSettings{}
+				if err := json.Unmarshal([]byte(enc), encoder); err != nil {
+					glog.Error("Error parsing encoder settings in header:", err)
+					encoder = nil
+				}
+			}
+
+			segments = append(segments, &core.TranscodedSegmentData{Data: body, Pixels: encodedPixels, Encoder: encoder})
 		}
 		res.TranscodeData = &core.TranscodeData{
 			Segments: segments,
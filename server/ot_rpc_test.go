@@ -32,7 +32,7 @@ type stubTranscoder struct {
 
 var testRemoteTranscoderResults = &core.TranscodeData{
 	Segments: []*core.TranscodedSegmentData{
-		{Data: []byte("body1"), Pixels: 777},
+		{Data: []byte("body1"), Pixels: 777, Encoder: &core.EncoderSettings{Codec: "libx264", BitrateMode: "cbr"}},
 		{Data: []byte("body2"), Pixels: 888},
 	},
 	Pixels: 999,
@@ -118,6 +118,12 @@ func TestRemoteTranscoder_Profiles(t *testing.T) {
 		assert.NoError(err)
 		assert.Equal(testRemoteTranscoderResults.Segments[i].Pixels, pixels)
 
+		if enc := testRemoteTranscoderResults.Segments[i].Encoder; enc != nil {
+			assert.JSONEq(`{"codec":"libx264","bitrate_mode":"cbr"}`, p.Header.Get("Encoder-Settings"))
+		} else {
+			assert.Empty(p.Header.Get("Encoder-Settings"))
+		}
+
 		assert.Equal("video/mp2t", strings.ToLower(p.Header.Get("Content-Type")))
 
 		i++
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/livepeer/go-livepeer/core"
+)
+
+var errPinnedElsewhere = newError(ErrorCategoryIngest, false, "ErrPinnedElsewhere")
+
+// IngestNodes, if set, pins each stream to one of the broadcasters sharing
+// an ingest hostname, so that all of its segments land on the same node.
+// Pushes reaching any other node are redirected to it.
+var IngestNodes *IngestCluster
+
+// IngestCluster is the set of broadcasters streams are pinned to, identified
+// by the base URLs they are reachable at directly. Streams are assigned by
+// rendezvous hashing of their manifest ID, so adding or removing a node only
+// moves the streams pinned to it.
+type IngestCluster struct {
+	self  string
+	nodes []string
+}
+
+// NewIngestCluster returns the cluster of the broadcasters at `nodes`, which
+// must include `self`, the URL of this node
+func NewIngestCluster(self string, nodes []string) (*IngestCluster, error) {
+	self, err := normalizeIngestNode(self)
+	if err != nil {
+		return nil, err
+	}
+	c := &IngestCluster{self: self}
+	found := false
+	for _, n := range nodes {
+		if strings.TrimSpace(n) == "" {
+			continue
+		}
+		n, err := normalizeIngestNode(n)
+		if err != nil {
+			return nil, err
+		}
+		found = found || n == self
+		c.nodes = append(c.nodes, n)
+	}
+	if !found {
+		return nil, fmt.Errorf("ingest node %s is not among the ingest nodes", self)
+	}
+	return c, nil
+}
+
+func normalizeIngestNode(node string) (string, error) {
+	u, err := url.Parse(strings.TrimSpace(node))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("invalid ingest node url=%s", node)
+	}
+	return u.Scheme + "://" + u.Host, nil
+}
+
+// owner returns the node `mid` is pinned to
+func (c *IngestCluster) owner(mid core.ManifestID) string {
+	var best string
+	var bestWeight uint64
+	for _, n := range c.nodes {
+		h := sha256.Sum256([]byte(n + "/" + string(mid)))
+		if w := binary.BigEndian.Uint64(h[:8]); best == "" || w > bestWeight {
+			best, bestWeight = n, w
+		}
+	}
+	return best
+}
+
+// pinnedNode returns the node other than this one that the stream `mid`
+// is pinned to, or "" if it is ingested here. `node`, if set by the auth
+// webhook, takes precedence over the hashed assignment.
+func (c *IngestCluster) pinnedNode(mid core.ManifestID, node string) (string, error) {
+	if c == nil {
+		return "", nil
+	}
+	if node == "" {
+		node = c.owner(mid)
+	} else {
+		var err error
+		if node, err = normalizeIngestNode(node); err != nil {
+			return "", err
+		}
+	}
+	if node == c.self {
+		return "", nil
+	}
+	return node, nil
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewIngestCluster(t *testing.T) {
+	assert := assert.New(t)
+
+	c, err := NewIngestCluster("https://b1:8935/", []string{"https://b1:8935", " https://b2:8935/live", ""})
+	assert.Nil(err)
+	assert.Equal("https://b1:8935", c.self)
+	assert.Equal([]string{"https://b1:8935", "https://b2:8935"}, c.nodes)
+
+	_, err = NewIngestCluster("https://b3:8935", []string{"https://b1:8935", "https://b2:8935"})
+	assert.EqualError(err, "ingest node https://b3:8935 is not among the ingest nodes")
+	_, err = NewIngestCluster("https://b1:8935", []string{"https://b1:8935", "b2:8935"})
+	assert.EqualError(err, "invalid ingest node url=b2:8935")
+	_, err = NewIngestCluster("", []string{"https://b1:8935"})
+	assert.EqualError(err, "invalid ingest node url=")
+}
+
+func TestIngestCluster_PinnedNode(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	nodes := []string{"https://b1:8935", "https://b2:8935", "https://b3:8935"}
+	clusters := make(map[string]*IngestCluster)
+	for _, n := range nodes {
+		c, err := NewIngestCluster(n, nodes)
+		require.Nil(err)
+		clusters[n] = c
+	}
+
+	owned := make(map[string]int)
+	for i := 0; i < 300; i++ {
+		mid := core.ManifestID(fmt.Sprintf("stream%d", i))
+		owner := clusters[nodes[0]].owner(mid)
+		owned[owner]++
+		// every node agrees on the owner, and ingests only its own streams
+		for n, c := range clusters {
+			node, err := c.pinnedNode(mid, "")
+			assert.Nil(err)
+			if n == owner {
+				assert.Empty(node)
+			} else {
+				assert.Equal(owner, node)
+			}
+		}
+	}
+	for _, n := range nodes {
+		assert.True(owned[n] > 50, "streams are spread over the nodes %v", owned)
+	}
+
+	// removing a node only moves its own streams
+	c, err := NewIngestCluster(nodes[0], nodes[:2])
+	require.Nil(err)
+	for i := 0; i < 300; i++ {
+		mid := core.ManifestID(fmt.Sprintf("stream%d", i))
+		if owner := clusters[nodes[0]].owner(mid); owner != nodes[2] {
+			assert.Equal(owner, c.owner(mid))
+		}
+	}
+
+	// webhook assignments take precedence
+	b1 := clusters[nodes[0]]
+	node, err := b1.pinnedNode("stream0", "https://b9:8935/")
+	assert.Nil(err)
+	assert.Equal("https://b9:8935", node)
+	node, err = b1.pinnedNode("stream0", "https://b1:8935")
+	assert.Nil(err)
+	assert.Empty(node)
+	_, err = b1.pinnedNode("stream0", "b2")
+	assert.EqualError(err, "invalid ingest node url=b2")
+
+	// without a cluster every stream is ingested here
+	var none *IngestCluster
+	node, err = none.pinnedNode("stream0", "https://b2:8935")
+	assert.Nil(err)
+	assert.Empty(node)
+}
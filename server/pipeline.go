@@ -0,0 +1,98 @@
+package server
+
+import (
+	"time"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/monitor"
+	"github.com/livepeer/go-livepeer/verification"
+	"github.com/livepeer/lpms/stream"
+)
+
+// SegmentStage names a stage of the processing of source segments by the
+// broadcaster
+type SegmentStage string
+
+// Stages of a source segment, in the order they run. Renditions are verified
+// once they are stored, as a failed verification restores the renditions of
+// an earlier attempt in place of the stored ones. The transcoding stages,
+// from select_session on, run again for each attempt at the segment.
+const (
+	StageValidate        SegmentStage = "validate"
+	StageStoreSource     SegmentStage = "store_source"
+	StageSelectSession   SegmentStage = "select_session"
+	StageSubmit          SegmentStage = "submit"
+	StageStoreRenditions SegmentStage = "store_renditions"
+	StageVerify          SegmentStage = "verify"
+	StageUpdatePlaylists SegmentStage = "update_playlists"
+)
+
+// SegmentJob is a source segment going through the stages of the broadcaster
+type SegmentJob struct {
+	ManifestID core.ManifestID
+	Nonce      uint64
+	Segment    *stream.HLSSegment
+	// Name the source is stored under, set by the store_source stage
+	Name string
+	// Session the segment is transcoded by, set by the select_session stage.
+	// A nil session after that stage leaves the segment untranscoded.
+	Session *BroadcastSession
+	// Result of the transcode, set by the submit stage
+	Result *ReceivedTranscodeResult
+	// URLs of the renditions, and their data if it was downloaded, in the
+	// order of the profiles of Session; set by the store_renditions stage
+	URLs []string
+	Data [][]byte
+	// URLs of the initialization segments of the renditions packaged as
+	// CMAF, empty for the others; set by the store_renditions stage
+	InitURLs []string
+	// Sizes of the renditions in bytes, -1 for those not passed through the
+	// broadcaster; set by the store_renditions stage
+	Sizes []int64
+	// Time the submit stage took
+	TranscodeTook time.Duration
+
+	cxn      *rtmpConnection
+	buf      *common.SegmentBuffer
+	verifier *verification.SegmentVerifier
+	errCode  monitor.SegmentTranscodeError
+}
+
+// SegmentHandler runs a stage for a segment
+type SegmentHandler func(job *SegmentJob) error
+
+// SegmentMiddleware wraps the handler of each stage, e.g. to check a segment
+// before it is stored or its renditions before they are published. It may
+// fail the stage, or run it in its own way, instead of calling `next`.
+type SegmentMiddleware func(stage SegmentStage, next SegmentHandler) SegmentHandler
+
+// UseSegmentMiddleware registers middleware for the segments of streams
+// created from then on. Middleware runs in registration order, so the first
+// one registered sees each stage first.
+func (s *LivepeerServer) UseSegmentMiddleware(mw ...SegmentMiddleware) {
+	s.segmentMiddleware = append(s.segmentMiddleware, mw...)
+}
+
+// segmentPipeline runs the stages of the segments of a stream through its
+// middleware. A nil *segmentPipeline runs the stages as they are.
+type segmentPipeline struct {
+	middleware []SegmentMiddleware
+}
+
+func newSegmentPipeline(mw []SegmentMiddleware) *segmentPipeline {
+	if len(mw) == 0 {
+		return nil
+	}
+	return &segmentPipeline{middleware: append([]SegmentMiddleware(nil), mw...)}
+}
+
+// run runs `stage` of `job` with handler `h`
+func (p *segmentPipeline) run(stage SegmentStage, job *SegmentJob, h SegmentHandler) error {
+	if p != nil {
+		for i := len(p.middleware) - 1; i >= 0; i-- {
+			h = p.middleware[i](stage, h)
+		}
+	}
+	return h(job)
+}
@@ -0,0 +1,89 @@
+package server
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/livepeer/lpms/ffmpeg"
+	"github.com/livepeer/lpms/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessSegment_Middleware(t *testing.T) {
+	assert := assert.New(t)
+
+	oldDownloadSeg := downloadSeg
+	defer func() { downloadSeg = oldDownloadSeg }()
+	downloadSeg = func(url string) ([]byte, error) { return []byte(url), nil }
+
+	var stages []string
+	trace := func(name string) SegmentMiddleware {
+		return func(stage SegmentStage, next SegmentHandler) SegmentHandler {
+			return func(job *SegmentJob) error {
+				stages = append(stages, name+":"+string(stage))
+				return next(job)
+			}
+		}
+	}
+	var published []string
+	publish := func(stage SegmentStage, next SegmentHandler) SegmentHandler {
+		if stage != StageUpdatePlaylists {
+			return next
+		}
+		return func(job *SegmentJob) error {
+			published = append(published, job.URLs...)
+			return next(job)
+		}
+	}
+	s := &LivepeerServer{}
+	s.UseSegmentMiddleware(trace("a"), trace("b"), publish)
+
+	bcastOS := &stubOSSession{host: "test://broad.com"}
+	sess := genBcastSess(t, "", bcastOS, "")
+	sourceProfile := ffmpeg.P240p30fps16x9
+	cxn := &rtmpConnection{
+		pl:          &stubPlaylistManager{os: bcastOS},
+		profile:     &sourceProfile,
+		sessManager: bsmWithSessList([]*BroadcastSession{sess}),
+		pipeline:    newSegmentPipeline(s.segmentMiddleware),
+	}
+	urls, err := processSegment(cxn, &stream.HLSSegment{}, nil)
+	assert.Nil(err)
+	assert.Equal([]string{"saved_P144p30fps16x9/0.ts"}, urls)
+	assert.Equal(urls, published)
+	// the first middleware registered sees each stage first
+	assert.Equal([]string{
+		"a:validate", "b:validate",
+		"a:store_source", "b:store_source",
+		"a:select_session", "b:select_session",
+		"a:submit", "b:submit",
+		"a:store_renditions", "b:store_renditions",
+		"a:verify", "b:verify",
+		"a:update_playlists", "b:update_playlists",
+	}, stages)
+
+	// middleware failing a stage stops the segment there
+	errRejected := errors.New("rejected")
+	stages = nil
+	reject := func(stage SegmentStage, next SegmentHandler) SegmentHandler {
+		if stage != StageStoreSource {
+			return next
+		}
+		return func(job *SegmentJob) error { return errRejected }
+	}
+	bcastOS = &stubOSSession{host: "test://broad.com"}
+	cxn.pl = &stubPlaylistManager{os: bcastOS}
+	cxn.pipeline = newSegmentPipeline([]SegmentMiddleware{reject, trace("a")})
+	urls, err = processSegment(cxn, &stream.HLSSegment{SeqNo: 1}, nil)
+	assert.Equal(errRejected, err)
+	assert.Nil(urls)
+	assert.Equal([]string{"a:validate"}, stages)
+	assert.Empty(bcastOS.saved)
+
+	// streams without middleware run the stages as they are
+	assert.Nil(newSegmentPipeline(nil))
+	var p *segmentPipeline
+	ran := false
+	assert.Nil(p.run(StageValidate, &SegmentJob{}, func(*SegmentJob) error { ran = true; return nil }))
+	assert.True(ran)
+}
@@ -0,0 +1,304 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errPlaybackSigMissing = newError(ErrorCategoryAuth, false, "ErrPlaybackSigMissing")
+	errPlaybackSigInvalid = newError(ErrorCategoryAuth, false, "ErrPlaybackSigInvalid")
+	errPlaybackSigExpired = newError(ErrorCategoryAuth, false, "ErrPlaybackSigExpired")
+	errPlaybackKeyUnknown = newError(ErrorCategoryAuth, false, "ErrPlaybackKeyUnknown")
+	errPlaybackKeyScope   = newError(ErrorCategoryAuth, false, "ErrPlaybackKeyScope")
+)
+
+// playbackTenantSep separates the key ID of a tenant from the rest of the
+// manifest IDs of its streams
+const playbackTenantSep = "-"
+
+// PlaybackSigner checks that requests for /stream/, /recordings/ and /hlskeys/
+// carry a URL signed with one of its keys. A signed URL has the query parameters
+//
+//	exp=<unix seconds>&kid=<key ID>&sig=<hex HMAC-SHA256 of "<kid>\n<manifest ID>\n<exp>">
+//
+// where kid is empty (and may be omitted) for the node's own key. The node's
+// key signs any manifest ID, while a tenant's key only signs the manifest IDs
+// of its streams, which start with "<kid>-", so that one tenant cannot grant
+// playback of another's streams. Key IDs may not contain "-" themselves. The
+// signature covers every playlist and segment of the manifest ID, and the
+// parameters are carried over into the URIs of the playlists served, so that
+// players need only be given the signed URL of the top level playlist.
+type PlaybackSigner struct {
+	mu   sync.RWMutex
+	keys map[string][]byte
+}
+
+// ParsePlaybackKeys reads a comma separated list of keys, each either
+// <key> for the node's own key or <key ID>:<key> for a tenant's key
+func ParsePlaybackKeys(keys string) (*PlaybackSigner, error) {
+	parsed, err := parsePlaybackKeys(keys)
+	if err != nil {
+		return nil, err
+	}
+	return &PlaybackSigner{keys: parsed}, nil
+}
+
+// SetKeys replaces the keys, eg when they are rotated, keeping the current
+// ones if `keys` is invalid
+func (p *PlaybackSigner) SetKeys(keys string) error {
+	parsed, err := parsePlaybackKeys(keys)
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.keys = parsed
+	p.mu.Unlock()
+	return nil
+}
+
+func (p *PlaybackSigner) key(kid string) ([]byte, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+func parsePlaybackKeys(keys string) (map[string][]byte, error) {
+	parsed := make(map[string][]byte)
+	for _, k := range strings.Split(keys, ",") {
+		k = strings.TrimSpace(k)
+		if k == "" {
+			continue
+		}
+		kid, key := "", k
+		if i := strings.Index(k, ":"); i >= 0 {
+			kid, key = k[:i], k[i+1:]
+		}
+		if key == "" {
+			return nil, fmt.Errorf("empty playback signing key for key ID %q", kid)
+		}
+		// the key ID is the first field of the manifest IDs it signs
+		if strings.Contains(kid, playbackTenantSep) {
+			return nil, fmt.Errorf("playback signing key ID %q contains %q", kid, playbackTenantSep)
+		}
+		if _, ok := parsed[kid]; ok {
+			return nil, fmt.Errorf("duplicate playback signing key for key ID %q", kid)
+		}
+		parsed[kid] = []byte(key)
+	}
+	if len(parsed) == 0 {
+		return nil, errors.New("no playback signing keys")
+	}
+	return parsed, nil
+}
+
+// playbackKeyGrants returns whether the key `kid` may sign `mid`: the node's
+// key signs any manifest ID, a tenant's key those whose first field is its
+// key ID
+func playbackKeyGrants(kid, mid string) bool {
+	if kid == "" {
+		return true
+	}
+	i := strings.Index(mid, playbackTenantSep)
+	return i > 0 && mid[:i] == kid
+}
+
+func (p *PlaybackSigner) mac(key []byte, kid, mid string, exp int64) []byte {
+	h := hmac.New(sha256.New, key)
+	fmt.Fprintf(h, "%s\n%s\n%d", kid, mid, exp)
+	return h.Sum(nil)
+}
+
+// Sign returns the query parameters granting access to `mid` until `expires`
+func (p *PlaybackSigner) Sign(mid, kid string, expires time.Time) (url.Values, error) {
+	key, ok := p.key(kid)
+	if !ok {
+		return nil, errPlaybackKeyUnknown
+	}
+	if !playbackKeyGrants(kid, mid) {
+		return nil, errPlaybackKeyScope
+	}
+	exp := expires.Unix()
+	q := url.Values{}
+	q.Set("exp", strconv.FormatInt(exp, 10))
+	if kid != "" {
+		q.Set("kid", kid)
+	}
+	q.Set("sig", hex.EncodeToString(p.mac(key, kid, mid, exp)))
+	return q, nil
+}
+
+// Verify checks the signature in `q` grants access to `mid` at `now`
+func (p *PlaybackSigner) Verify(mid string, q url.Values, now time.Time) error {
+	sig, expStr := q.Get("sig"), q.Get("exp")
+	if sig == "" || expStr == "" {
+		return errPlaybackSigMissing
+	}
+	kid := q.Get("kid")
+	key, ok := p.key(kid)
+	if !ok {
+		return errPlaybackKeyUnknown
+	}
+	if !playbackKeyGrants(kid, mid) {
+		return errPlaybackKeyScope
+	}
+	exp, err := strconv.ParseInt(expStr, 10, 64)
+	if err != nil {
+		return errPlaybackSigInvalid
+	}
+	mac, err := hex.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, p.mac(key, kid, mid, exp)) {
+		return errPlaybackSigInvalid
+	}
+	if now.Unix() > exp {
+		return errPlaybackSigExpired
+	}
+	return nil
+}
+
+// Middleware rejects unsigned requests for /stream/, /recordings/ and
+// /hlskeys/ with a 403. Pushes to /live/ are left to the auth webhook.
+func (p *PlaybackSigner) Middleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			mid := playbackManifestID(r.URL.Path)
+			if mid == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			q := r.URL.Query()
+			if err := p.Verify(mid, q, clock.Now()); err != nil {
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			}
+			if !strings.HasSuffix(r.URL.Path, ".m3u8") {
+				next.ServeHTTP(w, r)
+				return
+			}
+			pw := &playlistWriter{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(pw, r)
+			body := signPlaylist(pw.buf.Bytes(), p.playlistURISigner(mid, q))
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.WriteHeader(pw.status)
+			w.Write(body)
+		})
+	}
+}
+
+// playlistURISigner returns the query to append to a URI of a playlist of
+// `mid` requested with the verified query `q`, which is carried over as is.
+// Start over and DVR playlists point to segments under the /recordings/ of
+// the stream's recording ID, which `q` does not grant when it differs from
+// `mid`, so those are signed for the recording ID until the same expiry:
+// with the same key, or with the node's key if that one only signs the
+// manifest IDs of a tenant.
+func (p *PlaybackSigner) playlistURISigner(mid string, q url.Values) func(uri string) string {
+	kid := q.Get("kid")
+	sig := url.Values{"exp": q["exp"], "sig": q["sig"]}
+	if kid != "" {
+		sig.Set("kid", kid)
+	}
+	carried := sig.Encode()
+	exp, _ := strconv.ParseInt(q.Get("exp"), 10, 64)
+	return func(uri string) string {
+		recmid := recordingURIManifestID(uri)
+		if recmid == "" || recmid == mid {
+			return carried
+		}
+		for _, k := range []string{kid, ""} {
+			if recq, err := p.Sign(recmid, k, time.Unix(exp, 0)); err == nil {
+				return recq.Encode()
+			}
+		}
+		return carried
+	}
+}
+
+// recordingURIManifestID returns the manifest ID of a URI of a playlist
+// pointing to a path under /recordings/ of this node, or "" for other URIs
+func recordingURIManifestID(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "" || u.Host != "" || !strings.HasPrefix(u.Path, "/recordings/") {
+		return ""
+	}
+	return playbackManifestID(u.Path)
+}
+
+// playbackManifestID returns the manifest ID of a /stream/, /recordings/ or
+// /hlskeys/ path, or "" for other routes
+func playbackManifestID(path string) string {
+	var rest string
+	switch {
+	case strings.HasPrefix(path, "/stream/"):
+		rest = path[len("/stream/"):]
+	case strings.HasPrefix(path, "/recordings/"):
+		rest = path[len("/recordings/"):]
+	case strings.HasPrefix(path, hlsKeyPrefix):
+		rest = path[len(hlsKeyPrefix):]
+	default:
+		return ""
+	}
+	if i := strings.Index(rest, "/"); i >= 0 {
+		return rest[:i]
+	}
+	return strings.TrimSuffix(rest, ".m3u8")
+}
+
+// playlistWriter holds on to a playlist so its URIs can be signed
+type playlistWriter struct {
+	http.ResponseWriter
+	buf    bytes.Buffer
+	status int
+}
+
+func (pw *playlistWriter) WriteHeader(code int) {
+	pw.status = code
+}
+
+func (pw *playlistWriter) Write(b []byte) (int, error) {
+	return pw.buf.Write(b)
+}
+
+// signPlaylist appends the query returned by `sign` to each URI of an m3u8
+// playlist
+func signPlaylist(playlist []byte, sign func(uri string) string) []byte {
+	appendSig := func(uri string) string {
+		if strings.Contains(uri, "?") {
+			return uri + "&" + sign(uri)
+		}
+		return uri + "?" + sign(uri)
+	}
+	var out bytes.Buffer
+	out.Grow(len(playlist))
+	scanner := bufio.NewScanner(bytes.NewReader(playlist))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "#"):
+			// tags such as EXT-X-MAP and EXT-X-MEDIA carry URIs as attributes
+			if i := strings.Index(line, `URI="`); i >= 0 {
+				start := i + len(`URI="`)
+				if end := strings.Index(line[start:], `"`); end >= 0 {
+					line = line[:start] + appendSig(line[start:start+end]) + line[start+end:]
+				}
+			}
+		case strings.TrimSpace(line) != "":
+			line = appendSig(line)
+		}
+		out.WriteString(line)
+		out.WriteString("\n")
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePlaybackKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	p, err := ParsePlaybackKeys("nodekey, tenant1:abc,tenant2:d:e")
+	require.Nil(t, err)
+	assert.Equal([]byte("nodekey"), p.keys[""])
+	assert.Equal([]byte("abc"), p.keys["tenant1"])
+	assert.Equal([]byte("d:e"), p.keys["tenant2"])
+
+	_, err = ParsePlaybackKeys("")
+	assert.NotNil(err)
+	_, err = ParsePlaybackKeys("tenant1:")
+	assert.NotNil(err)
+	_, err = ParsePlaybackKeys("a:b,a:c")
+	assert.NotNil(err)
+	// key IDs can't span fields of manifest IDs
+	_, err = ParsePlaybackKeys("a-b:key")
+	assert.EqualError(err, `playback signing key ID "a-b" contains "-"`)
+}
+
+func TestPlaybackSigner_SetKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	p, err := ParsePlaybackKeys("oldkey")
+	require.Nil(t, err)
+	now := time.Now()
+	q, err := p.Sign("mani", "", now.Add(time.Minute))
+	require.Nil(t, err)
+
+	require.Nil(t, p.SetKeys("newkey,tenant:tenantkey"))
+	assert.Equal(errPlaybackSigInvalid, p.Verify("mani", q, now))
+	q, err = p.Sign("tenant-mani", "tenant", now.Add(time.Minute))
+	require.Nil(t, err)
+	assert.Nil(p.Verify("tenant-mani", q, now))
+
+	// invalid keys leave the current ones in place
+	assert.NotNil(p.SetKeys(""))
+	assert.Nil(p.Verify("tenant-mani", q, now))
+}
+
+func TestPlaybackSigner_Verify(t *testing.T) {
+	assert := assert.New(t)
+
+	p, err := ParsePlaybackKeys("nodekey,tenant:tenantkey")
+	require.Nil(t, err)
+	now := time.Now()
+
+	for _, kid := range []string{"", "tenant"} {
+		q, err := p.Sign("tenant-mani", kid, now.Add(time.Minute))
+		require.Nil(t, err)
+		assert.Nil(p.Verify("tenant-mani", q, now))
+		assert.Equal(errPlaybackSigInvalid, p.Verify("tenant-other", q, now))
+		assert.Equal(errPlaybackSigExpired, p.Verify("tenant-mani", q, now.Add(2*time.Minute)))
+
+		// extending the expiry invalidates the signature
+		q.Set("exp", "99999999999")
+		assert.Equal(errPlaybackSigInvalid, p.Verify("tenant-mani", q, now))
+	}
+
+	// a tenant's signature does not verify with another key
+	q, _ := p.Sign("tenant-mani", "tenant", now.Add(time.Minute))
+	q.Del("kid")
+	assert.Equal(errPlaybackSigInvalid, p.Verify("tenant-mani", q, now))
+	q.Set("kid", "nope")
+	assert.Equal(errPlaybackKeyUnknown, p.Verify("tenant-mani", q, now))
+
+	_, err = p.Sign("mani", "nope", now)
+	assert.Equal(errPlaybackKeyUnknown, err)
+	assert.Equal(errPlaybackSigMissing, p.Verify("mani", nil, now))
+}
+
+func TestPlaybackSigner_TenantIsolation(t *testing.T) {
+	assert := assert.New(t)
+
+	p, err := ParsePlaybackKeys("nodekey,tenant1:key1,tenant2:key2")
+	require.Nil(t, err)
+	now := time.Now()
+
+	// tenants only sign the manifest IDs of their streams
+	_, err = p.Sign("tenant2-mani", "tenant1", now.Add(time.Minute))
+	assert.Equal(errPlaybackKeyScope, err)
+	_, err = p.Sign("mani", "tenant1", now.Add(time.Minute))
+	assert.Equal(errPlaybackKeyScope, err)
+	_, err = p.Sign("tenant1mani", "tenant1", now.Add(time.Minute))
+	assert.Equal(errPlaybackKeyScope, err)
+
+	// a signature made with tenant1's key for tenant2's stream is refused
+	q := url.Values{}
+	exp := now.Add(time.Minute).Unix()
+	q.Set("exp", strconv.FormatInt(exp, 10))
+	q.Set("kid", "tenant1")
+	q.Set("sig", hex.EncodeToString(p.mac([]byte("key1"), "tenant1", "tenant2-mani", exp)))
+	assert.Equal(errPlaybackKeyScope, p.Verify("tenant2-mani", q, now))
+	q.Set("sig", hex.EncodeToString(p.mac([]byte("key1"), "tenant1", "mani", exp)))
+	assert.Equal(errPlaybackKeyScope, p.Verify("mani", q, now))
+
+	// a tenant's key doesn't sign the streams of a tenant whose key ID it
+	// prefixes, even if such a key was configured
+	p = &PlaybackSigner{keys: map[string][]byte{"": []byte("nodekey"), "a": []byte("keya"), "a-b": []byte("keyab")}}
+	_, err = p.Sign("a-b-x", "a", now.Add(time.Minute))
+	assert.Equal(errPlaybackKeyScope, err)
+	q.Set("kid", "a")
+	q.Set("sig", hex.EncodeToString(p.mac([]byte("keya"), "a", "a-b-x", exp)))
+	assert.Equal(errPlaybackKeyScope, p.Verify("a-b-x", q, now))
+	q, err = p.Sign("a-x", "a", now.Add(time.Minute))
+	require.Nil(t, err)
+	assert.Nil(p.Verify("a-x", q, now))
+
+	// the node's key still signs every stream
+	q, err = p.Sign("tenant2-mani", "", now.Add(time.Minute))
+	require.Nil(t, err)
+	assert.Nil(p.Verify("tenant2-mani", q, now))
+	q, err = p.Sign("tenant2-mani", "tenant2", now.Add(time.Minute))
+	require.Nil(t, err)
+	assert.Nil(p.Verify("tenant2-mani", q, now))
+}
+
+func TestPlaybackSigner_Middleware(t *testing.T) {
+	assert := assert.New(t)
+
+	p, err := ParsePlaybackKeys("tenant:tenantkey")
+	require.Nil(t, err)
+	playlist := "#EXTM3U\n#EXT-X-MAP:URI=\"init.mp4\"\n#EXTINF:2.000,\ntenant-mani/P240p30fps16x9/0.ts\n\nhttp://cdn/1.ts?a=b\n"
+	h := p.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-mpegURL")
+		w.Write([]byte(playlist))
+	}))
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	q, _ := p.Sign("tenant-mani", "tenant", time.Now().Add(time.Minute))
+	sig := q.Encode()
+
+	// pushes are not checked
+	assert.Equal(http.StatusOK, serve("/live/tenant-mani/0.ts").Code)
+
+	for _, path := range []string{"/stream/tenant-mani.m3u8", "/stream/tenant-mani/P240p30fps16x9.m3u8", "/recordings/tenant-mani/index.m3u8"} {
+		assert.Equal(http.StatusForbidden, serve(path).Code, path)
+		w := serve(path + "?" + sig)
+		assert.Equal(http.StatusOK, w.Code, path)
+		expected := "#EXTM3U\n#EXT-X-MAP:URI=\"init.mp4?" + sig + "\"\n#EXTINF:2.000,\ntenant-mani/P240p30fps16x9/0.ts?" + sig + "\n\nhttp://cdn/1.ts?a=b&" + sig + "\n"
+		assert.Equal(expected, w.Body.String(), path)
+		assert.Equal("application/x-mpegURL", w.Header().Get("Content-Type"))
+	}
+
+	// segments are passed through as is
+	w := serve("/stream/tenant-mani/P240p30fps16x9/0.ts?" + sig)
+	assert.Equal(playlist, w.Body.String())
+	assert.Equal(http.StatusForbidden, serve("/stream/tenant-other/P240p30fps16x9/0.ts?"+sig).Code)
+	assert.Equal(http.StatusForbidden, serve("/stream/other/P240p30fps16x9/0.ts?"+sig).Code)
+}
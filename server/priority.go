@@ -0,0 +1,68 @@
+package server
+
+import (
+	"math"
+
+	"github.com/livepeer/go-livepeer/core"
+)
+
+// priorityPolicy is how streams of a priority class use the orchestrators
+// and the resources of the node
+type priorityPolicy struct {
+	// Factor applied to the number of orchestrators a stream keeps sessions
+	// with, i.e. how many it can fail over to
+	orchFactor float64
+	// Fraction of those orchestrators below which more are requested; the
+	// higher, the sooner the stream looks for replacements
+	refreshAt float64
+	// Transcode attempts per segment on top of MaxAttempts
+	extraAttempts int
+	// Fraction of the memory budget that may be in use for a push of the
+	// stream to be accepted, so that lower classes are shed first
+	memShare float64
+}
+
+var priorityPolicies = map[core.StreamPriority]priorityPolicy{
+	core.PriorityLow:    {orchFactor: 0.5, refreshAt: 0.25, extraAttempts: -1, memShare: 0.75},
+	core.PriorityNormal: {orchFactor: 1, refreshAt: 0.5, extraAttempts: 0, memShare: 0.9},
+	core.PriorityHigh:   {orchFactor: 2, refreshAt: 0.75, extraAttempts: 2, memShare: 1},
+}
+
+// policyFor returns the policy of the priority class, or that of the normal
+// class for unknown ones
+func policyFor(p core.StreamPriority) priorityPolicy {
+	if pp, ok := priorityPolicies[p]; ok {
+		return pp
+	}
+	return priorityPolicies[core.PriorityNormal]
+}
+
+// numOrchs returns the number of orchestrators to request at once out of
+// `poolSize`, given the default of `n`
+func (pp priorityPolicy) numOrchs(poolSize, n float64) int {
+	num := math.Min(poolSize, n*pp.orchFactor)
+	if num < 1 && poolSize >= 1 {
+		num = 1
+	}
+	return int(num)
+}
+
+// refreshBelow returns the number of sessions below which a pool of
+// `numOrchs` orchestrators is refreshed
+func (pp priorityPolicy) refreshBelow(numOrchs int) int {
+	return int(math.Ceil(float64(numOrchs) * pp.refreshAt))
+}
+
+// attempts returns the number of times a segment is tried
+func (pp priorityPolicy) attempts() int {
+	if n := MaxAttempts + pp.extraAttempts; n > 1 {
+		return n
+	}
+	return 1
+}
+
+// memLimit returns the memory, out of a budget of `limit` bytes, that may be
+// in use for a push to be accepted
+func (pp priorityPolicy) memLimit(limit int64) int64 {
+	return int64(float64(limit) * pp.memShare)
+}
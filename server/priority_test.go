@@ -0,0 +1,80 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/drivers"
+	"github.com/livepeer/go-livepeer/net"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPriorityPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	low, normal, high := policyFor(core.PriorityLow), policyFor(core.PriorityNormal), policyFor(core.PriorityHigh)
+	assert.Equal(normal, policyFor(core.StreamPriority(7)))
+
+	// orchestrators
+	assert.Equal(4, low.numOrchs(10, 8))
+	assert.Equal(8, normal.numOrchs(10, 8))
+	assert.Equal(10, high.numOrchs(10, 8))
+	assert.Equal(1, low.numOrchs(1, 1))
+	assert.Equal(0, low.numOrchs(0, 8))
+	assert.Equal(2, low.refreshBelow(8))
+	assert.Equal(4, normal.refreshBelow(8))
+	assert.Equal(6, high.refreshBelow(8))
+
+	// attempts
+	oldAttempts := MaxAttempts
+	defer func() { MaxAttempts = oldAttempts }()
+	MaxAttempts = 3
+	assert.Equal(2, low.attempts())
+	assert.Equal(3, normal.attempts())
+	assert.Equal(5, high.attempts())
+	MaxAttempts = 1
+	assert.Equal(1, low.attempts())
+
+	// memory
+	assert.Equal(int64(75), low.memLimit(100))
+	assert.Equal(int64(90), normal.memLimit(100))
+	assert.Equal(int64(100), high.memLimit(100))
+	assert.Equal(int64(0), high.memLimit(0))
+}
+
+func TestPriority_SessionManager(t *testing.T) {
+	assert := assert.New(t)
+	n, _ := core.NewLivepeerNode(nil, "", nil)
+	sd := &stubDiscovery{}
+	for i := 0; i < 40; i++ {
+		sd.infos = append(sd.infos, &net.OrchestratorInfo{PriceInfo: &net.PriceInfo{}})
+	}
+	n.OrchestratorPool = sd
+	max := int(common.HTTPTimeout.Seconds()/SegLen.Seconds()) * 2
+
+	mid := core.RandomManifestID()
+	params := &core.StreamParameters{OS: drivers.NewMemoryDriver(nil).NewSession(string(mid))}
+	bsm := NewSessionManager(n, params, &LIFOSelector{})
+	assert.Equal(max, bsm.numOrchs)
+	assert.Equal((max+1)/2, bsm.refreshBelow)
+
+	params.Priority = core.PriorityHigh
+	bsm = NewSessionManager(n, params, &LIFOSelector{})
+	assert.Equal(2*max, bsm.numOrchs)
+	assert.True(bsm.refreshBelow > max/2)
+
+	params.Priority = core.PriorityLow
+	bsm = NewSessionManager(n, params, &LIFOSelector{})
+	assert.Equal(max/2, bsm.numOrchs)
+}
+
+func TestPriority_Connection(t *testing.T) {
+	assert := assert.New(t)
+	var cxn *rtmpConnection
+	assert.Equal(core.PriorityNormal, cxn.priority())
+	cxn = &rtmpConnection{}
+	assert.Equal(core.PriorityNormal, cxn.priority())
+	cxn.params = &core.StreamParameters{Priority: core.PriorityHigh}
+	assert.Equal(core.PriorityHigh, cxn.priority())
+}
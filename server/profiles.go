@@ -0,0 +1,138 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/livepeer/lpms/ffmpeg"
+)
+
+// ProfileLimits are the bounds of the renditions streams may be transcoded
+// into. Profiles outside of them are rejected when the stream is created
+// rather than left to fail, or to misbehave, once segments are transcoded.
+// Zero bounds are not checked, so the zero value only requires profiles to
+// be well formed.
+type ProfileLimits struct {
+	// Bounds of the width and height, in pixels
+	MinDimension int
+	MaxDimension int
+	// Whether the width and height must be even, as encoders of 4:2:0 video
+	// need them to be
+	EvenDimensions bool
+	// Bounds of the frame rate, in frames per second, of renditions that do
+	// not pass the frame rate of the source through
+	MinFPS float64
+	MaxFPS float64
+	// Bounds of the bitrate, in bits per second
+	MinBitrate int64
+	MaxBitrate int64
+	// Whether GOPs must be no longer than the segments streams are cut into
+	GOPWithinSegments bool
+}
+
+// DefaultProfileLimits are the limits applied unless set otherwise by flags
+var DefaultProfileLimits = ProfileLimits{
+	MinDimension:      16,
+	MaxDimension:      8192,
+	EvenDimensions:    true,
+	MinFPS:            1,
+	MaxFPS:            240,
+	MinBitrate:        10000,
+	MaxBitrate:        200000000,
+	GOPWithinSegments: true,
+}
+
+// TranscodeProfileLimits are the limits of the profiles from the auth webhook
+// and from -transcodingOptions
+var TranscodeProfileLimits = DefaultProfileLimits
+
+// validateProfiles checks that `profiles` have unique names and are within
+// TranscodeProfileLimits. The error names the first profile found invalid.
+func validateProfiles(profiles []ffmpeg.VideoProfile) error {
+	limits := TranscodeProfileLimits
+	names := make(map[string]bool, len(profiles))
+	for _, p := range profiles {
+		if p.Name == "" {
+			return fmt.Errorf("missing profile name resolution=%s", p.Resolution)
+		}
+		if names[p.Name] {
+			return fmt.Errorf("duplicate profile name=%s", p.Name)
+		}
+		names[p.Name] = true
+
+		if isAudioProfile(p) {
+			return fmt.Errorf("audio-only profile not supported profile=%s", p.Name)
+		}
+		var w, h int
+		if n, err := fmt.Sscanf(p.Resolution, "%dx%d", &w, &h); err != nil || n != 2 {
+			return fmt.Errorf("invalid resolution profile=%s resolution=%q", p.Name, p.Resolution)
+		}
+		if (limits.MinDimension > 0 && (w < limits.MinDimension || h < limits.MinDimension)) ||
+			(limits.MaxDimension > 0 && (w > limits.MaxDimension || h > limits.MaxDimension)) {
+			return fmt.Errorf("resolution out of bounds profile=%s resolution=%s min=%d max=%d", p.Name, p.Resolution, limits.MinDimension, limits.MaxDimension)
+		}
+		if limits.EvenDimensions && (w%2 != 0 || h%2 != 0) {
+			return fmt.Errorf("odd resolution profile=%s resolution=%s", p.Name, p.Resolution)
+		}
+
+		if p.Framerate == 0 {
+			if p.FramerateDen != 0 {
+				return fmt.Errorf("fps denominator without fps profile=%s fpsDen=%d", p.Name, p.FramerateDen)
+			}
+		} else {
+			fps := float64(p.Framerate)
+			if p.FramerateDen != 0 {
+				fps /= float64(p.FramerateDen)
+			}
+			if (limits.MinFPS > 0 && fps < limits.MinFPS) || (limits.MaxFPS > 0 && fps > limits.MaxFPS) {
+				return fmt.Errorf("fps out of bounds profile=%s fps=%.2f min=%v max=%v", p.Name, fps, limits.MinFPS, limits.MaxFPS)
+			}
+		}
+
+		bitrate, err := parseBitrate(p.Bitrate)
+		if err != nil {
+			return fmt.Errorf("invalid bitrate profile=%s bitrate=%q", p.Name, p.Bitrate)
+		}
+		if (limits.MinBitrate > 0 && bitrate < limits.MinBitrate) || (limits.MaxBitrate > 0 && bitrate > limits.MaxBitrate) {
+			return fmt.Errorf("bitrate out of bounds profile=%s bitrate=%d min=%d max=%d", p.Name, bitrate, limits.MinBitrate, limits.MaxBitrate)
+		}
+
+		if p.GOP < 0 && p.GOP != ffmpeg.GOPIntraOnly {
+			return fmt.Errorf("invalid gop profile=%s gop=%v", p.Name, p.GOP)
+		}
+		if segLen := maxSegmentLength(); limits.GOPWithinSegments && p.GOP > segLen {
+			return fmt.Errorf("gop longer than segments profile=%s gop=%v segmentLength=%v", p.Name, p.GOP, segLen)
+		}
+	}
+	return nil
+}
+
+// parseBitrate returns the bits per second of a profile bitrate, given either
+// as a number or with a k or M suffix as in the built-in presets
+func parseBitrate(s string) (int64, error) {
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(s, "k"), strings.HasSuffix(s, "K"):
+		mult, s = 1000, s[:len(s)-1]
+	case strings.HasSuffix(s, "M"):
+		mult, s = 1000000, s[:len(s)-1]
+	}
+	b, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return b * mult, nil
+}
+
+// maxSegmentLength returns the longest segments of RTMP streams are cut into
+func maxSegmentLength() time.Duration {
+	if KeyframeSegmentation == nil {
+		return SegLen
+	}
+	if KeyframeSegmentation.Max > KeyframeSegmentation.Min {
+		return KeyframeSegmentation.Max
+	}
+	return KeyframeSegmentation.Min
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/livepeer/lpms/ffmpeg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateProfiles(t *testing.T) {
+	assert := assert.New(t)
+
+	valid := ffmpeg.VideoProfile{Name: "prof", Bitrate: "400000", Resolution: "426x240"}
+	assert.Nil(validateProfiles(nil))
+	assert.Nil(validateProfiles([]ffmpeg.VideoProfile{valid}))
+	assert.Nil(validateProfiles([]ffmpeg.VideoProfile{{Name: "preset", Bitrate: "6000k", Resolution: "1920x1080", Framerate: 60}}))
+	assert.Nil(validateProfiles([]ffmpeg.VideoProfile{{Name: "ntsc", Bitrate: "2M", Resolution: "1280x720", Framerate: 30000, FramerateDen: 1001}}))
+	assert.Nil(validateProfiles([]ffmpeg.VideoProfile{{Name: "intra", Bitrate: "400k", Resolution: "426x240", GOP: ffmpeg.GOPIntraOnly}}))
+	assert.Nil(validateProfiles([]ffmpeg.VideoProfile{{Name: "gop", Bitrate: "400k", Resolution: "426x240", GOP: SegLen}}))
+
+	tests := []struct {
+		profile ffmpeg.VideoProfile
+		err     string
+	}{
+		{ffmpeg.VideoProfile{Bitrate: "400k", Resolution: "426x240"}, "missing profile name resolution=426x240"},
+		{ffmpeg.VideoProfile{Name: "p", Bitrate: "400k", Resolution: "wide"}, `invalid resolution profile=p resolution="wide"`},
+		{ffmpeg.VideoProfile{Name: "p", Bitrate: "400k", Resolution: "0x0"}, "resolution out of bounds profile=p resolution=0x0 min=16 max=8192"},
+		{ffmpeg.VideoProfile{Name: "p", Bitrate: "400k", Resolution: "10000x240"}, "resolution out of bounds profile=p resolution=10000x240 min=16 max=8192"},
+		{ffmpeg.VideoProfile{Name: "p", Bitrate: "400k", Resolution: "427x240"}, "odd resolution profile=p resolution=427x240"},
+		{ffmpeg.VideoProfile{Name: "p", Bitrate: "400k", Resolution: "426x240", FramerateDen: 2}, "fps denominator without fps profile=p fpsDen=2"},
+		{ffmpeg.VideoProfile{Name: "p", Bitrate: "400k", Resolution: "426x240", Framerate: 560}, "fps out of bounds profile=p fps=560.00 min=1 max=240"},
+		{ffmpeg.VideoProfile{Name: "p", Bitrate: "400k", Resolution: "426x240", Framerate: 1, FramerateDen: 12}, "fps out of bounds profile=p fps=0.08 min=1 max=240"},
+		{ffmpeg.VideoProfile{Name: "p", Bitrate: "fast", Resolution: "426x240"}, `invalid bitrate profile=p bitrate="fast"`},
+		{ffmpeg.VideoProfile{Name: "p", Bitrate: "432", Resolution: "426x240"}, "bitrate out of bounds profile=p bitrate=432 min=10000 max=200000000"},
+		{ffmpeg.VideoProfile{Name: "p", Bitrate: "400k", Resolution: "426x240", GOP: -time.Second}, "invalid gop profile=p gop=-1s"},
+		{ffmpeg.VideoProfile{Name: "p", Bitrate: "400k", Resolution: "426x240", GOP: 123 * time.Second}, "gop longer than segments profile=p gop=2m3s segmentLength=2s"},
+	}
+	for _, tt := range tests {
+		assert.EqualError(validateProfiles([]ffmpeg.VideoProfile{valid, tt.profile}), tt.err)
+	}
+
+	assert.EqualError(validateProfiles([]ffmpeg.VideoProfile{valid, valid}), "duplicate profile name=prof")
+
+	// GOPs may be as long as the longest keyframe aligned segments
+	defer func(b *SegmentBounds) { KeyframeSegmentation = b }(KeyframeSegmentation)
+	KeyframeSegmentation = &SegmentBounds{Min: time.Second, Max: 4 * time.Second}
+	gop := ffmpeg.VideoProfile{Name: "gop", Bitrate: "400k", Resolution: "426x240", GOP: 4 * time.Second}
+	assert.Nil(validateProfiles([]ffmpeg.VideoProfile{gop}))
+	gop.GOP = 5 * time.Second
+	assert.EqualError(validateProfiles([]ffmpeg.VideoProfile{gop}), "gop longer than segments profile=gop gop=5s segmentLength=4s")
+}
+
+func TestParseBitrate(t *testing.T) {
+	assert := assert.New(t)
+
+	for s, expected := range map[string]int64{"400000": 400000, "600k": 600000, "600K": 600000, "6M": 6000000} {
+		b, err := parseBitrate(s)
+		assert.Nil(err)
+		assert.Equal(expected, b)
+	}
+	for _, s := range []string{"", "k", "1.5M", "600kbps"} {
+		_, err := parseBitrate(s)
+		assert.NotNil(err)
+	}
+}
+
+func TestValidateProfiles_Limits(t *testing.T) {
+	assert := assert.New(t)
+	defer func(l ProfileLimits) { TranscodeProfileLimits = l }(TranscodeProfileLimits)
+
+	profiles := []ffmpeg.VideoProfile{
+		{Name: "prof1", Bitrate: "432", Framerate: 560, Resolution: "123x456"},
+		{Name: "prof2", Bitrate: "890", Resolution: "789x654", GOP: 123 * time.Second},
+	}
+
+	// zero limits only check that profiles are well formed
+	TranscodeProfileLimits = ProfileLimits{}
+	assert.Nil(validateProfiles(profiles))
+	assert.EqualError(validateProfiles([]ffmpeg.VideoProfile{{Name: "p", Bitrate: "fast", Resolution: "1x1"}}), `invalid bitrate profile=p bitrate="fast"`)
+
+	// each limit is checked on its own
+	TranscodeProfileLimits = ProfileLimits{MaxFPS: 240}
+	assert.EqualError(validateProfiles(profiles), "fps out of bounds profile=prof1 fps=560.00 min=0 max=240")
+	TranscodeProfileLimits = ProfileLimits{MinBitrate: 500}
+	assert.EqualError(validateProfiles(profiles), "bitrate out of bounds profile=prof1 bitrate=432 min=500 max=0")
+	TranscodeProfileLimits = ProfileLimits{EvenDimensions: true}
+	assert.EqualError(validateProfiles(profiles), "odd resolution profile=prof1 resolution=123x456")
+	TranscodeProfileLimits = ProfileLimits{MaxDimension: 700}
+	assert.EqualError(validateProfiles(profiles), "resolution out of bounds profile=prof2 resolution=789x654 min=0 max=700")
+	TranscodeProfileLimits = ProfileLimits{GOPWithinSegments: true}
+	assert.EqualError(validateProfiles(profiles), "gop longer than segments profile=prof2 gop=2m3s segmentLength=2s")
+
+	TranscodeProfileLimits = DefaultProfileLimits
+	assert.EqualError(validateProfiles(profiles), "odd resolution profile=prof1 resolution=123x456")
+}
@@ -14,6 +14,7 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -29,6 +30,7 @@ import (
 	lpmon "github.com/livepeer/go-livepeer/monitor"
 	"github.com/livepeer/go-livepeer/net"
 	"github.com/livepeer/lpms/ffmpeg"
+	"github.com/livepeer/lpms/stream"
 	"github.com/livepeer/lpms/vidplayer"
 )
 
@@ -99,7 +101,7 @@ func TestPush_MultipartReturn(t *testing.T) {
 		params:      &core.StreamParameters{Profiles: []ffmpeg.VideoProfile{ffmpeg.P144p25fps16x9}},
 	}
 
-	s.rtmpConnections["mani"] = cxn
+	s.rtmpConnections.store("mani", cxn)
 
 	req.Header.Set("Accept", "multipart/mixed")
 	s.HandlePush(w, req)
@@ -231,6 +233,41 @@ func TestPush_MemoryRequestError(t *testing.T) {
 	assert.Contains(strings.TrimSpace(string(body)), "Error reading http request body")
 }
 
+func TestPush_BodyTooLarge(t *testing.T) {
+	assert := assert.New(t)
+	s := setupServer()
+	defer serverCleanup(s)
+
+	oldMax := MaxPushBodySize
+	defer func() { MaxPushBodySize = oldMax }()
+	MaxPushBodySize = 4
+
+	push := func(length int64) (int, string) {
+		handler, _, w := requestSetup(s)
+		req := httptest.NewRequest("POST", "/live/mani/1.ts", strings.NewReader("InsteadOf.TS"))
+		req.ContentLength = length
+		handler.ServeHTTP(w, req)
+		resp := w.Result()
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		require.Nil(t, err)
+		return resp.StatusCode, string(body)
+	}
+
+	// rejected up front when the length is known
+	code, body := push(12)
+	assert.Equal(http.StatusRequestEntityTooLarge, code)
+	assert.Contains(body, "Segment too large")
+	assert.Contains(body, "bytes=12 max=4")
+
+	// otherwise once the limit is read past
+	code, body = push(-1)
+	assert.Equal(http.StatusRequestEntityTooLarge, code)
+	assert.Contains(body, "Segment too large")
+	_, exists := s.rtmpConnections.get("mani")
+	assert.False(exists)
+}
+
 func TestPush_EmptyURLError(t *testing.T) {
 	// assert http request body error returned
 	assert := assert.New(t)
@@ -257,13 +294,14 @@ func TestPush_ShouldUpdateLastUsed(t *testing.T) {
 	s.HandlePush(w, req)
 	resp := w.Result()
 	resp.Body.Close()
-	lu := s.rtmpConnections["mani1"].lastUsed
+	cxn, _ := s.rtmpConnections.get("mani1")
+	lu := cxn.lastUsedTime()
 	w = httptest.NewRecorder()
 	req = httptest.NewRequest("POST", "/live/mani1/1.ts", nil)
 	s.HandlePush(w, req)
 	resp = w.Result()
 	resp.Body.Close()
-	assert.True(lu.Before(s.rtmpConnections["mani1"].lastUsed))
+	assert.True(lu.Before(cxn.lastUsedTime()))
 }
 
 func TestPush_HTTPIngest(t *testing.T) {
@@ -307,8 +345,8 @@ func TestPush_MP4(t *testing.T) {
 	assert := assert.New(t)
 	s := setupServer()
 	defer serverCleanup(s)
-	s.rtmpConnections = map[core.ManifestID]*rtmpConnection{}
-	defer func() { s.rtmpConnections = map[core.ManifestID]*rtmpConnection{} }()
+	s.rtmpConnections = newConnectionMap()
+	defer func() { s.rtmpConnections = newConnectionMap() }()
 	segHandler := getHLSSegmentHandler(s)
 	ts, mux := stubTLSServer()
 	defer ts.Close()
@@ -422,12 +460,13 @@ func TestPush_MP4(t *testing.T) {
 	assert.Equal(1, i)
 
 	// Check formats
-	for _, cxn := range s.rtmpConnections {
+	s.rtmpConnections.forEach(func(_ core.ManifestID, cxn *rtmpConnection) bool {
 		assert.Equal(ffmpeg.FormatMP4, cxn.profile.Format)
 		for _, p := range cxn.params.Profiles {
 			assert.Equal(ffmpeg.FormatMP4, p.Format)
 		}
-	}
+		return true
+	})
 }
 
 func TestPush_SetVideoProfileFormats(t *testing.T) {
@@ -437,8 +476,8 @@ func TestPush_SetVideoProfileFormats(t *testing.T) {
 	// sometimes LivepeerServer needs time  to start
 	// esp if this is the only test in the suite being run (eg, via `-run)
 	time.Sleep(10 * time.Millisecond)
-	s.rtmpConnections = map[core.ManifestID]*rtmpConnection{}
-	defer func() { s.rtmpConnections = map[core.ManifestID]*rtmpConnection{} }()
+	s.rtmpConnections = newConnectionMap()
+	defer func() { s.rtmpConnections = newConnectionMap() }()
 
 	oldProfs := BroadcastJobVideoProfiles
 	defer func() { BroadcastJobVideoProfiles = oldProfs }()
@@ -451,8 +490,8 @@ func TestPush_SetVideoProfileFormats(t *testing.T) {
 	resp := w.Result()
 	defer resp.Body.Close()
 
-	assert.Len(s.rtmpConnections, 1)
-	for _, cxn := range s.rtmpConnections {
+	assert.Equal(1, s.rtmpConnections.len())
+	s.rtmpConnections.forEach(func(_ core.ManifestID, cxn *rtmpConnection) bool {
 		assert.Equal(ffmpeg.FormatMPEGTS, cxn.profile.Format)
 		assert.Len(cxn.params.Profiles, 2)
 		assert.Len(BroadcastJobVideoProfiles, 2)
@@ -463,7 +502,8 @@ func TestPush_SetVideoProfileFormats(t *testing.T) {
 			// streams. Make sure this doesn't happen!
 			assert.Equal(ffmpeg.FormatNone, BroadcastJobVideoProfiles[i].Format)
 		}
-	}
+		return true
+	})
 
 	// Sending a MP4 under the same stream name doesn't change assigned profiles
 	h, r, w = requestSetup(s)
@@ -472,8 +512,8 @@ func TestPush_SetVideoProfileFormats(t *testing.T) {
 	resp = w.Result()
 	defer resp.Body.Close()
 
-	assert.Len(s.rtmpConnections, 1)
-	for _, cxn := range s.rtmpConnections {
+	assert.Equal(1, s.rtmpConnections.len())
+	s.rtmpConnections.forEach(func(_ core.ManifestID, cxn *rtmpConnection) bool {
 		assert.Equal(ffmpeg.FormatMPEGTS, cxn.profile.Format)
 		assert.Len(cxn.params.Profiles, 2)
 		assert.Len(BroadcastJobVideoProfiles, 2)
@@ -481,7 +521,8 @@ func TestPush_SetVideoProfileFormats(t *testing.T) {
 			assert.Equal(ffmpeg.FormatMPEGTS, p.Format)
 			assert.Equal(ffmpeg.FormatNone, BroadcastJobVideoProfiles[i].Format)
 		}
-	}
+		return true
+	})
 
 	// Sending a MP4 under a new stream name sets the profile correctly
 	h, r, w = requestSetup(s)
@@ -490,8 +531,8 @@ func TestPush_SetVideoProfileFormats(t *testing.T) {
 	resp = w.Result()
 	defer resp.Body.Close()
 
-	assert.Len(s.rtmpConnections, 2)
-	cxn, ok := s.rtmpConnections["new"]
+	assert.Equal(2, s.rtmpConnections.len())
+	cxn, ok := s.rtmpConnections.get("new")
 	assert.True(ok, "stream did not exist")
 	assert.Equal(ffmpeg.FormatMP4, cxn.profile.Format)
 	assert.Len(cxn.params.Profiles, 2)
@@ -524,10 +565,10 @@ func TestPush_SetVideoProfileFormats(t *testing.T) {
 	defer resp.Body.Close()
 	assert.Equal(1, hookCalled)
 
-	assert.Len(s.rtmpConnections, 3)
-	cxn, ok = s.rtmpConnections["web"]
+	assert.Equal(3, s.rtmpConnections.len())
+	cxn, ok = s.rtmpConnections.get("web")
 	assert.False(ok, "stream should not exist")
-	cxn, ok = s.rtmpConnections["intweb"]
+	cxn, ok = s.rtmpConnections.get("intweb")
 	assert.True(ok, "stream did not exist")
 	assert.Equal(ffmpeg.FormatMP4, cxn.profile.Format)
 	assert.Len(cxn.params.Profiles, 2)
@@ -547,10 +588,10 @@ func TestPush_SetVideoProfileFormats(t *testing.T) {
 	// webhook should not be called again
 	assert.Equal(1, hookCalled)
 
-	assert.Len(s.rtmpConnections, 3)
-	cxn, ok = s.rtmpConnections["web"]
+	assert.Equal(3, s.rtmpConnections.len())
+	cxn, ok = s.rtmpConnections.get("web")
 	assert.False(ok, "stream should not exist")
-	cxn, ok = s.rtmpConnections["intweb"]
+	cxn, ok = s.rtmpConnections.get("intweb")
 	assert.True(ok, "stream did not exist")
 	assert.Equal(503, resp.StatusCode)
 }
@@ -558,9 +599,7 @@ func TestPush_SetVideoProfileFormats(t *testing.T) {
 func TestPush_ShouldRemoveSessionAfterTimeoutIfInternalMIDIsUsed(t *testing.T) {
 	defer goleak.VerifyNone(t, common.IgnoreRoutines()...)
 
-	oldRI := httpPushTimeout
-	httpPushTimeout = 100 * time.Millisecond
-	defer func() { httpPushTimeout = oldRI }()
+	c := useFakeClock(t)
 	assert := assert.New(t)
 	s, cancel := setupServerWithCancel()
 
@@ -584,29 +623,31 @@ func TestPush_ShouldRemoveSessionAfterTimeoutIfInternalMIDIsUsed(t *testing.T) {
 	resp.Body.Close()
 	assert.Equal(1, hookCalled)
 	s.connectionLock.Lock()
-	_, exists := s.rtmpConnections["intmid"]
-	_, existsExt := s.rtmpConnections["extmid1"]
-	intmid := s.internalManifests["extmid1"]
+	_, exists := s.rtmpConnections.get("intmid")
+	_, existsExt := s.rtmpConnections.get("extmid1")
+	intmid, _ := s.streamAliases.lookup("extmid1")
 	s.connectionLock.Unlock()
 	assert.Equal("intmid", string(intmid))
 	assert.True(exists)
 	assert.False(existsExt)
-	time.Sleep(150 * time.Millisecond)
-	s.connectionLock.Lock()
-	_, exists = s.rtmpConnections["intmid"]
-	_, extEx := s.internalManifests["extmid1"]
-	s.connectionLock.Unlock()
+	assert.True(c.waitTimers(1))
+	c.Advance(httpPushTimeout + time.Millisecond)
+	removed := func() bool {
+		s.connectionLock.Lock()
+		defer s.connectionLock.Unlock()
+		_, exists := s.rtmpConnections.get("intmid")
+		_, extEx := s.streamAliases.lookup("extmid1")
+		return !exists && !extEx
+	}
+	common.WaitUntil(time.Second, removed)
 	cancel()
-	assert.False(exists)
-	assert.False(extEx)
+	assert.True(removed(), "session was not removed")
 }
 
 func TestPush_ShouldRemoveSessionAfterTimeout(t *testing.T) {
 	defer goleak.VerifyNone(t, common.IgnoreRoutines()...)
 
-	oldRI := httpPushTimeout
-	httpPushTimeout = 100 * time.Millisecond
-	defer func() { httpPushTimeout = oldRI }()
+	c := useFakeClock(t)
 	assert := assert.New(t)
 	s, cancel := setupServerWithCancel()
 	w := httptest.NewRecorder()
@@ -614,22 +655,30 @@ func TestPush_ShouldRemoveSessionAfterTimeout(t *testing.T) {
 	s.HandlePush(w, req)
 	resp := w.Result()
 	resp.Body.Close()
-	s.connectionLock.Lock()
-	_, exists := s.rtmpConnections["mani3"]
-	s.connectionLock.Unlock()
-	assert.True(exists)
-	time.Sleep(150 * time.Millisecond)
-	s.connectionLock.Lock()
-	_, exists = s.rtmpConnections["mani3"]
-	s.connectionLock.Unlock()
+	exists := func() bool {
+		s.connectionLock.Lock()
+		defer s.connectionLock.Unlock()
+		_, exists := s.rtmpConnections.get("mani3")
+		return exists
+	}
+	assert.True(exists())
+
+	// the session outlives the timeout for as long as it is used
+	assert.True(c.waitTimers(1))
+	c.Advance(httpPushTimeout / 2)
+	s.HandlePush(httptest.NewRecorder(), httptest.NewRequest("POST", "/live/mani3/2.ts", nil))
+	c.Advance(httpPushTimeout/2 + time.Millisecond)
+	assert.True(c.waitTimers(1))
+	assert.True(exists())
+
+	c.Advance(httpPushTimeout)
+	common.WaitUntil(time.Second, func() bool { return !exists() })
 	cancel()
-	assert.False(exists)
+	assert.False(exists())
 }
 
 func TestPush_ShouldNotPanicIfSessionAlreadyRemoved(t *testing.T) {
-	oldRI := httpPushTimeout
-	httpPushTimeout = 100 * time.Millisecond
-	defer func() { httpPushTimeout = oldRI }()
+	c := useFakeClock(t)
 	assert := assert.New(t)
 	s := setupServer()
 	defer serverCleanup(s)
@@ -639,24 +688,26 @@ func TestPush_ShouldNotPanicIfSessionAlreadyRemoved(t *testing.T) {
 	resp := w.Result()
 	resp.Body.Close()
 	s.connectionLock.Lock()
-	_, exists := s.rtmpConnections["mani2"]
+	_, exists := s.rtmpConnections.get("mani2")
 	s.connectionLock.Unlock()
 	assert.True(exists)
 	s.connectionLock.Lock()
-	delete(s.rtmpConnections, "mani2")
+	s.rtmpConnections.delete("mani2")
 	s.connectionLock.Unlock()
-	time.Sleep(200 * time.Millisecond)
+	assert.True(c.waitTimers(1))
+	c.Advance(httpPushTimeout + time.Millisecond)
+	// the watchdog is done once no timers are left
+	common.WaitUntil(time.Second, func() bool { return pushWatchdog.len() == 0 })
+	assert.Equal(0, pushWatchdog.len())
 	s.connectionLock.Lock()
-	_, exists = s.rtmpConnections["mani2"]
+	_, exists = s.rtmpConnections.get("mani2")
 	s.connectionLock.Unlock()
 	assert.False(exists)
 }
 
 func TestPush_ResetWatchdog(t *testing.T) {
 	assert := assert.New(t)
-
-	// wait for any earlier tests to complete
-	assert.True(wgWait(&pushResetWg), "timed out waiting for earlier tests")
+	c := useFakeClock(t)
 
 	s := setupServer()
 	defer serverCleanup(s)
@@ -670,37 +721,13 @@ func TestPush_ResetWatchdog(t *testing.T) {
 		}
 	}
 
-	// override reset func with our own instrumentation
-	cancelCount := 0
-	resetCount := 0
-	var wrappedCancel func()
-	var wg sync.WaitGroup // to synchronize on cancels of the watchdog
-	timerCreationBarrier := make(chan struct{})
-	oldResetTimer := httpPushResetTimer
-	httpPushResetTimer = func() (context.Context, context.CancelFunc) {
-		wg.Add(1)
-		ctx, cancel := context.WithCancel(context.Background())
-		resetCount++
-		wrappedCancel = func() {
-			cancelCount++
-			cancel()
-			wg.Done()
-		}
-		timerCreationBarrier <- struct{}{}
-		return ctx, wrappedCancel
-	}
-	defer func() { httpPushResetTimer = oldResetTimer }()
-
-	// sanity check : normal flow should result in a single cancel
+	// sanity check : normal flow leaves only the idle timer scheduled
 	w := httptest.NewRecorder()
 	req := httptest.NewRequest("POST", "/live/name/0.ts", nil)
 	s.HandlePush(w, req)
-	assert.True(waitBarrier(timerCreationBarrier), "timer creation timed out")
-	assert.True(wgWait(&wg), "watchdog did not exit")
-	assert.Equal(1, cancelCount)
-	assert.Equal(1, resetCount)
+	assert.Equal(1, pushWatchdog.len())
 
-	// set up for "long transcode" : one timeout before returning normally
+	// set up for "long transcode" spanning several reset intervals
 	ts, mux := stubTLSServer()
 	defer ts.Close()
 	serverBarrier := make(chan struct{})
@@ -709,71 +736,44 @@ func TestPush_ResetWatchdog(t *testing.T) {
 	})
 	sess := StubBroadcastSession(ts.URL)
 	bsm := bsmWithSessList([]*BroadcastSession{sess})
-	s.connectionLock.Lock()
-	cxn, exists := s.rtmpConnections["name"]
+	cxn, exists := s.rtmpConnections.get("name")
 	assert.True(exists)
 	cxn.sessManager = bsm
-	s.connectionLock.Unlock()
 
-	cancelCount = 0
-	resetCount = 0
+	start := c.Now()
 	pushFuncBarrier := make(chan struct{})
 	go func() { s.HandlePush(w, req); pushFuncBarrier <- struct{}{} }()
+	common.WaitAssert(t, time.Second, func() bool { return pushWatchdog.len() == 2 }, "reset timer not scheduled")
 
-	assert.True(waitBarrier(timerCreationBarrier), "timer creation timed out")
-	assert.Equal(0, cancelCount)
-	assert.Equal(1, resetCount)
-	cxn.lastUsed = time.Time{} // reset. prob should be locked
-
-	// induce a timeout via cancellation
-	wrappedCancel()
-	assert.True(waitBarrier(timerCreationBarrier), "timer creation timed out")
-	assert.Equal(1, cancelCount)
-	assert.Equal(2, resetCount)
-	assert.NotEqual(time.Time{}, cxn.lastUsed, "lastUsed was not reset")
-
-	// check things with a normal return
-	cxn.lastUsed = time.Time{}  // reset again
-	serverBarrier <- struct{}{} // induce server to return
-	assert.True(waitBarrier(pushFuncBarrier), "push func timed out")
-	assert.True(wgWait(&wg), "watchdog did not exit")
-	assert.Equal(2, cancelCount)
-	assert.Equal(2, resetCount)
-	assert.Equal(time.Time{}, cxn.lastUsed, "lastUsed was reset")
-
-	// check lastUsed is not reset if session disappears
-	cancelCount = 0
-	resetCount = 0
-	go func() { s.HandlePush(w, req); pushFuncBarrier <- struct{}{} }()
-	assert.True(waitBarrier(timerCreationBarrier), "timer creation timed out")
-	assert.Equal(0, cancelCount)
-	assert.Equal(1, resetCount)
-	s.connectionLock.Lock()
-	cxn, exists = s.rtmpConnections["name"]
-	assert.True(exists)
-	delete(s.rtmpConnections, "name") // disappear the session
-	assert.NotEqual(time.Time{}, cxn.lastUsed, "lastUsed was not reset")
-	cxn.lastUsed = time.Time{} // use time zero value as a sentinel
-	s.connectionLock.Unlock()
-
-	wrappedCancel() // induce tick
-	assert.True(waitBarrier(timerCreationBarrier), "timer creation timed out")
-	assert.Equal(1, cancelCount)
-	assert.Equal(2, resetCount)
-	assert.Equal(time.Time{}, cxn.lastUsed)
+	// the in-flight request keeps the session alive
+	for i := 0; i < 3; i++ {
+		assert.True(c.waitTimerBy(c.Now().Add(httpPushResetInterval())), "reset timer not scheduled")
+		c.Advance(httpPushResetInterval())
+		common.WaitAssert(t, time.Second, func() bool { return !cxn.lastUsedTime().Before(c.Now()) }, "lastUsed was not reset")
+	}
+	assert.True(c.Since(start) > 2*httpPushTimeout)
+	_, exists = s.rtmpConnections.get("name")
+	assert.True(exists, "session timed out during transcode")
+	assert.Equal(2, pushWatchdog.len())
 
-	// clean up and some more sanity checks
+	// the reset timer is stopped once the request returns
 	serverBarrier <- struct{}{}
 	assert.True(waitBarrier(pushFuncBarrier), "push func timed out")
-	assert.True(wgWait(&wg), "watchdog did not exit")
-	assert.Equal(2, cancelCount)
-	assert.Equal(2, resetCount)
-	assert.Equal(time.Time{}, cxn.lastUsed, "lastUsed was reset")
-
-	// cancelling again should not lead to a timer reset since push is complete
-	assert.Panics(wrappedCancel)
-	assert.Equal(3, cancelCount)
-	assert.Equal(2, resetCount)
+	assert.Equal(1, pushWatchdog.len())
+
+	// without pushes the session is removed and no timers are left behind
+	removed := func() bool {
+		_, exists := s.rtmpConnections.get("name")
+		return !exists && pushWatchdog.len() == 0
+	}
+	for i := 0; i < 3 && !removed(); i++ {
+		if !c.waitTimerBy(c.Now().Add(httpPushTimeout)) {
+			break
+		}
+		c.Advance(httpPushTimeout)
+		common.WaitUntil(100*time.Millisecond, removed)
+	}
+	assert.True(removed(), "session was not removed")
 }
 
 func TestPush_FileExtensionError(t *testing.T) {
@@ -821,6 +821,44 @@ func TestPush_StorageError(t *testing.T) {
 	drivers.NodeStorage = tempStorage
 }
 
+func TestPush_MemoryBudgetExceeded(t *testing.T) {
+	assert := assert.New(t)
+	s := setupServer()
+	defer serverCleanup(s)
+
+	oldBudget := MemBudget
+	defer func() { MemBudget = oldBudget }()
+	MemBudget = common.NewMemoryBudget(10)
+	MemBudget.Charge("other", 5)
+
+	// new segments are rejected before a stream is created
+	handler, _, w := requestSetup(s)
+	req := httptest.NewRequest("POST", "/live/mani/1.ts", strings.NewReader("InsteadOf.TS"))
+	handler.ServeHTTP(w, req)
+	resp := w.Result()
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	require.Nil(t, err)
+	assert.Equal(http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal("Memory budget exceeded", strings.TrimSpace(string(body)))
+	_, exists := s.rtmpConnections.get("mani")
+	assert.False(exists)
+	assert.Equal(int64(5), MemBudget.Used())
+
+	// the reservation is returned once the segment has been handled
+	MemBudget.Release("other", 5)
+	handler, _, w = requestSetup(s)
+	req = httptest.NewRequest("POST", "/live/mani/1.ts", strings.NewReader("Short.TS"))
+	handler.ServeHTTP(w, req)
+	resp = w.Result()
+	defer resp.Body.Close()
+	body, err = ioutil.ReadAll(resp.Body)
+	require.Nil(t, err)
+	assert.NotContains(string(body), "Memory budget exceeded")
+	assert.Equal(int64(0), MemBudget.StreamUsage("mani"))
+}
+
 func TestPush_ForAuthWebhookFailure(t *testing.T) {
 	// assert app data error
 	assert := assert.New(t)
@@ -847,7 +885,7 @@ func TestPush_ResolutionWithoutContentResolutionHeader(t *testing.T) {
 	assert := assert.New(t)
 	server := setupServer()
 	defer serverCleanup(server)
-	server.rtmpConnections = map[core.ManifestID]*rtmpConnection{}
+	server.rtmpConnections = newConnectionMap()
 	handler, reader, w := requestSetup(server)
 	req := httptest.NewRequest("POST", "/live/seg.ts", reader)
 	defaultRes := "0x0"
@@ -856,19 +894,20 @@ func TestPush_ResolutionWithoutContentResolutionHeader(t *testing.T) {
 	resp := w.Result()
 	defer resp.Body.Close()
 
-	assert.Len(server.rtmpConnections, 1)
-	for _, cxn := range server.rtmpConnections {
+	assert.Equal(1, server.rtmpConnections.len())
+	server.rtmpConnections.forEach(func(_ core.ManifestID, cxn *rtmpConnection) bool {
 		assert.Equal(cxn.profile.Resolution, defaultRes)
-	}
+		return true
+	})
 
-	server.rtmpConnections = map[core.ManifestID]*rtmpConnection{}
+	server.rtmpConnections = newConnectionMap()
 }
 
 func TestPush_ResolutionWithContentResolutionHeader(t *testing.T) {
 	assert := assert.New(t)
 	server := setupServer()
 	defer serverCleanup(server)
-	server.rtmpConnections = map[core.ManifestID]*rtmpConnection{}
+	server.rtmpConnections = newConnectionMap()
 	handler, reader, w := requestSetup(server)
 	req := httptest.NewRequest("POST", "/live/seg.ts", reader)
 	resolution := "123x456"
@@ -878,12 +917,13 @@ func TestPush_ResolutionWithContentResolutionHeader(t *testing.T) {
 	resp := w.Result()
 	defer resp.Body.Close()
 
-	assert.Len(server.rtmpConnections, 1)
-	for _, cxn := range server.rtmpConnections {
+	assert.Equal(1, server.rtmpConnections.len())
+	server.rtmpConnections.forEach(func(_ core.ManifestID, cxn *rtmpConnection) bool {
 		assert.Equal(resolution, cxn.profile.Resolution)
-	}
+		return true
+	})
 
-	server.rtmpConnections = map[core.ManifestID]*rtmpConnection{}
+	server.rtmpConnections = newConnectionMap()
 }
 
 func TestPush_WebhookRequestURL(t *testing.T) {
@@ -919,6 +959,103 @@ func TestPush_WebhookRequestURL(t *testing.T) {
 	assert.Equal(503, resp.StatusCode)
 }
 
+func TestPush_IPFilters(t *testing.T) {
+	assert := assert.New(t)
+	s := setupServer()
+	defer serverCleanup(s)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"manifestID":"filtered","ingestAllowCidrs":["10.0.0.0/8"],"playbackDenyCidrs":["10.1.0.0/16"]}`))
+	}))
+	defer ts.Close()
+
+	oldURL := AuthWebhookURL
+	defer func() { AuthWebhookURL = oldURL }()
+	AuthWebhookURL = ts.URL
+
+	push := func(addr string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/live/seg/0.ts", strings.NewReader(""))
+		req.RemoteAddr = addr
+		s.HandlePush(w, req)
+		return w.Code
+	}
+
+	// disallowed address can not create the stream
+	assert.Equal(http.StatusForbidden, push("192.0.2.1:1234"))
+	_, exists := s.rtmpConnections.get("filtered")
+	assert.False(exists)
+
+	// Server has empty sessions list, so it will return 503
+	assert.Equal(http.StatusServiceUnavailable, push("10.1.2.3:1234"))
+	_, exists = s.rtmpConnections.get("filtered")
+	assert.True(exists)
+
+	// nor push to the stream once it exists
+	assert.Equal(http.StatusForbidden, push("192.0.2.1:1234"))
+
+	h := s.filterPlayback(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	play := func(path, addr string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = addr
+		h.ServeHTTP(w, req)
+		return w.Code
+	}
+	for _, path := range []string{"/stream/filtered.m3u8", "/stream/filtered/P240p30fps4x3.m3u8", "/stream/filtered/P240p30fps4x3/0.ts"} {
+		assert.Equal(http.StatusForbidden, play(path, "10.1.2.3:1234"), path)
+		assert.Equal(http.StatusOK, play(path, "192.0.2.1:1234"), path)
+	}
+	// unknown streams are left to the playback handlers
+	assert.Equal(http.StatusOK, play("/stream/other.m3u8", "10.1.2.3:1234"))
+	// as are other routes
+	assert.Equal(http.StatusOK, play("/recordings/filtered/index.m3u8", "10.1.2.3:1234"))
+}
+
+func TestPush_Signed(t *testing.T) {
+	assert := assert.New(t)
+	s := setupServer()
+	defer serverCleanup(s)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"manifestID":"signed","pushSecret":"secret"}`))
+	}))
+	defer ts.Close()
+
+	oldURL := AuthWebhookURL
+	defer func() { AuthWebhookURL = oldURL }()
+	AuthWebhookURL = ts.URL
+
+	push := func(path, sig string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", path, strings.NewReader("data"))
+		if sig != "" {
+			req.Header.Set(PushSigHeader, sig)
+		}
+		s.HandlePush(w, req)
+		return w.Code
+	}
+
+	// unsigned pushes can not create the stream
+	assert.Equal(http.StatusForbidden, push("/live/seg/0.ts", ""))
+	_, exists := s.rtmpConnections.get("signed")
+	assert.False(exists)
+
+	// Server has empty sessions list, so it will return 503
+	sig := signPush("secret", "/live/seg/0.ts", "n0", []byte("data"), time.Now())
+	assert.Equal(http.StatusServiceUnavailable, push("/live/seg/0.ts", sig))
+	_, exists = s.rtmpConnections.get("signed")
+	assert.True(exists)
+
+	// a captured push can not be replayed
+	assert.Equal(http.StatusForbidden, push("/live/seg/0.ts", sig))
+	// nor its signature used for another segment
+	assert.Equal(http.StatusForbidden, push("/live/seg/1.ts", signPush("secret", "/live/seg/0.ts", "n1", []byte("data"), time.Now())))
+	assert.Equal(http.StatusServiceUnavailable, push("/live/seg/1.ts", signPush("secret", "/live/seg/1.ts", "n1", []byte("data"), time.Now())))
+}
+
 func TestPush_OSPerStream(t *testing.T) {
 	lpmon.NodeID = "testNode"
 	drivers.Testing = true
@@ -1106,8 +1243,8 @@ func TestPush_ReuseIntmidWithDiffExtmid(t *testing.T) {
 	resp.Body.Close()
 	assert.Equal(1, hookCalled)
 	s.connectionLock.Lock()
-	_, exists := s.rtmpConnections["intmid"]
-	intmid := s.internalManifests["extmid1"]
+	_, exists := s.rtmpConnections.get("intmid")
+	intmid, _ := s.streamAliases.lookup("extmid1")
 	s.connectionLock.Unlock()
 	assert.Equal("intmid", string(intmid))
 	assert.True(exists)
@@ -1122,9 +1259,9 @@ func TestPush_ReuseIntmidWithDiffExtmid(t *testing.T) {
 	resp.Body.Close()
 	assert.Equal(2, hookCalled)
 	s.connectionLock.Lock()
-	_, exists = s.rtmpConnections["intmid"]
-	intmid = s.internalManifests["extmid2"]
-	_, existsOld := s.internalManifests["extmid1"]
+	_, exists = s.rtmpConnections.get("intmid")
+	intmid, _ = s.streamAliases.lookup("extmid2")
+	_, existsOld := s.streamAliases.lookup("extmid1")
 	s.connectionLock.Unlock()
 	assert.Equal("intmid", string(intmid))
 	assert.True(exists)
@@ -1133,12 +1270,140 @@ func TestPush_ReuseIntmidWithDiffExtmid(t *testing.T) {
 	time.Sleep(200 * time.Millisecond)
 
 	s.connectionLock.Lock()
-	_, exists = s.rtmpConnections["intmid"]
-	_, extEx := s.internalManifests["extmid1"]
-	_, extEx2 := s.internalManifests["extmid2"]
+	_, exists = s.rtmpConnections.get("intmid")
+	_, extEx := s.streamAliases.lookup("extmid1")
+	_, extEx2 := s.streamAliases.lookup("extmid2")
 	s.connectionLock.Unlock()
 	cancel()
 	assert.False(exists)
 	assert.False(extEx)
 	assert.False(extEx2)
 }
+
+func TestPush_PinnedElsewhere(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	s := setupServer()
+	defer serverCleanup(s)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"manifestID":"pinned","ingestNode":"https://b2.example.com:8935"}`))
+	}))
+	defer ts.Close()
+
+	oldURL, oldNodes := AuthWebhookURL, IngestNodes
+	defer func() { AuthWebhookURL, IngestNodes = oldURL, oldNodes }()
+	AuthWebhookURL = ts.URL
+	var err error
+	IngestNodes, err = NewIngestCluster("https://b1.example.com:8935", []string{"https://b1.example.com:8935", "https://b2.example.com:8935"})
+	require.Nil(err)
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/live/seg/0.ts?foo=bar", strings.NewReader(""))
+	s.HandlePush(w, req)
+	assert.Equal(http.StatusTemporaryRedirect, w.Code)
+	assert.Equal("https://b2.example.com:8935/live/seg/0.ts?foo=bar", w.Header().Get("Location"))
+	_, exists := s.rtmpConnections.get("pinned")
+	assert.False(exists)
+}
+
+func TestPush_ClusterRedirect(t *testing.T) {
+	assert := assert.New(t)
+	s := setupServer()
+	defer serverCleanup(s)
+
+	store := NewMemoryClusterStore()
+	b2 := newTestStreamCluster(t, store, "https://b2:8935")
+	b2.claim("remote", nil)
+	oldCluster := Cluster
+	defer func() { Cluster = oldCluster }()
+	Cluster = newTestStreamCluster(t, store, "https://b1:8935")
+
+	// streams ingested by another node are pushed and played there
+	w := httptest.NewRecorder()
+	s.HandlePush(w, httptest.NewRequest("POST", "/live/remote/0.ts", strings.NewReader("")))
+	assert.Equal(http.StatusTemporaryRedirect, w.Code)
+	assert.Equal("https://b2:8935/live/remote/0.ts", w.Header().Get("Location"))
+	_, exists := s.rtmpConnections.get("remote")
+	assert.False(exists)
+
+	h := s.redirectClusterPlayback(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/stream/remote/P240p30fps16x9.m3u8", nil))
+	assert.Equal(http.StatusTemporaryRedirect, w.Code)
+	assert.Equal("https://b2:8935/stream/remote/P240p30fps16x9.m3u8", w.Header().Get("Location"))
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/stream/other.m3u8", nil))
+	assert.Equal(http.StatusOK, w.Code)
+
+	// local streams are shared with the cluster
+	w = httptest.NewRecorder()
+	s.HandlePush(w, httptest.NewRequest("POST", "/live/local/0.ts", strings.NewReader("")))
+	assert.Equal("https://b1:8935", b2.remoteNode("local"))
+	removeRTMPStream(s, "local")
+	assert.Empty(b2.remoteNode("local"))
+
+	// interrupted streams of other nodes are taken over where they stopped
+	b2.claim("taken", nil)
+	b2.sawSeqNo("taken", 4)
+	b2.flush()
+	store.Delete(clusterLivePrefix + "taken")
+	w = httptest.NewRecorder()
+	s.HandlePush(w, httptest.NewRequest("POST", "/live/taken/5.ts", strings.NewReader("")))
+	cxn, exists := s.rtmpConnections.get("taken")
+	assert.True(exists)
+	assert.Equal(uint64(5), cxn.startSeqNo)
+	assert.Equal("https://b1:8935", b2.remoteNode("taken"))
+}
+
+func TestPush_Terminate(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	s := setupServer()
+	defer serverCleanup(s)
+
+	defer func(auth *CLIAuthorizer) { CLIAuth = auth }(CLIAuth)
+	terminate := func(mid, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("DELETE", "/live/"+mid, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		s.HandlePush(w, req)
+		return w
+	}
+
+	cxn, err := s.registerConnection(stream.NewBasicRTMPVideoStream(&core.StreamParameters{ManifestID: "intmid"}))
+	require.Nil(err)
+	s.streamAliases.bind("extmid", "intmid")
+	atomic.AddUint64(&cxn.sourceBytes, 100)
+	atomic.AddUint64(&cxn.transcodedBytes, 250)
+
+	// streams can't be ended without CLI users to authorize the request
+	assert.Equal(http.StatusForbidden, terminate("extmid", "").Code)
+
+	CLIAuth, err = newCLIAuthorizer([]byte(`[
+		{"name": "viewer", "role": "read-only", "token": "viewtoken"},
+		{"name": "ops", "role": "operator", "token": "opstoken"}
+	]`))
+	require.Nil(err)
+	assert.Equal(http.StatusUnauthorized, terminate("extmid", "").Code)
+	assert.Equal(http.StatusForbidden, terminate("extmid", "viewtoken").Code)
+	_, exists := s.rtmpConnections.get("intmid")
+	assert.True(exists)
+
+	w := terminate("extmid", "opstoken")
+	require.Equal(http.StatusOK, w.Code)
+	var st StreamStatus
+	require.Nil(json.Unmarshal(w.Body.Bytes(), &st))
+	assert.Equal("intmid", st.ManifestID)
+	assert.Equal("extmid", st.ExternalManifestID)
+	assert.Equal(uint64(100), st.SourceBytes)
+	assert.Equal(uint64(250), st.TranscodedBytes)
+	_, exists = s.rtmpConnections.get("intmid")
+	assert.False(exists)
+
+	assert.Equal(http.StatusNotFound, terminate("extmid", "opstoken").Code)
+}
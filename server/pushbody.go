@@ -0,0 +1,108 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/livepeer/lpms/ffmpeg"
+)
+
+// CheckPushBodies makes segments pushed over HTTP be rejected when they are
+// empty, truncated or otherwise malformed, rather than fail in transcoding
+var CheckPushBodies bool
+
+// Reasons segments are rejected for
+const (
+	segmentEmpty     = "empty"
+	segmentTruncated = "truncated"
+	segmentCorrupt   = "corrupt"
+)
+
+// malformedSegmentError tells why a segment was rejected
+type malformedSegmentError struct {
+	Reason string
+	msg    string
+}
+
+func (e *malformedSegmentError) Error() string {
+	return fmt.Sprintf("%s segment: %s", e.Reason, e.msg)
+}
+
+func malformedSegment(reason, format string, args ...interface{}) *malformedSegmentError {
+	return &malformedSegmentError{Reason: reason, msg: fmt.Sprintf(format, args...)}
+}
+
+// checkSegmentBody returns an error if the segment `data` of format `format`
+// is empty or, for MPEG-TS and MP4, doesn't hold whole packets or boxes
+func checkSegmentBody(data []byte, format ffmpeg.Format) *malformedSegmentError {
+	if len(data) == 0 {
+		return malformedSegment(segmentEmpty, "no data")
+	}
+	switch format {
+	case ffmpeg.FormatMPEGTS:
+		return checkTSBody(data)
+	case ffmpeg.FormatMP4:
+		return checkMP4Body(data)
+	}
+	return nil
+}
+
+func checkTSBody(data []byte) *malformedSegmentError {
+	pat := false
+	off := 0
+	for ; off+tsPacketSize <= len(data); off += tsPacketSize {
+		pkt := data[off : off+tsPacketSize]
+		if pkt[0] != 0x47 {
+			return malformedSegment(segmentCorrupt, "no MPEG-TS sync byte at offset %d", off)
+		}
+		if pkt[1]&0x1f == 0 && pkt[2] == 0 {
+			pat = true
+		}
+	}
+	if off < len(data) {
+		if data[off] != 0x47 {
+			return malformedSegment(segmentCorrupt, "no MPEG-TS sync byte at offset %d", off)
+		}
+		return malformedSegment(segmentTruncated, "partial MPEG-TS packet of %d bytes at offset %d", len(data)-off, off)
+	}
+	if !pat {
+		return malformedSegment(segmentCorrupt, "no MPEG-TS program association table")
+	}
+	return nil
+}
+
+func checkMP4Body(data []byte) *malformedSegmentError {
+	movie := false
+	for off := 0; off < len(data); {
+		rest := data[off:]
+		if len(rest) < 8 {
+			return malformedSegment(segmentTruncated, "partial MP4 box header of %d bytes at offset %d", len(rest), off)
+		}
+		size := uint64(binary.BigEndian.Uint32(rest))
+		hdr := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(rest))
+		case 1:
+			if len(rest) < 16 {
+				return malformedSegment(segmentTruncated, "partial MP4 box header of %d bytes at offset %d", len(rest), off)
+			}
+			size, hdr = binary.BigEndian.Uint64(rest[8:]), 16
+		}
+		if size < hdr {
+			return malformedSegment(segmentCorrupt, "MP4 box of invalid size %d at offset %d", size, off)
+		}
+		if size > uint64(len(rest)) {
+			return malformedSegment(segmentTruncated, "MP4 box of %d bytes at offset %d has %d", size, off, len(rest))
+		}
+		// media needs a movie box, or a movie fragment for fragmented files
+		if typ := string(rest[4:8]); typ == "moov" || typ == "moof" {
+			movie = true
+		}
+		off += int(size)
+	}
+	if !movie {
+		return malformedSegment(segmentCorrupt, "no MP4 movie or movie fragment box")
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/lpms/ffmpeg"
+	"github.com/livepeer/lpms/stream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckSegmentBody(t *testing.T) {
+	assert := assert.New(t)
+
+	reason := func(data []byte, format ffmpeg.Format) string {
+		if err := checkSegmentBody(data, format); err != nil {
+			return err.Reason
+		}
+		return ""
+	}
+
+	ts := tsTestSegment(tsTestFrames(0, 3000), nil)
+	assert.Equal("", reason(ts, ffmpeg.FormatMPEGTS))
+	assert.Equal(segmentEmpty, reason(nil, ffmpeg.FormatMPEGTS))
+	assert.Equal(segmentTruncated, reason(ts[:len(ts)-10], ffmpeg.FormatMPEGTS))
+	assert.Equal(segmentCorrupt, reason([]byte("InsteadOf.TS"), ffmpeg.FormatMPEGTS))
+	lostSync := append([]byte(nil), ts...)
+	lostSync[tsPacketSize] = 0
+	assert.Equal(segmentCorrupt, reason(lostSync, ffmpeg.FormatMPEGTS))
+	// no program tables
+	assert.Equal(segmentCorrupt, reason(tsTestVideo(3000, 0), ffmpeg.FormatMPEGTS))
+
+	ftyp := mp4TestBox("ftyp", []byte("isom"))
+	mp4 := append(append([]byte(nil), ftyp...), mp4TestBox("moov", mp4TestMvhd(1000, 2000))...)
+	assert.Equal("", reason(mp4, ffmpeg.FormatMP4))
+	assert.Equal("", reason(append(mp4TestBox("styp"), mp4TestBox("moof")...), ffmpeg.FormatMP4))
+	assert.Equal(segmentEmpty, reason([]byte{}, ffmpeg.FormatMP4))
+	assert.Equal(segmentTruncated, reason(mp4[:len(mp4)-1], ffmpeg.FormatMP4))
+	assert.Equal(segmentTruncated, reason(append(mp4, 0, 0, 0), ffmpeg.FormatMP4))
+	assert.Equal(segmentCorrupt, reason(ftyp, ffmpeg.FormatMP4))
+	assert.Equal(segmentCorrupt, reason([]byte{0, 0, 0, 4, 'f', 't', 'y', 'p'}, ffmpeg.FormatMP4))
+
+	// other formats are only checked for data
+	assert.Equal("", reason([]byte("data"), ffmpeg.FormatNone))
+	assert.Equal(segmentEmpty, reason(nil, ffmpeg.FormatNone))
+}
+
+func TestPush_MalformedSegment(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	s := setupServer()
+	defer serverCleanup(s)
+
+	defer func() { CheckPushBodies = false }()
+	CheckPushBodies = true
+
+	events := make(chan streamEvent, 1)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev streamEvent
+		body, _ := ioutil.ReadAll(r.Body)
+		if json.Unmarshal(body, &ev) == nil && ev.Event == "segmentRejected" {
+			events <- ev
+		}
+	}))
+	defer hook.Close()
+	defer func(url string) { StreamEventWebhookURL = url }(StreamEventWebhookURL)
+	StreamEventWebhookURL = hook.URL
+
+	push := func(path string, data []byte) (int, string) {
+		w := httptest.NewRecorder()
+		s.HandlePush(w, httptest.NewRequest("POST", path, bytes.NewReader(data)))
+		resp := w.Result()
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		require.Nil(err)
+		return resp.StatusCode, string(body)
+	}
+
+	// rejected before a stream is started
+	code, body := push("/live/mani/0.ts", nil)
+	assert.Equal(http.StatusUnprocessableEntity, code)
+	assert.Contains(body, "empty segment")
+	_, exists := s.rtmpConnections.get("mani")
+	assert.False(exists)
+
+	// the owners of started streams are told
+	params := &core.StreamParameters{ManifestID: "mani", Labels: core.NewStreamLabels(map[string]string{"customer": "cust1"})}
+	_, err := s.registerConnection(stream.NewBasicRTMPVideoStream(params))
+	require.Nil(err)
+	ts := tsTestSegment(tsTestFrames(0, 3000), nil)
+	code, body = push("/live/mani/1.ts", ts[:len(ts)-1])
+	assert.Equal(http.StatusUnprocessableEntity, code)
+	assert.Contains(body, "truncated segment")
+	select {
+	case ev := <-events:
+		assert.Equal("segmentRejected", ev.Event)
+		assert.Equal("mani", ev.ManifestID)
+		assert.Equal(map[string]string{"customer": "cust1"}, ev.Labels)
+		require.NotNil(ev.Error)
+		assert.Equal(ErrorCategoryIngest, ev.Error.Category)
+		assert.Contains(ev.Error.Message, "partial MPEG-TS packet")
+	case <-time.After(time.Second):
+		t.Fatal("no stream event")
+	}
+
+	// whole segments go through
+	code, body = push("/live/mani/2.ts", ts)
+	assert.NotEqual(http.StatusUnprocessableEntity, code)
+	assert.NotContains(body, "segment:")
+}
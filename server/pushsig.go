@@ -0,0 +1,113 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	errPushSigMissing  = newError(ErrorCategoryAuth, false, "ErrPushSigMissing")
+	errPushSigInvalid  = newError(ErrorCategoryAuth, false, "ErrPushSigInvalid")
+	errPushSigExpired  = newError(ErrorCategoryAuth, false, "ErrPushSigExpired")
+	errPushSigReplayed = newError(ErrorCategoryAuth, false, "ErrPushSigReplayed")
+)
+
+// PushSigHeader carries the signature of a push to a stream whose auth webhook
+// response has a pushSecret, as
+//
+//	t=<unix seconds>,n=<nonce>,s=<hex HMAC-SHA256 of "<t>\n<n>\n<URL path>\n<hex SHA-256 of body>">
+//
+// The nonce must be unique within PushSigWindow.
+const PushSigHeader = "Livepeer-Push-Signature"
+
+// PushSigWindow is how far the timestamp of a signed push may be from the
+// time it is received
+var PushSigWindow = 30 * time.Second
+
+// pushVerifier rejects pushes that are not signed with the secret of the
+// stream, are too old or replay an earlier push. A nil *pushVerifier accepts
+// every push.
+type pushVerifier struct {
+	secret []byte
+
+	mu     sync.Mutex
+	nonces map[string]time.Time
+}
+
+func newPushVerifier(secret string) *pushVerifier {
+	if secret == "" {
+		return nil
+	}
+	return &pushVerifier{secret: []byte(secret), nonces: make(map[string]time.Time)}
+}
+
+func (v *pushVerifier) mac(ts, nonce, path string, body []byte) []byte {
+	sum := sha256.Sum256(body)
+	h := hmac.New(sha256.New, v.secret)
+	fmt.Fprintf(h, "%s\n%s\n%s\n%s", ts, nonce, path, hex.EncodeToString(sum[:]))
+	return h.Sum(nil)
+}
+
+// check verifies the signature and timestamp of a push, returning its nonce
+func (v *pushVerifier) check(path, header string, body []byte, now time.Time) (string, error) {
+	if v == nil {
+		return "", nil
+	}
+	if header == "" {
+		return "", errPushSigMissing
+	}
+	var ts, nonce, sig string
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return "", errPushSigInvalid
+		}
+		switch kv[0] {
+		case "t":
+			ts = kv[1]
+		case "n":
+			nonce = kv[1]
+		case "s":
+			sig = kv[1]
+		}
+	}
+	t, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || nonce == "" {
+		return "", errPushSigInvalid
+	}
+	mac, err := hex.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, v.mac(ts, nonce, path, body)) {
+		return "", errPushSigInvalid
+	}
+	if d := now.Sub(time.Unix(t, 0)); d > PushSigWindow || d < -PushSigWindow {
+		return "", errPushSigExpired
+	}
+	return nonce, nil
+}
+
+// verify checks a push and remembers its nonce so it can't be replayed
+func (v *pushVerifier) verify(path, header string, body []byte, now time.Time) error {
+	nonce, err := v.check(path, header, body, now)
+	if v == nil || err != nil {
+		return err
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	// nonces are only kept for as long as their pushes would be accepted
+	for n, seen := range v.nonces {
+		if now.Sub(seen) > 2*PushSigWindow {
+			delete(v.nonces, n)
+		}
+	}
+	if _, ok := v.nonces[nonce]; ok {
+		return errPushSigReplayed
+	}
+	v.nonces[nonce] = now
+	return nil
+}
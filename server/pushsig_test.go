@@ -0,0 +1,58 @@
+package server
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// signPush returns the PushSigHeader value for a push of `body` to `path`
+func signPush(secret, path, nonce string, body []byte, now time.Time) string {
+	v := newPushVerifier(secret)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	return fmt.Sprintf("t=%s,n=%s,s=%s", ts, nonce, hex.EncodeToString(v.mac(ts, nonce, path, body)))
+}
+
+func TestPushVerifier(t *testing.T) {
+	assert := assert.New(t)
+
+	var nilVerifier *pushVerifier
+	assert.Nil(nilVerifier.verify("/live/mani/0.ts", "", nil, time.Now()))
+	assert.Nil(newPushVerifier(""))
+
+	v := newPushVerifier("secret")
+	require.NotNil(t, v)
+	now := time.Now()
+	body := []byte("segment")
+	sig := signPush("secret", "/live/mani/0.ts", "n1", body, now)
+
+	assert.Equal(errPushSigMissing, v.verify("/live/mani/0.ts", "", body, now))
+	assert.Nil(v.verify("/live/mani/0.ts", sig, body, now))
+	// the same push can't be replayed
+	assert.Equal(errPushSigReplayed, v.verify("/live/mani/0.ts", sig, body, now.Add(time.Second)))
+
+	// the signature covers the path, body, timestamp and nonce
+	sig = signPush("secret", "/live/mani/1.ts", "n2", body, now)
+	assert.Equal(errPushSigInvalid, v.verify("/live/mani/2.ts", sig, body, now))
+	assert.Equal(errPushSigInvalid, v.verify("/live/mani/1.ts", sig, []byte("other"), now))
+	assert.Equal(errPushSigInvalid, v.verify("/live/mani/1.ts", sig+"00", body, now))
+	assert.Equal(errPushSigInvalid, v.verify("/live/mani/1.ts", signPush("other", "/live/mani/1.ts", "n2", body, now), body, now))
+	assert.Equal(errPushSigInvalid, v.verify("/live/mani/1.ts", "garbage", body, now))
+
+	// pushes signed too long ago or ahead are rejected
+	assert.Equal(errPushSigExpired, v.verify("/live/mani/1.ts", sig, body, now.Add(PushSigWindow+time.Second)))
+	assert.Equal(errPushSigExpired, v.verify("/live/mani/1.ts", sig, body, now.Add(-PushSigWindow-time.Second)))
+	assert.Nil(v.verify("/live/mani/1.ts", sig, body, now.Add(PushSigWindow-time.Second)))
+
+	// nonces are forgotten once their pushes have expired
+	later := now.Add(3 * PushSigWindow)
+	assert.Nil(v.verify("/live/mani/3.ts", signPush("secret", "/live/mani/3.ts", "n3", body, later), body, later))
+	v.mu.Lock()
+	assert.Len(v.nonces, 1)
+	v.mu.Unlock()
+}
@@ -0,0 +1,191 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/drivers"
+)
+
+// RecordChecksums makes the broadcaster write manifests of the SHA-256 of
+// every recorded segment, by track, into the record store next to the JSON
+// playlists of the recording. Finalizing a recording verifies its segments
+// against the manifests saved with it, and fails if any segment was altered
+// or lost, so that archives can be audited.
+var RecordChecksums bool
+
+const checksumManifestPrefix = "checksums_"
+
+// checksumManifestMaxSegments is how many segments a checksum manifest lists
+// before another one is started, which bounds the size of its saves
+var checksumManifestMaxSegments = 4096
+
+// checksumManifest lists the checksums of the recorded segments of each track
+type checksumManifest struct {
+	Tracks map[string][]segmentChecksum `json:"tracks"`
+}
+
+type segmentChecksum struct {
+	SeqNo  uint64 `json:"seq_no"`
+	URI    string `json:"uri"`
+	SHA256 string `json:"sha256"`
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// recordChecksums writes the checksum manifests of the recording of a
+// stream. A nil *recordChecksums writes nothing.
+type recordChecksums struct {
+	mid  core.ManifestID
+	sess drivers.OSSession
+
+	mu       sync.Mutex
+	name     string
+	manifest checksumManifest
+	segments int
+	// Only one save is in flight at a time; segments added in the meantime
+	// are saved once it completes
+	saving bool
+	dirty  bool
+}
+
+// newRecordChecksums returns the writer of the checksum manifests of the
+// stream `mid` recorded to `ros`, or nil if checksums are off or the stream
+// is not recorded
+func newRecordChecksums(mid core.ManifestID, ros drivers.OSSession) *recordChecksums {
+	if !RecordChecksums || ros == nil {
+		return nil
+	}
+	rc := &recordChecksums{mid: mid, sess: ros}
+	rc.rotate()
+	return rc
+}
+
+// rotate starts a new manifest. Must be called with mu held.
+func (rc *recordChecksums) rotate() {
+	rc.name = fmt.Sprintf("%s%d.json", checksumManifestPrefix, clock.Now().UnixNano())
+	rc.manifest = checksumManifest{Tracks: make(map[string][]segmentChecksum)}
+	rc.segments = 0
+}
+
+// add adds the segment `seqNo` of `track`, saved to the record store at
+// `uri`, with its `data` to the manifest, which is saved in the background
+func (rc *recordChecksums) add(track string, seqNo uint64, uri string, data []byte) {
+	if rc == nil {
+		return
+	}
+	sum := segmentChecksum{SeqNo: seqNo, URI: uri, SHA256: sha256Hex(data)}
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.manifest.Tracks[track] = append(rc.manifest.Tracks[track], sum)
+	rc.segments++
+	rc.save()
+}
+
+// save writes the manifest to the record store, or marks it to be written
+// once the save in flight completes. Must be called with mu held.
+func (rc *recordChecksums) save() {
+	if rc.saving {
+		rc.dirty = true
+		return
+	}
+	b, err := json.Marshal(rc.manifest)
+	if err != nil {
+		glog.Errorf("Error encoding checksum manifest manifestID=%s err=%v", rc.mid, err)
+		return
+	}
+	rc.saving = true
+	go func(name string, data []byte) {
+		if _, err := rc.sess.SaveData(name, data, nil); err != nil {
+			glog.Errorf("Error saving checksum manifest manifestID=%s name=%s bytes=%d err=%v", rc.mid, name, len(data), err)
+		}
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+		rc.saving = false
+		if rc.dirty {
+			rc.dirty = false
+			rc.save()
+		}
+	}(rc.name, b)
+	if rc.segments >= checksumManifestMaxSegments {
+		rc.rotate()
+	}
+}
+
+// listChecksumManifests returns the names of the checksum manifests saved
+// by the sessions of `manifests`
+func listChecksumManifests(ctx context.Context, sess drivers.OSSession, manifests []string) ([]string, error) {
+	prefixes := make([]string, len(manifests))
+	for i, mid := range manifests {
+		prefixes[i] = mid + "/"
+	}
+	manifestLists, err := drivers.ParallelListFiles(ctx, sess, prefixes, "/", 16)
+	if err != nil {
+		return nil, err
+	}
+	var dirPrefixes []string
+	for _, list := range manifestLists {
+		for _, dirName := range list.Directories {
+			dirPrefixes = append(dirPrefixes, dirName+checksumManifestPrefix)
+		}
+	}
+	dirLists, err := drivers.ParallelListFiles(ctx, sess, dirPrefixes, "", 16)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, list := range dirLists {
+		for _, f := range list.Files {
+			names = append(names, f.Name)
+		}
+	}
+	return names, nil
+}
+
+// verifyRecordingChecksums checks the recorded segments of `manifests`
+// against the checksum manifests saved with them; recordings without any are
+// left unchecked
+func verifyRecordingChecksums(ctx context.Context, sess drivers.OSSession, manifests []string) error {
+	names, err := listChecksumManifests(ctx, sess, manifests)
+	if err != nil {
+		return wrapError(ErrorCategoryStorage, true, err)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	_, datas, err := drivers.ParallelReadFiles(ctx, sess, names, 16)
+	if err != nil {
+		return wrapError(ErrorCategoryStorage, true, err)
+	}
+	verified := 0
+	for i, data := range datas {
+		var m checksumManifest
+		if err := json.Unmarshal(data, &m); err != nil {
+			return fmt.Errorf("invalid checksum manifest name=%s: %w", names[i], err)
+		}
+		for track, sums := range m.Tracks {
+			for _, sum := range sums {
+				seg, err := readRecordedSegment(ctx, sess, recordedManifest(manifests, sum.URI), sum.URI)
+				if err != nil {
+					return wrapError(ErrorCategoryStorage, true, fmt.Errorf("reading track=%s seqNo=%d: %w", track, sum.SeqNo, err))
+				}
+				if sha256Hex(seg) != sum.SHA256 {
+					return newError(ErrorCategoryStorage, false, fmt.Sprintf("checksum mismatch track=%s seqNo=%d uri=%s", track, sum.SeqNo, sum.URI))
+				}
+				verified++
+			}
+		}
+	}
+	glog.V(common.VERBOSE).Infof("Verified recorded segments manifests=%v segments=%d", manifests, verified)
+	return nil
+}
@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/drivers"
+	"github.com/livepeer/lpms/ffmpeg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordChecksums(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	mem, ok := drivers.NewMemoryDriver(nil).NewSession("mid").(*drivers.MemorySession)
+	require.True(ok)
+
+	// off unless enabled for recorded streams
+	rc := newRecordChecksums("mid", mem)
+	assert.Nil(rc)
+	rc.add("source", 0, "source/0.ts", []byte("seg0"))
+	defer func(on bool) { RecordChecksums = on }(RecordChecksums)
+	RecordChecksums = true
+	assert.Nil(newRecordChecksums("mid", nil))
+
+	defer func(n int) { checksumManifestMaxSegments = n }(checksumManifestMaxSegments)
+	checksumManifestMaxSegments = 3
+	rc = newRecordChecksums("mid", mem)
+	require.NotNil(rc)
+	first := rc.name
+	saved := func(name string) checksumManifest {
+		var m checksumManifest
+		if data := mem.GetData(name); data != nil {
+			require.Nil(json.Unmarshal(data, &m))
+		}
+		return m
+	}
+	rc.add("source", 0, "source/0.ts", []byte("seg0"))
+	rc.add("P144p30fps16x9", 0, "P144p30fps16x9/0.ts", []byte("low0"))
+	rc.add("source", 1, "source/1.ts", []byte("seg1"))
+	common.WaitAssert(t, time.Second, func() bool { return len(saved(first).Tracks["source"]) == 2 }, "checksum manifest not saved")
+	assert.Equal(checksumManifest{Tracks: map[string][]segmentChecksum{
+		"source": {
+			{SeqNo: 0, URI: "source/0.ts", SHA256: sha256Hex([]byte("seg0"))},
+			{SeqNo: 1, URI: "source/1.ts", SHA256: sha256Hex([]byte("seg1"))},
+		},
+		"P144p30fps16x9": {{SeqNo: 0, URI: "P144p30fps16x9/0.ts", SHA256: sha256Hex([]byte("low0"))}},
+	}}, saved(first))
+
+	// full manifests are followed by new ones
+	name := func() string {
+		rc.mu.Lock()
+		defer rc.mu.Unlock()
+		return rc.name
+	}
+	common.WaitAssert(t, time.Second, func() bool { return name() != first }, "checksum manifest not rotated")
+	second := name()
+	rc.add("source", 2, "source/2.ts", []byte("seg2"))
+	common.WaitAssert(t, time.Second, func() bool { return len(saved(second).Tracks["source"]) == 1 }, "checksum manifest not saved")
+	assert.Len(saved(first).Tracks["source"], 2)
+}
+
+// saveChecksumManifest saves the checksums of `segs`, as saved by
+// saveRecordedSegments, to the recording
+func saveChecksumManifest(t *testing.T, ros drivers.OSDriver, mid, node string, profile ffmpeg.VideoProfile, segs map[uint64]string) {
+	m := checksumManifest{Tracks: make(map[string][]segmentChecksum)}
+	for seqNo, data := range segs {
+		uri := fmt.Sprintf("https://pub.test/%s/%s/%s/%d.ts", mid, node, profile.Name, seqNo)
+		m.Tracks[profile.Name] = append(m.Tracks[profile.Name], segmentChecksum{SeqNo: seqNo, URI: uri, SHA256: sha256Hex([]byte(data))})
+	}
+	b, err := json.Marshal(m)
+	require.Nil(t, err)
+	_, err = ros.NewSession(mid).SaveData(node+"/"+checksumManifestPrefix+"1.json", b, nil)
+	require.Nil(t, err)
+}
+
+func TestFinalizeRecording_Checksums(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	ros := drivers.NewMemoryDriver(nil)
+	profile := ffmpeg.P144p30fps16x9
+	segs := map[uint64]string{0: "seg0", 1: "seg1"}
+	saveRecordedPlaylist(t, ros, "sumsmid", "node1", profile, 0, 1)
+	saveRecordedSegments(t, ros, "sumsmid", "node1", profile, segs)
+	saveChecksumManifest(t, ros, "sumsmid", "node1", profile, segs)
+	sess := ros.NewSession("sumsmid")
+	require.Nil(verifyRecordingChecksums(context.Background(), sess, []string{"sumsmid"}))
+	require.Nil(finalizeRecording(context.Background(), sess, []string{"sumsmid"}, "", false))
+
+	// altered segments fail the finalization
+	saveRecordedSegments(t, ros, "sumsmid", "node1", profile, map[uint64]string{1: "evil"})
+	err := finalizeRecording(context.Background(), sess, []string{"sumsmid"}, "", false)
+	assert.EqualError(err, "checksum mismatch track=P144p30fps16x9 seqNo=1 uri=https://pub.test/sumsmid/node1/P144p30fps16x9/1.ts")
+	assert.False(isRetryable(err))
+
+	// as do lost ones, until they are back
+	saveRecordedPlaylist(t, ros, "lostmid", "node1", profile, 0, 1)
+	saveRecordedSegments(t, ros, "lostmid", "node1", profile, map[uint64]string{0: "seg0"})
+	saveChecksumManifest(t, ros, "lostmid", "node1", profile, segs)
+	err = verifyRecordingChecksums(context.Background(), ros.NewSession("lostmid"), []string{"lostmid"})
+	assert.NotNil(err)
+	assert.True(isRetryable(err))
+
+	// recordings without checksums are left unchecked
+	saveRecordedPlaylist(t, ros, "nosumsmid", "node1", profile, 0)
+	assert.Nil(verifyRecordingChecksums(context.Background(), ros.NewSession("nosumsmid"), []string{"nosumsmid"}))
+}
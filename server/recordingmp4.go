@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/drivers"
+)
+
+// RecordingMP4 queues the finalization of recordings once their streams end,
+// and packages each rendition of finalized recordings into a single MP4
+// served under /recordings/{manifestID}/{rendition}.mp4
+var RecordingMP4 bool
+
+// queueRecordingFinalize queues the finalization of the recording of the
+// stream of `params`, once it ends
+func (s *LivepeerServer) queueRecordingFinalize(params *core.StreamParameters) {
+	mid := string(params.RecordingID)
+	if mid == "" {
+		mid = string(params.ManifestID)
+	}
+	job, err := s.jobs.submit(recordingFinalizeJobKind, mid, &recordingJobPayload{
+		ManifestID:  mid,
+		Manifests:   append(append([]string(nil), params.PreviousSessions...), mid),
+		RecordStore: params.RecordStore,
+		ExtURL:      params.RecordExtURL,
+		MP4:         true,
+	})
+	if err != nil {
+		glog.Errorf("Error queueing recording finalization manifestID=%s err=%v", mid, err)
+		return
+	}
+	glog.Infof("Queued recording finalization manifestID=%s jobID=%s", mid, job.ID)
+}
+
+// packageRecordingMP4s remuxes the MPEG-TS segments of each track of the
+// finalized recording `jspl` of `manifests` into a single MP4, saved next to
+// the playlist of the track
+func packageRecordingMP4s(ctx context.Context, sess drivers.OSSession, manifests []string, jspl *core.JsonPlaylist) error {
+	tracks := make([]string, 0, len(jspl.Segments))
+	for track := range jspl.Segments {
+		tracks = append(tracks, track)
+	}
+	sort.Strings(tracks)
+	for _, track := range tracks {
+		var uris []string
+		for _, seg := range jspl.Segments[track] {
+			uris = append(uris, seg.URI)
+		}
+		if err := packageRecordingMP4(ctx, sess, manifests, track, uris); err != nil {
+			return fmt.Errorf("packaging track=%s: %w", track, err)
+		}
+	}
+	return nil
+}
+
+// packageRecordingMP4 joins the recorded segments `uris` of `track` and
+// remuxes them into <track>.mp4. Tracks of other containers than MPEG-TS,
+// which can't be joined by concatenation, are skipped.
+func packageRecordingMP4(ctx context.Context, sess drivers.OSSession, manifests []string, track string, uris []string) error {
+	for _, uri := range uris {
+		if ext := path.Ext(uri); ext != "" && ext != ".ts" {
+			glog.Infof("Not packaging recorded track=%s of extension=%s into MP4", track, ext)
+			return nil
+		}
+	}
+	if len(uris) == 0 {
+		return nil
+	}
+	dir, err := ioutil.TempDir("", "recording")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	inName, outName := filepath.Join(dir, "in.ts"), filepath.Join(dir, "out.mp4")
+	in, err := os.Create(inName)
+	if err != nil {
+		return err
+	}
+	for _, uri := range uris {
+		data, err := readRecordedSegment(ctx, sess, recordedManifest(manifests, uri), uri)
+		if err == nil {
+			_, err = in.Write(data)
+		}
+		if err != nil {
+			in.Close()
+			return wrapError(ErrorCategoryStorage, true, err)
+		}
+	}
+	if err := in.Close(); err != nil {
+		return err
+	}
+	if err := remuxFile(inName, outName); err != nil {
+		return err
+	}
+	data, err := ioutil.ReadFile(outName)
+	if err != nil {
+		return err
+	}
+	if _, err := sess.SaveData(track+".mp4", data, nil); err != nil {
+		return wrapError(ErrorCategoryStorage, true, err)
+	}
+	return nil
+}
+
+// recordedManifest returns which of `manifests` the recorded segment `uri`
+// belongs to; the last one if the URI doesn't tell
+func recordedManifest(manifests []string, uri string) string {
+	for _, mid := range manifests {
+		if strings.Contains(uri, mid+"/") {
+			return mid
+		}
+	}
+	return manifests[len(manifests)-1]
+}
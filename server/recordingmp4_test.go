@@ -0,0 +1,138 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/drivers"
+	"github.com/livepeer/lpms/ffmpeg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubRemuxFile replaces remuxFile with a copy of the input prefixed by
+// "mp4:" for the duration of the test
+func stubRemuxFile(t *testing.T) {
+	oldRemux := remuxFile
+	t.Cleanup(func() { remuxFile = oldRemux })
+	remuxFile = func(inName, outName string) error {
+		data, err := ioutil.ReadFile(inName)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(outName, append([]byte("mp4:"), data...), 0644)
+	}
+}
+
+// saveRecordedSegments saves the data of the segments of the playlist saved
+// by saveRecordedPlaylist
+func saveRecordedSegments(t *testing.T, ros drivers.OSDriver, mid, node string, profile ffmpeg.VideoProfile, segs map[uint64]string) {
+	for seqNo, data := range segs {
+		_, err := ros.NewSession(mid).SaveData(fmt.Sprintf("%s/%s/%d.ts", node, profile.Name, seqNo), []byte(data), nil)
+		require.Nil(t, err)
+	}
+}
+
+func TestFinalizeRecording_MP4(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	stubRemuxFile(t)
+
+	ros := drivers.NewMemoryDriver(nil)
+	profile := ffmpeg.P144p30fps16x9
+	saveRecordedPlaylist(t, ros, "mp4mid", "node1", profile, 0, 1)
+	saveRecordedSegments(t, ros, "mp4mid", "node1", profile, map[uint64]string{0: "seg0", 1: "seg1"})
+	sess := ros.NewSession("mp4mid")
+
+	require.Nil(finalizeRecording(context.Background(), sess, []string{"mp4mid"}, "", false))
+	_, err := sess.ReadData(context.Background(), "mp4mid/P144p30fps16x9.mp4")
+	assert.NotNil(err)
+
+	require.Nil(finalizeRecording(context.Background(), sess, []string{"mp4mid"}, "", true))
+	fi, err := sess.ReadData(context.Background(), "mp4mid/P144p30fps16x9.mp4")
+	require.Nil(err)
+	defer fi.Body.Close()
+	data, err := ioutil.ReadAll(fi.Body)
+	require.Nil(err)
+	assert.Equal("mp4:seg0seg1", string(data))
+
+	// missing segments fail the packaging
+	saveRecordedPlaylist(t, ros, "gapmid", "node1", profile, 0, 1)
+	saveRecordedSegments(t, ros, "gapmid", "node1", profile, map[uint64]string{0: "seg0"})
+	assert.NotNil(finalizeRecording(context.Background(), ros.NewSession("gapmid"), []string{"gapmid"}, "", true))
+}
+
+func TestPackageRecordingMP4_SkipsOtherContainers(t *testing.T) {
+	assert := assert.New(t)
+	oldRemux := remuxFile
+	defer func() { remuxFile = oldRemux }()
+	remuxFile = func(inName, outName string) error {
+		t.Error("remuxed a track of MP4 segments")
+		return nil
+	}
+
+	sess := drivers.NewMemoryDriver(nil).NewSession("mid")
+	assert.Nil(packageRecordingMP4(context.Background(), sess, []string{"mid"}, "low", []string{"mid/node1/low/0.mp4"}))
+	_, err := sess.ReadData(context.Background(), "mid/low.mp4")
+	assert.NotNil(err)
+}
+
+func TestRecordedManifest(t *testing.T) {
+	assert := assert.New(t)
+	manifests := []string{"sess1", "sess2"}
+	assert.Equal("sess1", recordedManifest(manifests, "https://pub.test/sess1/node1/source/0.ts"))
+	assert.Equal("sess2", recordedManifest(manifests, "sess2/node1/source/0.ts"))
+	assert.Equal("sess2", recordedManifest(manifests, "node1/source/0.ts"))
+}
+
+func TestQueueRecordingFinalize(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	stubRemuxFile(t)
+	s := setupServer()
+	defer serverCleanup(s)
+
+	oldStorage := drivers.RecordStorage
+	defer func() { drivers.RecordStorage = oldStorage }()
+	ros := drivers.NewMemoryDriver(nil)
+	drivers.RecordStorage = ros
+	profile := ffmpeg.P144p30fps16x9
+	saveRecordedPlaylist(t, ros, "endmid", "node1", profile, 0)
+	saveRecordedSegments(t, ros, "endmid", "node1", profile, map[uint64]string{0: "seg0"})
+
+	s.queueRecordingFinalize(&core.StreamParameters{ManifestID: "intmid", RecordingID: "endmid"})
+	jobs := s.jobs.list(recordingFinalizeJobKind, "")
+	require.Len(jobs, 1)
+	assert.Equal("endmid", jobs[0].Key)
+	var p recordingJobPayload
+	require.Nil(json.Unmarshal(jobs[0].Payload, &p))
+	assert.Equal([]string{"endmid"}, p.Manifests)
+	assert.True(p.MP4)
+
+	common.WaitAssert(t, 5*time.Second, func() bool {
+		job, _ := s.jobs.get(jobs[0].ID)
+		return job.finished()
+	}, "recording finalization did not finish")
+
+	// served under /recordings/ once packaged
+	writer := httptest.NewRecorder()
+	s.HandleRecordings(writer, httptest.NewRequest("GET", "/recordings/endmid/P144p30fps16x9.mp4", nil))
+	resp := writer.Result()
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	assert.Equal("video/mp4", resp.Header.Get("Content-Type"))
+	assert.Equal("mp4:seg0", string(body))
+
+	writer = httptest.NewRecorder()
+	s.HandleRecordings(writer, httptest.NewRequest("GET", "/recordings/endmid/P720p30fps16x9.mp4", nil))
+	assert.Equal(http.StatusNotFound, writer.Result().StatusCode)
+}
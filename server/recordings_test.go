@@ -3,10 +3,13 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/livepeer/go-livepeer/core"
@@ -59,15 +62,15 @@ func TestRecordingHandler(t *testing.T) {
 
 	jpl := core.NewJSONPlaylist()
 	profile := ffmpeg.P144p25fps16x9
-	jpl.InsertHLSSegment(&profile, 1, "sess1/testNode/P144p25fps16x9/1.ts", 2100)
+	jpl.InsertHLSSegment(&profile, 1, "sess1/testNode/P144p25fps16x9/1.ts", 2100, nil)
 	bjpl, _ := json.Marshal(jpl)
 	msess1.SaveData("testNode/playlist_1.json", bjpl, nil)
 	jpl = core.NewJSONPlaylist()
-	jpl.InsertHLSSegment(&profile, 2, "sess2/testNode/P144p25fps16x9/2.ts", 2100)
+	jpl.InsertHLSSegment(&profile, 2, "sess2/testNode/P144p25fps16x9/2.ts", 2100, nil)
 	bjpl, _ = json.Marshal(jpl)
 	msess2.SaveData("testNode/playlist_2.json", bjpl, nil)
 	jpl = core.NewJSONPlaylist()
-	jpl.InsertHLSSegment(&profile, 1, "sess3/testNode/P144p25fps16x9/3.ts", 2100)
+	jpl.InsertHLSSegment(&profile, 1, "sess3/testNode/P144p25fps16x9/3.ts", 2100, nil)
 	bjpl, _ = json.Marshal(jpl)
 	msess3.SaveData("testNode/playlist_3.json", bjpl, nil)
 
@@ -131,12 +134,12 @@ func TestRecording(t *testing.T) {
 
 	jpl := core.NewJSONPlaylist()
 	profile := ffmpeg.P144p25fps16x9
-	jpl.InsertHLSSegment(&profile, 1, "testNode/P144p25fps16x9/1.ts", 2100)
+	jpl.InsertHLSSegment(&profile, 1, "testNode/P144p25fps16x9/1.ts", 2100, nil)
 	bjpl, err := json.Marshal(jpl)
 	assert.Nil(err)
 	msess.SaveData("testNode/playlist_1.json", bjpl, nil)
 	jpl = core.NewJSONPlaylist()
-	jpl.InsertHLSSegment(&profile, 2, "testNode/P144p25fps16x9/2.ts", 2100)
+	jpl.InsertHLSSegment(&profile, 2, "testNode/P144p25fps16x9/2.ts", 2100, nil)
 	bjpl, err = json.Marshal(jpl)
 	assert.Nil(err)
 	msess.SaveData("testNode/playlist_2.json", bjpl, nil)
@@ -167,12 +170,12 @@ func TestRecording(t *testing.T) {
 
 	msess = mos.NewSession("sess2")
 	jpl = core.NewJSONPlaylist()
-	jpl.InsertHLSSegment(&profile, 3, "testNode/P144p25fps16x9/3.ts", 2100)
+	jpl.InsertHLSSegment(&profile, 3, "testNode/P144p25fps16x9/3.ts", 2100, nil)
 	bjpl, err = json.Marshal(jpl)
 	assert.Nil(err)
 	msess.SaveData("testNode/playlist_1.json", bjpl, nil)
 	jpl = core.NewJSONPlaylist()
-	jpl.InsertHLSSegment(&profile, 4, "testNode/P144p25fps16x9/4.ts", 2450)
+	jpl.InsertHLSSegment(&profile, 4, "testNode/P144p25fps16x9/4.ts", 2450, nil)
 	bjpl, err = json.Marshal(jpl)
 	assert.Nil(err)
 	msess.SaveData("testNode/playlist_2.json", bjpl, nil)
@@ -185,3 +188,66 @@ func TestRecording(t *testing.T) {
 	assert.NotNil(err)
 	assert.Nil(fir)
 }
+
+type signingOS struct {
+	drivers.OSDriver
+}
+
+func (os *signingOS) NewSession(path string) drivers.OSSession {
+	return &signingSession{OSSession: os.OSDriver.NewSession(path)}
+}
+
+type signingSession struct {
+	drivers.OSSession
+}
+
+func (s *signingSession) SignedURL(name string, expires time.Duration) (string, error) {
+	if name == "fail/source/1.ts" {
+		return "", errors.New("signing failed")
+	}
+	return fmt.Sprintf("https://signed.test/%s?expires=%s", name, expires), nil
+}
+
+func TestRecording_Redirect(t *testing.T) {
+	drivers.Testing = true
+	assert := assert.New(t)
+	s := setupServer()
+	defer serverCleanup(s)
+
+	oldStorage := drivers.RecordStorage
+	defer func() { drivers.RecordStorage = oldStorage }()
+	mos := drivers.NewMemoryDriver(nil)
+	drivers.RecordStorage = &signingOS{mos}
+	oldExpiry := RecordingsRedirectExpiry
+	defer func() { RecordingsRedirectExpiry = oldExpiry }()
+
+	makeReq := func(uri string) *http.Response {
+		writer := httptest.NewRecorder()
+		s.HandleRecordings(writer, httptest.NewRequest("GET", uri, nil))
+		return writer.Result()
+	}
+	mos.NewSession("sess1").SaveData("source/1.ts", []byte("segmentdata"), nil)
+	mos.NewSession("fail").SaveData("source/1.ts", []byte("faildata"), nil)
+
+	// proxied by default, with a length so that file backed readers can use sendfile
+	resp := makeReq("/recordings/sess1/source/1.ts")
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(200, resp.StatusCode)
+	assert.Equal("segmentdata", string(body))
+	assert.Equal("11", resp.Header.Get("Content-Length"))
+
+	RecordingsRedirectExpiry = time.Minute
+	resp = makeReq("/recordings/sess1/source/1.ts")
+	resp.Body.Close()
+	assert.Equal(http.StatusFound, resp.StatusCode)
+	assert.Equal("https://signed.test/sess1/source/1.ts?expires=1m0s", resp.Header.Get("Location"))
+	assert.Equal("*", resp.Header.Get("Access-Control-Allow-Origin"))
+
+	// falls back to proxying if the URL can't be signed
+	resp = makeReq("/recordings/fail/source/1.ts")
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(200, resp.StatusCode)
+	assert.Equal("faildata", string(body))
+}
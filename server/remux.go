@@ -0,0 +1,113 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/lpms/ffmpeg"
+)
+
+const remuxPrefix = "/remux/"
+
+// HandleRemux remuxes a segment POSTed to /remux/{name}.ts or
+// /remux/{name}.mp4 into the container of the extension of the path, and
+// answers with the remuxed segment. The streams of the segment are copied
+// without being transcoded, on this node, and the container of the segment
+// is told from its data.
+func (s *LivepeerServer) HandleRemux(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" && r.Method != "PUT" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	out := common.ProfileExtensionFormat(path.Ext(r.URL.Path))
+	if out == ffmpeg.FormatNone {
+		http.Error(w, fmt.Sprintf("Unsupported output extension %q", path.Ext(r.URL.Path)), http.StatusBadRequest)
+		return
+	}
+	buf := readSegmentBody(w, r)
+	if buf == nil {
+		return
+	}
+	defer buf.Release()
+	data := buf.Bytes()
+
+	in := segmentFormat(data)
+	if in == ffmpeg.FormatNone {
+		http.Error(w, "Unrecognized segment container", http.StatusUnprocessableEntity)
+		return
+	}
+	if merr := checkSegmentBody(data, in); merr != nil {
+		http.Error(w, merr.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+	remuxed, err := remuxSegment(data, in, out)
+	if err != nil {
+		glog.Errorf("Could not remux segment url=%s bytes=%d err=%v", common.RedactURL(r.URL.String()), len(data), err)
+		http.Error(w, "Could not remux segment", http.StatusUnprocessableEntity)
+		return
+	}
+	glog.V(common.DEBUG).Infof("Remuxed segment url=%s bytes=%d remuxed=%d", common.RedactURL(r.URL.String()), len(data), len(remuxed))
+	if typ, err := common.ProfileFormatMimeType(out); err == nil {
+		w.Header().Set("Content-Type", typ)
+	}
+	w.Write(remuxed)
+}
+
+// segmentFormat tells the container of the segment `data` from its first
+// bytes; FormatNone if it is neither MPEG-TS nor MP4
+func segmentFormat(data []byte) ffmpeg.Format {
+	if len(data) > 0 && data[0] == 0x47 {
+		return ffmpeg.FormatMPEGTS
+	}
+	if len(data) >= 8 {
+		switch string(data[4:8]) {
+		case "ftyp", "styp", "moov", "moof":
+			return ffmpeg.FormatMP4
+		}
+	}
+	return ffmpeg.FormatNone
+}
+
+// remuxSegment remuxes the segment `data` from the container `in` into the
+// container `out`
+var remuxSegment = func(data []byte, in, out ffmpeg.Format) ([]byte, error) {
+	inExt, err := common.ProfileFormatExtension(in)
+	if err != nil {
+		return nil, err
+	}
+	outExt, err := common.ProfileFormatExtension(out)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir("", "remux")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	inName, outName := filepath.Join(dir, "in"+inExt), filepath.Join(dir, "out"+outExt)
+	if err := ioutil.WriteFile(inName, data, 0644); err != nil {
+		return nil, err
+	}
+	if err := remuxFile(inName, outName); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(outName)
+}
+
+// remuxFile remuxes the file `inName` into the file `outName`, in the
+// containers of their extensions
+var remuxFile = func(inName, outName string) error {
+	_, err := ffmpeg.Transcode3(&ffmpeg.TranscodeOptionsIn{Fname: inName, Accel: ffmpeg.Software}, []ffmpeg.TranscodeOptions{{
+		Oname:        outName,
+		Accel:        ffmpeg.Software,
+		VideoEncoder: ffmpeg.ComponentOptions{Name: "copy"},
+		AudioEncoder: ffmpeg.ComponentOptions{Name: "copy"},
+	}})
+	return err
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/livepeer/lpms/ffmpeg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSegmentFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(ffmpeg.FormatMPEGTS, segmentFormat(tsTestSegment(tsTestFrames(0, 3000), nil)))
+	assert.Equal(ffmpeg.FormatMP4, segmentFormat(mp4TestBox("ftyp", []byte("isom"))))
+	assert.Equal(ffmpeg.FormatMP4, segmentFormat(mp4TestBox("moof")))
+	assert.Equal(ffmpeg.FormatNone, segmentFormat([]byte("InsteadOf.TS")))
+	assert.Equal(ffmpeg.FormatNone, segmentFormat(nil))
+}
+
+func TestRemux_Handler(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	s := setupServer()
+	defer serverCleanup(s)
+
+	oldRemux, oldMax := remuxSegment, MaxPushBodySize
+	defer func() { remuxSegment, MaxPushBodySize = oldRemux, oldMax }()
+	var remuxed []ffmpeg.Format
+	remuxSegment = func(data []byte, in, out ffmpeg.Format) ([]byte, error) {
+		remuxed = append(remuxed, in, out)
+		if out == ffmpeg.FormatMPEGTS {
+			return nil, errors.New("remux failed")
+		}
+		return []byte("remuxed"), nil
+	}
+
+	remux := func(method, path string, data []byte) (*http.Response, string) {
+		w := httptest.NewRecorder()
+		s.HandleRemux(w, httptest.NewRequest(method, path, bytes.NewReader(data)))
+		resp := w.Result()
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		require.Nil(err)
+		return resp, string(body)
+	}
+
+	ts := tsTestSegment(tsTestFrames(0, 3000), nil)
+	resp, body := remux("POST", "/remux/seg.mp4", ts)
+	assert.Equal(http.StatusOK, resp.StatusCode)
+	assert.Equal("video/mp4", resp.Header.Get("Content-Type"))
+	assert.Equal("remuxed", body)
+	assert.Equal([]ffmpeg.Format{ffmpeg.FormatMPEGTS, ffmpeg.FormatMP4}, remuxed)
+
+	remuxed = nil
+	mp4 := append(mp4TestBox("ftyp", []byte("isom")), mp4TestBox("moov", mp4TestMvhd(1000, 2000))...)
+	resp, _ = remux("POST", "/remux/seg.ts", mp4)
+	assert.Equal(http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.Equal([]ffmpeg.Format{ffmpeg.FormatMP4, ffmpeg.FormatMPEGTS}, remuxed)
+
+	// segments that can't be remuxed are rejected before ffmpeg sees them
+	remuxed = nil
+	resp, body = remux("POST", "/remux/seg.mp4", []byte("InsteadOf.TS"))
+	assert.Equal(http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.Contains(body, "Unrecognized segment container")
+	resp, body = remux("POST", "/remux/seg.mp4", ts[:len(ts)-1])
+	assert.Equal(http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.Contains(body, "truncated segment")
+	resp, _ = remux("POST", "/remux/seg.flv", ts)
+	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+	resp, _ = remux("GET", "/remux/seg.mp4", nil)
+	assert.Equal(http.StatusMethodNotAllowed, resp.StatusCode)
+	MaxPushBodySize = 4
+	resp, _ = remux("POST", "/remux/seg.mp4", ts)
+	assert.Equal(http.StatusRequestEntityTooLarge, resp.StatusCode)
+	assert.Nil(remuxed)
+}
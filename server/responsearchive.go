@@ -0,0 +1,83 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/livepeer/go-livepeer/drivers"
+)
+
+// ResponseArchiveRetention, if non-zero, makes the broadcaster archive what it
+// exchanged with the orchestrator for every transcoded segment to the record
+// object store of the stream: the segment credentials and payment it sent and
+// the raw TranscodeResult it got back, including the orchestrator's signature.
+// The archives can back disputes and billing audits.
+//
+// Object stores can't be asked to delete objects, so archives carry a
+// retain-until metadata value and are saved under responsesPrefix, where
+// lifecycle rules of the store should expire them after this long.
+var ResponseArchiveRetention time.Duration
+
+const responsesPrefix = "responses/"
+
+// archivedResponse is the JSON archived for a transcoded segment. Byte
+// slices are base64 encoded.
+type archivedResponse struct {
+	ManifestID   string    `json:"manifestID"`
+	SessionID    string    `json:"sessionID"`
+	Nonce        uint64    `json:"nonce"`
+	SeqNo        uint64    `json:"seqNo"`
+	Orchestrator string    `json:"orchestrator"`
+	Recipient    string    `json:"recipient,omitempty"`
+	ReceivedAt   time.Time `json:"receivedAt"`
+	RetainUntil  time.Time `json:"retainUntil"`
+	// SegCreds is the signed segment header sent to the orchestrator
+	SegCreds string `json:"segCreds"`
+	// Payment is the payment header sent to the orchestrator: a base64
+	// encoded net.Payment protobuf holding the tickets
+	Payment string `json:"payment,omitempty"`
+	// Response is the net.TranscodeResult protobuf as received
+	Response []byte `json:"response"`
+	// Sig is the orchestrator's signature over the hashes of the renditions
+	Sig []byte `json:"sig,omitempty"`
+}
+
+func responseArchiveName(seqNo uint64, sessionID string) string {
+	return fmt.Sprintf("%s%d_%s.json", responsesPrefix, seqNo, sessionID)
+}
+
+// newArchivedResponse records the transcode result `res` of segment `seqNo`
+// as received at `now`
+func newArchivedResponse(sess *BroadcastSession, nonce, seqNo uint64, res *ReceivedTranscodeResult, now time.Time) *archivedResponse {
+	rec := &archivedResponse{
+		ManifestID:   string(sess.Params.ManifestID),
+		SessionID:    sess.OrchestratorInfo.GetAuthToken().GetSessionId(),
+		Nonce:        nonce,
+		SeqNo:        seqNo,
+		Orchestrator: sess.OrchestratorInfo.GetTranscoder(),
+		ReceivedAt:   now.UTC(),
+		RetainUntil:  now.Add(ResponseArchiveRetention).UTC(),
+		SegCreds:     res.SegCreds,
+		Payment:      res.Payment,
+		Response:     res.Raw,
+	}
+	if tp := sess.OrchestratorInfo.GetTicketParams(); tp != nil {
+		rec.Recipient = ethcommon.BytesToAddress(tp.Recipient).Hex()
+	}
+	if res.TranscodeData != nil {
+		rec.Sig = res.Sig
+	}
+	return rec
+}
+
+// save writes the archive to the record object store session `os`
+func (rec *archivedResponse) save(os drivers.OSSession) (string, error) {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return "", err
+	}
+	meta := map[string]string{"retain-until": rec.RetainUntil.Format(time.RFC3339)}
+	return os.SaveData(responseArchiveName(rec.SeqNo, rec.SessionID), data, meta)
+}
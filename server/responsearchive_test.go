@@ -0,0 +1,60 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/drivers"
+	"github.com/livepeer/go-livepeer/net"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type metaOSSession struct {
+	drivers.OSSession
+	meta map[string]string
+}
+
+func (s *metaOSSession) SaveData(name string, data []byte, meta map[string]string) (string, error) {
+	s.meta = meta
+	return s.OSSession.SaveData(name, data, meta)
+}
+
+func TestArchiveResponse(t *testing.T) {
+	assert := assert.New(t)
+
+	defer func(r time.Duration) { ResponseArchiveRetention = r }(ResponseArchiveRetention)
+	ResponseArchiveRetention = 90 * 24 * time.Hour
+
+	sess := StubBroadcastSession("https://orch.example.com:8935")
+	sess.OrchestratorInfo.TicketParams = &net.TicketParams{Recipient: []byte{0xaa, 0xbb}}
+	res := &ReceivedTranscodeResult{
+		TranscodeData: &net.TranscodeData{Sig: []byte("orch sig")},
+		Raw:           []byte("raw result"),
+		SegCreds:      "creds",
+		Payment:       "payment",
+	}
+	now := time.Date(2020, 8, 1, 12, 0, 0, 0, time.UTC)
+	rec := newArchivedResponse(sess, 3, 7, res, now)
+
+	ros := &metaOSSession{OSSession: drivers.NewMemoryDriver(nil).NewSession("rec")}
+	uri, err := rec.save(ros)
+	require.Nil(t, err)
+	assert.Contains(uri, "responses/7_"+stubAuthToken.SessionId+".json")
+	assert.Equal(map[string]string{"retain-until": "2020-10-30T12:00:00Z"}, ros.meta)
+
+	var got archivedResponse
+	require.Nil(t, json.Unmarshal(ros.OSSession.(*drivers.MemorySession).GetData(uri), &got))
+	assert.Equal(string(sess.Params.ManifestID), got.ManifestID)
+	assert.Equal(uint64(3), got.Nonce)
+	assert.Equal(uint64(7), got.SeqNo)
+	assert.Equal("https://orch.example.com:8935", got.Orchestrator)
+	assert.Equal("0x000000000000000000000000000000000000aabb", strings.ToLower(got.Recipient))
+	assert.Equal("creds", got.SegCreds)
+	assert.Equal("payment", got.Payment)
+	assert.Equal([]byte("raw result"), got.Response)
+	assert.Equal([]byte("orch sig"), got.Sig)
+	assert.True(now.Equal(got.ReceivedAt))
+}
@@ -0,0 +1,429 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/monitor"
+	"github.com/livepeer/lpms/ffmpeg"
+)
+
+// RestreamFFmpegPath is the ffmpeg binary that pushes streams to their
+// restream targets
+var RestreamFFmpegPath = "ffmpeg"
+
+// States of the push to a restream target
+const (
+	RestreamConnecting   = "connecting"
+	RestreamLive         = "live"
+	RestreamReconnecting = "reconnecting"
+	RestreamStopped      = "stopped"
+)
+
+const (
+	restreamSourceProfile = "source"
+	// segments waiting to be pushed to a target before more are dropped
+	restreamQueueSize   = 8
+	restreamStopTimeout = 5 * time.Second
+)
+
+var (
+	// waits between attempts to push to a target after it fails; pushes
+	// that last longer than the maximum start over from the minimum
+	restreamRetryMin = time.Second
+	restreamRetryMax = 30 * time.Second
+
+	// restreamCommand returns the process that pushes the MPEG-TS it reads
+	// from its standard input to `url`
+	restreamCommand = func(url string) *exec.Cmd {
+		return exec.Command(RestreamFFmpegPath, "-hide_banner", "-loglevel", "error",
+			"-f", "mpegts", "-i", "pipe:0", "-c", "copy", "-f", "flv", url)
+	}
+
+	errRestreamExited = errors.New("restream process exited")
+)
+
+// RestreamStatus is the state of the push of a stream to a restream target
+type RestreamStatus struct {
+	// With the stream key redacted
+	URL     string `json:"url"`
+	Profile string `json:"profile"`
+	State   string `json:"state"`
+	// When the push entered State
+	Since           time.Time `json:"since"`
+	Connects        int       `json:"connects"`
+	BytesSent       uint64    `json:"bytesSent"`
+	SegmentsDropped uint64    `json:"segmentsDropped"`
+	LastError       string    `json:"lastError,omitempty"`
+}
+
+// validateRestreamTargets checks that `targets` are RTMP(S) URLs and name
+// renditions among `profiles`
+func validateRestreamTargets(targets []core.RestreamTarget, profiles []ffmpeg.VideoProfile) error {
+	for _, t := range targets {
+		u, err := url.Parse(t.URL)
+		if err != nil || (u.Scheme != "rtmp" && u.Scheme != "rtmps") || u.Host == "" {
+			return fmt.Errorf("invalid restream url=%s", common.RedactStreamKeyURL(t.URL))
+		}
+		if t.Profile == "" || t.Profile == restreamSourceProfile {
+			continue
+		}
+		found := false
+		for _, p := range profiles {
+			if p.Name == t.Profile {
+				if p.Format == ffmpeg.FormatMP4 {
+					return fmt.Errorf("restream profile=%s is not MPEG-TS", t.Profile)
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown restream profile=%s", t.Profile)
+		}
+	}
+	return nil
+}
+
+// restreams are the pushes of a stream to its restream targets. A nil
+// *restreams pushes nowhere.
+type restreams struct {
+	mid string
+
+	mu sync.Mutex
+	// by target URL
+	targets map[string]*restreamer
+}
+
+func newRestreams(mid string, targets []core.RestreamTarget) *restreams {
+	rs := &restreams{mid: mid, targets: make(map[string]*restreamer)}
+	rs.set(targets)
+	return rs
+}
+
+// set starts pushing to `targets`, and stops pushing to the targets that
+// are no longer among them
+func (rs *restreams) set(targets []core.RestreamTarget) {
+	if rs == nil {
+		return
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	keep := make(map[string]bool)
+	for _, t := range targets {
+		if t.Profile == "" {
+			t.Profile = restreamSourceProfile
+		}
+		keep[t.URL] = true
+		if r, ok := rs.targets[t.URL]; ok {
+			if r.target == t {
+				continue
+			}
+			r.stop()
+		}
+		r := newRestreamer(rs.mid, t)
+		rs.targets[t.URL] = r
+		go r.run()
+	}
+	for u, r := range rs.targets {
+		if !keep[u] {
+			r.stop()
+			delete(rs.targets, u)
+		}
+	}
+}
+
+// wants returns whether segments of the rendition `profile` are pushed to
+// any target
+func (rs *restreams) wants(profile string) bool {
+	if rs == nil {
+		return false
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for _, r := range rs.targets {
+		if r.target.Profile == profile {
+			return true
+		}
+	}
+	return false
+}
+
+// push queues the segment `data` of the rendition `profile` for the targets
+// it is pushed to. Segments are dropped for targets too slow to keep up.
+func (rs *restreams) push(profile string, data []byte) {
+	if rs == nil {
+		return
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	var seg []byte
+	for _, r := range rs.targets {
+		if r.target.Profile != profile {
+			continue
+		}
+		// `data` may be in a buffer that is reused once the segment is
+		// processed
+		if seg == nil {
+			seg = append([]byte(nil), data...)
+		}
+		r.queue(seg)
+	}
+}
+
+// stop stops pushing to all targets
+func (rs *restreams) stop() {
+	rs.set(nil)
+}
+
+func (rs *restreams) status() []RestreamStatus {
+	if rs == nil {
+		return nil
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	var res []RestreamStatus
+	for _, r := range rs.targets {
+		res = append(res, r.getStatus())
+	}
+	return res
+}
+
+// restreamer pushes a stream to one target, starting over whenever the push
+// fails until it is stopped
+type restreamer struct {
+	mid    string
+	target core.RestreamTarget
+	// host of the target, which the metrics of the push are tagged with
+	host     string
+	segs     chan []byte
+	done     chan struct{}
+	stopOnce sync.Once
+
+	mu     sync.Mutex
+	status RestreamStatus
+}
+
+func newRestreamer(mid string, target core.RestreamTarget) *restreamer {
+	var host string
+	if u, err := url.Parse(target.URL); err == nil {
+		host = u.Host
+	}
+	return &restreamer{
+		mid:    mid,
+		target: target,
+		host:   host,
+		segs:   make(chan []byte, restreamQueueSize),
+		done:   make(chan struct{}),
+		status: RestreamStatus{
+			URL:     common.RedactStreamKeyURL(target.URL),
+			Profile: target.Profile,
+			State:   RestreamConnecting,
+			Since:   time.Now(),
+		},
+	}
+}
+
+func (r *restreamer) queue(data []byte) {
+	select {
+	case r.segs <- data:
+	default:
+		r.mu.Lock()
+		r.status.SegmentsDropped++
+		r.mu.Unlock()
+		if monitor.Enabled {
+			monitor.RestreamSegmentDropped(r.mid, r.host, r.target.Profile)
+		}
+		glog.Warningf("Dropping segment for slow restream manifestID=%s url=%s", r.mid, r.status.URL)
+	}
+}
+
+func (r *restreamer) stop() {
+	r.stopOnce.Do(func() { close(r.done) })
+}
+
+func (r *restreamer) stopped() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (r *restreamer) getStatus() RestreamStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.status
+}
+
+func (r *restreamer) setState(state string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.status.State != state {
+		r.status.State = state
+		r.status.Since = time.Now()
+	}
+	if err != nil {
+		r.status.LastError = err.Error()
+	}
+}
+
+func (r *restreamer) run() {
+	glog.Infof("Starting restream manifestID=%s url=%s profile=%s", r.mid, r.status.URL, r.target.Profile)
+	wait := restreamRetryMin
+	for {
+		started := time.Now()
+		err := r.push()
+		if r.stopped() {
+			break
+		}
+		if time.Since(started) > restreamRetryMax {
+			wait = restreamRetryMin
+		}
+		glog.Errorf("Restream failed manifestID=%s url=%s retry=%s err=%v", r.mid, r.status.URL, wait, err)
+		if monitor.Enabled {
+			monitor.RestreamFailed(r.mid, r.host, r.target.Profile)
+		}
+		r.setState(RestreamReconnecting, err)
+		select {
+		case <-time.After(wait):
+		case <-r.done:
+		}
+		if r.stopped() {
+			break
+		}
+		if wait *= 2; wait > restreamRetryMax {
+			wait = restreamRetryMax
+		}
+	}
+	r.setState(RestreamStopped, nil)
+	glog.Infof("Stopped restream manifestID=%s url=%s", r.mid, r.status.URL)
+}
+
+// push runs one restream process, feeding it segments until it fails or the
+// restreamer is stopped
+func (r *restreamer) push() error {
+	cmd := restreamCommand(r.target.URL)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stderr := &tailWriter{max: 1024}
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return r.redact(err)
+	}
+	r.mu.Lock()
+	r.status.Connects++
+	r.mu.Unlock()
+	r.setState(RestreamConnecting, nil)
+	if monitor.Enabled {
+		monitor.RestreamConnected(r.mid, r.host, r.target.Profile)
+	}
+
+	var waitErr error
+	exited := make(chan struct{})
+	go func() {
+		waitErr = cmd.Wait()
+		close(exited)
+	}()
+	// let the process finish pushing what it has when stopped
+	go func() {
+		select {
+		case <-r.done:
+			stdin.Close()
+			select {
+			case <-exited:
+			case <-time.After(restreamStopTimeout):
+				cmd.Process.Kill()
+			}
+		case <-exited:
+		}
+	}()
+
+	exitErr := func(err error) error {
+		if msg := stderr.lastLine(); msg != "" {
+			err = fmt.Errorf("%v: %s", err, msg)
+		}
+		return r.redact(err)
+	}
+	for {
+		select {
+		case data := <-r.segs:
+			if _, err := stdin.Write(data); err != nil {
+				cmd.Process.Kill()
+				<-exited
+				return exitErr(err)
+			}
+			r.mu.Lock()
+			r.status.BytesSent += uint64(len(data))
+			r.mu.Unlock()
+			if monitor.Enabled {
+				monitor.RestreamBytesSent(r.mid, r.host, r.target.Profile, int64(len(data)))
+			}
+			r.setState(RestreamLive, nil)
+		case <-exited:
+			if waitErr == nil {
+				waitErr = errRestreamExited
+			}
+			return exitErr(waitErr)
+		case <-r.done:
+			<-exited
+			return nil
+		}
+	}
+}
+
+// redact masks the target URL, which carries the stream key, in `err`
+func (r *restreamer) redact(err error) error {
+	msg := err.Error()
+	if red := strings.Replace(msg, r.target.URL, r.status.URL, -1); red != msg {
+		return fmt.Errorf("%s", red)
+	}
+	return err
+}
+
+// tailWriter keeps the last `max` bytes written to it
+type tailWriter struct {
+	max int
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (w *tailWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	if len(w.buf) > w.max {
+		w.buf = w.buf[len(w.buf)-w.max:]
+	}
+	return len(p), nil
+}
+
+func (w *tailWriter) lastLine() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	lines := strings.Split(strings.TrimSpace(string(w.buf)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+func (s *LivepeerServer) setRestreams(mid core.ManifestID, targets []core.RestreamTarget) error {
+	cxn, ok := s.rtmpConnections.get(s.streamAliases.resolve(mid))
+	if !ok {
+		return errUnknownStream
+	}
+	if err := validateRestreamTargets(targets, cxn.params.Profiles); err != nil {
+		return err
+	}
+	cxn.restreams.set(targets)
+	return nil
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/lpms/ffmpeg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubRestreamCommand makes restreams write what they push to files in
+// `dir` named after the host of the target, failing the first `failures`
+// pushes with an error mentioning the target URL
+func stubRestreamCommand(t *testing.T, dir string, failures int) {
+	var mu sync.Mutex
+	oldCommand, oldRetry := restreamCommand, restreamRetryMin
+	restreamCommand = func(url string) *exec.Cmd {
+		mu.Lock()
+		defer mu.Unlock()
+		if failures > 0 {
+			failures--
+			return exec.Command("sh", "-c", `echo "$0: Connection refused" >&2; exit 1`, url)
+		}
+		host := strings.Split(strings.TrimPrefix(url, "rtmp://"), "/")[0]
+		return exec.Command("sh", "-c", `cat >> "$0"`, filepath.Join(dir, host))
+	}
+	restreamRetryMin = 10 * time.Millisecond
+	t.Cleanup(func() { restreamCommand, restreamRetryMin = oldCommand, oldRetry })
+}
+
+func waitForRestream(t *testing.T, r *restreamer, cond func(RestreamStatus) bool) RestreamStatus {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status := r.getStatus()
+		if cond(status) {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("restream status %+v", status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestValidateRestreamTargets(t *testing.T) {
+	assert := assert.New(t)
+	profiles := []ffmpeg.VideoProfile{ffmpeg.P240p30fps16x9, {Name: "mp4", Format: ffmpeg.FormatMP4}}
+
+	assert.Nil(validateRestreamTargets(nil, profiles))
+	assert.Nil(validateRestreamTargets([]core.RestreamTarget{
+		{URL: "rtmp://a.rtmp.youtube.com/live2/key"},
+		{URL: "rtmps://live.twitch.tv/app/key", Profile: "source"},
+		{URL: "rtmp://127.0.0.1:1935/live/key", Profile: ffmpeg.P240p30fps16x9.Name},
+	}, profiles))
+
+	err := validateRestreamTargets([]core.RestreamTarget{{URL: "https://example.com/live/key"}}, profiles)
+	assert.EqualError(err, "invalid restream url=https://example.com/live/xxxxx")
+	err = validateRestreamTargets([]core.RestreamTarget{{URL: "rtmp:///live/key"}}, profiles)
+	assert.EqualError(err, "invalid restream url=rtmp:///live/xxxxx")
+	err = validateRestreamTargets([]core.RestreamTarget{{URL: "rtmp://example.com/live/key", Profile: "P1080p"}}, profiles)
+	assert.EqualError(err, "unknown restream profile=P1080p")
+	err = validateRestreamTargets([]core.RestreamTarget{{URL: "rtmp://example.com/live/key", Profile: "mp4"}}, profiles)
+	assert.EqualError(err, "restream profile=mp4 is not MPEG-TS")
+}
+
+func TestRestreams_Push(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	dir, err := ioutil.TempDir("", "restream")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+	stubRestreamCommand(t, dir, 0)
+
+	rs := newRestreams("mid", []core.RestreamTarget{
+		{URL: "rtmp://a/live/key1"},
+		{URL: "rtmp://b/live/key2", Profile: "P240p30fps16x9"},
+	})
+	a, b := rs.targets["rtmp://a/live/key1"], rs.targets["rtmp://b/live/key2"]
+	assert.True(rs.wants("source"))
+	assert.True(rs.wants("P240p30fps16x9"))
+	assert.False(rs.wants("P360p30fps16x9"))
+
+	rs.push("source", []byte("src0"))
+	rs.push("P240p30fps16x9", []byte("240p0"))
+	rs.push("P360p30fps16x9", []byte("360p0"))
+	rs.push("source", []byte("src1"))
+	waitForRestream(t, a, func(s RestreamStatus) bool { return s.BytesSent == 8 })
+	status := waitForRestream(t, b, func(s RestreamStatus) bool { return s.BytesSent == 5 })
+	assert.Equal(RestreamLive, status.State)
+	assert.Equal("rtmp://b/live/xxxxx", status.URL)
+	assert.Equal("P240p30fps16x9", status.Profile)
+	assert.Equal(1, status.Connects)
+	assert.Len(rs.status(), 2)
+
+	// targets no longer set are stopped
+	rs.set([]core.RestreamTarget{{URL: "rtmp://b/live/key2", Profile: "P240p30fps16x9"}})
+	waitForRestream(t, a, func(s RestreamStatus) bool { return s.State == RestreamStopped })
+	assert.Same(b, rs.targets["rtmp://b/live/key2"])
+	assert.Len(rs.status(), 1)
+	assert.False(rs.wants("source"))
+
+	rs.stop()
+	waitForRestream(t, b, func(s RestreamStatus) bool { return s.State == RestreamStopped })
+	assert.Empty(rs.status())
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "a"))
+	require.Nil(err)
+	assert.Equal("src0src1", string(data))
+	data, err = ioutil.ReadFile(filepath.Join(dir, "b"))
+	require.Nil(err)
+	assert.Equal("240p0", string(data))
+
+	// nil restreams push nowhere
+	var none *restreams
+	none.set([]core.RestreamTarget{{URL: "rtmp://a/live/key"}})
+	none.push("source", []byte("src"))
+	assert.False(none.wants("source"))
+	assert.Nil(none.status())
+	none.stop()
+}
+
+func TestRestreamer_Reconnect(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	dir, err := ioutil.TempDir("", "restream")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+	stubRestreamCommand(t, dir, 2)
+
+	r := newRestreamer("mid", core.RestreamTarget{URL: "rtmp://a/live/secret", Profile: "source"})
+	// metrics are tagged with the host rather than the URL with its key
+	assert.Equal("a", r.host)
+	go r.run()
+	defer r.stop()
+
+	// the push is attempted again until it succeeds
+	waitForRestream(t, r, func(s RestreamStatus) bool { return s.Connects == 3 })
+	r.queue([]byte("seg"))
+	status := waitForRestream(t, r, func(s RestreamStatus) bool { return s.State == RestreamLive })
+	assert.Equal(3, status.Connects)
+	assert.Equal(uint64(3), status.BytesSent)
+	assert.Contains(status.LastError, "rtmp://a/live/xxxxx: Connection refused")
+	assert.NotContains(status.LastError, "secret")
+
+	// segments are dropped rather than block the stream
+	r.stop()
+	waitForRestream(t, r, func(s RestreamStatus) bool { return s.State == RestreamStopped })
+	for i := 0; i < restreamQueueSize+2; i++ {
+		r.queue([]byte("seg"))
+	}
+	assert.Equal(uint64(2), r.getStatus().SegmentsDropped)
+}
@@ -11,14 +11,16 @@ import (
 	"github.com/golang/glog"
 	"github.com/livepeer/go-livepeer/net"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/health"
 	"google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
 )
 
 const getOrchestratorTimeout = 2 * time.Second
 
-var errNoOrchestrators = errors.New("no orchestrators")
+var errNoOrchestrators = newError(ErrorCategoryDiscovery, true, "no orchestrators")
 
 type Router struct {
 	uris []*url.URL
@@ -81,6 +83,11 @@ func (r *Router) Ping(ctx context.Context, req *net.PingPong) (*net.PingPong, er
 	return &net.PingPong{Value: []byte{}}, nil
 }
 
+func (r *Router) Notifications(req *net.OrchestratorRequest, stream net.Orchestrator_NotificationsServer) error {
+	// Broadcasters subscribe to the orchestrators they were routed to directly
+	return status.Error(codes.Unimplemented, "notifications are not supported by the router")
+}
+
 func checkAvailability(uri *url.URL) error {
 	client, conn, err := startOrchestratorClient(uri)
 	if err != nil {
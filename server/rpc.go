@@ -3,6 +3,7 @@ package server
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -22,7 +23,6 @@ import (
 	"github.com/livepeer/go-livepeer/pm"
 	ffmpeg "github.com/livepeer/lpms/ffmpeg"
 	"github.com/livepeer/lpms/stream"
-	"github.com/patrickmn/go-cache"
 
 	ethcommon "github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/crypto"
@@ -35,9 +35,8 @@ const GRPCConnectTimeout = 3 * time.Second
 const GRPCTimeout = 8 * time.Second
 
 var authTokenValidPeriod = 30 * time.Minute
-var discoveryAuthWebhookCacheCleanup = 5 * time.Minute
 
-var discoveryAuthWebhookCache = cache.New(authTokenValidPeriod, discoveryAuthWebhookCacheCleanup)
+var discoveryAuthWebhookCache = newTTLCache(authTokenValidPeriod)
 
 type Orchestrator interface {
 	ServiceURI() *url.URL
@@ -118,6 +117,12 @@ type ReceivedTranscodeResult struct {
 	*net.TranscodeData
 	Info         *net.OrchestratorInfo
 	LatencyScore float64
+
+	// Raw is the TranscodeResult protobuf as received, and SegCreds and
+	// Payment are the headers the segment was sent with
+	Raw      []byte
+	SegCreds string
+	Payment  string
 }
 
 type lphttp struct {
@@ -137,6 +142,10 @@ func (h *lphttp) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *lphttp) GetOrchestrator(context context.Context, req *net.OrchestratorRequest) (*net.OrchestratorInfo, error) {
+	if err := SegmentClientAuth.verifyPeer(context); err != nil {
+		glog.Errorf("Rejecting orchestrator info request err=%v", err)
+		return nil, err
+	}
 	return getOrchestrator(h.orchestrator, req)
 }
 
@@ -166,12 +175,15 @@ func StartTranscodeServer(orch Orchestrator, bind string, mux *http.ServeMux, wo
 
 	glog.Info("Listening for RPC on ", bind)
 	srv := http.Server{
-		Addr:    bind,
-		Handler: &lp,
+		Addr:      bind,
+		Handler:   TLSPolicy.hsts(&lp),
+		TLSConfig: &tls.Config{},
 		// XXX doesn't handle streaming RPC well; split remote transcoder RPC?
 		//ReadTimeout:  HTTPTimeout,
 		//WriteTimeout: HTTPTimeout,
 	}
+	SegmentClientAuth.configureTLS(srv.TLSConfig)
+	TLSPolicy.configureTLS(srv.TLSConfig)
 	srv.ListenAndServeTLS(cert, key)
 }
 
@@ -222,6 +234,9 @@ func GetOrchestratorInfo(ctx context.Context, bcast common.Broadcaster, orchestr
 	defer conn.Close()
 
 	req, err := genOrchestratorReq(bcast)
+	if req != nil {
+		req.AuthToken = sessionToken(ctx)
+	}
 	r, err := c.GetOrchestrator(ctx, req)
 	if err != nil {
 		glog.Errorf("Could not get orchestrator orch=%v err=%v", orchestratorServer, err)
@@ -231,6 +246,23 @@ func GetOrchestratorInfo(ctx context.Context, bcast common.Broadcaster, orchestr
 	return r, nil
 }
 
+type sessionTokenKey struct{}
+
+// withSessionToken returns a context for GetOrchestratorInfo to ask the
+// orchestrator to continue the session of `token` instead of starting a new
+// one, so that it keeps the transcode contexts of the session warm
+func withSessionToken(ctx context.Context, token *net.AuthToken) context.Context {
+	if token == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, sessionTokenKey{}, token)
+}
+
+func sessionToken(ctx context.Context) *net.AuthToken {
+	token, _ := ctx.Value(sessionTokenKey{}).(*net.AuthToken)
+	return token
+}
+
 func startOrchestratorClient(uri *url.URL) (net.OrchestratorClient, *grpc.ClientConn, error) {
 	glog.V(common.DEBUG).Infof("Connecting RPC to %v", uri)
 	conn, err := grpc.Dial(uri.Host,
@@ -264,8 +296,19 @@ func getOrchestrator(orch Orchestrator, req *net.OrchestratorRequest) (*net.Orch
 		return nil, fmt.Errorf("authentication failed: %v", err)
 	}
 
+	// Continue the session the broadcaster refreshes, if its token is still
+	// valid, so that its segments keep being transcoded with the same contexts
+	var sessionID string
+	if token := req.GetAuthToken(); token != nil {
+		if err := verifyAuthToken(orch, token); err != nil {
+			glog.V(common.DEBUG).Infof("Not continuing session sessionID=%s err=%v", token.SessionId, err)
+		} else {
+			sessionID = token.SessionId
+		}
+	}
+
 	// currently, orchestrator == transcoder
-	return orchestratorInfo(orch, addr, orch.ServiceURI().String())
+	return orchestratorInfo(orch, addr, orch.ServiceURI().String(), sessionID)
 }
 
 func getPriceInfo(orch Orchestrator, addr ethcommon.Address) (*net.PriceInfo, error) {
@@ -278,7 +321,9 @@ func getPriceInfo(orch Orchestrator, addr ethcommon.Address) (*net.PriceInfo, er
 	return orch.PriceInfo(addr)
 }
 
-func orchestratorInfo(orch Orchestrator, addr ethcommon.Address, serviceURI string) (*net.OrchestratorInfo, error) {
+// orchestratorInfo returns the info for `addr` to transcode with, for the
+// session `sessionID` or a new one if it is empty
+func orchestratorInfo(orch Orchestrator, addr ethcommon.Address, serviceURI string, sessionID string) (*net.OrchestratorInfo, error) {
 	priceInfo, err := getPriceInfo(orch, addr)
 	if err != nil {
 		return nil, err
@@ -290,7 +335,9 @@ func orchestratorInfo(orch Orchestrator, addr ethcommon.Address, serviceURI stri
 	}
 
 	// Generate auth token
-	sessionID := string(core.RandomManifestID())
+	if sessionID == "" {
+		sessionID = string(core.RandomManifestID())
+	}
 	expiration := time.Now().Add(authTokenValidPeriod).Unix()
 	authToken := orch.AuthToken(sessionID, expiration)
 
@@ -301,6 +348,9 @@ func orchestratorInfo(orch Orchestrator, addr ethcommon.Address, serviceURI stri
 		Address:      orch.Address().Bytes(),
 		Capabilities: orch.Capabilities(),
 		AuthToken:    authToken,
+
+		MaintenanceWindows: maintenanceWindows.upcoming(clock.Now()),
+		SegmentKey:         segmentPublicKey(authToken.SessionId),
 	}
 
 	os := drivers.NodeStorage.NewSession(authToken.SessionId)
@@ -321,6 +371,9 @@ func verifyOrchestratorReq(orch Orchestrator, addr ethcommon.Address, sig []byte
 		glog.Error("orchestrator req sig check failed")
 		return fmt.Errorf("orchestrator req sig check failed")
 	}
+	if orchNotifications.isDraining() {
+		return errOrchDraining
+	}
 	return orch.CheckCapacity("")
 }
 
@@ -366,16 +419,11 @@ func authenticateBroadcaster(id string) (*discoveryAuthWebhookRes, error) {
 }
 
 func addToDiscoveryAuthWebhookCache(id string, webhookRes *discoveryAuthWebhookRes, expiration time.Duration) {
-	_, ok := discoveryAuthWebhookCache.Get(id)
-	if ok {
-		discoveryAuthWebhookCache.Replace(id, webhookRes, authTokenValidPeriod)
-	} else {
-		discoveryAuthWebhookCache.Add(id, webhookRes, authTokenValidPeriod)
-	}
+	discoveryAuthWebhookCache.setFor(id, webhookRes, expiration)
 }
 
 func getFromDiscoveryAuthWebhookCache(id string) *discoveryAuthWebhookRes {
-	c, ok := discoveryAuthWebhookCache.Get(id)
+	c, ok := discoveryAuthWebhookCache.get(id)
 	if !ok {
 		return nil
 	}
@@ -456,5 +504,7 @@ func coreSegMetadata(segData *net.SegData) (*core.SegTranscodingMetadata, error)
 		Duration:   dur,
 		Caps:       caps,
 		AuthToken:  segData.AuthToken,
+		Metadata:   segData.Metadata,
+		SegmentKey: segData.SegmentKey,
 	}, nil
 }
@@ -1006,6 +1006,50 @@ func TestGetOrchestrator_GivenValidSig_ReturnsAuthToken(t *testing.T) {
 	assert.Equal(authToken.Expiration, oInfo.AuthToken.Expiration)
 }
 
+func TestGetOrchestrator_ContinuesSession(t *testing.T) {
+	assert := assert.New(t)
+	orch := &mockOrchestrator{}
+	drivers.NodeStorage = drivers.NewMemoryDriver(nil)
+
+	origRandomIDGenerator := common.RandomIDGenerator
+	defer func() { common.RandomIDGenerator = origRandomIDGenerator }()
+	common.RandomIDGenerator = func(length uint) string { return "fresh" }
+
+	valid := &net.AuthToken{Token: []byte("valid"), SessionId: "warm", Expiration: time.Now().Add(time.Minute).Unix()}
+	expired := &net.AuthToken{Token: []byte("expired"), SessionId: "stale", Expiration: time.Now().Add(-time.Minute).Unix()}
+	forged := &net.AuthToken{Token: []byte("forged"), SessionId: "other", Expiration: valid.Expiration}
+
+	orch.On("VerifySig", mock.Anything, mock.Anything, mock.Anything).Return(true)
+	orch.On("ServiceURI").Return(url.Parse("http://someuri.com"))
+	orch.On("Address").Return(ethcommon.Address{})
+	orch.On("TicketParams", mock.Anything, mock.Anything).Return(nil, nil)
+	orch.On("PriceInfo", mock.Anything).Return(nil, nil)
+	orch.On("AuthToken", valid.SessionId, valid.Expiration).Return(valid)
+	orch.On("AuthToken", expired.SessionId, expired.Expiration).Return(expired)
+	orch.On("AuthToken", forged.SessionId, forged.Expiration).Return(&net.AuthToken{Token: []byte("genuine")})
+	// tokens issued for the request
+	orch.On("AuthToken", valid.SessionId, mock.Anything).Return(&net.AuthToken{Token: []byte("renewed"), SessionId: valid.SessionId})
+	orch.On("AuthToken", "fresh", mock.Anything).Return(&net.AuthToken{Token: []byte("new"), SessionId: "fresh"})
+
+	// a valid token continues its session
+	oInfo, err := getOrchestrator(orch, &net.OrchestratorRequest{AuthToken: valid})
+	assert.Nil(err)
+	assert.Equal("warm", oInfo.AuthToken.SessionId)
+	assert.Equal([]byte("renewed"), oInfo.AuthToken.Token)
+
+	// an expired or forged token starts a new session
+	for _, token := range []*net.AuthToken{expired, forged} {
+		oInfo, err = getOrchestrator(orch, &net.OrchestratorRequest{AuthToken: token})
+		assert.Nil(err)
+		assert.Equal("fresh", oInfo.AuthToken.SessionId)
+	}
+
+	// so does no token
+	oInfo, err = getOrchestrator(orch, &net.OrchestratorRequest{})
+	assert.Nil(err)
+	assert.Equal("fresh", oInfo.AuthToken.SessionId)
+}
+
 func TestGetOrchestrator_StorageInit(t *testing.T) {
 	assert := assert.New(t)
 
@@ -1097,7 +1141,7 @@ func TestGetPriceInfo_Webhook_Cache_WrongType_ReturnsDefaultPrice(t *testing.T)
 
 	addr := ethcommon.HexToAddress("foo")
 
-	discoveryAuthWebhookCache.Add(addr.Hex(), 500, authTokenValidPeriod)
+	discoveryAuthWebhookCache.set(addr.Hex(), 500)
 
 	priceInfo := &net.PriceInfo{
 		PricePerUnit:  100,
@@ -0,0 +1,319 @@
+package server
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// SanitizeTimestamps makes the timestamps of RTMP source segments be repaired
+// before the segments are saved or sent to orchestrators. Broken timestamps
+// otherwise carry over into broken renditions.
+var SanitizeTimestamps bool
+
+// AVSyncDriftLimit is how far audio of a source may drift from its video
+// over the course of a stream. Beyond it the stream is reported as unhealthy,
+// or the drift is corrected if AVSyncCorrection is set.
+var AVSyncDriftLimit = 500 * time.Millisecond
+
+// AVSyncCorrection makes the timestamp sanitizer move the audio of streams
+// back in sync with the video when it drifts beyond AVSyncDriftLimit.
+// Long running sources, such as OBS on laptops, drift as the clocks of their
+// audio and video capture go apart.
+var AVSyncCorrection bool
+
+const (
+	tsTimestampMask = 1<<33 - 1
+	// frame durations assumed until there are frames to measure, and the
+	// largest interval still taken as a frame duration, in 90kHz units
+	defaultVideoFrameDur = 3000 // 30fps
+	defaultAudioFrameDur = 1920 // 1024 AAC samples at 48kHz
+	maxFrameDur          = 18000
+	// AAC encoders start audio up to this far ahead of video for priming
+	// samples: 2112 samples at 44.1kHz
+	aacPrimingMax = 4311
+)
+
+// tsSanitizer repairs the timestamps of the MPEG-TS source segments of a
+// stream in place of the encoder:
+//   - video decode timestamps that go backwards or repeat are moved past the
+//     previous frame, along with all timestamps following them
+//   - presentation timestamps before decode timestamps are set to the latter
+//   - missing presentation timestamps are filled in where the packet has room
+//   - audio that starts ahead of video for AAC priming samples is moved to
+//     start with the video, rather than at negative timestamps
+//   - audio that drifts from video by more than the drift limit, if any, is
+//     moved back to where it was relative to the video at the start
+//
+// A nil *tsSanitizer leaves segments as they are.
+type tsSanitizer struct {
+	mid string
+	// in 90kHz units; 0 to leave drift uncorrected
+	driftLimit int64
+
+	mu sync.Mutex
+	// added to all timestamps to keep video going forward
+	offset    int64
+	lastVideo int64
+	videoDur  int64
+	haveVideo bool
+	// added to audio timestamps to undo AAC priming and drift
+	audioShift int64
+	primed     bool
+	lastAudio  int64
+	audioDur   int64
+	haveAudio  bool
+	// offset of audio from video at the start of the stream
+	avOffset     int64
+	haveAVOffset bool
+}
+
+type tsSanitizeStats struct {
+	dts, pts, missing, audio int
+	priming                  bool
+	drift                    int64
+}
+
+// newTSSanitizer returns a sanitizer that corrects A/V drift beyond
+// `driftLimit`, or nil if not `enabled`
+func newTSSanitizer(mid string, enabled bool, driftLimit time.Duration) *tsSanitizer {
+	if !enabled {
+		return nil
+	}
+	return &tsSanitizer{
+		mid:        mid,
+		driftLimit: int64(driftLimit.Seconds() * 90000),
+		videoDur:   defaultVideoFrameDur,
+		audioDur:   defaultAudioFrameDur,
+	}
+}
+
+// reset forgets the timestamps of the source, such as when the publisher
+// reconnects
+func (s *tsSanitizer) reset() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.offset, s.audioShift = 0, 0
+	s.videoDur, s.audioDur = defaultVideoFrameDur, defaultAudioFrameDur
+	s.haveVideo, s.haveAudio, s.primed, s.haveAVOffset = false, false, false, false
+}
+
+// sanitize returns the MPEG-TS segment `data` with its timestamps repaired.
+// `data` itself is left untouched.
+func (s *tsSanitizer) sanitize(seqNo uint64, data []byte) []byte {
+	if s == nil {
+		return data
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := append([]byte(nil), data...)
+	var stats tsSanitizeStats
+	var videoStart int64 = -1
+	ts := parseTSTimestamps(data)
+	if len(ts.video) > 0 {
+		videoStart = ts.video[0]
+	}
+	synced := len(ts.video) > 0 && len(ts.audio) > 0
+	if synced && s.haveAVOffset && s.driftLimit > 0 {
+		if drift := tsDelta(ts.audio[0]+s.audioShift, ts.video[0]) - s.avOffset; drift > s.driftLimit || drift < -s.driftLimit {
+			// audio starts over from its new position, wherever the
+			// previous audio ended
+			s.audioShift -= drift
+			s.haveAudio = false
+			stats.drift = drift
+		}
+	}
+	pmtPID, videoPID, audioPID := -1, -1, -1
+	for off := 0; off+tsPacketSize <= len(out); off += tsPacketSize {
+		pkt := out[off : off+tsPacketSize]
+		if pkt[0] != 0x47 || pkt[1]&0x40 == 0 || pkt[3]>>4&0x1 == 0 {
+			continue
+		}
+		pid := int(pkt[1]&0x1f)<<8 | int(pkt[2])
+		start := 4
+		if pkt[3]&0x20 != 0 {
+			start += 1 + int(pkt[4])
+		}
+		if start+9 > tsPacketSize {
+			continue
+		}
+		switch pid {
+		case 0:
+			if p := parsePAT(pkt[start:]); p >= 0 {
+				pmtPID = p
+			}
+		case pmtPID:
+			videoPID, audioPID = parsePMT(pkt[start:])
+		case videoPID:
+			s.sanitizeVideo(pkt, start, &stats)
+		case audioPID:
+			s.sanitizeAudio(pkt, start, videoStart, &stats)
+		}
+	}
+	if synced && !s.haveAVOffset {
+		s.avOffset, s.haveAVOffset = tsDelta(ts.audio[0]+s.audioShift, ts.video[0]), true
+	}
+
+	if stats != (tsSanitizeStats{}) {
+		glog.Infof("Sanitized source timestamps manifestID=%s seqNo=%d dts=%d pts=%d missing=%d audio=%d priming=%v drift=%.3fs",
+			s.mid, seqNo, stats.dts, stats.pts, stats.missing, stats.audio, stats.priming, float64(stats.drift)/90000)
+	}
+	return out
+}
+
+func (s *tsSanitizer) sanitizeVideo(pkt []byte, start int, stats *tsSanitizeStats) {
+	pes := pkt[start:]
+	flags, ok := pesFlags(pes, stats)
+	if !ok {
+		return
+	}
+	if flags == 0 {
+		if s.haveVideo && insertPTS(pkt, start, (s.lastVideo+s.videoDur)&tsTimestampMask) {
+			s.lastVideo = (s.lastVideo + s.videoDur) & tsTimestampMask
+			stats.missing++
+		}
+		return
+	}
+	pts := readPESTimestamp(pes[9:14])
+	dts := pts
+	if flags == 0x3 {
+		dts = readPESTimestamp(pes[14:19])
+		if tsDelta(pts, dts) < 0 {
+			pts = dts
+			stats.pts++
+		}
+	}
+	pts, dts = pts+s.offset, dts+s.offset
+	if s.haveVideo {
+		if d := tsDelta(dts, s.lastVideo); d <= 0 {
+			shift := s.videoDur - d
+			s.offset += shift
+			pts, dts = pts+shift, dts+shift
+			stats.dts++
+		} else if d <= maxFrameDur {
+			s.videoDur = d
+		}
+	}
+	writePESTimestamp(pes[9:14], flags, pts&tsTimestampMask)
+	if flags == 0x3 {
+		writePESTimestamp(pes[14:19], 0x1, dts&tsTimestampMask)
+	}
+	s.lastVideo, s.haveVideo = dts&tsTimestampMask, true
+}
+
+func (s *tsSanitizer) sanitizeAudio(pkt []byte, start int, videoStart int64, stats *tsSanitizeStats) {
+	pes := pkt[start:]
+	flags, ok := pesFlags(pes, stats)
+	if !ok {
+		return
+	}
+	if flags == 0 {
+		if s.haveAudio && insertPTS(pkt, start, (s.lastAudio+s.audioDur)&tsTimestampMask) {
+			s.lastAudio = (s.lastAudio + s.audioDur) & tsTimestampMask
+			stats.missing++
+		}
+		return
+	}
+	pts := readPESTimestamp(pes[9:14])
+	if !s.primed && videoStart >= 0 {
+		if d := tsDelta(videoStart, pts); d > 0 && d <= aacPrimingMax {
+			s.audioShift = d
+			stats.priming = true
+		}
+	}
+	s.primed = true
+	pts += s.offset + s.audioShift
+	if s.haveAudio {
+		if d := tsDelta(pts, s.lastAudio); d <= 0 {
+			pts = s.lastAudio + s.audioDur
+			stats.audio++
+		} else if d <= maxFrameDur {
+			s.audioDur = d
+		}
+	}
+	writePESTimestamp(pes[9:14], flags, pts&tsTimestampMask)
+	if flags == 0x3 {
+		// audio is presented as it is decoded
+		writePESTimestamp(pes[14:19], 0x1, pts&tsTimestampMask)
+	}
+	s.lastAudio, s.haveAudio = pts&tsTimestampMask, true
+}
+
+// pesFlags returns the PTS_DTS_flags of the PES packet header `pes`, turning
+// a DTS without a PTS, which is not allowed, into a PTS
+func pesFlags(pes []byte, stats *tsSanitizeStats) (byte, bool) {
+	if len(pes) < 9 || pes[0] != 0 || pes[1] != 0 || pes[2] != 1 {
+		return 0, false
+	}
+	flags := pes[7] >> 6
+	switch {
+	case flags == 0x1 && len(pes) >= 14:
+		pes[7] = pes[7]&0x3f | 0x2<<6
+		pes[9] = pes[9]&0x0f | 0x2<<4
+		stats.pts++
+		return 0x2, true
+	case flags == 0x2 && len(pes) >= 14, flags == 0x3 && len(pes) >= 19, flags == 0:
+		return flags, true
+	}
+	return 0, false
+}
+
+// insertPTS adds the PTS `ts` to the PES packet without timestamps starting
+// at pkt[start:]. Packets are not resized, so this uses stuffing of either
+// the PES header or the adaptation field for room and returns false if there
+// is none.
+func insertPTS(pkt []byte, start int, ts int64) bool {
+	pes := pkt[start:]
+	// without any optional fields the header data is all stuffing
+	if pes[7]&0x3f == 0 && pes[8] >= 5 && len(pes) >= 14 {
+		pes[7] |= 0x2 << 6
+		writePESTimestamp(pes[9:14], 0x2, ts)
+		return true
+	}
+	if pkt[3]&0x20 == 0 || pes[8] > 250 {
+		return false
+	}
+	afLen := int(pkt[4])
+	stuffing := 0
+	for i := 4 + afLen; i > 5 && pkt[i] == 0xff; i-- {
+		stuffing++
+	}
+	if stuffing < 5 {
+		return false
+	}
+	// shrink the adaptation field and move the fixed part of the PES header
+	// into the room freed up, right in front of the new PTS
+	pkt[4] = byte(afLen - 5)
+	copy(pkt[start-5:start+4], pkt[start:start+9])
+	pes = pkt[start-5:]
+	pes[7] |= 0x2 << 6
+	pes[8] += 5
+	if l := int(pes[4])<<8 | int(pes[5]); l > 0 {
+		l += 5
+		pes[4], pes[5] = byte(l>>8), byte(l)
+	}
+	writePESTimestamp(pes[9:14], 0x2, ts)
+	return true
+}
+
+// tsDelta is a - b for 33 bit timestamps that may have wrapped around
+func tsDelta(a, b int64) int64 {
+	d := (a - b) & tsTimestampMask
+	if d > 1<<32 {
+		d -= 1 << 33
+	}
+	return d
+}
+
+func writePESTimestamp(b []byte, prefix byte, ts int64) {
+	b[0] = prefix<<4 | byte(ts>>30&0x07)<<1 | 1
+	b[1] = byte(ts >> 22)
+	b[2] = byte(ts>>15&0x7f)<<1 | 1
+	b[3] = byte(ts >> 7)
+	b[4] = byte(ts&0x7f)<<1 | 1
+}
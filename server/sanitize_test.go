@@ -0,0 +1,138 @@
+package server
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// tsTestPTS is the PTS of the PES packet starting in the `i`th TS packet
+func tsTestPTS(data []byte, i int) int64 {
+	ts, _ := pesTimestamp(data[i*tsPacketSize+4:], false)
+	return ts
+}
+
+func TestTSSanitizer_NonMonotonic(t *testing.T) {
+	assert := assert.New(t)
+
+	s := newTSSanitizer("mid", true, 0)
+	in := tsTestSegment(tsTestFrames(90000, 3000, 3000), []int64{90000})
+	out := s.sanitize(0, in)
+	assert.Equal(in, out, "good timestamps are left as they are")
+
+	// timestamps starting over are moved past the previous segment, audio
+	// included
+	in = tsTestSegment(tsTestFrames(0, 3000, 3000), []int64{0})
+	orig := append([]byte(nil), in...)
+	out = s.sanitize(1, in)
+	assert.Equal(orig, in, "input is not modified")
+	ts := parseTSTimestamps(out)
+	assert.Equal([]int64{99000, 102000, 105000}, ts.video)
+	assert.Equal([]int64{99000}, ts.audio)
+	assert.Equal(int64(102000), tsTestPTS(out, 2))
+
+	// and stay moved
+	out = s.sanitize(2, tsTestSegment(tsTestFrames(9000, 3000), nil))
+	assert.Equal([]int64{108000, 111000}, parseTSTimestamps(out).video)
+
+	// repeated timestamps within a segment
+	out = s.sanitize(3, tsTestSegment([]int64{12000, 12000, 15000}, nil))
+	assert.Equal([]int64{114000, 117000, 120000}, parseTSTimestamps(out).video)
+
+	// timestamps start over after a reconnect
+	s.reset()
+	out = s.sanitize(4, tsTestSegment(tsTestFrames(0, 3000), nil))
+	assert.Equal([]int64{0, 3000}, parseTSTimestamps(out).video)
+}
+
+func TestTSSanitizer_PTS(t *testing.T) {
+	assert := assert.New(t)
+	s := newTSSanitizer("mid", true, 0)
+
+	// presentation before decode
+	in := append(tsTestPSI(), tsTestVideo(3000, 6000)...)
+	out := s.sanitize(0, in)
+	assert.Equal(int64(6000), tsTestPTS(out, 2))
+	assert.Equal([]int64{6000}, parseTSTimestamps(out).video)
+
+	// DTS without PTS
+	dtsOnly := []byte{0x00, 0x00, 0x01, 0xe0, 0x00, 0x00, 0x80, 0x40, 5}
+	dtsOnly = append(dtsOnly, tsTestTimestamp(0x1, 9000)...)
+	out = s.sanitize(1, append(tsTestPSI(), tsTestPacket(0x101, dtsOnly)...))
+	assert.Equal(int64(9000), tsTestPTS(out, 2))
+
+	// no timestamps, with room in the PES header
+	noTS := []byte{0x00, 0x00, 0x01, 0xe0, 0x00, 0x00, 0x80, 0x00, 5, 0xff, 0xff, 0xff, 0xff, 0xff, 0xab}
+	out = s.sanitize(2, append(tsTestPSI(), tsTestPacket(0x101, noTS)...))
+	assert.Equal(int64(12000), tsTestPTS(out, 2))
+	assert.Equal(byte(0xab), out[2*tsPacketSize+4+14])
+
+	// no timestamps, with room in the adaptation field
+	pkt := []byte{0x47, 0x41, 0x01, 0x30, 10, 0x00, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff,
+		0x00, 0x00, 0x01, 0xe0, 0x00, 0x10, 0x80, 0x00, 0x00, 0xab}
+	for len(pkt) < tsPacketSize {
+		pkt = append(pkt, 0xcd)
+	}
+	out = s.sanitize(3, append(tsTestPSI(), pkt...))
+	assert.Equal([]int64{15000}, parseTSTimestamps(out).video)
+	pkt = out[2*tsPacketSize:]
+	assert.Equal(byte(5), pkt[4])
+	assert.Equal([]byte{0x00, 0x15}, pkt[14:16], "PES packet length")
+	assert.Equal(byte(0xab), pkt[24])
+	assert.Equal(bytes.Repeat([]byte{0xcd}, tsPacketSize-25), []byte(pkt[25:]))
+
+	// no timestamps and no room
+	pkt = tsTestPacket(0x101, []byte{0x00, 0x00, 0x01, 0xe0, 0x00, 0x00, 0x80, 0x00, 0x00, 0xab})
+	in = append(tsTestPSI(), pkt...)
+	assert.Equal(in, s.sanitize(4, in))
+}
+
+func TestTSSanitizer_AACPriming(t *testing.T) {
+	assert := assert.New(t)
+
+	// audio ahead of video by priming samples starts with the video
+	s := newTSSanitizer("mid", true, 0)
+	out := s.sanitize(0, tsTestSegment(tsTestFrames(0, 3000), []int64{-2048 & tsTimestampMask, -128 & tsTimestampMask, 1792}))
+	assert.Equal([]int64{0, 1920, 3840}, parseTSTimestamps(out).audio)
+	out = s.sanitize(1, tsTestSegment(tsTestFrames(6000), []int64{3712}))
+	assert.Equal([]int64{5760}, parseTSTimestamps(out).audio)
+
+	// audio well ahead of video is left alone
+	s = newTSSanitizer("mid", true, 0)
+	out = s.sanitize(0, tsTestSegment(tsTestFrames(90000), []int64{45000}))
+	assert.Equal([]int64{45000}, parseTSTimestamps(out).audio)
+}
+
+func TestTSSanitizer_AVDrift(t *testing.T) {
+	assert := assert.New(t)
+	s := newTSSanitizer("mid", true, 500*time.Millisecond)
+
+	out := s.sanitize(0, tsTestSegment(tsTestFrames(0, 3000), []int64{0}))
+	assert.Equal([]int64{0}, parseTSTimestamps(out).audio)
+
+	// drift within the limit is left alone
+	out = s.sanitize(1, tsTestSegment(tsTestFrames(180000, 3000), []int64{210000}))
+	assert.Equal([]int64{210000}, parseTSTimestamps(out).audio)
+
+	// beyond it audio is moved back in sync
+	out = s.sanitize(2, tsTestSegment(tsTestFrames(360000, 3000), []int64{414000, 415920}))
+	assert.Equal([]int64{360000, 361920}, parseTSTimestamps(out).audio)
+	out = s.sanitize(3, tsTestSegment(tsTestFrames(540000, 3000), []int64{594000}))
+	assert.Equal([]int64{540000}, parseTSTimestamps(out).audio)
+
+	// drift is left alone without a limit
+	s = newTSSanitizer("mid", true, 0)
+	s.sanitize(0, tsTestSegment(tsTestFrames(0, 3000), []int64{0}))
+	out = s.sanitize(1, tsTestSegment(tsTestFrames(360000, 3000), []int64{414000}))
+	assert.Equal([]int64{414000}, parseTSTimestamps(out).audio)
+}
+
+func TestTSSanitizer_Disabled(t *testing.T) {
+	s := newTSSanitizer("mid", false, 0)
+	assert.Nil(t, s)
+	in := tsTestSegment(tsTestFrames(3000, -3000), nil)
+	assert.Same(t, &in[0], &s.sanitize(0, in)[0])
+	s.reset()
+}
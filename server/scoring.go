@@ -0,0 +1,174 @@
+package server
+
+import (
+	"math"
+	"sync"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/core"
+)
+
+// SelectionWeights are the weights orchestrators are scored by when selected
+// for streams that don't set their own. The zero value keeps the stake
+// weighted random selection.
+var SelectionWeights core.SelectionWeights
+
+// weight of the latest sample in the averages of orchestrator performance
+const perfSmoothing = 0.2
+
+// orchPerf tracks the recent performance of the orchestrators used by the
+// streams of the node
+var orchPerf = newOrchPerformance()
+
+type orchPerformance struct {
+	mu sync.Mutex
+	// by orchestrator service URI
+	orchs map[string]*orchPerfStats
+}
+
+type orchPerfStats struct {
+	// moving averages of the latency scores of segments and of the share
+	// of them that failed
+	latency   float64
+	errorRate float64
+	sampled   bool
+}
+
+func newOrchPerformance() *orchPerformance {
+	return &orchPerformance{orchs: make(map[string]*orchPerfStats)}
+}
+
+// success records that the orchestrator `orch` transcoded a segment with
+// the latency score `latency`
+func (p *orchPerformance) success(orch string, latency float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.stats(orch)
+	if !s.sampled {
+		s.latency = latency
+		s.sampled = true
+	} else {
+		s.latency += perfSmoothing * (latency - s.latency)
+	}
+	s.errorRate -= perfSmoothing * s.errorRate
+}
+
+// failure records that the orchestrator `orch` failed a segment
+func (p *orchPerformance) failure(orch string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.stats(orch)
+	s.errorRate += perfSmoothing * (1 - s.errorRate)
+}
+
+// get returns the recent performance of the orchestrator `orch`; a latency
+// of 0 if it has not transcoded any segment yet
+func (p *orchPerformance) get(orch string) (latency, errorRate float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if s, ok := p.orchs[orch]; ok {
+		return s.latency, s.errorRate
+	}
+	return 0, 0
+}
+
+func (p *orchPerformance) stats(orch string) *orchPerfStats {
+	s, ok := p.orchs[orch]
+	if !ok {
+		s = &orchPerfStats{}
+		p.orchs[orch] = s
+	}
+	return s
+}
+
+// SessionScore is the score of an orchestrator at its last selection, along
+// with the components the weights apply to, each between 0 and 1
+type SessionScore struct {
+	Orchestrator string  `json:"orchestrator"`
+	Stake        float64 `json:"stake"`
+	Price        float64 `json:"price"`
+	Latency      float64 `json:"latency"`
+	Errors       float64 `json:"errors"`
+	Score        float64 `json:"score"`
+}
+
+// scoreSessions scores `sessions` by the weights `w`:
+//   - stake is that of the orchestrator over the largest one of `stakes`
+//   - price is the lowest price over that of the orchestrator
+//   - latency is 1/(1+L) for the average latency score L of the orchestrator,
+//     so that one returning segments as fast as they play scores 0.5, as do
+//     orchestrators that haven't returned any yet
+//   - errors is 1 minus the share of segments the orchestrator failed lately
+func scoreSessions(w core.SelectionWeights, sessions []*BroadcastSession, stakes map[ethcommon.Address]int64) []SessionScore {
+	var maxStake int64
+	for _, stake := range stakes {
+		if stake > maxStake {
+			maxStake = stake
+		}
+	}
+	prices := make([]float64, len(sessions))
+	minPrice := math.Inf(1)
+	for i, sess := range sessions {
+		if pi := sess.OrchestratorInfo.GetPriceInfo(); pi != nil && pi.PixelsPerUnit > 0 {
+			prices[i] = float64(pi.PricePerUnit) / float64(pi.PixelsPerUnit)
+		}
+		minPrice = math.Min(minPrice, prices[i])
+	}
+
+	scores := make([]SessionScore, len(sessions))
+	for i, sess := range sessions {
+		orch := sess.OrchestratorInfo.GetTranscoder()
+		s := SessionScore{Orchestrator: orch, Price: 1}
+		if maxStake > 0 {
+			addr := ethcommon.BytesToAddress(sess.OrchestratorInfo.GetTicketParams().GetRecipient())
+			s.Stake = float64(stakes[addr]) / float64(maxStake)
+		}
+		if prices[i] > 0 {
+			s.Price = minPrice / prices[i]
+		}
+		latency, errorRate := orchPerf.get(orch)
+		if latency == 0 {
+			latency = 1
+		}
+		s.Latency = 1 / (1 + latency)
+		s.Errors = 1 - errorRate
+		s.Score = w.Stake*s.Stake + w.Price*s.Price + w.Latency*s.Latency + w.Errors*s.Errors
+		scores[i] = s
+	}
+	return scores
+}
+
+// selectScoredSession selects the session of unknownSessions with the
+// highest score by the weights of the selector
+func (s *MinLSSelector) selectScoredSession() *BroadcastSession {
+	var stakes map[ethcommon.Address]int64
+	if s.stakeRdr != nil {
+		var addrs []ethcommon.Address
+		for _, sess := range s.unknownSessions {
+			addrs = append(addrs, ethcommon.BytesToAddress(sess.OrchestratorInfo.GetTicketParams().GetRecipient()))
+		}
+		var err error
+		if stakes, err = s.stakeRdr.Stakes(addrs); err != nil {
+			// score the sessions by the other weights
+			glog.Errorf("failed to read stake weights for selection: %v", err)
+		}
+	}
+
+	s.scores = scoreSessions(s.weights, s.unknownSessions, stakes)
+	best := 0
+	for i, score := range s.scores {
+		if score.Score > s.scores[best].Score {
+			best = i
+		}
+	}
+	sess := s.unknownSessions[best]
+	s.removeUnknownSession(best)
+	return sess
+}
+
+// Scores returns the scores of the sessions at the last selection among
+// those without a latency score yet; nil if the selector doesn't score them
+func (s *MinLSSelector) Scores() []SessionScore {
+	return s.scores
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"errors"
+	"testing"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/net"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func scoringTestSession(orch string, stakeAddr byte, price int64) *BroadcastSession {
+	return &BroadcastSession{
+		OrchestratorInfo: &net.OrchestratorInfo{
+			Transcoder:   orch,
+			TicketParams: &net.TicketParams{Recipient: ethcommon.BytesToAddress([]byte{stakeAddr}).Bytes()},
+			PriceInfo:    &net.PriceInfo{PricePerUnit: price, PixelsPerUnit: 1},
+		},
+	}
+}
+
+func TestOrchPerformance(t *testing.T) {
+	assert := assert.New(t)
+
+	p := newOrchPerformance()
+	latency, errorRate := p.get("a")
+	assert.Zero(latency)
+	assert.Zero(errorRate)
+
+	p.success("a", 2)
+	latency, errorRate = p.get("a")
+	assert.Equal(2.0, latency)
+	assert.Zero(errorRate)
+	p.success("a", 1)
+	latency, _ = p.get("a")
+	assert.InDelta(1.8, latency, 1e-9)
+
+	p.failure("a")
+	_, errorRate = p.get("a")
+	assert.InDelta(perfSmoothing, errorRate, 1e-9)
+	p.success("a", 1)
+	_, errorRate = p.get("a")
+	assert.InDelta(perfSmoothing*(1-perfSmoothing), errorRate, 1e-9)
+}
+
+func TestScoreSessions(t *testing.T) {
+	assert := assert.New(t)
+
+	oldPerf := orchPerf
+	defer func() { orchPerf = oldPerf }()
+	orchPerf = newOrchPerformance()
+	orchPerf.success("fast", 0.5)
+	orchPerf.failure("flaky")
+
+	sessions := []*BroadcastSession{
+		scoringTestSession("fast", 1, 4),
+		scoringTestSession("flaky", 2, 2),
+		scoringTestSession("new", 3, 0),
+	}
+	stakes := map[ethcommon.Address]int64{ethcommon.BytesToAddress([]byte{1}): 100, ethcommon.BytesToAddress([]byte{2}): 50}
+
+	scores := scoreSessions(core.SelectionWeights{Stake: 1, Price: 1, Latency: 1, Errors: 1}, sessions, stakes)
+	require.Len(t, scores, 3)
+	assert.Equal("fast", scores[0].Orchestrator)
+	assert.Equal(1.0, scores[0].Stake)
+	assert.Equal(0.5, scores[1].Stake)
+	assert.Zero(scores[2].Stake)
+	// free orchestrators are the cheapest
+	assert.Zero(scores[0].Price)
+	assert.Zero(scores[1].Price)
+	assert.Equal(1.0, scores[2].Price)
+	assert.InDelta(1/1.5, scores[0].Latency, 1e-9)
+	assert.Equal(0.5, scores[1].Latency)
+	assert.Equal(0.5, scores[2].Latency)
+	assert.Equal(1.0, scores[0].Errors)
+	assert.InDelta(1-perfSmoothing, scores[1].Errors, 1e-9)
+	for _, s := range scores {
+		assert.InDelta(s.Stake+s.Price+s.Latency+s.Errors, s.Score, 1e-9)
+	}
+
+	// without stakes
+	scores = scoreSessions(core.SelectionWeights{Price: 1}, sessions[:2], nil)
+	assert.Zero(scores[0].Stake)
+	assert.Equal(0.5, scores[0].Score)
+	assert.Equal(1.0, scores[1].Score)
+}
+
+func TestMinLSSelector_SelectScoredSession(t *testing.T) {
+	assert := assert.New(t)
+
+	oldPerf := orchPerf
+	defer func() { orchPerf = oldPerf }()
+	orchPerf = newOrchPerformance()
+
+	stakeRdr := newStubStakeReader()
+	stakeRdr.SetStakes(map[ethcommon.Address]int64{ethcommon.BytesToAddress([]byte{1}): 100, ethcommon.BytesToAddress([]byte{2}): 10})
+	sel := NewMinLSSelectorWithWeights(stakeRdr, 1.0, core.SelectionWeights{Stake: 1, Price: 2})
+	assert.Nil(sel.Scores())
+
+	sel.Add([]*BroadcastSession{scoringTestSession("a", 1, 4), scoringTestSession("b", 2, 1)})
+	// b is a tenth of the stake of a for a quarter of its price
+	sess := sel.Select()
+	assert.Equal("b", sess.OrchestratorInfo.Transcoder)
+	scores := sel.Scores()
+	assert.Len(scores, 2)
+	assert.Equal("a", scores[0].Orchestrator)
+	assert.Equal(1.5, scores[0].Score)
+	assert.InDelta(2.1, scores[1].Score, 1e-9)
+	assert.Equal("a", sel.Select().OrchestratorInfo.Transcoder)
+	assert.Nil(sel.Select())
+
+	// sessions are still scored by the other weights without stakes
+	stakeRdr.err = errors.New("Stakes error")
+	sel.Add([]*BroadcastSession{scoringTestSession("a", 1, 4), scoringTestSession("b", 2, 1)})
+	assert.Equal("b", sel.Select().OrchestratorInfo.Transcoder)
+
+	sel.Clear()
+	assert.Nil(sel.Scores())
+}
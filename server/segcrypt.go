@@ -0,0 +1,130 @@
+package server
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/lpms/stream"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// SegmentEncryption, if set, makes the broadcaster encrypt the segments it
+// submits to orchestrators end to end, so that proxies or CDNs between them
+// can't read the content wherever TLS is terminated. The key is agreed with
+// each orchestrator session through X25519; orchestrators that don't
+// advertise a session key are not selected.
+var SegmentEncryption bool
+
+const segmentKeySize = 32
+
+var errSegmentKey = errors.New("invalid segment key")
+var errNoSegmentKey = newError(ErrorCategoryTranscode, true, "orchestrator does not support segment encryption")
+
+// secret the orchestrator derives the private keys of its sessions from, so
+// that they don't have to be kept around
+var segmentKeySecret = common.RandomBytesGenerator(32)
+
+// broadcaster key pair, generated on first use
+var bcastSegmentKey struct {
+	once      sync.Once
+	priv, pub [segmentKeySize]byte
+}
+
+func broadcasterSegmentKey() (priv, pub *[segmentKeySize]byte) {
+	k := &bcastSegmentKey
+	k.once.Do(func() {
+		if _, err := io.ReadFull(rand.Reader, k.priv[:]); err != nil {
+			panic(err)
+		}
+		curve25519.ScalarBaseMult(&k.pub, &k.priv)
+	})
+	return &k.priv, &k.pub
+}
+
+// sessionSegmentKey returns the key pair of the orchestrator session
+// `sessionID`
+func sessionSegmentKey(sessionID string) (priv, pub *[segmentKeySize]byte) {
+	priv, pub = new([segmentKeySize]byte), new([segmentKeySize]byte)
+	h := hmac.New(sha256.New, segmentKeySecret)
+	h.Write([]byte(sessionID))
+	copy(priv[:], h.Sum(nil))
+	curve25519.ScalarBaseMult(pub, priv)
+	return priv, pub
+}
+
+// segmentPublicKey returns the public key the orchestrator advertises for
+// the session `sessionID`
+func segmentPublicKey(sessionID string) []byte {
+	_, pub := sessionSegmentKey(sessionID)
+	return pub[:]
+}
+
+// segmentCipher returns the cipher agreed between `priv` and the peer's
+// public key
+func segmentCipher(priv *[segmentKeySize]byte, peer []byte) (cipher.AEAD, error) {
+	if len(peer) != segmentKeySize {
+		return nil, errSegmentKey
+	}
+	var peerPub, shared [segmentKeySize]byte
+	copy(peerPub[:], peer)
+	curve25519.ScalarMult(&shared, priv, &peerPub)
+	// Reject low order points, which yield a key known to anyone
+	var zero [segmentKeySize]byte
+	if subtle.ConstantTimeCompare(shared[:], zero[:]) == 1 {
+		return nil, errSegmentKey
+	}
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared[:], nil, []byte("livepeer segment encryption")), key); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptsSegment returns whether `seg` is sent to `sess` encrypted. Segments
+// uploaded to object storage are sent as URIs, which are not.
+func encryptsSegment(sess *BroadcastSession, seg *stream.HLSSegment) bool {
+	return SegmentEncryption && seg.Name == "" && len(sess.OrchestratorInfo.GetSegmentKey()) > 0
+}
+
+// encryptSegment encrypts `data` to the session key of the orchestrator,
+// prefixed with the nonce
+func encryptSegment(sess *BroadcastSession, data []byte) ([]byte, error) {
+	priv, _ := broadcasterSegmentKey()
+	aead, err := segmentCipher(priv, sess.OrchestratorInfo.GetSegmentKey())
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, data, nil), nil
+}
+
+// decryptSegment decrypts the data of a segment of the session `sessionID`
+// that the broadcaster encrypted with its key `bcastKey`
+func decryptSegment(sessionID string, bcastKey []byte, data []byte) ([]byte, error) {
+	priv, _ := sessionSegmentKey(sessionID)
+	aead, err := segmentCipher(priv, bcastKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errSegmentKey
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	return aead.Open(ciphertext[:0], nonce, ciphertext, nil)
+}
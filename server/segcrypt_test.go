@@ -0,0 +1,208 @@
+package server
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"testing"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/golang/protobuf/proto"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/drivers"
+	"github.com/livepeer/go-livepeer/net"
+	"github.com/livepeer/lpms/ffmpeg"
+	"github.com/livepeer/lpms/stream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func useSegmentEncryption(t *testing.T) {
+	SegmentEncryption = true
+	t.Cleanup(func() { SegmentEncryption = false })
+}
+
+func TestSegmentEncryption_RoundTrip(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	sess := &BroadcastSession{OrchestratorInfo: &net.OrchestratorInfo{SegmentKey: segmentPublicKey("sess")}}
+	_, bcastKey := broadcasterSegmentKey()
+	data := []byte("segment data")
+
+	enc, err := encryptSegment(sess, data)
+	require.Nil(err)
+	assert.NotContains(string(enc), string(data))
+	dec, err := decryptSegment("sess", bcastKey[:], enc)
+	require.Nil(err)
+	assert.Equal(data, dec)
+
+	// a fresh nonce is used for each segment
+	enc2, err := encryptSegment(sess, data)
+	require.Nil(err)
+	assert.NotEqual(enc, enc2)
+
+	// only the orchestrator session the key was advertised for can decrypt
+	_, err = decryptSegment("other", bcastKey[:], enc)
+	assert.Error(err)
+	_, err = decryptSegment("sess", segmentPublicKey("other"), enc)
+	assert.Error(err)
+
+	// tampered or truncated data is rejected
+	enc[len(enc)-1] ^= 1
+	_, err = decryptSegment("sess", bcastKey[:], enc)
+	assert.Error(err)
+	_, err = decryptSegment("sess", bcastKey[:], enc[:4])
+	assert.Equal(errSegmentKey, err)
+}
+
+func TestSegmentCipher_InvalidKey(t *testing.T) {
+	assert := assert.New(t)
+	priv, _ := sessionSegmentKey("sess")
+
+	_, err := segmentCipher(priv, []byte("short"))
+	assert.Equal(errSegmentKey, err)
+
+	// low order points would make the key known to anyone
+	_, err = segmentCipher(priv, make([]byte, segmentKeySize))
+	assert.Equal(errSegmentKey, err)
+
+	// keys of a session are stable
+	assert.Equal(segmentPublicKey("sess"), segmentPublicKey("sess"))
+	assert.NotEqual(segmentPublicKey("sess"), segmentPublicKey("other"))
+}
+
+func TestGenSegCreds_SegmentKey(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	orch := &stubOrchestrator{offchain: true, authToken: stubAuthToken}
+	sess := StubBroadcastSession("transcoder")
+	sess.Params.Profiles = []ffmpeg.VideoProfile{ffmpeg.P144p30fps16x9}
+	seg := &stream.HLSSegment{Data: []byte("foo")}
+	_, bcastKey := broadcasterSegmentKey()
+
+	// not encrypted unless enabled
+	sess.OrchestratorInfo.SegmentKey = segmentPublicKey(stubAuthToken.SessionId)
+	creds, err := genSegCreds(sess, seg)
+	require.Nil(err)
+	md, err := verifySegCreds(orch, creds, ethcommon.Address{})
+	require.Nil(err)
+	assert.Nil(md.SegmentKey)
+
+	useSegmentEncryption(t)
+	creds, err = genSegCreds(sess, seg)
+	require.Nil(err)
+	md, err = verifySegCreds(orch, creds, ethcommon.Address{})
+	require.Nil(err)
+	assert.Equal(bcastKey[:], md.SegmentKey)
+
+	// segments uploaded to object storage are sent as URIs in the clear
+	creds, err = genSegCreds(sess, &stream.HLSSegment{Data: []byte("foo"), Name: "https://store.example/foo.ts"})
+	require.Nil(err)
+	md, err = verifySegCreds(orch, creds, ethcommon.Address{})
+	require.Nil(err)
+	assert.Nil(md.SegmentKey)
+
+	// orchestrators without a session key are sent nothing
+	sess.OrchestratorInfo.SegmentKey = nil
+	_, err = SubmitSegment(sess, seg, 0)
+	assert.Equal(errNoSegmentKey, err)
+}
+
+func TestServeSegment_Encrypted(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	useSegmentEncryption(t)
+
+	orch := &mockOrchestrator{}
+	handler := serveSegmentHandler(orch)
+
+	orch.On("VerifySig", mock.Anything, mock.Anything, mock.Anything).Return(true)
+	orch.On("AuthToken", mock.Anything, mock.Anything).Return(stubAuthToken)
+
+	s := &BroadcastSession{
+		Broadcaster: stubBroadcaster2(),
+		Params: &core.StreamParameters{
+			ManifestID: core.RandomManifestID(),
+			Profiles:   []ffmpeg.VideoProfile{ffmpeg.P720p60fps16x9},
+		},
+		OrchestratorInfo: &net.OrchestratorInfo{
+			AuthToken:  stubAuthToken,
+			SegmentKey: segmentPublicKey(stubAuthToken.SessionId),
+		},
+	}
+	seg := &stream.HLSSegment{Data: []byte("foo")}
+	creds, err := genSegCreds(s, seg)
+	require.Nil(err)
+	body, err := encryptSegment(s, seg.Data)
+	require.Nil(err)
+
+	drivers.NodeStorage = drivers.NewMemoryDriver(nil)
+	url, _ := url.Parse("foo")
+	orch.On("ServiceURI").Return(url)
+	orch.On("Address").Return(ethcommon.Address{})
+	orch.On("PriceInfo", mock.Anything).Return(&net.PriceInfo{}, nil)
+	orch.On("TicketParams", mock.Anything, mock.Anything).Return(&net.TicketParams{}, nil)
+	orch.On("ProcessPayment", net.Payment{}, core.ManifestID(s.OrchestratorInfo.AuthToken.SessionId)).Return(nil)
+	orch.On("SufficientBalance", mock.Anything, core.ManifestID(s.OrchestratorInfo.AuthToken.SessionId)).Return(true)
+
+	// the orchestrator transcodes the decrypted segment
+	tRes := &core.TranscodeResult{TranscodeData: &core.TranscodeData{}, Sig: []byte("foo")}
+	orch.On("TranscodeSeg", mock.MatchedBy(func(md *core.SegTranscodingMetadata) bool {
+		return md.SegmentKey == nil
+	}), mock.MatchedBy(func(seg *stream.HLSSegment) bool {
+		return bytes.Equal(seg.Data, []byte("foo"))
+	})).Return(tRes, nil).Once()
+	orch.On("DebitFees", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	headers := map[string]string{
+		paymentHeader: "",
+		segmentHeader: creds,
+	}
+	resp := httpPostResp(handler, bytes.NewReader(body), headers)
+	defer resp.Body.Close()
+	require.Equal(http.StatusOK, resp.StatusCode)
+	data, err := ioutil.ReadAll(resp.Body)
+	require.Nil(err)
+	var tr net.TranscodeResult
+	require.Nil(proto.Unmarshal(data, &tr))
+	_, ok := tr.Result.(*net.TranscodeResult_Data)
+	assert.True(ok)
+	// the key of the session carries on in the refreshed info
+	assert.Equal(segmentPublicKey(stubAuthToken.SessionId), tr.Info.SegmentKey)
+	orch.AssertExpectations(t)
+
+	// data that doesn't decrypt is rejected
+	body[len(body)-1] ^= 1
+	resp = httpPostResp(handler, bytes.NewReader(body), headers)
+	defer resp.Body.Close()
+	assert.Equal(http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestSelectOrchestrator_SegmentEncryption(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	s := setupServer()
+	defer serverCleanup(s)
+	defer func() { s.LivepeerNode.OrchestratorPool = nil }()
+
+	s.LivepeerNode.OrchestratorPool = &stubDiscovery{infos: []*net.OrchestratorInfo{
+		{Transcoder: "transcoder1", PriceInfo: &net.PriceInfo{PricePerUnit: 1, PixelsPerUnit: 1}, AuthToken: stubAuthToken},
+		{Transcoder: "transcoder2", PriceInfo: &net.PriceInfo{PricePerUnit: 1, PixelsPerUnit: 1}, AuthToken: stubAuthToken, SegmentKey: segmentPublicKey("sess")},
+	}}
+	sp := &core.StreamParameters{ManifestID: core.RandomManifestID()}
+
+	sess, err := selectOrchestrator(s.LivepeerNode, sp, 2, newSuspender())
+	require.Nil(err)
+	assert.Len(sess, 2)
+
+	// orchestrators without support for encryption aren't selected
+	useSegmentEncryption(t)
+	sess, err = selectOrchestrator(s.LivepeerNode, sp, 2, newSuspender())
+	require.Nil(err)
+	require.Len(sess, 1)
+	assert.Equal("transcoder2", sess[0].OrchestratorInfo.Transcoder)
+}
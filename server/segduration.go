@@ -0,0 +1,183 @@
+package server
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/lpms/ffmpeg"
+)
+
+// DefaultSegmentDuration is the duration given to pushed segments that have
+// no Content-Duration header and whose duration can not be probed either
+var DefaultSegmentDuration = 2 * time.Second
+
+// probeSegmentDuration reads the duration of the segment `data` of format
+// `format` from its container, without decoding it
+func probeSegmentDuration(data []byte, format ffmpeg.Format) (time.Duration, bool) {
+	switch format {
+	case ffmpeg.FormatMPEGTS:
+		ts := parseTSTimestamps(data)
+		// the video is timed by frame, so it is the more precise
+		if d, ok := timestampsDuration(ts.video); ok {
+			return d, true
+		}
+		return timestampsDuration(ts.audio)
+	case ffmpeg.FormatMP4:
+		return mp4Duration(data)
+	}
+	return 0, false
+}
+
+// timestampsDuration is the time from the first to the last of the 90kHz
+// timestamps `ts` of a stream, plus the usual interval between them for the
+// last frame
+func timestampsDuration(ts []int64) (time.Duration, bool) {
+	if len(ts) < 2 {
+		return 0, false
+	}
+	deltas := make([]int64, 0, len(ts)-1)
+	var span int64
+	for i := 1; i < len(ts); i++ {
+		d := ts[i] - ts[i-1]
+		// 33 bit timestamps wrap around about every 26 hours
+		if d < -(1 << 32) {
+			d += 1 << 33
+		}
+		if d <= 0 {
+			continue
+		}
+		deltas = append(deltas, d)
+		span += d
+	}
+	if len(deltas) == 0 {
+		return 0, false
+	}
+	sort.Slice(deltas, func(i, j int) bool { return deltas[i] < deltas[j] })
+	span += deltas[len(deltas)/2]
+	return time.Duration(span) * time.Second / 90000, true
+}
+
+// mp4Duration reads the duration of an MP4 file from its movie header, or
+// from its movie extends header for fragmented files
+func mp4Duration(data []byte) (time.Duration, bool) {
+	moov, ok := mp4Box(data, "moov")
+	if !ok {
+		return 0, false
+	}
+	mvhd, ok := mp4Box(moov, "mvhd")
+	if !ok || len(mvhd) < 4 {
+		return 0, false
+	}
+	var timescale uint32
+	var duration uint64
+	if mvhd[0] == 1 {
+		// version, flags, 64 bit creation and modification times
+		if len(mvhd) < 32 {
+			return 0, false
+		}
+		timescale = binary.BigEndian.Uint32(mvhd[20:])
+		duration = binary.BigEndian.Uint64(mvhd[24:])
+	} else {
+		if len(mvhd) < 20 {
+			return 0, false
+		}
+		timescale = binary.BigEndian.Uint32(mvhd[12:])
+		duration = uint64(binary.BigEndian.Uint32(mvhd[16:]))
+	}
+	if duration == 0 {
+		if mvex, ok := mp4Box(moov, "mvex"); ok {
+			if mehd, ok := mp4Box(mvex, "mehd"); ok && len(mehd) >= 8 {
+				if mehd[0] == 1 && len(mehd) >= 12 {
+					duration = binary.BigEndian.Uint64(mehd[4:])
+				} else {
+					duration = uint64(binary.BigEndian.Uint32(mehd[4:]))
+				}
+			}
+		}
+	}
+	// all ones is an unknown duration
+	if timescale == 0 || duration == 0 || duration == 1<<32-1 || duration == 1<<64-1 {
+		return 0, false
+	}
+	return time.Duration(float64(duration) / float64(timescale) * float64(time.Second)), true
+}
+
+// mp4Box returns the payload of the first box of type `typ` among the boxes
+// in `data`
+func mp4Box(data []byte, typ string) ([]byte, bool) {
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data))
+		hdr := uint64(8)
+		switch size {
+		case 0:
+			// the box extends to the end of the data
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return nil, false
+			}
+			size, hdr = binary.BigEndian.Uint64(data[8:]), 16
+		}
+		if size < hdr || size > uint64(len(data)) {
+			return nil, false
+		}
+		if string(data[4:8]) == typ {
+			return data[hdr:size], true
+		}
+		data = data[size:]
+	}
+	return nil, false
+}
+
+// SegmentDurationMin and SegmentDurationMax bound the segment durations
+// streams may ask for, with the segmentDurationMs of the auth webhook
+// response or the segmentDuration query parameter of their ingest URL
+var (
+	SegmentDurationMin = time.Second
+	SegmentDurationMax = 10 * time.Second
+)
+
+// parseSegmentDuration returns the segment duration the ingest URL `u` asks
+// for in its segmentDuration query parameter, e.g. "4s"; zero if none
+func parseSegmentDuration(u *url.URL) (time.Duration, error) {
+	v := u.Query().Get("segmentDuration")
+	if v == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid segment duration %q", v)
+	}
+	return d, nil
+}
+
+// validateSegmentDuration checks that the segment duration `d` of a stream
+// is within bounds, and that segments of that duration can hold a GOP of each
+// of `profiles` if TranscodeProfileLimits require it
+func validateSegmentDuration(d time.Duration, profiles []ffmpeg.VideoProfile) error {
+	if d < SegmentDurationMin || d > SegmentDurationMax {
+		return fmt.Errorf("segment duration out of bounds duration=%v min=%v max=%v", d, SegmentDurationMin, SegmentDurationMax)
+	}
+	if !TranscodeProfileLimits.GOPWithinSegments || KeyframeSegmentation != nil {
+		return nil
+	}
+	for _, p := range profiles {
+		if p.GOP > d {
+			return fmt.Errorf("gop longer than segments profile=%s gop=%v segmentLength=%v", p.Name, p.GOP, d)
+		}
+	}
+	return nil
+}
+
+// streamSegLen returns the duration the source of the stream of `params` is
+// cut into segments at, unless cut at keyframes with KeyframeSegmentation
+func streamSegLen(params *core.StreamParameters) time.Duration {
+	if params != nil && params.SegmentDuration > 0 {
+		return params.SegmentDuration
+	}
+	return SegLen
+}
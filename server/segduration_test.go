@@ -0,0 +1,136 @@
+package server
+
+import (
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/lpms/ffmpeg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mp4TestBox(typ string, payload ...[]byte) []byte {
+	box := append(make([]byte, 4), typ...)
+	for _, p := range payload {
+		box = append(box, p...)
+	}
+	binary.BigEndian.PutUint32(box, uint32(len(box)))
+	return box
+}
+
+// mp4TestMvhd is a version 0 movie header, up to its duration
+func mp4TestMvhd(timescale, duration uint32) []byte {
+	b := make([]byte, 20)
+	binary.BigEndian.PutUint32(b[12:], timescale)
+	binary.BigEndian.PutUint32(b[16:], duration)
+	return mp4TestBox("mvhd", b)
+}
+
+func TestProbeSegmentDuration(t *testing.T) {
+	assert := assert.New(t)
+
+	probe := func(data []byte, format ffmpeg.Format) time.Duration {
+		d, ok := probeSegmentDuration(data, format)
+		if !ok {
+			return -1
+		}
+		return d
+	}
+
+	// 60 frames at 30fps, the last one lasting as long as the others
+	video := []int64{90000}
+	for i := 1; i < 60; i++ {
+		video = append(video, video[i-1]+3000)
+	}
+	assert.Equal(2*time.Second, probe(tsTestSegment(video, []int64{90000}), ffmpeg.FormatMPEGTS))
+	// across a timestamp wraparound
+	assert.Equal(100*time.Millisecond, probe(tsTestSegment(tsTestFrames(1<<33-6000, 3000, 3000), nil), ffmpeg.FormatMPEGTS))
+	// audio only
+	assert.Equal(6*time.Second, probe(tsTestSegment(nil, tsTestFrames(0, 180000, 180000)), ffmpeg.FormatMPEGTS))
+	// a single frame is not enough
+	assert.Equal(time.Duration(-1), probe(tsTestSegment([]int64{0}, nil), ffmpeg.FormatMPEGTS))
+
+	ftyp := mp4TestBox("ftyp", []byte("isom"))
+	assert.Equal(6*time.Second, probe(append(ftyp, mp4TestBox("moov", mp4TestMvhd(1000, 6000))...), ffmpeg.FormatMP4))
+
+	// version 1 header
+	mvhd := make([]byte, 32)
+	mvhd[0] = 1
+	binary.BigEndian.PutUint32(mvhd[20:], 90000)
+	binary.BigEndian.PutUint64(mvhd[24:], 540000)
+	assert.Equal(6*time.Second, probe(mp4TestBox("moov", mp4TestBox("mvhd", mvhd)), ffmpeg.FormatMP4))
+
+	// fragmented, with the duration in the movie extends header
+	mehd := []byte{0, 0, 0, 0, 0, 0, 0x0b, 0xb8}
+	moov := mp4TestBox("moov", mp4TestMvhd(1000, 0), mp4TestBox("mvex", mp4TestBox("mehd", mehd)))
+	assert.Equal(3*time.Second, probe(append(ftyp, moov...), ffmpeg.FormatMP4))
+
+	// unknown durations
+	assert.Equal(time.Duration(-1), probe(mp4TestBox("moov", mp4TestMvhd(1000, 0)), ffmpeg.FormatMP4))
+	assert.Equal(time.Duration(-1), probe(mp4TestBox("moov", mp4TestMvhd(1000, 1<<32-1)), ffmpeg.FormatMP4))
+	assert.Equal(time.Duration(-1), probe(ftyp, ffmpeg.FormatMP4))
+	assert.Equal(time.Duration(-1), probe([]byte("InsteadOf.TS"), ffmpeg.FormatMPEGTS))
+	// truncated boxes
+	assert.Equal(time.Duration(-1), probe(mp4TestBox("moov", mp4TestMvhd(1000, 6000))[:20], ffmpeg.FormatMP4))
+}
+
+func TestStreamSegmentDuration(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	s := setupServer()
+	defer serverCleanup(s)
+	createSid := createRTMPStreamIDHandler(s)
+	defer func(profiles []ffmpeg.VideoProfile) { BroadcastJobVideoProfiles = profiles }(BroadcastJobVideoProfiles)
+	BroadcastJobVideoProfiles = []ffmpeg.VideoProfile{ffmpeg.P144p30fps16x9}
+
+	params := func(rawurl string) *core.StreamParameters {
+		u, err := url.Parse(rawurl)
+		require.Nil(err)
+		sid := createSid(u)
+		if sid == nil {
+			return nil
+		}
+		return sid.(*core.StreamParameters)
+	}
+
+	// streams are cut at the duration of the node unless they ask otherwise
+	p := params("rtmp://localhost/live/a")
+	require.NotNil(p)
+	assert.Zero(p.SegmentDuration)
+	assert.Equal(SegLen, streamSegLen(p))
+	p = params("rtmp://localhost/live/a?segmentDuration=4s")
+	require.NotNil(p)
+	assert.Equal(4*time.Second, p.SegmentDuration)
+	assert.Equal(4*time.Second, streamSegLen(p))
+
+	// within bounds
+	assert.Nil(params("rtmp://localhost/live/a?segmentDuration=four"))
+	assert.Nil(params("rtmp://localhost/live/a?segmentDuration=500ms"))
+	assert.Nil(params("rtmp://localhost/live/a?segmentDuration=1m"))
+
+	// the auth webhook has the last word
+	var resp string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(resp))
+	}))
+	defer ts.Close()
+	defer func(url string) { AuthWebhookURL = url }(AuthWebhookURL)
+	AuthWebhookURL = ts.URL
+	resp = `{"manifestID":"a", "segmentDurationMs": 6000}`
+	p = params("rtmp://localhost/live/a?segmentDuration=4s")
+	require.NotNil(p)
+	assert.Equal(6*time.Second, p.SegmentDuration)
+	resp = `{"manifestID":"a", "segmentDurationMs": 60000}`
+	assert.Nil(params("rtmp://localhost/live/a"))
+
+	// segments must hold a GOP of every rendition
+	resp = `{"manifestID":"a", "segmentDurationMs": 1000, "profiles": [{"name":"p","width":320,"height":240,"bitrate":1000000,"gop":"2.0"}]}`
+	assert.Nil(params("rtmp://localhost/live/a"))
+	resp = `{"manifestID":"a", "segmentDurationMs": 3000, "profiles": [{"name":"p","width":320,"height":240,"bitrate":1000000,"gop":"2.0"}]}`
+	assert.NotNil(params("rtmp://localhost/live/a"))
+}
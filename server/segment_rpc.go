@@ -6,10 +6,10 @@ import (
 	"crypto/tls"
 	"encoding/base64"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math"
 	"math/big"
-	gonet "net"
 	"net/http"
 	"strings"
 	"time"
@@ -22,10 +22,8 @@ import (
 	"github.com/livepeer/go-livepeer/net"
 	"github.com/livepeer/lpms/ffmpeg"
 	"github.com/livepeer/lpms/stream"
-	"golang.org/x/net/http2"
 
 	ethcommon "github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/crypto"
 
 	"github.com/golang/glog"
 	"github.com/golang/protobuf/proto"
@@ -35,32 +33,67 @@ import (
 const paymentHeader = "Livepeer-Payment"
 const segmentHeader = "Livepeer-Segment"
 
+// capabilitiesHeader carries the capabilities of an orchestrator rejecting a
+// segment for capabilities it lacks, as base64 encoded net.Capabilities
+const capabilitiesHeader = "Livepeer-Capabilities"
+
 const pixelEstimateMultiplier = 1.02
 
-var errSegEncoding = errors.New("ErrorSegEncoding")
-var errSegSig = errors.New("ErrSegSig")
-var errFormat = errors.New("unrecognized profile output format")
-var errProfile = errors.New("unrecognized encoder profile")
-var errDuration = errors.New("invalid duration")
-var errCapCompat = errors.New("incompatible capabilities")
+var errSegEncoding = newError(ErrorCategoryTranscode, false, "ErrorSegEncoding")
+var errSegSig = newError(ErrorCategoryAuth, false, "ErrSegSig")
+var errFormat = newError(ErrorCategoryTranscode, false, "unrecognized profile output format")
+var errProfile = newError(ErrorCategoryTranscode, false, "unrecognized encoder profile")
+var errDuration = newError(ErrorCategoryTranscode, false, "invalid duration")
+var errCapCompat = newError(ErrorCategoryTranscode, false, "incompatible capabilities")
+
+// CapabilityMismatchError is returned for segments an orchestrator rejected
+// for needing capabilities it lacks. Streams can be degraded to do without
+// the missing capabilities.
+type CapabilityMismatchError struct {
+	// Capabilities of the job the orchestrator lacks
+	Missing []core.Capability
+	// Those of the missing capabilities that are unknown to the orchestrator,
+	// which runs an older version, rather than unsupported by it
+	Unknown []core.Capability
+	// Capabilities of the orchestrator
+	Supported *net.Capabilities
+}
+
+func (e *CapabilityMismatchError) Error() string {
+	msg := fmt.Sprintf("incompatible capabilities missing=%s", core.CapabilityNames(e.Missing))
+	if len(e.Unknown) > 0 {
+		msg += fmt.Sprintf(" unknown=%s", core.CapabilityNames(e.Unknown))
+	}
+	return msg
+}
 
 var tlsConfig = &tls.Config{InsecureSkipVerify: true}
 var httpClient = &http.Client{
-	Transport: &http2.Transport{
-		TLSClientConfig: tlsConfig,
-		DialTLS: func(network, addr string, cfg *tls.Config) (gonet.Conn, error) {
-			netDialer := &gonet.Dialer{
-				Timeout: 2 * time.Second,
-			}
-			return tls.DialWithDialer(netDialer, network, addr, cfg)
-		},
-	},
+	Transport: common.NewHTTPTransport(common.DefaultTransportConfig),
 	// Don't set a timeout here; pass a context to the request
 }
 
+// SetTransportConfig replaces the transports used to submit segments to
+// orchestrators and download the results, and sets the client certificates
+// presented to orchestrators. Must be called before any segments are
+// submitted.
+func SetTransportConfig(cfg common.TransportConfig) {
+	httpClient.Transport = common.NewHTTPTransport(cfg)
+	tlsConfig.Certificates = cfg.ClientCertificates
+	// Client certificates are only for orchestrators
+	cfg.ClientCertificates = nil
+	drivers.SetTransportConfig(cfg)
+}
+
 func (h *lphttp) ServeSegment(w http.ResponseWriter, r *http.Request) {
 	orch := h.orchestrator
 
+	if err := SegmentClientAuth.verify(r.TLS); err != nil {
+		glog.Errorf("Rejecting segment from addr=%s err=%v", r.RemoteAddr, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
 	payment, err := getPayment(r.Header.Get(paymentHeader))
 	if err != nil {
 		glog.Error("Could not parse payment")
@@ -76,6 +109,11 @@ func (h *lphttp) ServeSegment(w http.ResponseWriter, r *http.Request) {
 	segData, err := verifySegCreds(orch, seg, sender)
 	if err != nil {
 		glog.Error("Could not verify segment creds")
+		if err == errCapCompat {
+			if caps, err := encodeCapabilities(orch.Capabilities()); err == nil {
+				w.Header().Set(capabilitiesHeader, caps)
+			}
+		}
 		http.Error(w, err.Error(), http.StatusForbidden)
 		return
 	}
@@ -101,7 +139,7 @@ func (h *lphttp) ServeSegment(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	oInfo, err := orchestratorInfo(orch, sender, orch.ServiceURI().String())
+	oInfo, err := orchestratorInfo(orch, sender, orch.ServiceURI().String(), "")
 	if err != nil {
 		glog.Errorf("Error updating orchestrator info - err=%v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -109,6 +147,7 @@ func (h *lphttp) ServeSegment(w http.ResponseWriter, r *http.Request) {
 	}
 	// Use existing auth token because new auth tokens should only be sent out in GetOrchestrator() RPC calls
 	oInfo.AuthToken = segData.AuthToken
+	oInfo.SegmentKey = segmentPublicKey(segData.AuthToken.SessionId)
 
 	// download the segment and check the hash
 	dlStart := time.Now()
@@ -148,7 +187,18 @@ func (h *lphttp) ServeSegment(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	hash := crypto.Keccak256(data)
+	if len(segData.SegmentKey) > 0 && uri == "" {
+		data, err = decryptSegment(segData.AuthToken.SessionId, segData.SegmentKey, data)
+		if err != nil {
+			glog.Errorf("Could not decrypt segment manifestID=%s sessionID=%s seqNo=%d err=%v", segData.ManifestID, segData.AuthToken.SessionId, segData.Seq, err)
+			http.Error(w, "BadRequest", http.StatusBadRequest)
+			return
+		}
+		// Transcoders get the segment from the orchestrator itself
+		segData.SegmentKey = nil
+	}
+
+	hash := common.Keccak256(data)
 	if !bytes.Equal(hash, segData.Hash.Bytes()) {
 		glog.Error("Mismatched hash for body; rejecting")
 		http.Error(w, "Forbidden", http.StatusForbidden)
@@ -189,8 +239,9 @@ func (h *lphttp) ServeSegment(w http.ResponseWriter, r *http.Request) {
 		}
 		pixels += res.TranscodeData.Segments[i].Pixels
 		d := &net.TranscodedSegmentData{
-			Url:    uri,
-			Pixels: res.TranscodeData.Segments[i].Pixels,
+			Url:             uri,
+			Pixels:          res.TranscodeData.Segments[i].Pixels,
+			EncoderSettings: core.NetEncoderSettings(res.TranscodeData.Segments[i].Encoder),
 		}
 		segments = append(segments, d)
 	}
@@ -319,7 +370,7 @@ func verifySegCreds(orch Orchestrator, segCreds string, broadcaster ethcommon.Ad
 	}
 
 	if !md.Caps.CompatibleWith(orch.Capabilities()) {
-		glog.Error("Capability check failed")
+		glog.Errorf("Capability check failed missing=%s", core.CapabilityNames(md.Caps.Missing(orch.Capabilities())))
 		return nil, errCapCompat
 	}
 
@@ -328,14 +379,8 @@ func verifySegCreds(orch Orchestrator, segCreds string, broadcaster ethcommon.Ad
 		return nil, errors.New("missing auth token")
 	}
 
-	verifyToken := orch.AuthToken(segData.AuthToken.SessionId, segData.AuthToken.Expiration)
-	if !bytes.Equal(verifyToken.Token, segData.AuthToken.Token) {
-		return nil, errors.New("invalid auth token")
-	}
-
-	expiration := time.Unix(segData.AuthToken.Expiration, 0)
-	if time.Now().After(expiration) {
-		return nil, errors.New("expired auth token")
+	if err := verifyAuthToken(orch, segData.AuthToken); err != nil {
+		return nil, err
 	}
 
 	if err := orch.CheckCapacity(core.ManifestID(segData.AuthToken.SessionId)); err != nil {
@@ -346,6 +391,42 @@ func verifySegCreds(orch Orchestrator, segCreds string, broadcaster ethcommon.Ad
 	return md, nil
 }
 
+// encodeCapabilities encodes `caps` for the capabilities header
+func encodeCapabilities(caps *net.Capabilities) (string, error) {
+	buf, err := proto.Marshal(caps)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf), nil
+}
+
+// decodeCapabilities decodes the capabilities header
+func decodeCapabilities(header string) (*net.Capabilities, error) {
+	buf, err := base64.StdEncoding.DecodeString(header)
+	if err != nil {
+		return nil, err
+	}
+	var caps net.Capabilities
+	if err := proto.Unmarshal(buf, &caps); err != nil {
+		return nil, err
+	}
+	return &caps, nil
+}
+
+// verifyAuthToken checks that `token` was issued by `orch` and has not expired
+func verifyAuthToken(orch Orchestrator, token *net.AuthToken) error {
+	verifyToken := orch.AuthToken(token.SessionId, token.Expiration)
+	if !bytes.Equal(verifyToken.Token, token.Token) {
+		return errors.New("invalid auth token")
+	}
+
+	expiration := time.Unix(token.Expiration, 0)
+	if time.Now().After(expiration) {
+		return errors.New("expired auth token")
+	}
+	return nil
+}
+
 func SubmitSegment(sess *BroadcastSession, seg *stream.HLSSegment, nonce uint64) (*ReceivedTranscodeResult, error) {
 	uploaded := seg.Name != "" // hijack seg.Name to convey the uploaded URI
 
@@ -360,22 +441,29 @@ func SubmitSegment(sess *BroadcastSession, seg *stream.HLSSegment, nonce uint64)
 	if uploaded {
 		data = []byte(seg.Name)
 	}
+	if encryptsSegment(sess, seg) {
+		if data, err = encryptSegment(sess, data); err != nil {
+			return nil, wrapError(ErrorCategoryTranscode, true, err)
+		}
+	} else if SegmentEncryption && !uploaded {
+		return nil, errNoSegmentKey
+	}
 
 	priceInfo, err := common.RatPriceInfo(sess.OrchestratorInfo.GetPriceInfo())
 	if err != nil {
-		return nil, err
+		return nil, wrapError(ErrorCategoryPayment, true, err)
 	}
 
 	params := sess.Params
 	fee, err := estimateFee(seg, params.Profiles, priceInfo)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(ErrorCategoryPayment, true, err)
 	}
 
 	// Create a BalanceUpdate to be completed when this function returns
 	balUpdate, err := newBalanceUpdate(sess, fee)
 	if err != nil {
-		return nil, err
+		return nil, wrapError(ErrorCategoryPayment, true, err)
 	}
 
 	// The balance update should be completed when this function returns
@@ -392,7 +480,7 @@ func SubmitSegment(sess *BroadcastSession, seg *stream.HLSSegment, nonce uint64)
 			monitor.PaymentCreateError(recipient, string(params.ManifestID))
 		}
 
-		return nil, err
+		return nil, wrapError(ErrorCategoryPayment, !shouldStopStream(err), err)
 	}
 
 	// set a minimum timeout to accommodate transport / processing overhead
@@ -405,7 +493,11 @@ func SubmitSegment(sess *BroadcastSession, seg *stream.HLSSegment, nonce uint64)
 	defer cancel()
 
 	ti := sess.OrchestratorInfo
-	req, err := http.NewRequestWithContext(ctx, "POST", ti.Transcoder+"/segment", bytes.NewBuffer(data))
+	var body io.Reader = bytes.NewBuffer(data)
+	if !uploaded {
+		body = UploadPacing.reader(ctx, ti.Transcoder, body)
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", ti.Transcoder+"/segment", body)
 	if err != nil {
 		glog.Errorf("Could not generate transcode request to orch=%s", ti.Transcoder)
 		if monitor.Enabled {
@@ -413,6 +505,8 @@ func SubmitSegment(sess *BroadcastSession, seg *stream.HLSSegment, nonce uint64)
 		}
 		return nil, err
 	}
+	// not inferred from paced bodies
+	req.ContentLength = int64(len(data))
 
 	req.Header.Set(segmentHeader, segCreds)
 	req.Header.Set(paymentHeader, payment)
@@ -433,7 +527,7 @@ func SubmitSegment(sess *BroadcastSession, seg *stream.HLSSegment, nonce uint64)
 		if monitor.Enabled {
 			monitor.SegmentUploadFailed(nonce, seg.SeqNo, monitor.SegmentUploadErrorUnknown, err, false)
 		}
-		return nil, fmt.Errorf("header timeout: %w", err)
+		return nil, wrapError(ErrorCategoryTranscode, true, fmt.Errorf("header timeout: %w", err))
 	}
 	defer resp.Body.Close()
 
@@ -460,7 +554,18 @@ func SubmitSegment(sess *BroadcastSession, seg *stream.HLSSegment, nonce uint64)
 					fmt.Errorf("Code: %d Error: %s", resp.StatusCode, errorString), false)
 			}
 		}
-		return nil, fmt.Errorf(errorString)
+		if header := resp.Header.Get(capabilitiesHeader); resp.StatusCode == http.StatusForbidden && header != "" {
+			if caps, err := decodeCapabilities(header); err == nil {
+				missing := params.Capabilities.Missing(caps)
+				return nil, wrapError(ErrorCategoryTranscode, true, &CapabilityMismatchError{
+					Missing:   missing,
+					Unknown:   core.Unknown(missing, caps),
+					Supported: caps,
+				})
+			}
+			glog.Errorf("Unable to decode capabilities of orch=%s", ti.Transcoder)
+		}
+		return nil, wrapError(ErrorCategoryTranscode, true, fmt.Errorf(errorString))
 	}
 	glog.Infof("Uploaded segment nonce=%d manifestID=%s sessionID=%s seqNo=%d orch=%s dur=%s", nonce, params.ManifestID, sess.OrchestratorInfo.AuthToken.SessionId, seg.SeqNo, ti.Transcoder, uploadDur)
 	if monitor.Enabled {
@@ -475,7 +580,7 @@ func SubmitSegment(sess *BroadcastSession, seg *stream.HLSSegment, nonce uint64)
 		if monitor.Enabled {
 			monitor.SegmentTranscodeFailed(monitor.SegmentTranscodeErrorReadBody, nonce, seg.SeqNo, err, false)
 		}
-		return nil, fmt.Errorf("body timeout: %w", err)
+		return nil, wrapError(ErrorCategoryTranscode, true, fmt.Errorf("body timeout: %w", err))
 	}
 	transcodeDur := tookAllDur - uploadDur
 
@@ -486,7 +591,7 @@ func SubmitSegment(sess *BroadcastSession, seg *stream.HLSSegment, nonce uint64)
 		if monitor.Enabled {
 			monitor.SegmentTranscodeFailed(monitor.SegmentTranscodeErrorParseResponse, nonce, seg.SeqNo, err, false)
 		}
-		return nil, err
+		return nil, wrapError(ErrorCategoryTranscode, true, err)
 	}
 
 	// check for errors and exit early if there's anything unusual
@@ -508,7 +613,8 @@ func SubmitSegment(sess *BroadcastSession, seg *stream.HLSSegment, nonce uint64)
 				monitor.SegmentTranscodeFailed(monitor.SegmentTranscodeErrorTranscode, nonce, seg.SeqNo, err, false)
 			}
 		}
-		return nil, err
+		// errors of the source itself fail with any orchestrator
+		return nil, wrapError(ErrorCategoryTranscode, !isNonRetryableError(err), err)
 	case *net.TranscodeResult_Data:
 		// fall through here for the normal case
 		tdata = res.Data
@@ -518,7 +624,7 @@ func SubmitSegment(sess *BroadcastSession, seg *stream.HLSSegment, nonce uint64)
 		if monitor.Enabled {
 			monitor.SegmentTranscodeFailed(monitor.SegmentTranscodeErrorUnknownResponse, nonce, seg.SeqNo, err, false)
 		}
-		return nil, err
+		return nil, wrapError(ErrorCategoryTranscode, true, err)
 	}
 
 	// We treat a response as "receiving change" where the change is the difference between the credit and debit for the update
@@ -546,6 +652,9 @@ func SubmitSegment(sess *BroadcastSession, seg *stream.HLSSegment, nonce uint64)
 		TranscodeData: tdata,
 		Info:          tr.Info,
 		LatencyScore:  tookAllDur.Seconds() / seg.Duration,
+		Raw:           data,
+		SegCreds:      segCreds,
+		Payment:       payment,
 	}, nil
 }
 
@@ -559,7 +668,7 @@ func genSegCreds(sess *BroadcastSession, seg *stream.HLSSegment) (string, error)
 
 	// Generate signature for relevant parts of segment
 	params := sess.Params
-	hash := crypto.Keccak256(seg.Data)
+	hash := common.Keccak256(seg.Data)
 	md := &core.SegTranscodingMetadata{
 		ManifestID: params.ManifestID,
 		Seq:        int64(seg.SeqNo),
@@ -569,6 +678,11 @@ func genSegCreds(sess *BroadcastSession, seg *stream.HLSSegment) (string, error)
 		Duration:   time.Duration(seg.Duration * float64(time.Second)),
 		Caps:       params.Capabilities,
 		AuthToken:  sess.OrchestratorInfo.GetAuthToken(),
+		Metadata:   params.Metadata.Get(),
+	}
+	if encryptsSegment(sess, seg) {
+		_, pub := broadcasterSegmentKey()
+		md.SegmentKey = pub[:]
 	}
 	sig, err := sess.Broadcaster.Sign(md.Flatten())
 	if err != nil {
@@ -587,7 +701,7 @@ func genSegCreds(sess *BroadcastSession, seg *stream.HLSSegment) (string, error)
 		glog.Error("Unable to marshal ", err)
 		return "", err
 	}
-	return base64.StdEncoding.EncodeToString(data), nil
+	return common.EncodeBase64(data), nil
 }
 
 func estimateFee(seg *stream.HLSSegment, profiles []ffmpeg.VideoProfile, priceInfo *big.Rat) (*big.Rat, error) {
@@ -745,7 +859,7 @@ func genPayment(sess *BroadcastSession, numTickets int) (string, error) {
 		return "", err
 	}
 
-	return base64.StdEncoding.EncodeToString(data), nil
+	return common.EncodeBase64(data), nil
 }
 
 func validatePrice(sess *BroadcastSession) error {
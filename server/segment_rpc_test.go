@@ -119,6 +119,43 @@ func TestServeSegment_MismatchHashError(t *testing.T) {
 	assert.Equal("Forbidden", strings.TrimSpace(string(body)))
 }
 
+func TestServeSegment_CapabilityMismatch(t *testing.T) {
+	orch := &mockOrchestrator{}
+	handler := serveSegmentHandler(orch)
+
+	orch.On("VerifySig", mock.Anything, mock.Anything, mock.Anything).Return(true)
+
+	s := &BroadcastSession{
+		Broadcaster: stubBroadcaster2(),
+		Params: &core.StreamParameters{
+			ManifestID:   core.RandomManifestID(),
+			Profiles:     []ffmpeg.VideoProfile{ffmpeg.P720p30fps16x9},
+			Capabilities: core.NewCapabilities([]core.Capability{core.Capability_GOP}, nil),
+		},
+		OrchestratorInfo: &net.OrchestratorInfo{AuthToken: stubAuthToken},
+	}
+	creds, err := genSegCreds(s, &stream.HLSSegment{})
+	require.Nil(t, err)
+
+	headers := map[string]string{
+		paymentHeader: "",
+		segmentHeader: creds,
+	}
+	resp := httpPostResp(handler, bytes.NewReader([]byte("foo")), headers)
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	require.Nil(t, err)
+
+	assert := assert.New(t)
+	assert.Equal(http.StatusForbidden, resp.StatusCode)
+	assert.Equal(errCapCompat.Error(), strings.TrimSpace(string(body)))
+	caps, err := decodeCapabilities(resp.Header.Get(capabilitiesHeader))
+	require.Nil(t, err)
+	assert.True(proto.Equal(orch.Capabilities(), caps))
+	assert.Equal(core.CapabilitiesVersion, caps.Version)
+}
+
 func TestServeSegment_TranscodeSegError(t *testing.T) {
 	orch := &mockOrchestrator{}
 	handler := serveSegmentHandler(orch)
@@ -1059,7 +1096,7 @@ func TestServeSegment_UpdateOrchestratorInfo_WebhookCache_PriceInfo(t *testing.T
 		PixelsPerUnit: 30,
 	}
 
-	discoveryAuthWebhookCache.Add(s.Broadcaster.Address().Hex(), &discoveryAuthWebhookRes{PriceInfo: webhookPrice}, authTokenValidPeriod)
+	discoveryAuthWebhookCache.set(s.Broadcaster.Address().Hex(), &discoveryAuthWebhookRes{PriceInfo: webhookPrice})
 
 	params := &net.TicketParams{
 		Recipient:         []byte("foo"),
@@ -1702,6 +1739,57 @@ func TestSubmitSegment_Non200StatusCode(t *testing.T) {
 	balance.AssertNotCalled(t, "Credit", mock.Anything)
 }
 
+func TestSubmitSegment_CapabilityMismatch(t *testing.T) {
+	assert := assert.New(t)
+
+	orchCaps := core.NewCapabilities([]core.Capability{core.Capability_H264, core.Capability_MPEGTS}, nil).ToNetCapabilities()
+	orchCaps.Version = uint32(core.Capability_AuthToken)
+	header, err := encodeCapabilities(orchCaps)
+	require.Nil(t, err)
+	ts, mux := stubTLSServer()
+	defer ts.Close()
+	mux.HandleFunc("/segment", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(capabilitiesHeader, header)
+		http.Error(w, errCapCompat.Error(), http.StatusForbidden)
+	})
+
+	params := &core.StreamParameters{
+		ManifestID: core.RandomManifestID(),
+		Profiles:   []ffmpeg.VideoProfile{{Name: "gop", Resolution: "426x240", Bitrate: "400k", GOP: time.Second}},
+	}
+	params.Capabilities, err = core.JobCapabilities(params)
+	require.Nil(t, err)
+	s := &BroadcastSession{
+		Broadcaster: stubBroadcaster2(),
+		Params:      params,
+		OrchestratorInfo: &net.OrchestratorInfo{
+			Transcoder: ts.URL,
+			PriceInfo:  &net.PriceInfo{PricePerUnit: 1, PixelsPerUnit: 1},
+			AuthToken:  stubAuthToken,
+		},
+	}
+
+	_, err = SubmitSegment(s, &stream.HLSSegment{}, 0)
+	var mismatch *CapabilityMismatchError
+	require.True(t, errors.As(err, &mismatch))
+	assert.Equal([]core.Capability{core.Capability_GOP, core.Capability_AuthToken}, mismatch.Missing)
+	assert.Equal([]core.Capability{core.Capability_AuthToken}, mismatch.Unknown)
+	assert.Equal(orchCaps.Bitstring, mismatch.Supported.Bitstring)
+	assert.EqualError(err, "incompatible capabilities missing=AuthToken,GOP unknown=AuthToken")
+	assert.True(isRetryable(err))
+
+	// orchestrators without the header fail the segment as before
+	ts2, mux2 := stubTLSServer()
+	defer ts2.Close()
+	mux2.HandleFunc("/segment", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, errCapCompat.Error(), http.StatusForbidden)
+	})
+	s.OrchestratorInfo.Transcoder = ts2.URL
+	_, err = SubmitSegment(s, &stream.HLSSegment{}, 0)
+	assert.False(errors.As(err, &mismatch))
+	assert.EqualError(err, errCapCompat.Error())
+}
+
 func TestSubmitSegment_ProtoUnmarshalError(t *testing.T) {
 	ts, mux := stubTLSServer()
 	defer ts.Close()
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/lpms/stream"
+)
+
+// KeyframeSegmentation, if set, makes RTMP streams be cut into segments at
+// keyframes of the source rather than every SegLen. Every segment then starts
+// with a keyframe, so renditions can be cut cleanly and the source can be
+// passed through as is.
+var KeyframeSegmentation *SegmentBounds
+
+// SegmentBounds are the durations keyframe aligned segments are kept within.
+// The segmenter cuts at the first keyframe past Min; segments that still end
+// up shorter, such as after a late keyframe, are joined with the segments
+// following them as long as the result is no longer than Max. Segments
+// longer than Max can only be shortened by a shorter keyframe interval at
+// the source, so they are passed on and logged.
+type SegmentBounds struct {
+	Min time.Duration
+	Max time.Duration
+}
+
+// segmentJoiner joins segments of a stream that are shorter than the minimum
+// duration with the segments following them, and numbers the resulting
+// segments consecutively from the sequence number of the first segment
+type segmentJoiner struct {
+	mid     string
+	bounds  SegmentBounds
+	started bool
+	seqNo   uint64
+	pending *stream.HLSSegment
+}
+
+func newSegmentJoiner(mid string, bounds SegmentBounds) *segmentJoiner {
+	return &segmentJoiner{mid: mid, bounds: bounds}
+}
+
+// add takes the next segment from the segmenter and returns the segments
+// that are ready to be processed
+func (j *segmentJoiner) add(seg *stream.HLSSegment) []*stream.HLSSegment {
+	if !j.started {
+		j.started = true
+		j.seqNo = seg.SeqNo
+	}
+	max := j.bounds.Max.Seconds()
+	if max > 0 && seg.Duration > max {
+		glog.Warningf("Segment longer than the maximum duration; shorten the keyframe interval of the source manifestID=%s seqNo=%d dur=%v max=%v",
+			j.mid, seg.SeqNo, seg.Duration, max)
+	}
+	var out []*stream.HLSSegment
+	if j.pending != nil && max > 0 && j.pending.Duration+seg.Duration > max {
+		out = append(out, j.emit())
+	}
+	if j.pending == nil {
+		j.pending = &stream.HLSSegment{
+			Name:     seg.Name,
+			Data:     seg.Data,
+			Duration: seg.Duration,
+		}
+	} else {
+		// MPEG-TS segments can be joined by concatenation
+		data := make([]byte, 0, len(j.pending.Data)+len(seg.Data))
+		j.pending.Data = append(append(data, j.pending.Data...), seg.Data...)
+		j.pending.Duration += seg.Duration
+	}
+	if j.pending.Duration >= j.bounds.Min.Seconds() {
+		out = append(out, j.emit())
+	}
+	return out
+}
+
+// flush returns the segment held back at the end of the stream, if any
+func (j *segmentJoiner) flush() []*stream.HLSSegment {
+	if j.pending == nil {
+		return nil
+	}
+	return []*stream.HLSSegment{j.emit()}
+}
+
+func (j *segmentJoiner) emit() *stream.HLSSegment {
+	seg := j.pending
+	seg.SeqNo = j.seqNo
+	j.seqNo++
+	j.pending = nil
+	return seg
+}
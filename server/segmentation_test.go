@@ -0,0 +1,55 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/livepeer/lpms/stream"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSegmentJoiner(t *testing.T) {
+	assert := assert.New(t)
+
+	j := newSegmentJoiner("mid", SegmentBounds{Min: 2 * time.Second, Max: 5 * time.Second})
+	seg := func(seqNo uint64, dur float64, data string) *stream.HLSSegment {
+		return &stream.HLSSegment{SeqNo: seqNo, Name: data, Data: []byte(data), Duration: dur}
+	}
+
+	// long enough segments pass through
+	out := j.add(seg(10, 2, "a"))
+	assert.Len(out, 1)
+	assert.Equal(uint64(10), out[0].SeqNo)
+	assert.Equal([]byte("a"), out[0].Data)
+
+	// short segments are held back and joined with the next
+	assert.Empty(j.add(seg(11, 0.5, "b")))
+	assert.Empty(j.add(seg(12, 1, "c")))
+	out = j.add(seg(13, 2, "d"))
+	assert.Len(out, 1)
+	assert.Equal(uint64(11), out[0].SeqNo)
+	assert.Equal("b", out[0].Name)
+	assert.Equal([]byte("bcd"), out[0].Data)
+	assert.Equal(3.5, out[0].Duration)
+
+	// but not beyond the maximum
+	assert.Empty(j.add(seg(14, 1, "e")))
+	out = j.add(seg(15, 4.5, "f"))
+	assert.Len(out, 2)
+	assert.Equal(uint64(12), out[0].SeqNo)
+	assert.Equal([]byte("e"), out[0].Data)
+	assert.Equal(uint64(13), out[1].SeqNo)
+	assert.Equal([]byte("f"), out[1].Data)
+
+	// segments longer than the maximum can't be split
+	out = j.add(seg(16, 6, "g"))
+	assert.Len(out, 1)
+	assert.Equal(6.0, out[0].Duration)
+
+	// whatever is left is flushed at the end of the stream
+	assert.Empty(j.add(seg(17, 1, "h")))
+	out = j.flush()
+	assert.Len(out, 1)
+	assert.Equal(uint64(15), out[0].SeqNo)
+	assert.Empty(j.flush())
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/livepeer/go-livepeer/core"
+)
+
+// MaxSegmentMetadataSize bounds the total size of the keys and values of the
+// metadata of a stream, as it is sent in the header of every segment
+var MaxSegmentMetadataSize = 1024
+
+var errNoSegmentMetadata = errors.New("stream was not started with metadata")
+
+func validateSegmentMetadata(md map[string]string) error {
+	size := 0
+	for k, v := range md {
+		if k == "" {
+			return errors.New("empty metadata key")
+		}
+		size += len(k) + len(v)
+	}
+	if size > MaxSegmentMetadataSize {
+		return fmt.Errorf("metadata too large size=%d max=%d", size, MaxSegmentMetadataSize)
+	}
+	return nil
+}
+
+// setSegmentMetadata replaces the metadata of a live stream, for its
+// segments from the next one on. Only streams that the auth webhook started
+// with metadata have any.
+func (s *LivepeerServer) setSegmentMetadata(mid core.ManifestID, md map[string]string) error {
+	cxn, ok := s.rtmpConnections.get(s.streamAliases.resolve(mid))
+	if !ok {
+		return errUnknownStream
+	}
+	if cxn.params.Metadata == nil {
+		return errNoSegmentMetadata
+	}
+	if err := validateSegmentMetadata(md); err != nil {
+		return err
+	}
+	cxn.params.Metadata.Set(md)
+	return nil
+}
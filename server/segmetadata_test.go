@@ -0,0 +1,96 @@
+package server
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/net"
+	"github.com/livepeer/lpms/stream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateSegmentMetadata(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(validateSegmentMetadata(nil))
+	assert.Nil(validateSegmentMetadata(map[string]string{"viewer": "viewer1", "epoch": ""}))
+	assert.EqualError(validateSegmentMetadata(map[string]string{"": "x"}), "empty metadata key")
+	err := validateSegmentMetadata(map[string]string{"viewer": strings.Repeat("x", MaxSegmentMetadataSize)})
+	assert.EqualError(err, "metadata too large size=1033 max=1024")
+}
+
+func TestSetSegmentMetadata(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	s := setupServer()
+	defer serverCleanup(s)
+
+	mid := core.ManifestID(t.Name())
+	md := map[string]string{"epoch": "1"}
+	assert.Equal(errUnknownStream, s.setSegmentMetadata(mid, md))
+
+	// streams started without metadata do not get any
+	cxn, err := s.registerConnection(stream.NewBasicRTMPVideoStream(&core.StreamParameters{ManifestID: mid + "_nomd"}))
+	require.Nil(err)
+	assert.Equal(errNoSegmentMetadata, s.setSegmentMetadata(mid+"_nomd", md))
+	assert.Nil(cxn.params.Metadata)
+
+	params := &core.StreamParameters{ManifestID: mid, Metadata: core.NewSegmentMetadata(map[string]string{"epoch": "0"})}
+	cxn, err = s.registerConnection(stream.NewBasicRTMPVideoStream(params))
+	require.Nil(err)
+	assert.EqualError(s.setSegmentMetadata(mid, map[string]string{"": "x"}), "empty metadata key")
+	require.Nil(s.setSegmentMetadata(mid, md))
+	assert.Equal(md, cxn.params.Metadata.Get())
+
+	// sent along the segments that follow
+	sess := &BroadcastSession{Broadcaster: stubBroadcaster2(), Params: cxn.params}
+	data, err := genSegCreds(sess, &stream.HLSSegment{Data: []byte("foo")})
+	require.Nil(err)
+	buf, err := base64.StdEncoding.DecodeString(data)
+	require.Nil(err)
+	var segData net.SegData
+	require.Nil(proto.Unmarshal(buf, &segData))
+	assert.Equal(md, segData.Metadata)
+	segMd, err := coreSegMetadata(&segData)
+	require.Nil(err)
+	assert.Equal(md, segMd.Metadata)
+}
+
+func TestCreateRTMPStreamHandler_SegmentMetadata(t *testing.T) {
+	assert := assert.New(t)
+	s := setupServer()
+	defer serverCleanup(s)
+	createSid := createRTMPStreamIDHandler(s)
+	u, _ := url.Parse("http://hot/something/id1")
+
+	var resp string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(resp))
+	}))
+	defer ts.Close()
+	defer func(u string) { AuthWebhookURL = u }(AuthWebhookURL)
+	AuthWebhookURL = ts.URL
+
+	resp = `{"manifestID":"a"}`
+	params := createSid(u).(*core.StreamParameters)
+	assert.Nil(params.Metadata)
+
+	resp = `{"manifestID":"a", "metadata": {}}`
+	params = createSid(u).(*core.StreamParameters)
+	assert.NotNil(params.Metadata)
+	assert.Empty(params.Metadata.Get())
+
+	resp = `{"manifestID":"a", "metadata": {"viewer": "viewer1"}}`
+	params = createSid(u).(*core.StreamParameters)
+	assert.Equal(map[string]string{"viewer": "viewer1"}, params.Metadata.Get())
+
+	resp = `{"manifestID":"a", "metadata": {"": "viewer1"}}`
+	assert.Nil(createSid(u))
+}
@@ -0,0 +1,200 @@
+package server
+
+import (
+	"sync"
+
+	"github.com/livepeer/go-livepeer/core"
+)
+
+var errSegmentQueueFull = newError(ErrorCategoryIngest, true, "ErrSegmentQueueFull")
+
+// SegmentWorkers, if set, bounds the number of segments processed at once by
+// the node. Segments are otherwise processed on their own goroutines.
+var SegmentWorkers *SegmentPool
+
+// SegmentPool is a fixed set of workers processing segments. Each stream has
+// its own FIFO queue and workers take segments from the streams in round
+// robin order, so that a stream sending a burst of segments doesn't hold up
+// every other stream on the node. Streams of a higher priority class are
+// served before any stream of a lower one.
+type SegmentPool struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queues map[string]*segmentQueue
+	// streams with queued segments, in service order, by priority class
+	ready    [core.NumStreamPriorities][]string
+	queued   int
+	busy     int
+	maxQueue int
+}
+
+type segmentQueue struct {
+	prio core.StreamPriority
+	segs []func()
+}
+
+// NewSegmentPool starts `workers` workers. At most `maxQueue` segments may
+// wait for a worker per stream.
+func NewSegmentPool(workers, maxQueue int) *SegmentPool {
+	p := &SegmentPool{
+		queues:   make(map[string]*segmentQueue),
+		maxQueue: maxQueue,
+	}
+	p.cond = sync.NewCond(&p.mu)
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	return p
+}
+
+// Submit queues `f` to be run for the stream, of priority class `prio`, and
+// returns immediately. It returns an error if the stream's queue is full.
+// The priority class of the latest submission applies to the segments
+// already queued for the stream too.
+func (p *SegmentPool) Submit(stream string, prio core.StreamPriority, f func()) error {
+	if p == nil {
+		go f()
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	q, ok := p.queues[stream]
+	if !ok {
+		q = &segmentQueue{prio: prio}
+	}
+	if len(q.segs) >= p.maxQueue {
+		return errSegmentQueueFull
+	}
+	if !ok {
+		i := readyIndex(prio)
+		p.queues[stream] = q
+		p.ready[i] = append(p.ready[i], stream)
+	} else if from, to := readyIndex(q.prio), readyIndex(prio); from != to {
+		// Reclassified streams go to the back of their new class
+		p.removeReady(from, stream)
+		p.ready[to] = append(p.ready[to], stream)
+	}
+	q.prio = prio
+	q.segs = append(q.segs, f)
+	p.queued++
+	p.cond.Signal()
+	return nil
+}
+
+// Do runs `f` for the stream, of priority class `prio`, on a worker and waits
+// for it to complete. It returns an error without running `f` if the
+// stream's queue is full.
+func (p *SegmentPool) Do(stream string, prio core.StreamPriority, f func()) error {
+	if p == nil {
+		f()
+		return nil
+	}
+	done := make(chan struct{})
+	err := p.Submit(stream, prio, func() {
+		defer close(done)
+		f()
+	})
+	if err != nil {
+		return err
+	}
+	<-done
+	return nil
+}
+
+// Queued returns the number of segments waiting for a worker
+func (p *SegmentPool) Queued() int {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.queued
+}
+
+// StreamQueued returns the number of segments of the stream waiting for a worker
+func (p *SegmentPool) StreamQueued(stream string) int {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if q, ok := p.queues[stream]; ok {
+		return len(q.segs)
+	}
+	return 0
+}
+
+// Busy returns the number of workers processing a segment
+func (p *SegmentPool) Busy() int {
+	if p == nil {
+		return 0
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.busy
+}
+
+func (p *SegmentPool) work() {
+	for {
+		p.mu.Lock()
+		stream, i := p.next()
+		for i < 0 {
+			p.cond.Wait()
+			stream, i = p.next()
+		}
+		p.ready[i] = p.ready[i][1:]
+		q := p.queues[stream]
+		f := q.segs[0]
+		q.segs[0] = nil
+		if q.segs = q.segs[1:]; len(q.segs) > 0 {
+			// Go to the back of the line for the next segment
+			j := readyIndex(q.prio)
+			p.ready[j] = append(p.ready[j], stream)
+		} else {
+			delete(p.queues, stream)
+		}
+		p.queued--
+		p.busy++
+		p.mu.Unlock()
+
+		f()
+
+		p.mu.Lock()
+		p.busy--
+		p.mu.Unlock()
+	}
+}
+
+// next returns the stream to serve next and the index of its ready list, or
+// -1 if no segments are queued. Caller must hold the lock.
+func (p *SegmentPool) next() (string, int) {
+	for i := len(p.ready) - 1; i >= 0; i-- {
+		if len(p.ready[i]) > 0 {
+			return p.ready[i][0], i
+		}
+	}
+	return "", -1
+}
+
+// removeReady removes the stream from the ready list at index `i`. Caller
+// must hold the lock.
+func (p *SegmentPool) removeReady(i int, stream string) {
+	for j, s := range p.ready[i] {
+		if s == stream {
+			p.ready[i] = append(p.ready[i][:j], p.ready[i][j+1:]...)
+			return
+		}
+	}
+}
+
+// readyIndex returns the index of the ready list of the priority class
+func readyIndex(prio core.StreamPriority) int {
+	i := int(prio - core.PriorityLow)
+	if i < 0 {
+		return 0
+	}
+	if i >= core.NumStreamPriorities {
+		return core.NumStreamPriorities - 1
+	}
+	return i
+}
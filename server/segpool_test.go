@@ -0,0 +1,171 @@
+package server
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSegmentPool_Nil(t *testing.T) {
+	assert := assert.New(t)
+	var p *SegmentPool
+	ran := false
+	assert.Nil(p.Do("a", core.PriorityNormal, func() { ran = true }))
+	assert.True(ran)
+
+	done := make(chan struct{})
+	assert.Nil(p.Submit("a", core.PriorityNormal, func() { close(done) }))
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("segment was not processed")
+	}
+	assert.Equal(0, p.Queued())
+	assert.Equal(0, p.StreamQueued("a"))
+	assert.Equal(0, p.Busy())
+}
+
+func TestSegmentPool_Fairness(t *testing.T) {
+	assert := assert.New(t)
+	p := NewSegmentPool(1, 3)
+
+	// hold the only worker until everything is queued
+	gate := make(chan struct{})
+	assert.Nil(p.Submit("x", core.PriorityNormal, func() { <-gate }))
+	for p.Busy() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	run := func(name string) func() {
+		wg.Add(1)
+		return func() {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			wg.Done()
+		}
+	}
+	assert.Nil(p.Submit("a", core.PriorityNormal, run("a1")))
+	assert.Nil(p.Submit("a", core.PriorityNormal, run("a2")))
+	assert.Nil(p.Submit("a", core.PriorityNormal, run("a3")))
+	assert.Nil(p.Submit("b", core.PriorityNormal, run("b1")))
+	assert.Equal(errSegmentQueueFull, p.Submit("a", core.PriorityNormal, func() {}))
+	assert.Equal(errSegmentQueueFull, p.Do("a", core.PriorityNormal, func() {}))
+	assert.Equal(4, p.Queued())
+	assert.Equal(3, p.StreamQueued("a"))
+	assert.Equal(1, p.StreamQueued("b"))
+
+	// a stream with a backlog doesn't hold up the others
+	close(gate)
+	assert.True(wgWait(&wg))
+	assert.Equal([]string{"a1", "b1", "a2", "a3"}, order)
+	assert.Equal(0, p.Queued())
+	assert.Equal(0, p.StreamQueued("a"))
+}
+
+func TestSegmentPool_Priority(t *testing.T) {
+	assert := assert.New(t)
+	p := NewSegmentPool(1, 3)
+
+	gate := make(chan struct{})
+	assert.Nil(p.Submit("x", core.PriorityNormal, func() { <-gate }))
+	for p.Busy() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	run := func(name string) func() {
+		wg.Add(1)
+		return func() {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			wg.Done()
+		}
+	}
+	assert.Nil(p.Submit("low", core.PriorityLow, run("l1")))
+	assert.Nil(p.Submit("a", core.PriorityNormal, run("a1")))
+	assert.Nil(p.Submit("a", core.PriorityNormal, run("a2")))
+	assert.Nil(p.Submit("high", core.PriorityHigh, run("h1")))
+	assert.Nil(p.Submit("high", core.PriorityHigh, run("h2")))
+	assert.Nil(p.Submit("b", core.PriorityNormal, run("b1")))
+
+	// higher classes first, round robin within a class
+	close(gate)
+	assert.True(wgWait(&wg))
+	assert.Equal([]string{"h1", "h2", "a1", "b1", "a2", "l1"}, order)
+	assert.Equal(0, p.Queued())
+}
+
+func TestSegmentPool_Reprioritize(t *testing.T) {
+	assert := assert.New(t)
+	p := NewSegmentPool(1, 3)
+
+	gate := make(chan struct{})
+	assert.Nil(p.Submit("x", core.PriorityNormal, func() { <-gate }))
+	for p.Busy() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	run := func(name string) func() {
+		wg.Add(1)
+		return func() {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			wg.Done()
+		}
+	}
+	assert.Nil(p.Submit("b", core.PriorityNormal, run("b1")))
+	assert.Nil(p.Submit("a", core.PriorityNormal, run("a1")))
+	assert.Nil(p.Submit("c", core.PriorityHigh, run("c1")))
+	// the latest priority class applies to the segments already queued
+	assert.Nil(p.Submit("a", core.PriorityHigh, run("a2")))
+	assert.Nil(p.Submit("c", core.PriorityLow, run("c2")))
+
+	close(gate)
+	assert.True(wgWait(&wg))
+	assert.Equal([]string{"a1", "a2", "b1", "c1", "c2"}, order)
+	assert.Equal(0, p.Queued())
+}
+
+func TestSegmentPool_Do(t *testing.T) {
+	assert := assert.New(t)
+	p := NewSegmentPool(2, 10)
+
+	var mu sync.Mutex
+	running, maxRunning := 0, 0
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := p.Do("a", core.PriorityNormal, func() {
+				mu.Lock()
+				running++
+				if running > maxRunning {
+					maxRunning = running
+				}
+				mu.Unlock()
+				time.Sleep(5 * time.Millisecond)
+				mu.Lock()
+				running--
+				mu.Unlock()
+			})
+			assert.Nil(err)
+		}()
+	}
+	assert.True(wgWait(&wg))
+	assert.Equal(2, maxRunning)
+}
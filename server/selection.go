@@ -7,6 +7,7 @@ import (
 	ethcommon "github.com/ethereum/go-ethereum/common"
 	"github.com/golang/glog"
 	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/core"
 )
 
 // BroadcastSessionsSelector selects the next BroadcastSession to use
@@ -94,6 +95,11 @@ type MinLSSelector struct {
 	stakeRdr stakeReader
 
 	minLS float64
+
+	// weights sessions without a latency score are scored by; zero for a
+	// stake weighted random selection
+	weights core.SelectionWeights
+	scores  []SessionScore
 }
 
 // NewMinLSSelector returns an instance of MinLSSelector configured with a good enough latency score
@@ -108,6 +114,15 @@ func NewMinLSSelector(stakeRdr stakeReader, minLS float64) *MinLSSelector {
 	}
 }
 
+// NewMinLSSelectorWithWeights returns an instance of MinLSSelector that selects
+// the session with the highest score by `weights` among those without a
+// latency score
+func NewMinLSSelectorWithWeights(stakeRdr stakeReader, minLS float64, weights core.SelectionWeights) *MinLSSelector {
+	sel := NewMinLSSelector(stakeRdr, minLS)
+	sel.weights = weights
+	return sel
+}
+
 // Add adds the sessions to the selector's list of sessions without a latency score
 func (s *MinLSSelector) Add(sessions []*BroadcastSession) {
 	s.unknownSessions = append(s.unknownSessions, sessions...)
@@ -144,14 +159,20 @@ func (s *MinLSSelector) Clear() {
 	s.unknownSessions = nil
 	s.knownSessions = &sessHeap{}
 	s.stakeRdr = nil
+	s.scores = nil
 }
 
-// Use stake weighted random selection to select from unknownSessions
+// Use stake weighted random selection to select from unknownSessions, unless
+// the selector scores them
 func (s *MinLSSelector) selectUnknownSession() *BroadcastSession {
 	if len(s.unknownSessions) == 0 {
 		return nil
 	}
 
+	if !s.weights.IsZero() {
+		return s.selectScoredSession()
+	}
+
 	if s.stakeRdr == nil {
 		// Sessions are selected based on the order of unknownSessions in off-chain mode
 		sess := s.unknownSessions[0]
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/core"
+)
+
+// ServiceURICheckers are services that check, from outside the network of the
+// orchestrator, that its service URI is reachable. Each is sent a POST of
+// {"serviceURI": "..."} and answers {"reachable": bool, "error": "..."}.
+// Service URIs are validated with them before being registered on chain.
+var ServiceURICheckers []*url.URL
+
+// ServiceURICheckInterval is how often the service URI is validated again
+// with ServiceURICheckers
+var ServiceURICheckInterval = time.Hour
+
+var serviceURICheckClient = &http.Client{Timeout: 30 * time.Second}
+
+type serviceURICheckResponse struct {
+	Reachable bool   `json:"reachable"`
+	Error     string `json:"error"`
+}
+
+// ServiceURIHealth is the outcome of the last validation of the service URI,
+// as served at /serviceURIHealth
+type ServiceURIHealth struct {
+	ServiceURI string `json:"serviceURI"`
+	Reachable  bool   `json:"reachable"`
+	Error      string `json:"error,omitempty"`
+	// Unix time of the validation
+	CheckedAt int64 `json:"checkedAt"`
+}
+
+var serviceURIHealth struct {
+	mu   sync.Mutex
+	last *ServiceURIHealth
+}
+
+// ValidateServiceURI asks ServiceURICheckers whether `uri` is reachable.
+// Fails if any of them can't reach it, or if none of them answered. Always
+// succeeds without checkers.
+func ValidateServiceURI(uri string) error {
+	if len(ServiceURICheckers) == 0 {
+		return nil
+	}
+	err := validateServiceURI(uri)
+	health := &ServiceURIHealth{ServiceURI: uri, Reachable: err == nil, CheckedAt: clock.Now().Unix()}
+	if err != nil {
+		health.Error = err.Error()
+	}
+	serviceURIHealth.mu.Lock()
+	serviceURIHealth.last = health
+	serviceURIHealth.mu.Unlock()
+	return err
+}
+
+func validateServiceURI(uri string) error {
+	var answered int
+	for _, checker := range ServiceURICheckers {
+		resp, err := checkServiceURI(checker, uri)
+		if err != nil {
+			glog.Warningf("Unable to check service URI checker=%s err=%v", common.RedactURL(checker.String()), err)
+			continue
+		}
+		answered++
+		if !resp.Reachable {
+			return fmt.Errorf("service URI %s is unreachable from %s: %s", uri, checker.Host, resp.Error)
+		}
+	}
+	if answered == 0 {
+		return errors.New("no service URI checker answered")
+	}
+	return nil
+}
+
+func checkServiceURI(checker *url.URL, uri string) (*serviceURICheckResponse, error) {
+	body, err := json.Marshal(map[string]string{"serviceURI": uri})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := serviceURICheckClient.Post(checker.String(), "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status=%d body=%s", resp.StatusCode, data)
+	}
+	var res serviceURICheckResponse
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// lastServiceURIHealth returns the outcome of the last validation of the
+// service URI, nil if it wasn't validated
+func lastServiceURIHealth() *ServiceURIHealth {
+	serviceURIHealth.mu.Lock()
+	defer serviceURIHealth.mu.Unlock()
+	return serviceURIHealth.last
+}
+
+// WatchServiceURI validates the service URI of the node with
+// ServiceURICheckers right away and every ServiceURICheckInterval until `ctx`
+// is done, flagging it when it can't be reached from outside, e.g. due to NAT
+// or firewall misconfigurations
+func WatchServiceURI(ctx context.Context, n *core.LivepeerNode) {
+	if len(ServiceURICheckers) == 0 {
+		return
+	}
+	timer := clock.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C():
+		}
+		uri := n.GetServiceURI().String()
+		if err := ValidateServiceURI(uri); err != nil {
+			glog.Errorf("Service URI failed validation; broadcasters may be unable to reach the orchestrator. Check NAT and firewall configuration serviceURI=%s err=%v", uri, err)
+		} else {
+			glog.V(common.DEBUG).Infof("Service URI validated serviceURI=%s", uri)
+		}
+		if ServiceURICheckInterval <= 0 {
+			return
+		}
+		timer.Reset(ServiceURICheckInterval)
+	}
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateServiceURI(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	c := useFakeClock(t)
+
+	defer func(checkers []*url.URL, interval time.Duration) {
+		ServiceURICheckers, ServiceURICheckInterval = checkers, interval
+		serviceURIHealth.last = nil
+	}(ServiceURICheckers, ServiceURICheckInterval)
+
+	// anything goes without checkers
+	ServiceURICheckers = nil
+	assert.Nil(ValidateServiceURI("https://127.0.0.1:8935"))
+	assert.Nil(lastServiceURIHealth())
+
+	var reachable, checks int32 = 1, 0
+	checker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&checks, 1)
+		var req map[string]string
+		assert.Nil(json.NewDecoder(r.Body).Decode(&req))
+		assert.Equal("https://1.2.3.4:8935", req["serviceURI"])
+		if atomic.LoadInt32(&reachable) == 1 {
+			w.Write([]byte(`{"reachable":true}`))
+			return
+		}
+		w.Write([]byte(`{"reachable":false,"error":"connection refused"}`))
+	}))
+	defer checker.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+	checkerURL, _ := url.Parse(checker.URL)
+	brokenURL, _ := url.Parse(broken.URL)
+
+	// checkers that fail are ignored, as long as one answered
+	ServiceURICheckers = []*url.URL{brokenURL, checkerURL}
+	require.Nil(ValidateServiceURI("https://1.2.3.4:8935"))
+	assert.Equal(&ServiceURIHealth{ServiceURI: "https://1.2.3.4:8935", Reachable: true, CheckedAt: c.Now().Unix()}, lastServiceURIHealth())
+
+	atomic.StoreInt32(&reachable, 0)
+	err := ValidateServiceURI("https://1.2.3.4:8935")
+	assert.EqualError(err, "service URI https://1.2.3.4:8935 is unreachable from "+checkerURL.Host+": connection refused")
+	assert.False(lastServiceURIHealth().Reachable)
+	assert.Equal(err.Error(), lastServiceURIHealth().Error)
+
+	ServiceURICheckers = []*url.URL{brokenURL}
+	assert.EqualError(ValidateServiceURI("https://1.2.3.4:8935"), "no service URI checker answered")
+
+	// the service URI of the node is validated periodically
+	ServiceURICheckers = []*url.URL{checkerURL}
+	ServiceURICheckInterval = time.Minute
+	n, _ := core.NewLivepeerNode(nil, "", nil)
+	serviceURI, _ := url.Parse("https://1.2.3.4:8935")
+	n.SetServiceURI(serviceURI)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	atomic.StoreInt32(&checks, 0)
+	atomic.StoreInt32(&reachable, 1)
+	go WatchServiceURI(ctx, n)
+	assert.True(c.waitTimerBy(c.Now()))
+	c.Advance(0)
+	assert.True(c.waitTimerBy(c.Now().Add(ServiceURICheckInterval)))
+	assert.Equal(int32(1), atomic.LoadInt32(&checks))
+	assert.True(lastServiceURIHealth().Reachable)
+	atomic.StoreInt32(&reachable, 0)
+	c.Advance(ServiceURICheckInterval)
+	assert.True(c.waitTimerBy(c.Now().Add(ServiceURICheckInterval)))
+	assert.Equal(int32(2), atomic.LoadInt32(&checks))
+	assert.False(lastServiceURIHealth().Reachable)
+}
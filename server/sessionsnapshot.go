@@ -0,0 +1,198 @@
+package server
+
+import (
+	"context"
+	"math/big"
+	"sync"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/golang/glog"
+	"github.com/golang/protobuf/proto"
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/net"
+)
+
+// SessionSnapshotMaxAge, if non-zero, makes the broadcaster snapshot the
+// sessions of its streams with orchestrators in the node DB: the orchestrator
+// info with its auth token and ticket params, the nonce of the last ticket
+// sent and the credit with the orchestrator. A stream pushed again after the
+// broadcaster restarts resumes the sessions snapshotted within this long
+// rather than selecting orchestrators anew, so it keeps paying them from the
+// credit it has with them.
+var SessionSnapshotMaxAge time.Duration
+
+// sessionSnapshots snapshots the sessions of a stream. A nil
+// *sessionSnapshots snapshots nothing.
+type sessionSnapshots struct {
+	db  *common.DB
+	mid core.ManifestID
+
+	// Held while writing snapshots, so that none is written once the stream
+	// ended
+	mu    sync.Mutex
+	ended bool
+
+	// Whether the snapshots were restored; only done for the first sessions
+	// of the stream
+	restored bool
+}
+
+func newSessionSnapshots(db *common.DB, mid core.ManifestID) *sessionSnapshots {
+	if db == nil || SessionSnapshotMaxAge <= 0 {
+		return nil
+	}
+	return &sessionSnapshots{db: db, mid: mid}
+}
+
+// save snapshots `sess` after a segment was transcoded with it. The snapshot
+// is written in the background so as not to hold up the stream.
+func (ss *sessionSnapshots) save(sess *BroadcastSession) {
+	if ss == nil || sess.OrchestratorInfo == nil {
+		return
+	}
+	tinfo := sess.OrchestratorInfo
+	info, err := proto.Marshal(tinfo)
+	if err != nil {
+		glog.Errorf("Unable to encode session snapshot manifestID=%s orch=%s err=%v", ss.mid, tinfo.Transcoder, err)
+		return
+	}
+	snap := &common.DBBroadcastSession{
+		ManifestID:   string(ss.mid),
+		Orchestrator: tinfo.Transcoder,
+		Info:         info,
+		UpdatedAt:    clock.Now(),
+	}
+	if sess.Sender != nil && sess.PMSessionID != "" {
+		if snap.SenderNonce, err = sess.Sender.SenderNonce(sess.PMSessionID); err != nil {
+			glog.Errorf("Unable to snapshot sender nonce manifestID=%s orch=%s err=%v", ss.mid, tinfo.Transcoder, err)
+			return
+		}
+	}
+	if sess.Balances != nil && tinfo.TicketParams != nil && tinfo.AuthToken != nil {
+		addr := ethcommon.BytesToAddress(tinfo.TicketParams.Recipient)
+		if balance := sess.Balances.Balance(addr, core.ManifestID(tinfo.AuthToken.SessionId)); balance != nil {
+			snap.Balance = new(big.Rat).Set(balance)
+		}
+	}
+	go func() {
+		ss.mu.Lock()
+		defer ss.mu.Unlock()
+		if ss.ended {
+			return
+		}
+		if err := ss.db.UpdateBroadcastSession(snap); err != nil {
+			glog.Errorf("Unable to snapshot session manifestID=%s orch=%s err=%v", ss.mid, snap.Orchestrator, err)
+		}
+	}()
+}
+
+// drop removes the snapshot of `sess`, e.g. as it failed and won't be used
+// by the stream anymore
+func (ss *sessionSnapshots) drop(sess *BroadcastSession) {
+	if ss == nil {
+		return
+	}
+	orch := sess.OrchestratorInfo.GetTranscoder()
+	go func() {
+		ss.mu.Lock()
+		defer ss.mu.Unlock()
+		if err := ss.db.DeleteBroadcastSession(string(ss.mid), orch); err != nil {
+			glog.Errorf("Unable to drop session snapshot manifestID=%s orch=%s err=%v", ss.mid, orch, err)
+		}
+	}()
+}
+
+// clear removes the snapshots of the stream once it ended
+func (ss *sessionSnapshots) clear() {
+	if ss == nil {
+		return
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.ended = true
+	if err := ss.db.DeleteBroadcastSessions(string(ss.mid)); err != nil {
+		glog.Errorf("Unable to clear session snapshots manifestID=%s err=%v", ss.mid, err)
+	}
+}
+
+// restore returns the sessions of the stream snapshotted within
+// SessionSnapshotMaxAge, resuming their PM sessions from the last ticket sent
+// and crediting their balances. Sessions whose auth token or ticket params
+// expired are left out. Only the first call restores anything.
+func (ss *sessionSnapshots) restore(n *core.LivepeerNode, params *core.StreamParameters) []*BroadcastSession {
+	if ss == nil || ss.restored {
+		return nil
+	}
+	ss.restored = true
+	snaps, err := ss.db.SelectBroadcastSessions(string(ss.mid), clock.Now().Add(-SessionSnapshotMaxAge))
+	if err != nil {
+		glog.Errorf("Unable to restore session snapshots manifestID=%s err=%v", ss.mid, err)
+		return nil
+	}
+
+	var sessions []*BroadcastSession
+	for _, snap := range snaps {
+		tinfo := &net.OrchestratorInfo{}
+		if err := proto.Unmarshal(snap.Info, tinfo); err != nil {
+			glog.Errorf("Invalid session snapshot manifestID=%s orch=%s err=%v", ss.mid, snap.Orchestrator, err)
+			continue
+		}
+		if tinfo.AuthToken == nil || tinfo.AuthToken.Expiration <= clock.Now().Unix() {
+			glog.V(common.DEBUG).Infof("Not restoring session with expired auth token manifestID=%s orch=%s", ss.mid, snap.Orchestrator)
+			continue
+		}
+
+		var sessionID string
+		if n.Sender != nil && tinfo.TicketParams != nil {
+			ticketParams := pmTicketParams(tinfo.TicketParams)
+			if err := n.Sender.ValidateTicketParams(ticketParams); err != nil {
+				glog.V(common.DEBUG).Infof("Not restoring session with invalid ticket params manifestID=%s orch=%s err=%v", ss.mid, snap.Orchestrator, err)
+				continue
+			}
+			sessionID = n.Sender.ResumeSession(*ticketParams, snap.SenderNonce)
+		}
+
+		if n.Balances != nil && tinfo.TicketParams != nil && snap.Balance != nil {
+			// The stream may still have its balance if the node didn't restart
+			addr := ethcommon.BytesToAddress(tinfo.TicketParams.Recipient)
+			id := core.ManifestID(tinfo.AuthToken.SessionId)
+			if n.Balances.Balance(addr, id) == nil {
+				n.Balances.Credit(addr, id, snap.Balance)
+			}
+		}
+
+		sessions = append(sessions, newBroadcastSession(n, params, tinfo, sessionID))
+	}
+	if len(sessions) > 0 {
+		glog.Infof("Restored sessions manifestID=%s sessions=%d", ss.mid, len(sessions))
+	}
+	return sessions
+}
+
+// sessionSnapshotPruneInterval is how often session snapshots past
+// SessionSnapshotMaxAge are pruned
+var sessionSnapshotPruneInterval = time.Hour
+
+// pruneSessionSnapshots prunes the session snapshots in `db` past
+// SessionSnapshotMaxAge until `ctx` is done, e.g. of streams that were never
+// pushed again
+func pruneSessionSnapshots(ctx context.Context, db *common.DB) {
+	timer := clock.NewTimer(sessionSnapshotPruneInterval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C():
+		}
+		n, err := db.PruneBroadcastSessions(clock.Now().Add(-SessionSnapshotMaxAge))
+		if err != nil {
+			glog.Errorf("Unable to prune session snapshots err=%v", err)
+		} else if n > 0 {
+			glog.V(common.DEBUG).Infof("Pruned session snapshots sessions=%d", n)
+		}
+		timer.Reset(sessionSnapshotPruneInterval)
+	}
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/drivers"
+	"github.com/livepeer/go-livepeer/net"
+	"github.com/livepeer/go-livepeer/pm"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionSnapshots(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	c := useFakeClock(t)
+
+	dbh, dbraw, err := common.TempDB(t)
+	require.Nil(err)
+	defer dbh.Close()
+	defer dbraw.Close()
+
+	// snapshots are off without a max age or a DB
+	assert.Nil(newSessionSnapshots(dbh, "mid"))
+	defer func(age time.Duration) { SessionSnapshotMaxAge = age }(SessionSnapshotMaxAge)
+	SessionSnapshotMaxAge = time.Hour
+	assert.Nil(newSessionSnapshots(nil, "mid"))
+	var nilSnapshots *sessionSnapshots
+	nilSnapshots.save(StubBroadcastSession("https://o1"))
+	assert.Nil(nilSnapshots.restore(nil, nil))
+
+	newNode := func(sender pm.Sender) *core.LivepeerNode {
+		n, err := core.NewLivepeerNode(nil, "", dbh)
+		require.Nil(err)
+		n.Sender = sender
+		n.Balances = core.NewAddressBalances(time.Minute)
+		return n
+	}
+	params := &core.StreamParameters{ManifestID: "mid", OS: drivers.NewMemoryDriver(nil).NewSession("mid")}
+	orchInfo := func(transcoder string, expiration time.Duration) *net.OrchestratorInfo {
+		return &net.OrchestratorInfo{
+			Transcoder:   transcoder,
+			TicketParams: defaultTicketParams(),
+			AuthToken:    &net.AuthToken{Token: []byte("foo"), SessionId: transcoder, Expiration: c.Now().Add(expiration).Unix()},
+		}
+	}
+
+	sender := &pm.MockSender{}
+	sender.On("SenderNonce", "pm1").Return(7, nil)
+	sender.On("SenderNonce", "pm2").Return(1, nil)
+	n := newNode(sender)
+	defer n.Balances.StopCleanup()
+	sess1 := newBroadcastSession(n, params, orchInfo("https://o1", time.Hour), "pm1")
+	sess2 := newBroadcastSession(n, params, orchInfo("https://o2", time.Minute), "pm2")
+	addr1 := ethcommon.BytesToAddress(sess1.OrchestratorInfo.TicketParams.Recipient)
+	n.Balances.Credit(addr1, "https://o1", big.NewRat(5, 1))
+
+	ss := newSessionSnapshots(dbh, "mid")
+	require.NotNil(ss)
+	ss.save(sess1)
+	ss.save(sess2)
+	var snaps []*common.DBBroadcastSession
+	common.WaitAssert(t, time.Second, func() bool {
+		snaps, err = dbh.SelectBroadcastSessions("mid", c.Now())
+		return err == nil && len(snaps) == 2
+	}, "sessions not snapshotted")
+	assert.Equal(uint32(7), snaps[0].SenderNonce)
+	assert.Equal(big.NewRat(5, 1), snaps[0].Balance)
+	assert.Nil(snaps[1].Balance)
+
+	// after a restart, sessions are resumed from the last ticket sent and
+	// with their balance, unless their auth token expired
+	c.Advance(2 * time.Minute)
+	restarted := &pm.MockSender{}
+	restarted.On("ValidateTicketParams", mock.Anything).Return(nil)
+	restarted.On("ResumeSession", *pmTicketParams(sess1.OrchestratorInfo.TicketParams), uint32(7)).Return("pm1")
+	n = newNode(restarted)
+	defer n.Balances.StopCleanup()
+	ss = newSessionSnapshots(dbh, "mid")
+	sessions := ss.restore(n, params)
+	require.Len(sessions, 1)
+	assert.Equal("https://o1", sessions[0].OrchestratorInfo.Transcoder)
+	assert.Equal(sess1.OrchestratorInfo.AuthToken.Token, sessions[0].OrchestratorInfo.AuthToken.Token)
+	assert.Equal("pm1", sessions[0].PMSessionID)
+	assert.Equal(big.NewRat(5, 1), n.Balances.Balance(addr1, "https://o1"))
+	restarted.AssertExpectations(t)
+
+	// but only once
+	assert.Nil(ss.restore(n, params))
+
+	// sessions that failed are dropped, and all are once the stream ended
+	ss.drop(sess2)
+	common.WaitAssert(t, time.Second, func() bool {
+		snaps, err = dbh.SelectBroadcastSessions("mid", time.Time{})
+		return err == nil && len(snaps) == 1
+	}, "session not dropped")
+	ss.clear()
+	snaps, err = dbh.SelectBroadcastSessions("mid", time.Time{})
+	require.Nil(err)
+	assert.Empty(snaps)
+}
@@ -0,0 +1,322 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Warnings about the configuration of the encoder of a source stream. These
+// are the most common reasons for bad output, so they are logged, listed in
+// the stream's node status and sent to the stream event webhook.
+const (
+	SourceWarningLongGOP       = "long_gop"
+	SourceWarningVariableFPS   = "variable_frame_rate"
+	SourceWarningNonMonotonic  = "non_monotonic_timestamps"
+	SourceWarningAudioDrift    = "audio_drift"
+	sourceVariableFPSThreshold = 0.2 // share of irregular frame intervals
+)
+
+// sourceWarning is a problem found with the source of a stream
+type sourceWarning struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	SeqNo   uint64 `json:"seqNo"`
+}
+
+// sourceChecker looks for signs of a misconfigured encoder in the source
+// segments of a stream. Each kind of warning is reported once per stream.
+// A nil *sourceChecker checks nothing.
+type sourceChecker struct {
+	mid    string
+	notify func(w sourceWarning)
+	// duration the source is cut into segments at
+	segLen time.Duration
+
+	mu       sync.Mutex
+	warnings []sourceWarning
+	// decode timestamp of the last video frame seen, in 90kHz units
+	lastDTS  int64
+	haveLast bool
+	// offset of the audio from the video at the start of the first segment,
+	// and how far it has drifted from that since
+	avOffset     float64
+	haveAVOffset bool
+	avDrift      float64
+}
+
+func newSourceChecker(mid string, notify func(w sourceWarning)) *sourceChecker {
+	return &sourceChecker{mid: mid, notify: notify, segLen: SegLen}
+}
+
+// reset forgets the timestamps of the source, such as when the publisher
+// reconnects
+func (c *sourceChecker) reset() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.haveLast = false
+	c.haveAVOffset = false
+	c.avDrift = 0
+}
+
+// Warnings returns the messages of the warnings found so far
+func (c *sourceChecker) Warnings() []string {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var msgs []string
+	for _, w := range c.warnings {
+		msgs = append(msgs, w.Message)
+	}
+	return msgs
+}
+
+// AVDrift returns how far, in seconds, audio has drifted from video as of
+// the last segment
+func (c *sourceChecker) AVDrift() float64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.avDrift
+}
+
+// check analyzes the MPEG-TS source segment `data` of duration `dur` seconds
+func (c *sourceChecker) check(seqNo uint64, data []byte, dur float64) {
+	if c == nil {
+		return
+	}
+	ts := parseTSTimestamps(data)
+
+	c.mu.Lock()
+	var found []sourceWarning
+	warn := func(code, format string, args ...interface{}) {
+		for _, w := range c.warnings {
+			if w.Code == code {
+				return
+			}
+		}
+		w := sourceWarning{Code: code, Message: fmt.Sprintf(format, args...), SeqNo: seqNo}
+		c.warnings = append(c.warnings, w)
+		found = append(found, w)
+	}
+
+	// segments are cut at keyframes, so segments well past the target
+	// duration mean keyframes are further apart than that
+	if target, max := sourceSegmentDurations(c.segLen); max > 0 && dur > max {
+		warn(SourceWarningLongGOP, "segment of %.1fs is longer than %.1fs; set the keyframe interval of the encoder to %.0fs or less", dur, max, target)
+	}
+
+	if len(ts.video) > 0 {
+		prev, have := c.lastDTS, c.haveLast
+		var deltas []int64
+		for _, dts := range ts.video {
+			if have {
+				d := dts - prev
+				// 33 bit timestamps wrap around about every 26 hours
+				if d < -(1 << 32) {
+					d += 1 << 33
+				}
+				if d <= 0 {
+					warn(SourceWarningNonMonotonic, "video timestamps go backwards or repeat; check the encoder's timestamp settings")
+				} else {
+					deltas = append(deltas, d)
+				}
+			}
+			prev, have = dts, true
+		}
+		c.lastDTS, c.haveLast = prev, have
+		if irregularShare(deltas) > sourceVariableFPSThreshold {
+			warn(SourceWarningVariableFPS, "the source has a variable frame rate; configure the encoder for a constant frame rate")
+		}
+	}
+
+	if len(ts.video) > 0 && len(ts.audio) > 0 {
+		offset := float64(ts.audio[0]-ts.video[0]) / 90000
+		if !c.haveAVOffset {
+			c.avOffset, c.haveAVOffset = offset, true
+		}
+		c.avDrift = offset - c.avOffset
+		// drift that is corrected doesn't make for bad output
+		if limit := AVSyncDriftLimit.Seconds(); !AVSyncCorrection && (c.avDrift > limit || c.avDrift < -limit) {
+			warn(SourceWarningAudioDrift, "audio has drifted %.2fs from video; check the audio sample rate and clock of the encoder", c.avDrift)
+		}
+	}
+	c.mu.Unlock()
+
+	for _, w := range found {
+		glog.Warningf("Source encoder warning manifestID=%s seqNo=%d code=%s msg=%q", c.mid, seqNo, w.Code, w.Message)
+		if c.notify != nil {
+			c.notify(w)
+		}
+	}
+}
+
+// irregularShare is the share of frame intervals more than 10% off the median
+func irregularShare(deltas []int64) float64 {
+	if len(deltas) < 2 {
+		return 0
+	}
+	sorted := append([]int64(nil), deltas...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	median := sorted[len(sorted)/2]
+	irregular := 0
+	for _, d := range deltas {
+		if diff := d - median; diff*10 > median || -diff*10 > median {
+			irregular++
+		}
+	}
+	return float64(irregular) / float64(len(deltas))
+}
+
+// sourceSegmentDurations returns the duration in seconds that source segments
+// are cut at, given segments of `segLen` unless cut at keyframes, and the
+// duration beyond which the keyframe interval is too long, or 0 if there is
+// no such limit
+func sourceSegmentDurations(segLen time.Duration) (target, max float64) {
+	if KeyframeSegmentation != nil {
+		return KeyframeSegmentation.Min.Seconds(), KeyframeSegmentation.Max.Seconds()
+	}
+	return segLen.Seconds(), 1.5 * segLen.Seconds()
+}
+
+const tsPacketSize = 188
+
+// tsTimestamps are the decode timestamps of the video frames and the
+// presentation timestamps of the audio frames of an MPEG-TS segment, in
+// 90kHz units and in stream order
+type tsTimestamps struct {
+	video []int64
+	audio []int64
+	// presentation timestamps of the video frames flagged as random access
+	// points, i.e. keyframes
+	keyframes []int64
+}
+
+// parseTSTimestamps reads the PES timestamps of the first video and audio
+// streams of the first program of an MPEG-TS segment
+func parseTSTimestamps(data []byte) tsTimestamps {
+	var res tsTimestamps
+	pmtPID, videoPID, audioPID := -1, -1, -1
+	for off := 0; off+tsPacketSize <= len(data); off += tsPacketSize {
+		pkt := data[off : off+tsPacketSize]
+		if pkt[0] != 0x47 {
+			continue
+		}
+		pusi := pkt[1]&0x40 != 0
+		pid := int(pkt[1]&0x1f)<<8 | int(pkt[2])
+		afc := pkt[3] >> 4 & 0x3
+		if afc&0x1 == 0 || !pusi {
+			continue
+		}
+		start := 4
+		randomAccess := false
+		if afc&0x2 != 0 {
+			start += 1 + int(pkt[4])
+			randomAccess = pkt[4] > 0 && pkt[5]&0x40 != 0
+		}
+		if start >= tsPacketSize {
+			continue
+		}
+		payload := pkt[start:]
+		switch pid {
+		case 0:
+			if p := parsePAT(payload); p >= 0 {
+				pmtPID = p
+			}
+		case pmtPID:
+			videoPID, audioPID = parsePMT(payload)
+		case videoPID:
+			if ts, ok := pesTimestamp(payload, true); ok {
+				res.video = append(res.video, ts)
+			}
+			if ts, ok := pesTimestamp(payload, false); ok && randomAccess {
+				res.keyframes = append(res.keyframes, ts)
+			}
+		case audioPID:
+			if ts, ok := pesTimestamp(payload, false); ok {
+				res.audio = append(res.audio, ts)
+			}
+		}
+	}
+	return res
+}
+
+// psiSection returns the section of a PSI payload starting with a pointer field
+func psiSection(payload []byte) []byte {
+	if len(payload) < 1 || 1+int(payload[0])+3 > len(payload) {
+		return nil
+	}
+	sec := payload[1+int(payload[0]):]
+	length := int(sec[1]&0x0f)<<8 | int(sec[2])
+	if 3+length > len(sec) || length < 9 {
+		return nil
+	}
+	// drop the CRC
+	return sec[:3+length-4]
+}
+
+func parsePAT(payload []byte) int {
+	sec := psiSection(payload)
+	if sec == nil || sec[0] != 0x00 {
+		return -1
+	}
+	for i := 8; i+4 <= len(sec); i += 4 {
+		if program := int(sec[i])<<8 | int(sec[i+1]); program != 0 {
+			return int(sec[i+2]&0x1f)<<8 | int(sec[i+3])
+		}
+	}
+	return -1
+}
+
+func parsePMT(payload []byte) (video, audio int) {
+	video, audio = -1, -1
+	sec := psiSection(payload)
+	if sec == nil || sec[0] != 0x02 || len(sec) < 12 {
+		return
+	}
+	i := 12 + (int(sec[10]&0x0f)<<8 | int(sec[11]))
+	for ; i+5 <= len(sec); i += 5 + (int(sec[i+3]&0x0f)<<8 | int(sec[i+4])) {
+		pid := int(sec[i+1]&0x1f)<<8 | int(sec[i+2])
+		switch sec[i] {
+		case 0x01, 0x02, 0x1b, 0x24: // MPEG-1/2, H.264, HEVC
+			if video < 0 {
+				video = pid
+			}
+		case 0x03, 0x04, 0x0f, 0x11, 0x81: // MPEG audio, AAC, AC-3
+			if audio < 0 {
+				audio = pid
+			}
+		}
+	}
+	return
+}
+
+// pesTimestamp returns the DTS of a PES packet, or its PTS if there is no
+// DTS or `dts` is false
+func pesTimestamp(payload []byte, dts bool) (int64, bool) {
+	if len(payload) < 14 || payload[0] != 0 || payload[1] != 0 || payload[2] != 1 {
+		return 0, false
+	}
+	flags := payload[7] >> 6
+	if flags&0x2 == 0 {
+		return 0, false
+	}
+	if dts && flags == 0x3 && len(payload) >= 19 {
+		return readPESTimestamp(payload[14:19]), true
+	}
+	return readPESTimestamp(payload[9:14]), true
+}
+
+func readPESTimestamp(b []byte) int64 {
+	return int64(b[0]>>1&0x07)<<30 | int64(b[1])<<22 | int64(b[2]>>1)<<15 | int64(b[3])<<7 | int64(b[4]>>1)
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func tsTestPacket(pid int, payload []byte) []byte {
+	pkt := []byte{0x47, 0x40 | byte(pid>>8), byte(pid), 0x10}
+	pkt = append(pkt, payload...)
+	for len(pkt) < tsPacketSize {
+		pkt = append(pkt, 0xff)
+	}
+	return pkt
+}
+
+func tsTestTimestamp(prefix byte, ts int64) []byte {
+	b := make([]byte, 5)
+	writePESTimestamp(b, prefix, ts)
+	return b
+}
+
+// tsTestPSI is the PAT and PMT of a program with H.264 video on PID 0x101 and
+// AAC audio on PID 0x102
+func tsTestPSI() []byte {
+	pat := []byte{0x00, 0x00, 0xb0, 13, 0x00, 0x01, 0xc1, 0x00, 0x00, 0x00, 0x01, 0xe1, 0x00, 0, 0, 0, 0}
+	pmt := []byte{0x00, 0x02, 0xb0, 23, 0x00, 0x01, 0xc1, 0x00, 0x00, 0xe1, 0x01, 0xf0, 0x00,
+		0x1b, 0xe1, 0x01, 0xf0, 0x00,
+		0x0f, 0xe1, 0x02, 0xf0, 0x00,
+		0, 0, 0, 0}
+	return append(tsTestPacket(0, pat), tsTestPacket(0x100, pmt)...)
+}
+
+func tsTestVideo(pts, dts int64) []byte {
+	pes := []byte{0x00, 0x00, 0x01, 0xe0, 0x00, 0x00, 0x80, 0xc0, 10}
+	pes = append(pes, tsTestTimestamp(0x3, pts)...)
+	pes = append(pes, tsTestTimestamp(0x1, dts)...)
+	return tsTestPacket(0x101, pes)
+}
+
+func tsTestAudio(pts int64) []byte {
+	pes := []byte{0x00, 0x00, 0x01, 0xc0, 0x00, 0x00, 0x80, 0x80, 5}
+	pes = append(pes, tsTestTimestamp(0x2, pts)...)
+	return tsTestPacket(0x102, pes)
+}
+
+// tsTestSegment builds an MPEG-TS segment with H.264 video frames decoded at
+// `video` and AAC audio frames presented at `audio`
+func tsTestSegment(video, audio []int64) []byte {
+	data := tsTestPSI()
+	for _, dts := range video {
+		data = append(data, tsTestVideo(dts+3000, dts)...)
+	}
+	for _, pts := range audio {
+		data = append(data, tsTestAudio(pts)...)
+	}
+	return data
+}
+
+func tsTestFrames(start int64, deltas ...int64) []int64 {
+	ts := []int64{start}
+	for _, d := range deltas {
+		start += d
+		ts = append(ts, start)
+	}
+	return ts
+}
+
+func TestParseTSTimestamps(t *testing.T) {
+	assert := assert.New(t)
+
+	ts := parseTSTimestamps(tsTestSegment([]int64{90000, 93000, 1 << 32}, []int64{90100}))
+	assert.Equal([]int64{90000, 93000, 1 << 32}, ts.video)
+	assert.Equal([]int64{90100}, ts.audio)
+
+	// not MPEG-TS
+	ts = parseTSTimestamps([]byte("ftypisom"))
+	assert.Empty(ts.video)
+	assert.Empty(ts.audio)
+}
+
+func TestSourceChecker(t *testing.T) {
+	assert := assert.New(t)
+
+	var notified []string
+	c := newSourceChecker("mid", func(w sourceWarning) { notified = append(notified, w.Code) })
+
+	// constant frame rate, audio in sync
+	c.check(0, tsTestSegment(tsTestFrames(0, 3000, 3000, 3000, 3000), []int64{0, 1920}), 2)
+	c.check(1, tsTestSegment(tsTestFrames(15000, 3000, 3000, 3000), []int64{15000}), 2)
+	assert.Empty(notified)
+	assert.Nil(c.Warnings())
+
+	// keyframes too far apart
+	c.check(2, tsTestSegment(tsTestFrames(27000, 3000), []int64{27000}), 4)
+	assert.Equal([]string{SourceWarningLongGOP}, notified)
+
+	// frame intervals all over the place
+	c.check(3, tsTestSegment(tsTestFrames(33000, 3000, 4500, 1500, 3000, 6000), []int64{33000}), 2)
+	assert.Equal([]string{SourceWarningLongGOP, SourceWarningVariableFPS}, notified)
+
+	// timestamps going back to before the previous segment
+	c.check(4, tsTestSegment(tsTestFrames(20000, 3000), []int64{20000}), 2)
+	assert.Equal([]string{SourceWarningLongGOP, SourceWarningVariableFPS, SourceWarningNonMonotonic}, notified)
+
+	// audio drifting away from video
+	c.check(5, tsTestSegment(tsTestFrames(60000, 3000), []int64{60000 + 60000}), 2)
+	assert.Equal([]string{SourceWarningLongGOP, SourceWarningVariableFPS, SourceWarningNonMonotonic, SourceWarningAudioDrift}, notified)
+	assert.Len(c.Warnings(), 4)
+	assert.InDelta(0.667, c.AVDrift(), 0.001)
+
+	// each warning is reported once
+	c.check(6, tsTestSegment(tsTestFrames(0, 3000), []int64{90000}), 5)
+	assert.Len(notified, 4)
+
+	// timestamps start over after a reconnect
+	c = newSourceChecker("mid", func(w sourceWarning) { notified = append(notified, w.Code) })
+	notified = nil
+	c.check(0, tsTestSegment(tsTestFrames(90000, 3000), []int64{90000}), 2)
+	c.reset()
+	c.check(1, tsTestSegment(tsTestFrames(0, 3000), []int64{45000}), 2)
+	assert.Empty(notified)
+
+	// corrected drift is no problem
+	AVSyncCorrection = true
+	defer func() { AVSyncCorrection = false }()
+	c = newSourceChecker("mid", func(w sourceWarning) { notified = append(notified, w.Code) })
+	c.check(0, tsTestSegment(tsTestFrames(0, 3000), []int64{0}), 2)
+	c.check(1, tsTestSegment(tsTestFrames(6000, 3000), []int64{96000}), 2)
+	assert.Empty(notified)
+	assert.InDelta(1.0, c.AVDrift(), 0.001)
+
+	// nil checker checks nothing
+	var none *sourceChecker
+	none.check(0, nil, 10)
+	none.reset()
+	assert.Nil(none.Warnings())
+	assert.Zero(none.AVDrift())
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/lpms/ffmpeg"
+	"github.com/livepeer/lpms/stream"
+)
+
+// Actions on sources beyond the SourceCaps
+const (
+	SourceLimitReject    = "reject"
+	SourceLimitDownscale = "downscale"
+)
+
+// SourceCaps, if set, caps the resolution and frame rate of the sources of
+// streams, as orchestrators are paid by the pixels they transcode
+var SourceCaps *SourceLimits
+
+// SourceLimits is a maximum resolution and frame rate of sources, and whether
+// sources beyond them are rejected or downscaled by the broadcaster before
+// they are stored and transcoded. A nil *SourceLimits caps nothing.
+type SourceLimits struct {
+	// longer and shorter side of the largest resolution, so that it caps
+	// portrait and landscape sources alike; 0 for no cap
+	long, short int
+	// 0 for no cap
+	fps       uint
+	downscale bool
+}
+
+// NewSourceLimits returns limits of `resolution`, as WxH, and `fps`, either
+// being empty or 0 for no cap, on which sources beyond them get `action`
+func NewSourceLimits(resolution string, fps uint, action string) (*SourceLimits, error) {
+	l := &SourceLimits{fps: fps}
+	if resolution != "" {
+		w, h := parseResolution(resolution)
+		if w <= 0 || h <= 0 {
+			return nil, fmt.Errorf("invalid maximum source resolution %q", resolution)
+		}
+		l.long, l.short = sides(w, h)
+	}
+	switch action {
+	case SourceLimitReject:
+	case SourceLimitDownscale:
+		l.downscale = true
+	default:
+		return nil, fmt.Errorf("unknown source limit action %q", action)
+	}
+	return l, nil
+}
+
+func parseResolution(resolution string) (w, h int) {
+	fmt.Sscanf(resolution, "%dx%d", &w, &h)
+	return
+}
+
+func sides(w, h int) (long, short int) {
+	if w < h {
+		return h, w
+	}
+	return w, h
+}
+
+func (l *SourceLimits) String() string {
+	var caps []string
+	if l.long > 0 {
+		caps = append(caps, fmt.Sprintf("%dx%d", l.long, l.short))
+	}
+	if l.fps > 0 {
+		caps = append(caps, fmt.Sprintf("%dfps", l.fps))
+	}
+	return strings.Join(caps, " at ")
+}
+
+// overResolution tells whether a source of `w` by `h` is larger than the cap;
+// sources of unknown resolution are not
+func (l *SourceLimits) overResolution(w, h int) bool {
+	long, short := sides(w, h)
+	return l.long > 0 && short > 0 && (long > l.long || short > l.short)
+}
+
+// checkResolution rejects streams with a source resolution beyond the cap,
+// unless such sources are downscaled
+func (l *SourceLimits) checkResolution(resolution string) error {
+	if l == nil || l.downscale {
+		return nil
+	}
+	if l.overResolution(parseResolution(resolution)) {
+		return wrapError(ErrorCategoryIngest, false, fmt.Errorf("source resolution %s exceeds the maximum of %dx%d", resolution, l.long, l.short))
+	}
+	return nil
+}
+
+// apply checks the source segment `seg`, of a stream with a source of
+// `resolution` in `format`, against the caps. Segments beyond them are
+// rejected, or replaced with a downscaled version.
+func (l *SourceLimits) apply(resolution string, format ffmpeg.Format, seg *stream.HLSSegment) error {
+	if l == nil {
+		return nil
+	}
+	w, h := parseResolution(resolution)
+	var fps float64
+	if l.fps > 0 {
+		fps = sourceFrameRate(parseTSTimestamps(seg.Data))
+	}
+	overRes := l.overResolution(w, h)
+	overFPS := l.fps > 0 && fps > float64(l.fps)+0.5
+	if !overRes && !overFPS {
+		return nil
+	}
+	if !l.downscale {
+		return wrapError(ErrorCategoryIngest, false, fmt.Errorf("source of %s at %.2ffps exceeds the maximum of %v", resolution, fps, l))
+	}
+
+	p := ffmpeg.VideoProfile{Name: "source", Resolution: resolution, Format: format}
+	// bits per pixel of the source, kept for the downscaled source
+	scale := 1.0
+	if overRes {
+		long, short := sides(w, h)
+		s := math.Min(float64(l.long)/float64(long), float64(l.short)/float64(short))
+		nw, nh := int(float64(w)*s)&^1, int(float64(h)*s)&^1
+		p.Resolution = fmt.Sprintf("%dx%d", nw, nh)
+		scale = float64(nw*nh) / float64(w*h)
+	}
+	if overFPS {
+		p.Framerate = l.fps
+		scale *= float64(l.fps) / fps
+	}
+	p.Bitrate = "6000000"
+	if seg.Duration > 0 {
+		p.Bitrate = fmt.Sprint(int(float64(len(seg.Data)*8) / seg.Duration * scale))
+	}
+	data, err := downscaleSource(seg.Data, p)
+	if err != nil {
+		return fmt.Errorf("could not downscale source: %w", err)
+	}
+	glog.V(common.DEBUG).Infof("Downscaled source seqNo=%d from=%s@%.2ffps to=%s@%dfps", seg.SeqNo, resolution, fps, p.Resolution, p.Framerate)
+	seg.Data = data
+	return nil
+}
+
+// downscaleSource transcodes the source segment `data` into `p`
+var downscaleSource = func(data []byte, p ffmpeg.VideoProfile) ([]byte, error) {
+	ext, err := common.ProfileFormatExtension(p.Format)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := ioutil.TempDir("", "source")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+	in, out := filepath.Join(dir, "in"+ext), filepath.Join(dir, "out"+ext)
+	if err := ioutil.WriteFile(in, data, 0644); err != nil {
+		return nil, err
+	}
+	_, err = ffmpeg.Transcode3(&ffmpeg.TranscodeOptionsIn{Fname: in, Accel: ffmpeg.Software}, []ffmpeg.TranscodeOptions{{
+		Oname:        out,
+		Profile:      p,
+		Accel:        ffmpeg.Software,
+		AudioEncoder: ffmpeg.ComponentOptions{Name: "copy"},
+	}})
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(out)
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/livepeer/lpms/ffmpeg"
+	"github.com/livepeer/lpms/stream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSourceLimits(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := NewSourceLimits("1080p", 0, SourceLimitReject)
+	assert.EqualError(err, `invalid maximum source resolution "1080p"`)
+	_, err = NewSourceLimits("1920x1080", 0, "drop")
+	assert.EqualError(err, `unknown source limit action "drop"`)
+
+	l, err := NewSourceLimits("1080x1920", 30, SourceLimitDownscale)
+	assert.Nil(err)
+	assert.Equal("1920x1080 at 30fps", l.String())
+	l, err = NewSourceLimits("", 60, SourceLimitReject)
+	assert.Nil(err)
+	assert.Equal("60fps", l.String())
+}
+
+func TestSourceLimits_Reject(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil((*SourceLimits)(nil).checkResolution("3840x2160"))
+	assert.Nil((*SourceLimits)(nil).apply("3840x2160", ffmpeg.FormatNone, &stream.HLSSegment{}))
+
+	l, err := NewSourceLimits("1920x1080", 30, SourceLimitReject)
+	require.Nil(err)
+	assert.Nil(l.checkResolution("1920x1080"))
+	// portrait sources are capped alike
+	assert.Nil(l.checkResolution("1080x1920"))
+	assert.Nil(l.checkResolution("0x0"))
+	err = l.checkResolution("3840x2160")
+	assert.EqualError(err, "source resolution 3840x2160 exceeds the maximum of 1920x1080")
+	assert.Equal(ErrorCategoryIngest, errorCategory(err))
+	assert.False(isRetryable(err))
+
+	// frame rates are measured from the segments
+	seg := &stream.HLSSegment{Data: tsTestSegment(tsTestFrames(0, 3000, 3000, 3000), nil), Duration: 1}
+	assert.Nil(l.apply("1280x720", ffmpeg.FormatNone, seg))
+	seg.Data = tsTestSegment(tsTestFrames(0, 1500, 1500, 1500), nil)
+	err = l.apply("1280x720", ffmpeg.FormatNone, seg)
+	assert.EqualError(err, "source of 1280x720 at 60.00fps exceeds the maximum of 1920x1080 at 30fps")
+	assert.Equal(ErrorCategoryIngest, errorCategory(err))
+}
+
+func TestSourceLimits_Downscale(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	var profiles []ffmpeg.VideoProfile
+	var downscaleErr error
+	defer func(f func([]byte, ffmpeg.VideoProfile) ([]byte, error)) { downscaleSource = f }(downscaleSource)
+	downscaleSource = func(data []byte, p ffmpeg.VideoProfile) ([]byte, error) {
+		profiles = append(profiles, p)
+		return []byte("downscaled"), downscaleErr
+	}
+
+	l, err := NewSourceLimits("1920x1080", 30, SourceLimitDownscale)
+	require.Nil(err)
+	// not rejected at the start of the stream
+	assert.Nil(l.checkResolution("3840x2160"))
+
+	data := tsTestSegment(tsTestFrames(0, 1500, 1500, 1500), nil)
+	seg := &stream.HLSSegment{Data: data, Duration: 1}
+	require.Nil(l.apply("3840x2160", ffmpeg.FormatMPEGTS, seg))
+	assert.Equal("downscaled", string(seg.Data))
+	require.Len(profiles, 1)
+	assert.Equal("1920x1080", profiles[0].Resolution)
+	assert.Equal(uint(30), profiles[0].Framerate)
+	assert.Equal(ffmpeg.FormatMPEGTS, profiles[0].Format)
+	// as many bits per pixel as the source
+	assert.Equal(fmt.Sprint(int(float64(len(data)*8)*0.125)), profiles[0].Bitrate)
+
+	// within the frame rate cap, keeping the aspect ratio of portrait sources
+	seg = &stream.HLSSegment{Data: tsTestSegment(tsTestFrames(0, 3000, 3000, 3000), nil), Duration: 1}
+	require.Nil(l.apply("1440x2560", ffmpeg.FormatNone, seg))
+	require.Len(profiles, 2)
+	assert.Equal("1080x1920", profiles[1].Resolution)
+	assert.Zero(profiles[1].Framerate)
+
+	// sources within the caps are left alone
+	seg = &stream.HLSSegment{Data: data[:0], Duration: 1}
+	require.Nil(l.apply("1280x720", ffmpeg.FormatNone, seg))
+	assert.Len(profiles, 2)
+
+	downscaleErr = errors.New("no ffmpeg")
+	seg = &stream.HLSSegment{Data: data, Duration: 1}
+	assert.EqualError(l.apply("3840x2160", ffmpeg.FormatNone, seg), "could not downscale source: no ffmpeg")
+	assert.Equal(data, seg.Data)
+}
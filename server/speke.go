@@ -0,0 +1,151 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/core"
+)
+
+// SPEKEKeySource fetches the keys of encrypted streams from a key server
+// speaking SPEKE, the CPIX based protocol of DRM key providers. Each key
+// period of a stream is requested as its own content key, identified by the
+// manifest ID and a key ID derived from it, so the key server can hand the
+// same key to downstream packagers.
+//
+// Segments are still encrypted with AES-128 as a whole; the EXT-X-KEY tags
+// are annotated with the key URI and key format returned for the first DRM
+// system listed, so players and packagers fetch the key through the DRM
+// system rather than from this node.
+type SPEKEKeySource struct {
+	URL string
+	// DRM system IDs to request key information for, eg
+	// 94ce86fb-07ff-4f43-adb8-93d2fa968ca2 for FairPlay
+	SystemIDs []string
+	Client    *http.Client
+}
+
+// NewSPEKEKeySource returns a key source for the SPEKE endpoint at `url`
+func NewSPEKEKeySource(url string, systemIDs []string) *SPEKEKeySource {
+	return &SPEKEKeySource{
+		URL:       url,
+		SystemIDs: systemIDs,
+		Client:    &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// spekeKeyID derives a UUID for the key period so that repeated requests for
+// the same period ask for the same key
+func spekeKeyID(mid core.ManifestID, idx uint64) string {
+	h := sha256.Sum256([]byte(fmt.Sprintf("%s/%d", mid, idx)))
+	// version 4 style UUID
+	h[6] = (h[6] & 0x0f) | 0x40
+	h[8] = (h[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", h[0:4], h[4:6], h[6:8], h[8:10], h[10:16])
+}
+
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func (sp *SPEKEKeySource) request(mid core.ManifestID, kid string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, `<?xml version="1.0" encoding="UTF-8"?>
+<cpix:CPIX id="%s" xmlns:cpix="urn:dashif:org:cpix" xmlns:pskc="urn:ietf:params:xml:ns:keyprov:pskc" xmlns:speke="urn:aws:amazon:com:speke">
+<cpix:ContentKeyList><cpix:ContentKey kid="%s"></cpix:ContentKey></cpix:ContentKeyList>
+<cpix:DRMSystemList>`, xmlEscape(string(mid)), kid)
+	for _, sys := range sp.SystemIDs {
+		fmt.Fprintf(&b, `<cpix:DRMSystem kid="%s" systemId="%s"><cpix:URIExtXKey></cpix:URIExtXKey><speke:KeyFormat></speke:KeyFormat><speke:KeyFormatVersions></speke:KeyFormatVersions></cpix:DRMSystem>`,
+			kid, xmlEscape(sys))
+	}
+	b.WriteString("</cpix:DRMSystemList>\n</cpix:CPIX>\n")
+	return b.Bytes()
+}
+
+// cpixResponse holds the parts of a CPIX document used here. Elements are
+// matched by local name, whatever the namespace prefix.
+type cpixResponse struct {
+	ContentKeys []struct {
+		KID   string `xml:"kid,attr"`
+		Value string `xml:"Data>Secret>PlainValue"`
+	} `xml:"ContentKeyList>ContentKey"`
+	DRMSystems []struct {
+		KID               string `xml:"kid,attr"`
+		SystemID          string `xml:"systemId,attr"`
+		URIExtXKey        string `xml:"URIExtXKey"`
+		KeyFormat         string `xml:"KeyFormat"`
+		KeyFormatVersions string `xml:"KeyFormatVersions"`
+	} `xml:"DRMSystemList>DRMSystem"`
+}
+
+func decodeBase64Field(s string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	return string(b), err
+}
+
+// HLSKey requests the key of the `idx`th key period of `mid`
+func (sp *SPEKEKeySource) HLSKey(mid core.ManifestID, idx uint64) (*HLSKey, error) {
+	kid := spekeKeyID(mid, idx)
+	started := time.Now()
+	req, err := http.NewRequest("POST", sp.URL, bytes.NewReader(sp.request(mid, kid)))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/xml")
+	resp, err := sp.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("key server status=%d body=%s", resp.StatusCode, string(body))
+	}
+	var doc cpixResponse
+	if err := xml.Unmarshal(body, &doc); err != nil {
+		return nil, err
+	}
+
+	key := &HLSKey{}
+	for _, ck := range doc.ContentKeys {
+		if ck.KID != kid {
+			continue
+		}
+		if key.Key, err = base64.StdEncoding.DecodeString(ck.Value); err != nil {
+			return nil, fmt.Errorf("invalid content key: %v", err)
+		}
+	}
+	if key.Key == nil {
+		return nil, fmt.Errorf("no content key returned for kid=%s", kid)
+	}
+	for _, sys := range doc.DRMSystems {
+		if sys.KID != kid || sys.URIExtXKey == "" {
+			continue
+		}
+		if key.URI, err = decodeBase64Field(sys.URIExtXKey); err != nil {
+			return nil, fmt.Errorf("invalid URIExtXKey for systemId=%s: %v", sys.SystemID, err)
+		}
+		if key.KeyFormat, err = decodeBase64Field(sys.KeyFormat); err != nil {
+			return nil, fmt.Errorf("invalid KeyFormat for systemId=%s: %v", sys.SystemID, err)
+		}
+		if key.KeyFormatVersions, err = decodeBase64Field(sys.KeyFormatVersions); err != nil {
+			return nil, fmt.Errorf("invalid KeyFormatVersions for systemId=%s: %v", sys.SystemID, err)
+		}
+		break
+	}
+	glog.V(common.VERBOSE).Infof("Fetched HLS key manifestID=%s idx=%d kid=%s dur=%s", mid, idx, kid, time.Since(started))
+	return key, nil
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSPEKEKeySource(t *testing.T) {
+	assert := assert.New(t)
+
+	b64 := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+	var reqBody []byte
+	status := http.StatusOK
+	keyValue := b64("0123456789abcdef")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal("application/xml", r.Header.Get("Content-Type"))
+		reqBody, _ = ioutil.ReadAll(r.Body)
+		var req struct {
+			ID  string `xml:"id,attr"`
+			Key struct {
+				KID string `xml:"kid,attr"`
+			} `xml:"ContentKeyList>ContentKey"`
+		}
+		assert.Nil(xml.Unmarshal(reqBody, &req))
+		w.WriteHeader(status)
+		fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?>
+<cpix:CPIX id="%s" xmlns:cpix="urn:dashif:org:cpix" xmlns:pskc="urn:ietf:params:xml:ns:keyprov:pskc" xmlns:speke="urn:aws:amazon:com:speke">
+  <cpix:ContentKeyList>
+    <cpix:ContentKey kid="%s"><cpix:Data><pskc:Secret><pskc:PlainValue>%s</pskc:PlainValue></pskc:Secret></cpix:Data></cpix:ContentKey>
+  </cpix:ContentKeyList>
+  <cpix:DRMSystemList>
+    <cpix:DRMSystem kid="%s" systemId="94ce86fb-07ff-4f43-adb8-93d2fa968ca2">
+      <cpix:URIExtXKey>%s</cpix:URIExtXKey>
+      <speke:KeyFormat>%s</speke:KeyFormat>
+      <speke:KeyFormatVersions>%s</speke:KeyFormatVersions>
+    </cpix:DRMSystem>
+  </cpix:DRMSystemList>
+</cpix:CPIX>`, xmlEscape(req.ID), req.Key.KID, keyValue, req.Key.KID, b64("skd://mani"), b64("com.apple.streamingkeydelivery"), b64("1"))
+	}))
+	defer ts.Close()
+
+	sp := NewSPEKEKeySource(ts.URL, []string{"94ce86fb-07ff-4f43-adb8-93d2fa968ca2"})
+	key, err := sp.HLSKey("mani&co", 3)
+	require.Nil(t, err)
+	assert.Equal([]byte("0123456789abcdef"), key.Key)
+	assert.Equal("skd://mani", key.URI)
+	assert.Equal("com.apple.streamingkeydelivery", key.KeyFormat)
+	assert.Equal("1", key.KeyFormatVersions)
+
+	// the request names the stream and a key ID stable for the key period
+	assert.Contains(string(reqBody), `id="mani&amp;co"`)
+	assert.Contains(string(reqBody), `kid="`+spekeKeyID("mani&co", 3)+`"`)
+	assert.Contains(string(reqBody), `systemId="94ce86fb-07ff-4f43-adb8-93d2fa968ca2"`)
+	assert.Equal(spekeKeyID("mani&co", 3), spekeKeyID("mani&co", 3))
+	assert.NotEqual(spekeKeyID("mani&co", 3), spekeKeyID("mani&co", 4))
+	assert.Len(spekeKeyID("mani", 0), 36)
+
+	keyValue = "not base64"
+	_, err = sp.HLSKey("mani", 0)
+	assert.NotNil(err)
+
+	status = http.StatusForbidden
+	_, err = sp.HLSKey("mani", 0)
+	assert.Contains(err.Error(), "status=403")
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/m3u8"
+)
+
+// StartOverWindow, if non-zero, keeps the last StartOverWindow of the
+// recordings of live streams at hand to serve start over playlists from:
+// /stream/{manifestID}/startover.m3u8 and its media playlists play a live
+// stream from the start of the window, or from the time given by the start
+// query parameter, up to the live edge.
+var StartOverWindow time.Duration
+
+const startOverName = "startover"
+
+var errStartOverUnavailable = errors.New("start over not available")
+
+// startOverSegment is a segment of a start over playlist
+type startOverSegment struct {
+	seqNo         uint64
+	uri           string
+	duration      float64
+	discontinuity bool
+}
+
+// serveStartOver serves the start over playlists of the streams of the node,
+// passing other requests on to `next`
+func (s *LivepeerServer) serveStartOver(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mid, track, ok := parseStartOverPath(r.URL.Path)
+		if !ok || StartOverWindow <= 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		start, err := parseStartOverTime(r.URL.Query().Get("start"))
+		if err != nil {
+			glog.Errorf("Invalid start over time url=%s err=%v", common.RedactURL(r.URL.String()), err)
+			http.Error(w, "Invalid start time", http.StatusBadRequest)
+			return
+		}
+		cxn, ok := s.rtmpConnections.get(mid)
+		if !ok || cxn.pl == nil {
+			http.Error(w, "Stream not found", http.StatusNotFound)
+			return
+		}
+		var body []byte
+		if track == "" {
+			var master *m3u8.MasterPlaylist
+			if master, err = startOverMasterPlaylist(cxn, r.URL.Query().Get("start")); err == nil {
+				body = master.Encode().Bytes()
+			}
+		} else {
+			var mpl *m3u8.MediaPlaylist
+			if mpl, err = startOverMediaPlaylist(cxn, track, start, clock.Now()); err == nil {
+				body = mpl.Encode().Bytes()
+			}
+		}
+		if err != nil {
+			glog.Errorf("Unable to serve start over playlist url=%s err=%v", common.RedactURL(r.URL.String()), err)
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		w.Header().Set("Cache-Control", "max-age=1")
+		w.Header().Set("Content-Type", "application/x-mpegURL")
+		w.Write(body)
+	})
+}
+
+// parseStartOverPath returns the manifest ID of a start over playlist request
+// and the track requested, which is empty for the master playlist
+func parseStartOverPath(reqPath string) (core.ManifestID, string, bool) {
+	if !strings.HasPrefix(reqPath, "/stream/") || path.Ext(reqPath) != ".m3u8" {
+		return "", "", false
+	}
+	parts := strings.Split(strings.TrimSuffix(cleanStreamPrefix(reqPath), ".m3u8"), "/")
+	switch {
+	case len(parts) == 2 && parts[1] == startOverName:
+		return core.ManifestID(parts[0]), "", parts[0] != ""
+	case len(parts) == 3 && parts[1] == startOverName:
+		return core.ManifestID(parts[0]), parts[2], parts[0] != "" && parts[2] != ""
+	}
+	return "", "", false
+}
+
+// parseStartOverTime parses a start time given as a Unix timestamp or in
+// RFC 3339 format. The zero time is returned if `v` is empty.
+func parseStartOverTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+// startOverMasterPlaylist returns the master playlist of the start over
+// playlists of the recorded tracks of the stream, starting at `start`
+func startOverMasterPlaylist(cxn *rtmpConnection, start string) (*m3u8.MasterPlaylist, error) {
+	rec, err := startOverRecording(cxn)
+	if err != nil {
+		return nil, err
+	}
+	var query string
+	if start != "" {
+		query = "?start=" + url.QueryEscape(start)
+	}
+	master := m3u8.NewMasterPlaylist()
+	for _, track := range rec.Tracks {
+		uri := fmt.Sprintf("%s/%s.m3u8%s", startOverName, track.Name, query)
+		master.Append(uri, nil, m3u8.VariantParams{Bandwidth: track.Bandwidth, Resolution: track.Resolution})
+	}
+	return master, nil
+}
+
+// startOverMediaPlaylist returns the playlist of `track` from `start`, or
+// from StartOverWindow before `now` if later, up to the live edge
+func startOverMediaPlaylist(cxn *rtmpConnection, track string, start, now time.Time) (*m3u8.MediaPlaylist, error) {
+	if windowStart := now.Add(-StartOverWindow); start.Before(windowStart) {
+		start = windowStart
+	}
+	mpl, err := recordedMediaPlaylist(cxn, track, start, now)
+	if err != nil {
+		return nil, err
+	}
+	mpl.MediaType = m3u8.EVENT
+	return mpl, nil
+}
+
+// recordedMediaPlaylist returns the playlist of `track` from `start` up to
+// the live edge at `now`. The recorded segments are followed by the live ones
+// that have not been recorded yet.
+func recordedMediaPlaylist(cxn *rtmpConnection, track string, start, now time.Time) (*m3u8.MediaPlaylist, error) {
+	rec, err := startOverRecording(cxn)
+	if err != nil {
+		return nil, err
+	}
+	var segs []startOverSegment
+	for _, seg := range rec.Segments[track] {
+		segs = append(segs, startOverSegment{
+			seqNo:         seg.SeqNo,
+			uri:           recordingSegmentURI(cxn.params, seg.URI),
+			duration:      float64(seg.DurationMs) / 1000.0,
+			discontinuity: seg.Discontinuity,
+		})
+	}
+	segs = append(segs, liveSegmentsAfter(cxn.pl.GetHLSMediaPlaylist(track), segs)...)
+	if len(segs) == 0 {
+		return nil, fmt.Errorf("no segments for track=%s", track)
+	}
+
+	// Segment times are not recorded, so count back from the live edge
+	first := len(segs) - 1
+	end := now
+	for i := len(segs) - 1; i >= 0; i-- {
+		if !end.After(start) {
+			break
+		}
+		first = i
+		end = end.Add(-time.Duration(segs[i].duration * float64(time.Second)))
+	}
+	segs = segs[first:]
+
+	mpl, err := m3u8.NewMediaPlaylist(uint(len(segs)), uint(len(segs)))
+	if err != nil {
+		return nil, err
+	}
+	for _, seg := range segs {
+		mseg := &m3u8.MediaSegment{URI: seg.uri, Duration: seg.duration, Discontinuity: seg.discontinuity}
+		if err := mpl.InsertSegment(seg.seqNo, mseg); err != nil {
+			return nil, err
+		}
+	}
+	mpl.SeqNo = segs[0].seqNo
+	return mpl, nil
+}
+
+// startOverRecording returns the recording of the stream to build start over
+// playlists from
+func startOverRecording(cxn *rtmpConnection) (*core.JsonPlaylist, error) {
+	// Recorded segments are stored in the clear
+	if cxn.hlsKeys != nil {
+		return nil, errStartOverUnavailable
+	}
+	rec := cxn.pl.GetRecording()
+	if rec == nil {
+		return nil, errStartOverUnavailable
+	}
+	return rec, nil
+}
+
+// recordingSegmentURI returns the URI under /recordings/ of the segment
+// recorded at `uri`
+func recordingSegmentURI(params *core.StreamParameters, uri string) string {
+	if params == nil || params.RecordingID == "" {
+		return uri
+	}
+	if i := strings.Index(uri, string(params.RecordingID)+"/"); i != -1 {
+		return "/recordings/" + uri[i:]
+	}
+	return uri
+}
+
+// liveSegmentsAfter returns the segments of the live playlist that follow
+// `segs`
+func liveSegmentsAfter(live *m3u8.MediaPlaylist, segs []startOverSegment) []startOverSegment {
+	if live == nil {
+		return nil
+	}
+	var after []startOverSegment
+	for _, seg := range live.Segments {
+		if seg == nil || (len(segs) > 0 && seg.SeqId <= segs[len(segs)-1].seqNo) {
+			continue
+		}
+		after = append(after, startOverSegment{
+			seqNo:         seg.SeqId,
+			uri:           seg.URI,
+			duration:      seg.Duration,
+			discontinuity: seg.Discontinuity,
+		})
+	}
+	sort.Slice(after, func(i, j int) bool { return after[i].seqNo < after[j].seqNo })
+	return after
+}
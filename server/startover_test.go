@@ -0,0 +1,233 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/drivers"
+	"github.com/livepeer/lpms/ffmpeg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartOver_ParsePath(t *testing.T) {
+	assert := assert.New(t)
+
+	mid, track, ok := parseStartOverPath("/stream/mani/startover.m3u8")
+	assert.True(ok)
+	assert.Equal(core.ManifestID("mani"), mid)
+	assert.Empty(track)
+
+	mid, track, ok = parseStartOverPath("/stream/mani/startover/source.m3u8")
+	assert.True(ok)
+	assert.Equal(core.ManifestID("mani"), mid)
+	assert.Equal("source", track)
+
+	for _, p := range []string{
+		"/stream/mani.m3u8",
+		"/stream/mani/source.m3u8",
+		"/stream/mani/startover/source/1.ts",
+		"/stream/mani/startover/0.ts",
+		"/stream//startover.m3u8",
+		"/recordings/mani/startover.m3u8",
+	} {
+		_, _, ok = parseStartOverPath(p)
+		assert.False(ok, p)
+	}
+}
+
+func TestStartOver_ParseTime(t *testing.T) {
+	assert := assert.New(t)
+
+	start, err := parseStartOverTime("")
+	assert.Nil(err)
+	assert.True(start.IsZero())
+
+	start, err = parseStartOverTime("1600000000")
+	assert.Nil(err)
+	assert.Equal(time.Unix(1600000000, 0), start)
+
+	start, err = parseStartOverTime("2020-09-13T12:26:40Z")
+	assert.Nil(err)
+	assert.True(time.Unix(1600000000, 0).Equal(start))
+
+	_, err = parseStartOverTime("yesterday")
+	assert.NotNil(err)
+}
+
+func TestStartOver_RecordingSegmentURI(t *testing.T) {
+	assert := assert.New(t)
+	params := &core.StreamParameters{RecordingID: "recmid"}
+	assert.Equal("/recordings/recmid/source/1.ts", recordingSegmentURI(params, "https://store.example/bucket/recmid/source/1.ts"))
+	assert.Equal("/recordings/recmid/source/1.ts", recordingSegmentURI(params, "/stream/recmid/source/1.ts"))
+	assert.Equal("https://store.example/other/1.ts", recordingSegmentURI(params, "https://store.example/other/1.ts"))
+	assert.Equal("/stream/recmid/source/1.ts", recordingSegmentURI(&core.StreamParameters{}, "/stream/recmid/source/1.ts"))
+	assert.Equal("/stream/recmid/source/1.ts", recordingSegmentURI(nil, "/stream/recmid/source/1.ts"))
+}
+
+// startOverConnection returns a connection that recorded segments 1 to 4 of
+// its source and has segments 3 to 6 in its live playlist, all 2s long
+func startOverConnection(t *testing.T) *rtmpConnection {
+	profile := ffmpeg.P144p30fps16x9
+	profile.Name = "source"
+	storage := drivers.NewMemoryDriver(nil)
+	pl := core.NewBasicPlaylistManager("mani", storage.NewSession("mani"), storage.NewSession("recmid"))
+	for i := 1; i <= 4; i++ {
+		pl.InsertHLSSegmentJSON(&profile, uint64(i), fmt.Sprintf("https://store.example/recmid/source/%d.ts", i), 2, nil)
+	}
+	for i := 3; i <= 6; i++ {
+		require.Nil(t, pl.InsertHLSSegment(&profile, uint64(i), fmt.Sprintf("mani/source/%d.ts", i), 2))
+	}
+	return &rtmpConnection{
+		mid:    "mani",
+		pl:     pl,
+		params: &core.StreamParameters{ManifestID: "mani", RecordingID: "recmid"},
+	}
+}
+
+func TestStartOver_MediaPlaylist(t *testing.T) {
+	assert := assert.New(t)
+	oldWindow := StartOverWindow
+	defer func() { StartOverWindow = oldWindow }()
+	StartOverWindow = 10 * time.Second
+
+	cxn := startOverConnection(t)
+	now := time.Unix(1600000000, 0)
+	seqNos := func(start time.Time) []uint64 {
+		mpl, err := startOverMediaPlaylist(cxn, "source", start, now)
+		require.Nil(t, err)
+		var seqNos []uint64
+		for _, seg := range mpl.Segments {
+			if seg != nil {
+				seqNos = append(seqNos, seg.SeqId)
+			}
+		}
+		assert.Equal(seqNos[0], mpl.SeqNo)
+		return seqNos
+	}
+
+	// recorded segments followed by the live ones, trimmed to the window
+	mpl, err := startOverMediaPlaylist(cxn, "source", time.Time{}, now)
+	require.Nil(t, err)
+	out := mpl.String()
+	assert.Contains(out, "#EXT-X-PLAYLIST-TYPE:EVENT")
+	assert.Contains(out, "/recordings/recmid/source/2.ts")
+	assert.Contains(out, "/recordings/recmid/source/4.ts")
+	assert.NotContains(out, "mani/source/4.ts")
+	assert.Contains(out, "mani/source/6.ts")
+	assert.NotContains(out, "#EXT-X-ENDLIST")
+	assert.Equal([]uint64{2, 3, 4, 5, 6}, seqNos(time.Time{}))
+
+	// within the window from the start time on
+	assert.Equal([]uint64{5, 6}, seqNos(now.Add(-3*time.Second)))
+	assert.Equal([]uint64{6}, seqNos(now))
+	assert.Equal([]uint64{2, 3, 4, 5, 6}, seqNos(now.Add(-time.Hour)))
+	StartOverWindow = time.Hour
+	assert.Equal([]uint64{1, 2, 3, 4, 5, 6}, seqNos(time.Time{}))
+
+	_, err = startOverMediaPlaylist(cxn, "P144p30fps16x9", time.Time{}, now)
+	assert.NotNil(err)
+
+	// not with encrypted playlists
+	cxn.hlsKeys = newHLSKeyring("mani", 3, nil)
+	_, err = startOverMediaPlaylist(cxn, "source", time.Time{}, now)
+	assert.Equal(errStartOverUnavailable, err)
+}
+
+func TestStartOver_Handler(t *testing.T) {
+	assert := assert.New(t)
+	s := setupServer()
+	defer serverCleanup(s)
+	oldWindow := StartOverWindow
+	defer func() { StartOverWindow = oldWindow }()
+
+	s.rtmpConnections.store("mani", startOverConnection(t))
+	defer s.rtmpConnections.delete("mani")
+	s.rtmpConnections.store("unrecorded", &rtmpConnection{mid: "unrecorded", pl: core.NewBasicPlaylistManager("unrecorded", nil, nil)})
+	defer s.rtmpConnections.delete("unrecorded")
+
+	var passed bool
+	h := s.serveStartOver(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		passed = true
+	}))
+	serve := func(method, path string) *httptest.ResponseRecorder {
+		passed = false
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w
+	}
+
+	// passed on while disabled
+	StartOverWindow = 0
+	serve("GET", "/stream/mani/startover.m3u8")
+	assert.True(passed)
+
+	StartOverWindow = time.Hour
+	serve("GET", "/stream/mani/source.m3u8")
+	assert.True(passed)
+
+	w := serve("GET", "/stream/mani/startover.m3u8?start=1600000000")
+	assert.False(passed)
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Equal("application/x-mpegURL", w.Header().Get("Content-Type"))
+	assert.Contains(w.Body.String(), "startover/source.m3u8?start=1600000000")
+
+	w = serve("GET", "/stream/mani/startover/source.m3u8")
+	assert.Equal(http.StatusOK, w.Code)
+	assert.Contains(w.Body.String(), "/recordings/recmid/source/1.ts")
+
+	assert.Equal(http.StatusBadRequest, serve("GET", "/stream/mani/startover/source.m3u8?start=soon").Code)
+	assert.Equal(http.StatusMethodNotAllowed, serve("POST", "/stream/mani/startover.m3u8").Code)
+	assert.Equal(http.StatusNotFound, serve("GET", "/stream/mani/startover/P720p30fps16x9.m3u8").Code)
+	assert.Equal(http.StatusNotFound, serve("GET", "/stream/unknown/startover.m3u8").Code)
+	assert.Equal(http.StatusNotFound, serve("GET", "/stream/unrecorded/startover.m3u8").Code)
+}
+
+func TestStartOver_PlaybackSigning(t *testing.T) {
+	assert := assert.New(t)
+	s := setupServer()
+	defer serverCleanup(s)
+	oldWindow := StartOverWindow
+	defer func() { StartOverWindow = oldWindow }()
+	StartOverWindow = time.Hour
+
+	// the recording ID differs from the manifest IDs the streams are played by
+	cxn := startOverConnection(t)
+	s.rtmpConnections.store("mani", cxn)
+	defer s.rtmpConnections.delete("mani")
+	s.rtmpConnections.store("tenant-mani", cxn)
+	defer s.rtmpConnections.delete("tenant-mani")
+
+	p, err := ParsePlaybackKeys("nodekey,tenant:tenantkey")
+	require.Nil(t, err)
+	h := p.Middleware()(s.serveStartOver(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("segment"))
+	})))
+	serve := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	for _, tc := range []struct{ mid, kid string }{{"mani", ""}, {"tenant-mani", "tenant"}} {
+		q, err := p.Sign(tc.mid, tc.kid, time.Now().Add(time.Minute))
+		require.Nil(t, err)
+		w := serve("/stream/" + tc.mid + "/startover/source.m3u8?" + q.Encode())
+		require.Equal(t, http.StatusOK, w.Code, tc.mid)
+		var recorded string
+		for _, line := range strings.Split(w.Body.String(), "\n") {
+			if strings.HasPrefix(line, "/recordings/recmid/source/1.ts?") {
+				recorded = line
+			}
+		}
+		require.NotEmpty(t, recorded, tc.mid)
+		assert.Equal(http.StatusOK, serve(recorded).Code, tc.mid)
+		// the signature of the stream alone does not grant its recording
+		assert.Equal(http.StatusForbidden, serve("/recordings/recmid/source/1.ts?"+q.Encode()).Code, tc.mid)
+	}
+}
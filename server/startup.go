@@ -0,0 +1,67 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+var errNotReady = newError(ErrorCategoryDiscovery, true, "ErrNotReady")
+
+// StartupReady, if set, holds back the transcoding of ingested segments until
+// the node has finished starting up. This lets ingest be served while slower
+// parts of the startup, such as event backfill and orchestrator discovery,
+// complete in the background.
+var StartupReady *StartupGate
+
+// StartupGate is opened once the node is ready. Callers may wait for it to
+// open for up to a timeout.
+type StartupGate struct {
+	ready   chan struct{}
+	once    sync.Once
+	timeout time.Duration
+}
+
+// NewStartupGate returns a closed gate that callers wait on for at most `timeout`
+func NewStartupGate(timeout time.Duration) *StartupGate {
+	return &StartupGate{
+		ready:   make(chan struct{}),
+		timeout: timeout,
+	}
+}
+
+// Open marks the node as ready and releases all waiters
+func (g *StartupGate) Open() {
+	if g == nil {
+		return
+	}
+	g.once.Do(func() { close(g.ready) })
+}
+
+// Ready returns whether the gate is open
+func (g *StartupGate) Ready() bool {
+	if g == nil {
+		return true
+	}
+	select {
+	case <-g.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// Wait blocks until the gate is open. It returns an error if it is still
+// closed after the timeout.
+func (g *StartupGate) Wait() error {
+	if g.Ready() {
+		return nil
+	}
+	timer := time.NewTimer(g.timeout)
+	defer timer.Stop()
+	select {
+	case <-g.ready:
+		return nil
+	case <-timer.C:
+		return errNotReady
+	}
+}
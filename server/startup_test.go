@@ -0,0 +1,34 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStartupGate(t *testing.T) {
+	assert := assert.New(t)
+
+	// nil gate is always open
+	var g *StartupGate
+	assert.True(g.Ready())
+	assert.Nil(g.Wait())
+	g.Open()
+
+	g = NewStartupGate(10 * time.Millisecond)
+	assert.False(g.Ready())
+	assert.Equal(errNotReady, g.Wait())
+
+	// waiters are released once the gate opens
+	g = NewStartupGate(time.Second)
+	errc := make(chan error)
+	go func() { errc <- g.Wait() }()
+	time.Sleep(10 * time.Millisecond)
+	g.Open()
+	assert.Nil(<-errc)
+	assert.True(g.Ready())
+	assert.Nil(g.Wait())
+	// opening again is harmless
+	g.Open()
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/livepeer/go-livepeer/core"
+)
+
+// StreamAlias binds the manifest ID a stream is pushed to, its external ID,
+// to the manifest ID the auth webhook gave the stream, its internal ID
+type StreamAlias struct {
+	External core.ManifestID `json:"external"`
+	Internal core.ManifestID `json:"internal"`
+	// Unix time the alias was bound at
+	Since int64 `json:"since"`
+}
+
+// streamAliases is the registry of the aliases of the streams of a node.
+//
+// An external ID is in one of three states:
+//   - unbound: pushes to it start a new stream, claiming it first
+//   - claimed: a push is starting the stream, calling the auth webhook; other
+//     pushes to the ID wait for the claim to be released rather than call
+//     the webhook again
+//   - bound: pushes to it go to the stream of the internal ID, until the
+//     stream ends or another external ID is bound to the internal ID
+//
+// Each internal ID has at most one external ID bound to it.
+type streamAliases struct {
+	mu      sync.Mutex
+	aliases map[core.ManifestID]*StreamAlias
+	// external IDs by internal ID
+	external map[core.ManifestID]core.ManifestID
+	claims   map[core.ManifestID]*aliasClaim
+}
+
+type aliasClaim struct {
+	aliases *streamAliases
+	ext     core.ManifestID
+	done    chan struct{}
+	once    sync.Once
+}
+
+func newStreamAliases() *streamAliases {
+	return &streamAliases{
+		aliases:  make(map[core.ManifestID]*StreamAlias),
+		external: make(map[core.ManifestID]core.ManifestID),
+		claims:   make(map[core.ManifestID]*aliasClaim),
+	}
+}
+
+// lookup returns the internal ID bound to the external ID `ext`, if any
+func (a *streamAliases) lookup(ext core.ManifestID) (core.ManifestID, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if alias, ok := a.aliases[ext]; ok {
+		return alias.Internal, true
+	}
+	return "", false
+}
+
+// resolve returns the internal ID bound to `mid`, or `mid` itself if it is
+// not an external ID
+func (a *streamAliases) resolve(mid core.ManifestID) core.ManifestID {
+	if intmid, ok := a.lookup(mid); ok {
+		return intmid
+	}
+	return mid
+}
+
+// externalOf returns the external ID bound to the internal ID `intmid`, if
+// any
+func (a *streamAliases) externalOf(intmid core.ManifestID) (core.ManifestID, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	ext, ok := a.external[intmid]
+	return ext, ok
+}
+
+// claim claims the external ID `ext` to start its stream, once any other
+// claim of it was released. The claim has to be released once the stream
+// is started, or failed to.
+func (a *streamAliases) claim(ext core.ManifestID) *aliasClaim {
+	for {
+		a.mu.Lock()
+		c, ok := a.claims[ext]
+		if !ok {
+			c = &aliasClaim{aliases: a, ext: ext, done: make(chan struct{})}
+			a.claims[ext] = c
+			a.mu.Unlock()
+			return c
+		}
+		a.mu.Unlock()
+		<-c.done
+	}
+}
+
+// release releases the claim; it may be called more than once
+func (c *aliasClaim) release() {
+	c.once.Do(func() {
+		c.aliases.mu.Lock()
+		delete(c.aliases.claims, c.ext)
+		c.aliases.mu.Unlock()
+		close(c.done)
+	})
+}
+
+// bind binds the external ID `ext` to the internal ID `intmid`, returning the
+// external ID that was bound to `intmid` before, if any
+func (a *streamAliases) bind(ext, intmid core.ManifestID) core.ManifestID {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if alias, ok := a.aliases[ext]; ok && alias.Internal != intmid {
+		delete(a.external, alias.Internal)
+	}
+	prev, ok := a.external[intmid]
+	if ok && prev != ext {
+		delete(a.aliases, prev)
+	} else {
+		prev = ""
+	}
+	if alias, ok := a.aliases[ext]; !ok || alias.Internal != intmid {
+		a.aliases[ext] = &StreamAlias{External: ext, Internal: intmid, Since: clock.Now().Unix()}
+	}
+	a.external[intmid] = ext
+	return prev
+}
+
+// unbind removes the alias of the stream of the internal ID `intmid`
+func (a *streamAliases) unbind(intmid core.ManifestID) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if ext, ok := a.external[intmid]; ok {
+		delete(a.aliases, ext)
+		delete(a.external, intmid)
+	}
+}
+
+// list returns the aliases sorted by external ID
+func (a *streamAliases) list() []StreamAlias {
+	a.mu.Lock()
+	res := make([]StreamAlias, 0, len(a.aliases))
+	for _, alias := range a.aliases {
+		res = append(res, *alias)
+	}
+	a.mu.Unlock()
+	sort.Slice(res, func(i, j int) bool { return res[i].External < res[j].External })
+	return res
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamAliases(t *testing.T) {
+	assert := assert.New(t)
+	useFakeClock(t)
+	a := newStreamAliases()
+
+	assert.Equal(core.ManifestID("ext1"), a.resolve("ext1"))
+	_, ok := a.lookup("ext1")
+	assert.False(ok)
+
+	assert.Empty(a.bind("ext1", "int"))
+	assert.Equal(core.ManifestID("int"), a.resolve("ext1"))
+	ext, ok := a.externalOf("int")
+	assert.True(ok)
+	assert.Equal(core.ManifestID("ext1"), ext)
+	// binding again changes nothing
+	assert.Empty(a.bind("ext1", "int"))
+
+	// an internal ID has one external ID at most
+	assert.Equal(core.ManifestID("ext1"), a.bind("ext2", "int"))
+	_, ok = a.lookup("ext1")
+	assert.False(ok)
+	assert.Equal([]StreamAlias{{External: "ext2", Internal: "int", Since: 1600000000}}, a.list())
+
+	// as does an external ID
+	assert.Empty(a.bind("ext2", "int2"))
+	_, ok = a.externalOf("int")
+	assert.False(ok)
+	assert.Equal(core.ManifestID("int2"), a.resolve("ext2"))
+
+	a.bind("ext3", "int3")
+	a.unbind("int2")
+	a.unbind("unknown")
+	assert.Equal([]StreamAlias{{External: "ext3", Internal: "int3", Since: 1600000000}}, a.list())
+}
+
+func TestStreamAliases_Claim(t *testing.T) {
+	assert := assert.New(t)
+	a := newStreamAliases()
+
+	c := a.claim("ext")
+	other := a.claim("other")
+	claimed := make(chan *aliasClaim)
+	go func() { claimed <- a.claim("ext") }()
+	select {
+	case <-claimed:
+		t.Fatal("claimed twice")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	a.bind("ext", "int")
+	c.release()
+	c.release()
+	select {
+	case c2 := <-claimed:
+		// the stream was started in the meantime
+		assert.Equal(core.ManifestID("int"), a.resolve("ext"))
+		c2.release()
+	case <-time.After(time.Second):
+		t.Fatal("claim not released")
+	}
+	other.release()
+	assert.Empty(a.claims)
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common"
+)
+
+// StreamEventWebhookURL, if set, receives a JSON POST for events of streams
+// that their owners should know about, such as a misconfigured encoder
+var StreamEventWebhookURL string
+
+// StreamEventWebhookSecret, if set, signs the events posted to the stream
+// event webhook with WebhookSigHeader
+var StreamEventWebhookSecret string
+
+// streamEventAttempts bounds the deliveries of an event to the webhook. Events
+// may be delivered more than once, so receivers should dedupe them by ID.
+var streamEventAttempts = 5
+
+var streamEventClient = &http.Client{Timeout: 5 * time.Second}
+
+// Events of the lifecycle of streams
+const (
+	streamStartedEvent     = "stream.started"
+	streamEndedEvent       = "stream.ended"
+	streamIdleEvent        = "stream.idle"
+	segmentTranscodedEvent = "segment.transcoded"
+)
+
+type streamEvent struct {
+	ID         string            `json:"id"`
+	Event      string            `json:"event"`
+	ManifestID string            `json:"manifestID"`
+	Time       int64             `json:"time"`
+	Nonce      uint64            `json:"nonce,omitempty"`
+	SeqNo      *uint64           `json:"seqNo,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Rendition  string            `json:"rendition,omitempty"`
+	Bytes      *streamBytes      `json:"bytes,omitempty"`
+	Warning    *sourceWarning    `json:"warning,omitempty"`
+	Error      *streamEventError `json:"error,omitempty"`
+}
+
+// streamBytes counts the bytes of a stream so far
+type streamBytes struct {
+	Source     uint64 `json:"source"`
+	Transcoded uint64 `json:"transcoded"`
+}
+
+type streamEventError struct {
+	Category  ErrorCategory `json:"category"`
+	Retryable bool          `json:"retryable"`
+	Message   string        `json:"message"`
+}
+
+func newStreamEventError(err error) *streamEventError {
+	return &streamEventError{Category: errorCategory(err), Retryable: isRetryable(err), Message: err.Error()}
+}
+
+// newLifecycleEvent returns the lifecycle event `event` of the stream of
+// `cxn`, with the bytes counted so far
+func newLifecycleEvent(cxn *rtmpConnection, event string) *streamEvent {
+	ev := &streamEvent{
+		Event:      event,
+		ManifestID: string(cxn.mid),
+		Time:       clock.Now().Unix(),
+		Nonce:      cxn.nonce,
+		Bytes: &streamBytes{
+			Source:     atomic.LoadUint64(&cxn.sourceBytes),
+			Transcoded: atomic.LoadUint64(&cxn.transcodedBytes),
+		},
+	}
+	if cxn.params != nil {
+		ev.Labels = cxn.params.Labels.Get()
+	}
+	return ev
+}
+
+// sendStreamEvent posts `ev` to the stream event webhook in the background,
+// and publishes it to the event bus
+func sendStreamEvent(ev *streamEvent) {
+	if ev.ID == "" {
+		ev.ID = common.RandName()
+	}
+	EventBus.publish(eventStream, ev)
+	if StreamEventWebhookURL == "" {
+		return
+	}
+	go deliverStreamEvent(StreamEventWebhookURL, ev)
+}
+
+// deliverStreamEvent posts `ev` to `url`, retrying with backoff up to
+// streamEventAttempts times while the webhook fails in a way that may be
+// temporary
+func deliverStreamEvent(url string, ev *streamEvent) {
+	backoff := eventRetryMin
+	for attempt := 1; ; attempt++ {
+		err := postWebhookJSON(url, ev)
+		if err == nil {
+			return
+		}
+		if attempt >= streamEventAttempts || !isRetryable(err) {
+			glog.Errorf("Error sending stream event manifestID=%s event=%s id=%s attempts=%d err=%v", ev.ManifestID, ev.Event, ev.ID, attempt, err)
+			return
+		}
+		glog.V(common.DEBUG).Infof("Error sending stream event manifestID=%s event=%s id=%s retryIn=%v err=%v", ev.ManifestID, ev.Event, ev.ID, backoff, err)
+		t := clock.NewTimer(backoff)
+		<-t.C()
+		if backoff *= 2; backoff > eventRetryMax {
+			backoff = eventRetryMax
+		}
+	}
+}
+
+// postWebhookJSON posts `v` as JSON to `url`, signed with
+// StreamEventWebhookSecret if set
+func postWebhookJSON(url string, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if StreamEventWebhookSecret != "" {
+		req.Header.Set(WebhookSigHeader, signWebhook(StreamEventWebhookSecret, clock.Now(), body))
+	}
+	resp, err := streamEventClient.Do(req)
+	if err != nil {
+		return wrapError(ErrorCategoryUnknown, true, common.RedactError(err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		rbody, _ := ioutil.ReadAll(resp.Body)
+		// Other client errors won't go away with retries
+		retryable := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return wrapError(ErrorCategoryUnknown, retryable, fmt.Errorf("status=%d error=%s", resp.StatusCode, string(rbody)))
+	}
+	return nil
+}
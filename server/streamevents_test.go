@@ -0,0 +1,129 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/lpms/stream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeliverStreamEvent(t *testing.T) {
+	assert := assert.New(t)
+	c := useFakeClock(t)
+
+	defer func(secret string) { StreamEventWebhookSecret = secret }(StreamEventWebhookSecret)
+	StreamEventWebhookSecret = "secret"
+
+	var attempts int32
+	status, failures := int32(http.StatusServiceUnavailable), int32(2)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Equal("application/json", r.Header.Get("Content-Type"))
+		assert.Equal(signWebhook("secret", c.Now(), body), r.Header.Get(WebhookSigHeader))
+		var ev streamEvent
+		assert.Nil(json.Unmarshal(body, &ev))
+		assert.Equal("evid", ev.ID)
+		if atomic.AddInt32(&attempts, 1) <= atomic.LoadInt32(&failures) {
+			w.WriteHeader(int(atomic.LoadInt32(&status)))
+		}
+	}))
+	defer hook.Close()
+
+	deliver := func() chan struct{} {
+		atomic.StoreInt32(&attempts, 0)
+		done := make(chan struct{})
+		go func() {
+			deliverStreamEvent(hook.URL, &streamEvent{ID: "evid", Event: streamStartedEvent, ManifestID: "mani"})
+			close(done)
+		}()
+		return done
+	}
+	waitDone := func(done chan struct{}) {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("event not delivered")
+		}
+	}
+
+	// temporary failures are retried with backoff
+	done := deliver()
+	assert.True(c.waitTimers(1))
+	c.Advance(eventRetryMin)
+	assert.True(c.waitTimers(1))
+	c.Advance(2 * eventRetryMin)
+	waitDone(done)
+	assert.Equal(int32(3), atomic.LoadInt32(&attempts))
+
+	// up to streamEventAttempts times
+	atomic.StoreInt32(&failures, 100)
+	done = deliver()
+	for i := 1; i < streamEventAttempts; i++ {
+		assert.True(c.waitTimers(1))
+		c.Advance(eventRetryMax)
+	}
+	waitDone(done)
+	assert.Equal(int32(streamEventAttempts), atomic.LoadInt32(&attempts))
+
+	// while other client errors are not
+	atomic.StoreInt32(&status, http.StatusBadRequest)
+	done = deliver()
+	waitDone(done)
+	assert.Equal(int32(1), atomic.LoadInt32(&attempts))
+}
+
+func TestStreamLifecycleEvents(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	s := setupServer()
+	defer serverCleanup(s)
+
+	events := make(chan streamEvent, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ev streamEvent
+		body, _ := ioutil.ReadAll(r.Body)
+		if json.Unmarshal(body, &ev) == nil {
+			events <- ev
+		}
+	}))
+	defer hook.Close()
+	defer func(url string) { StreamEventWebhookURL = url }(StreamEventWebhookURL)
+	StreamEventWebhookURL = hook.URL
+
+	next := func() streamEvent {
+		select {
+		case ev := <-events:
+			return ev
+		case <-time.After(time.Second):
+			t.Fatal("no stream event")
+		}
+		return streamEvent{}
+	}
+
+	params := &core.StreamParameters{ManifestID: "mani", Labels: core.NewStreamLabels(map[string]string{"customer": "cust1"})}
+	cxn, err := s.registerConnection(stream.NewBasicRTMPVideoStream(params))
+	require.Nil(err)
+	ev := next()
+	assert.Equal(streamStartedEvent, ev.Event)
+	assert.NotEmpty(ev.ID)
+	assert.Equal("mani", ev.ManifestID)
+	assert.Equal(cxn.nonce, ev.Nonce)
+	assert.Equal(map[string]string{"customer": "cust1"}, ev.Labels)
+	assert.Equal(&streamBytes{}, ev.Bytes)
+
+	atomic.AddUint64(&cxn.sourceBytes, 100)
+	atomic.AddUint64(&cxn.transcodedBytes, 250)
+	require.Nil(removeRTMPStream(s, "mani"))
+	ev = next()
+	assert.Equal(streamEndedEvent, ev.Event)
+	assert.Equal(cxn.nonce, ev.Nonce)
+	assert.Equal(&streamBytes{Source: 100, Transcoded: 250}, ev.Bytes)
+}
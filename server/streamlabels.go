@@ -0,0 +1,87 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/core"
+)
+
+// MaxStreamLabelsSize bounds the total size of the keys and values of the
+// labels of a stream, as they are repeated in every usage record
+var MaxStreamLabelsSize = 4096
+
+// recordingInfoName is the file, at the root of the recording of a stream,
+// that its labels are saved to
+const recordingInfoName = "info.json"
+
+type recordingInfo struct {
+	ManifestID string            `json:"manifestID"`
+	Labels     map[string]string `json:"labels"`
+	UpdatedAt  int64             `json:"updatedAt"`
+}
+
+func validateStreamLabels(labels map[string]string) error {
+	size := 0
+	for k, v := range labels {
+		if k == "" {
+			return errors.New("empty label key")
+		}
+		size += len(k) + len(v)
+	}
+	if size > MaxStreamLabelsSize {
+		return fmt.Errorf("labels too large size=%d max=%d", size, MaxStreamLabelsSize)
+	}
+	return nil
+}
+
+// streamLabels returns the labels of a live stream
+func (s *LivepeerServer) streamLabels(mid core.ManifestID) (map[string]string, error) {
+	cxn, ok := s.rtmpConnections.get(s.streamAliases.resolve(mid))
+	if !ok {
+		return nil, errUnknownStream
+	}
+	return cxn.params.Labels.Get(), nil
+}
+
+// setStreamLabels sets labels of a live stream, removing those given an
+// empty value, and saves them along its recording
+func (s *LivepeerServer) setStreamLabels(mid core.ManifestID, labels map[string]string) error {
+	cxn, ok := s.rtmpConnections.get(s.streamAliases.resolve(mid))
+	if !ok {
+		return errUnknownStream
+	}
+	// validated as they are once merged
+	merged := make(map[string]string)
+	for k, v := range cxn.params.Labels.Get() {
+		merged[k] = v
+	}
+	for k, v := range labels {
+		merged[k] = v
+	}
+	if err := validateStreamLabels(merged); err != nil {
+		return err
+	}
+	cxn.params.Labels.Update(labels)
+	go saveRecordingInfo(cxn)
+	return nil
+}
+
+// saveRecordingInfo saves the labels of the stream of `cxn` to the root of
+// its recording, if it is recorded
+func saveRecordingInfo(cxn *rtmpConnection) {
+	ros := cxn.pl.GetRecordOSSession()
+	if ros == nil {
+		return
+	}
+	info := recordingInfo{ManifestID: string(cxn.mid), Labels: cxn.params.Labels.Get(), UpdatedAt: clock.Now().Unix()}
+	data, err := json.Marshal(info)
+	if err == nil {
+		_, err = ros.SaveData(recordingInfoName, data, nil)
+	}
+	if err != nil {
+		glog.Errorf("Error saving recording info manifestID=%s err=%v", cxn.mid, err)
+	}
+}
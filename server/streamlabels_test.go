@@ -0,0 +1,75 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/drivers"
+	"github.com/livepeer/lpms/stream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateStreamLabels(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(validateStreamLabels(nil))
+	assert.Nil(validateStreamLabels(map[string]string{"title": "Live", "tags": ""}))
+	assert.EqualError(validateStreamLabels(map[string]string{"": "x"}), "empty label key")
+	err := validateStreamLabels(map[string]string{"title": strings.Repeat("x", MaxStreamLabelsSize)})
+	assert.EqualError(err, "labels too large size=4101 max=4096")
+}
+
+func TestSetStreamLabels(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	s := setupServer()
+	defer serverCleanup(s)
+
+	mid := core.ManifestID(t.Name())
+	_, err := s.streamLabels(mid)
+	assert.Equal(errUnknownStream, err)
+	assert.Equal(errUnknownStream, s.setStreamLabels(mid, map[string]string{"title": "Live"}))
+
+	ros := drivers.NewMemoryDriver(nil).NewSession("rec").(*drivers.MemorySession)
+	params := &core.StreamParameters{ManifestID: mid, RecordOS: ros, Labels: core.NewStreamLabels(map[string]string{"customer": "cust1"})}
+	cxn, err := s.registerConnection(stream.NewBasicRTMPVideoStream(params))
+	require.Nil(err)
+	labels, err := s.streamLabels(mid)
+	require.Nil(err)
+	assert.Equal(map[string]string{"customer": "cust1"}, labels)
+
+	// by the external manifest ID too
+	s.streamAliases.bind("ext", mid)
+	require.Nil(s.setStreamLabels("ext", map[string]string{"title": "Live", "customer": ""}))
+	assert.Equal(map[string]string{"title": "Live"}, cxn.params.Labels.Get())
+	assert.EqualError(s.setStreamLabels(mid, map[string]string{"": "x"}), "empty label key")
+	assert.EqualError(s.setStreamLabels(mid, map[string]string{"tags": strings.Repeat("x", MaxStreamLabelsSize)}),
+		"labels too large size=4109 max=4096")
+
+	// saved along the recording
+	var info recordingInfo
+	require.Eventually(func() bool {
+		data := ros.GetData("rec/" + recordingInfoName)
+		return data != nil && json.Unmarshal(data, &info) == nil && info.Labels["title"] == "Live"
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(string(mid), info.ManifestID)
+
+	// and surfaced along usage records and stream events
+	job := meteredJob(1)
+	job.cxn = cxn
+	assert.Equal(map[string]string{"title": "Live"}, newUsageRecord(job, time.Now(), nil).Labels)
+	assert.Equal(map[string]string{"title": "Live"}, s.GetNodeStatus().StreamInfo[string(mid)].Labels)
+
+	// streams started without labels may be given some
+	cxn, err = s.registerConnection(stream.NewBasicRTMPVideoStream(&core.StreamParameters{ManifestID: mid + "_nolabels"}))
+	require.Nil(err)
+	labels, err = s.streamLabels(mid + "_nolabels")
+	require.Nil(err)
+	assert.Empty(labels)
+	require.Nil(s.setStreamLabels(mid+"_nolabels", map[string]string{"title": "Later"}))
+	assert.Equal(map[string]string{"title": "Later"}, cxn.params.Labels.Get())
+}
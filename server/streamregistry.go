@@ -0,0 +1,109 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/core"
+)
+
+// ProvisionedStreams, if set, authenticates ingest against the stream keys
+// of the streams it lists rather than over the auth webhook
+var ProvisionedStreams *StreamRegistry
+
+// StreamKeyMaxFailures is the number of failed stream key checks in a row
+// after which a stream is locked out for StreamKeyLockout, whatever the key
+var StreamKeyMaxFailures = 5
+var StreamKeyLockout = 5 * time.Minute
+
+var errUnknownProvisionedStream = errors.New("stream not provisioned")
+var errInvalidStreamKey = errors.New("invalid stream key")
+var errStreamLockedOut = errors.New("stream locked out after repeated stream key failures")
+
+// StreamRegistry holds the pre-provisioned streams of the node, each with
+// the static key it has to be pushed with
+type StreamRegistry struct {
+	mu      sync.Mutex
+	streams map[core.ManifestID]*provisionedStream
+}
+
+type provisionedStream struct {
+	resp authWebhookResponse
+	// consecutive failed key checks, and the end of the lockout they led to
+	failures    int
+	lockedUntil time.Time
+}
+
+// NewStreamRegistry reads the streams from the JSON file `streamsFile`, a
+// list of objects as returned by the auth webhook, each with a manifestID
+// and a streamKey
+func NewStreamRegistry(streamsFile string) (*StreamRegistry, error) {
+	data, err := ioutil.ReadFile(streamsFile)
+	if err != nil {
+		return nil, err
+	}
+	return newStreamRegistry(data)
+}
+
+func newStreamRegistry(data []byte) (*StreamRegistry, error) {
+	var streams []authWebhookResponse
+	if err := json.Unmarshal(data, &streams); err != nil {
+		return nil, err
+	}
+	if len(streams) == 0 {
+		return nil, fmt.Errorf("no streams provisioned")
+	}
+	r := &StreamRegistry{streams: make(map[core.ManifestID]*provisionedStream)}
+	for _, s := range streams {
+		mid := core.ManifestID(s.ManifestID)
+		if mid == "" || s.StreamKey == "" {
+			return nil, fmt.Errorf("provisioned streams need a manifestID and a streamKey")
+		}
+		if _, ok := r.streams[mid]; ok {
+			return nil, fmt.Errorf("duplicate provisioned stream manifestID=%s", mid)
+		}
+		r.streams[mid] = &provisionedStream{resp: s}
+	}
+	return r, nil
+}
+
+// authenticate checks the key that the stream of `u` is pushed with, given
+// either as the last element of the path or as the `key` query parameter.
+// It returns the provisioned stream in place of an auth webhook response.
+func (r *StreamRegistry) authenticate(u *url.URL) (*authWebhookResponse, error) {
+	sid := parseStreamID(u.Path)
+	key := u.Query().Get("key")
+	if key == "" {
+		key = sid.Rendition
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s, ok := r.streams[sid.ManifestID]
+	if !ok {
+		return nil, errUnknownProvisionedStream
+	}
+	now := clock.Now()
+	if now.Before(s.lockedUntil) {
+		return nil, errStreamLockedOut
+	}
+	if subtle.ConstantTimeCompare([]byte(key), []byte(s.resp.StreamKey)) != 1 {
+		s.failures++
+		if s.failures >= StreamKeyMaxFailures {
+			glog.Warningf("Locking out stream after repeated stream key failures manifestID=%s failures=%d lockout=%v", sid.ManifestID, s.failures, StreamKeyLockout)
+			s.failures = 0
+			s.lockedUntil = now.Add(StreamKeyLockout)
+		}
+		return nil, errInvalidStreamKey
+	}
+	s.failures = 0
+	resp := s.resp
+	return &resp, nil
+}
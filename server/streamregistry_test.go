@@ -0,0 +1,101 @@
+package server
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewStreamRegistry_Errors(t *testing.T) {
+	assert := assert.New(t)
+
+	_, err := newStreamRegistry([]byte(`[]`))
+	assert.EqualError(err, "no streams provisioned")
+	_, err = newStreamRegistry([]byte(`[{"manifestID": "a"}]`))
+	assert.EqualError(err, "provisioned streams need a manifestID and a streamKey")
+	_, err = newStreamRegistry([]byte(`[{"manifestID": "a", "streamKey": "k1"}, {"manifestID": "a", "streamKey": "k2"}]`))
+	assert.EqualError(err, "duplicate provisioned stream manifestID=a")
+	_, err = newStreamRegistry([]byte(`{}`))
+	assert.NotNil(err)
+	_, err = NewStreamRegistry("/nonexistent/streams.json")
+	assert.NotNil(err)
+}
+
+func TestStreamRegistry_Authenticate(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	c := useFakeClock(t)
+	defer func(n int, d time.Duration) { StreamKeyMaxFailures, StreamKeyLockout = n, d }(StreamKeyMaxFailures, StreamKeyLockout)
+	StreamKeyMaxFailures, StreamKeyLockout = 3, time.Minute
+
+	r, err := newStreamRegistry([]byte(`[
+		{"manifestID": "a", "streamKey": "secret", "presets": ["P144p30fps16x9"]},
+		{"manifestID": "b", "streamKey": "other"}
+	]`))
+	require.Nil(err)
+	auth := func(u string) (*authWebhookResponse, error) {
+		parsed, err := url.Parse(u)
+		require.Nil(err)
+		return r.authenticate(parsed)
+	}
+
+	resp, err := auth("rtmp://localhost/live/a/secret")
+	require.Nil(err)
+	assert.Equal("a", resp.ManifestID)
+	assert.Equal("secret", resp.StreamKey)
+	assert.Equal([]string{"P144p30fps16x9"}, resp.Presets)
+	// HTTP pushes give the key as a query parameter
+	_, err = auth("http://localhost/live/a/0.ts?key=secret")
+	assert.Nil(err)
+
+	_, err = auth("rtmp://localhost/live/c/secret")
+	assert.Equal(errUnknownProvisionedStream, err)
+	_, err = auth("rtmp://localhost/live/a")
+	assert.Equal(errInvalidStreamKey, err)
+
+	// a success resets the count of failures
+	_, err = auth("rtmp://localhost/live/a/guess")
+	assert.Equal(errInvalidStreamKey, err)
+	_, err = auth("rtmp://localhost/live/a/secret")
+	assert.Nil(err)
+
+	for i := 0; i < 3; i++ {
+		_, err = auth("rtmp://localhost/live/a/guess")
+		assert.Equal(errInvalidStreamKey, err)
+	}
+	// locked out even with the right key, other streams are not
+	_, err = auth("rtmp://localhost/live/a/secret")
+	assert.Equal(errStreamLockedOut, err)
+	_, err = auth("rtmp://localhost/live/b/other")
+	assert.Nil(err)
+
+	c.Advance(time.Minute)
+	_, err = auth("rtmp://localhost/live/a/secret")
+	assert.Nil(err)
+}
+
+func TestCreateRTMPStreamHandler_StreamRegistry(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	s := setupServer()
+	defer serverCleanup(s)
+	createSid := createRTMPStreamIDHandler(s)
+
+	r, err := newStreamRegistry([]byte(`[{"manifestID": "reg", "streamKey": "secret", "presets": ["P144p30fps16x9"]}]`))
+	require.Nil(err)
+	defer func(r *StreamRegistry) { ProvisionedStreams = r }(ProvisionedStreams)
+	ProvisionedStreams = r
+
+	u, _ := url.Parse("rtmp://localhost/live/reg/wrong")
+	assert.Nil(createSid(u))
+	u, _ = url.Parse("rtmp://localhost/live/reg/secret")
+	params, ok := createSid(u).(*core.StreamParameters)
+	require.True(ok)
+	assert.Equal(core.ManifestID("reg"), params.ManifestID)
+	assert.Equal("secret", params.RtmpKey)
+	assert.Len(params.Profiles, 1)
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/lpms/ffmpeg"
+)
+
+// StreamStateMaxAge, if non-zero, makes the broadcaster save the state of its
+// streams in the node DB as their segments come in: the internal manifest ID
+// bound to the manifest ID they are pushed to, their profiles and segment
+// duration, and the sequence number of their last segment. A stream pushed
+// again within this long of its last segment, such as after the broadcaster
+// crashed or restarted, resumes where it was: into the same playlists and
+// object store paths, with the same renditions. The auth webhook may still
+// give it others, or a new manifest ID to start over with.
+var StreamStateMaxAge time.Duration
+
+// persistedStream is the JSON encoded state of a stream
+type persistedStream struct {
+	Profiles        []ffmpeg.VideoProfile `json:"profiles"`
+	AudioProfiles   []ffmpeg.VideoProfile `json:"audioProfiles,omitempty"`
+	SegmentDuration time.Duration         `json:"segmentDuration,omitempty"`
+}
+
+// resumedStream is the saved state of a stream pushed again
+type resumedStream struct {
+	persistedStream
+	ManifestID core.ManifestID
+	NextSeqNo  uint64
+}
+
+// loadStreamState returns the state of the stream pushed to `extmid` saved
+// within StreamStateMaxAge, or nil if there is none
+func loadStreamState(db *common.DB, extmid core.ManifestID) *resumedStream {
+	if db == nil || StreamStateMaxAge <= 0 || extmid == "" {
+		return nil
+	}
+	row, err := db.SelectStreamState(string(extmid), clock.Now().Add(-StreamStateMaxAge))
+	if err != nil {
+		glog.Errorf("Unable to load stream state manifestID=%s err=%v", extmid, err)
+		return nil
+	}
+	if row == nil {
+		return nil
+	}
+	res := &resumedStream{ManifestID: core.ManifestID(row.InternalID), NextSeqNo: row.NextSeqNo}
+	if err := json.Unmarshal(row.State, &res.persistedStream); err != nil {
+		glog.Errorf("Invalid stream state manifestID=%s err=%v", extmid, err)
+		return nil
+	}
+	return res
+}
+
+// streamState saves the state of a stream. A nil *streamState saves nothing.
+type streamState struct {
+	db *common.DB
+
+	// Held while writing the state, so that none is written once the
+	// stream ended
+	mu    sync.Mutex
+	row   common.DBStreamState
+	ended bool
+}
+
+// newStreamState returns the saver of the state of the stream of `params`.
+// If the stream resumes a saved one, it also returns the sequence number the
+// saved stream left off at.
+func newStreamState(db *common.DB, params *core.StreamParameters) (st *streamState, nextSeqNo uint64, resumed bool) {
+	if db == nil || StreamStateMaxAge <= 0 {
+		return nil, 0, false
+	}
+	extmid := params.RecordingID
+	if extmid == "" {
+		extmid = params.ManifestID
+	}
+	if prev := loadStreamState(db, extmid); prev != nil && prev.ManifestID == params.ManifestID {
+		nextSeqNo, resumed = prev.NextSeqNo, true
+	}
+	st = &streamState{db: db, row: common.DBStreamState{ManifestID: string(extmid), InternalID: string(params.ManifestID)}}
+	return st, nextSeqNo, resumed
+}
+
+// save saves the state of the stream of `params` once its segment `seqNo`
+// came in. The state is written in the background so as not to hold up the
+// stream.
+func (st *streamState) save(seqNo uint64, params *core.StreamParameters) {
+	if st == nil {
+		return
+	}
+	data, err := json.Marshal(persistedStream{Profiles: params.Profiles, AudioProfiles: params.AudioProfiles, SegmentDuration: params.SegmentDuration})
+	if err != nil {
+		glog.Errorf("Unable to encode stream state manifestID=%s err=%v", st.row.InternalID, err)
+		return
+	}
+	now := clock.Now()
+	go func() {
+		st.mu.Lock()
+		defer st.mu.Unlock()
+		// segments processed concurrently may be saved out of order
+		if st.ended || seqNo+1 < st.row.NextSeqNo {
+			return
+		}
+		st.row.State, st.row.NextSeqNo, st.row.UpdatedAt = data, seqNo+1, now
+		if err := st.db.UpdateStreamState(&st.row); err != nil {
+			glog.Errorf("Unable to save stream state manifestID=%s seqNo=%d err=%v", st.row.InternalID, seqNo, err)
+		}
+	}()
+}
+
+// clear removes the state of the stream once it ended
+func (st *streamState) clear() {
+	if st == nil {
+		return
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.ended = true
+	if err := st.db.DeleteStreamState(st.row.ManifestID); err != nil {
+		glog.Errorf("Unable to clear stream state manifestID=%s err=%v", st.row.InternalID, err)
+	}
+}
+
+// streamStatePruneInterval is how often stream states past
+// StreamStateMaxAge are pruned
+var streamStatePruneInterval = time.Hour
+
+// pruneStreamStates prunes the stream states in `db` past StreamStateMaxAge
+// until `ctx` is done, e.g. of streams that were never pushed again
+func pruneStreamStates(ctx context.Context, db *common.DB) {
+	timer := clock.NewTimer(streamStatePruneInterval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C():
+		}
+		n, err := db.PruneStreamStates(clock.Now().Add(-StreamStateMaxAge))
+		if err != nil {
+			glog.Errorf("Unable to prune stream states err=%v", err)
+		} else if n > 0 {
+			glog.V(common.DEBUG).Infof("Pruned stream states streams=%d", n)
+		}
+		timer.Reset(streamStatePruneInterval)
+	}
+}
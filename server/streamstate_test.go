@@ -0,0 +1,138 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/lpms/ffmpeg"
+	"github.com/livepeer/lpms/stream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamState(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	c := useFakeClock(t)
+
+	dbh, dbraw, err := common.TempDB(t)
+	require.Nil(err)
+	defer dbh.Close()
+	defer dbraw.Close()
+
+	params := &core.StreamParameters{ManifestID: "mid", RecordingID: "ext", Profiles: []ffmpeg.VideoProfile{ffmpeg.P144p30fps16x9}, SegmentDuration: 4 * time.Second}
+
+	// states are off without a max age or a DB
+	st, _, _ := newStreamState(dbh, params)
+	assert.Nil(st)
+	defer func(age time.Duration) { StreamStateMaxAge = age }(StreamStateMaxAge)
+	StreamStateMaxAge = time.Hour
+	st, _, _ = newStreamState(nil, params)
+	assert.Nil(st)
+	st.save(0, params)
+	st.clear()
+
+	st, _, resumed := newStreamState(dbh, params)
+	require.NotNil(st)
+	assert.False(resumed)
+	st.save(3, params)
+	common.WaitAssert(t, time.Second, func() bool {
+		prev := loadStreamState(dbh, "ext")
+		return prev != nil && prev.NextSeqNo == 4
+	}, "stream state not saved")
+	// segments saved out of order don't take the state back
+	st.save(1, params)
+	time.Sleep(50 * time.Millisecond)
+	prev := loadStreamState(dbh, "ext")
+	require.NotNil(prev)
+	assert.Equal(&resumedStream{persistedStream: persistedStream{Profiles: params.Profiles, SegmentDuration: 4 * time.Second}, ManifestID: "mid", NextSeqNo: 4}, prev)
+
+	// after a restart, the stream pushed to the same manifest ID resumes
+	s := setupServer()
+	defer serverCleanup(s)
+	defer func(db *common.DB) { s.LivepeerNode.Database = db }(s.LivepeerNode.Database)
+	s.LivepeerNode.Database = dbh
+	u, _ := url.Parse("rtmp://localhost/live/ext")
+	resumedParams := createRTMPStreamIDHandler(s)(u).(*core.StreamParameters)
+	assert.Equal(core.ManifestID("mid"), resumedParams.ManifestID)
+	assert.Equal(core.ManifestID("ext"), resumedParams.RecordingID)
+	assert.Equal(params.Profiles, resumedParams.Profiles)
+	assert.Equal(4*time.Second, resumedParams.SegmentDuration)
+	assert.False(resumedParams.AutoLadder)
+
+	cxn, err := s.registerConnection(stream.NewBasicRTMPVideoStream(resumedParams))
+	require.Nil(err)
+	assert.Equal(uint64(4), cxn.startSeqNo)
+	assert.NotNil(cxn.state)
+
+	// the state is gone once the stream ended
+	require.Nil(removeRTMPStream(s, "mid"))
+	assert.Nil(loadStreamState(dbh, "ext"))
+	fresh := createRTMPStreamIDHandler(s)(u).(*core.StreamParameters)
+	assert.Equal(core.ManifestID("ext"), fresh.ManifestID)
+
+	// or once it is too old to resume
+	st, _, _ = newStreamState(dbh, params)
+	st.save(5, params)
+	common.WaitAssert(t, time.Second, func() bool { return loadStreamState(dbh, "ext") != nil }, "stream state not saved")
+	c.Advance(StreamStateMaxAge + time.Second)
+	assert.Nil(loadStreamState(dbh, "ext"))
+	n, err := dbh.PruneStreamStates(c.Now().Add(-StreamStateMaxAge))
+	require.Nil(err)
+	assert.Equal(int64(1), n)
+}
+
+func TestStreamState_Webhook(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	dbh, dbraw, err := common.TempDB(t)
+	require.Nil(err)
+	defer dbh.Close()
+	defer dbraw.Close()
+	defer func(age time.Duration) { StreamStateMaxAge = age }(StreamStateMaxAge)
+	StreamStateMaxAge = time.Hour
+	defer func(p []ffmpeg.VideoProfile) { BroadcastJobVideoProfiles = p }(BroadcastJobVideoProfiles)
+	BroadcastJobVideoProfiles = []ffmpeg.VideoProfile{ffmpeg.P360p30fps16x9}
+
+	params := &core.StreamParameters{ManifestID: "mid", RecordingID: "ext", Profiles: []ffmpeg.VideoProfile{ffmpeg.P144p30fps16x9}}
+	st, _, _ := newStreamState(dbh, params)
+	require.NotNil(st)
+	st.save(3, params)
+	common.WaitAssert(t, time.Second, func() bool { return loadStreamState(dbh, "ext") != nil }, "stream state not saved")
+
+	s := setupServer()
+	defer serverCleanup(s)
+	defer func(db *common.DB) { s.LivepeerNode.Database = db }(s.LivepeerNode.Database)
+	s.LivepeerNode.Database = dbh
+	defer func(u string) { AuthWebhookURL = u }(AuthWebhookURL)
+	create := func(resp string) *core.StreamParameters {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(resp))
+		}))
+		defer ts.Close()
+		AuthWebhookURL = ts.URL
+		u, _ := url.Parse("rtmp://localhost/live/ext")
+		return createRTMPStreamIDHandler(s)(u).(*core.StreamParameters)
+	}
+
+	// the same manifest ID resumes with the saved renditions
+	resumed := create(`{"manifestID":"mid"}`)
+	assert.Equal(core.ManifestID("mid"), resumed.ManifestID)
+	assert.Equal(params.Profiles, resumed.Profiles)
+
+	// unless the webhook gave others
+	resumed = create(`{"manifestID":"mid", "presets":["P240p30fps16x9"]}`)
+	assert.Equal(core.ManifestID("mid"), resumed.ManifestID)
+	assert.Equal([]ffmpeg.VideoProfile{ffmpeg.P240p30fps16x9}, resumed.Profiles)
+
+	// a new manifest ID starts the stream over
+	fresh := create(`{"manifestID":"other"}`)
+	assert.Equal(core.ManifestID("other"), fresh.ManifestID)
+	assert.Equal(BroadcastJobVideoProfiles, fresh.Profiles)
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"sort"
+	"sync/atomic"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/core"
+)
+
+// StreamStatus is the detail of a live stream of a broadcaster, as served
+// at /status/streams
+type StreamStatus struct {
+	ManifestID string `json:"manifestID"`
+	// Manifest ID the stream is pushed to, when the auth webhook gave the
+	// stream another one
+	ExternalManifestID string   `json:"externalManifestID,omitempty"`
+	Profiles           []string `json:"profiles"`
+	SourceBytes        uint64   `json:"sourceBytes"`
+	TranscodedBytes    uint64   `json:"transcodedBytes"`
+	// Segments processed, and those of them that failed
+	Segments       uint64 `json:"segments"`
+	FailedSegments uint64 `json:"failedSegments"`
+	// Transcoder URL of the orchestrator the stream last sent a segment to
+	Orchestrator string `json:"orchestrator,omitempty"`
+	// Number of orchestrator sessions the stream may send segments to
+	Sessions int `json:"sessions"`
+	// Unix time the stream last received a segment
+	LastUsed int64 `json:"lastUsed"`
+	// Whether the publisher disconnected and the stream waits for it to
+	// reconnect
+	AwaitingReconnect bool `json:"awaitingReconnect"`
+}
+
+// activeOrchestrator returns the transcoder URL of the session the last
+// segment was sent to, and the number of sessions of the stream
+func (bsm *BroadcastSessionsManager) activeOrchestrator() (string, int) {
+	bsm.sessLock.Lock()
+	defer bsm.sessLock.Unlock()
+	var orch string
+	if bsm.lastSess != nil {
+		orch = common.RedactURL(bsm.lastSess.OrchestratorInfo.GetTranscoder())
+	}
+	return orch, len(bsm.sessMap)
+}
+
+func (s *LivepeerServer) streamStatus(mid core.ManifestID, cxn *rtmpConnection, external map[core.ManifestID]core.ManifestID) StreamStatus {
+	st := StreamStatus{
+		ManifestID:         string(mid),
+		ExternalManifestID: string(external[mid]),
+		Profiles:           []string{},
+		SourceBytes:        atomic.LoadUint64(&cxn.sourceBytes),
+		TranscodedBytes:    atomic.LoadUint64(&cxn.transcodedBytes),
+		Segments:           atomic.LoadUint64(&cxn.segments),
+		FailedSegments:     atomic.LoadUint64(&cxn.failedSegments),
+		AwaitingReconnect:  cxn.awaitingReconnect(),
+	}
+	if lastUsed := cxn.lastUsedTime(); !lastUsed.IsZero() {
+		st.LastUsed = lastUsed.Unix()
+	}
+	if cxn.params != nil {
+		for _, p := range cxn.params.Profiles {
+			st.Profiles = append(st.Profiles, p.Name)
+		}
+	}
+	if cxn.sessManager != nil {
+		st.Orchestrator, st.Sessions = cxn.sessManager.activeOrchestrator()
+	}
+	return st
+}
+
+// streamsStatus returns the detail of the live streams of the node, ordered
+// by manifest ID
+func (s *LivepeerServer) streamsStatus() []StreamStatus {
+	external := s.externalManifestIDs()
+	res := []StreamStatus{}
+	s.rtmpConnections.forEach(func(mid core.ManifestID, cxn *rtmpConnection) bool {
+		res = append(res, s.streamStatus(mid, cxn, external))
+		return true
+	})
+	sort.Slice(res, func(i, j int) bool { return res[i].ManifestID < res[j].ManifestID })
+	return res
+}
+
+// streamStatusOf returns the detail of the live stream `mid`, given by its
+// internal or external manifest ID
+func (s *LivepeerServer) streamStatusOf(mid core.ManifestID) (StreamStatus, bool) {
+	mid = s.streamAliases.resolve(mid)
+	cxn, ok := s.rtmpConnections.get(mid)
+	if !ok {
+		return StreamStatus{}, false
+	}
+	return s.streamStatus(mid, cxn, s.externalManifestIDs()), true
+}
+
+// externalManifestIDs returns the external manifest IDs of streams by their
+// internal ID
+func (s *LivepeerServer) externalManifestIDs() map[core.ManifestID]core.ManifestID {
+	external := make(map[core.ManifestID]core.ManifestID)
+	for _, alias := range s.streamAliases.list() {
+		external[alias.Internal] = alias.External
+	}
+	return external
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// TLSPolicy, if set, restricts the TLS versions and cipher suites accepted by
+// the orchestrator's service endpoint and the broadcaster's HTTPS server, and
+// has them send a Strict-Transport-Security header
+var TLSPolicy *TLSConfigPolicy
+
+// TLSConfigPolicy holds the TLS settings of the node's listeners. A nil
+// *TLSConfigPolicy keeps the Go defaults and sends no HSTS header.
+type TLSConfigPolicy struct {
+	MinVersion uint16
+	// CipherSuites applies to TLS 1.2 and below; TLS 1.3 suites are not
+	// configurable. Empty for the Go defaults.
+	CipherSuites []uint16
+	// HSTSMaxAge is the max-age of the Strict-Transport-Security header; 0
+	// to not send the header
+	HSTSMaxAge            time.Duration
+	HSTSIncludeSubdomains bool
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsCipherSuites = map[string]uint16{
+	"TLS_RSA_WITH_AES_128_CBC_SHA":            tls.TLS_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_RSA_WITH_AES_256_CBC_SHA":            tls.TLS_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_RSA_WITH_AES_128_GCM_SHA256":         tls.TLS_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_RSA_WITH_AES_256_GCM_SHA384":         tls.TLS_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA":    tls.TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA":      tls.TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA,
+	"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256":   tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256": tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	"TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384":   tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384": tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	"TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305":    tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+	"TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305":  tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+}
+
+// NewTLSConfigPolicy parses a minimum TLS version such as "1.2" and a comma
+// separated list of cipher suite names; either may be empty for the Go
+// defaults. Returns nil if nothing is configured.
+func NewTLSConfigPolicy(minVersion, cipherSuites string, hstsMaxAge time.Duration, hstsIncludeSubdomains bool) (*TLSConfigPolicy, error) {
+	if minVersion == "" && cipherSuites == "" && hstsMaxAge <= 0 {
+		return nil, nil
+	}
+	p := &TLSConfigPolicy{HSTSMaxAge: hstsMaxAge, HSTSIncludeSubdomains: hstsIncludeSubdomains}
+	if minVersion != "" {
+		v, ok := tlsVersions[minVersion]
+		if !ok {
+			return nil, fmt.Errorf("unknown TLS version %q", minVersion)
+		}
+		p.MinVersion = v
+	}
+	// gRPC needs HTTP/2, which needs one of these suites over TLS 1.2
+	http2Capable := false
+	for _, name := range strings.Split(cipherSuites, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		id, ok := tlsCipherSuites[strings.ToUpper(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown or unsupported TLS cipher suite %q", name)
+		}
+		if id == tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 || id == tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+			http2Capable = true
+		}
+		p.CipherSuites = append(p.CipherSuites, id)
+	}
+	if len(p.CipherSuites) > 0 && !http2Capable {
+		return nil, fmt.Errorf("TLS cipher suites must include TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 for HTTP/2")
+	}
+	return p, nil
+}
+
+// configureTLS applies the policy to a server TLS config
+func (p *TLSConfigPolicy) configureTLS(cfg *tls.Config) {
+	if p == nil {
+		return
+	}
+	if p.MinVersion != 0 {
+		cfg.MinVersion = p.MinVersion
+	}
+	if len(p.CipherSuites) > 0 {
+		cfg.CipherSuites = p.CipherSuites
+		cfg.PreferServerCipherSuites = true
+	}
+}
+
+// hsts adds the Strict-Transport-Security header to responses sent over TLS
+func (p *TLSConfigPolicy) hsts(h http.Handler) http.Handler {
+	if p == nil || p.HSTSMaxAge <= 0 {
+		return h
+	}
+	value := fmt.Sprintf("max-age=%d", int64(p.HSTSMaxAge/time.Second))
+	if p.HSTSIncludeSubdomains {
+		value += "; includeSubDomains"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil {
+			w.Header().Set("Strict-Transport-Security", value)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTLSConfigPolicy(t *testing.T) {
+	assert := assert.New(t)
+
+	p, err := NewTLSConfigPolicy("", "", 0, true)
+	assert.Nil(err)
+	assert.Nil(p)
+	// nil policy leaves the config alone
+	cfg := &tls.Config{}
+	p.configureTLS(cfg)
+	assert.Equal(uint16(0), cfg.MinVersion)
+
+	p, err = NewTLSConfigPolicy("1.2", "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls_ecdhe_rsa_with_aes_256_gcm_sha384", 0, false)
+	require.Nil(t, err)
+	p.configureTLS(cfg)
+	assert.Equal(uint16(tls.VersionTLS12), cfg.MinVersion)
+	assert.Equal([]uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384}, cfg.CipherSuites)
+	assert.True(cfg.PreferServerCipherSuites)
+
+	_, err = NewTLSConfigPolicy("1.4", "", 0, false)
+	assert.EqualError(err, `unknown TLS version "1.4"`)
+	_, err = NewTLSConfigPolicy("", "TLS_RSA_WITH_RC4_128_SHA", 0, false)
+	assert.EqualError(err, `unknown or unsupported TLS cipher suite "TLS_RSA_WITH_RC4_128_SHA"`)
+	// HTTP/2 can't be negotiated without an AES-128-GCM suite
+	_, err = NewTLSConfigPolicy("", "TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384", 0, false)
+	assert.NotNil(err)
+}
+
+func TestTLSConfigPolicy_HSTS(t *testing.T) {
+	assert := assert.New(t)
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	var p *TLSConfigPolicy
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "https://example.com/", nil)
+	p.hsts(h).ServeHTTP(rec, req)
+	assert.Empty(rec.Header().Get("Strict-Transport-Security"))
+
+	p, err := NewTLSConfigPolicy("", "", 365*24*time.Hour, true)
+	require.Nil(t, err)
+	rec = httptest.NewRecorder()
+	p.hsts(h).ServeHTTP(rec, req)
+	assert.Equal("max-age=31536000; includeSubDomains", rec.Header().Get("Strict-Transport-Security"))
+
+	// not sent over plain HTTP
+	rec = httptest.NewRecorder()
+	p.hsts(h).ServeHTTP(rec, httptest.NewRequest("GET", "http://example.com/", nil))
+	assert.Empty(rec.Header().Get("Strict-Transport-Security"))
+}
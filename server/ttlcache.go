@@ -0,0 +1,66 @@
+package server
+
+import (
+	"sync"
+	"time"
+)
+
+// ttlCache is a cache of values expiring a fixed time after they were set.
+// It runs on the clock of the server so that tests can expire entries
+// without sleeping. Expired entries are pruned as new ones are set, so it
+// needs no janitor goroutine.
+type ttlCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]ttlCacheEntry
+	pruned  time.Time
+}
+
+type ttlCacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+func newTTLCache(ttl time.Duration) *ttlCache {
+	return &ttlCache{ttl: ttl, entries: make(map[string]ttlCacheEntry)}
+}
+
+// get returns the value of `key` unless it is missing or expired
+func (c *ttlCache) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok || !clock.Now().Before(e.expires) {
+		return nil, false
+	}
+	return e.value, true
+}
+
+// set stores the value of `key` for the time to live of the cache, replacing
+// any earlier one
+func (c *ttlCache) set(key string, value interface{}) {
+	c.setFor(key, value, c.ttl)
+}
+
+// setFor stores the value of `key` until `ttl` passed
+func (c *ttlCache) setFor(key string, value interface{}, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := clock.Now()
+	if now.Sub(c.pruned) >= c.ttl {
+		for k, e := range c.entries {
+			if !now.Before(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		c.pruned = now
+	}
+	c.entries[key] = ttlCacheEntry{value: value, expires: now.Add(ttl)}
+}
+
+// len returns the number of entries, including expired ones not pruned yet
+func (c *ttlCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.entries)
+}
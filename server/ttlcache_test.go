@@ -0,0 +1,48 @@
+package server
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTTLCache(t *testing.T) {
+	assert := assert.New(t)
+	c := useFakeClock(t)
+	cache := newTTLCache(time.Minute)
+
+	_, ok := cache.get("a")
+	assert.False(ok)
+
+	cache.set("a", 1)
+	cache.setFor("b", 2, time.Hour)
+	v, ok := cache.get("a")
+	assert.True(ok)
+	assert.Equal(1, v)
+
+	// entries expire on the clock of the server
+	c.Advance(time.Minute - time.Second)
+	_, ok = cache.get("a")
+	assert.True(ok)
+	c.Advance(time.Second)
+	_, ok = cache.get("a")
+	assert.False(ok)
+	v, ok = cache.get("b")
+	assert.True(ok)
+	assert.Equal(2, v)
+
+	// replacing an entry extends it
+	cache.set("b", 3)
+	c.Advance(30 * time.Second)
+	v, ok = cache.get("b")
+	assert.True(ok)
+	assert.Equal(3, v)
+
+	// expired entries are pruned as new ones are set
+	cache.set("c", 4)
+	assert.Equal(2, cache.len())
+	c.Advance(time.Minute)
+	cache.set("d", 5)
+	assert.Equal(1, cache.len())
+}
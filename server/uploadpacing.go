@@ -0,0 +1,135 @@
+package server
+
+import (
+	"context"
+	"io"
+	"math"
+	"sync"
+	"time"
+)
+
+// UploadPacing, if set, paces the uploads of segments to orchestrators so
+// that a broadcaster on a constrained uplink leaves room for ingest and for
+// the uploads of other streams
+var UploadPacing *UploadPacer
+
+// amount of data paced at once
+const uploadChunkSize = 32 * 1024
+
+// UploadPacer caps the bandwidth of the uploads of the node, and that of the
+// uploads to each orchestrator. Uploads share the bandwidth chunk by chunk in
+// the order they ask for it, so that a large source segment doesn't hold up
+// those of other streams until it is sent.
+type UploadPacer struct {
+	node *bandwidthLimiter
+
+	mu       sync.Mutex
+	orchRate float64
+	// by orchestrator service URI
+	orchs map[string]*bandwidthLimiter
+}
+
+// NewUploadPacer returns a pacer capping the uploads of the node at
+// `nodeBitrate` and those to each orchestrator at `orchBitrate`, in bits per
+// second; 0 for no cap
+func NewUploadPacer(nodeBitrate, orchBitrate int64) *UploadPacer {
+	return &UploadPacer{
+		node:     newBandwidthLimiter(float64(nodeBitrate) / 8),
+		orchRate: float64(orchBitrate) / 8,
+		orchs:    make(map[string]*bandwidthLimiter),
+	}
+}
+
+// reader returns `r`, read no faster than the bandwidth left for uploads to
+// the orchestrator `orch`
+func (p *UploadPacer) reader(ctx context.Context, orch string, r io.Reader) io.Reader {
+	if p == nil {
+		return r
+	}
+	return &pacedReader{ctx: ctx, r: r, limiters: []*bandwidthLimiter{p.node, p.orch(orch)}}
+}
+
+func (p *UploadPacer) orch(orch string) *bandwidthLimiter {
+	if p.orchRate <= 0 {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	l, ok := p.orchs[orch]
+	if !ok {
+		l = newBandwidthLimiter(p.orchRate)
+		p.orchs[orch] = l
+	}
+	return l
+}
+
+// bandwidthLimiter is a token bucket of bytes. Its tokens may go negative so
+// that senders waiting on it are served in the order they reserved bytes.
+type bandwidthLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newBandwidthLimiter returns a limiter of `rate` bytes per second, letting
+// through bursts of a tenth of a second; nil if `rate` is not positive
+func newBandwidthLimiter(rate float64) *bandwidthLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	burst := math.Max(rate/10, uploadChunkSize)
+	return &bandwidthLimiter{rate: rate, burst: burst, tokens: burst, last: clock.Now()}
+}
+
+// reserve takes `n` bytes off the limiter, returning how long to wait before
+// sending them
+func (l *bandwidthLimiter) reserve(n int) time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := clock.Now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens / l.rate * float64(time.Second))
+}
+
+// pacedReader holds back the data it reads until `limiters` let it through
+type pacedReader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*bandwidthLimiter
+}
+
+func (p *pacedReader) Read(b []byte) (int, error) {
+	if len(b) > uploadChunkSize {
+		b = b[:uploadChunkSize]
+	}
+	n, err := p.r.Read(b)
+	if n <= 0 {
+		return n, err
+	}
+	var wait time.Duration
+	for _, l := range p.limiters {
+		if d := l.reserve(n); d > wait {
+			wait = d
+		}
+	}
+	if wait > 0 {
+		timer := clock.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C():
+		case <-p.ctx.Done():
+			return 0, p.ctx.Err()
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readChunks reads `r` a chunk at a time, as HTTP transports do
+func readChunks(r io.Reader) ([]byte, error) {
+	buf := make([]byte, uploadChunkSize)
+	var out []byte
+	for {
+		n, err := r.Read(buf)
+		out = append(out, buf[:n]...)
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return out, err
+		}
+	}
+}
+
+func TestBandwidthLimiter_Reserve(t *testing.T) {
+	assert := assert.New(t)
+	c := useFakeClock(t)
+
+	assert.Nil(newBandwidthLimiter(0))
+	assert.Zero((*bandwidthLimiter)(nil).reserve(1 << 20))
+
+	// bursts of a tenth of a second, or a chunk
+	l := newBandwidthLimiter(1e6)
+	assert.Equal(1e5, l.burst)
+	assert.Equal(float64(uploadChunkSize), newBandwidthLimiter(1000).burst)
+
+	assert.Zero(l.reserve(1e5))
+	assert.Equal(100*time.Millisecond, l.reserve(1e5))
+	// later reservations wait behind earlier ones
+	assert.Equal(300*time.Millisecond, l.reserve(2e5))
+
+	// refilled as time goes by, up to the burst
+	c.Advance(time.Hour)
+	assert.Zero(l.reserve(1e5))
+	assert.Equal(time.Millisecond, l.reserve(1e3))
+}
+
+func TestUploadPacer_Reader(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	c := useFakeClock(t)
+
+	var p *UploadPacer
+	r := bytes.NewReader([]byte("segment"))
+	assert.Equal(r, p.reader(context.Background(), "orch", r))
+
+	// one chunk per second per orchestrator, under a node cap of two
+	p = NewUploadPacer(2*8*uploadChunkSize, 8*uploadChunkSize)
+	assert.Same(p.orch("a"), p.orch("a"))
+	assert.NotSame(p.orch("a"), p.orch("b"))
+	assert.Nil(NewUploadPacer(8*uploadChunkSize, 0).orch("a"))
+
+	data := bytes.Repeat([]byte{0x47}, 3*uploadChunkSize)
+	read := make(chan []byte)
+	go func() {
+		b, _ := readChunks(p.reader(context.Background(), "a", bytes.NewReader(data)))
+		read <- b
+	}()
+	// the first chunk goes through in the burst, the others a second apart
+	for i := 0; i < 2; i++ {
+		require.True(c.waitTimers(1))
+		c.Advance(time.Second)
+	}
+	select {
+	case b := <-read:
+		assert.Equal(data, b)
+	case <-time.After(time.Second):
+		t.Fatal("paced upload did not finish")
+	}
+
+	// uploads give up when their request does
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error)
+	go func() {
+		_, err := readChunks(p.reader(ctx, "a", bytes.NewReader(data)))
+		errs <- err
+	}()
+	require.True(c.waitTimers(1))
+	cancel()
+	select {
+	case err := <-errs:
+		assert.Equal(context.Canceled, err)
+	case <-time.After(time.Second):
+		t.Fatal("paced upload was not canceled")
+	}
+}
@@ -0,0 +1,482 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/drivers"
+	"github.com/livepeer/go-livepeer/verification"
+	ffmpeg "github.com/livepeer/lpms/ffmpeg"
+	"github.com/livepeer/lpms/stream"
+)
+
+// vodRecordDir is the directory of a recording that the renditions of its
+// deferred transcode are saved to, next to the directories of the nodes that
+// recorded the source
+const vodRecordDir = "vod"
+
+// Kinds of the queued jobs of recordings, keyed by manifest ID
+const (
+	recordingTranscodeJobKind = "recordingTranscode"
+	recordingFinalizeJobKind  = "recordingFinalize"
+)
+
+// recordedSession is the source recorded for a session of a stream
+type recordedSession struct {
+	manifestID string
+	profile    core.JsonMediaTrack
+	segments   []recordedSegment
+}
+
+type recordedSegment struct {
+	seqNo    uint64
+	uri      string
+	duration float64
+}
+
+type transcodeRecordingResponse struct {
+	JobID      string   `json:"jobID"`
+	ManifestID string   `json:"manifestID"`
+	Segments   int      `json:"segments"`
+	Profiles   []string `json:"profiles"`
+}
+
+// recordingJobPayload is the payload of the queued jobs of a recording
+type recordingJobPayload struct {
+	ManifestID string `json:"manifestID"`
+	// Manifests of the sessions of the recording
+	Manifests []string `json:"manifests"`
+	// Record store of the recording; that of the node if empty
+	RecordStore string `json:"recordStore,omitempty"`
+	// URL the recording is read from, if not the record store
+	ExtURL   string                `json:"extURL,omitempty"`
+	Profiles []ffmpeg.VideoProfile `json:"profiles,omitempty"`
+	// Package each rendition into a single MP4 once finalized
+	MP4 bool `json:"mp4,omitempty"`
+}
+
+// HandleTranscodeRecording handles POSTs to /recordings/{manifestID}/transcode
+// by transcoding the recorded source of the stream, typically a record only
+// one, into the profiles from the auth webhook. The renditions are saved next
+// to the source and the recording is finalized once they all are, which is
+// reported to the stream event webhook. Answers once the source is found and
+// the transcode is queued.
+func (s *LivepeerServer) HandleTranscodeRecording(w http.ResponseWriter, r *http.Request) {
+	r.URL.Host = r.Host
+	if r.URL.Scheme == "" {
+		r.URL.Scheme = "http"
+	}
+	pp := strings.Split(r.URL.Path, "/")
+	if len(pp) != 4 || pp[2] == "" {
+		glog.Errorf(`/recordings transcode request wrong url structure url=%s host=%s`, common.RedactURL(r.URL.String()), r.Host)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	manifestID := pp[2]
+	resp, err := authenticateStream(r.URL.String())
+	if err != nil {
+		glog.Errorf("Authentication denied for url=%s err=%v", common.RedactURL(r.URL.String()), err)
+		if strings.Contains(err.Error(), "not found") {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusForbidden)
+		}
+		return
+	}
+
+	profiles := BroadcastJobVideoProfiles
+	manifests := []string{manifestID}
+	var extURL, storeURL string
+	if resp != nil {
+		parsedProfiles, err := jsonProfileToVideoProfile(resp)
+		if err != nil {
+			glog.Errorf("Failed to parse JSON video profile for url=%s err=%v", common.RedactURL(r.URL.String()), err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if p := append(parsePresets(resp.Presets), parsedProfiles...); len(p) > 0 {
+			profiles = p
+		}
+		manifests = append(resp.PreviousSessions, manifestID)
+		extURL = resp.RecordObjectStoreURL
+		storeURL = resp.RecordObjectStore
+	}
+	if err := validateProfiles(profiles); err != nil {
+		glog.Errorf("Invalid profiles for url=%s err=%v", common.RedactURL(r.URL.String()), err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ros, err := recordStore(resp)
+	if err != nil {
+		glog.Errorf("Error parsing OS URL err=%v request url=%s", err, common.RedactURL(r.URL.String()))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if ros == nil {
+		glog.Errorf("No record object store defined for request url=%s", common.RedactURL(r.URL.String()))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	sess := ros.NewSession(manifestID)
+
+	sources, err := readRecordedSources(r.Context(), sess, manifests)
+	if err != nil {
+		glog.Errorf("Error reading recording url=%s err=%v", common.RedactURL(r.URL.String()), err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	var numSegs int
+	for _, src := range sources {
+		numSegs += len(src.segments)
+	}
+	if numSegs == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	job, err := s.jobs.submit(recordingTranscodeJobKind, manifestID, &recordingJobPayload{
+		ManifestID:  manifestID,
+		Manifests:   manifests,
+		RecordStore: storeURL,
+		ExtURL:      extURL,
+		Profiles:    profiles,
+		MP4:         RecordingMP4,
+	})
+	if err == errJobExists {
+		http.Error(w, "recording already being transcoded", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		glog.Errorf("Error queueing recording transcode url=%s err=%v", common.RedactURL(r.URL.String()), err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	glog.Infof("Queued recording transcode manifestID=%s jobID=%s sessions=%d segments=%d profiles=%v", manifestID, job.ID, len(sources), numSegs, common.ProfilesNames(profiles))
+
+	names := make([]string, len(profiles))
+	for i, p := range profiles {
+		names[i] = p.Name
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(&transcodeRecordingResponse{JobID: job.ID, ManifestID: manifestID, Segments: numSegs, Profiles: names})
+}
+
+// runRecordingTranscode makes an attempt at a queued recording transcode
+func (s *LivepeerServer) runRecordingTranscode(ctx context.Context, job *runningJob) error {
+	var p recordingJobPayload
+	if err := job.decode(&p); err != nil {
+		return err
+	}
+	ros, err := recordStoreAt(p.RecordStore)
+	if err != nil {
+		return err
+	}
+	if ros == nil {
+		return errors.New("no record object store")
+	}
+	sess := ros.NewSession(p.ManifestID)
+	sources, err := readRecordedSources(ctx, sess, p.Manifests)
+	if err != nil {
+		return wrapError(ErrorCategoryStorage, true, err)
+	}
+	return s.transcodeRecording(ctx, ros, sess, p.Manifests, sources, p.Profiles, p.ExtURL, p.MP4, job.progress)
+}
+
+// finishRecordingTranscode reports a finished recording transcode to the
+// stream event webhook
+func finishRecordingTranscode(job jobInfo) {
+	ev := &streamEvent{Event: "recordingTranscoded", ManifestID: job.Key, Time: clock.Now().Unix()}
+	switch job.State {
+	case jobCompleted:
+	case jobFailed:
+		ev.Event, ev.Error = "recordingTranscodeFailed", job.Error
+	default:
+		return
+	}
+	sendStreamEvent(ev)
+}
+
+// HandleFinalizeRecording handles POSTs to /recordings/{manifestID}/finalize
+// by queueing the finalization of the recording: the playlists of all its
+// sessions are joined and saved, as they are when a finalized recording is
+// first requested
+func (s *LivepeerServer) HandleFinalizeRecording(w http.ResponseWriter, r *http.Request) {
+	r.URL.Host = r.Host
+	if r.URL.Scheme == "" {
+		r.URL.Scheme = "http"
+	}
+	pp := strings.Split(r.URL.Path, "/")
+	if len(pp) != 4 || pp[2] == "" {
+		glog.Errorf(`/recordings finalize request wrong url structure url=%s host=%s`, common.RedactURL(r.URL.String()), r.Host)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	manifestID := pp[2]
+	resp, err := authenticateStream(r.URL.String())
+	if err != nil {
+		glog.Errorf("Authentication denied for url=%s err=%v", common.RedactURL(r.URL.String()), err)
+		if strings.Contains(err.Error(), "not found") {
+			w.WriteHeader(http.StatusNotFound)
+		} else {
+			w.WriteHeader(http.StatusForbidden)
+		}
+		return
+	}
+	p := &recordingJobPayload{ManifestID: manifestID, Manifests: []string{manifestID}, MP4: RecordingMP4}
+	if resp != nil {
+		p.Manifests = append(resp.PreviousSessions, manifestID)
+		p.RecordStore = resp.RecordObjectStore
+		p.ExtURL = resp.RecordObjectStoreURL
+	}
+	if ros, err := recordStoreAt(p.RecordStore); err != nil || ros == nil {
+		glog.Errorf("No valid record object store for request url=%s err=%v", common.RedactURL(r.URL.String()), err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	job, err := s.jobs.submit(recordingFinalizeJobKind, manifestID, p)
+	if err == errJobExists {
+		http.Error(w, "recording already being finalized", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		glog.Errorf("Error queueing recording finalization url=%s err=%v", common.RedactURL(r.URL.String()), err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	glog.Infof("Queued recording finalization manifestID=%s jobID=%s", manifestID, job.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(&job)
+}
+
+// runRecordingFinalize makes an attempt at a queued recording finalization
+func runRecordingFinalize(ctx context.Context, job *runningJob) error {
+	var p recordingJobPayload
+	if err := job.decode(&p); err != nil {
+		return err
+	}
+	ros, err := recordStoreAt(p.RecordStore)
+	if err != nil {
+		return err
+	}
+	if ros == nil {
+		return errors.New("no record object store")
+	}
+	return finalizeRecording(ctx, ros.NewSession(p.ManifestID), p.Manifests, p.ExtURL, p.MP4)
+}
+
+// recordStoreAt returns the record store at `osURL`, or that of the node if
+// empty
+func recordStoreAt(osURL string) (drivers.OSDriver, error) {
+	return recordStore(&authWebhookResponse{RecordObjectStore: osURL})
+}
+
+// readRecordedSources returns the recorded source of each of `manifests`
+func readRecordedSources(ctx context.Context, sess drivers.OSSession, manifests []string) ([]*recordedSession, error) {
+	var sources []*recordedSession
+	for _, mid := range manifests {
+		filesMap, jsonFiles, _, err := getPlaylistsFromStore(ctx, sess, []string{mid})
+		if err != nil {
+			return nil, err
+		}
+		if len(jsonFiles) == 0 {
+			continue
+		}
+		jspl, err := joinRecordings(ctx, sess, []string{mid}, filesMap, jsonFiles, false, "source")
+		if err != nil {
+			return nil, err
+		}
+		src := &recordedSession{manifestID: mid}
+		for _, track := range jspl.Tracks {
+			if track.Name == "source" {
+				src.profile = track
+			}
+		}
+		for _, seg := range jspl.Segments["source"] {
+			src.segments = append(src.segments, recordedSegment{
+				seqNo:    seg.SeqNo,
+				uri:      seg.URI,
+				duration: float64(seg.DurationMs) / 1000.0,
+			})
+		}
+		sources = append(sources, src)
+	}
+	return sources, nil
+}
+
+// transcodeRecording transcodes the recorded `sources` into `profiles` and
+// finalizes the recording of `manifests` with the renditions, packaging them
+// into MP4s if `mp4` is set. Reports the share of sessions transcoded to
+// `progress`.
+func (s *LivepeerServer) transcodeRecording(ctx context.Context, ros drivers.OSDriver, sess drivers.OSSession,
+	manifests []string, sources []*recordedSession, profiles []ffmpeg.VideoProfile, extURL string, mp4 bool, progress func(float64)) error {
+
+	for i, src := range sources {
+		if err := s.transcodeRecordedSession(ctx, ros, sess, src, profiles); err != nil {
+			return err
+		}
+		progress(float64(i+1) / float64(len(sources)))
+	}
+	return finalizeRecording(ctx, sess, manifests, extURL, mp4)
+}
+
+// finalizeRecording verifies the checksums of the recording of `manifests`,
+// joins the playlists of all its sessions and saves the finalized playlists,
+// along with an MP4 of each track if `mp4` is set
+func finalizeRecording(ctx context.Context, sess drivers.OSSession, manifests []string, extURL string, mp4 bool) error {
+	filesMap, jsonFiles, _, err := getPlaylistsFromStore(ctx, sess, manifests)
+	if err != nil {
+		return wrapError(ErrorCategoryStorage, true, err)
+	}
+	if len(jsonFiles) == 0 {
+		return errors.New("nothing recorded")
+	}
+	if err := verifyRecordingChecksums(ctx, sess, manifests); err != nil {
+		return err
+	}
+	jspl, err := joinRecordings(ctx, sess, manifests, filesMap, jsonFiles, true, "")
+	if err != nil {
+		return err
+	}
+	masterPList, mediaLists, err := recordingPlaylists(jspl)
+	if err != nil {
+		return err
+	}
+	if err := saveRecordingPlaylists(sess, manifests, jspl, masterPList, mediaLists, extURL); err != nil {
+		return err
+	}
+	if !mp4 {
+		return nil
+	}
+	return packageRecordingMP4s(ctx, sess, manifests, jspl)
+}
+
+// transcodeRecordedSession transcodes the source recorded for a session of
+// a stream, saving the renditions and their JSON playlist under vodRecordDir
+// of the session
+func (s *LivepeerServer) transcodeRecordedSession(ctx context.Context, ros drivers.OSDriver, sess drivers.OSSession,
+	src *recordedSession, profiles []ffmpeg.VideoProfile) error {
+
+	mid := core.ManifestID(src.manifestID)
+	params := &core.StreamParameters{
+		ManifestID: mid,
+		Profiles:   append([]ffmpeg.VideoProfile(nil), profiles...),
+		OS:         ros.NewSession(path.Join(src.manifestID, vodRecordDir)),
+		Resolution: src.profile.Resolution,
+	}
+	caps, err := core.JobCapabilities(params)
+	if err != nil {
+		return err
+	}
+	params.Capabilities = caps
+	cxn := s.newVODConnection(params)
+	defer cxn.sessManager.cleanup()
+
+	jspl := core.NewJSONPlaylist()
+	for _, rseg := range src.segments {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		data, err := readRecordedSegment(ctx, sess, src.manifestID, rseg.uri)
+		if err != nil {
+			return fmt.Errorf("reading source manifestID=%s seqNo=%d: %w", mid, rseg.seqNo, err)
+		}
+		ext := path.Ext(rseg.uri)
+		if ext == "" {
+			ext = ".ts"
+		}
+		seg := &stream.HLSSegment{SeqNo: rseg.seqNo, Data: data, Duration: rseg.duration}
+		job, err := transcodeStoredSegment(cxn, seg, fmt.Sprintf("source/%d%s", rseg.seqNo, ext))
+		if err != nil {
+			return fmt.Errorf("transcoding manifestID=%s seqNo=%d: %w", mid, rseg.seqNo, err)
+		}
+		for i, url := range job.URLs {
+			var encoder *core.EncoderSettings
+			if job.Result != nil && i < len(job.Result.Segments) {
+				encoder = core.EncoderSettingsFromNet(job.Result.Segments[i].EncoderSettings)
+			}
+			jspl.InsertHLSSegment(&job.Session.Params.Profiles[i], seg.SeqNo, url, seg.Duration, encoder)
+		}
+	}
+
+	b, err := json.Marshal(jspl)
+	if err != nil {
+		return err
+	}
+	_, err = params.OS.SaveData(fmt.Sprintf("playlist_%d.json", clock.Now().UnixNano()), b, nil)
+	return err
+}
+
+// newVODConnection returns a connection, which is not registered with the
+// server, to transcode recorded segments with
+func (s *LivepeerServer) newVODConnection(params *core.StreamParameters) *rtmpConnection {
+	var stakeRdr stakeReader
+	if s.LivepeerNode.Eth != nil {
+		stakeRdr = &storeStakeReader{store: s.LivepeerNode.Database}
+	}
+	vProfile := ffmpeg.VideoProfile{
+		Name:       "source",
+		Resolution: params.Resolution,
+		Bitrate:    "4000k",
+		Format:     params.Format,
+	}
+	return &rtmpConnection{
+		mid:         params.ManifestID,
+		nonce:       rand.Uint64(),
+		pl:          core.NewBasicPlaylistManager(params.ManifestID, params.OS, nil),
+		profile:     &vProfile,
+		params:      params,
+		sessManager: NewSessionManager(s.LivepeerNode, params, NewMinLSSelectorWithWeights(stakeRdr, 1.0, selectionWeights(params))),
+		pipeline:    newSegmentPipeline(s.segmentMiddleware),
+		journal:     newSegmentJournal(s.LivepeerNode.Database, params.ManifestID),
+		lastUsed:    clock.Now(),
+	}
+}
+
+// readRecordedSegment reads the segment saved at `uri` from the recording of
+// `manifestID`
+func readRecordedSegment(ctx context.Context, sess drivers.OSSession, manifestID, uri string) ([]byte, error) {
+	name := uri
+	if i := strings.Index(uri, manifestID+"/"); i != -1 {
+		name = uri[i:]
+	}
+	fi, err := sess.ReadData(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	defer fi.Body.Close()
+	return ioutil.ReadAll(fi.Body)
+}
+
+// transcodeStoredSegment transcodes a source segment that is stored already,
+// under `name`, retrying as live segments are. Unlike live segments, stored
+// ones fail if no orchestrator is available rather than stay untranscoded.
+func transcodeStoredSegment(cxn *rtmpConnection, seg *stream.HLSSegment, name string) (*SegmentJob, error) {
+	job := &SegmentJob{ManifestID: cxn.mid, Nonce: cxn.nonce, Segment: seg, Name: name, cxn: cxn}
+	if Policy != nil {
+		job.verifier = verification.NewSegmentVerifier(Policy)
+	}
+	start := clock.Now()
+	var err error
+	for i := 0; i < MaxAttempts; i++ {
+		if _, err = job.transcode(); err == nil && job.Session == nil {
+			err = errNoOrchs
+		}
+		if err == nil || isNonRetryableError(err) {
+			break
+		}
+	}
+	cxn.journal.record(job, start, err)
+	meterSegment(job, start, err)
+	return job, err
+}
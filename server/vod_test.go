@@ -0,0 +1,227 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/drivers"
+	"github.com/livepeer/lpms/ffmpeg"
+	"github.com/livepeer/lpms/stream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// saveRecordedPlaylist saves the JSON playlist of a node recording segments
+// `seqNos` of the rendition `profile` of the stream `mid` to `ros`
+func saveRecordedPlaylist(t *testing.T, ros drivers.OSDriver, mid, node string, profile ffmpeg.VideoProfile, seqNos ...uint64) {
+	jpl := core.NewJSONPlaylist()
+	for _, seqNo := range seqNos {
+		jpl.InsertHLSSegment(&profile, seqNo, fmt.Sprintf("https://pub.test/%s/%s/%s/%d.ts", mid, node, profile.Name, seqNo), 2, nil)
+	}
+	b, err := json.Marshal(jpl)
+	require.Nil(t, err)
+	_, err = ros.NewSession(mid).SaveData(node+"/playlist_1.json", b, nil)
+	require.Nil(t, err)
+}
+
+func TestProcessSegment_RecordOnly(t *testing.T) {
+	assert := assert.New(t)
+
+	bcastOS := &stubOSSession{host: "test://broad.com"}
+	sess := genBcastSess(t, "", bcastOS, "")
+	sourceProfile := ffmpeg.P240p30fps16x9
+	cxn := &rtmpConnection{
+		pl:          &stubPlaylistManager{os: bcastOS},
+		profile:     &sourceProfile,
+		params:      &core.StreamParameters{RecordOnly: true},
+		sessManager: bsmWithSessList([]*BroadcastSession{sess}),
+	}
+	urls, err := processSegment(cxn, &stream.HLSSegment{}, nil)
+	assert.Nil(err)
+	assert.Nil(urls)
+	// only the source is saved
+	assert.Equal([]string{"P240p30fps16x9/0.ts"}, bcastOS.saved)
+}
+
+func TestTranscodeStoredSegment(t *testing.T) {
+	assert := assert.New(t)
+
+	oldDownloadSeg := downloadSeg
+	defer func() { downloadSeg = oldDownloadSeg }()
+	downloadSeg = func(url string) ([]byte, error) { return []byte(url), nil }
+
+	bcastOS := &stubOSSession{host: "test://broad.com"}
+	sess := genBcastSess(t, "", bcastOS, "")
+	sourceProfile := ffmpeg.P240p30fps16x9
+	cxn := &rtmpConnection{
+		pl:          &stubPlaylistManager{os: bcastOS},
+		profile:     &sourceProfile,
+		sessManager: bsmWithSessList([]*BroadcastSession{sess}),
+	}
+	job, err := transcodeStoredSegment(cxn, &stream.HLSSegment{SeqNo: 3}, "source/3.ts")
+	assert.Nil(err)
+	assert.Equal(sess, job.Session)
+	assert.Equal([]string{"saved_P144p30fps16x9/3.ts"}, job.URLs)
+	// the source is not saved again
+	assert.Equal([]string{"P144p30fps16x9/3.ts"}, bcastOS.saved)
+
+	// stored segments fail rather than stay untranscoded
+	cxn.sessManager = bsmWithSessList(nil)
+	job, err = transcodeStoredSegment(cxn, &stream.HLSSegment{SeqNo: 4}, "source/4.ts")
+	assert.Equal(errNoOrchs, err)
+	assert.Nil(job.Session)
+	assert.Nil(job.URLs)
+}
+
+func TestReadRecordedSources(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	drivers.Testing = true
+
+	ros, err := drivers.ParseOSURL("memory://vodstore1", true)
+	require.Nil(err)
+	sess := ros.NewSession("mid")
+	source := ffmpeg.VideoProfile{Name: "source", Resolution: "1280x720", Bitrate: "4000k"}
+	saveRecordedPlaylist(t, ros, "prev", "node1", source, 0, 1)
+	saveRecordedPlaylist(t, ros, "mid", "node1", source, 0, 1)
+	saveRecordedPlaylist(t, ros, "mid", "node2", source, 2)
+	saveRecordedPlaylist(t, ros, "mid", vodRecordDir, ffmpeg.P144p30fps16x9, 0)
+
+	sources, err := readRecordedSources(context.Background(), sess, []string{"prev", "mid", "none"})
+	require.Nil(err)
+	require.Len(sources, 2)
+	assert.Equal("prev", sources[0].manifestID)
+	assert.Len(sources[0].segments, 2)
+	assert.Equal("mid", sources[1].manifestID)
+	assert.Equal("1280x720", sources[1].profile.Resolution)
+	assert.Equal([]recordedSegment{
+		{seqNo: 0, uri: "https://pub.test/mid/node1/source/0.ts", duration: 2},
+		{seqNo: 1, uri: "https://pub.test/mid/node1/source/1.ts", duration: 2},
+		{seqNo: 2, uri: "https://pub.test/mid/node2/source/2.ts", duration: 2},
+	}, sources[1].segments)
+
+	_, err = sess.SaveData("node1/source/0.ts", []byte("source data"), nil)
+	require.Nil(err)
+	data, err := readRecordedSegment(context.Background(), sess, "mid", sources[1].segments[0].uri)
+	assert.Nil(err)
+	assert.Equal([]byte("source data"), data)
+}
+
+func TestHandleTranscodeRecording(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	drivers.Testing = true
+	s := setupServer()
+	defer serverCleanup(s)
+
+	var authResp atomic.Value
+	authResp.Store(`{"manifestID":"vodmid", "recordObjectStore": "memory://vodstore2", "recordOnly": true, "presets": ["P144p30fps16x9"]}`)
+	whts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(authResp.Load().(string)))
+	}))
+	defer whts.Close()
+	oldURL := AuthWebhookURL
+	defer func() { AuthWebhookURL = oldURL }()
+	AuthWebhookURL = whts.URL
+
+	makeReq := func() (int, string) {
+		writer := httptest.NewRecorder()
+		s.HandleRecordings(writer, httptest.NewRequest("POST", "/recordings/vodmid/transcode", nil))
+		resp := writer.Result()
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode, string(body)
+	}
+
+	// nothing recorded
+	code, _ := makeReq()
+	assert.Equal(http.StatusNotFound, code)
+
+	ros, err := drivers.ParseOSURL("memory://vodstore2", true)
+	require.Nil(err)
+	saveRecordedPlaylist(t, ros, "vodmid", "node1", ffmpeg.VideoProfile{Name: "source"}, 0)
+	_, err = ros.NewSession("vodmid").SaveData("node1/source/0.ts", []byte("source data"), nil)
+	require.Nil(err)
+
+	// a transcode of the recording is already queued
+	s.jobs.mu.Lock()
+	s.jobs.jobs["queued"] = &queuedJob{info: jobInfo{ID: "queued", Kind: recordingTranscodeJobKind, Key: "vodmid", State: jobRunning}}
+	s.jobs.mu.Unlock()
+	code, _ = makeReq()
+	assert.Equal(http.StatusConflict, code)
+	s.jobs.mu.Lock()
+	delete(s.jobs.jobs, "queued")
+	s.jobs.mu.Unlock()
+
+	authResp.Store(`{"manifestID":"vodmid", "recordObjectStore": "memory://vodstore2", "profiles": [{"name": "odd", "width": 427, "height": 240, "bitrate": 400000}]}`)
+	code, body := makeReq()
+	assert.Equal(http.StatusBadRequest, code)
+	assert.Equal("odd resolution profile=odd resolution=427x240\n", body)
+
+	// the transcode is queued, failing here for lack of orchestrators
+	defer func(n int) { JobMaxAttempts = n }(JobMaxAttempts)
+	JobMaxAttempts = 1
+	authResp.Store(`{"manifestID":"vodmid", "recordObjectStore": "memory://vodstore2", "presets": ["P144p30fps16x9"]}`)
+	code, body = makeReq()
+	assert.Equal(http.StatusAccepted, code)
+	var res transcodeRecordingResponse
+	require.Nil(json.Unmarshal([]byte(body), &res))
+	assert.Equal(transcodeRecordingResponse{JobID: res.JobID, ManifestID: "vodmid", Segments: 1, Profiles: []string{"P144p30fps16x9"}}, res)
+	var job jobInfo
+	common.WaitAssert(t, 5*time.Second, func() bool {
+		job, _ = s.jobs.get(res.JobID)
+		return job.finished()
+	}, "recording transcode did not finish")
+	assert.Equal(jobFailed, job.State)
+	assert.Equal("vodmid", job.Key)
+	require.NotNil(job.Error)
+	assert.Equal(ErrorCategoryDiscovery, job.Error.Category)
+}
+
+func TestHandleFinalizeRecording(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	drivers.Testing = true
+	s := setupServer()
+	defer serverCleanup(s)
+
+	whts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"manifestID":"finmid", "recordObjectStore": "memory://vodstore3"}`))
+	}))
+	defer whts.Close()
+	oldURL := AuthWebhookURL
+	defer func() { AuthWebhookURL = oldURL }()
+	AuthWebhookURL = whts.URL
+
+	ros, err := drivers.ParseOSURL("memory://vodstore3", true)
+	require.Nil(err)
+	saveRecordedPlaylist(t, ros, "finmid", "node1", ffmpeg.P144p30fps16x9, 0, 1)
+
+	writer := httptest.NewRecorder()
+	s.HandleRecordings(writer, httptest.NewRequest("POST", "/recordings/finmid/finalize", nil))
+	resp := writer.Result()
+	defer resp.Body.Close()
+	require.Equal(http.StatusAccepted, resp.StatusCode)
+	var job jobInfo
+	require.Nil(json.NewDecoder(resp.Body).Decode(&job))
+	assert.Equal(recordingFinalizeJobKind, job.Kind)
+	assert.Equal("finmid", job.Key)
+
+	common.WaitAssert(t, 5*time.Second, func() bool {
+		job, _ = s.jobs.get(job.ID)
+		return job.finished()
+	}, "recording finalization did not finish")
+	assert.Equal(jobCompleted, job.State)
+	fi, err := ros.NewSession("finmid").ReadData(context.Background(), "finmid/index.m3u8")
+	require.Nil(err)
+	fi.Body.Close()
+}
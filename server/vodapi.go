@@ -0,0 +1,477 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/drivers"
+	ffmpeg "github.com/livepeer/lpms/ffmpeg"
+	"github.com/livepeer/lpms/stream"
+	"github.com/livepeer/m3u8"
+)
+
+// vodJobKind is the kind of the queued jobs of the VOD API
+const vodJobKind = "vod"
+
+// vodRequest is the JSON body of a POST to /vod, or its "request" part if the
+// source is uploaded as the "file" part of a multipart form
+type vodRequest struct {
+	// URL of the source, if it is not uploaded
+	URL      string        `json:"url"`
+	Presets  []string      `json:"presets"`
+	Profiles []jsonProfile `json:"profiles"`
+	// Object store the outputs are saved to, if not that of the auth webhook
+	// or the record store
+	ObjectStore string `json:"objectStore"`
+	// Also assemble an MP4 file of each rendition
+	MP4 bool `json:"mp4"`
+}
+
+// vodJobPayload is the payload of a queued VOD job
+type vodJobPayload struct {
+	// URL or path of the source
+	Source string `json:"source"`
+	// Work dir of the job, holding an uploaded source
+	Dir      string                `json:"dir"`
+	Profiles []ffmpeg.VideoProfile `json:"profiles"`
+	// Object store the outputs are saved to; the record store if empty
+	ObjectStore string `json:"objectStore,omitempty"`
+	MP4         bool   `json:"mp4"`
+}
+
+// vodJobResult is the result of a completed VOD job
+type vodJobResult struct {
+	// URL of the HLS master playlist
+	Playlist string `json:"playlist,omitempty"`
+	// URLs of the MP4 files, by rendition
+	MP4 map[string]string `json:"mp4,omitempty"`
+}
+
+// vodJobStatus is the status of a VOD job, as answered by the VOD API
+type vodJobStatus struct {
+	ID       string  `json:"id"`
+	State    string  `json:"state"`
+	Progress float64 `json:"progress"`
+	vodJobResult
+	Error *streamEventError `json:"error,omitempty"`
+}
+
+func newVODJobStatus(job jobInfo) *vodJobStatus {
+	st := &vodJobStatus{ID: job.ID, State: job.State, Progress: job.Progress, Error: job.Error}
+	if job.State == jobCompleted && len(job.Result) > 0 {
+		if err := json.Unmarshal(job.Result, &st.vodJobResult); err != nil {
+			glog.Errorf("Invalid result of VOD job id=%s err=%v", job.ID, err)
+		}
+	}
+	return st
+}
+
+// fileSegment is a segment of a VOD source cut to a local file
+type fileSegment struct {
+	path     string
+	duration float64
+}
+
+// segmentFile cuts the file or URL `src` into MPEG-TS segments of about
+// `segLen` in `dir`, returning them in order
+var segmentFile = func(src, dir string, segLen time.Duration) ([]fileSegment, error) {
+	playlist := filepath.Join(dir, "source.m3u8")
+	err := ffmpeg.RTMPToHLS(src, playlist, filepath.Join(dir, "source_%d.ts"), strconv.FormatFloat(segLen.Seconds(), 'f', -1, 64), 0)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(playlist)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	pl, _, err := m3u8.DecodeFrom(f, true)
+	if err != nil {
+		return nil, err
+	}
+	mpl, ok := pl.(*m3u8.MediaPlaylist)
+	if !ok {
+		return nil, errors.New("segmenter wrote a master playlist")
+	}
+	var segs []fileSegment
+	for _, seg := range mpl.Segments {
+		if seg == nil {
+			break
+		}
+		segs = append(segs, fileSegment{path: filepath.Join(dir, filepath.Base(seg.URI)), duration: seg.Duration})
+	}
+	return segs, nil
+}
+
+// remuxMP4 remuxes the MPEG-TS file `in` into the MP4 file `out`
+var remuxMP4 = func(in, out string) error {
+	_, err := ffmpeg.Transcode3(&ffmpeg.TranscodeOptionsIn{Fname: in, Accel: ffmpeg.Software}, []ffmpeg.TranscodeOptions{{
+		Oname:        out,
+		Accel:        ffmpeg.Software,
+		VideoEncoder: ffmpeg.ComponentOptions{Name: "copy"},
+		AudioEncoder: ffmpeg.ComponentOptions{Name: "copy"},
+		Muxer:        ffmpeg.ComponentOptions{Name: "mp4"},
+	}})
+	return err
+}
+
+// HandleVOD handles the VOD API. A POST to /vod queues a job transcoding a
+// source, by URL or upload, into a ladder by the orchestrators, as segments of
+// streams are, and answers with the status of the job. The HLS playlists of
+// the renditions, and MP4 files if asked for, are saved to an object store.
+// GET /vod/{id} answers with the status of a job.
+func (s *LivepeerServer) HandleVOD(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" && strings.HasPrefix(r.URL.Path, "/vod/") {
+		s.handleVODStatus(w, r)
+		return
+	}
+	if r.Method != "POST" || r.URL.Path != "/vod" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	r.URL.Host = r.Host
+	if r.URL.Scheme == "" {
+		r.URL.Scheme = "http"
+	}
+	resp, err := authenticateStream(r.URL.String())
+	if err != nil {
+		glog.Errorf("Authentication denied for url=%s err=%v", common.RedactURL(r.URL.String()), err)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	dir, err := ioutil.TempDir(s.LivepeerNode.WorkDir, "vod")
+	if err != nil {
+		glog.Errorf("Error creating VOD work dir err=%v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	started := false
+	defer func() {
+		if !started {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	req, src, err := readVODRequest(r, dir)
+	if err != nil {
+		glog.Errorf("Invalid VOD request url=%s err=%v", common.RedactURL(r.URL.String()), err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	profiles, err := parseJSONProfiles(req.Profiles)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	profiles = append(parsePresets(req.Presets), profiles...)
+	if len(profiles) == 0 {
+		profiles = BroadcastJobVideoProfiles
+	}
+	if err := validateProfiles(profiles); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	storeURL := vodStoreURL(req, resp)
+	store, err := vodStore(storeURL)
+	if err != nil {
+		glog.Errorf("Error parsing VOD object store url=%s err=%v", common.RedactURL(r.URL.String()), err)
+		http.Error(w, "invalid object store", http.StatusBadRequest)
+		return
+	}
+	if store == nil {
+		http.Error(w, "no object store to save the outputs to", http.StatusBadRequest)
+		return
+	}
+
+	job, err := s.jobs.submit(vodJobKind, "", &vodJobPayload{
+		Source:      src,
+		Dir:         dir,
+		Profiles:    profiles,
+		ObjectStore: storeURL,
+		MP4:         req.MP4,
+	})
+	if err != nil {
+		glog.Errorf("Error queueing VOD job url=%s err=%v", common.RedactURL(r.URL.String()), err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	started = true
+	glog.Infof("Queued VOD job id=%s profiles=%s mp4=%v", job.ID, common.ProfilesNames(profiles), req.MP4)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/vod/"+job.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(newVODJobStatus(job))
+}
+
+func (s *LivepeerServer) handleVODStatus(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.jobs.get(strings.TrimPrefix(r.URL.Path, "/vod/"))
+	if !ok || job.Kind != vodJobKind {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newVODJobStatus(job))
+}
+
+// readVODRequest reads a VOD request, saving an uploaded source to `dir`.
+// Returns the request and the URL or path of its source.
+func readVODRequest(r *http.Request, dir string) (*vodRequest, string, error) {
+	req := &vodRequest{}
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			return nil, "", fmt.Errorf("invalid request: %w", err)
+		}
+		if req.URL == "" {
+			return nil, "", errors.New("missing source url")
+		}
+		return req, req.URL, nil
+	}
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, "", err
+	}
+	var src string
+	for {
+		p, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		switch p.FormName() {
+		case "request":
+			if err := json.NewDecoder(p).Decode(req); err != nil {
+				return nil, "", fmt.Errorf("invalid request: %w", err)
+			}
+		case "file":
+			src = filepath.Join(dir, "upload"+filepath.Ext(p.FileName()))
+			f, err := os.Create(src)
+			if err != nil {
+				return nil, "", err
+			}
+			_, err = io.Copy(f, p)
+			f.Close()
+			if err != nil {
+				return nil, "", err
+			}
+		}
+		p.Close()
+	}
+	if src == "" {
+		src = req.URL
+	}
+	if src == "" {
+		return nil, "", errors.New("missing source file or url")
+	}
+	return req, src, nil
+}
+
+// vodStoreURL returns the URL of the object store the outputs of `req` are
+// saved to: the one of the request, else that of the auth webhook, else its
+// record store. Empty for the record store of the node.
+func vodStoreURL(req *vodRequest, resp *authWebhookResponse) string {
+	switch {
+	case req.ObjectStore != "":
+		return req.ObjectStore
+	case resp != nil && resp.ObjectStore != "":
+		return resp.ObjectStore
+	case resp != nil:
+		return resp.RecordObjectStore
+	}
+	return ""
+}
+
+// vodStore returns the object store at `osURL`, or the record store of the
+// node if empty
+func vodStore(osURL string) (drivers.OSDriver, error) {
+	if osURL != "" {
+		return drivers.ParseOSURL(osURL, false)
+	}
+	if drivers.RecordStorage == nil {
+		return nil, nil
+	}
+	return drivers.RecordStorage, nil
+}
+
+// runVODJob makes an attempt at a queued VOD job: segments the source in the
+// work dir of the job, transcodes the segments and saves the outputs
+func (s *LivepeerServer) runVODJob(ctx context.Context, job *runningJob) error {
+	var p vodJobPayload
+	if err := job.decode(&p); err != nil {
+		return err
+	}
+	store, err := vodStore(p.ObjectStore)
+	if err != nil {
+		return err
+	}
+	if store == nil {
+		return errors.New("no object store to save the outputs to")
+	}
+	dir := filepath.Join(p.Dir, "segments")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	segs, err := segmentFile(p.Source, dir, SegLen)
+	if err != nil {
+		return wrapError(ErrorCategoryIngest, false, err)
+	}
+	if len(segs) == 0 {
+		return newError(ErrorCategoryIngest, false, "no segments in source")
+	}
+	params := &core.StreamParameters{
+		ManifestID: core.ManifestID(job.info.ID),
+		Profiles:   p.Profiles,
+		OS:         store.NewSession(job.info.ID),
+	}
+	caps, err := core.JobCapabilities(params)
+	if err != nil {
+		return err
+	}
+	params.Capabilities = caps
+	cxn := s.newVODConnection(params)
+	defer cxn.sessManager.cleanup()
+	res, err := transcodeVOD(ctx, cxn, segs, dir, p.MP4, job.progress)
+	if err != nil {
+		return err
+	}
+	return job.setResult(res)
+}
+
+// finishVODJob removes the work dir of a finished VOD job
+func finishVODJob(job jobInfo) {
+	var p vodJobPayload
+	if err := json.Unmarshal(job.Payload, &p); err != nil || p.Dir == "" {
+		return
+	}
+	os.RemoveAll(p.Dir)
+}
+
+// transcodeVOD transcodes `segs` with `cxn`, then saves the HLS playlists,
+// and MP4 files if `mp4` is set, of the renditions to the object store of the
+// connection. Reports the share of segments transcoded to `progress`.
+func transcodeVOD(ctx context.Context, cxn *rtmpConnection, segs []fileSegment, dir string, mp4 bool, progress func(float64)) (*vodJobResult, error) {
+	out := cxn.params.OS
+	// renditions may be dropped on the way, so these are all there can be
+	profiles := append([]ffmpeg.VideoProfile(nil), cxn.params.Profiles...)
+	mpls := make(map[string]*m3u8.MediaPlaylist)
+	tsFiles := make(map[string]*os.File)
+	defer func() {
+		for _, f := range tsFiles {
+			f.Close()
+		}
+	}()
+
+	for i, fseg := range segs {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadFile(fseg.path)
+		if err != nil {
+			return nil, err
+		}
+		seg := &stream.HLSSegment{SeqNo: uint64(i), Data: data, Duration: fseg.duration}
+		job, err := transcodeStoredSegment(cxn, seg, fmt.Sprintf("source/%d.ts", i))
+		if err != nil {
+			return nil, err
+		}
+		for k, url := range job.URLs {
+			profile := job.Session.Params.Profiles[k]
+			mpl, ok := mpls[profile.Name]
+			if !ok {
+				if mpl, err = m3u8.NewMediaPlaylist(uint(len(segs)), uint(len(segs))); err != nil {
+					return nil, err
+				}
+				mpl.Live = false
+				mpls[profile.Name] = mpl
+			}
+			if err := mpl.Append(url, seg.Duration, ""); err != nil {
+				return nil, err
+			}
+			if !mp4 {
+				continue
+			}
+			data := job.Data[k]
+			if data == nil {
+				if data, err = downloadSeg(url); err != nil {
+					return nil, wrapError(ErrorCategoryStorage, true, err)
+				}
+			}
+			f, ok := tsFiles[profile.Name]
+			if !ok {
+				if f, err = os.Create(filepath.Join(dir, profile.Name+".ts")); err != nil {
+					return nil, err
+				}
+				tsFiles[profile.Name] = f
+			}
+			if _, err := f.Write(data); err != nil {
+				return nil, err
+			}
+		}
+		progress(float64(i+1) / float64(len(segs)))
+	}
+
+	res := &vodJobResult{}
+	master := m3u8.NewMasterPlaylist()
+	for _, profile := range profiles {
+		mpl, ok := mpls[profile.Name]
+		if !ok {
+			continue
+		}
+		name := profile.Name + ".m3u8"
+		if _, err := out.SaveData(name, mpl.Encode().Bytes(), nil); err != nil {
+			return nil, wrapError(ErrorCategoryStorage, true, err)
+		}
+		master.Append(name, mpl, ffmpeg.VideoProfileToVariantParams(profile))
+	}
+	playlist, err := out.SaveData("index.m3u8", master.Encode().Bytes(), nil)
+	if err != nil {
+		return nil, wrapError(ErrorCategoryStorage, true, err)
+	}
+	res.Playlist = playlist
+
+	for _, profile := range profiles {
+		f, ok := tsFiles[profile.Name]
+		if !ok {
+			continue
+		}
+		f.Close()
+		name := filepath.Join(dir, profile.Name+".mp4")
+		if err := remuxMP4(f.Name(), name); err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		uri, err := out.SaveData(profile.Name+".mp4", data, nil)
+		if err != nil {
+			return nil, wrapError(ErrorCategoryStorage, true, err)
+		}
+		if res.MP4 == nil {
+			res.MP4 = make(map[string]string)
+		}
+		res.MP4[profile.Name] = uri
+	}
+	return res, nil
+}
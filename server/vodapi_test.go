@@ -0,0 +1,172 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/livepeer/go-livepeer/common"
+	"github.com/livepeer/go-livepeer/core"
+	"github.com/livepeer/go-livepeer/drivers"
+	"github.com/livepeer/lpms/ffmpeg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTranscodeVOD(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+
+	oldDownloadSeg, oldRemuxMP4 := downloadSeg, remuxMP4
+	defer func() { downloadSeg, remuxMP4 = oldDownloadSeg, oldRemuxMP4 }()
+	downloadSeg = func(url string) ([]byte, error) { return []byte(url + ";"), nil }
+	remuxMP4 = func(in, out string) error {
+		data, err := ioutil.ReadFile(in)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(out, append([]byte("mp4:"), data...), 0644)
+	}
+
+	dir, err := ioutil.TempDir("", "vodjob")
+	require.Nil(err)
+	defer os.RemoveAll(dir)
+	var segs []fileSegment
+	for _, name := range []string{"source_0.ts", "source_1.ts"} {
+		p := filepath.Join(dir, name)
+		require.Nil(ioutil.WriteFile(p, []byte(name), 0644))
+		segs = append(segs, fileSegment{path: p, duration: 2})
+	}
+
+	bcastOS := &stubOSSession{host: "test://broad.com"}
+	sessions := []*BroadcastSession{genBcastSess(t, "", bcastOS, ""), genBcastSess(t, "", bcastOS, "")}
+	out := drivers.NewMemoryDriver(nil).NewSession("job")
+	sourceProfile := ffmpeg.P240p30fps16x9
+	cxn := &rtmpConnection{
+		pl:          &stubPlaylistManager{os: bcastOS},
+		profile:     &sourceProfile,
+		params:      &core.StreamParameters{ManifestID: "job", Profiles: []ffmpeg.VideoProfile{ffmpeg.P144p30fps16x9}, OS: out},
+		sessManager: bsmWithSessList(sessions),
+	}
+	var progress []float64
+	res, err := transcodeVOD(context.Background(), cxn, segs, dir, true, func(p float64) { progress = append(progress, p) })
+	require.Nil(err)
+	assert.Equal([]float64{0.5, 1}, progress)
+	assert.Contains(res.Playlist, "index.m3u8")
+	require.Len(res.MP4, 1)
+	assert.Contains(res.MP4["P144p30fps16x9"], "P144p30fps16x9.mp4")
+
+	read := func(name string) string {
+		fi, err := out.ReadData(context.Background(), "job/"+name)
+		require.Nil(err)
+		defer fi.Body.Close()
+		data, err := ioutil.ReadAll(fi.Body)
+		require.Nil(err)
+		return string(data)
+	}
+	assert.Contains(read("index.m3u8"), "P144p30fps16x9.m3u8")
+	mpl := read("P144p30fps16x9.m3u8")
+	assert.Contains(mpl, "saved_P144p30fps16x9/0.ts")
+	assert.Contains(mpl, "saved_P144p30fps16x9/1.ts")
+	assert.Contains(mpl, "#EXT-X-ENDLIST")
+	assert.Equal("mp4:saved_P144p30fps16x9/0.ts;saved_P144p30fps16x9/1.ts;", read("P144p30fps16x9.mp4"))
+}
+
+func TestHandleVOD(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	drivers.Testing = true
+	s := setupServer()
+	defer serverCleanup(s)
+
+	sources := make(chan string, 1)
+	oldSegmentFile := segmentFile
+	defer func() { segmentFile = oldSegmentFile }()
+	segmentFile = func(src, dir string, segLen time.Duration) ([]fileSegment, error) {
+		if data, err := ioutil.ReadFile(src); err == nil {
+			src = string(data)
+		}
+		sources <- src
+		return nil, errors.New("no video")
+	}
+
+	makeReq := func(method, path, contentType string, body []byte) (int, string) {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		writer := httptest.NewRecorder()
+		s.HandleVOD(writer, req)
+		resp := writer.Result()
+		data, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return resp.StatusCode, string(data)
+	}
+
+	code, _ := makeReq("PUT", "/vod", "", nil)
+	assert.Equal(http.StatusMethodNotAllowed, code)
+	code, _ = makeReq("GET", "/vod/none", "", nil)
+	assert.Equal(http.StatusNotFound, code)
+	code, body := makeReq("POST", "/vod", "", []byte(`{"objectStore": "memory://vodapi1"}`))
+	assert.Equal(http.StatusBadRequest, code)
+	assert.Equal("missing source url\n", body)
+	code, body = makeReq("POST", "/vod", "", []byte(`{"url": "https://src.test/in.mp4", "objectStore": "memory://vodapi1",
+		"profiles": [{"name": "odd", "width": 427, "height": 240, "bitrate": 400000}]}`))
+	assert.Equal(http.StatusBadRequest, code)
+	assert.Equal("odd resolution profile=odd resolution=427x240\n", body)
+	code, body = makeReq("POST", "/vod", "", []byte(`{"url": "https://src.test/in.mp4"}`))
+	assert.Equal(http.StatusBadRequest, code)
+	assert.Equal("no object store to save the outputs to\n", body)
+
+	waitFailed := func(body string) vodJobStatus {
+		var st vodJobStatus
+		require.Nil(json.Unmarshal([]byte(body), &st))
+		assert.Equal(jobQueued, st.State)
+		common.WaitAssert(t, 5*time.Second, func() bool {
+			code, body := makeReq("GET", "/vod/"+st.ID, "", nil)
+			return code == http.StatusOK && json.Unmarshal([]byte(body), &st) == nil && st.State == jobFailed
+		}, "VOD job did not fail")
+		return st
+	}
+
+	code, body = makeReq("POST", "/vod", "", []byte(`{"url": "https://src.test/in.mp4", "objectStore": "memory://vodapi1", "presets": ["P144p30fps16x9"]}`))
+	assert.Equal(http.StatusAccepted, code)
+	st := waitFailed(body)
+	assert.Equal("https://src.test/in.mp4", <-sources)
+	require.NotNil(st.Error)
+	assert.Equal(ErrorCategoryIngest, st.Error.Category)
+
+	// uploaded sources are saved for the segmenter
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	fw, err := mw.CreateFormField("request")
+	require.Nil(err)
+	fw.Write([]byte(`{"objectStore": "memory://vodapi1", "mp4": true}`))
+	fw, err = mw.CreateFormFile("file", "in.mp4")
+	require.Nil(err)
+	fw.Write([]byte("uploaded video"))
+	require.Nil(mw.Close())
+	code, body = makeReq("POST", "/vod", mw.FormDataContentType(), form.Bytes())
+	assert.Equal(http.StatusAccepted, code)
+	st = waitFailed(body)
+	assert.Equal("uploaded video", <-sources)
+	// the upload is removed with the job finished
+	job, ok := s.jobs.get(st.ID)
+	require.True(ok)
+	var p vodJobPayload
+	require.Nil(json.Unmarshal(job.Payload, &p))
+	assert.True(p.MP4)
+	common.WaitAssert(t, time.Second, func() bool {
+		_, err := os.Stat(p.Dir)
+		return os.IsNotExist(err)
+	}, "upload not removed")
+}
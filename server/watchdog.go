@@ -0,0 +1,167 @@
+package server
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// pushWatchdog drives the idle session and in-flight reset timers of HTTP push
+var pushWatchdog = newExpiryQueue()
+
+// expiryQueue runs timer callbacks from a single goroutine ordered by a
+// min-heap of deadlines, rather than using a goroutine per timer. The
+// goroutine is only running while there are timers scheduled.
+//
+// Callbacks run one at a time and must not block; anything slow should be
+// handed off to its own goroutine.
+type expiryQueue struct {
+	clock   Clock
+	mu      sync.Mutex
+	timers  expiryHeap
+	wake    chan struct{}
+	running bool
+}
+
+type expiryTimer struct {
+	q       *expiryQueue
+	when    time.Time
+	fn      func()
+	index   int // position in the heap, -1 if not scheduled
+	stopped bool
+}
+
+// newExpiryQueue returns a queue running on the clock of the server
+func newExpiryQueue() *expiryQueue {
+	return &expiryQueue{clock: clock, wake: make(chan struct{}, 1)}
+}
+
+// newTimer creates a timer that calls fn once it expires. The timer is not
+// scheduled until reset is called.
+func (q *expiryQueue) newTimer(fn func()) *expiryTimer {
+	return &expiryTimer{q: q, fn: fn, index: -1}
+}
+
+// len returns the number of scheduled timers
+func (q *expiryQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.timers)
+}
+
+// reset schedules the timer to expire after d, replacing any earlier
+// deadline. It has no effect once the timer has been stopped.
+func (t *expiryTimer) reset(d time.Duration) {
+	q := t.q
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if t.stopped {
+		return
+	}
+	t.when = q.clock.Now().Add(d)
+	if t.index >= 0 {
+		heap.Fix(&q.timers, t.index)
+	} else {
+		heap.Push(&q.timers, t)
+	}
+	if !q.running {
+		q.running = true
+		go q.run()
+	} else if q.timers[0] == t {
+		// The earliest deadline changed
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// stop unschedules the timer for good. Returns false if the timer was not
+// scheduled, e.g. because it already expired.
+func (t *expiryTimer) stop() bool {
+	q := t.q
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	t.stopped = true
+	if t.index < 0 {
+		return false
+	}
+	heap.Remove(&q.timers, t.index)
+	return true
+}
+
+func (q *expiryQueue) run() {
+	// created once the queue first waits, so that a test clock sees no
+	// timer until then
+	var timer Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+	for {
+		q.mu.Lock()
+		now := q.clock.Now()
+		var due []func()
+		for len(q.timers) > 0 && !q.timers[0].when.After(now) {
+			t := heap.Pop(&q.timers).(*expiryTimer)
+			due = append(due, t.fn)
+		}
+		if len(due) > 0 {
+			q.mu.Unlock()
+			for _, fn := range due {
+				fn()
+			}
+			continue
+		}
+		if len(q.timers) == 0 {
+			q.running = false
+			q.mu.Unlock()
+			return
+		}
+		wait := q.timers[0].when.Sub(now)
+		q.mu.Unlock()
+
+		if timer == nil {
+			timer = q.clock.NewTimer(wait)
+		} else {
+			if !timer.Stop() {
+				select {
+				case <-timer.C():
+				default:
+				}
+			}
+			timer.Reset(wait)
+		}
+		select {
+		case <-timer.C():
+		case <-q.wake:
+		}
+	}
+}
+
+type expiryHeap []*expiryTimer
+
+func (h expiryHeap) Len() int           { return len(h) }
+func (h expiryHeap) Less(i, j int) bool { return h[i].when.Before(h[j].when) }
+func (h expiryHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *expiryHeap) Push(x interface{}) {
+	t := x.(*expiryTimer)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *expiryHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	t.index = -1
+	*h = old[:n-1]
+	return t
+}
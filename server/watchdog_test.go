@@ -0,0 +1,96 @@
+package server
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpiryQueue_Order(t *testing.T) {
+	assert := assert.New(t)
+
+	q := newExpiryQueue()
+	var mu sync.Mutex
+	var fired []int
+	var wg sync.WaitGroup
+	for _, i := range []int{3, 1, 2} {
+		i := i
+		wg.Add(1)
+		q.newTimer(func() {
+			mu.Lock()
+			fired = append(fired, i)
+			mu.Unlock()
+			wg.Done()
+		}).reset(time.Duration(i) * 10 * time.Millisecond)
+	}
+	assert.Equal(3, q.len())
+	assert.True(wgWait(&wg))
+	assert.Equal([]int{1, 2, 3}, fired)
+	assert.Equal(0, q.len())
+}
+
+func TestExpiryQueue_ResetStop(t *testing.T) {
+	assert := assert.New(t)
+
+	q := newExpiryQueue()
+	fired := make(chan time.Time, 2)
+	tm := q.newTimer(func() { fired <- time.Now() })
+
+	// moving the deadline earlier wakes the queue up
+	start := time.Now()
+	tm.reset(time.Hour)
+	tm.reset(10 * time.Millisecond)
+	select {
+	case at := <-fired:
+		assert.True(at.Sub(start) < time.Second)
+	case <-time.After(time.Second):
+		t.Error("timer did not fire")
+	}
+	// already expired
+	assert.False(tm.stop())
+
+	// stopped timers never fire and can not be rescheduled
+	tm = q.newTimer(func() { fired <- time.Now() })
+	tm.reset(10 * time.Millisecond)
+	assert.True(tm.stop())
+	tm.reset(10 * time.Millisecond)
+	assert.Equal(0, q.len())
+	time.Sleep(30 * time.Millisecond)
+	assert.Len(fired, 0)
+
+	// the queue goroutine exits once there is nothing left to run
+	q.mu.Lock()
+	running := q.running
+	q.mu.Unlock()
+	assert.False(running)
+}
+
+func TestExpiryQueue_Periodic(t *testing.T) {
+	assert := assert.New(t)
+
+	q := newExpiryQueue()
+	var mu sync.Mutex
+	count := 0
+	var tm *expiryTimer
+	tm = q.newTimer(func() {
+		mu.Lock()
+		count++
+		mu.Unlock()
+		tm.reset(5 * time.Millisecond)
+	})
+	tm.reset(5 * time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	tm.stop()
+	// let a callback that was already running finish
+	time.Sleep(10 * time.Millisecond)
+	mu.Lock()
+	n := count
+	mu.Unlock()
+	assert.True(n >= 3, "timer fired %d times", n)
+	time.Sleep(20 * time.Millisecond)
+	mu.Lock()
+	assert.Equal(n, count)
+	mu.Unlock()
+}
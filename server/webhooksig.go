@@ -0,0 +1,68 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errWebhookSigMissing = newError(ErrorCategoryAuth, false, "ErrWebhookSigMissing")
+	errWebhookSigInvalid = newError(ErrorCategoryAuth, false, "ErrWebhookSigInvalid")
+	errWebhookSigExpired = newError(ErrorCategoryAuth, false, "ErrWebhookSigExpired")
+)
+
+// WebhookSigHeader carries the signature of the body of a webhook request or
+// response, signed with a secret shared with the webhook, as
+//
+//	t=<unix seconds>,s=<hex HMAC-SHA256 of "<t>\n<body>">
+const WebhookSigHeader = "X-Livepeer-Signature"
+
+// WebhookSigWindow is how far the timestamp of a signed webhook response may
+// be from the time it is received
+var WebhookSigWindow = 5 * time.Minute
+
+func webhookMAC(secret, ts string, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(h, "%s\n%s", ts, body)
+	return h.Sum(nil)
+}
+
+// signWebhook returns the WebhookSigHeader of `body` sent at `t`
+func signWebhook(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	return fmt.Sprintf("t=%s,s=%s", ts, hex.EncodeToString(webhookMAC(secret, ts, body)))
+}
+
+// verifyWebhook checks the WebhookSigHeader `header` of `body` received at
+// `now`
+func verifyWebhook(secret, header string, body []byte, now time.Time) error {
+	if header == "" {
+		return errWebhookSigMissing
+	}
+	var ts, sig string
+	for _, kv := range strings.Split(header, ",") {
+		switch {
+		case strings.HasPrefix(kv, "t="):
+			ts = kv[2:]
+		case strings.HasPrefix(kv, "s="):
+			sig = kv[2:]
+		}
+	}
+	mac, err := hex.DecodeString(sig)
+	if err != nil || ts == "" || !hmac.Equal(mac, webhookMAC(secret, ts, body)) {
+		return errWebhookSigInvalid
+	}
+	secs, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errWebhookSigInvalid
+	}
+	if d := now.Sub(time.Unix(secs, 0)); d > WebhookSigWindow || d < -WebhookSigWindow {
+		return errWebhookSigExpired
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package server
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookSig(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Unix(1600000000, 0)
+	body := []byte(`{"event":"stream.started"}`)
+	sig := signWebhook("secret", now, body)
+	assert.Equal("t=1600000000,s=d30b49ee849c906bf101456632edf8fad2199b8ef58c1392d0158401bb4eb368", sig)
+
+	assert.Nil(verifyWebhook("secret", sig, body, now))
+	assert.Nil(verifyWebhook("secret", sig, body, now.Add(WebhookSigWindow)))
+	assert.Equal(errWebhookSigMissing, verifyWebhook("secret", "", body, now))
+	assert.Equal(errWebhookSigInvalid, verifyWebhook("other", sig, body, now))
+	assert.Equal(errWebhookSigInvalid, verifyWebhook("secret", sig, []byte(`{"event":"stream.ended"}`), now))
+	assert.Equal(errWebhookSigInvalid, verifyWebhook("secret", "t=1600000001,s=d30b49ee849c906bf101456632edf8fad2199b8ef58c1392d0158401bb4eb368", body, now))
+	assert.Equal(errWebhookSigInvalid, verifyWebhook("secret", "t=1600000000,s=nothex", body, now))
+	assert.Equal(errWebhookSigInvalid, verifyWebhook("secret", "s=d30b49ee849c906bf101456632edf8fad2199b8ef58c1392d0158401bb4eb368", body, now))
+	assert.Equal(errWebhookSigExpired, verifyWebhook("secret", sig, body, now.Add(WebhookSigWindow+time.Second)))
+	assert.Equal(errWebhookSigExpired, verifyWebhook("secret", sig, body, now.Add(-WebhookSigWindow-time.Second)))
+}
+
+func TestAuthenticateStream_Signed(t *testing.T) {
+	assert := assert.New(t)
+	require := require.New(t)
+	c := useFakeClock(t)
+
+	defer func(url, secret string, verify bool) {
+		AuthWebhookURL, AuthWebhookSecret, AuthWebhookVerifyResponse = url, secret, verify
+	}(AuthWebhookURL, AuthWebhookSecret, AuthWebhookVerifyResponse)
+
+	var respSecret atomic.Value
+	respSecret.Store("secret")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		// the receiver can tell the request comes from the node
+		if err := verifyWebhook("secret", r.Header.Get(WebhookSigHeader), body, c.Now()); err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var req authWebhookReq
+		assert.Nil(json.Unmarshal(body, &req))
+		assert.Equal("rtmp://localhost/live/key", req.URL)
+		resp := []byte(`{"manifestID":"mani"}`)
+		if secret := respSecret.Load().(string); secret != "" {
+			w.Header().Set(WebhookSigHeader, signWebhook(secret, c.Now(), resp))
+		}
+		w.Write(resp)
+	}))
+	defer ts.Close()
+	AuthWebhookURL = ts.URL
+
+	// unsigned requests are refused by the receiver
+	_, err := authenticateStream("rtmp://localhost/live/key")
+	assert.Error(err)
+
+	AuthWebhookSecret = "secret"
+	resp, err := authenticateStream("rtmp://localhost/live/key")
+	require.Nil(err)
+	assert.Equal("mani", resp.ManifestID)
+
+	// responses are checked if asked to
+	AuthWebhookVerifyResponse = true
+	resp, err = authenticateStream("rtmp://localhost/live/key")
+	require.Nil(err)
+	assert.Equal("mani", resp.ManifestID)
+
+	respSecret.Store("forged")
+	_, err = authenticateStream("rtmp://localhost/live/key")
+	assert.Equal(errWebhookSigInvalid, err)
+
+	respSecret.Store("")
+	_, err = authenticateStream("rtmp://localhost/live/key")
+	assert.Equal(errWebhookSigMissing, err)
+}
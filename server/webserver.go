@@ -25,6 +25,7 @@ import (
 	"github.com/livepeer/go-livepeer/eth"
 	lpTypes "github.com/livepeer/go-livepeer/eth/types"
 	"github.com/livepeer/go-livepeer/monitor"
+	"github.com/livepeer/go-livepeer/net"
 	ffmpeg "github.com/livepeer/lpms/ffmpeg"
 	"github.com/pkg/errors"
 )
@@ -39,6 +40,13 @@ func (s *LivepeerServer) setServiceURI(serviceURI string) error {
 		return err
 	}
 
+	// Broadcasters fail to reach service URIs that are misconfigured, e.g.
+	// behind NAT, so don't register them
+	if err := ValidateServiceURI(serviceURI); err != nil {
+		glog.Error(err)
+		return err
+	}
+
 	glog.Infof("Storing service URI %v in service registry...", serviceURI)
 
 	tx, err := s.LivepeerNode.Eth.SetServiceURI(serviceURI)
@@ -66,7 +74,7 @@ func (s *LivepeerServer) StartCliWebserver(bindAddr string) {
 	mux := s.cliWebServerHandlers(bindAddr)
 	srv := &http.Server{
 		Addr:    bindAddr,
-		Handler: mux,
+		Handler: AuditLog.Handler(auditCategoryAdmin, CLIAuth.Handler(mux)),
 	}
 
 	glog.Info("CLI server listening on ", bindAddr)
@@ -455,6 +463,82 @@ func (s *LivepeerServer) cliWebServerHandlers(bindAddr string) *http.ServeMux {
 
 	})
 
+	// Stop accepting new sessions and tell subscribed broadcasters to move away
+	mux.HandleFunc("/setOrchestratorDrain", func(w http.ResponseWriter, r *http.Request) {
+		drain, err := strconv.ParseBool(r.FormValue("drain"))
+		if err != nil {
+			respondWith400(w, "drain must be a boolean")
+			return
+		}
+		var delay int64
+		if d := r.FormValue("delay"); d != "" {
+			delay, err = strconv.ParseInt(d, 10, 64)
+			if err != nil || delay < 0 {
+				respondWith400(w, "delay must be a non-negative number of seconds")
+				return
+			}
+		}
+		orchNotifications.setDraining(drain, clock.Now().Add(time.Duration(delay)*time.Second))
+		glog.Infof("Orchestrator drain set to %v delay=%ds", drain, delay)
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// Stop accepting new streams, letting live ones carry on until they end,
+	// after which the node exits
+	mux.HandleFunc("/setBroadcasterDrain", func(w http.ResponseWriter, r *http.Request) {
+		if s.LivepeerNode.NodeType != core.BroadcasterNode {
+			respondWith400(w, "only a broadcaster can be drained")
+			return
+		}
+		drain, err := strconv.ParseBool(r.FormValue("drain"))
+		if err != nil {
+			respondWith400(w, "drain must be a boolean")
+			return
+		}
+		if drain {
+			s.StartDrain()
+		} else {
+			s.CancelDrain()
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// Replace the scheduled maintenance windows advertised to broadcasters;
+	// empty to clear them
+	mux.HandleFunc("/setMaintenanceWindows", func(w http.ResponseWriter, r *http.Request) {
+		if s.LivepeerNode.NodeType != core.OrchestratorNode {
+			respondWith400(w, "maintenance windows can only be set on an orchestrator")
+			return
+		}
+		windows, err := ParseMaintenanceWindows(r.FormValue("windows"))
+		if err != nil {
+			respondWith400(w, err.Error())
+			return
+		}
+		SetMaintenanceWindows(windows)
+		glog.Infof("Orchestrator maintenance windows set to %q", r.FormValue("windows"))
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// Stop advertising capabilities, such as after losing the hardware for
+	// them, and tell subscribed broadcasters. An empty list enables them all.
+	mux.HandleFunc("/setDisabledCapabilities", func(w http.ResponseWriter, r *http.Request) {
+		if s.LivepeerNode.NodeType != core.OrchestratorNode {
+			respondWith400(w, "capabilities can only be disabled on an orchestrator")
+			return
+		}
+		caps, err := core.ParseCapabilities(r.FormValue("capabilities"))
+		if err != nil {
+			respondWith400(w, err.Error())
+			return
+		}
+		if s.LivepeerNode.SetDisabledCapabilities(caps) {
+			glog.Infof("Orchestrator capabilities disabled: %s", core.CapabilityNames(caps))
+			orchNotifications.notify(net.OrchestratorNotification_CAPABILITIES_CHANGE, clock.Now())
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
 	//Bond some amount of tokens to an orchestrator.
 	mux.HandleFunc("/bond", func(w http.ResponseWriter, r *http.Request) {
 		if s.LivepeerNode.Eth != nil {
@@ -827,6 +911,231 @@ func (s *LivepeerServer) cliWebServerHandlers(bindAddr string) *http.ServeMux {
 		http.Error(w, "Error getting status", http.StatusInternalServerError)
 	})
 
+	// Detail of the live streams of a broadcaster, or of the stream given by
+	// its internal or external manifestID
+	// Outcome of the last validation of the service URI with -serviceURICheckers
+	mux.HandleFunc("/serviceURIHealth", func(w http.ResponseWriter, r *http.Request) {
+		health := lastServiceURIHealth()
+		if health == nil {
+			respondWithError(w, "service URI not validated", http.StatusNotFound)
+			return
+		}
+		data, err := json.Marshal(health)
+		if err != nil {
+			respondWith500(w, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	})
+
+	mux.HandleFunc("/status/streams", func(w http.ResponseWriter, r *http.Request) {
+		var v interface{}
+		if mid := r.FormValue("manifestID"); mid != "" {
+			st, ok := s.streamStatusOf(core.ManifestID(mid))
+			if !ok {
+				respondWithError(w, "stream not found", http.StatusNotFound)
+				return
+			}
+			v = st
+		} else {
+			v = s.streamsStatus()
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			respondWith500(w, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	})
+
+	// Push synthetic streams through this broadcaster and report the results
+	mux.HandleFunc("/loadTest", func(w http.ResponseWriter, r *http.Request) {
+		if s.LivepeerNode.NodeType != core.BroadcasterNode {
+			respondWith400(w, "load tests can only be run on a broadcaster")
+			return
+		}
+		cfg := DefaultLoadTestConfig
+		for name, v := range map[string]*int{"streams": &cfg.Streams, "segments": &cfg.Segments, "size": &cfg.SegmentSize} {
+			if str := r.FormValue(name); str != "" {
+				n, err := strconv.Atoi(str)
+				if err != nil || n <= 0 {
+					respondWith400(w, fmt.Sprintf("%s must be a positive number", name))
+					return
+				}
+				*v = n
+			}
+		}
+		cfg.Multipart = r.FormValue("multipart") == "true"
+		cfg.ManifestPrefix = fmt.Sprintf("loadtest-%d", time.Now().UnixNano())
+
+		glog.Infof("Starting load test streams=%d segments=%d size=%d", cfg.Streams, cfg.Segments, cfg.SegmentSize)
+		rep := RunLoadTest(r.Context(), s.mediaHandler(), cfg)
+		glog.Infof("Finished load test %s", rep)
+		for i := 0; i < cfg.Streams; i++ {
+			removeRTMPStream(s, core.ManifestID(cfg.manifestID(i)))
+		}
+
+		data, err := json.Marshal(rep)
+		if err != nil {
+			respondWith500(w, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	})
+
+	// Restream targets of the live streams and the state of the pushes to them
+	mux.HandleFunc("/restreams", func(w http.ResponseWriter, r *http.Request) {
+		res := make(map[string][]RestreamStatus)
+		s.rtmpConnections.forEach(func(mid core.ManifestID, cxn *rtmpConnection) bool {
+			if status := cxn.restreams.status(); len(status) > 0 {
+				res[string(mid)] = status
+			}
+			return true
+		})
+		data, err := json.Marshal(res)
+		if err != nil {
+			respondWith500(w, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	})
+
+	// Scores of the orchestrators of the live streams at their last
+	// selection, for streams whose orchestrators are scored
+	mux.HandleFunc("/selectionScores", func(w http.ResponseWriter, r *http.Request) {
+		res := make(map[string][]SessionScore)
+		s.rtmpConnections.forEach(func(mid core.ManifestID, cxn *rtmpConnection) bool {
+			if scores := cxn.sessManager.scores(); len(scores) > 0 {
+				res[string(mid)] = scores
+			}
+			return true
+		})
+		data, err := json.Marshal(res)
+		if err != nil {
+			respondWith500(w, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	})
+
+	// Manifest IDs streams are pushed to, bound to those the auth webhook
+	// gave them
+	mux.HandleFunc("/streamAliases", func(w http.ResponseWriter, r *http.Request) {
+		data, err := json.Marshal(s.streamAliases.list())
+		if err != nil {
+			respondWith500(w, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	})
+
+	// Streams of the cluster this node shares them with
+	mux.HandleFunc("/clusterStreams", func(w http.ResponseWriter, r *http.Request) {
+		streams, err := Cluster.list()
+		if err != nil {
+			respondWith500(w, err.Error())
+			return
+		}
+		if streams == nil {
+			streams = []*ClusterStream{}
+		}
+		data, err := json.Marshal(streams)
+		if err != nil {
+			respondWith500(w, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	})
+
+	// Queued jobs of the node, e.g. VOD transcodes, optionally filtered by
+	// kind and state
+	mux.HandleFunc("/jobs", func(w http.ResponseWriter, r *http.Request) {
+		data, err := json.Marshal(s.jobs.list(r.FormValue("kind"), r.FormValue("state")))
+		if err != nil {
+			respondWith500(w, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	})
+
+	// Cancel a queued job, stopping it if it is running
+	mux.Handle("/cancelJob", mustHaveFormParams(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := s.jobs.cancel(r.FormValue("id")); err != nil {
+			respondWith400(w, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}), "id"))
+
+	// Replace the restream targets of a live stream, given as a JSON array
+	// of {"url", "profile"} objects; an empty array stops all restreams
+	mux.Handle("/setRestreams", mustHaveFormParams(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var targets []core.RestreamTarget
+		if err := json.Unmarshal([]byte(r.FormValue("targets")), &targets); err != nil {
+			respondWith400(w, fmt.Sprintf("invalid targets: %v", err))
+			return
+		}
+		if err := s.setRestreams(core.ManifestID(r.FormValue("manifestID")), targets); err != nil {
+			respondWith400(w, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}), "manifestID", "targets"))
+
+	// Replace the metadata sent along the segments of a live stream, given
+	// as a JSON object of strings
+	mux.Handle("/setStreamMetadata", mustHaveFormParams(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var md map[string]string
+		if err := json.Unmarshal([]byte(r.FormValue("metadata")), &md); err != nil {
+			respondWith400(w, fmt.Sprintf("invalid metadata: %v", err))
+			return
+		}
+		if err := s.setSegmentMetadata(core.ManifestID(r.FormValue("manifestID")), md); err != nil {
+			respondWith400(w, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}), "manifestID", "metadata"))
+
+	// Labels of a live stream, as a JSON object of strings
+	mux.Handle("/streamLabels", mustHaveFormParams(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		labels, err := s.streamLabels(core.ManifestID(r.FormValue("manifestID")))
+		if err != nil {
+			respondWith400(w, err.Error())
+			return
+		}
+		data, err := json.Marshal(labels)
+		if err != nil {
+			respondWith500(w, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}), "manifestID"))
+
+	// Set labels of a live stream, given as a JSON object of strings; labels
+	// set to an empty string are removed
+	mux.Handle("/setStreamLabels", mustHaveFormParams(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var labels map[string]string
+		if err := json.Unmarshal([]byte(r.FormValue("labels")), &labels); err != nil {
+			respondWith400(w, fmt.Sprintf("invalid labels: %v", err))
+			return
+		}
+		if err := s.setStreamLabels(core.ManifestID(r.FormValue("manifestID")), labels); err != nil {
+			respondWith400(w, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}), "manifestID", "labels"))
+
 	mux.HandleFunc("/contractAddresses", func(w http.ResponseWriter, r *http.Request) {
 		if s.LivepeerNode.Eth != nil {
 			addrMap := s.LivepeerNode.Eth.ContractAddresses()
@@ -1233,5 +1542,6 @@ func (s *LivepeerServer) setOrchestratorPriceInfo(pricePerUnitStr, pixelsPerUnit
 
 	s.LivepeerNode.SetBasePrice(big.NewRat(pricePerUnit, pixelsPerUnit))
 	glog.Infof("Price per pixel set to %d wei for %d pixels\n", pricePerUnit, pixelsPerUnit)
+	orchNotifications.notify(net.OrchestratorNotification_PRICE_CHANGE, time.Now())
 	return nil
 }